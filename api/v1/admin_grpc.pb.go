@@ -0,0 +1,924 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.3
+// source: api/v1/admin.proto
+
+package log_v1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Admin_PromoteServer_FullMethodName      = "/log.v1.Admin/PromoteServer"
+	Admin_DescribeCluster_FullMethodName    = "/log.v1.Admin/DescribeCluster"
+	Admin_DescribeReplica_FullMethodName    = "/log.v1.Admin/DescribeReplica"
+	Admin_TransferLeadership_FullMethodName = "/log.v1.Admin/TransferLeadership"
+	Admin_GetLeadership_FullMethodName      = "/log.v1.Admin/GetLeadership"
+	Admin_CreateTopic_FullMethodName        = "/log.v1.Admin/CreateTopic"
+	Admin_ListTopics_FullMethodName         = "/log.v1.Admin/ListTopics"
+	Admin_TriggerRebalance_FullMethodName   = "/log.v1.Admin/TriggerRebalance"
+	Admin_PauseRebalance_FullMethodName     = "/log.v1.Admin/PauseRebalance"
+	Admin_Backup_FullMethodName             = "/log.v1.Admin/Backup"
+	Admin_PrepareBackup_FullMethodName      = "/log.v1.Admin/PrepareBackup"
+	Admin_ReadBackup_FullMethodName         = "/log.v1.Admin/ReadBackup"
+	Admin_GetConfig_FullMethodName          = "/log.v1.Admin/GetConfig"
+	Admin_SetConfig_FullMethodName          = "/log.v1.Admin/SetConfig"
+	Admin_AddAclRules_FullMethodName        = "/log.v1.Admin/AddAclRules"
+	Admin_RemoveAclRules_FullMethodName     = "/log.v1.Admin/RemoveAclRules"
+	Admin_ListAclRules_FullMethodName       = "/log.v1.Admin/ListAclRules"
+	Admin_CreateToken_FullMethodName        = "/log.v1.Admin/CreateToken"
+	Admin_ListConsumers_FullMethodName      = "/log.v1.Admin/ListConsumers"
+)
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Admin manages the cluster's servers.
+type AdminClient interface {
+	// PromoteServer makes a non-voting server a voter, so it counts towards the
+	// quorum and may become the leader. Only the leader can promote servers.
+	PromoteServer(ctx context.Context, in *PromoteServerRequest, opts ...grpc.CallOption) (*PromoteServerResponse, error)
+	// DescribeCluster returns the servers of the cluster with how far each one's
+	// replica of the log is behind the leader's, so operators can spot slow replicas.
+	DescribeCluster(ctx context.Context, in *DescribeClusterRequest, opts ...grpc.CallOption) (*DescribeClusterResponse, error)
+	// DescribeReplica returns the state of the server's own replica of the log.
+	// DescribeCluster collects it from every server.
+	DescribeReplica(ctx context.Context, in *DescribeReplicaRequest, opts ...grpc.CallOption) (*DescribeReplicaResponse, error)
+	// TransferLeadership makes the leader hand the leadership over to another voter,
+	// e.g. before restarting the leader for maintenance, and returns once the new
+	// leader was elected. Only the leader can transfer the leadership.
+	TransferLeadership(ctx context.Context, in *TransferLeadershipRequest, opts ...grpc.CallOption) (*TransferLeadershipResponse, error)
+	// GetLeadership returns the cluster's leadership as the server sees it.
+	GetLeadership(ctx context.Context, in *GetLeadershipRequest, opts ...grpc.CallOption) (*GetLeadershipResponse, error)
+	// CreateTopic creates a topic split into partitions, each replicated by its own
+	// Raft group on the servers the leader assigns it to, so writes to different
+	// partitions are spread across leaders. Only the leader can create topics.
+	CreateTopic(ctx context.Context, in *CreateTopicRequest, opts ...grpc.CallOption) (*CreateTopicResponse, error)
+	// ListTopics returns the topics created with CreateTopic and the servers their
+	// partitions are assigned to.
+	ListTopics(ctx context.Context, in *ListTopicsRequest, opts ...grpc.CallOption) (*ListTopicsResponse, error)
+	// TriggerRebalance makes the leader rebalance the partitions' replicas across
+	// the servers right away, instead of at its next periodic round, and returns the
+	// moves it started. Only the leader can rebalance, and not while paused.
+	TriggerRebalance(ctx context.Context, in *TriggerRebalanceRequest, opts ...grpc.CallOption) (*TriggerRebalanceResponse, error)
+	// PauseRebalance pauses or resumes rebalancing, e.g. during maintenance. The
+	// setting is replicated, so it survives leader changes. Moves already started
+	// are carried out. Only the leader can pause rebalancing.
+	PauseRebalance(ctx context.Context, in *PauseRebalanceRequest, opts ...grpc.CallOption) (*PauseRebalanceResponse, error)
+	// Backup streams a consistent backup of the cluster: its topics and the records of
+	// every partition, the default topic's included, up to offsets committed at a single
+	// point. Writes are paused cluster-wide while every server takes the offsets of the
+	// partitions it leads, then resumed before the records are streamed, so a restore
+	// never holds a record without those written before it. Only the leader backs up.
+	Backup(ctx context.Context, in *BackupRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BackupChunk], error)
+	// PrepareBackup waits for the server to pause its writes, then returns the ranges
+	// of committed records of the partitions it leads. The leader calls it on every
+	// server while backing up the cluster.
+	PrepareBackup(ctx context.Context, in *PrepareBackupRequest, opts ...grpc.CallOption) (*PrepareBackupResponse, error)
+	// ReadBackup streams the records of a range returned by PrepareBackup, from the
+	// server holding it. The leader calls it while backing up the cluster.
+	ReadBackup(ctx context.Context, in *BackupRange, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BackupChunk], error)
+	// GetConfig returns the cluster's dynamic configuration, as the server last
+	// applied it.
+	GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*GetConfigResponse, error)
+	// SetConfig replaces the cluster's dynamic configuration. It's replicated through
+	// the Raft log, so every server applies it without restarting, and servers joining
+	// or restarting later apply it too. Only the leader sets the configuration.
+	SetConfig(ctx context.Context, in *SetConfigRequest, opts ...grpc.CallOption) (*SetConfigResponse, error)
+	// AddAclRules adds rules to the ACL rules of the cluster's configuration, which
+	// every server enforces on top of its policy file's, e.g. to onboard a client.
+	// Rules already there are ignored. The rules are replicated through the Raft log
+	// like SetConfig, but changed without replacing the rest of the configuration, so
+	// concurrent changes don't overwrite each other. Only the leader changes rules.
+	AddAclRules(ctx context.Context, in *AddAclRulesRequest, opts ...grpc.CallOption) (*AddAclRulesResponse, error)
+	// RemoveAclRules removes rules from the ACL rules of the cluster's configuration;
+	// rules not there are ignored. The policy files' rules can't be removed.
+	RemoveAclRules(ctx context.Context, in *RemoveAclRulesRequest, opts ...grpc.CallOption) (*RemoveAclRulesResponse, error)
+	// ListAclRules returns the ACL rules of the cluster's configuration, as the server
+	// last applied them.
+	ListAclRules(ctx context.Context, in *ListAclRulesRequest, opts ...grpc.CallOption) (*ListAclRulesResponse, error)
+	// CreateToken mints a short-lived bearer token authenticating as the caller, or as
+	// another subject, but only permitted the actions of its scopes, e.g. for a support
+	// engineer or batch job consuming a topic for a while without a certificate of its
+	// own. Tokens are signed with the cluster's token signing key, so every server
+	// accepts them until they expire, and the ACL must still permit their subjects.
+	CreateToken(ctx context.Context, in *CreateTokenRequest, opts ...grpc.CallOption) (*CreateTokenResponse, error)
+	// ListConsumers returns the consumer groups the server served records to, by
+	// subject, topic and partition, with how far behind the head of the log each
+	// one is, so lagging consumers can be spotted. Each server only knows the
+	// groups it served, and forgets those that stopped consuming a while ago.
+	ListConsumers(ctx context.Context, in *ListConsumersRequest, opts ...grpc.CallOption) (*ListConsumersResponse, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) PromoteServer(ctx context.Context, in *PromoteServerRequest, opts ...grpc.CallOption) (*PromoteServerResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PromoteServerResponse)
+	err := c.cc.Invoke(ctx, Admin_PromoteServer_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) DescribeCluster(ctx context.Context, in *DescribeClusterRequest, opts ...grpc.CallOption) (*DescribeClusterResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DescribeClusterResponse)
+	err := c.cc.Invoke(ctx, Admin_DescribeCluster_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) DescribeReplica(ctx context.Context, in *DescribeReplicaRequest, opts ...grpc.CallOption) (*DescribeReplicaResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DescribeReplicaResponse)
+	err := c.cc.Invoke(ctx, Admin_DescribeReplica_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) TransferLeadership(ctx context.Context, in *TransferLeadershipRequest, opts ...grpc.CallOption) (*TransferLeadershipResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransferLeadershipResponse)
+	err := c.cc.Invoke(ctx, Admin_TransferLeadership_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetLeadership(ctx context.Context, in *GetLeadershipRequest, opts ...grpc.CallOption) (*GetLeadershipResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetLeadershipResponse)
+	err := c.cc.Invoke(ctx, Admin_GetLeadership_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) CreateTopic(ctx context.Context, in *CreateTopicRequest, opts ...grpc.CallOption) (*CreateTopicResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateTopicResponse)
+	err := c.cc.Invoke(ctx, Admin_CreateTopic_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListTopics(ctx context.Context, in *ListTopicsRequest, opts ...grpc.CallOption) (*ListTopicsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListTopicsResponse)
+	err := c.cc.Invoke(ctx, Admin_ListTopics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) TriggerRebalance(ctx context.Context, in *TriggerRebalanceRequest, opts ...grpc.CallOption) (*TriggerRebalanceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TriggerRebalanceResponse)
+	err := c.cc.Invoke(ctx, Admin_TriggerRebalance_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) PauseRebalance(ctx context.Context, in *PauseRebalanceRequest, opts ...grpc.CallOption) (*PauseRebalanceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PauseRebalanceResponse)
+	err := c.cc.Invoke(ctx, Admin_PauseRebalance_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Backup(ctx context.Context, in *BackupRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BackupChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Admin_ServiceDesc.Streams[0], Admin_Backup_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[BackupRequest, BackupChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Admin_BackupClient = grpc.ServerStreamingClient[BackupChunk]
+
+func (c *adminClient) PrepareBackup(ctx context.Context, in *PrepareBackupRequest, opts ...grpc.CallOption) (*PrepareBackupResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PrepareBackupResponse)
+	err := c.cc.Invoke(ctx, Admin_PrepareBackup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ReadBackup(ctx context.Context, in *BackupRange, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BackupChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Admin_ServiceDesc.Streams[1], Admin_ReadBackup_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[BackupRange, BackupChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Admin_ReadBackupClient = grpc.ServerStreamingClient[BackupChunk]
+
+func (c *adminClient) GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*GetConfigResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetConfigResponse)
+	err := c.cc.Invoke(ctx, Admin_GetConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) SetConfig(ctx context.Context, in *SetConfigRequest, opts ...grpc.CallOption) (*SetConfigResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SetConfigResponse)
+	err := c.cc.Invoke(ctx, Admin_SetConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) AddAclRules(ctx context.Context, in *AddAclRulesRequest, opts ...grpc.CallOption) (*AddAclRulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AddAclRulesResponse)
+	err := c.cc.Invoke(ctx, Admin_AddAclRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) RemoveAclRules(ctx context.Context, in *RemoveAclRulesRequest, opts ...grpc.CallOption) (*RemoveAclRulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RemoveAclRulesResponse)
+	err := c.cc.Invoke(ctx, Admin_RemoveAclRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListAclRules(ctx context.Context, in *ListAclRulesRequest, opts ...grpc.CallOption) (*ListAclRulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListAclRulesResponse)
+	err := c.cc.Invoke(ctx, Admin_ListAclRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) CreateToken(ctx context.Context, in *CreateTokenRequest, opts ...grpc.CallOption) (*CreateTokenResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateTokenResponse)
+	err := c.cc.Invoke(ctx, Admin_CreateToken_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListConsumers(ctx context.Context, in *ListConsumersRequest, opts ...grpc.CallOption) (*ListConsumersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListConsumersResponse)
+	err := c.cc.Invoke(ctx, Admin_ListConsumers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility.
+//
+// Admin manages the cluster's servers.
+type AdminServer interface {
+	// PromoteServer makes a non-voting server a voter, so it counts towards the
+	// quorum and may become the leader. Only the leader can promote servers.
+	PromoteServer(context.Context, *PromoteServerRequest) (*PromoteServerResponse, error)
+	// DescribeCluster returns the servers of the cluster with how far each one's
+	// replica of the log is behind the leader's, so operators can spot slow replicas.
+	DescribeCluster(context.Context, *DescribeClusterRequest) (*DescribeClusterResponse, error)
+	// DescribeReplica returns the state of the server's own replica of the log.
+	// DescribeCluster collects it from every server.
+	DescribeReplica(context.Context, *DescribeReplicaRequest) (*DescribeReplicaResponse, error)
+	// TransferLeadership makes the leader hand the leadership over to another voter,
+	// e.g. before restarting the leader for maintenance, and returns once the new
+	// leader was elected. Only the leader can transfer the leadership.
+	TransferLeadership(context.Context, *TransferLeadershipRequest) (*TransferLeadershipResponse, error)
+	// GetLeadership returns the cluster's leadership as the server sees it.
+	GetLeadership(context.Context, *GetLeadershipRequest) (*GetLeadershipResponse, error)
+	// CreateTopic creates a topic split into partitions, each replicated by its own
+	// Raft group on the servers the leader assigns it to, so writes to different
+	// partitions are spread across leaders. Only the leader can create topics.
+	CreateTopic(context.Context, *CreateTopicRequest) (*CreateTopicResponse, error)
+	// ListTopics returns the topics created with CreateTopic and the servers their
+	// partitions are assigned to.
+	ListTopics(context.Context, *ListTopicsRequest) (*ListTopicsResponse, error)
+	// TriggerRebalance makes the leader rebalance the partitions' replicas across
+	// the servers right away, instead of at its next periodic round, and returns the
+	// moves it started. Only the leader can rebalance, and not while paused.
+	TriggerRebalance(context.Context, *TriggerRebalanceRequest) (*TriggerRebalanceResponse, error)
+	// PauseRebalance pauses or resumes rebalancing, e.g. during maintenance. The
+	// setting is replicated, so it survives leader changes. Moves already started
+	// are carried out. Only the leader can pause rebalancing.
+	PauseRebalance(context.Context, *PauseRebalanceRequest) (*PauseRebalanceResponse, error)
+	// Backup streams a consistent backup of the cluster: its topics and the records of
+	// every partition, the default topic's included, up to offsets committed at a single
+	// point. Writes are paused cluster-wide while every server takes the offsets of the
+	// partitions it leads, then resumed before the records are streamed, so a restore
+	// never holds a record without those written before it. Only the leader backs up.
+	Backup(*BackupRequest, grpc.ServerStreamingServer[BackupChunk]) error
+	// PrepareBackup waits for the server to pause its writes, then returns the ranges
+	// of committed records of the partitions it leads. The leader calls it on every
+	// server while backing up the cluster.
+	PrepareBackup(context.Context, *PrepareBackupRequest) (*PrepareBackupResponse, error)
+	// ReadBackup streams the records of a range returned by PrepareBackup, from the
+	// server holding it. The leader calls it while backing up the cluster.
+	ReadBackup(*BackupRange, grpc.ServerStreamingServer[BackupChunk]) error
+	// GetConfig returns the cluster's dynamic configuration, as the server last
+	// applied it.
+	GetConfig(context.Context, *GetConfigRequest) (*GetConfigResponse, error)
+	// SetConfig replaces the cluster's dynamic configuration. It's replicated through
+	// the Raft log, so every server applies it without restarting, and servers joining
+	// or restarting later apply it too. Only the leader sets the configuration.
+	SetConfig(context.Context, *SetConfigRequest) (*SetConfigResponse, error)
+	// AddAclRules adds rules to the ACL rules of the cluster's configuration, which
+	// every server enforces on top of its policy file's, e.g. to onboard a client.
+	// Rules already there are ignored. The rules are replicated through the Raft log
+	// like SetConfig, but changed without replacing the rest of the configuration, so
+	// concurrent changes don't overwrite each other. Only the leader changes rules.
+	AddAclRules(context.Context, *AddAclRulesRequest) (*AddAclRulesResponse, error)
+	// RemoveAclRules removes rules from the ACL rules of the cluster's configuration;
+	// rules not there are ignored. The policy files' rules can't be removed.
+	RemoveAclRules(context.Context, *RemoveAclRulesRequest) (*RemoveAclRulesResponse, error)
+	// ListAclRules returns the ACL rules of the cluster's configuration, as the server
+	// last applied them.
+	ListAclRules(context.Context, *ListAclRulesRequest) (*ListAclRulesResponse, error)
+	// CreateToken mints a short-lived bearer token authenticating as the caller, or as
+	// another subject, but only permitted the actions of its scopes, e.g. for a support
+	// engineer or batch job consuming a topic for a while without a certificate of its
+	// own. Tokens are signed with the cluster's token signing key, so every server
+	// accepts them until they expire, and the ACL must still permit their subjects.
+	CreateToken(context.Context, *CreateTokenRequest) (*CreateTokenResponse, error)
+	// ListConsumers returns the consumer groups the server served records to, by
+	// subject, topic and partition, with how far behind the head of the log each
+	// one is, so lagging consumers can be spotted. Each server only knows the
+	// groups it served, and forgets those that stopped consuming a while ago.
+	ListConsumers(context.Context, *ListConsumersRequest) (*ListConsumersResponse, error)
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAdminServer struct{}
+
+func (UnimplementedAdminServer) PromoteServer(context.Context, *PromoteServerRequest) (*PromoteServerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PromoteServer not implemented")
+}
+func (UnimplementedAdminServer) DescribeCluster(context.Context, *DescribeClusterRequest) (*DescribeClusterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DescribeCluster not implemented")
+}
+func (UnimplementedAdminServer) DescribeReplica(context.Context, *DescribeReplicaRequest) (*DescribeReplicaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DescribeReplica not implemented")
+}
+func (UnimplementedAdminServer) TransferLeadership(context.Context, *TransferLeadershipRequest) (*TransferLeadershipResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TransferLeadership not implemented")
+}
+func (UnimplementedAdminServer) GetLeadership(context.Context, *GetLeadershipRequest) (*GetLeadershipResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLeadership not implemented")
+}
+func (UnimplementedAdminServer) CreateTopic(context.Context, *CreateTopicRequest) (*CreateTopicResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateTopic not implemented")
+}
+func (UnimplementedAdminServer) ListTopics(context.Context, *ListTopicsRequest) (*ListTopicsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTopics not implemented")
+}
+func (UnimplementedAdminServer) TriggerRebalance(context.Context, *TriggerRebalanceRequest) (*TriggerRebalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TriggerRebalance not implemented")
+}
+func (UnimplementedAdminServer) PauseRebalance(context.Context, *PauseRebalanceRequest) (*PauseRebalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PauseRebalance not implemented")
+}
+func (UnimplementedAdminServer) Backup(*BackupRequest, grpc.ServerStreamingServer[BackupChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method Backup not implemented")
+}
+func (UnimplementedAdminServer) PrepareBackup(context.Context, *PrepareBackupRequest) (*PrepareBackupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PrepareBackup not implemented")
+}
+func (UnimplementedAdminServer) ReadBackup(*BackupRange, grpc.ServerStreamingServer[BackupChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method ReadBackup not implemented")
+}
+func (UnimplementedAdminServer) GetConfig(context.Context, *GetConfigRequest) (*GetConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetConfig not implemented")
+}
+func (UnimplementedAdminServer) SetConfig(context.Context, *SetConfigRequest) (*SetConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetConfig not implemented")
+}
+func (UnimplementedAdminServer) AddAclRules(context.Context, *AddAclRulesRequest) (*AddAclRulesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddAclRules not implemented")
+}
+func (UnimplementedAdminServer) RemoveAclRules(context.Context, *RemoveAclRulesRequest) (*RemoveAclRulesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveAclRules not implemented")
+}
+func (UnimplementedAdminServer) ListAclRules(context.Context, *ListAclRulesRequest) (*ListAclRulesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAclRules not implemented")
+}
+func (UnimplementedAdminServer) CreateToken(context.Context, *CreateTokenRequest) (*CreateTokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateToken not implemented")
+}
+func (UnimplementedAdminServer) ListConsumers(context.Context, *ListConsumersRequest) (*ListConsumersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListConsumers not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+func (UnimplementedAdminServer) testEmbeddedByValue()               {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	// If the following call pancis, it indicates UnimplementedAdminServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_PromoteServer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PromoteServerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).PromoteServer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_PromoteServer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).PromoteServer(ctx, req.(*PromoteServerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_DescribeCluster_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DescribeClusterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).DescribeCluster(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_DescribeCluster_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).DescribeCluster(ctx, req.(*DescribeClusterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_DescribeReplica_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DescribeReplicaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).DescribeReplica(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_DescribeReplica_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).DescribeReplica(ctx, req.(*DescribeReplicaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_TransferLeadership_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransferLeadershipRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).TransferLeadership(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_TransferLeadership_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).TransferLeadership(ctx, req.(*TransferLeadershipRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetLeadership_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLeadershipRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetLeadership(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_GetLeadership_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetLeadership(ctx, req.(*GetLeadershipRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_CreateTopic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateTopicRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).CreateTopic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_CreateTopic_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).CreateTopic(ctx, req.(*CreateTopicRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListTopics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTopicsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListTopics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListTopics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListTopics(ctx, req.(*ListTopicsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_TriggerRebalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TriggerRebalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).TriggerRebalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_TriggerRebalance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).TriggerRebalance(ctx, req.(*TriggerRebalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_PauseRebalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PauseRebalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).PauseRebalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_PauseRebalance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).PauseRebalance(ctx, req.(*PauseRebalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Backup_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BackupRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServer).Backup(m, &grpc.GenericServerStream[BackupRequest, BackupChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Admin_BackupServer = grpc.ServerStreamingServer[BackupChunk]
+
+func _Admin_PrepareBackup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PrepareBackupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).PrepareBackup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_PrepareBackup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).PrepareBackup(ctx, req.(*PrepareBackupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ReadBackup_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BackupRange)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServer).ReadBackup(m, &grpc.GenericServerStream[BackupRange, BackupChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Admin_ReadBackupServer = grpc.ServerStreamingServer[BackupChunk]
+
+func _Admin_GetConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_GetConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetConfig(ctx, req.(*GetConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_SetConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).SetConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_SetConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).SetConfig(ctx, req.(*SetConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_AddAclRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddAclRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).AddAclRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_AddAclRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).AddAclRules(ctx, req.(*AddAclRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_RemoveAclRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveAclRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).RemoveAclRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_RemoveAclRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).RemoveAclRules(ctx, req.(*RemoveAclRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListAclRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAclRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListAclRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListAclRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListAclRules(ctx, req.(*ListAclRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_CreateToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).CreateToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_CreateToken_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).CreateToken(ctx, req.(*CreateTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListConsumers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListConsumersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListConsumers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListConsumers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListConsumers(ctx, req.(*ListConsumersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "log.v1.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PromoteServer",
+			Handler:    _Admin_PromoteServer_Handler,
+		},
+		{
+			MethodName: "DescribeCluster",
+			Handler:    _Admin_DescribeCluster_Handler,
+		},
+		{
+			MethodName: "DescribeReplica",
+			Handler:    _Admin_DescribeReplica_Handler,
+		},
+		{
+			MethodName: "TransferLeadership",
+			Handler:    _Admin_TransferLeadership_Handler,
+		},
+		{
+			MethodName: "GetLeadership",
+			Handler:    _Admin_GetLeadership_Handler,
+		},
+		{
+			MethodName: "CreateTopic",
+			Handler:    _Admin_CreateTopic_Handler,
+		},
+		{
+			MethodName: "ListTopics",
+			Handler:    _Admin_ListTopics_Handler,
+		},
+		{
+			MethodName: "TriggerRebalance",
+			Handler:    _Admin_TriggerRebalance_Handler,
+		},
+		{
+			MethodName: "PauseRebalance",
+			Handler:    _Admin_PauseRebalance_Handler,
+		},
+		{
+			MethodName: "PrepareBackup",
+			Handler:    _Admin_PrepareBackup_Handler,
+		},
+		{
+			MethodName: "GetConfig",
+			Handler:    _Admin_GetConfig_Handler,
+		},
+		{
+			MethodName: "SetConfig",
+			Handler:    _Admin_SetConfig_Handler,
+		},
+		{
+			MethodName: "AddAclRules",
+			Handler:    _Admin_AddAclRules_Handler,
+		},
+		{
+			MethodName: "RemoveAclRules",
+			Handler:    _Admin_RemoveAclRules_Handler,
+		},
+		{
+			MethodName: "ListAclRules",
+			Handler:    _Admin_ListAclRules_Handler,
+		},
+		{
+			MethodName: "CreateToken",
+			Handler:    _Admin_CreateToken_Handler,
+		},
+		{
+			MethodName: "ListConsumers",
+			Handler:    _Admin_ListConsumers_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Backup",
+			Handler:       _Admin_Backup_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ReadBackup",
+			Handler:       _Admin_ReadBackup_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/v1/admin.proto",
+}
@@ -2,48 +2,258 @@ package log_v1
 
 import (
 	"fmt"
+	"strconv"
+	"time"
 
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
-// ErrOffsetOutOfRange is a custom error type used to indicate that
-// a requested offset is not available in the log.
-type ErrOffsetOutOfRange struct {
-	Offset uint64 // The out-of-range offset that triggered the error
+// ErrorDomain is the domain reported in the ErrorInfo details of every proglog error.
+const ErrorDomain = "proglog"
+
+// Reasons reported in the ErrorInfo details of proglog errors.
+// Clients should branch on these values rather than on error messages.
+const (
+	ReasonOffsetOutOfRange = "OFFSET_OUT_OF_RANGE" // The requested offset is not in the log
+	ReasonOffsetMismatch   = "OFFSET_MISMATCH"     // The log head moved past the expected offset
+	ReasonThrottled        = "THROTTLED"           // The caller exceeded its quota and must back off
+	ReasonReplicaBehind    = "REPLICA_BEHIND"      // The server hasn't caught up with the caller's writes
+	ReasonRecordTooLarge   = "RECORD_TOO_LARGE"    // The produced record exceeds the server's size limit
+	ReasonInvalidRequest   = "INVALID_REQUEST"     // The request is malformed and must not be retried as is
+	ReasonNotFound         = "NOT_FOUND"           // The addressed topic or partition doesn't exist
+	ReasonUnauthorized     = "UNAUTHORIZED"        // The caller may not perform the action on the object
+	ReasonStreamIdle       = "STREAM_IDLE"         // The stream was closed after being idle for too long
+	ReasonCanceled         = "CANCELED"            // The caller canceled the request or its deadline passed
+	ReasonUnavailable      = "UNAVAILABLE"         // The server can't serve the request right now
+	ReasonFenced           = "FENCED"              // The write came from a leader deposed by a newer one
+	ReasonFellBehind       = "FELL_BEHIND"         // The stream fell behind the changes it streams
+	ReasonInternal         = "INTERNAL"            // The server failed unexpectedly
+)
+
+// DefaultRetryDelay is the backoff suggested in the RetryInfo of retryable errors that
+// don't know how long the condition will last.
+const DefaultRetryDelay = 100 * time.Millisecond
+
+// NewError returns an error with the given code and message carrying an ErrorInfo detail
+// with the reason and metadata, plus a RetryInfo detail if the code is retryable.
+func NewError(code codes.Code, reason, msg string, metadata map[string]string) error {
+	var extra []protoadapt.MessageV1
+	if retryable(code) {
+		extra = append(extra, &errdetails.RetryInfo{RetryDelay: durationpb.New(DefaultRetryDelay)})
+	}
+	return newStatus(code, reason, msg, metadata, extra...).Err()
 }
 
-// GRPCStatus converts the ErrOffsetOutOfRange into a gRPC status, which can be sent to a client.
-// This function returns a status that contains the error code and a localized error message.
-func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
-	// Create a new gRPC status with a status code (404) and a descriptive error message
-	st := status.New(
-		404, // HTTP 404 equivalent error code for "not found"
-		fmt.Sprintf("The requested offset is outside the log's range: %d", e.Offset),
-	)
+// WithDetails returns the error with an ErrorInfo detail attached, so clients can rely on every
+// proglog error carrying a reason. Errors that already carry an ErrorInfo are returned as is;
+// context errors become Canceled or DeadlineExceeded, and anything else becomes Internal.
+func WithDetails(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		// Not a gRPC status, e.g. an I/O error from the log or a context error
+		st = status.FromContextError(err)
+	}
+	for _, d := range st.Details() {
+		if _, ok := d.(*errdetails.ErrorInfo); ok {
+			return err
+		}
+	}
+	return NewError(st.Code(), reasonForCode(st.Code()), st.Message(), nil)
+}
 
-	// Create a localized error message for additional details
-	msg := fmt.Sprintf("The requested offset is outside the log's range: %d", e.Offset)
-	d := &errdetails.LocalizedMessage{
-		Locale:  "en-US", // Locale for the message, set to English (US)
-		Message: msg,     // The descriptive error message
+// reasonForCode returns the reason reported for errors that didn't set a specific one.
+func reasonForCode(code codes.Code) string {
+	switch code {
+	case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition:
+		return ReasonInvalidRequest
+	case codes.NotFound:
+		return ReasonNotFound
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return ReasonUnauthorized
+	case codes.Canceled, codes.DeadlineExceeded:
+		return ReasonCanceled
+	case codes.Unavailable, codes.Aborted, codes.ResourceExhausted:
+		return ReasonUnavailable
+	default:
+		return ReasonInternal
 	}
+}
+
+// retryable reports whether a request failing with the code may succeed if retried unchanged.
+func retryable(code codes.Code) bool {
+	switch code {
+	case codes.Unavailable, codes.Aborted, codes.ResourceExhausted:
+		return true
+	default:
+		return false
+	}
+}
+
+// newStatus builds a gRPC status with the given code and message, attaching an ErrorInfo
+// detail carrying the reason and metadata, a LocalizedMessage with the message, and any
+// extra details. If the details cannot be attached, the plain status is returned.
+func newStatus(code codes.Code, reason, msg string, metadata map[string]string, extra ...protoadapt.MessageV1) *status.Status {
+	st := status.New(code, msg)
 
-	// Attach the localized message as additional details to the gRPC status
-	// This provides more context to clients when they receive the error
-	std, err := st.WithDetails(d)
+	// Attach the machine-readable reason and metadata, plus a human-readable message
+	details := append([]protoadapt.MessageV1{
+		&errdetails.ErrorInfo{
+			Reason:   reason,
+			Domain:   ErrorDomain,
+			Metadata: metadata,
+		},
+		&errdetails.LocalizedMessage{
+			Locale:  "en-US",
+			Message: msg,
+		},
+	}, extra...)
+	std, err := st.WithDetails(details...)
 	if err != nil {
 		// If there was an error adding the details, return the original status without additional details
 		return st
 	}
-
-	// Return the status with additional details
 	return std
 }
 
+// ErrOffsetOutOfRange is a custom error type used to indicate that
+// a requested offset is not available in the log.
+type ErrOffsetOutOfRange struct {
+	Offset uint64 // The out-of-range offset that triggered the error
+	Lowest uint64 // Lowest offset available in the log when the error occurred
+	Next   uint64 // Offset the next appended record will get; the log holds [Lowest, Next)
+}
+
+// GRPCStatus converts the ErrOffsetOutOfRange into a gRPC status, which can be sent to a client.
+// The status uses codes.OutOfRange and carries the requested offset and the log's current
+// range in its ErrorInfo metadata, so clients can decide whether to wait or seek.
+func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.OutOfRange,
+		ReasonOffsetOutOfRange,
+		fmt.Sprintf("The requested offset is outside the log's range: %d", e.Offset),
+		map[string]string{
+			"offset":        strconv.FormatUint(e.Offset, 10),
+			"lowest_offset": strconv.FormatUint(e.Lowest, 10),
+			"next_offset":   strconv.FormatUint(e.Next, 10),
+		},
+	)
+}
+
 // Error implements the standard error interface for ErrOffsetOutOfRange.
 // It returns a string representation of the gRPC status containing the error.
 func (e ErrOffsetOutOfRange) Error() string {
 	// Get the error message from the gRPC status and return it as a string
 	return e.GRPCStatus().Err().Error()
 }
+
+// ErrOffsetMismatch is returned by a compare-and-append when the record would not be
+// stored at the offset the producer expected, because the log head has moved.
+type ErrOffsetMismatch struct {
+	Expected uint64 // Offset the producer expected the record to be stored at
+	Next     uint64 // Offset the next appended record would actually get
+}
+
+// GRPCStatus converts the ErrOffsetMismatch into a gRPC status using codes.FailedPrecondition,
+// so clients know to re-read the log and retry with an updated expected offset.
+func (e ErrOffsetMismatch) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.FailedPrecondition,
+		ReasonOffsetMismatch,
+		fmt.Sprintf("The log head moved: expected offset %d, next offset is %d", e.Expected, e.Next),
+		map[string]string{
+			"expected_offset": strconv.FormatUint(e.Expected, 10),
+			"next_offset":     strconv.FormatUint(e.Next, 10),
+		},
+	)
+}
+
+// Error implements the standard error interface for ErrOffsetMismatch.
+func (e ErrOffsetMismatch) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrThrottled is returned when a subject exceeds its quota.
+type ErrThrottled struct {
+	Subject    string        // Subject whose quota was exceeded
+	Quota      string        // Name of the exceeded quota, e.g. "produce_bytes"
+	RetryAfter time.Duration // How long the subject should wait before retrying
+}
+
+// GRPCStatus converts the ErrThrottled into a gRPC status using codes.ResourceExhausted,
+// with a RetryInfo detail telling clients how long to back off.
+func (e ErrThrottled) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.ResourceExhausted,
+		ReasonThrottled,
+		fmt.Sprintf("%s exceeded the %s quota, retry after %s", e.Subject, e.Quota, e.RetryAfter),
+		map[string]string{
+			"subject": e.Subject,
+			"quota":   e.Quota,
+		},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(e.RetryAfter)},
+	)
+}
+
+// Error implements the standard error interface for ErrThrottled.
+func (e ErrThrottled) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrReplicaBehind is returned when a read presents a session token for a write the
+// serving replica hasn't received yet.
+type ErrReplicaBehind struct {
+	Offset uint64 // Offset of the write identified by the session token
+	Next   uint64 // Offset the replica's next appended record will get
+}
+
+// GRPCStatus converts the ErrReplicaBehind into a gRPC status using codes.Unavailable,
+// with a RetryInfo detail so clients retry, possibly against another replica.
+func (e ErrReplicaBehind) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.Unavailable,
+		ReasonReplicaBehind,
+		fmt.Sprintf("The replica hasn't caught up with offset %d yet, next offset is %d", e.Offset, e.Next),
+		map[string]string{
+			"offset":      strconv.FormatUint(e.Offset, 10),
+			"next_offset": strconv.FormatUint(e.Next, 10),
+		},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(DefaultRetryDelay)},
+	)
+}
+
+// Error implements the standard error interface for ErrReplicaBehind.
+func (e ErrReplicaBehind) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrRecordTooLarge is returned when a produced record exceeds the server's record size limit.
+type ErrRecordTooLarge struct {
+	Size int // Encoded size of the record in bytes
+	Max  int // Largest record size the server accepts in bytes
+}
+
+// GRPCStatus converts the ErrRecordTooLarge into a gRPC status using codes.InvalidArgument,
+// since retrying the same record can't succeed.
+func (e ErrRecordTooLarge) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.InvalidArgument,
+		ReasonRecordTooLarge,
+		fmt.Sprintf("The record is %d bytes, larger than the maximum of %d bytes", e.Size, e.Max),
+		map[string]string{
+			"size":     strconv.Itoa(e.Size),
+			"max_size": strconv.Itoa(e.Max),
+		},
+	)
+}
+
+// Error implements the standard error interface for ErrRecordTooLarge.
+func (e ErrRecordTooLarge) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/glauco/proglog/internal/agent"
+	"github.com/glauco/proglog/internal/audit"
+	"github.com/glauco/proglog/internal/auth"
+	"github.com/glauco/proglog/internal/config"
+	"github.com/glauco/proglog/internal/discovery"
+	"github.com/glauco/proglog/internal/server"
+	"github.com/glauco/proglog/internal/systemd"
+	"github.com/glauco/proglog/internal/version"
+	"github.com/glauco/proglog/pkg/devcert"
+	"github.com/glauco/proglog/pkg/vaultpki"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// envPrefix prefixes the environment variables setting the flags, e.g. PROGLOG_DATA_DIR.
+const envPrefix = "PROGLOG"
+
+// Exit codes, telling orchestrators whether restarting the agent may help.
+const (
+	// exitRuntime is the code of failures while starting or running, e.g. as the seed nodes
+	// couldn't be joined in time, which restarting may fix.
+	exitRuntime = 1
+	// exitConfig is the code of invalid configurations, e.g. missing files or a data directory
+	// that can't be written, which restarting won't fix.
+	exitConfig = 2
+)
+
+// addrs is a flag holding a comma-separated list of addresses, which may also be repeated.
+type addrs []string
+
+func (a *addrs) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *addrs) Set(value string) error {
+	for _, addr := range strings.Split(value, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			*a = append(*a, addr)
+		}
+	}
+	return nil
+}
+
+// newLogger returns the logger of the agent's logs, at the level and in the format, text or json,
+// writing to stderr. Without a format, info logs keep going through the standard logger.
+func newLogger(level slog.Level, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "":
+		if level == slog.LevelInfo {
+			return slog.Default(), nil
+		}
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	}
+	return nil, fmt.Errorf("-log-format must be text or json, got %q", format)
+}
+
+// validateTracing checks that the sample ratio is a fraction.
+func validateTracing(ratio float64) error {
+	if !(ratio >= 0 && ratio <= 1) {
+		return fmt.Errorf("-trace-sample-ratio must be between 0 and 1, got %v", ratio)
+	}
+	return nil
+}
+
+// newTracerProvider returns the provider of the node's tracers, appending their spans to the file
+// as JSON lines, in batches. Traces the node starts are sampled at the ratio, and those of the
+// requests follow their callers' decisions.
+func newTracerProvider(file string, ratio float64, nodeName string) (*sdktrace.TracerProvider, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("-trace-file: %w", err)
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "proglog"),
+			attribute.String("service.instance.id", nodeName),
+			attribute.String("service.version", version.Get().Version),
+		)),
+	), nil
+}
+
+// validateTokenSigning checks that scoped tokens are only minted and accepted over TLS, so they
+// aren't sent in the clear.
+func validateTokenSigning(keyFile string, serverTLS bool) error {
+	if keyFile != "" && !serverTLS {
+		return errors.New("-server-tls-cert-file or -vault-role is required to accept scoped tokens, so they aren't sent in the clear")
+	}
+	return nil
+}
+
+// validateJWT checks that JWTs are only accepted over TLS, so tokens aren't sent in the clear, and
+// that they're validated by one key set.
+func validateJWT(jwtConfig auth.JWTConfig, serverTLS bool) error {
+	if jwtConfig.JWKSURL == "" && jwtConfig.JWKSFile == "" {
+		return nil
+	}
+	var errs []error
+	if jwtConfig.JWKSURL != "" && jwtConfig.JWKSFile != "" {
+		errs = append(errs, errors.New("-jwt-jwks-url and -jwt-jwks-file are exclusive"))
+	}
+	if jwtConfig.Issuer == "" {
+		errs = append(errs, errors.New("-jwt-issuer is required to accept JWTs"))
+	}
+	if !serverTLS {
+		errs = append(errs, errors.New("-server-tls-cert-file or -vault-role is required to accept JWTs, so they aren't sent in the clear"))
+	}
+	return errors.Join(errs...)
+}
+
+// vaultFlags configure the certificates requested from Vault's PKI engine, instead of the TLS
+// files.
+type vaultFlags struct {
+	addr           string
+	tokenFile      string
+	namespace      string
+	mount          string
+	role           string
+	commonName     string
+	peerCommonName string
+	altNames       addrs
+	ttl            time.Duration
+	caFile         string
+}
+
+// register registers the flags, defaulting the address to VAULT_ADDR like Vault's CLI.
+func (f *vaultFlags) register() {
+	flag.StringVar(&f.addr, "vault-addr", os.Getenv("VAULT_ADDR"), "Address of the Vault server issuing the node's certificates.")
+	flag.StringVar(&f.tokenFile, "vault-token-file", "", "Path to the Vault token, read again at each renewal, e.g. written by a Vault agent; defaults to VAULT_TOKEN.")
+	flag.StringVar(&f.namespace, "vault-namespace", os.Getenv("VAULT_NAMESPACE"), "Vault namespace the PKI engine is mounted in.")
+	flag.StringVar(&f.mount, "vault-pki-mount", "pki", "Path the Vault PKI engine is mounted at.")
+	flag.StringVar(&f.role, "vault-role", "", "Vault PKI role issuing the node's server and peer certificates, renewed before they expire,\n"+
+		"instead of the -server-tls and -peer-tls files; disabled when empty.")
+	flag.StringVar(&f.commonName, "vault-common-name", "", "Common name of the node's server certificate (default the node name).")
+	flag.StringVar(&f.peerCommonName, "vault-peer-common-name", "root", "Common name the node authenticates to its peers as, which the ACL must allow to describe the cluster.")
+	flag.Var(&f.altNames, "vault-alt-names", "Comma-separated DNS names and IPs the server certificate is valid for, besides the -bind-addr host.")
+	flag.DurationVar(&f.ttl, "vault-ttl", 0, "How long the certificates are valid for (default the role's TTL); they're renewed after two thirds of it.")
+	flag.StringVar(&f.caFile, "vault-ca-file", "", "Path to the certificate authority of Vault's own TLS certificate, if not trusted by the system.")
+}
+
+// validate checks that Vault isn't configured along with the TLS files it replaces, and that it's
+// told where to issue the certificates from.
+func (f *vaultFlags) validate(serverTLS, peerTLS config.TLSFlags, devTLSDir string) error {
+	if f.role == "" {
+		return nil
+	}
+	var errs []error
+	if f.addr == "" {
+		errs = append(errs, errors.New("-vault-role requires -vault-addr"))
+	}
+	if f.tokenFile == "" && os.Getenv("VAULT_TOKEN") == "" {
+		errs = append(errs, errors.New("-vault-role requires -vault-token-file or VAULT_TOKEN"))
+	}
+	if serverTLS.IsSet() || peerTLS.IsSet() || devTLSDir != "" {
+		errs = append(errs, errors.New("-vault-role and the -server-tls, -peer-tls and -dev-tls-dir flags are exclusive"))
+	}
+	return errors.Join(errs...)
+}
+
+// setup returns the issuers of the server and peer certificates, reached at the host and named
+// after the node by default.
+func (f *vaultFlags) setup(nodeName, host string) (server, peer *vaultpki.Issuer, err error) {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	if f.caFile != "" {
+		tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{CAFile: f.caFile})
+		if err != nil {
+			return nil, nil, fmt.Errorf("-vault-ca-file: %w", err)
+		}
+		httpClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	cfg := vaultpki.Config{
+		Address:    f.addr,
+		Token:      os.Getenv("VAULT_TOKEN"),
+		TokenFile:  f.tokenFile,
+		Namespace:  f.namespace,
+		Mount:      f.mount,
+		Role:       f.role,
+		TTL:        f.ttl,
+		HTTPClient: httpClient,
+	}
+	if cfg.TokenFile != "" {
+		cfg.Token = ""
+	}
+	serverCfg := cfg
+	serverCfg.CommonName = cmp.Or(f.commonName, nodeName)
+	serverCfg.Hosts = append([]string{host}, f.altNames...)
+	if server, err = vaultpki.New(serverCfg); err != nil {
+		return nil, nil, err
+	}
+	peerCfg := cfg
+	peerCfg.CommonName = f.peerCommonName
+	if peer, err = vaultpki.New(peerCfg); err != nil {
+		server.Close()
+		return nil, nil, err
+	}
+	return server, peer, nil
+}
+
+func main() {
+	hostname, _ := os.Hostname()
+	var (
+		cfg            agent.Config
+		startJoinAddrs addrs
+		serverTLS      config.TLSFlags
+		peerTLS        config.TLSFlags
+		leaveOnExit    bool
+		metricsAddr    string
+		gossipKeyFile  string
+		devTLSDir      string
+		jwtConfig      auth.JWTConfig
+		subjectRules   server.SubjectRules
+		opaConfig      auth.OPAConfig
+		opaTokenFile   string
+		tokenKeyFile   string
+		tokenMaxTTL    time.Duration
+		auditSink      string
+		vault          vaultFlags
+		logLevel       slog.Level
+		logFormat      string
+		traceFile      string
+		traceRatio     float64
+	)
+	flag.StringVar(&cfg.NodeName, "node-name", hostname, "Unique name of the node in the cluster.")
+	flag.StringVar(&cfg.BindAddr, "bind-addr", "127.0.0.1:8401", "Address Serf gossips on.")
+	flag.IntVar(&cfg.RPCPort, "rpc-port", 8400, "Port gRPC, HTTP and Raft are served on, on the bind address' host.")
+	flag.StringVar(&cfg.DataDir, "data-dir", filepath.Join(os.TempDir(), "proglog"), "Directory the log and Raft state are stored in.")
+	flag.BoolVar(&cfg.Bootstrap, "bootstrap", false, "Form a new cluster; only for the first node of a cluster, and ignored once the data dir holds cluster state.")
+	flag.BoolVar(&cfg.NonVoter, "non-voter", false, "Join as a non-voter, which replicates the log without counting towards the quorum.")
+	flag.Var(&startJoinAddrs, "start-join-addrs", "Comma-separated Serf addresses of existing nodes to join the cluster through.")
+	flag.DurationVar(&cfg.JoinRetryTimeout, "join-retry-timeout", time.Minute, "How long to keep retrying to join -start-join-addrs while none is up, e.g. as they're starting too; 0 tries once.")
+	flag.StringVar(&cfg.Datacenter, "datacenter", "", "Datacenter the node runs in, e.g. its region.")
+	flag.StringVar(&cfg.Rack, "rack", "", "Rack the node runs in, e.g. its availability zone; partition replicas are spread across racks.")
+	flag.StringVar(&cfg.GossipProfile, "gossip-profile", discovery.ProfileLAN, "Serf failure detection profile: lan, or wan for nodes across datacenters.")
+	flag.StringVar(&cfg.JoinDNS, "join-dns", "", "DNS name of nodes to join the cluster through, re-resolved periodically: host:port for A/AAAA records, or an SRV name, e.g. _serf._tcp.proglog.example.com.")
+	flag.DurationVar(&cfg.JoinDNSInterval, "join-dns-interval", 0, "How often -join-dns is re-resolved (default 30s).")
+	flag.DurationVar(&cfg.FailedNodeTimeout, "failed-node-timeout", 0, "How long a node may be failed before it's removed from the cluster (default 30m).")
+	flag.DurationVar(&cfg.RebalanceInterval, "rebalance-interval", 0, "How often partition replicas are rebalanced across the nodes (default 1m); negative only rebalances when triggered.")
+	flag.IntVar(&cfg.MaxRebalanceMoves, "max-rebalance-moves", 0, "Most replicas a rebalance moves, throttling the data copied across the cluster (default 1).")
+	flag.StringVar(&cfg.ACLModelFile, "acl-model-file", config.ACLModelFile, "Path to the ACL model.")
+	flag.StringVar(&cfg.ACLPolicyFile, "acl-policy-file", config.ACLPolicyFile, "Path to the ACL policy.")
+	flag.BoolVar(&cfg.Insecure, "insecure", false, "Accept gRPC clients without certificates, e.g. over plaintext, as the anonymous subject; unless the ACL files are set explicitly, every client may do anything. For local development only.")
+	flag.StringVar(&jwtConfig.JWKSURL, "jwt-jwks-url", "", "URL of the JSON Web Key Set of the identity provider whose JWTs authenticate clients without certificates, e.g. its jwks_uri; disabled when empty.")
+	flag.StringVar(&jwtConfig.JWKSFile, "jwt-jwks-file", "", "Path to the JSON Web Key Set validating JWTs, instead of -jwt-jwks-url.")
+	flag.StringVar(&jwtConfig.Issuer, "jwt-issuer", "", "Issuer, the iss claim, of the JWTs accepted.")
+	flag.StringVar(&jwtConfig.Audience, "jwt-audience", "proglog", "Audience, in the aud claim, the JWTs accepted must be issued for.")
+	flag.StringVar(&jwtConfig.SubjectClaim, "jwt-subject-claim", "sub", "Claim of the JWTs naming the subject the ACL authorizes, e.g. email.")
+	flag.StringVar(&opaConfig.URL, "opa-url", "", "URL of the Open Policy Agent rule authorizing requests instead of the ACL files, e.g. http://127.0.0.1:8181/v1/data/proglog/authz;\n"+
+		"disabled when empty.")
+	flag.StringVar(&opaTokenFile, "opa-token-file", "", "Path to the bearer token authenticating the queries to OPA.")
+	flag.DurationVar(&opaConfig.CacheTTL, "opa-cache-ttl", 0, "How long OPA's decisions are cached; every request queries OPA when 0.")
+	flag.StringVar(&tokenKeyFile, "token-signing-key-file", "", "Path to the base64-encoded keys signing the scoped tokens CreateToken mints, one per line, the first signing;\n"+
+		"every node must share them. Tokens aren't minted nor accepted when empty.")
+	flag.DurationVar(&tokenMaxTTL, "token-max-ttl", 24*time.Hour, "Longest scoped tokens CreateToken mints are valid for.")
+	flag.Var(&subjectRules, "subject-rule", "Rule extracting the subject the ACL authorizes clients as from their certificates, instead of the CommonName: cn, dns, uri or email,\n"+
+		"optionally followed by a colon and a regexp the name must match, whose first group is the subject, e.g. uri:^spiffe://example\\.org/sa/(.+)$.\n"+
+		"Repeat it to try several rules in order; clients no rule matches, peers included, aren't authenticated.")
+	flag.StringVar(&auditSink, "audit-sink", "", "Where every authorization decision is audited: the path of a file to append JSON lines to,\n"+
+		"log:<dir> for a commit log in the directory, slog for the node's log, or an http:// or https:// URL to post batches of events to;\n"+
+		"disabled when empty.")
+	vault.register()
+	flag.StringVar(&devTLSDir, "dev-tls-dir", "", "Directory to generate a throwaway CA, certificates and ACL into, or to reuse them from, securing the node and authorizing clients when the TLS and ACL flags aren't set. For local development only.")
+	flag.BoolVar(&leaveOnExit, "leave-on-exit", false, "Leave the cluster when stopped, instead of being kept as failed until reaped.")
+	flag.StringVar(&gossipKeyFile, "gossip-key-file", "", "Path to the base64-encoded keys encrypting the Serf gossip, one per line, the first encrypting; gossip is plaintext when empty.")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Address to serve Prometheus metrics on at /metrics, e.g. 127.0.0.1:9100; disabled when empty.")
+	flag.TextVar(&logLevel, "log-level", slog.LevelInfo, "Lowest level logged: debug, e.g. for segment rolls and streams opening and closing, info, warn or error.")
+	flag.StringVar(&logFormat, "log-format", "", "Format of the logs: text or json key-value records (default the standard log's lines, which omit debug ones).")
+	flag.StringVar(&traceFile, "trace-file", "", "Path of a file to append the OpenTelemetry spans of the RPCs and of the log's appends and syncs to, as JSON lines,\n"+
+		"continuing the traces of the requests' W3C traceparent metadata; tracing is disabled when empty.")
+	flag.Float64Var(&traceRatio, "trace-sample-ratio", 1, "Fraction of the traces started by the node that are sampled with -trace-file; those of sampled requests always are.")
+	flag.String("config-file", "", "Path to a YAML, or TOML if named *.toml, file setting flags not given on the command line, keyed by their names.")
+	serverTLS.Register(flag.CommandLine, envPrefix, "server", "server's")
+	peerTLS.Register(flag.CommandLine, envPrefix, "peer", "peer's")
+	// The command parses the flags, and layers the environment variables and the config file
+	// under them; it returns without sources once it printed the help or the version
+	var sources config.Sources
+	cmd := &cobra.Command{
+		Use:   filepath.Base(os.Args[0]) + " [flags]",
+		Short: "Run a node of a proglog cluster.",
+		Long: "Runs a node of a proglog cluster, serving the log over gRPC and HTTP and replicating it with Raft.\n\n" +
+			"Flags not given on the command line are read from PROGLOG_<FLAG> environment variables,\n" +
+			"e.g. PROGLOG_DATA_DIR, then from --config-file. Flags may be written with one dash or two.",
+		Version:       version.String(version.Get()),
+		Args:          cobra.NoArgs,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, _ []string) (err error) {
+			sources, err = config.LayerFlags(cmd.Flags(), envPrefix, "config-file")
+			return err
+		},
+	}
+	cmd.Flags().AddGoFlagSet(flag.CommandLine)
+	cmd.SetArgs(config.LongFlagArgs(cmd.Flags(), os.Args[1:]))
+	if err := cmd.Execute(); err != nil {
+		fatalf(exitConfig, "%v\nRun '%s --help' for usage.", err, filepath.Base(os.Args[0]))
+	}
+	if sources == nil {
+		return
+	}
+	if err := devDefaults(sources, cfg.Insecure, devTLSDir, cfg.BindAddr); err != nil {
+		fatal(exitConfig, err)
+	}
+	if err := opaDefaults(sources, opaConfig.URL); err != nil {
+		fatal(exitConfig, err)
+	}
+	// Fail on missing or incomplete files now, naming the flags, rather than once first used
+	files := append(serverTLS.Files(), peerTLS.Files()...)
+	files = append(files, "acl-model-file", "acl-policy-file", "gossip-key-file", "jwt-jwks-file", "vault-token-file", "vault-ca-file", "opa-token-file", "token-signing-key-file")
+	logger, logErr := newLogger(logLevel, logFormat)
+	if err := errors.Join(
+		logErr,
+		validateTracing(traceRatio),
+		serverTLS.Validate(),
+		peerTLS.Validate(),
+		vault.validate(serverTLS, peerTLS, devTLSDir),
+		validateJWT(jwtConfig, serverTLS.HasCert() || vault.role != ""),
+		validateTokenSigning(tokenKeyFile, serverTLS.HasCert() || vault.role != ""),
+		config.CheckFiles(flag.CommandLine, sources, files...),
+	); err != nil {
+		fatalf(exitConfig, "invalid configuration:\n%v", err)
+	}
+	log.Printf("version %s", version.String(version.Get()))
+	log.Println("effective configuration:")
+	config.PrintFlags(log.Writer(), flag.CommandLine, sources)
+	cfg.StartJoinAddrs = startJoinAddrs
+	cfg.SubjectRules = subjectRules
+	cfg.Logger = logger
+
+	// Create the data directory, e.g. on a fresh volume, and fail now if it can't be written
+	if err := prepareDataDir(cfg.DataDir); err != nil {
+		fatal(exitConfig, err)
+	}
+	host, _, err := net.SplitHostPort(cfg.BindAddr)
+	if err != nil {
+		fatal(exitConfig, err)
+	}
+	if cfg.ServerTLSConfig, err = serverTLS.Setup(true, host); err != nil {
+		fatal(exitConfig, err)
+	}
+	if cfg.PeerTLSConfig, err = peerTLS.Setup(false, host); err != nil {
+		fatal(exitConfig, err)
+	}
+	var vaultServer, vaultPeer *vaultpki.Issuer
+	if vault.role != "" {
+		// Vault may be briefly unavailable, so failing to issue is a runtime error, retried by
+		// restarting
+		if vaultServer, vaultPeer, err = vault.setup(cfg.NodeName, host); err != nil {
+			fatal(exitRuntime, err)
+		}
+		cfg.ServerTLSConfig = vaultServer.ServerTLSConfig()
+		cfg.PeerTLSConfig = vaultPeer.ClientTLSConfig(host)
+	}
+	if jwtConfig.JWKSURL != "" || jwtConfig.JWKSFile != "" {
+		if cfg.TokenValidator, err = auth.NewJWTValidator(jwtConfig); err != nil {
+			fatal(exitConfig, err)
+		}
+	}
+	if tokenKeyFile != "" {
+		keys, err := config.LoadTokenSigningKeys(tokenKeyFile)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		if cfg.TokenIssuer, err = auth.NewTokenSigner(auth.TokenSignerConfig{Keys: keys, MaxTTL: tokenMaxTTL}); err != nil {
+			fatal(exitConfig, err)
+		}
+	}
+	if opaConfig.URL != "" {
+		if opaTokenFile != "" {
+			token, err := os.ReadFile(opaTokenFile)
+			if err != nil {
+				fatal(exitConfig, err)
+			}
+			opaConfig.Token = strings.TrimSpace(string(token))
+		}
+		if cfg.Authorizer, err = auth.NewOPAAuthorizer(opaConfig); err != nil {
+			fatal(exitConfig, err)
+		}
+	}
+	if gossipKeyFile != "" {
+		if cfg.GossipKeys, err = config.LoadGossipKeyring(gossipKeyFile); err != nil {
+			fatal(exitConfig, err)
+		}
+	}
+
+	if metricsAddr != "" {
+		registry := prometheus.NewRegistry()
+		cfg.Metrics = registry
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		go func() {
+			fatal(exitRuntime, http.ListenAndServe(metricsAddr, mux))
+		}()
+	}
+
+	// Serve on the socket systemd opened, if socket activated
+	if cfg.RPCListener, err = systemd.Listener(); err != nil {
+		fatal(exitConfig, err)
+	}
+	if cfg.RPCListener != nil {
+		rpcAddr, _ := cfg.RPCAddr()
+		if _, port, _ := net.SplitHostPort(cfg.RPCListener.Addr().String()); port != fmt.Sprint(cfg.RPCPort) {
+			// The other nodes are told to reach the node on the RPC port
+			fatalf(exitConfig, "socket activation: the socket listens on %s, but -rpc-port makes the node reachable on %s", cfg.RPCListener.Addr(), rpcAddr)
+		}
+		log.Printf("serving on the socket-activated %s", cfg.RPCListener.Addr())
+	}
+
+	var auditor *audit.Auditor
+	if auditSink != "" {
+		sink, err := audit.Open(auditSink, logger)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		auditor = audit.New(sink, audit.Config{})
+		cfg.Auditor = auditor
+	}
+
+	var tracerProvider *sdktrace.TracerProvider
+	if traceFile != "" {
+		if tracerProvider, err = newTracerProvider(traceFile, traceRatio, cfg.NodeName); err != nil {
+			fatal(exitConfig, err)
+		}
+		cfg.TracerProvider = tracerProvider
+	}
+
+	a, err := agent.New(cfg)
+	if err != nil {
+		fatal(exitConfig, err)
+	}
+	if err := a.Start(); err != nil {
+		fatal(exitRuntime, err)
+	}
+	systemd.NotifyOrLog(systemd.Ready)
+
+	// Run until told to stop, then leave the cluster or just shut down
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	<-sigc
+	systemd.NotifyOrLog(systemd.Stopping)
+	stop := a.Shutdown
+	if leaveOnExit {
+		stop = a.Leave
+	}
+	if err := stop(); err != nil {
+		fatal(exitRuntime, err)
+	}
+	// Write the decisions audited last once the servers stopped, and the spans ended last
+	if auditor != nil {
+		if err := auditor.Close(); err != nil {
+			fatal(exitRuntime, err)
+		}
+	}
+	if tracerProvider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := tracerProvider.Shutdown(ctx); err != nil {
+			fatal(exitRuntime, err)
+		}
+	}
+	if vaultServer != nil {
+		vaultServer.Close()
+		vaultPeer.Close()
+	}
+}
+
+// prepareDataDir creates the data directory if it doesn't exist, and checks it can be written.
+func prepareDataDir(dir string) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("-data-dir: %w", err)
+	}
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("-data-dir: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// fatal logs the error and exits with the code.
+func fatal(code int, err error) {
+	log.Print(err)
+	os.Exit(code)
+}
+
+// fatalf logs the formatted error and exits with the code.
+func fatalf(code int, format string, v ...any) {
+	log.Printf(format, v...)
+	os.Exit(code)
+}
+
+// opaDefaults unsets the ACL flags when OPA authorizes requests instead, failing if they were set
+// too. Their sources tell they were unset by -opa-url.
+func opaDefaults(sources config.Sources, opaURL string) error {
+	if opaURL == "" {
+		return nil
+	}
+	for _, name := range []string{"acl-model-file", "acl-policy-file"} {
+		if source := sources[name]; source != "" && source != "dev-tls-dir" && source != "insecure" {
+			return fmt.Errorf("-opa-url and -%s (%s) are exclusive", name, source)
+		}
+		if err := flag.Set(name, ""); err != nil {
+			return err
+		}
+		sources[name] = "opa-url"
+	}
+	return nil
+}
+
+// devDefaults defaults the ACL flags not set to none when insecure, so every client may do
+// anything, and the TLS and ACL flags not set, nor replaced by secrets, to the files of the development directory, if any,
+// generated unless it holds them already. Their sources tell they came from -dev-tls-dir.
+func devDefaults(sources config.Sources, insecure bool, devTLSDir, bindAddr string) error {
+	defaults := make(map[string]string)
+	if insecure {
+		defaults["acl-model-file"], defaults["acl-policy-file"] = "", ""
+	}
+	if devTLSDir != "" {
+		host, _, err := net.SplitHostPort(bindAddr)
+		if err != nil {
+			return err
+		}
+		if err := devcert.Generate(devTLSDir, append([]string{host}, devcert.LocalHosts...)...); err != nil {
+			return fmt.Errorf("-dev-tls-dir: %w", err)
+		}
+		path := func(name string) string { return filepath.Join(devTLSDir, name) }
+		maps.Copy(defaults, map[string]string{
+			"server-tls-cert-file": path(devcert.ServerCertFile),
+			"server-tls-key-file":  path(devcert.ServerKeyFile),
+			"server-tls-ca-file":   path(devcert.CAFile),
+			"peer-tls-cert-file":   path(devcert.RootClientCertFile),
+			"peer-tls-key-file":    path(devcert.RootClientKeyFile),
+			"peer-tls-ca-file":     path(devcert.CAFile),
+		})
+		if !insecure {
+			defaults["acl-model-file"], defaults["acl-policy-file"] = path(devcert.ACLModelFile), path(devcert.ACLPolicyFile)
+		}
+	}
+	for name, value := range defaults {
+		// A TLS file isn't defaulted when its secret replaces it
+		if sources[name] != "" || sources[strings.TrimSuffix(name, "-file")+"-secret"] != "" {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return err
+		}
+		sources[name] = "dev-tls-dir"
+		if value == "" {
+			sources[name] = "insecure"
+		}
+	}
+	return nil
+}
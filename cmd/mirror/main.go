@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/config"
+	prolog "github.com/glauco/proglog/internal/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// envPrefix prefixes the environment variables holding the passphrases of encrypted keys, e.g.
+// PROGLOG_SOURCE_TLS_KEY_PASSPHRASE.
+const envPrefix = "PROGLOG"
+
+// clusterFlags are the address of a cluster the mirror connects to, and the files, or references
+// to the secrets, securing the connection.
+type clusterFlags struct {
+	addr string
+	tls  config.TLSFlags
+}
+
+// register registers the flags, named after the prefix, e.g. -source-tls-cert-file.
+func (f *clusterFlags) register(prefix string) {
+	flag.StringVar(&f.addr, prefix+"-addr", "", "RPC address of a server of the "+prefix+" cluster.")
+	f.tls.Register(flag.CommandLine, envPrefix, prefix, prefix+" cluster client's")
+}
+
+// client returns a client of the cluster, connected over TLS if any file or secret was given.
+func (f *clusterFlags) client() (api.LogClient, error) {
+	creds := insecure.NewCredentials()
+	if f.tls.IsSet() {
+		host, _, err := net.SplitHostPort(f.addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig, err := f.tls.Setup(false, host)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	cc, err := grpc.NewClient(f.addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return api.NewLogClient(cc), nil
+}
+
+func main() {
+	var (
+		source, destination clusterFlags
+		mirror              prolog.Mirror
+		replicated          bool
+	)
+	source.register("source")
+	destination.register("destination")
+	flag.BoolVar(&mirror.PreserveOffsets, "preserve-offsets", false, "Store every record at its source offset; the destination must only be written by the mirror.")
+	flag.BoolVar(&replicated, "acks-replicated", false, "Wait for a quorum of the destination to store each record before mirroring the next.")
+	flag.Parse()
+	if source.addr == "" || destination.addr == "" {
+		log.Fatal("both -source-addr and -destination-addr are required")
+	}
+	if err := errors.Join(source.tls.Validate(), destination.tls.Validate()); err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+
+	var err error
+	if mirror.Source, err = source.client(); err != nil {
+		log.Fatal(err)
+	}
+	if mirror.Destination, err = destination.client(); err != nil {
+		log.Fatal(err)
+	}
+	if replicated {
+		mirror.Acks = api.Acks_ACKS_REPLICATED
+	}
+
+	// Mirror until told to stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := mirror.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	apiv2 "github.com/glauco/proglog/api/v2"
+	"github.com/glauco/proglog/internal/config"
+	"github.com/glauco/proglog/internal/mqtt"
+	"github.com/glauco/proglog/internal/systemd"
+	"github.com/glauco/proglog/internal/version"
+	"github.com/glauco/proglog/pkg/loadbalance"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix prefixes the environment variables setting the flags, e.g. PROGLOG_CLUSTER_ADDR.
+const envPrefix = "PROGLOG"
+
+// routes is a flag holding a comma-separated list of filter=topic routes, which may also be
+// repeated.
+type routes []mqtt.Route
+
+func (r *routes) String() string {
+	s := make([]string, len(*r))
+	for i, route := range *r {
+		s[i] = route.Filter + "=" + route.Topic
+	}
+	return strings.Join(s, ",")
+}
+
+func (r *routes) Set(value string) error {
+	for _, route := range strings.Split(value, ",") {
+		if route = strings.TrimSpace(route); route == "" {
+			continue
+		}
+		filter, topic, _ := strings.Cut(route, "=")
+		*r = append(*r, mqtt.Route{Filter: filter, Topic: topic})
+	}
+	return nil
+}
+
+// readRoutes reads the routes listed under the routes key of the YAML file.
+func readRoutes(path string) ([]mqtt.Route, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Routes []mqtt.Route `yaml:"routes"`
+	}
+	if err := yaml.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("routes file %s: %w", path, err)
+	}
+	return file.Routes, nil
+}
+
+func main() {
+	var (
+		srv         mqtt.Server
+		listenAddr  string
+		clusterAddr string
+		routesFile  string
+		flagRoutes  routes
+		deviceTLS   config.TLSFlags
+		clusterTLS  config.TLSFlags
+		anonymous   bool
+	)
+	flag.String("config-file", "", "Path to a YAML, or TOML if named *.toml, file setting flags not given on the command line, keyed by their names.")
+	flag.StringVar(&listenAddr, "listen-addr", ":1883", "Address devices connect to; use :8883 with TLS.")
+	flag.StringVar(&clusterAddr, "cluster-addr", "", "RPC address of a server of the cluster the messages are appended to.")
+	flag.StringVar(&routesFile, "routes-file", "", "Path to a YAML file listing the routes mapping MQTT topics to the log's topics, under the routes key.")
+	flag.Var(&flagRoutes, "route", "Comma-separated filter=topic routes, e.g. devices/+/telemetry=telemetry, tried before -routes-file's; an empty topic is the default topic.")
+	flag.BoolVar(&anonymous, "allow-anonymous", false, "Accept devices without authenticating them, when -tls-ca-file isn't set;\n"+
+		"any host reaching -listen-addr may then append records as the cluster client.")
+	flag.IntVar(&srv.MaxPacketSize, "max-packet-size", mqtt.DefaultMaxPacketSize, "Largest packet, and so message, devices may send, in bytes.")
+	deviceTLS.Register(flag.CommandLine, envPrefix, "", "bridge's, served to devices,")
+	clusterTLS.Register(flag.CommandLine, envPrefix, "cluster", "cluster client's")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n\n"+
+			"Flags not given on the command line are read from PROGLOG_<FLAG> environment variables,\n"+
+			"e.g. PROGLOG_CLUSTER_ADDR, then from -config-file.\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	sources, err := config.ParseFlags(flag.CommandLine, os.Args[1:], envPrefix, "config-file")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Fail on missing or incomplete files now, naming the flags, rather than once first used
+	var errs []error
+	if clusterAddr == "" {
+		errs = append(errs, errors.New("-cluster-addr is required"))
+	}
+	if len(flagRoutes) == 0 && routesFile == "" {
+		errs = append(errs, errors.New("-route or -routes-file is required"))
+	}
+	if deviceTLS.HasCA() && !deviceTLS.HasCert() {
+		errs = append(errs, errors.New("-tls-ca-file requires -tls-cert-file, as devices are verified over TLS"))
+	}
+	if !deviceTLS.HasCA() && !anonymous {
+		errs = append(errs, errors.New("devices must be authenticated: set -tls-ca-file to require their certificates,\n"+
+			"or -allow-anonymous to accept any device reaching -listen-addr"))
+	}
+	files := append(deviceTLS.Files(), clusterTLS.Files()...)
+	if err := errors.Join(append(errs,
+		deviceTLS.Validate(),
+		clusterTLS.Validate(),
+		config.CheckFiles(flag.CommandLine, sources, append(files, "routes-file")...),
+	)...); err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+	log.Printf("version %s", version.String(version.Get()))
+	log.Println("effective configuration:")
+	config.PrintFlags(log.Writer(), flag.CommandLine, sources)
+
+	routes := []mqtt.Route(flagRoutes)
+	if routesFile != "" {
+		fileRoutes, err := readRoutes(routesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		routes = append(routes, fileRoutes...)
+	}
+
+	// Produce through the leader, following it as it changes
+	creds := insecure.NewCredentials()
+	host, _, err := net.SplitHostPort(clusterAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	clusterTLSConfig, err := clusterTLS.Setup(false, host)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if clusterTLSConfig != nil {
+		creds = credentials.NewTLS(clusterTLSConfig)
+	}
+	cc, err := grpc.NewClient(fmt.Sprintf("%s:///%s", loadbalance.Name, clusterAddr), grpc.WithTransportCredentials(creds))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cc.Close()
+	bridge, err := mqtt.NewBridge(apiv2.NewLogClient(cc), routes, slog.Default())
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv.Handler = bridge.Handle
+	if !deviceTLS.HasCA() {
+		log.Printf("warning: devices aren't authenticated, so any host reaching %s may append records as the cluster client", listenAddr)
+	}
+
+	// Serve on the socket systemd opened, if socket activated, or listen on the address
+	ln, err := systemd.Listener()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ln != nil {
+		log.Printf("serving on the socket-activated %s, ignoring -listen-addr", ln.Addr())
+	} else if ln, err = net.Listen("tcp", listenAddr); err != nil {
+		log.Fatal(err)
+	}
+	deviceTLSConfig, err := deviceTLS.Setup(true, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if deviceTLSConfig != nil {
+		ln = tls.NewListener(ln, deviceTLSConfig)
+	}
+
+	// Serve until told to stop, or serving fails
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ln)
+	}()
+	systemd.NotifyOrLog(systemd.Ready)
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-served:
+		log.Fatal(err)
+	case sig := <-sigc:
+		log.Printf("received %s, shutting down", sig)
+	}
+	// Messages being appended aren't acknowledged, so devices publish them again once reconnected
+	systemd.NotifyOrLog(systemd.Stopping)
+	if err := srv.Close(); err != nil {
+		log.Print(err)
+	}
+}
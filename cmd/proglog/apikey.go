@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/glauco/proglog/internal/auth"
+)
+
+// runAPIKey issues, revokes and lists the API keys of a store, e.g. the -api-key-file of
+// cmd/server, which picks up the changes without a restart.
+func runAPIKey(_ context.Context, args []string) error {
+	var (
+		file    string
+		subject string
+		name    string
+		ttl     time.Duration
+	)
+	fs := newFlagSet("apikey <issue|revoke|list>", "\n\nCommands:\n"+
+		"  issue -subject <subject>  Issue a key to the subject, printing it once; only its hash is stored.\n"+
+		"  revoke <id>               Revoke the key with the ID.\n"+
+		"  list                      List the keys, without the keys themselves.\n\n"+
+		"e.g. proglog apikey issue -file /etc/proglog/api-keys.json -subject webhooks -name 'billing webhook'", nil)
+	fs.StringVar(&file, "file", "", "Path to the store of API keys, e.g. cmd/server's -api-key-file.")
+	fs.StringVar(&subject, "subject", "", "Subject the ACL authorizes clients with the key as; required to issue.")
+	fs.StringVar(&name, "name", "", "What the key is for, e.g. the webhook sending it.")
+	fs.DurationVar(&ttl, "ttl", 0, "How long the key is accepted for; 0 never expires.")
+	if len(args) == 0 {
+		fs.Usage()
+		return errors.New("expected issue, revoke or list")
+	}
+	cmd := args[0]
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if file == "" {
+		fs.Usage()
+		return errors.New("-file is required")
+	}
+	store, err := auth.OpenAPIKeyStore(file)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "issue":
+		if subject == "" {
+			return errors.New("-subject is required to issue a key")
+		}
+		key, info, err := store.Issue(subject, name, ttl)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "issued key %s to %s; it isn't shown again\n", info.ID, info.Subject)
+		fmt.Println(key)
+		return nil
+	case "revoke":
+		if fs.NArg() != 1 {
+			return errors.New("expected the ID of the key to revoke")
+		}
+		return store.Revoke(fs.Arg(0))
+	case "list":
+		keys, err := store.Keys()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSUBJECT\tNAME\tCREATED\tEXPIRES")
+		for _, k := range keys {
+			expires := "never"
+			if k.Expires != nil {
+				expires = k.Expires.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", k.ID, k.Subject, k.Name, k.Created.Format(time.RFC3339), expires)
+		}
+		return w.Flush()
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown apikey command %q", cmd)
+	}
+}
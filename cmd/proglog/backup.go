@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/log"
+	"github.com/glauco/proglog/pkg/client"
+	"google.golang.org/protobuf/encoding/protodelim"
+)
+
+// backupBatchSize is how many records a chunk of a directory's backup holds at most, as many as
+// the servers' backups.
+const backupBatchSize = 100
+
+// runBackup writes a backup of a cluster, streamed by its leader's Admin service, or of a log's
+// directory to an archive file.
+func runBackup(ctx context.Context, args []string) error {
+	var (
+		conn connFlags
+		out  string
+		dir  string
+	)
+	fs := newFlagSet("backup", "\n\nWrites a consistent backup of the cluster, from its leader, to the archive -out: its\n"+
+		"topics and the records of every partition. With -dir, backs up the log in the directory\n"+
+		"instead, as the default topic, without a server.", &conn)
+	fs.StringVar(&out, "out", "", "Path to the archive to write, gzipped if named *.gz; - writes to stdout.")
+	fs.StringVar(&dir, "dir", "", "Log directory to back up instead of a server, e.g. a stopped agent's <data-dir>/log.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if out == "" {
+		return errors.New("-out is required")
+	}
+	archive, err := createArchive(out)
+	if err != nil {
+		return err
+	}
+	var stats backupStats
+	if dir != "" {
+		err = backupDir(dir, archive, &stats)
+	} else {
+		err = backupServer(ctx, &conn, archive, &stats)
+	}
+	if err := archive.close(err == nil); err != nil {
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "backed up %d records of %d partitions\n", stats.records, stats.partitions)
+	return nil
+}
+
+// backupStats counts what a backup holds.
+type backupStats struct {
+	partitions int
+	records    int
+}
+
+// backupServer writes the backup streamed by the server to the archive.
+func backupServer(ctx context.Context, conn *connFlags, archive *archiveWriter, stats *backupStats) error {
+	cc, err := conn.dial()
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+	stream, err := api.NewAdminClient(cc).Backup(ctx, &api.BackupRequest{})
+	if err != nil {
+		return err
+	}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		stats.partitions += len(chunk.Ranges)
+		stats.records += len(chunk.Records)
+		if err := archive.write(chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// backupDir writes the records of the log's directory to the archive, as the default topic's.
+func backupDir(dir string, archive *archiveWriter, stats *backupStats) error {
+	inspector, err := log.Inspect(dir)
+	if err != nil {
+		return err
+	}
+	defer inspector.Close()
+	segments := inspector.Segments()
+	if len(segments) == 0 {
+		return fmt.Errorf("no segments in %s", dir)
+	}
+	r := &api.BackupRange{
+		Topic:        log.DefaultTopic,
+		LowestOffset: segments[0].BaseOffset,
+		NextOffset:   segments[len(segments)-1].NextOffset,
+	}
+	if err := archive.write(&api.BackupChunk{Ranges: []*api.BackupRange{r}}); err != nil {
+		return err
+	}
+	stats.partitions = 1
+	chunk := &api.BackupChunk{Topic: r.Topic}
+	for off := r.LowestOffset; off < r.NextOffset; off++ {
+		record, err := inspector.Read(off)
+		if err != nil {
+			return fmt.Errorf("read offset %d: %w", off, err)
+		}
+		chunk.Records = append(chunk.Records, record)
+		stats.records++
+		if len(chunk.Records) == backupBatchSize || off+1 == r.NextOffset {
+			if err := archive.write(chunk); err != nil {
+				return err
+			}
+			chunk = &api.BackupChunk{Topic: r.Topic}
+		}
+	}
+	return nil
+}
+
+// runRestore replays the records of a partition of an archive into a server's default topic, or
+// into a log's directory.
+func runRestore(ctx context.Context, args []string) error {
+	var (
+		conn       connFlags
+		in         string
+		dir        string
+		topic      string
+		partition  uint
+		replicated bool
+	)
+	fs := newFlagSet("restore", "\n\nReplays the records of a partition of the archive -in, the default topic's by default, by\n"+
+		"producing them to the server's default topic, which gives them new offsets. With -dir,\n"+
+		"writes them to a new log in the directory instead, at their offsets, without a server.", &conn)
+	fs.StringVar(&in, "in", "", "Path to the archive to read, gunzipped if named *.gz; - reads from stdin.")
+	fs.StringVar(&dir, "dir", "", "Directory to write a log to instead of producing to a server; it must not hold a log yet.")
+	fs.StringVar(&topic, "topic", log.DefaultTopic, "Topic of the archive to restore the records of.")
+	fs.UintVar(&partition, "partition", 0, "Partition of -topic to restore the records of.")
+	fs.BoolVar(&replicated, "acks-replicated", false, "Wait for a quorum of the cluster to store the records.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if in == "" {
+		return errors.New("-in is required")
+	}
+	archive, err := openArchive(in)
+	if err != nil {
+		return err
+	}
+	defer archive.close()
+
+	// The first chunk holds the ranges backed up
+	first, err := archive.read()
+	if err != nil {
+		return fmt.Errorf("read archive %s: %w", in, err)
+	}
+	var r *api.BackupRange
+	var available []string
+	for _, rr := range first.Ranges {
+		if rr.Topic == topic && rr.Partition == uint32(partition) {
+			r = rr
+		}
+		available = append(available, fmt.Sprintf("%s/%d", rr.Topic, rr.Partition))
+	}
+	if r == nil {
+		if len(available) == 0 {
+			return fmt.Errorf("%s isn't a backup archive: it holds no ranges", in)
+		}
+		return fmt.Errorf("archive %s holds no partition %d of topic %q, only %s", in, partition, topic, strings.Join(available, ", "))
+	}
+
+	// records calls fn with the records of the partition, in order
+	records := func(fn func(*api.Record) error) error {
+		for {
+			chunk, err := archive.read()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("read archive %s: %w", in, err)
+			}
+			if chunk.Topic != r.Topic || chunk.Partition != r.Partition {
+				continue
+			}
+			for _, record := range chunk.Records {
+				if err := fn(record); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if dir != "" {
+		return restoreDir(dir, r, records)
+	}
+	return restoreServer(ctx, &conn, replicated, records)
+}
+
+// restoreServer produces the records to the server's default topic.
+func restoreServer(ctx context.Context, conn *connFlags, replicated bool, records func(func(*api.Record) error) error) error {
+	logClient, err := conn.client()
+	if err != nil {
+		return err
+	}
+	config := client.ProducerConfig{}
+	if replicated {
+		config.Acks = api.Acks_ACKS_REPLICATED
+	}
+	producer := client.NewProducer(logClient, config)
+	var (
+		mu          sync.Mutex
+		first, last uint64
+		produced    int
+		errs        []error
+	)
+	err = records(func(record *api.Record) error {
+		// The server gives the record its offset
+		record.Offset = 0
+		return producer.Produce(ctx, record, func(offset uint64, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			if produced == 0 {
+				first = offset
+			}
+			last = offset
+			produced++
+		})
+	})
+	// Closing waits for the records buffered to be produced
+	producer.Close()
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d records failed, first with: %w", len(errs), errs[0])
+	}
+	if produced > 0 {
+		fmt.Fprintf(os.Stderr, "restored %d records at offsets %d-%d\n", produced, first, last)
+	}
+	return nil
+}
+
+// restoreDir writes the records to a new log in the directory, at the offsets they were backed
+// up at.
+func restoreDir(dir string, r *api.BackupRange, records func(func(*api.Record) error) error) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	inspector, err := log.Inspect(dir)
+	if err != nil {
+		return err
+	}
+	existing := len(inspector.Segments())
+	inspector.Close()
+	if existing > 0 {
+		return fmt.Errorf("%s already holds a log", dir)
+	}
+	config := log.Config{}
+	config.Segment.InitialOffset = r.LowestOffset
+	l, err := log.NewLog(dir, config)
+	if err != nil {
+		return err
+	}
+	restored := 0
+	err = records(func(record *api.Record) error {
+		want := record.Offset
+		off, err := l.Append(record)
+		if err != nil {
+			return err
+		}
+		if off != want {
+			return fmt.Errorf("record at offset %d was restored at %d: the archive's records aren't contiguous", want, off)
+		}
+		restored++
+		return nil
+	})
+	if cerr := l.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "restored %d records at offsets [%d, %d) to %s\n", restored, r.LowestOffset, r.NextOffset, dir)
+	return nil
+}
+
+// archiveWriter writes a backup archive: the chunks of a backup, each prefixed with its size as
+// a varint, gzipped if the archive is named *.gz. It writes to a temporary file renamed once
+// the backup completed, so a failed backup doesn't leave a partial archive behind.
+type archiveWriter struct {
+	path string   // Path of the archive, or - for stdout
+	file *os.File // Temporary file, or stdout
+	gz   *gzip.Writer
+	w    *bufio.Writer
+}
+
+// createArchive creates the archive at the path.
+func createArchive(path string) (*archiveWriter, error) {
+	a := &archiveWriter{path: path, file: os.Stdout}
+	if path != "-" {
+		f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+		if err != nil {
+			return nil, err
+		}
+		a.file = f
+	}
+	var w io.Writer = a.file
+	if strings.HasSuffix(path, ".gz") {
+		a.gz = gzip.NewWriter(w)
+		w = a.gz
+	}
+	a.w = bufio.NewWriter(w)
+	return a, nil
+}
+
+// write writes the chunk.
+func (a *archiveWriter) write(chunk *api.BackupChunk) error {
+	_, err := protodelim.MarshalTo(a.w, chunk)
+	return err
+}
+
+// close flushes and closes the archive, then moves it to its path if the backup completed, or
+// removes it otherwise.
+func (a *archiveWriter) close(completed bool) error {
+	err := a.w.Flush()
+	if a.gz != nil {
+		err = errors.Join(err, a.gz.Close())
+	}
+	if a.path == "-" {
+		return err
+	}
+	err = errors.Join(err, a.file.Close())
+	if err != nil || !completed {
+		os.Remove(a.file.Name())
+		return err
+	}
+	return os.Rename(a.file.Name(), a.path)
+}
+
+// archiveReader reads a backup archive written by an archiveWriter.
+type archiveReader struct {
+	file *os.File // Archive, or stdin
+	gz   *gzip.Reader
+	r    *bufio.Reader
+}
+
+// openArchive opens the archive at the path.
+func openArchive(path string) (*archiveReader, error) {
+	a := &archiveReader{file: os.Stdin}
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		a.file = f
+	}
+	var r io.Reader = a.file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			a.close()
+			return nil, err
+		}
+		a.gz, r = gz, gz
+	}
+	a.r = bufio.NewReader(r)
+	return a, nil
+}
+
+// read reads the next chunk, or fails with io.EOF at the archive's end.
+func (a *archiveReader) read() (*api.BackupChunk, error) {
+	chunk := &api.BackupChunk{}
+	// Chunks hold up to a batch of records, which may be larger than the default limit
+	if err := (protodelim.UnmarshalOptions{MaxSize: -1}).UnmarshalFrom(a.r, chunk); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// close closes the archive.
+func (a *archiveReader) close() error {
+	if a.gz != nil {
+		a.gz.Close()
+	}
+	if a.file == os.Stdin {
+		return nil
+	}
+	return a.file.Close()
+}
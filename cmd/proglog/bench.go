@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/pkg/client"
+)
+
+// benchSentAt is the header stamping benchmark records with when they were produced, in
+// nanoseconds since the epoch, so consumers measure how long records take to reach them.
+const benchSentAt = "proglog-bench-sent-at"
+
+// runBench produces and/or consumes records for a duration and reports the throughput and latency
+// percentiles.
+func runBench(ctx context.Context, args []string) error {
+	var (
+		conn        connFlags
+		mode        string
+		recordSize  int
+		concurrency int
+		duration    time.Duration
+		batchSize   int
+		linger      time.Duration
+		replicated  bool
+	)
+	fs := newFlagSet("bench", "\n\nDrives produce and/or consume load against the server for -duration, then reports the\n"+
+		"throughput and latency percentiles. Produce latency is how long records take to be\n"+
+		"acknowledged; consume latency is how long records take from being produced to being consumed,\n"+
+		"measured for records produced by a benchmark once the consumers started.", &conn)
+	fs.StringVar(&mode, "mode", "produce", "Load to drive: produce, consume, or both at once.")
+	fs.IntVar(&recordSize, "record-size", 100, "Size of the records' values produced, in bytes.")
+	fs.IntVar(&concurrency, "concurrency", 1, "Number of producers, and of consumers each consuming every record.")
+	fs.DurationVar(&duration, "duration", 10*time.Second, "How long to drive the load for.")
+	fs.IntVar(&batchSize, "batch-size", 100, "Most records the producers send in a batch.")
+	fs.DurationVar(&linger, "linger", 5*time.Millisecond, "How long the producers wait for a batch to fill before sending it.")
+	fs.BoolVar(&replicated, "acks-replicated", false, "Wait for a quorum of the cluster to store the records.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	produce, consume := mode == "produce" || mode == "both", mode == "consume" || mode == "both"
+	switch {
+	case !produce && !consume:
+		return fmt.Errorf("-mode must be produce, consume or both, not %q", mode)
+	case recordSize < 0 || concurrency < 1 || duration <= 0 || batchSize < 1:
+		return errors.New("-record-size, -concurrency, -duration and -batch-size must be positive")
+	}
+	logClient, err := conn.client()
+	if err != nil {
+		return err
+	}
+
+	// Consumers consume the whole log, or only what's produced once they start when producing too
+	var from uint64
+	if consume {
+		lowest, next, err := client.Offsets(ctx, logClient)
+		if err != nil {
+			return err
+		}
+		from = lowest
+		if produce {
+			from = next
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	var (
+		wg        sync.WaitGroup
+		produced  benchStats
+		consumed  benchStats
+		start     = time.Now()
+		value     = make([]byte, recordSize)
+		config    = client.ProducerConfig{BatchSize: batchSize, Linger: linger}
+		consumers = make([]error, concurrency)
+	)
+	rand.Read(value)
+	if replicated {
+		config.Acks = api.Acks_ACKS_REPLICATED
+	}
+	for i := 0; i < concurrency; i++ {
+		if produce {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				benchProduce(ctx, client.NewProducer(logClient, config), value, &produced)
+			}()
+		}
+		if consume {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				consumers[i] = benchConsume(ctx, logClient, from, start, &consumed)
+			}()
+		}
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if produce {
+		produced.report(os.Stdout, "produce", elapsed)
+	}
+	if consume {
+		consumed.report(os.Stdout, "consume", elapsed)
+	}
+	if err := errors.Join(consumers...); err != nil {
+		return fmt.Errorf("consume: %w", err)
+	}
+	if produced.errors > 0 {
+		return fmt.Errorf("%d records failed, first with: %w", produced.errors, produced.err)
+	}
+	return nil
+}
+
+// benchProduce produces records with the value until ctx is done, then closes the producer, which
+// waits for the records buffered to be acknowledged.
+func benchProduce(ctx context.Context, producer *client.Producer, value []byte, stats *benchStats) {
+	defer producer.Close()
+	for ctx.Err() == nil {
+		sent := time.Now()
+		record := &api.Record{
+			Value:   value,
+			Headers: map[string]string{benchSentAt: strconv.FormatInt(sent.UnixNano(), 10)},
+		}
+		err := producer.Produce(ctx, record, func(_ uint64, err error) {
+			stats.add(len(value), time.Since(sent), err)
+		})
+		if err != nil {
+			return
+		}
+	}
+}
+
+// benchConsume consumes the records from the offset until ctx is done, measuring the latency of
+// those produced by a benchmark after start.
+func benchConsume(ctx context.Context, logClient api.LogClient, from uint64, start time.Time, stats *benchStats) error {
+	stream, err := logClient.ConsumeStream(ctx, &api.ConsumeRequest{Offset: from})
+	if err != nil {
+		return err
+	}
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		latency := time.Duration(-1)
+		if ns, err := strconv.ParseInt(res.Record.Headers[benchSentAt], 10, 64); err == nil {
+			if sent := time.Unix(0, ns); !sent.Before(start) {
+				latency = time.Since(sent)
+			}
+		}
+		stats.add(len(res.Record.Value), latency, nil)
+	}
+}
+
+// benchStats collects the records produced or consumed by a benchmark, concurrently.
+type benchStats struct {
+	mu        sync.Mutex
+	records   int
+	bytes     int
+	errors    int
+	err       error // First error
+	latencies []time.Duration
+}
+
+// add adds a record of the size, with its latency if not negative, or its error.
+func (s *benchStats) add(size int, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		if s.errors == 0 {
+			s.err = err
+		}
+		s.errors++
+		return
+	}
+	s.records++
+	s.bytes += size
+	if latency >= 0 {
+		s.latencies = append(s.latencies, latency)
+	}
+}
+
+// report writes the throughput over the elapsed time, and the latency percentiles, e.g.
+//
+//	produce: 120000 records, 11.4 MiB in 10.0s: 12000 records/s, 1.1 MiB/s, 0 errors
+//	  latency: p50 1.2ms, p90 2.3ms, p99 5.1ms, p99.9 9.4ms, max 12.1ms
+func (s *benchStats) report(w io.Writer, name string, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	const mib = 1 << 20
+	secs := elapsed.Seconds()
+	fmt.Fprintf(w, "%s: %d records, %.1f MiB in %.1fs: %.0f records/s, %.2f MiB/s, %d errors\n",
+		name, s.records, float64(s.bytes)/mib, secs, float64(s.records)/secs, float64(s.bytes)/mib/secs, s.errors)
+	if len(s.latencies) == 0 {
+		return
+	}
+	slices.Sort(s.latencies)
+	percentile := func(p float64) time.Duration {
+		i := int(math.Ceil(p*float64(len(s.latencies)))) - 1
+		return s.latencies[max(i, 0)].Round(time.Microsecond)
+	}
+	fmt.Fprintf(w, "  latency: p50 %v, p90 %v, p99 %v, p99.9 %v, max %v\n",
+		percentile(0.5), percentile(0.9), percentile(0.99), percentile(0.999), percentile(1))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/pkg/client"
+)
+
+// errDone stops consuming once the records asked for were printed.
+var errDone = errors.New("done")
+
+// runConsume prints the records from an offset, up to the log's end or a count.
+func runConsume(ctx context.Context, args []string) error {
+	var (
+		conn     connFlags
+		output   recordFlags
+		from     uint64
+		count    uint64
+		follow   bool
+		bookmark string
+	)
+	fs := newFlagSet("consume", "\n\nPrints the records from -from-offset, one per line, until the log's end. -n counts the\n"+
+		"records read, whether the filters print them or not.\n\n"+
+		"With -bookmark, the offset of the next record to print is saved to the file every few seconds\n"+
+		"and on exit, and consuming resumes from it when run again, e.g. by a script run periodically:\n\n"+
+		"  proglog consume -bookmark ~/.proglog/orders -f\n\n"+
+		"Records printed since the last save, e.g. before a crash, are printed again.", &conn)
+	output.register(fs)
+	fs.Uint64Var(&from, "from-offset", 0, "Offset of the first record to print, unless resuming from -bookmark.")
+	fs.Uint64Var(&count, "n", 0, "Most records to print; 0 prints them all.")
+	fs.BoolVar(&follow, "f", false, "Keep printing the records as they're produced, instead of stopping at the log's end.")
+	fs.StringVar(&bookmark, "bookmark", "", "Path to the file saving the consumer's position, to resume from when run again; created if missing.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	printer, err := output.printer(os.Stdout)
+	if err != nil {
+		return err
+	}
+	var store client.OffsetStore
+	if bookmark != "" {
+		if strings.HasPrefix(bookmark, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			bookmark = filepath.Join(home, bookmark[2:])
+		}
+		fileStore := client.NewFileStore(bookmark)
+		offset, ok, err := fileStore.Load(ctx)
+		if err != nil {
+			return fmt.Errorf("-bookmark: %w", err)
+		}
+		if ok {
+			from = offset
+		}
+		store = fileStore
+	}
+	logClient, err := conn.client()
+	if err != nil {
+		return err
+	}
+	return printRecords(ctx, logClient, printer, store, from, count, follow)
+}
+
+// runTail prints the latest records, and keeps printing the new ones if following.
+func runTail(ctx context.Context, args []string) error {
+	var (
+		conn   connFlags
+		output recordFlags
+		last   uint64
+		follow bool
+	)
+	fs := newFlagSet("tail", "\n\nPrints the log's latest records, one per line, e.g. to watch the records produced with -f:\n\n"+
+		"  proglog tail -f -format json -key-prefix user- -header source=web\n"+
+		"  proglog tail -f -template '{{.AppendTime.Format \"15:04:05\"}} {{.Key}} {{.Value}}'\n\n"+
+		"-n counts the latest records, whether the filters print them or not.", &conn)
+	output.register(fs)
+	fs.Uint64Var(&last, "n", 10, "How many of the latest records to print.")
+	fs.BoolVar(&follow, "f", false, "Keep printing the records as they're produced.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	printer, err := output.printer(os.Stdout)
+	if err != nil {
+		return err
+	}
+	logClient, err := conn.client()
+	if err != nil {
+		return err
+	}
+	lowest, next, err := client.Offsets(ctx, logClient)
+	if err != nil {
+		return err
+	}
+	from := lowest
+	if next-lowest > last {
+		from = next - last
+	}
+	return printRecords(ctx, logClient, printer, nil, from, 0, follow)
+}
+
+// printRecords prints the records from the offset with the printer: count of them if set, and
+// until the log's end as of the call unless following. Streams failing meanwhile are resumed from
+// the next record to print. The position is committed to the store, if set.
+func printRecords(ctx context.Context, logClient api.LogClient, printer *recordPrinter, store client.OffsetStore, from, count uint64, follow bool) error {
+	until := uint64(0) // Offset to stop at, unless 0
+	if count > 0 {
+		until = from + count
+	}
+	if !follow {
+		_, next, err := client.Offsets(ctx, logClient)
+		if err != nil {
+			return err
+		}
+		if until == 0 || next < until {
+			until = next
+		}
+		if from >= until {
+			return nil
+		}
+	}
+	// Consuming stops at the record following the last to print, which isn't processed, rather
+	// than at the last, so the last counts as consumed in the position committed. The log may not
+	// hold the following record yet, so consuming is canceled too
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	done := false
+	consumer := client.NewConsumer(logClient, client.ConsumerConfig{Offset: from, Store: store})
+	err := consumer.Run(ctx, func(_ context.Context, record *api.Record) error {
+		if done {
+			return errDone
+		}
+		if err := printer.Print(record); err != nil {
+			return err
+		}
+		if until != 0 && record.Offset+1 >= until {
+			done = true
+			stop()
+		}
+		return nil
+	})
+	if done && (errors.Is(err, errDone) || errors.Is(err, context.Canceled)) {
+		return nil
+	}
+	return err
+}
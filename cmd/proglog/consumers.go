@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+)
+
+// runConsumers lists the consumer groups the server served records to, with their lag.
+func runConsumers(ctx context.Context, args []string) error {
+	var (
+		conn  connFlags
+		topic string
+		group string
+	)
+	fs := newFlagSet("consumers", "\n\nLists the consumer groups the server served records to, by subject, topic and partition,\n"+
+		"with how many records each one is behind the head of the log. Each server only knows the\n"+
+		"groups it served, so list every server's to see a cluster's.", &conn)
+	fs.StringVar(&topic, "topic", "", "Topic to list the consumers of; every topic's if empty.")
+	fs.StringVar(&group, "group", "", "Consumer group to list; every group if empty.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cc, err := conn.dial()
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+	res, err := api.NewAdminClient(cc).ListConsumers(ctx, &api.ListConsumersRequest{Topic: topic, Group: group})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tPARTITION\tGROUP\tSUBJECT\tOFFSET\tHIGH WATERMARK\tLAG\tLAST CONSUMED")
+	for _, c := range res.Consumers {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\t%d\t%d\t%s\n", c.Topic, c.Partition, c.Group, c.Subject, c.Offset,
+			c.HighWatermark, c.Lag, c.LastConsumed.AsTime().Format(time.RFC3339))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+)
+
+// recordFlags are the flags choosing how the records consumed are printed, and which are.
+type recordFlags struct {
+	format    string
+	template  string
+	keyPrefix string
+	headers   headerFilters
+}
+
+// register registers the flags on the command's flag set.
+func (f *recordFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.format, "format", "raw", "How records are printed: raw prints their values, json an object per line, as proglog replay reads,\n"+
+		"hex a dump of their values, and template -template; -template alone implies template.")
+	fs.StringVar(&f.template, "template", "", "Go template printing each record, e.g. '{{.Offset}} {{.Key}}: {{.Value}}', with .Offset, .Key, .Value,\n"+
+		".Headers, .AppendTime and .LeaderEpoch, and the hex and json functions; a newline is appended if missing.")
+	fs.StringVar(&f.keyPrefix, "key-prefix", "", "Print only the records whose keys start with the prefix.")
+	fs.Var(&f.headers, "header", "Print only the records with the header, as name=value, or name for any value; repeat to require several.")
+}
+
+// printer returns the printer of the records the flags choose, writing to w.
+func (f *recordFlags) printer(w io.Writer) (*recordPrinter, error) {
+	format := f.format
+	if f.template != "" && format == "raw" {
+		format = "template"
+	}
+	p := &recordPrinter{w: w, keyPrefix: f.keyPrefix, headers: f.headers}
+	switch format {
+	case "raw":
+		p.print = p.printRaw
+	case "json":
+		p.print = p.printJSON
+	case "hex":
+		p.print = p.printHex
+	case "template":
+		if f.template == "" {
+			return nil, fmt.Errorf("-format template needs -template")
+		}
+		tmpl, err := template.New("record").Funcs(template.FuncMap{
+			"hex":  func(s string) string { return hex.EncodeToString([]byte(s)) },
+			"json": jsonValue,
+		}).Parse(f.template)
+		if err != nil {
+			return nil, fmt.Errorf("-template: %w", err)
+		}
+		p.tmpl = tmpl
+		p.print = p.printTemplate
+	default:
+		return nil, fmt.Errorf("-format must be raw, json, hex or template, not %q", f.format)
+	}
+	return p, nil
+}
+
+// headerFilters is a flag holding the headers records must have, as name=value, or name for any
+// value, which may be repeated.
+type headerFilters []string
+
+func (h *headerFilters) String() string {
+	return strings.Join(*h, ",")
+}
+
+func (h *headerFilters) Set(value string) error {
+	if name, _, _ := strings.Cut(value, "="); name == "" {
+		return fmt.Errorf("expected name=value or name, not %q", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// match returns whether the record has every header.
+func (h headerFilters) match(record *api.Record) bool {
+	for _, filter := range h {
+		name, value, hasValue := strings.Cut(filter, "=")
+		got, ok := record.Headers[name]
+		if !ok || hasValue && got != value {
+			return false
+		}
+	}
+	return true
+}
+
+// recordPrinter prints the records matching its filters in its format.
+type recordPrinter struct {
+	w         io.Writer
+	keyPrefix string
+	headers   headerFilters
+	tmpl      *template.Template
+	print     func(record *api.Record) error
+}
+
+// Print prints the record, unless the filters skip it.
+func (p *recordPrinter) Print(record *api.Record) error {
+	if !bytes.HasPrefix(record.Key, []byte(p.keyPrefix)) || !p.headers.match(record) {
+		return nil
+	}
+	return p.print(record)
+}
+
+// printRaw prints the record's value on a line.
+func (p *recordPrinter) printRaw(record *api.Record) error {
+	_, err := fmt.Fprintf(p.w, "%s\n", record.Value)
+	return err
+}
+
+// printedRecord is a record printed as JSON, whose fields are those proglog replay reads, and
+// its offset.
+type printedRecord struct {
+	Offset    uint64            `json:"offset"`
+	Value     json.RawMessage   `json:"value"`
+	Key       *string           `json:"key,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// printJSON prints the record as a JSON object on a line.
+func (p *recordPrinter) printJSON(record *api.Record) error {
+	printed := printedRecord{
+		Offset:    record.Offset,
+		Value:     json.RawMessage(jsonValue(string(record.Value))),
+		Headers:   record.Headers,
+		Timestamp: record.AppendTime.AsTime(),
+	}
+	if record.Key != nil {
+		key := string(record.Key)
+		printed.Key = &key
+	}
+	b, err := json.Marshal(printed)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(p.w, "%s\n", b)
+	return err
+}
+
+// printHex prints a line naming the record's offset and key, then a hex dump of its value.
+func (p *recordPrinter) printHex(record *api.Record) error {
+	if _, err := fmt.Fprintf(p.w, "offset %d, key %q, %d bytes:\n%s", record.Offset, record.Key, len(record.Value), hex.Dump(record.Value)); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(p.w)
+	return err
+}
+
+// templateRecord is the record the templates are executed with, whose bytes are strings so
+// templates print them as text.
+type templateRecord struct {
+	Offset      uint64
+	Key         string
+	Value       string
+	Headers     map[string]string
+	AppendTime  time.Time
+	LeaderEpoch uint64
+}
+
+// printTemplate prints the record with the template, ending with a newline.
+func (p *recordPrinter) printTemplate(record *api.Record) error {
+	var b bytes.Buffer
+	if err := p.tmpl.Execute(&b, templateRecord{
+		Offset:      record.Offset,
+		Key:         string(record.Key),
+		Value:       string(record.Value),
+		Headers:     record.Headers,
+		AppendTime:  record.AppendTime.AsTime(),
+		LeaderEpoch: record.LeaderEpoch,
+	}); err != nil {
+		return err
+	}
+	if !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+		b.WriteByte('\n')
+	}
+	_, err := p.w.Write(b.Bytes())
+	return err
+}
+
+// jsonValue returns the value as JSON: as is if it's JSON other than a string, e.g. an object,
+// and as a JSON string otherwise, the way proglog replay reads values back.
+func jsonValue(value string) string {
+	trimmed := strings.TrimSpace(value)
+	if json.Valid([]byte(trimmed)) && !strings.HasPrefix(trimmed, `"`) {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, []byte(trimmed)); err == nil {
+			return compact.String()
+		}
+	}
+	b, _ := json.Marshal(value)
+	return string(b)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/log"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// runInspect describes the segments of a log's directory, or prints records of it, without a
+// server and without modifying it.
+func runInspect(_ context.Context, args []string) error {
+	var (
+		offset uint64
+		count  uint64
+	)
+	fs := newFlagSet("inspect", " <dir>\n\nDescribes the segments of the log in <dir>, e.g. an agent's <data-dir>/log, without\n"+
+		"modifying it, or prints its records from -offset as JSON. The log's server should be stopped.", nil)
+	fs.Uint64Var(&offset, "offset", 0, "Offset of the first record to print, instead of describing the segments.")
+	fs.Uint64Var(&count, "n", 1, "How many records to print from -offset.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected the log's directory")
+	}
+	dir := fs.Arg(0)
+	printRecords := false
+	fs.Visit(func(f *flag.Flag) {
+		printRecords = printRecords || f.Name == "offset" || f.Name == "n"
+	})
+
+	inspector, err := log.Inspect(dir)
+	if err != nil {
+		return err
+	}
+	defer inspector.Close()
+	segments := inspector.Segments()
+	if len(segments) == 0 {
+		// The directory may be an agent's data dir rather than its log's
+		if _, err := os.Stat(filepath.Join(dir, "log")); err == nil {
+			return fmt.Errorf("no segments in %s; an agent's data dir holds its log in %s", dir, filepath.Join(dir, "log"))
+		}
+		return fmt.Errorf("no segments in %s", dir)
+	}
+
+	if printRecords {
+		marshal := protojson.MarshalOptions{Multiline: true, Indent: "  ", EmitUnpopulated: true}
+		for off := offset; off < offset+count; off++ {
+			record, err := inspector.Read(off)
+			var outOfRange api.ErrOffsetOutOfRange
+			if errors.As(err, &outOfRange) {
+				return fmt.Errorf("offset %d is outside the log's range [%d, %d)", off, outOfRange.Lowest, outOfRange.Next)
+			} else if err != nil {
+				return err
+			}
+			b, err := marshal.Marshal(record)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\n", b)
+		}
+		return nil
+	}
+
+	var records uint64
+	var bytes int64
+	for _, s := range segments {
+		records += s.Records
+		bytes += s.StoreBytes + s.IndexBytes
+	}
+	fmt.Printf("%s: %d records at offsets [%d, %d) in %d segments, %d bytes\n\n",
+		dir, records, segments[0].BaseOffset, segments[len(segments)-1].NextOffset, len(segments), bytes)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BASE OFFSET\tNEXT OFFSET\tRECORDS\tSTORE BYTES\tINDEX BYTES\tSTATE")
+	for _, s := range segments {
+		state := "closed"
+		if !s.Clean {
+			state = "open, or not closed before a crash"
+		}
+		if s.TrailingBytes > 0 {
+			state += fmt.Sprintf("; %d bytes past the last whole record, dropped once opened", s.TrailingBytes)
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%s\n", s.BaseOffset, s.NextOffset, s.Records, s.StoreBytes, s.IndexBytes, state)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,171 @@
+// Command proglog produces records to and consumes records from a proglog server over its gRPC
+// API, so the log can be used without writing Go, and inspects logs' directories, e.g.
+//
+//	proglog produce -file events.txt
+//	proglog replay -file events.jsonl -rate 1000/s
+//	proglog consume -from-offset 42 -n 10
+//	proglog tail -f
+//	proglog offsets
+//	proglog consumers -topic orders
+//	proglog bench -mode both -concurrency 4 -duration 30s
+//	proglog inspect -offset 42 /var/lib/proglog/log
+//	proglog backup -out backup.gz
+//	proglog apikey issue -file api-keys.json -subject webhooks
+//	proglog token -scope payments:consume -ttl 2h
+//	proglog version
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/config"
+	"github.com/glauco/proglog/pkg/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// command is a subcommand of the CLI.
+type command struct {
+	summary string
+	// run runs the command with its arguments, those following the command's name.
+	run func(ctx context.Context, args []string) error
+}
+
+// commands are the CLI's subcommands, by name.
+var commands = map[string]command{
+	"produce":   {"Produce records read from stdin or a file, one per line.", runProduce},
+	"replay":    {"Produce the records of a JSONL, CSV or protobuf file at a controlled rate.", runReplay},
+	"consume":   {"Print the records from an offset.", runConsume},
+	"tail":      {"Print the latest records, and follow the new ones with -f.", runTail},
+	"offsets":   {"Print the range of offsets the log holds.", runOffsets},
+	"consumers": {"List the consumer groups the server served, with their lag.", runConsumers},
+	"bench":     {"Drive produce and consume load, and report throughput and latency.", runBench},
+	"inspect":   {"Describe a log's directory, or print its records, without a server.", runInspect},
+	"backup":    {"Write a backup of the cluster, or of a log's directory, to an archive.", runBackup},
+	"restore":   {"Replay the records of a backup archive into a server or a log's directory.", runRestore},
+	"version":   {"Print the build of the CLI and of the server.", runVersion},
+	"apikey":    {"Issue, revoke and list the API keys authenticating HTTP clients.", runAPIKey},
+	"token":     {"Mint a short-lived token scoped to some actions, e.g. consuming a topic.", runToken},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		if os.Args[1] != "help" && os.Args[1] != "-h" && os.Args[1] != "-help" {
+			fmt.Fprintf(os.Stderr, "proglog: unknown command %q\n", os.Args[1])
+		}
+		usage()
+		os.Exit(2)
+	}
+
+	// Run until done or told to stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := cmd.run(ctx, os.Args[2:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "proglog %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the commands.
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: proglog <command> [flags]\n\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun 'proglog <command> -h' for the command's flags.")
+}
+
+// connFlags are the address of the server the commands connect to, and the files, or references
+// to the secrets, securing the connection.
+type connFlags struct {
+	addr      string
+	tls       config.TLSFlags
+	tokenFile string
+}
+
+// register registers the flags on the command's flag set.
+func (f *connFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.addr, "addr", "127.0.0.1:8400", "RPC address of the server.")
+	f.tls.Register(fs, "PROGLOG", "", "client's")
+	fs.StringVar(&f.tokenFile, "token-file", "", "Path to the bearer token, e.g. a JWT, the server authenticates the client with instead of a certificate;\n"+
+		"needs -tls-ca-file.")
+}
+
+// client returns a client of the server, connected over TLS if any file or secret was given.
+func (f *connFlags) client() (api.LogClient, error) {
+	cc, err := f.dial()
+	if err != nil {
+		return nil, err
+	}
+	return api.NewLogClient(cc), nil
+}
+
+// dial returns a connection to the server, over TLS if any file or secret was given, for the commands
+// using services other than Log.
+func (f *connFlags) dial() (*grpc.ClientConn, error) {
+	if err := f.tls.Validate(); err != nil {
+		return nil, err
+	}
+	creds := insecure.NewCredentials()
+	if f.tls.IsSet() {
+		host, _, err := net.SplitHostPort(f.addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig, err := f.tls.Setup(false, host)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	// Reads are retried while the server is unavailable, e.g. as a leader is elected
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds), client.WithRetryPolicy(client.RetryPolicy{})}
+	if f.tokenFile != "" {
+		// Tokens are read from a file rather than a flag, so they don't show in the process list
+		token, err := os.ReadFile(f.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, client.WithBearerToken(strings.TrimSpace(string(token))))
+	}
+	return grpc.NewClient(f.addr, opts...)
+}
+
+// newFlagSet returns the flag set of the command, whose usage is the command's arguments following
+// its name and flags, and a description. The connection flags are registered if conn is set.
+func newFlagSet(name, usage string, conn *connFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: proglog %s [flags]%s\n\nFlags:\n", name, usage)
+		fs.PrintDefaults()
+	}
+	if conn != nil {
+		conn.register(fs)
+	}
+	return fs
+}
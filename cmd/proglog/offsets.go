@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/glauco/proglog/pkg/client"
+)
+
+// runOffsets prints the range of offsets the log holds.
+func runOffsets(ctx context.Context, args []string) error {
+	var conn connFlags
+	fs := newFlagSet("offsets", "\n\nPrints the offset of the log's oldest record and the one the next record will get.", &conn)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	logClient, err := conn.client()
+	if err != nil {
+		return err
+	}
+	lowest, next, err := client.Offsets(ctx, logClient)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("lowest_offset: %d\nnext_offset: %d\n", lowest, next)
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/pkg/client"
+)
+
+// headers is a flag holding record headers as name=value pairs, which may be repeated.
+type headers map[string]string
+
+func (h headers) String() string {
+	var pairs []string
+	for name, value := range h {
+		pairs = append(pairs, name+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (h headers) Set(value string) error {
+	name, value, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("header %q isn't a name=value pair", value)
+	}
+	h[name] = value
+	return nil
+}
+
+// runProduce produces the lines of stdin or a file as records, in batches, and reports the range
+// of offsets they got.
+func runProduce(ctx context.Context, args []string) error {
+	var (
+		conn       connFlags
+		file       string
+		key        string
+		whole      bool
+		replicated bool
+		hdrs       = make(headers)
+	)
+	fs := newFlagSet("produce", "\n\nProduces the lines read from stdin, or -file, as records.", &conn)
+	fs.StringVar(&file, "file", "", "Path to the file to produce the lines of, instead of stdin.")
+	fs.StringVar(&key, "key", "", "Key of the records.")
+	fs.Var(hdrs, "header", "Header of the records, as name=value; may be repeated.")
+	fs.BoolVar(&whole, "whole", false, "Produce the whole input as a single record, instead of a record per line.")
+	fs.BoolVar(&replicated, "acks-replicated", false, "Wait for a quorum of the cluster to store the records.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	in := io.Reader(os.Stdin)
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	logClient, err := conn.client()
+	if err != nil {
+		return err
+	}
+	config := client.ProducerConfig{}
+	if replicated {
+		config.Acks = api.Acks_ACKS_REPLICATED
+	}
+	producer := client.NewProducer(logClient, config)
+
+	var (
+		mu          sync.Mutex
+		first, last uint64
+		produced    int
+		errs        []error
+	)
+	callback := func(offset uint64, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if produced == 0 {
+			first = offset
+		}
+		last = offset
+		produced++
+	}
+	record := func(value []byte) *api.Record {
+		record := &api.Record{Value: value, Headers: hdrs}
+		if key != "" {
+			record.Key = []byte(key)
+		}
+		return record
+	}
+	if whole {
+		value, err := io.ReadAll(in)
+		if err != nil {
+			producer.Close()
+			return err
+		}
+		err = producer.Produce(ctx, record(value), callback)
+	} else {
+		err = produceLines(ctx, producer, in, record, callback)
+	}
+	// Closing waits for the records buffered to be produced
+	producer.Close()
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d records failed, first with: %w", len(errs), errs[0])
+	}
+	if produced > 0 {
+		fmt.Fprintf(os.Stderr, "produced %d records at offsets %d-%d\n", produced, first, last)
+	}
+	return nil
+}
+
+// produceLines produces each line read from in as a record, without its line ending.
+func produceLines(
+	ctx context.Context,
+	producer *client.Producer,
+	in io.Reader,
+	record func([]byte) *api.Record,
+	callback func(uint64, error),
+) error {
+	r := bufio.NewReader(in)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+			if perr := producer.Produce(ctx, record(line), callback); perr != nil {
+				return perr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
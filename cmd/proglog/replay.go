@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/pkg/client"
+	"golang.org/x/time/rate"
+	"google.golang.org/protobuf/encoding/protodelim"
+)
+
+// replayRecord is a record read from a replayed file, with when it was originally appended, if
+// the file tells.
+type replayRecord struct {
+	record *api.Record
+	time   time.Time
+}
+
+// replayReader reads the records of a replayed file, failing with io.EOF at its end.
+type replayReader func() (*replayRecord, error)
+
+// runReplay produces the records of a JSONL, CSV or protobuf file at a controlled rate, to seed
+// environments or reproduce production traffic.
+func runReplay(ctx context.Context, args []string) error {
+	var (
+		conn       connFlags
+		file       string
+		format     string
+		rateFlag   string
+		replicated bool
+	)
+	fs := newFlagSet("replay", "\n\nProduces the records of -file at -rate. Formats:\n"+
+		"  jsonl  an object per line: {\"value\": ..., \"key\": \"...\", \"headers\": {...}, \"timestamp\": \"...\"};\n"+
+		"         string values are produced as is, and other JSON values as their JSON\n"+
+		"  csv    a header row naming the columns, then a record per row: its value, key and\n"+
+		"         timestamp columns, and any other column as a header of the column's name\n"+
+		"  proto  api.Record messages, each prefixed with its size as a varint; the append_time\n"+
+		"         is the timestamp\n"+
+		"Timestamps are RFC 3339 and optional, used by -rate original only.", &conn)
+	fs.StringVar(&file, "file", "-", "Path to the file to replay, gzipped if named *.gz, or - for stdin.")
+	fs.StringVar(&format, "format", "", "Format of the file: jsonl, csv or proto; guessed from -file's extension if empty.")
+	fs.StringVar(&rateFlag, "rate", "", "Records to produce per unit of time, e.g. 1000/s, 50/100ms or 3000/m; original to keep\n"+
+		"the intervals between the records' timestamps; empty produces them as fast as possible.")
+	fs.BoolVar(&replicated, "acks-replicated", false, "Wait for a quorum of the cluster to store the records.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if format == "" {
+		format = guessReplayFormat(file)
+		if format == "" {
+			return fmt.Errorf("can't tell the format of %s, set -format", file)
+		}
+	}
+	limiter, original, err := parseReplayRate(rateFlag)
+	if err != nil {
+		return err
+	}
+
+	in, err := openReplayFile(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var next replayReader
+	switch format {
+	case "jsonl":
+		next = jsonlReader(in)
+	case "csv":
+		next, err = csvReader(in)
+	case "proto":
+		next = protoReader(in)
+	default:
+		err = fmt.Errorf("-format must be jsonl, csv or proto, not %q", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	logClient, err := conn.client()
+	if err != nil {
+		return err
+	}
+	config := client.ProducerConfig{}
+	if replicated {
+		config.Acks = api.Acks_ACKS_REPLICATED
+	}
+	producer := client.NewProducer(logClient, config)
+
+	var (
+		mu          sync.Mutex
+		first, last uint64
+		produced    int
+		errs        []error
+	)
+	callback := func(offset uint64, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if produced == 0 {
+			first = offset
+		}
+		last = offset
+		produced++
+	}
+	start := time.Now()
+	err = replay(ctx, producer, next, limiter, original, callback)
+	// Closing waits for the records buffered to be produced
+	producer.Close()
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d records failed, first with: %w", len(errs), errs[0])
+	}
+	if produced > 0 {
+		elapsed := time.Since(start)
+		fmt.Fprintf(os.Stderr, "produced %d records at offsets %d-%d in %s (%.0f records/s)\n",
+			produced, first, last, elapsed.Round(time.Millisecond), float64(produced)/elapsed.Seconds())
+	}
+	return nil
+}
+
+// replay produces the records read, waiting for the limiter if any, or for the intervals between
+// the records' timestamps if replaying the original timing. Records without timestamps are
+// produced right after the previous ones.
+func replay(
+	ctx context.Context,
+	producer *client.Producer,
+	next replayReader,
+	limiter *rate.Limiter,
+	original bool,
+	callback func(uint64, error),
+) error {
+	var start, firstTime time.Time // When the first record with a timestamp was produced, and its timestamp
+	for n := 1; ; n++ {
+		r, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("record %d: %w", n, err)
+		}
+		switch {
+		case limiter != nil:
+			if err := limiter.Wait(ctx); err != nil {
+				return err
+			}
+		case original && !r.time.IsZero() && start.IsZero():
+			start, firstTime = time.Now(), r.time
+		case original && !r.time.IsZero():
+			if err := sleep(ctx, time.Until(start.Add(r.time.Sub(firstTime)))); err != nil {
+				return err
+			}
+		}
+		if err := producer.Produce(ctx, r.record, callback); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep waits for the duration, unless the context is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// parseReplayRate parses -rate: a limiter producing records at the rate, e.g. 1000/s or 50/100ms,
+// or whether to keep the original timing. Both are unset if the rate is empty.
+func parseReplayRate(s string) (limiter *rate.Limiter, original bool, err error) {
+	switch s {
+	case "":
+		return nil, false, nil
+	case "original":
+		return nil, true, nil
+	}
+	count, unit, ok := strings.Cut(s, "/")
+	if !ok {
+		unit = "s"
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n <= 0 {
+		return nil, false, fmt.Errorf("-rate %q must count a positive number of records, e.g. 1000/s", s)
+	}
+	// Units are durations, whose count may be left out, e.g. s for 1s
+	if unit != "" && (unit[0] < '0' || unit[0] > '9') {
+		unit = "1" + unit
+	}
+	per, err := time.ParseDuration(unit)
+	if err != nil || per <= 0 {
+		return nil, false, fmt.Errorf("-rate %q must be per a positive unit of time, e.g. 1000/s", s)
+	}
+	// Records are spread evenly, rather than sent in bursts
+	return rate.NewLimiter(rate.Limit(n/per.Seconds()), 1), false, nil
+}
+
+// guessReplayFormat returns the format of the file named path by its extension, ignoring .gz, or
+// an empty string if it doesn't tell.
+func guessReplayFormat(path string) string {
+	switch filepath.Ext(strings.TrimSuffix(path, ".gz")) {
+	case ".jsonl", ".ndjson", ".json":
+		return "jsonl"
+	case ".csv":
+		return "csv"
+	case ".pb", ".binpb", ".proto", ".protobuf":
+		return "proto"
+	}
+	return ""
+}
+
+// replayFile is a replayed file, uncompressed if gzipped.
+type replayFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// openReplayFile opens the file at the path, or stdin if -.
+func openReplayFile(path string) (*replayFile, error) {
+	f := &replayFile{Reader: os.Stdin}
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		f.Reader, f.closers = file, []io.Closer{file}
+	}
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f.Reader)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.Reader, f.closers = gz, append([]io.Closer{gz}, f.closers...)
+	}
+	return f, nil
+}
+
+// Close closes the file.
+func (f *replayFile) Close() error {
+	var err error
+	for _, c := range f.closers {
+		err = errors.Join(err, c.Close())
+	}
+	return err
+}
+
+// jsonlRecord is a record of a JSONL file.
+type jsonlRecord struct {
+	Value     json.RawMessage   `json:"value"`
+	Key       *string           `json:"key"`
+	Headers   map[string]string `json:"headers"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// jsonlReader reads records from a JSON object per line, skipping blank lines.
+func jsonlReader(in io.Reader) replayReader {
+	scanner := bufio.NewScanner(in)
+	// Lines hold records, which may be much longer than the default limit
+	scanner.Buffer(nil, 64<<20)
+	return func() (*replayRecord, error) {
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var j jsonlRecord
+			if err := json.Unmarshal(line, &j); err != nil {
+				return nil, err
+			}
+			if j.Value == nil {
+				return nil, errors.New("missing value")
+			}
+			record := &api.Record{Value: []byte(j.Value), Headers: j.Headers}
+			// Strings are produced as is, rather than quoted
+			var value string
+			if json.Unmarshal(j.Value, &value) == nil {
+				record.Value = []byte(value)
+			}
+			if j.Key != nil {
+				record.Key = []byte(*j.Key)
+			}
+			return &replayRecord{record: record, time: j.Timestamp}, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+}
+
+// csvReader reads records from a CSV file, whose header row names the columns: its value, key and
+// timestamp columns, and others holding headers of their names.
+func csvReader(in io.Reader) (replayReader, error) {
+	r := csv.NewReader(in)
+	r.ReuseRecord = true
+	columns, err := r.Read()
+	if err == io.EOF {
+		return func() (*replayRecord, error) { return nil, io.EOF }, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns = append([]string(nil), columns...)
+	value := -1
+	for i, column := range columns {
+		if column == "value" {
+			value = i
+		}
+	}
+	if value < 0 {
+		return nil, errors.New("csv has no value column")
+	}
+	return func() (*replayRecord, error) {
+		row, err := r.Read()
+		if err != nil {
+			return nil, err
+		}
+		replayed := &replayRecord{record: &api.Record{}}
+		for i, column := range columns {
+			switch field := row[i]; column {
+			case "value":
+				replayed.record.Value = []byte(field)
+			case "key":
+				if field != "" {
+					replayed.record.Key = []byte(field)
+				}
+			case "timestamp":
+				if field == "" {
+					continue
+				}
+				if replayed.time, err = time.Parse(time.RFC3339Nano, field); err != nil {
+					return nil, err
+				}
+			default:
+				if replayed.record.Headers == nil {
+					replayed.record.Headers = make(map[string]string)
+				}
+				replayed.record.Headers[column] = field
+			}
+		}
+		return replayed, nil
+	}, nil
+}
+
+// protoReader reads size-delimited records, as protodelim writes them.
+func protoReader(in io.Reader) replayReader {
+	r := bufio.NewReader(in)
+	return func() (*replayRecord, error) {
+		record := &api.Record{}
+		if err := (protodelim.UnmarshalOptions{MaxSize: -1}).UnmarshalFrom(r, record); err != nil {
+			return nil, err
+		}
+		replayed := &replayRecord{record: record}
+		if record.AppendTime != nil {
+			replayed.time = record.AppendTime.AsTime()
+		}
+		// Records get their offsets, append times and epochs from the server
+		record.Offset, record.AppendTime, record.LeaderEpoch = 0, nil, 0
+		return replayed, nil
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// scopes is a flag.Value of token scopes, each written object:action, set by repeating the flag.
+type scopes []*api.TokenScope
+
+// String returns the scopes, separated by spaces.
+func (s *scopes) String() string {
+	out := make([]string, 0, len(*s))
+	for _, scope := range *s {
+		out = append(out, scope.Object+":"+scope.Action)
+	}
+	return strings.Join(out, " ")
+}
+
+// Set parses a scope and appends it to the scopes.
+func (s *scopes) Set(value string) error {
+	object, action, ok := strings.Cut(value, ":")
+	if !ok || object == "" || action == "" {
+		return fmt.Errorf("scope %q must be written object:action, e.g. payments:consume", value)
+	}
+	*s = append(*s, &api.TokenScope{Object: object, Action: action})
+	return nil
+}
+
+// runToken mints a scoped token through the server's Admin service, printing it so it can be
+// handed to a temporary client, e.g. saved to the file of its -token-file.
+func runToken(ctx context.Context, args []string) error {
+	var (
+		conn    connFlags
+		scopes  scopes
+		ttl     time.Duration
+		subject string
+	)
+	fs := newFlagSet("token", "\n\nMints a short-lived token authenticating as the client, or as -subject, but only permitted\n"+
+		"the actions of its scopes, and prints it once; e.g. proglog token -scope payments:consume -ttl 2h", &conn)
+	fs.Var(&scopes, "scope", "Action the token is permitted on an object, written object:action, e.g. payments:consume; * stands for any.\n"+
+		"Repeat it to permit several; at least one is required.")
+	fs.DurationVar(&ttl, "ttl", time.Hour, "How long the token is valid; the servers cap it.")
+	fs.StringVar(&subject, "subject", "", "Subject the token authenticates as, if not the client; requires administering the cluster.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(scopes) == 0 {
+		fs.Usage()
+		return errors.New("-scope is required")
+	}
+	cc, err := conn.dial()
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+	res, err := api.NewAdminClient(cc).CreateToken(ctx, &api.CreateTokenRequest{
+		Scopes:  scopes,
+		Ttl:     durationpb.New(ttl),
+		Subject: subject,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "minted token %s, expiring at %s; it isn't shown again\n", res.Id, res.Expires.AsTime().Format(time.RFC3339))
+	fmt.Println(res.Token)
+	return nil
+}
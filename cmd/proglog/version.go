@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/version"
+)
+
+// runVersion prints the build of the CLI and of the server, or only the CLI's with -client.
+func runVersion(ctx context.Context, args []string) error {
+	var (
+		conn       connFlags
+		clientOnly bool
+	)
+	fs := newFlagSet("version", "\n\nPrints the version, commit and build date of the CLI and of the server. GetServers\n"+
+		"lists the versions of every server of the cluster, e.g. during rolling upgrades.", &conn)
+	fs.BoolVar(&clientOnly, "client", false, "Print the CLI's build only, without connecting to the server.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	fmt.Printf("client: %s\n", version.String(version.Get()))
+	if clientOnly {
+		return nil
+	}
+	logClient, err := conn.client()
+	if err != nil {
+		return err
+	}
+	res, err := logClient.GetVersion(ctx, &api.GetVersionRequest{})
+	if err != nil {
+		return fmt.Errorf("get the server's version: %w", err)
+	}
+	fmt.Printf("server: %s\n", version.String(res.Build))
+	return nil
+}
@@ -1,14 +1,311 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/glauco/proglog/internal/audit"
+	"github.com/glauco/proglog/internal/auth"
+	"github.com/glauco/proglog/internal/config"
+	prolog "github.com/glauco/proglog/internal/log"
 	"github.com/glauco/proglog/internal/server"
+	"github.com/glauco/proglog/internal/systemd"
+	"github.com/glauco/proglog/internal/version"
 )
 
+// envPrefix prefixes the environment variables setting the flags, e.g. PROGLOG_DATA_DIR.
+const envPrefix = "PROGLOG"
+
+// serverConfig is the server's configuration, set by its flags.
+type serverConfig struct {
+	addr            string
+	dataDir         string
+	tls             config.TLSFlags
+	aclModelFile    string
+	aclPolicyFile   string
+	apiKeyFile      string
+	opaConfig       auth.OPAConfig
+	opaTokenFile    string
+	subjectRules    server.SubjectRules
+	auditSink       string
+	allowedOrigins  string
+	shutdownTimeout time.Duration
+}
+
+// parseConfig parses the configuration from the arguments, the environment and the config file,
+// and validates it, failing on missing or incomplete files now, naming the flags, rather than
+// once first used. It returns the flag set it parsed, so its values can be printed.
+func parseConfig(args []string, errorHandling flag.ErrorHandling) (*serverConfig, *flag.FlagSet, config.Sources, error) {
+	c := &serverConfig{}
+	fs := flag.NewFlagSet(os.Args[0], errorHandling)
+	fs.StringVar(&c.addr, "addr", ":9090", "Address the HTTP server listens on.")
+	fs.StringVar(&c.dataDir, "data-dir", "", "Directory the log is stored in; records are kept in memory, and lost on exit, when empty.")
+	// Without a certificate, the server serves plain HTTP; with a CA, clients' certificates are required
+	c.tls.Register(fs, envPrefix, "", "server's")
+	fs.StringVar(&c.aclModelFile, "acl-model-file", "", "Path to the ACL model authorizing clients by their certificates; requests aren't authorized when empty. SIGHUP reloads it.")
+	fs.StringVar(&c.aclPolicyFile, "acl-policy-file", "", "Path to the ACL policy; SIGHUP reloads it.")
+	fs.StringVar(&c.apiKeyFile, "api-key-file", "", "Path to the store of hashed API keys authenticating clients by their X-API-Key header, which proglog apikey issues keys into;\n"+
+		"re-read when changed, and created once a key is issued. Disabled when empty.")
+	fs.StringVar(&c.opaConfig.URL, "opa-url", "", "URL of the Open Policy Agent rule authorizing requests instead of the ACL files, e.g. http://127.0.0.1:8181/v1/data/proglog/authz;\n"+
+		"OPA may run as a sidecar loading Rego files, e.g. opa run --server policy.rego. Requests aren't authorized when it and -acl-model-file are empty.")
+	fs.StringVar(&c.opaTokenFile, "opa-token-file", "", "Path to the bearer token authenticating the queries to OPA.")
+	fs.DurationVar(&c.opaConfig.CacheTTL, "opa-cache-ttl", 0, "How long OPA's decisions are cached; every request queries OPA when 0.")
+	fs.Var(&c.subjectRules, "subject-rule", "Rule extracting the subject the ACL authorizes clients as from their certificates, instead of the CommonName: cn, dns, uri or email,\n"+
+		"optionally followed by a colon and a regexp the name must match, whose first group is the subject, e.g. uri:^spiffe://example\\.org/sa/(.+)$.\n"+
+		"Repeat it to try several rules in order; clients no rule matches aren't authenticated.")
+	fs.StringVar(&c.auditSink, "audit-sink", "", "Where every authorization decision is audited: the path of a file to append JSON lines to,\n"+
+		"log:<dir> for a commit log in the directory, slog for the server's log, or an http:// or https:// URL to post batches of events to;\n"+
+		"disabled when empty.")
+	fs.StringVar(&c.allowedOrigins, "allowed-origins", "", "Comma-separated origins of the web pages allowed to open WebSockets on /ws besides the server's own,\n"+
+		"e.g. https://app.example.com, or * for any; browsers send them the user's cookies and certificates.")
+	fs.DurationVar(&c.shutdownTimeout, "shutdown-timeout", 30*time.Second, "How long SIGTERM and SIGINT wait for the requests being served to complete before closing their connections.")
+	fs.String("config-file", "", "Path to a YAML, or TOML if named *.toml, file setting flags not given on the command line, keyed by their names.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags]\n\n"+
+			"Flags not given on the command line are read from PROGLOG_<FLAG> environment variables,\n"+
+			"e.g. PROGLOG_DATA_DIR, then from -config-file.\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	sources, err := config.ParseFlags(fs, args, envPrefix, "config-file")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var errs []error
+	errs = append(errs, c.tls.Validate())
+	if (c.aclModelFile == "") != (c.aclPolicyFile == "") {
+		errs = append(errs, errors.New("-acl-model-file and -acl-policy-file must be set together"))
+	}
+	if c.tls.HasCA() && !c.tls.HasCert() {
+		errs = append(errs, errors.New("-tls-ca-file requires -tls-cert-file, as clients are verified over TLS"))
+	}
+	if c.aclModelFile != "" && c.opaConfig.URL != "" {
+		errs = append(errs, errors.New("-acl-model-file and -opa-url are exclusive, as either authorizes requests"))
+	}
+	if c.opaConfig.URL == "" && c.opaTokenFile != "" {
+		errs = append(errs, errors.New("-opa-token-file requires -opa-url"))
+	}
+	authorized := c.aclModelFile != "" || c.opaConfig.URL != ""
+	if authorized && !c.tls.HasCA() && c.apiKeyFile == "" {
+		errs = append(errs, errors.New("-acl-model-file and -opa-url require -tls-ca-file or -api-key-file, as clients are authorized by their certificates or API keys"))
+	}
+	if c.apiKeyFile != "" && (!authorized || !c.tls.HasCert()) {
+		errs = append(errs, errors.New("-api-key-file requires -acl-model-file or -opa-url, and -tls-cert-file, so keys are authorized and aren't sent in the clear"))
+	}
+	if c.auditSink != "" && !authorized {
+		errs = append(errs, errors.New("-audit-sink requires -acl-model-file or -opa-url, as only authorized requests are audited"))
+	}
+	errs = append(errs, config.CheckFiles(fs, sources,
+		append(c.tls.Files(), "acl-model-file", "acl-policy-file", "opa-token-file")...))
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return c, fs, sources, nil
+}
+
 func main() {
-	// Initialize a new HTTP server instance listening on port 9090
-	srv := server.NewHttpServer(":9090")
-	// Start the server and log any fatal errors if the server fails to start or crashes
-	log.Fatal(srv.ListenAndServe())
+	cfg, fs, sources, err := parseConfig(os.Args[1:], flag.ExitOnError)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("version %s", version.String(version.Get()))
+	log.Println("effective configuration:")
+	config.PrintFlags(log.Writer(), fs, sources)
+
+	var opts []server.HTTPOption
+	var clog *prolog.Log
+	if cfg.dataDir != "" {
+		// Store the records in a log on disk, so they survive restarts
+		if err := os.MkdirAll(cfg.dataDir, 0755); err != nil {
+			log.Fatal(err)
+		}
+		clog, err = prolog.NewLog(cfg.dataDir, prolog.Config{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, server.WithHTTPLog(server.NewCommitRecordLog(clog)))
+	}
+	tlsConfig, err := cfg.tls.Setup(true, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if tlsConfig != nil {
+		opts = append(opts, server.WithHTTPTLS(tlsConfig))
+	}
+	// Only the ACL files are reloaded by SIGHUP; OPA's policies change in OPA
+	var acl *auth.Authorizer
+	var authorizer server.Authorizer
+	switch {
+	case cfg.aclModelFile != "":
+		acl = auth.New(cfg.aclModelFile, cfg.aclPolicyFile)
+		if err := acl.Err(); err != nil {
+			log.Fatal(err)
+		}
+		authorizer = acl
+	case cfg.opaConfig.URL != "":
+		if cfg.opaTokenFile != "" {
+			token, err := os.ReadFile(cfg.opaTokenFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			cfg.opaConfig.Token = strings.TrimSpace(string(token))
+		}
+		if authorizer, err = auth.NewOPAAuthorizer(cfg.opaConfig); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var auditor *audit.Auditor
+	if authorizer != nil {
+		httpAuth := &server.HTTPAuth{Authorizer: authorizer, SubjectRules: cfg.subjectRules}
+		if cfg.apiKeyFile != "" {
+			keys, err := auth.OpenAPIKeyStore(cfg.apiKeyFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			httpAuth.Keys = keys
+		}
+		if cfg.auditSink != "" {
+			sink, err := audit.Open(cfg.auditSink, slog.Default())
+			if err != nil {
+				log.Fatal(err)
+			}
+			auditor = audit.New(sink, audit.Config{})
+			httpAuth.Auditor = auditor
+		}
+		opts = append(opts, server.WithMiddleware(httpAuth.Middleware))
+	}
+
+	for _, origin := range strings.Split(cfg.allowedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			opts = append(opts, server.WithAllowedOrigins(origin))
+		}
+	}
+
+	// Initialize a new HTTP server instance listening on the address
+	srv, err := server.NewHttpServer(&server.HTTPConfig{Addr: cfg.addr}, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Shutting down doesn't wait for hijacked connections, e.g. WebSockets, so the requests are
+	// counted until their handlers return, as they may use the log
+	var requests sync.WaitGroup
+	handler := srv.Handler
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		defer requests.Done()
+		handler.ServeHTTP(w, r)
+	})
+
+	// Serve on the socket systemd opened, if socket activated, or listen on the address
+	ln, err := systemd.Listener()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ln != nil {
+		log.Printf("serving on the socket-activated %s, ignoring -addr", ln.Addr())
+	} else if ln, err = net.Listen("tcp", srv.Addr); err != nil {
+		log.Fatal(err)
+	}
+
+	// Serve until told to stop, or serving fails
+	stopping := make(chan os.Signal, 1)
+	signal.Notify(stopping, os.Interrupt, syscall.SIGTERM)
+	reloading := make(chan os.Signal, 1)
+	signal.Notify(reloading, syscall.SIGHUP)
+	served := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			// The certificate is in the TLS config already
+			served <- srv.ServeTLS(ln, "", "")
+			return
+		}
+		served <- srv.Serve(ln)
+	}()
+	// The listener accepts connections already, so clients can connect once systemd is told
+	systemd.NotifyOrLog(systemd.Ready)
+	code := 0
+serving:
+	for {
+		select {
+		case err := <-served:
+			log.Print(err)
+			code = 1
+			break serving
+		case <-reloading:
+			systemd.NotifyOrLog(systemd.Reloading)
+			reload(cfg, fs, acl)
+			systemd.NotifyOrLog(systemd.Ready)
+		case sig := <-stopping:
+			log.Printf("received %s, shutting down", sig)
+			systemd.NotifyOrLog(systemd.Stopping)
+			signal.Stop(stopping) // A second signal kills the server
+			shutdown(srv, &requests, cfg.shutdownTimeout)
+			break serving
+		}
+	}
+
+	// Write the decisions audited last, and close the log once no request uses it, so its
+	// buffered writes are flushed to disk
+	if auditor != nil {
+		if err := auditor.Close(); err != nil {
+			log.Printf("close the audit sink: %v", err)
+			code = 1
+		}
+	}
+	if clog != nil {
+		if err := clog.Close(); err != nil {
+			log.Printf("close the log: %v", err)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// shutdown stops accepting requests and waits for those being served to complete, up to the
+// timeout, then closes the connections left and waits for their handlers to return.
+func shutdown(srv *http.Server, requests *sync.WaitGroup, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("requests still served after %s, closing their connections", timeout)
+		srv.Close()
+	}
+	// The WebSockets are closed as the server shuts down, so their handlers return shortly
+	requests.Wait()
+}
+
+// reload reloads the ACL files, as SIGHUP tells, so operators can change the policy without
+// restarting the server. The configuration is parsed again, reporting the settings that
+// changed, which take a restart to apply.
+func reload(cfg *serverConfig, fs *flag.FlagSet, authorizer *auth.Authorizer) {
+	log.Print("received hangup, reloading")
+	_, reloaded, _, err := parseConfig(os.Args[1:], flag.ContinueOnError)
+	if err != nil {
+		log.Printf("reload the configuration: %v", err)
+	} else {
+		fs.VisitAll(func(f *flag.Flag) {
+			if value := reloaded.Lookup(f.Name).Value.String(); value != f.Value.String() {
+				log.Printf("-%s changed to %q; restart the server to apply it", f.Name, value)
+			}
+		})
+	}
+	if authorizer == nil {
+		return
+	}
+	if err := authorizer.Reload(); err != nil {
+		log.Printf("reload %s and %s, keeping the previous ACL: %v", cfg.aclModelFile, cfg.aclPolicyFile, err)
+		return
+	}
+	log.Printf("reloaded the ACL from %s and %s", cfg.aclModelFile, cfg.aclPolicyFile)
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	apiv2 "github.com/glauco/proglog/api/v2"
+	"github.com/glauco/proglog/internal/config"
+	"github.com/glauco/proglog/internal/syslog"
+	"github.com/glauco/proglog/internal/systemd"
+	"github.com/glauco/proglog/internal/version"
+	"github.com/glauco/proglog/pkg/loadbalance"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// envPrefix prefixes the environment variables setting the flags, e.g. PROGLOG_CLUSTER_ADDR.
+const envPrefix = "PROGLOG"
+
+func main() {
+	var (
+		srv         syslog.Server
+		sink        syslog.Sink
+		tcpAddr     string
+		udpAddr     string
+		tlsAddr     string
+		clusterAddr string
+		senderTLS   config.TLSFlags
+		clusterTLS  config.TLSFlags
+	)
+	flag.String("config-file", "", "Path to a YAML, or TOML if named *.toml, file setting flags not given on the command line, keyed by their names.")
+	flag.StringVar(&tcpAddr, "tcp-addr", ":514", "Address syslog is received on over TCP; disabled when empty.")
+	flag.StringVar(&udpAddr, "udp-addr", ":514", "Address syslog is received on over UDP; disabled when empty.")
+	flag.StringVar(&tlsAddr, "tls-addr", ":6514", "Address syslog is received on over TLS, once -tls-cert-file or -tls-cert-secret is set; disabled when empty.")
+	flag.StringVar(&clusterAddr, "cluster-addr", "", "RPC address of a server of the cluster the messages are appended to.")
+	flag.StringVar(&sink.Topic, "topic", "", "Topic the messages are appended to; the default topic when empty.")
+	flag.Func("partitions", "Number of the topic's partitions the messages are spread across by hostname (default 1).", func(s string) error {
+		_, err := fmt.Sscan(s, &sink.Partitions)
+		return err
+	})
+	flag.IntVar(&srv.MaxMessageSize, "max-message-size", syslog.DefaultMaxMessageSize, "Largest message senders may send, in bytes.")
+	senderTLS.Register(flag.CommandLine, envPrefix, "", "sink's, served to senders over TLS,")
+	clusterTLS.Register(flag.CommandLine, envPrefix, "cluster", "cluster client's")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n\n"+
+			"Flags not given on the command line are read from PROGLOG_<FLAG> environment variables,\n"+
+			"e.g. PROGLOG_CLUSTER_ADDR, then from -config-file.\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	sources, err := config.ParseFlags(flag.CommandLine, os.Args[1:], envPrefix, "config-file")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Fail on missing or incomplete files now, naming the flags, rather than once first used
+	var errs []error
+	if clusterAddr == "" {
+		errs = append(errs, errors.New("-cluster-addr is required"))
+	}
+	if !senderTLS.HasCert() {
+		tlsAddr = ""
+	}
+	if tcpAddr == "" && udpAddr == "" && tlsAddr == "" {
+		errs = append(errs, errors.New("-tcp-addr, -udp-addr or -tls-addr with -tls-cert-file is required"))
+	}
+	if senderTLS.HasCA() && !senderTLS.HasCert() {
+		errs = append(errs, errors.New("-tls-ca-file requires -tls-cert-file, as senders are verified over TLS"))
+	}
+	if err := errors.Join(append(errs,
+		senderTLS.Validate(),
+		clusterTLS.Validate(),
+		config.CheckFiles(flag.CommandLine, sources, append(senderTLS.Files(), clusterTLS.Files()...)...),
+	)...); err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+	log.Printf("version %s", version.String(version.Get()))
+	log.Println("effective configuration:")
+	config.PrintFlags(log.Writer(), flag.CommandLine, sources)
+
+	// Produce through the leader, following it as it changes
+	creds := insecure.NewCredentials()
+	host, _, err := net.SplitHostPort(clusterAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	clusterTLSConfig, err := clusterTLS.Setup(false, host)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if clusterTLSConfig != nil {
+		creds = credentials.NewTLS(clusterTLSConfig)
+	}
+	cc, err := grpc.NewClient(fmt.Sprintf("%s:///%s", loadbalance.Name, clusterAddr), grpc.WithTransportCredentials(creds))
+	if err != nil {
+		log.Fatal(err)
+	}
+	sink.Client = apiv2.NewLogClient(cc)
+	srv.Handler = sink.Handle
+	srv.Logger = slog.Default()
+
+	// Listen on every address before serving, so the sink either receives on all or fails
+	served := make(chan error, 3)
+	var serves []func()
+	if tcpAddr != "" {
+		l, err := net.Listen("tcp", tcpAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		serves = append(serves, func() { served <- srv.ServeStream(l) })
+	}
+	if tlsAddr != "" {
+		tlsConfig, err := senderTLS.Setup(true, "")
+		if err != nil {
+			log.Fatal(err)
+		}
+		l, err := tls.Listen("tcp", tlsAddr, tlsConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		serves = append(serves, func() { served <- srv.ServeStream(l) })
+	}
+	if udpAddr != "" {
+		pc, err := net.ListenPacket("udp", udpAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		serves = append(serves, func() { served <- srv.ServePacket(pc) })
+	}
+	for _, serve := range serves {
+		go serve()
+	}
+
+	// Serve until told to stop, or serving fails
+	systemd.NotifyOrLog(systemd.Ready)
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	code := 0
+	select {
+	case err := <-served:
+		log.Print(err)
+		code = 1
+	case sig := <-sigc:
+		log.Printf("received %s, shutting down", sig)
+	}
+	// The messages being appended fail: they're lost over UDP, and sent again by TCP senders that
+	// retry once reconnected
+	systemd.NotifyOrLog(systemd.Stopping)
+	if err := srv.Close(); err != nil {
+		log.Print(err)
+	}
+	cc.Close()
+	os.Exit(code)
+}
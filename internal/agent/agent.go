@@ -0,0 +1,546 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/glauco/proglog/internal/auth"
+	"github.com/glauco/proglog/internal/discovery"
+	"github.com/glauco/proglog/internal/log"
+	"github.com/glauco/proglog/internal/server"
+	"github.com/glauco/proglog/internal/version"
+	"github.com/hashicorp/raft"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// shutdownTimeout bounds how long shutting down waits for in-flight requests to finish.
+const shutdownTimeout = 5 * time.Second
+
+// Config configures an Agent, which runs every component of a node of the cluster.
+type Config struct {
+	ServerTLSConfig *tls.Config // ServerTLSConfig secures the gRPC server and the Raft connections it accepts.
+	PeerTLSConfig   *tls.Config // PeerTLSConfig secures the Raft connections to the other nodes.
+	DataDir         string      // DataDir holds the log's records and Raft's state.
+	BindAddr        string      // BindAddr is the address Serf gossips on, e.g. "127.0.0.1:8401".
+	RPCPort         int         // RPCPort is the port gRPC, HTTP and Raft share, on BindAddr's host.
+	NodeName        string      // NodeName uniquely identifies the node in the cluster.
+	// RPCListener serves gRPC, HTTP and Raft instead of listening on RPCPort, e.g. a socket
+	// systemd opened for the agent. Its address must still be BindAddr's host and RPCPort, as
+	// the other nodes are told to reach the node there. The agent closes it with the node.
+	RPCListener net.Listener
+	// Discovery finds the other nodes of the cluster, e.g. a discovery.Static listing them, or
+	// one watching a service registry. It defaults to gossiping with Serf, which the settings
+	// below configure; they're ignored when it's set, as are GossipProfile, GossipKeys and
+	// FailedNodeTimeout. The agent starts it, and leaves or shuts it down with the node.
+	Discovery discovery.Discovery
+	// StartJoinAddrs are the Serf addresses of existing nodes to join the cluster through.
+	StartJoinAddrs []string
+	// JoinRetryTimeout is how long starting keeps retrying to join the cluster through the
+	// StartJoinAddrs while none can be joined, e.g. as they're being started alongside the node;
+	// 0 tries once.
+	JoinRetryTimeout time.Duration
+	// JoinDNS is a DNS name resolving to the Serf addresses of nodes to join the cluster through,
+	// e.g. a Kubernetes headless service: "host:port" is looked up as A and AAAA records, and a
+	// name without a port as an SRV record. It's re-resolved every JoinDNSInterval, 30 seconds by
+	// default, so nodes join as they appear in the DNS.
+	JoinDNS         string
+	JoinDNSInterval time.Duration
+	// Datacenter and Rack locate the node, e.g. its region and availability zone. Partitions'
+	// replicas are spread across racks, so losing a rack doesn't lose every replica of a
+	// partition. Empty when unknown.
+	Datacenter string
+	Rack       string
+	// GossipProfile tunes Serf's failure detection for the network between the nodes:
+	// discovery.ProfileLAN, the default, or discovery.ProfileWAN when they span datacenters.
+	GossipProfile string
+	// GossipKeys encrypt the Serf gossip between the nodes, e.g. loaded from
+	// config.GossipKeyFile with config.LoadGossipKeyring; the first key encrypts and every key
+	// decrypts. Every node of the cluster must share a key. The gossip is plaintext when there
+	// are none, while the Raft connections are secured by the server and peer TLS configs.
+	GossipKeys [][]byte
+	// FailedNodeTimeout is how long a node may be failed before it's removed from the cluster;
+	// 0 keeps the default of 30 minutes. Nodes that Leave are removed right away.
+	FailedNodeTimeout time.Duration
+	ACLModelFile      string // ACLModelFile is the Casbin model the Authorizer enforces.
+	ACLPolicyFile     string // ACLPolicyFile is the Casbin policy the Authorizer enforces.
+	// Authorizer, if set, authorizes the gRPC and HTTP requests instead of the Casbin ACL files,
+	// e.g. an auth.OPAAuthorizer. The ACL rules of the cluster's configuration then don't apply.
+	Authorizer server.Authorizer
+	// Insecure accepts gRPC clients without certificates, e.g. over plaintext connections when
+	// ServerTLSConfig isn't set, as server.AnonymousSubject, for local development. Without
+	// ACL files, every client may do anything, over gRPC and HTTP alike.
+	Insecure bool
+	// Bootstrap makes the node form a new cluster, which the other nodes then join through
+	// their StartJoinAddrs. Only the first node of a cluster should bootstrap it, and only the
+	// first time it starts: nodes whose DataDir already holds cluster state restart as members
+	// of that cluster, so keeping the setting across restarts is safe.
+	Bootstrap bool
+	// NonVoter makes the node join the cluster as a non-voter, which replicates the log to serve
+	// reads or take backups without voting or counting towards the quorum, so it doesn't slow
+	// down writes. It can be promoted to a voter later through the Admin service.
+	NonVoter bool
+	// BearerTokens and APIKeys map the credentials accepted by the HTTP server to their subjects,
+	// which are authorized with the same ACL as the gRPC server's clients.
+	BearerTokens map[string]string
+	APIKeys      map[string]string
+	// APIKeyValidator, if set, authenticates the HTTP clients by the API keys APIKeys doesn't
+	// hold, e.g. an auth.APIKeyStore.
+	APIKeyValidator server.TokenValidator
+	// TokenValidator, if set, authenticates the gRPC and HTTP clients without certificates by
+	// their bearer tokens, e.g. JWTs validated by an auth.JWTValidator. The gRPC server then
+	// accepts TLS clients without certificates, while the Raft connections still require them.
+	TokenValidator server.TokenValidator
+	// TokenIssuer, if set, mints the scoped tokens of the Admin service's CreateToken, e.g. an
+	// auth.TokenSigner with the cluster's signing keys, and authenticates the gRPC and HTTP
+	// clients by them, like TokenValidator. Every node must hold the keys of the tokens accepted.
+	TokenIssuer server.TokenIssuer
+	// SubjectRules, if set, authorize the gRPC and HTTP clients by the subjects the rules extract
+	// from their certificates' SANs, e.g. SPIFFE IDs, rather than by their CommonNames.
+	SubjectRules []server.SubjectRule
+	// Auditor, if set, records every authorization decision of the gRPC and HTTP servers, e.g.
+	// an audit.Auditor. The agent doesn't close it, as it may outlive the agent.
+	Auditor server.Auditor
+	// RebalanceInterval is how often the partitions' replicas are rebalanced across the nodes,
+	// e.g. as nodes join and leave; 0 defaults to a minute, and a negative interval only
+	// rebalances when triggered through the Admin service.
+	RebalanceInterval time.Duration
+	// MaxRebalanceMoves caps how many replicas a rebalance moves, throttling the data copied to
+	// catch the new replicas up; 0 defaults to 1.
+	MaxRebalanceMoves int
+	// Metrics registers the gRPC server's RPC metrics and, on the leader, the lag of every
+	// server's replica of the log, when set.
+	Metrics prometheus.Registerer
+	Logger  *slog.Logger // Logger receives the agent's logs; defaults to slog.Default().
+	// TracerProvider provides the tracer of the spans of the RPCs and of the log's appends and
+	// syncs they lead to; defaults to otel.GetTracerProvider().
+	TracerProvider trace.TracerProvider
+}
+
+// RPCAddr returns the address the node serves gRPC, HTTP and Raft on.
+func (c Config) RPCAddr() (string, error) {
+	host, _, err := net.SplitHostPort(c.BindAddr)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, fmt.Sprint(c.RPCPort)), nil
+}
+
+// Validate checks that the Config holds every required setting and fills in defaults for optional ones.
+func (c *Config) Validate() error {
+	if c.NodeName == "" {
+		return errors.New("agent config: node name is required")
+	}
+	if c.DataDir == "" {
+		return errors.New("agent config: data dir is required")
+	}
+	if _, err := c.RPCAddr(); err != nil {
+		return fmt.Errorf("agent config: invalid bind address %q: %w", c.BindAddr, err)
+	}
+	if c.Bootstrap && (len(c.StartJoinAddrs) > 0 || c.JoinDNS != "") {
+		// Bootstrapping while joining would form a second cluster alongside the one joined
+		return errors.New("agent config: a bootstrapping node forms a new cluster, so it can't join one through start join addresses or DNS")
+	}
+	if c.Bootstrap && c.NonVoter {
+		// The bootstrapping node must vote to elect itself the cluster's first leader
+		return errors.New("agent config: a bootstrapping node must be a voter")
+	}
+	if (c.ServerTLSConfig == nil) != (c.PeerTLSConfig == nil) {
+		// Raft connections are dialed with the peer TLS config and accepted with the server's,
+		// so a node securing only one side can't replicate with the others
+		return errors.New("agent config: server and peer TLS configs must be set together, so Raft connections are secured both ways")
+	}
+	if c.MaxRebalanceMoves < 0 {
+		return fmt.Errorf("agent config: max rebalance moves must not be negative, got %d", c.MaxRebalanceMoves)
+	}
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+	if c.TracerProvider == nil {
+		c.TracerProvider = otel.GetTracerProvider()
+	}
+	return nil
+}
+
+// Agent runs a node of the cluster: a log replicated with Raft, served over gRPC and HTTP on a
+// single port, and the membership discovering the other nodes, which join the Raft cluster as
+// they're discovered. Embedding a node takes creating an Agent, starting it and shutting it down.
+type Agent struct {
+	Config
+
+	mux        *server.Multiplexer
+	log        *log.DistributedLog
+	partitions *log.Partitions
+	server     *grpc.Server
+	httpServer *http.Server
+	membership discovery.Discovery
+	cluster    *cluster
+	events     *serverEvents
+	// stopObservingLeader stops passing the leadership changes on to the events
+	stopObservingLeader func()
+
+	mu       sync.Mutex
+	started  bool
+	shutdown bool
+}
+
+// New creates an Agent from the config, without starting it.
+func New(config Config) (*Agent, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &Agent{Config: config}, nil
+}
+
+// Start sets up every component and starts serving. It fails, shutting down whatever it already
+// set up, if a component can't be set up. Agents can only be started once.
+func (a *Agent) Start() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.started {
+		return errors.New("agent already started")
+	}
+	a.started = true
+
+	setup := []func() error{
+		a.setupMux,
+		a.setupLog,
+		a.setupEvents,
+		a.setupServers,
+		a.setupMembership,
+	}
+	for _, fn := range setup {
+		if err := fn(); err != nil {
+			// The mux never served, so closing its listener wouldn't end the Accepts on its
+			// matched listeners, e.g. the partitions', which closing the mux does
+			if a.mux != nil {
+				a.mux.Close()
+			}
+			a.close()
+			return err
+		}
+	}
+	go a.serve()
+	return nil
+}
+
+// setupMux listens on the RPC address, which gRPC, HTTP and Raft share, unless given a listener.
+func (a *Agent) setupMux() error {
+	ln := a.RPCListener
+	if ln == nil {
+		rpcAddr, err := a.RPCAddr()
+		if err != nil {
+			return err
+		}
+		if ln, err = net.Listen("tcp", rpcAddr); err != nil {
+			return err
+		}
+	}
+	a.mux = server.NewMultiplexer(ln)
+	return nil
+}
+
+// setupLog starts the distributed log, with Raft on the connections starting with log.RaftRPC,
+// and the Raft groups of the partitions assigned to the node, on those starting with
+// log.PartitionRaftRPC.
+func (a *Agent) setupLog() error {
+	raftLn := a.mux.Match(matchFirstByte(log.RaftRPC))
+	partitionsLn := a.mux.Match(matchFirstByte(log.PartitionRaftRPC))
+
+	config := log.Config{}
+	config.Raft.StreamLayer = log.NewStreamLayer(raftLn, a.ServerTLSConfig, a.PeerTLSConfig)
+	config.Raft.LocalID = raft.ServerID(a.NodeName)
+	config.Raft.Bootstrap = a.Bootstrap
+	config.Logger = a.Logger.With(slog.String("component", "log"))
+	config.TracerProvider = a.TracerProvider
+	var err error
+	a.log, err = log.NewDistributedLog(a.DataDir, config)
+	if err != nil {
+		return err
+	}
+	a.partitions, err = log.NewPartitions(a.log, log.PartitionsConfig{
+		DataDir:           a.DataDir,
+		Listener:          partitionsLn,
+		ServerTLSConfig:   a.ServerTLSConfig,
+		PeerTLSConfig:     a.PeerTLSConfig,
+		Config:            config,
+		Logger:            a.Logger,
+		RebalanceInterval: a.RebalanceInterval,
+		MaxMoves:          a.MaxRebalanceMoves,
+	})
+	if err != nil {
+		return err
+	}
+	if a.log.Bootstrapped() {
+		// Wait for the node to elect itself, so it accepts writes as soon as Start returns
+		return a.log.WaitForLeader(3 * time.Second)
+	}
+	if a.Bootstrap {
+		a.Logger.Info("not bootstrapping: the data dir already holds the cluster's state",
+			slog.String("data_dir", a.DataDir))
+	}
+	return nil
+}
+
+// setupEvents streams the changes of the cluster's servers to the watchers: the members joining,
+// leaving and failing, as the membership reports them, and the leadership changing.
+func (a *Agent) setupEvents() error {
+	a.events = newServerEvents()
+	a.stopObservingLeader = a.log.ObserveLeader(a.events.observeLeader)
+	return nil
+}
+
+// matchFirstByte matches the connections starting with the byte, e.g. to tell Raft's apart.
+func matchFirstByte(b byte) func(io.Reader) bool {
+	return func(r io.Reader) bool {
+		got := make([]byte, 1)
+		if _, err := r.Read(got); err != nil {
+			return false
+		}
+		return bytes.Equal(got, []byte{b})
+	}
+}
+
+// setupServers creates the gRPC and HTTP servers, both serving the distributed log and
+// authorizing requests with the same ACL, extended by the cluster's configuration.
+func (a *Agent) setupServers() error {
+	// Insecure nodes without an ACL let every client do anything
+	var authorizer server.Authorizer
+	if a.Authorizer != nil {
+		authorizer = a.Authorizer
+	} else if !a.Insecure || a.ACLModelFile != "" || a.ACLPolicyFile != "" {
+		acl := auth.New(a.ACLModelFile, a.ACLPolicyFile)
+		if err := acl.Err(); err != nil {
+			return err
+		}
+		authorizer = &clusterAuthorizer{
+			Authorizer: acl,
+			log:        a.log,
+			logger:     a.Logger,
+		}
+	}
+
+	// Servers are described by dialing them like the Raft connections, with the peer credentials
+	dialCreds := insecure.NewCredentials()
+	if a.PeerTLSConfig != nil {
+		dialCreds = credentials.NewTLS(a.PeerTLSConfig)
+	}
+	a.cluster = newCluster(a.log, a.partitions, a.NodeName, []grpc.DialOption{grpc.WithTransportCredentials(dialCreds)}, a.Logger)
+
+	opts := []server.Option{
+		server.WithLogger(a.Logger),
+		server.WithTracerProvider(a.TracerProvider),
+		server.WithGetServerer(a.log),
+		server.WithServerWatcher(a.events),
+		server.WithClusterAdmin(a.cluster),
+		server.WithPartitionLogs(partitionLogs{a.partitions}),
+	}
+	if a.ServerTLSConfig != nil {
+		tlsConfig := a.ServerTLSConfig
+		if a.TokenValidator != nil || a.TokenIssuer != nil {
+			// The config is cloned since the Raft stream layer shares it
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		opts = append(opts, server.WithTLS(tlsConfig))
+	}
+	if a.TokenValidator != nil {
+		opts = append(opts, server.WithTokenValidator(a.TokenValidator))
+	}
+	if a.TokenIssuer != nil {
+		opts = append(opts, server.WithTokenIssuer(a.TokenIssuer))
+	}
+	if len(a.SubjectRules) > 0 {
+		opts = append(opts, server.WithSubjectRules(a.SubjectRules...))
+	}
+	if a.Auditor != nil {
+		opts = append(opts, server.WithAuditor(a.Auditor))
+	}
+	if a.Insecure {
+		opts = append(opts, server.Insecure())
+	}
+	if a.Metrics != nil {
+		opts = append(opts, server.WithMetrics(a.Metrics))
+		if err := a.Metrics.Register(a.cluster); err != nil {
+			return err
+		}
+	}
+	var err error
+	a.server, err = server.NewGRPCServer(&server.Config{
+		CommitLog:  a.log,
+		Authorizer: authorizer,
+	}, opts...)
+	if err != nil {
+		return err
+	}
+
+	var httpOpts []server.HTTPOption
+	if authorizer != nil {
+		httpAuth := &server.HTTPAuth{
+			Authorizer:   authorizer,
+			BearerTokens: a.BearerTokens,
+			APIKeys:      a.APIKeys,
+			Tokens:       a.TokenValidator,
+			Issuer:       a.TokenIssuer,
+			Keys:         a.APIKeyValidator,
+			SubjectRules: a.SubjectRules,
+			Auditor:      a.Auditor,
+		}
+		httpOpts = append(httpOpts, server.WithMiddleware(httpAuth.Middleware))
+	}
+	a.httpServer, err = server.NewHttpServer(&server.HTTPConfig{
+		Log:    server.NewCommitRecordLog(a.log),
+		Logger: a.Logger,
+	}, httpOpts...)
+	return err
+}
+
+// setupMembership joins the cluster, adding the nodes the discovery finds to the Raft cluster.
+func (a *Agent) setupMembership() error {
+	rpcAddr, err := a.RPCAddr()
+	if err != nil {
+		return err
+	}
+	membership := a.Discovery
+	if membership == nil {
+		membership = a.serfMembership(rpcAddr)
+	}
+	if err := membership.Start(memberHandler{DistributedLog: a.log, events: a.events}); err != nil {
+		return err
+	}
+	a.membership = membership
+	return nil
+}
+
+// serfMembership returns the Discovery gossiping with the other nodes through Serf.
+func (a *Agent) serfMembership(rpcAddr string) *discovery.Membership {
+	return discovery.NewMembership(discovery.Config{
+		NodeName: a.NodeName,
+		BindAddr: a.BindAddr,
+		Tags: map[string]string{
+			"rpc_addr": rpcAddr,
+		},
+		StartJoinAddrs:      a.StartJoinAddrs,
+		JoinRetryTimeout:    a.JoinRetryTimeout,
+		JoinDNS:             a.JoinDNS,
+		JoinDNSInterval:     a.JoinDNSInterval,
+		NonVoter:            a.NonVoter,
+		Datacenter:          a.Datacenter,
+		Rack:                a.Rack,
+		Version:             version.Get().Version,
+		Profile:             a.GossipProfile,
+		FailedMemberTimeout: a.FailedNodeTimeout,
+		EncryptKeys:         a.GossipKeys,
+		Logger:              a.Logger,
+	})
+}
+
+// serve serves gRPC, HTTP and Raft until the agent shuts down. If serving fails before,
+// the agent shuts down, as the node can't take part in the cluster anymore.
+func (a *Agent) serve() {
+	err := a.mux.Serve(a.server, a.httpServer)
+	a.mu.Lock()
+	shutdown := a.shutdown
+	a.mu.Unlock()
+	if shutdown {
+		return
+	}
+	a.Logger.Error("failed to serve", slog.String("error", fmt.Sprint(err)))
+	if err := a.Shutdown(); err != nil {
+		a.Logger.Error("failed to shut down", slog.String("error", err.Error()))
+	}
+}
+
+// Leave gracefully removes the node from the cluster and shuts it down. A leader removes itself
+// from the Raft cluster, handing leadership over, and the other nodes are told the node left, so
+// the leader removes it right away instead of waiting for it to be reaped as failed.
+func (a *Agent) Leave() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.shutdown {
+		return nil
+	}
+	a.shutdown = true
+	var errs []error
+	if a.log != nil {
+		errs = append(errs, a.log.Demote())
+	}
+	if a.membership != nil {
+		errs = append(errs, a.membership.Leave())
+		a.membership = nil
+	}
+	return errors.Join(append(errs, a.close())...)
+}
+
+// Shutdown stops the servers and closes the log without leaving the cluster, so the other
+// nodes see the node fail and keep it in the cluster until it's reaped, e.g. while it restarts.
+// It's safe to call more than once.
+func (a *Agent) Shutdown() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.shutdown {
+		return nil
+	}
+	a.shutdown = true
+	return a.close()
+}
+
+// close tears down every component that was set up, in the reverse order, and returns their
+// errors joined. The caller must hold the lock.
+func (a *Agent) close() error {
+	var errs []error
+	if a.membership != nil {
+		errs = append(errs, a.membership.Shutdown())
+	}
+	if a.httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		errs = append(errs, a.httpServer.Shutdown(ctx))
+		cancel()
+	}
+	if a.server != nil {
+		// Open streams keep a graceful stop waiting, so they're cut off after the timeout
+		stopped := make(chan struct{})
+		go func() {
+			a.server.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(shutdownTimeout):
+			a.server.Stop()
+		}
+	}
+	if a.cluster != nil {
+		if a.Metrics != nil {
+			a.Metrics.Unregister(a.cluster)
+		}
+		errs = append(errs, a.cluster.Close())
+	}
+	// The partitions' Raft groups are closed before the log's, which assigns them
+	if a.partitions != nil {
+		errs = append(errs, a.partitions.Close())
+	}
+	if a.stopObservingLeader != nil {
+		a.stopObservingLeader()
+	}
+	if a.mux != nil {
+		a.mux.Close()
+	}
+	if a.log != nil {
+		errs = append(errs, a.log.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,759 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	apiv2 "github.com/glauco/proglog/api/v2"
+	"github.com/glauco/proglog/internal/config"
+	"github.com/glauco/proglog/internal/discovery"
+	"github.com/glauco/proglog/internal/version"
+	logclient "github.com/glauco/proglog/pkg/client"
+	"github.com/glauco/proglog/pkg/loadbalance"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/go-dynaport"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestAgent(t *testing.T) {
+	agents, peerTLSConfig := setupCluster(t, 3, func(i int, c *Config) {
+		c.Datacenter, c.Rack = "eu-west", fmt.Sprintf("rack-%d", i)
+		if i == 2 {
+			// The last agent serves on a listener opened for it, as systemd's socket activation does
+			rpcAddr, err := c.RPCAddr()
+			require.NoError(t, err)
+			c.RPCListener, err = net.Listen("tcp", rpcAddr)
+			require.NoError(t, err)
+		}
+	})
+
+	ctx := context.Background()
+	leaderClient := client(t, agents[0], peerTLSConfig)
+
+	// Every agent lists the whole cluster, led by the bootstrapping agent, and where it runs
+	serversResponse, err := client(t, agents[1], peerTLSConfig).GetServers(ctx, &api.GetServersRequest{})
+	require.NoError(t, err)
+	require.Len(t, serversResponse.Servers, 3)
+	for i, srv := range serversResponse.Servers {
+		rpcAddr, err := agents[i].RPCAddr()
+		require.NoError(t, err)
+		require.Equal(t, agents[i].NodeName, srv.Id)
+		require.Equal(t, rpcAddr, srv.RpcAddr)
+		require.Equal(t, i == 0, srv.IsLeader)
+		require.Equal(t, "eu-west", srv.Datacenter)
+		require.Equal(t, agents[i].Rack, srv.Rack)
+		require.Equal(t, version.Get().Version, srv.Version)
+	}
+	produceResponse, err := leaderClient.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("foo")},
+	})
+	require.NoError(t, err)
+	require.NotZero(t, produceResponse.LeaderEpoch)
+
+	// Records produced to the leader can be consumed from every node once replicated
+	for _, agent := range agents {
+		c := client(t, agent, peerTLSConfig)
+		require.Eventually(t, func() bool {
+			res, err := c.Consume(ctx, &api.ConsumeRequest{Offset: produceResponse.Offset})
+			return err == nil && string(res.Record.Value) == "foo"
+		}, 3*time.Second, 50*time.Millisecond)
+	}
+
+	// Produces asking for replicated acks are acknowledged once a quorum stored the record,
+	// and followers reject writes as unavailable, so clients retry them on the leader
+	replicated := &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("replicated")},
+		Acks:   api.Acks_ACKS_REPLICATED,
+	}
+	_, err = client(t, agents[1], peerTLSConfig).Produce(ctx, replicated)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	produceResponse, err = leaderClient.Produce(ctx, replicated)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), produceResponse.Offset)
+
+	// The HTTP server shares the port and serves the same log, with the same ACL
+	rpcAddr, err := agents[0].RPCAddr()
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, "http://"+rpcAddr+"/", strings.NewReader(`{"record":{"value":"YmFy"}}`))
+	require.NoError(t, err)
+	req.Header.Set("X-API-Key", "secret")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode, string(body))
+	require.JSONEq(t, `{"offset":2}`, string(body))
+
+	followerClient := client(t, agents[1], peerTLSConfig)
+	require.Eventually(t, func() bool {
+		res, err := followerClient.Consume(ctx, &api.ConsumeRequest{Offset: 2})
+		return err == nil && string(res.Record.Value) == "bar"
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// Clients resolving the cluster through any node send produces to the leader
+	rpcAddr, err = agents[2].RPCAddr()
+	require.NoError(t, err)
+	conn, err := grpc.NewClient(
+		fmt.Sprintf("%s:///%s", loadbalance.Name, rpcAddr),
+		grpc.WithTransportCredentials(credentials.NewTLS(peerTLSConfig)),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	produceResponse, err = api.NewLogClient(conn).Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("baz")},
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		res, err := api.NewLogClient(conn).Consume(ctx, &api.ConsumeRequest{Offset: produceResponse.Offset})
+		return err == nil && string(res.Record.Value) == "baz"
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// Linearizable consumes see the latest produce right away, from the leader only
+	linearizable := &api.ConsumeRequest{Offset: produceResponse.Offset, Linearizable: true}
+	_, err = followerClient.Consume(ctx, linearizable)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	produceResponse, err = api.NewLogClient(conn).Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("qux")},
+	})
+	require.NoError(t, err)
+	linearizable.Offset = produceResponse.Offset
+	consumeResponse, err := api.NewLogClient(conn).Consume(loadbalance.ToLeader(ctx), linearizable)
+	require.NoError(t, err)
+	require.Equal(t, "qux", string(consumeResponse.Record.Value))
+
+	// Unauthenticated HTTP requests are rejected
+	rpcAddr, err = agents[0].RPCAddr()
+	require.NoError(t, err)
+	res, err = http.Get("http://" + rpcAddr + "/offsets")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestAgentLeave(t *testing.T) {
+	agents, peerTLSConfig := setupCluster(t, 3, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	servers := func(agent *Agent) []*api.Server {
+		res, err := client(t, agent, peerTLSConfig).GetServers(ctx, &api.GetServersRequest{})
+		if err != nil {
+			return nil
+		}
+		return res.Servers
+	}
+
+	// The remaining node watches the cluster's changes as they happen
+	stream, err := client(t, agents[1], peerTLSConfig).WatchServers(ctx, &api.WatchServersRequest{})
+	require.NoError(t, err)
+	_, err = stream.Header()
+	require.NoError(t, err)
+	events := make(chan *api.ServerEvent, 16)
+	go func() {
+		defer close(events)
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			events <- event
+		}
+	}()
+	// waitEvent waits for an event of the type about the server with the id, unless one was
+	// received already
+	seen := make(map[string]bool)
+	waitEvent := func(typ api.ServerEventType, id string) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for !seen[fmt.Sprint(typ, id)] {
+			select {
+			case event, ok := <-events:
+				require.True(t, ok)
+				seen[fmt.Sprint(event.Type, event.GetServer().GetId())] = true
+			case <-timeout:
+				t.Fatalf("no %s event about server %q", typ, id)
+			}
+		}
+	}
+
+	// A follower leaving is removed from the cluster right away
+	require.NoError(t, agents[2].Leave())
+	require.Eventually(t, func() bool {
+		return len(servers(agents[0])) == 2
+	}, 3*time.Second, 50*time.Millisecond)
+	waitEvent(api.ServerEventType_SERVER_EVENT_TYPE_LEFT, "2")
+
+	// The leader leaving removes itself, and the remaining node takes over
+	require.NoError(t, agents[0].Leave())
+	require.Eventually(t, func() bool {
+		s := servers(agents[1])
+		return len(s) == 1 && s[0].Id == "1" && s[0].IsLeader
+	}, 5*time.Second, 50*time.Millisecond)
+	waitEvent(api.ServerEventType_SERVER_EVENT_TYPE_LEFT, "0")
+	waitEvent(api.ServerEventType_SERVER_EVENT_TYPE_LEADER_CHANGED, "1")
+	_, err = client(t, agents[1], peerTLSConfig).Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("foo")},
+	})
+	require.NoError(t, err)
+}
+
+func TestAgentRestart(t *testing.T) {
+	agents, peerTLSConfig := setupCluster(t, 1, nil)
+	ctx := context.Background()
+	_, err := client(t, agents[0], peerTLSConfig).Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("foo")},
+	})
+	require.NoError(t, err)
+
+	// Bootstrapping and joining at once would form a second cluster
+	cfg := agents[0].Config
+	cfg.StartJoinAddrs = []string{"127.0.0.1:8401"}
+	_, err = New(cfg)
+	require.Error(t, err)
+
+	// Restarting with the same config doesn't bootstrap a new cluster, but resumes the node's
+	require.NoError(t, agents[0].Shutdown())
+	restarted, err := New(agents[0].Config)
+	require.NoError(t, err)
+	require.NoError(t, restarted.Start())
+	t.Cleanup(func() { require.NoError(t, restarted.Shutdown()) })
+	require.False(t, restarted.log.Bootstrapped())
+
+	c := client(t, restarted, peerTLSConfig)
+	require.Eventually(t, func() bool {
+		res, err := c.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("bar")}})
+		return err == nil && res.Offset == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	res, err := c.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	require.Equal(t, "foo", string(res.Record.Value))
+}
+
+func TestAgentStartFailure(t *testing.T) {
+	// No seed node is up, so joining fails once the retries time out
+	ports := dynaport.Get(3)
+	a, err := New(Config{
+		NodeName:         "0",
+		StartJoinAddrs:   []string{fmt.Sprintf("127.0.0.1:%d", ports[2])},
+		JoinRetryTimeout: time.Second,
+		BindAddr:         fmt.Sprintf("127.0.0.1:%d", ports[0]),
+		RPCPort:          ports[1],
+		DataDir:          t.TempDir(),
+		Insecure:         true,
+	})
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() { done <- a.Start() }()
+	select {
+	case err := <-done:
+		require.ErrorContains(t, err, "join cluster")
+	case <-time.After(10 * time.Second):
+		t.Fatal("start didn't fail once the join retries timed out")
+	}
+}
+
+func TestAgentNonVoter(t *testing.T) {
+	agents, peerTLSConfig := setupCluster(t, 3, func(i int, c *Config) {
+		c.NonVoter = i == 2
+	})
+	ctx := context.Background()
+	leaderConn := dial(t, agents[0], peerTLSConfig)
+	voters := func() []bool {
+		res, err := api.NewLogClient(leaderConn).GetServers(ctx, &api.GetServersRequest{})
+		require.NoError(t, err)
+		var voters []bool
+		for _, srv := range res.Servers {
+			voters = append(voters, srv.IsVoter)
+		}
+		return voters
+	}
+
+	// The non-voter joins the cluster and replicates its records without voting
+	require.Equal(t, []bool{true, true, false}, voters())
+	produceResponse, err := api.NewLogClient(leaderConn).Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("foo")},
+	})
+	require.NoError(t, err)
+	nonVoterClient := client(t, agents[2], peerTLSConfig)
+	require.Eventually(t, func() bool {
+		res, err := nonVoterClient.Consume(ctx, &api.ConsumeRequest{Offset: produceResponse.Offset})
+		return err == nil && string(res.Record.Value) == "foo"
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// Promoting it makes it a voter, and only servers of the cluster can be promoted
+	admin := api.NewAdminClient(leaderConn)
+	_, err = admin.PromoteServer(ctx, &api.PromoteServerRequest{Id: "2"})
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, true, true}, voters())
+	_, err = admin.PromoteServer(ctx, &api.PromoteServerRequest{Id: "3"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// Bootstrapping nodes must vote to elect themselves
+	cfg := agents[0].Config
+	cfg.NonVoter = true
+	_, err = New(cfg)
+	require.Error(t, err)
+}
+
+func TestAgentStaticDiscovery(t *testing.T) {
+	var statics []*discovery.Static
+	agents, peerTLSConfig := setupCluster(t, 2, func(i int, c *Config) {
+		rpcAddr, err := c.RPCAddr()
+		require.NoError(t, err)
+		static := &discovery.Static{
+			NodeName: c.NodeName,
+			Servers:  []discovery.StaticServer{{Name: c.NodeName, RPCAddr: rpcAddr}},
+		}
+		c.Discovery, c.StartJoinAddrs = static, nil
+		statics = append(statics, static)
+	})
+	ctx := context.Background()
+	leader := client(t, agents[0], peerTLSConfig)
+	servers := func() int {
+		res, err := leader.GetServers(ctx, &api.GetServersRequest{})
+		require.NoError(t, err)
+		return len(res.Servers)
+	}
+
+	// Nodes only join the cluster once listed
+	require.Equal(t, 1, servers())
+	rpcAddr, err := agents[1].RPCAddr()
+	require.NoError(t, err)
+	statics[0].Add(discovery.StaticServer{Name: "1", RPCAddr: rpcAddr})
+	require.Eventually(t, func() bool {
+		return servers() == 2
+	}, 3*time.Second, 50*time.Millisecond)
+	produceResponse, err := leader.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("foo")},
+	})
+	require.NoError(t, err)
+	followerClient := client(t, agents[1], peerTLSConfig)
+	require.Eventually(t, func() bool {
+		res, err := followerClient.Consume(ctx, &api.ConsumeRequest{Offset: produceResponse.Offset})
+		return err == nil && string(res.Record.Value) == "foo"
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// Once removed, the node leaves the cluster
+	statics[0].Remove("1")
+	require.Eventually(t, func() bool {
+		return servers() == 1
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestAgentInsecure(t *testing.T) {
+	agents, _ := setupCluster(t, 1, func(_ int, c *Config) {
+		c.ServerTLSConfig, c.PeerTLSConfig = nil, nil
+		c.ACLModelFile, c.ACLPolicyFile = "", ""
+		c.Insecure = true
+	})
+	rpcAddr, err := agents[0].RPCAddr()
+	require.NoError(t, err)
+	conn, err := grpc.NewClient(rpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	// Without an ACL, plaintext clients may do anything, over gRPC and HTTP alike
+	_, err = api.NewLogClient(conn).Produce(context.Background(), &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("foo")},
+	})
+	require.NoError(t, err)
+	res, err := http.Get("http://" + rpcAddr + "/offsets")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestAgentTokenAuth(t *testing.T) {
+	agents, peerTLSConfig := setupCluster(t, 1, func(_ int, c *Config) {
+		c.TokenValidator = tokenValidator{"root-token": "root"}
+	})
+	tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CAFile:        config.CAFile,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	rpcAddr, err := agents[0].RPCAddr()
+	require.NoError(t, err)
+	produce := func(opts ...grpc.DialOption) error {
+		conn, err := grpc.NewClient(rpcAddr, append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))...)
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = api.NewLogClient(conn).Produce(context.Background(), &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("foo")},
+		})
+		return err
+	}
+
+	// Clients without certificates authenticate with their tokens
+	require.NoError(t, produce(logclient.WithBearerToken("root-token")))
+	require.Equal(t, codes.Unauthenticated, status.Code(produce(logclient.WithBearerToken("bogus"))))
+	require.Equal(t, codes.Unauthenticated, status.Code(produce()))
+
+	// Clients with certificates are still authenticated by them
+	_, err = client(t, agents[0], peerTLSConfig).Produce(context.Background(), &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("bar")},
+	})
+	require.NoError(t, err)
+}
+
+// tokenValidator validates the tokens it maps to their subjects.
+type tokenValidator map[string]string
+
+func (v tokenValidator) Validate(_ context.Context, token string) (string, error) {
+	subject, ok := v[token]
+	if !ok {
+		return "", fmt.Errorf("unknown token")
+	}
+	return subject, nil
+}
+
+func TestAgentDescribeCluster(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	agents, peerTLSConfig := setupCluster(t, 3, func(i int, c *Config) {
+		if i == 0 {
+			c.Metrics = registry
+		}
+	})
+	ctx := context.Background()
+	_, err := client(t, agents[0], peerTLSConfig).Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("foo")},
+	})
+	require.NoError(t, err)
+
+	// Any node describes the whole cluster, and replicas catch up with the leader's
+	admin := api.NewAdminClient(dial(t, agents[1], peerTLSConfig))
+	require.Eventually(t, func() bool {
+		res, err := admin.DescribeCluster(ctx, &api.DescribeClusterRequest{})
+		require.NoError(t, err)
+		require.Len(t, res.Replicas, 3)
+		for _, r := range res.Replicas {
+			if r.Error != "" || r.NextOffset != 1 || r.LagRecords != 0 || r.Lag.AsDuration() != 0 {
+				return false
+			}
+		}
+		return true
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// Replicas of servers that are down are reported as such, by the API and the leader's metrics
+	require.NoError(t, agents[2].Shutdown())
+	res, err := admin.DescribeCluster(ctx, &api.DescribeClusterRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "2", res.Replicas[2].Server.Id)
+	require.NotEmpty(t, res.Replicas[2].Error)
+	require.Nil(t, res.Replicas[2].Lag)
+
+	metrics := gather(t, registry)
+	require.Equal(t, 1.0, metrics[`proglog_replica_up{id="1"}`])
+	require.Equal(t, 0.0, metrics[`proglog_replica_up{id="2"}`])
+	require.Equal(t, 0.0, metrics[`proglog_replica_lag_records{id="1"}`])
+	require.Equal(t, 0.0, metrics[`proglog_replica_lag_seconds{id="1"}`])
+	_, ok := metrics[`proglog_replica_lag_records{id="2"}`]
+	require.False(t, ok)
+}
+
+func TestAgentTopics(t *testing.T) {
+	agents, peerTLSConfig := setupCluster(t, 3, nil)
+	ctx := context.Background()
+
+	// Only the leader creates topics, assigning each partition to as many servers as asked
+	_, err := api.NewAdminClient(dial(t, agents[1], peerTLSConfig)).CreateTopic(ctx, &api.CreateTopicRequest{Name: "orders"})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	res, err := api.NewAdminClient(dial(t, agents[0], peerTLSConfig)).CreateTopic(ctx, &api.CreateTopicRequest{
+		Name:              "orders",
+		Partitions:        3,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Topic.Partitions, 3)
+
+	clients := make(map[string]apiv2.LogClient)
+	for _, agent := range agents {
+		clients[agent.NodeName] = apiv2.NewLogClient(dial(t, agent, peerTLSConfig))
+	}
+	for _, partition := range res.Topic.Partitions {
+		require.Len(t, partition.Replicas, 2)
+		replicas := make(map[string]bool)
+		for _, replica := range partition.Replicas {
+			replicas[replica.Id] = true
+		}
+
+		// The partition's replicas elect a leader of their own, which accepts its writes
+		var off uint64
+		value := []byte(fmt.Sprintf("order %d", partition.Id))
+		require.Eventually(t, func() bool {
+			for id := range replicas {
+				res, err := clients[id].Produce(ctx, &apiv2.ProduceRequest{
+					Topic:     "orders",
+					Partition: partition.Id,
+					Record:    &apiv2.Record{Value: value},
+				})
+				if err == nil {
+					off = res.Offset
+					return true
+				}
+			}
+			return false
+		}, 10*time.Second, 100*time.Millisecond)
+		require.Equal(t, uint64(0), off)
+
+		// Every replica of the partition holds its records, while other servers don't
+		for id, client := range clients {
+			if !replicas[id] {
+				_, err := client.Consume(ctx, &apiv2.ConsumeRequest{Topic: "orders", Partition: partition.Id})
+				require.Equal(t, codes.FailedPrecondition, status.Code(err))
+				continue
+			}
+			require.Eventually(t, func() bool {
+				res, err := client.Consume(ctx, &apiv2.ConsumeRequest{Topic: "orders", Partition: partition.Id})
+				return err == nil && string(res.Record.Value) == string(value)
+			}, 3*time.Second, 50*time.Millisecond)
+		}
+	}
+
+	// Every server knows the topics, and that's all they know
+	followerAdmin := api.NewAdminClient(dial(t, agents[2], peerTLSConfig))
+	topics, err := followerAdmin.ListTopics(ctx, &api.ListTopicsRequest{})
+	require.NoError(t, err)
+	require.Len(t, topics.Topics, 1)
+	require.Equal(t, "orders", topics.Topics[0].Name)
+	_, err = clients["0"].Consume(ctx, &apiv2.ConsumeRequest{Topic: "payments"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// The partitions are balanced across the servers, and rebalancing can be paused cluster-wide
+	leaderAdmin := api.NewAdminClient(dial(t, agents[0], peerTLSConfig))
+	rebalance, err := leaderAdmin.TriggerRebalance(ctx, &api.TriggerRebalanceRequest{})
+	require.NoError(t, err)
+	require.Empty(t, rebalance.Moves)
+	_, err = leaderAdmin.PauseRebalance(ctx, &api.PauseRebalanceRequest{Paused: true})
+	require.NoError(t, err)
+	_, err = leaderAdmin.TriggerRebalance(ctx, &api.TriggerRebalanceRequest{})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.Eventually(t, func() bool {
+		topics, err := followerAdmin.ListTopics(ctx, &api.ListTopicsRequest{})
+		return err == nil && topics.RebalancePaused
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestAgentClusterConfig(t *testing.T) {
+	agents, peerTLSConfig := setupCluster(t, 2, nil)
+	ctx := context.Background()
+	nobodyTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.NobodyClientCertFile,
+		KeyFile:       config.NobodyClientKeyFile,
+		CAFile:        config.CAFile,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	_, err = client(t, agents[0], peerTLSConfig).Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("foo")},
+	})
+	require.NoError(t, err)
+	nobody := client(t, agents[1], nobodyTLSConfig)
+	_, err = nobody.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Only the leader sets the configuration, whose ACL rules every node enforces once replicated
+	_, err = api.NewAdminClient(dial(t, agents[1], peerTLSConfig)).SetConfig(ctx, &api.SetConfigRequest{
+		Config: &api.ClusterConfig{},
+	})
+	require.Error(t, err)
+	res, err := api.NewAdminClient(dial(t, agents[0], peerTLSConfig)).SetConfig(ctx, &api.SetConfigRequest{
+		Config: &api.ClusterConfig{AclRules: []*api.AclRule{{Subject: "nobody", Object: "*", Action: "consume"}}},
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := nobody.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+		return err == nil
+	}, 3*time.Second, 50*time.Millisecond)
+	got, err := api.NewAdminClient(dial(t, agents[1], peerTLSConfig)).GetConfig(ctx, &api.GetConfigRequest{})
+	require.NoError(t, err)
+	require.Equal(t, res.Config.Version, got.Config.Version)
+}
+
+func TestAgentBackup(t *testing.T) {
+	agents, peerTLSConfig := setupCluster(t, 3, nil)
+	ctx := context.Background()
+	leaderAdmin := api.NewAdminClient(dial(t, agents[0], peerTLSConfig))
+	_, err := leaderAdmin.CreateTopic(ctx, &api.CreateTopicRequest{
+		Name:              "orders",
+		Partitions:        2,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	_, err = client(t, agents[0], peerTLSConfig).Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("foo"), Headers: map[string]string{"source": "test"}},
+	})
+	require.NoError(t, err)
+	// Each partition's leader, once elected, accepts its writes
+	var clients []apiv2.LogClient
+	for _, agent := range agents {
+		clients = append(clients, apiv2.NewLogClient(dial(t, agent, peerTLSConfig)))
+	}
+	for id := uint32(0); id < 2; id++ {
+		require.Eventually(t, func() bool {
+			for _, client := range clients {
+				_, err := client.Produce(ctx, &apiv2.ProduceRequest{
+					Topic:     "orders",
+					Partition: id,
+					Record:    &apiv2.Record{Value: []byte("order")},
+				})
+				if err == nil {
+					return true
+				}
+			}
+			return false
+		}, 10*time.Second, 100*time.Millisecond)
+	}
+
+	// Only the leader backs the cluster up
+	backup := func(agent *Agent) ([]*api.BackupChunk, error) {
+		stream, err := api.NewAdminClient(dial(t, agent, peerTLSConfig)).Backup(ctx, &api.BackupRequest{})
+		require.NoError(t, err)
+		var chunks []*api.BackupChunk
+		for {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				return chunks, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+	_, err = backup(agents[1])
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	chunks, err := backup(agents[0])
+	require.NoError(t, err)
+
+	// The backup holds the topics, then the records of every partition, read from their leaders
+	require.Len(t, chunks[0].Topics, 1)
+	require.Equal(t, "orders", chunks[0].Topics[0].Name)
+	require.Len(t, chunks[0].Ranges, 3)
+	records := make(map[string][]*api.Record)
+	for _, chunk := range chunks[1:] {
+		key := fmt.Sprintf("%s/%d", chunk.Topic, chunk.Partition)
+		records[key] = append(records[key], chunk.Records...)
+	}
+	require.Len(t, records["default/0"], 1)
+	require.Equal(t, "test", records["default/0"][0].Headers["source"])
+	require.Len(t, records["orders/0"], 1)
+	require.Len(t, records["orders/1"], 1)
+
+	// Writes were resumed
+	_, err = client(t, agents[0], peerTLSConfig).Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("bar")},
+	})
+	require.NoError(t, err)
+}
+
+// gather returns the value of every gauge of the registry, by name and id label.
+func gather(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	metrics := make(map[string]float64)
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			if m.GetGauge() == nil {
+				continue
+			}
+			name := family.GetName()
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "id" {
+					name += fmt.Sprintf(`{id=%q}`, label.GetValue())
+				}
+			}
+			metrics[name] = m.GetGauge().GetValue()
+		}
+	}
+	return metrics
+}
+
+// setupCluster starts a cluster of n agents: the first bootstraps it and the others join
+// through it. It returns the agents, once the followers had time to join the Raft cluster,
+// and the TLS config of the root client, which is also the agents' peer TLS config. The agents'
+// gossip is encrypted with a shared key.
+// fn, if set, adjusts the config of each agent.
+func setupCluster(t *testing.T, n int, fn func(i int, c *Config)) ([]*Agent, *tls.Config) {
+	t.Helper()
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.ServerCertFile,
+		KeyFile:       config.ServerKeyFile,
+		CAFile:        config.CAFile,
+		Server:        true,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	peerTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.RootClientCertFile,
+		KeyFile:       config.RootClientKeyFile,
+		CAFile:        config.CAFile,
+		Server:        false,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+
+	var agents []*Agent
+	for i := 0; i < n; i++ {
+		ports := dynaport.Get(2)
+		var startJoinAddrs []string
+		if i != 0 {
+			startJoinAddrs = append(startJoinAddrs, agents[0].BindAddr)
+		}
+		cfg := Config{
+			NodeName:        fmt.Sprintf("%d", i),
+			Bootstrap:       i == 0,
+			StartJoinAddrs:  startJoinAddrs,
+			BindAddr:        fmt.Sprintf("127.0.0.1:%d", ports[0]),
+			RPCPort:         ports[1],
+			DataDir:         t.TempDir(),
+			ACLModelFile:    config.ACLModelFile,
+			ACLPolicyFile:   config.ACLPolicyFile,
+			ServerTLSConfig: serverTLSConfig,
+			PeerTLSConfig:   peerTLSConfig,
+			GossipKeys:      [][]byte{[]byte("0123456789abcdef")},
+			APIKeys:         map[string]string{"secret": "root"},
+		}
+		if fn != nil {
+			fn(i, &cfg)
+		}
+		agent, err := New(cfg)
+		require.NoError(t, err)
+		require.NoError(t, agent.Start())
+		t.Cleanup(func() { require.NoError(t, agent.Shutdown()) })
+		agents = append(agents, agent)
+	}
+	// Give the followers time to join the Raft cluster
+	time.Sleep(3 * time.Second)
+	return agents, peerTLSConfig
+}
+
+// client returns a gRPC client of the agent, authenticated with the TLS config.
+func client(t *testing.T, agent *Agent, tlsConfig *tls.Config) api.LogClient {
+	t.Helper()
+	return api.NewLogClient(dial(t, agent, tlsConfig))
+}
+
+// dial returns a gRPC connection to the agent, authenticated with the TLS config.
+func dial(t *testing.T, agent *Agent, tlsConfig *tls.Config) *grpc.ClientConn {
+	t.Helper()
+	rpcAddr, err := agent.RPCAddr()
+	require.NoError(t, err)
+	conn, err := grpc.NewClient(rpcAddr, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
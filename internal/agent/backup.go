@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/log"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// prepareBackupTimeout bounds how long writes stay paused while the servers take the ranges
+	// of the partitions they lead.
+	prepareBackupTimeout = 10 * time.Second
+	// backupBatchSize is how many records a backup chunk holds at most.
+	backupBatchSize = 100
+)
+
+// Backup streams a consistent backup of the cluster to send: a first chunk with the topics and
+// the ranges of records backed up, then the records of every range in chunks. It pauses the
+// writes cluster-wide, asks every server to take the ranges of the partitions it leads, and
+// resumes the writes, so they're only paused while the ranges are taken, not while the records
+// are copied. Only the cluster's leader backs it up.
+func (c *cluster) Backup(ctx context.Context, send func(*api.BackupChunk) error) error {
+	topics, ranges, err := c.prepareBackup(ctx)
+	if err != nil {
+		return err
+	}
+	if err := send(&api.BackupChunk{Topics: topics, Ranges: ranges}); err != nil {
+		return err
+	}
+	for _, r := range ranges {
+		if err := c.copyRange(ctx, r, send); err != nil {
+			return fmt.Errorf("back up topic %q partition %d: %w", r.Topic, r.Partition, err)
+		}
+	}
+	return nil
+}
+
+// prepareBackup pauses the writes, takes the topics and the ranges of every partition from the
+// servers leading them, and resumes the writes. It fails if a partition's range is missing, e.g.
+// as its leader is down.
+func (c *cluster) prepareBackup(ctx context.Context) ([]*api.Topic, []*api.BackupRange, error) {
+	pauseIndex, err := c.partitions.PauseWrites()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err := c.partitions.ResumeWrites(); err != nil {
+			c.logger.Error("failed to resume writes after a backup", slog.String("error", err.Error()))
+		}
+	}()
+	topics := c.log.Topics()
+	servers, err := c.log.GetServers()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, prepareBackupTimeout)
+	defer cancel()
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errs   []error
+		ranges = make(map[string]*api.BackupRange) // Ranges by topic and partition
+	)
+	for _, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.prepareServerBackup(ctx, srv.RpcAddr, pauseIndex)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("server %s: %w", srv.Id, err))
+				return
+			}
+			for _, r := range res {
+				r.RpcAddr = srv.RpcAddr
+				// A deposed leader may report a partition too; the range with the most is the latest
+				key := fmt.Sprintf("%s/%d", r.Topic, r.Partition)
+				if other, ok := ranges[key]; !ok || other.NextOffset < r.NextOffset {
+					ranges[key] = r
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Back up the default topic and the partitions of the topics, in order, ignoring those of
+	// topics created while the ranges were taken
+	var backup []*api.BackupRange
+	missing := func(topic string, partition uint32) error {
+		msg := fmt.Sprintf("no server took the range of topic %q partition %d", topic, partition)
+		if err := errors.Join(errs...); err != nil {
+			msg += ": " + err.Error()
+		}
+		return api.NewError(codes.Unavailable, api.ReasonUnavailable, msg, nil)
+	}
+	r, ok := ranges[log.DefaultTopic+"/0"]
+	if !ok {
+		return nil, nil, missing(log.DefaultTopic, 0)
+	}
+	backup = append(backup, r)
+	for _, topic := range topics {
+		for _, partition := range topic.Partitions {
+			r, ok := ranges[fmt.Sprintf("%s/%d", topic.Name, partition.Id)]
+			if !ok {
+				return nil, nil, missing(topic.Name, partition.Id)
+			}
+			backup = append(backup, r)
+		}
+	}
+	return topics, backup, nil
+}
+
+// prepareServerBackup asks the server at the address for the ranges of the partitions it leads.
+func (c *cluster) prepareServerBackup(ctx context.Context, addr string, pauseIndex uint64) ([]*api.BackupRange, error) {
+	conn, err := c.conn(addr)
+	if err != nil {
+		return nil, err
+	}
+	res, err := api.NewAdminClient(conn).PrepareBackup(ctx, &api.PrepareBackupRequest{PauseIndex: pauseIndex})
+	if err != nil {
+		return nil, err
+	}
+	return res.Ranges, nil
+}
+
+// copyRange streams the range's records to send, from the server that took the range.
+func (c *cluster) copyRange(ctx context.Context, r *api.BackupRange, send func(*api.BackupChunk) error) error {
+	if r.LowestOffset == r.NextOffset {
+		return nil
+	}
+	conn, err := c.conn(r.RpcAddr)
+	if err != nil {
+		return err
+	}
+	stream, err := api.NewAdminClient(conn).ReadBackup(ctx, r)
+	if err != nil {
+		return err
+	}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := send(chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// PrepareBackup waits for the node to pause its writes, then returns the ranges of the
+// partitions it leads.
+func (c *cluster) PrepareBackup(ctx context.Context, pauseIndex uint64) ([]*api.BackupRange, error) {
+	return c.partitions.PrepareBackup(ctx, pauseIndex)
+}
+
+// ReadBackup streams the records of the range to send, in chunks of up to backupBatchSize
+// records, from the node's replica of the partition.
+func (c *cluster) ReadBackup(ctx context.Context, r *api.BackupRange, send func(*api.BackupChunk) error) error {
+	l := c.log
+	if r.Topic != log.DefaultTopic {
+		var err error
+		if l, err = c.partitions.Partition(r.Topic, r.Partition); err != nil {
+			return err
+		}
+	}
+	chunk := &api.BackupChunk{Topic: r.Topic, Partition: r.Partition}
+	for off := r.LowestOffset; off < r.NextOffset; off++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		record, err := l.Read(off)
+		if err != nil {
+			return err
+		}
+		chunk.Records = append(chunk.Records, record)
+		if len(chunk.Records) == backupBatchSize || off+1 == r.NextOffset {
+			if err := send(chunk); err != nil {
+				return err
+			}
+			chunk = &api.BackupChunk{Topic: r.Topic, Partition: r.Partition}
+		}
+	}
+	return nil
+}
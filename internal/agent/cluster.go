@@ -0,0 +1,282 @@
+package agent
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/log"
+	"github.com/glauco/proglog/internal/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// describeTimeout bounds how long describing the cluster waits for each server to describe its replica.
+const describeTimeout = 3 * time.Second
+
+// Metrics exported by the leader about every server's replica of the log.
+var (
+	replicaUpDesc = prometheus.NewDesc(
+		"proglog_replica_up",
+		"Whether the server's replica of the log could be described (1) or not (0).",
+		[]string{"id"},
+		nil,
+	)
+	replicaLagRecordsDesc = prometheus.NewDesc(
+		"proglog_replica_lag_records",
+		"Number of records the server's replica of the log is behind the leader's.",
+		[]string{"id"},
+		nil,
+	)
+	replicaLagSecondsDesc = prometheus.NewDesc(
+		"proglog_replica_lag_seconds",
+		"How much older the most recent record of the server's replica is than the leader's.",
+		[]string{"id"},
+		nil,
+	)
+)
+
+// cluster administers the cluster the agent's node is a member of, for the Admin service. It
+// describes the cluster by asking every server, itself included, to describe its replica of the
+// log, and measures each replica's lag against the leader's. It's also a Prometheus collector
+// exporting that lag, on the leader only, so it's exported once per cluster.
+type cluster struct {
+	log        *log.DistributedLog
+	partitions *log.Partitions
+	nodeName   string
+	dialOpts   []grpc.DialOption // Options dialing the servers, with the peer credentials
+	logger     *slog.Logger
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn // Connections to the servers, by RPC address
+}
+
+var _ server.ClusterAdmin = (*cluster)(nil)
+var _ prometheus.Collector = (*cluster)(nil)
+
+// newCluster creates a cluster administering the log and partitions of the node with the given name.
+func newCluster(
+	log *log.DistributedLog,
+	partitions *log.Partitions,
+	nodeName string,
+	dialOpts []grpc.DialOption,
+	logger *slog.Logger,
+) *cluster {
+	return &cluster{
+		log:        log,
+		partitions: partitions,
+		nodeName:   nodeName,
+		dialOpts:   dialOpts,
+		logger:     logger.With(slog.String("component", "cluster")),
+		conns:      make(map[string]*grpc.ClientConn),
+	}
+}
+
+// Promote makes a non-voting server a voter.
+func (c *cluster) Promote(id string) error {
+	return c.log.Promote(id)
+}
+
+// TransferLeadership hands the leadership over to the voter with the id, or to any voter if it's empty.
+func (c *cluster) TransferLeadership(id string) error {
+	return c.log.TransferLeadership(id)
+}
+
+// Leadership returns the cluster's leadership as the node sees it.
+func (c *cluster) Leadership() (*api.Leadership, error) {
+	return c.log.Leadership()
+}
+
+// CreateTopic creates a topic whose partitions are assigned to replicationFactor servers each.
+func (c *cluster) CreateTopic(name string, partitions, replicationFactor uint32) (*api.Topic, error) {
+	return c.log.CreateTopic(name, partitions, replicationFactor)
+}
+
+// Topics returns the topics created in the cluster.
+func (c *cluster) Topics() []*api.Topic {
+	return c.log.Topics()
+}
+
+// Rebalance moves replicas of the partitions across the servers, if the node leads the cluster.
+func (c *cluster) Rebalance() ([]*api.PartitionMove, error) {
+	return c.partitions.Rebalance()
+}
+
+// PauseRebalance pauses or resumes rebalancing, if the node leads the cluster.
+func (c *cluster) PauseRebalance(paused bool) error {
+	return c.partitions.PauseRebalance(paused)
+}
+
+// RebalancePaused reports whether rebalancing is paused.
+func (c *cluster) RebalancePaused() bool {
+	return c.log.RebalancePaused()
+}
+
+// DescribeCluster describes the replica of every server concurrently, and sets their lag behind
+// the leader's. Servers that can't be described are reported with the error.
+func (c *cluster) DescribeCluster(ctx context.Context) ([]*api.ReplicaStatus, error) {
+	servers, err := c.log.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	c.closeStaleConns(servers)
+
+	replicas := make([]*api.ReplicaStatus, len(servers))
+	var wg sync.WaitGroup
+	for i, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			replicas[i] = c.describeReplica(ctx, srv)
+		}()
+	}
+	wg.Wait()
+	setLag(replicas)
+	return replicas, nil
+}
+
+// describeReplica asks the server to describe its replica.
+func (c *cluster) describeReplica(ctx context.Context, srv *api.Server) *api.ReplicaStatus {
+	ctx, cancel := context.WithTimeout(ctx, describeTimeout)
+	defer cancel()
+	conn, err := c.conn(srv.RpcAddr)
+	if err != nil {
+		return &api.ReplicaStatus{Server: srv, Error: err.Error()}
+	}
+	res, err := api.NewAdminClient(conn).DescribeReplica(ctx, &api.DescribeReplicaRequest{})
+	if err != nil {
+		return &api.ReplicaStatus{Server: srv, Error: err.Error()}
+	}
+	replica := res.GetReplica()
+	if replica == nil {
+		replica = &api.ReplicaStatus{}
+	}
+	replica.Server = srv
+	return replica
+}
+
+// setLag sets how far every replica is behind the leader's, if the leader's was described.
+func setLag(replicas []*api.ReplicaStatus) {
+	var leader *api.ReplicaStatus
+	for _, r := range replicas {
+		if r.Server.IsLeader && r.Error == "" {
+			leader = r
+		}
+	}
+	if leader == nil {
+		return
+	}
+	for _, r := range replicas {
+		if r.Error != "" {
+			continue
+		}
+		// Replicas are described one after the other, so one may seem ahead of the leader
+		if r.NextOffset < leader.NextOffset {
+			r.LagRecords = leader.NextOffset - r.NextOffset
+		}
+		switch {
+		case leader.LastAppendTime == nil:
+			r.Lag = durationpb.New(0)
+		case r.LastAppendTime != nil:
+			r.Lag = durationpb.New(max(leader.LastAppendTime.AsTime().Sub(r.LastAppendTime.AsTime()), 0))
+		}
+		// A replica without records while the leader has some lags by an unknown time
+	}
+}
+
+// conn returns the connection to the server at the address, creating it on first use.
+func (c *cluster) conn(addr string) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := grpc.NewClient(addr, c.dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+// closeStaleConns closes the connections to addresses no server of the cluster has anymore.
+func (c *cluster) closeStaleConns(servers []*api.Server) {
+	addrs := make(map[string]bool, len(servers))
+	for _, srv := range servers {
+		addrs[srv.RpcAddr] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for addr, conn := range c.conns {
+		if !addrs[addr] {
+			conn.Close()
+			delete(c.conns, addr)
+		}
+	}
+}
+
+// Close closes the connections to the servers.
+func (c *cluster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for addr, conn := range c.conns {
+		conn.Close()
+		delete(c.conns, addr)
+	}
+	return nil
+}
+
+// Describe implements prometheus.Collector.
+func (c *cluster) Describe(ch chan<- *prometheus.Desc) {
+	ch <- replicaUpDesc
+	ch <- replicaLagRecordsDesc
+	ch <- replicaLagSecondsDesc
+}
+
+// Collect implements prometheus.Collector, exporting every replica's lag if the node leads the
+// cluster, and nothing otherwise.
+func (c *cluster) Collect(ch chan<- prometheus.Metric) {
+	if !c.isLeader() {
+		return
+	}
+	replicas, err := c.DescribeCluster(context.Background())
+	if err != nil {
+		c.logger.Error("failed to describe cluster", slog.String("error", err.Error()))
+		return
+	}
+	for _, r := range replicas {
+		up := 0.0
+		if r.Error == "" {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(replicaUpDesc, prometheus.GaugeValue, up, r.Server.Id)
+		if r.Error != "" {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			replicaLagRecordsDesc, prometheus.GaugeValue, float64(r.LagRecords), r.Server.Id,
+		)
+		if r.Lag != nil {
+			ch <- prometheus.MustNewConstMetric(
+				replicaLagSecondsDesc, prometheus.GaugeValue, r.Lag.AsDuration().Seconds(), r.Server.Id,
+			)
+		}
+	}
+}
+
+// isLeader reports whether the node leads the cluster.
+func (c *cluster) isLeader() bool {
+	servers, err := c.log.GetServers()
+	if err != nil {
+		return false
+	}
+	for _, srv := range servers {
+		if srv.Id == c.nodeName {
+			return srv.IsLeader
+		}
+	}
+	return false
+}
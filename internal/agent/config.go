@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"log/slog"
+	"sync"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/auth"
+	"github.com/glauco/proglog/internal/log"
+)
+
+// ClusterConfig returns the cluster's dynamic configuration, as the node last applied it.
+func (c *cluster) ClusterConfig() *api.ClusterConfig {
+	return c.log.ClusterConfig()
+}
+
+// SetConfig replaces the cluster's dynamic configuration, replicating it to every node through
+// Raft. Only the cluster's leader sets it.
+func (c *cluster) SetConfig(config *api.ClusterConfig) (*api.ClusterConfig, error) {
+	return c.log.SetConfig(config)
+}
+
+// UpdateACLRules removes and adds ACL rules of the cluster's configuration, replicating the
+// change to every node through Raft. Only the cluster's leader updates them.
+func (c *cluster) UpdateACLRules(add, remove []*api.AclRule) (*api.ClusterConfig, error) {
+	return c.log.UpdateACLRules(add, remove)
+}
+
+// clusterAuthorizer authorizes requests with the rules of the ACL policy file and those of the
+// cluster's configuration, which it sets again whenever the node applies a new configuration, so
+// every node enforces the same rules without being restarted.
+type clusterAuthorizer struct {
+	*auth.Authorizer
+	log    *log.DistributedLog
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	version uint64 // Version of the cluster's configuration whose rules are set
+}
+
+// Authorize decides whether the subject may act on the object, with the latest rules.
+func (a *clusterAuthorizer) Authorize(subject, object, action string) error {
+	a.sync()
+	return a.Authorizer.Authorize(subject, object, action)
+}
+
+// sync sets the rules of the cluster's configuration if it changed since they were last set.
+// Failing to, the previous rules stay, and setting them is retried on the next request.
+func (a *clusterAuthorizer) sync() {
+	config := a.log.ClusterConfig()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if config.Version == a.version {
+		return
+	}
+	if err := a.Authorizer.SetRules(config.AclRules); err != nil {
+		a.logger.Error("failed to set the ACL rules of the cluster's configuration",
+			slog.Uint64("version", config.Version),
+			slog.String("error", err.Error()))
+		return
+	}
+	a.version = config.Version
+}
@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"sync"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/discovery"
+	"github.com/glauco/proglog/internal/log"
+	"github.com/glauco/proglog/internal/server"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// serverEventsBuffer is how many events a watcher may fall behind before it's dropped.
+const serverEventsBuffer = 64
+
+// memberEventTypes maps the membership's event types to their API representation.
+var memberEventTypes = map[discovery.MemberEventType]api.ServerEventType{
+	discovery.MemberJoined: api.ServerEventType_SERVER_EVENT_TYPE_JOINED,
+	discovery.MemberLeft:   api.ServerEventType_SERVER_EVENT_TYPE_LEFT,
+	discovery.MemberFailed: api.ServerEventType_SERVER_EVENT_TYPE_FAILED,
+}
+
+// serverEvents broadcasts the changes of the cluster's servers to their watchers, for
+// WatchServers. Publishing never blocks: watchers falling behind are dropped, closing their
+// channel, so a slow client can't hold the membership or Raft up.
+type serverEvents struct {
+	mu       sync.Mutex
+	watchers map[chan *api.ServerEvent]struct{}
+}
+
+var _ server.ServerWatcher = (*serverEvents)(nil)
+
+// newServerEvents creates a broadcaster without watchers.
+func newServerEvents() *serverEvents {
+	return &serverEvents{watchers: make(map[chan *api.ServerEvent]struct{})}
+}
+
+// WatchServers returns a channel receiving the events published from now on, and a func to stop
+// watching. The channel is closed if the watcher falls behind.
+func (e *serverEvents) WatchServers() (<-chan *api.ServerEvent, func()) {
+	ch := make(chan *api.ServerEvent, serverEventsBuffer)
+	e.mu.Lock()
+	e.watchers[ch] = struct{}{}
+	e.mu.Unlock()
+	return ch, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if _, ok := e.watchers[ch]; ok {
+			delete(e.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish sends the event about the server to every watcher, dropping those falling behind.
+func (e *serverEvents) publish(t api.ServerEventType, srv *api.Server) {
+	event := &api.ServerEvent{Type: t, Server: srv, Time: timestamppb.Now()}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.watchers {
+		select {
+		case ch <- event:
+		default:
+			delete(e.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// observeMember publishes a member joining, leaving or failing, as the membership reports it.
+func (e *serverEvents) observeMember(event discovery.MemberEvent) {
+	e.publish(memberEventTypes[event.Type], &api.Server{
+		Id:         event.Name,
+		RpcAddr:    event.RPCAddr,
+		IsVoter:    !event.NonVoter,
+		Datacenter: event.Datacenter,
+		Rack:       event.Rack,
+		Version:    event.Version,
+	})
+}
+
+// memberHandler adds the servers the discovery finds to the cluster, through the log, and
+// publishes them joining, leaving and failing.
+type memberHandler struct {
+	*log.DistributedLog
+	events *serverEvents
+}
+
+var (
+	_ discovery.ObserverHandler = memberHandler{}
+	_ discovery.VersionHandler  = memberHandler{}
+)
+
+// Observe publishes the member's event.
+func (h memberHandler) Observe(event discovery.MemberEvent) {
+	h.events.observeMember(event)
+}
+
+// observeLeader publishes the leadership changing, as Raft reports it.
+func (e *serverEvents) observeLeader(leader *api.Server) {
+	e.publish(api.ServerEventType_SERVER_EVENT_TYPE_LEADER_CHANGED, leader)
+}
@@ -0,0 +1,23 @@
+package agent
+
+import (
+	"github.com/glauco/proglog/internal/log"
+	"github.com/glauco/proglog/internal/server"
+)
+
+// partitionLogs serves the partitions replicated by the node to the gRPC server's v2 API.
+// Partitions replicated by other nodes fail with their addresses, so clients can go there.
+type partitionLogs struct {
+	partitions *log.Partitions
+}
+
+var _ server.PartitionLogs = partitionLogs{}
+
+// PartitionLog returns the log of the topic's partition, if the node replicates it.
+func (p partitionLogs) PartitionLog(topic string, partition uint32) (server.CommitLog, error) {
+	l, err := p.partitions.Partition(topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
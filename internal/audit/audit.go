@@ -0,0 +1,163 @@
+// Package audit records the authorization decisions of proglog's servers, the audit trail of who
+// did, or tried to do, what on the log, to a file, the node's own log or an external endpoint.
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/glauco/proglog/internal/server"
+)
+
+// Auditor defaults.
+const (
+	defaultBufferSize    = 4096
+	defaultBatchSize     = 256
+	defaultFlushInterval = time.Second
+	defaultWriteTimeout  = 10 * time.Second
+)
+
+// Sink writes batches of audit events somewhere they're kept, e.g. a file.
+type Sink interface {
+	Write(ctx context.Context, events []server.AuditEvent) error
+	Close() error
+}
+
+// Config configures an Auditor.
+type Config struct {
+	// BufferSize is how many events may wait to be written; events audited while the buffer is
+	// full are dropped, so a slow sink doesn't slow requests down. Defaults to 4096.
+	BufferSize int
+	// BatchSize is the most events written at once; defaults to 256.
+	BatchSize int
+	// FlushInterval is the longest events wait to be written in a batch; defaults to a second.
+	FlushInterval time.Duration
+	// WriteTimeout bounds each write to the sink; defaults to 10 seconds.
+	WriteTimeout time.Duration
+	// Logger logs the events dropped and the sink's failures; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Auditor is a server.Auditor writing the events to a sink in the background, in batches, so
+// auditing doesn't add the sink's latency to requests. Events the sink fails to write are logged
+// and dropped rather than retried, as are those audited while the buffer is full.
+type Auditor struct {
+	sink   Sink
+	config Config
+	events chan server.AuditEvent
+	done   chan struct{}
+
+	mu      sync.RWMutex
+	closed  bool
+	dropped atomic.Uint64
+}
+
+var _ server.Auditor = (*Auditor)(nil)
+
+// New returns an auditor writing to the sink until it's closed.
+func New(sink Sink, config Config) *Auditor {
+	if config.BufferSize <= 0 {
+		config.BufferSize = defaultBufferSize
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultFlushInterval
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = defaultWriteTimeout
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	a := &Auditor{
+		sink:   sink,
+		config: config,
+		events: make(chan server.AuditEvent, config.BufferSize),
+		done:   make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// Audit queues the event to be written, or drops it if the buffer is full or the auditor closed.
+func (a *Auditor) Audit(event server.AuditEvent) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		a.dropped.Add(1)
+		return
+	}
+	select {
+	case a.events <- event:
+	default:
+		// Only the first drop is logged, so a stalled sink doesn't flood the log
+		if a.dropped.Add(1) == 1 {
+			a.config.Logger.Warn("audit buffer full, dropping events")
+		}
+	}
+}
+
+// Dropped returns how many events were dropped, whether the buffer was full or the sink failed.
+func (a *Auditor) Dropped() uint64 {
+	return a.dropped.Load()
+}
+
+// Close writes the events queued, then closes the sink.
+func (a *Auditor) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.events)
+	a.mu.Unlock()
+	<-a.done
+	return a.sink.Close()
+}
+
+// run writes the events in batches, when a batch is full or every FlushInterval, until the
+// auditor is closed.
+func (a *Auditor) run() {
+	defer close(a.done)
+	ticker := time.NewTicker(a.config.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]server.AuditEvent, 0, a.config.BatchSize)
+	for {
+		select {
+		case event, ok := <-a.events:
+			if !ok {
+				a.write(batch)
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) == a.config.BatchSize {
+				a.write(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			a.write(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// write writes the batch to the sink, counting its events as dropped if it fails.
+func (a *Auditor) write(batch []server.AuditEvent) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.WriteTimeout)
+	defer cancel()
+	if err := a.sink.Write(ctx, batch); err != nil {
+		a.dropped.Add(uint64(len(batch)))
+		a.config.Logger.Error("failed to write audit events",
+			slog.Int("events", len(batch)),
+			slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glauco/proglog/internal/server"
+	"github.com/stretchr/testify/require"
+)
+
+// memorySink keeps the batches written to it, or fails with err.
+type memorySink struct {
+	mu      sync.Mutex
+	batches [][]server.AuditEvent
+	err     error
+	closed  bool
+}
+
+func (s *memorySink) Write(_ context.Context, events []server.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.batches = append(s.batches, append([]server.AuditEvent(nil), events...))
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *memorySink) written() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, batch := range s.batches {
+		n += len(batch)
+	}
+	return n
+}
+
+func TestAuditor(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T){
+		"writes full batches right away": func(t *testing.T) {
+			sink := &memorySink{}
+			a := New(sink, Config{BatchSize: 2, FlushInterval: time.Hour})
+			defer a.Close()
+			for i := 0; i < 4; i++ {
+				a.Audit(server.AuditEvent{Subject: "alice"})
+			}
+			require.Eventually(t, func() bool { return sink.written() == 4 }, time.Second, 10*time.Millisecond)
+			require.Len(t, sink.batches, 2)
+		},
+		"writes partial batches every flush interval": func(t *testing.T) {
+			sink := &memorySink{}
+			a := New(sink, Config{FlushInterval: 10 * time.Millisecond})
+			defer a.Close()
+			a.Audit(server.AuditEvent{Subject: "alice"})
+			require.Eventually(t, func() bool { return sink.written() == 1 }, time.Second, 10*time.Millisecond)
+		},
+		"close writes the events queued and closes the sink": func(t *testing.T) {
+			sink := &memorySink{}
+			a := New(sink, Config{FlushInterval: time.Hour})
+			a.Audit(server.AuditEvent{Subject: "alice"})
+			require.NoError(t, a.Close())
+			require.Equal(t, 1, sink.written())
+			require.True(t, sink.closed)
+
+			// Events audited once closed are dropped
+			a.Audit(server.AuditEvent{Subject: "alice"})
+			require.Equal(t, uint64(1), a.Dropped())
+			require.NoError(t, a.Close())
+		},
+		"counts the events the sink fails to write as dropped": func(t *testing.T) {
+			a := New(&memorySink{err: errors.New("disk full")}, Config{FlushInterval: time.Hour})
+			a.Audit(server.AuditEvent{Subject: "alice"})
+			a.Audit(server.AuditEvent{Subject: "bob"})
+			require.NoError(t, a.Close())
+			require.Equal(t, uint64(2), a.Dropped())
+		},
+	} {
+		t.Run(scenario, fn)
+	}
+}
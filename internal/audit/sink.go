@@ -0,0 +1,223 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	api "github.com/glauco/proglog/api/v1"
+	prolog "github.com/glauco/proglog/internal/log"
+	"github.com/glauco/proglog/internal/server"
+)
+
+// Open returns the sink the spec names: log:<dir> for a commit log stored in the directory, slog
+// for the node's own log, an http:// or https:// URL for an external endpoint, or otherwise the
+// path of a file.
+func Open(spec string, logger *slog.Logger) (Sink, error) {
+	switch {
+	case spec == "":
+		return nil, fmt.Errorf("audit: empty sink")
+	case spec == "log", spec == "log:":
+		return nil, fmt.Errorf("audit: the log sink needs the directory of its commit log, e.g. log:/var/lib/proglog/audit; slog logs the events instead")
+	case strings.HasPrefix(spec, "log:"):
+		return OpenCommitLogSink(strings.TrimPrefix(spec, "log:"))
+	case spec == "slog":
+		return NewSlogSink(logger), nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return NewHTTPSink(spec, nil), nil
+	default:
+		return NewFileSink(spec)
+	}
+}
+
+// FileSink appends the events to a file, as a JSON object per line. Each batch is synced to disk
+// once written, so the events written survive a crash.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink returns a sink appending to the file, created if it doesn't exist. Only its owner
+// may read it, as events name the clients and what they tried to do.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Write appends the events to the file and syncs it.
+func (s *FileSink) Write(_ context.Context, events []server.AuditEvent) error {
+	var b bytes.Buffer
+	if err := encodeLines(&b, events); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(b.Bytes()); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// auditSegmentBytes is the size of the segments of the commit logs OpenCommitLogSink opens, large
+// enough for thousands of events each.
+const auditSegmentBytes = 1 << 20
+
+// CommitLogSink appends each event to a proglog commit log as a record, whose value is the event
+// as JSON and key its subject, so the audit trail is stored, retained and consumed like any other
+// log's records.
+type CommitLogSink struct {
+	log   server.CommitLog
+	close func() error // Closes the log, if the sink opened it
+}
+
+// NewCommitLogSink returns a sink appending to the commit log, e.g. the log of a topic's
+// partition. The log is the caller's, and stays open when the sink is closed.
+func NewCommitLogSink(log server.CommitLog) *CommitLogSink {
+	return &CommitLogSink{log: log, close: func() error { return nil }}
+}
+
+// OpenCommitLogSink returns a sink appending to the commit log stored in the directory, created
+// if it doesn't exist. Only its owner may read it, as events name the clients and what they tried
+// to do.
+func OpenCommitLogSink(dir string) (*CommitLogSink, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	var c prolog.Config
+	c.Segment.MaxStoreBytes = auditSegmentBytes
+	c.Segment.MaxIndexBytes = auditSegmentBytes
+	log, err := prolog.NewLog(dir, c)
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	return &CommitLogSink{log: log, close: log.Close}, nil
+}
+
+// Write appends the events to the commit log, in order.
+func (s *CommitLogSink) Write(_ context.Context, events []server.AuditEvent) error {
+	for _, event := range events {
+		b, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := s.log.Append(&api.Record{Key: []byte(event.Subject), Value: b}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the commit log if the sink opened it.
+func (s *CommitLogSink) Close() error {
+	return s.close()
+}
+
+// SlogSink logs the events with a structured logger, e.g. the node's, so they're collected with
+// its other logs.
+type SlogSink struct {
+	logger *slog.Logger
+}
+
+// NewSlogSink returns a sink logging the events with the logger, or slog.Default() if nil.
+func NewSlogSink(logger *slog.Logger) *SlogSink {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogSink{logger: logger}
+}
+
+// Write logs each event at the info level, as an audit message with the event's fields.
+func (s *SlogSink) Write(ctx context.Context, events []server.AuditEvent) error {
+	for _, event := range events {
+		attrs := []slog.Attr{
+			slog.Time("time", event.Time),
+			slog.String("subject", event.Subject),
+			slog.String("object", event.Object),
+			slog.String("action", event.Action),
+			slog.String("method", event.Method),
+			slog.String("peer", event.Peer),
+			slog.Bool("allowed", event.Allowed),
+			slog.Duration("latency", event.Latency),
+		}
+		if event.Reason != "" {
+			attrs = append(attrs, slog.String("reason", event.Reason))
+		}
+		s.logger.LogAttrs(ctx, slog.LevelInfo, "audit", attrs...)
+	}
+	return nil
+}
+
+// Close does nothing, the logger being the caller's.
+func (s *SlogSink) Close() error {
+	return nil
+}
+
+// HTTPSink posts each batch of events to an endpoint, e.g. a SIEM's collector, as a JSON array.
+// Responses other than 2xx fail the write.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSink returns a sink posting to the URL with the client, or http.DefaultClient if nil;
+// each write is bounded by the Auditor's WriteTimeout.
+func NewHTTPSink(url string, client *http.Client) *HTTPSink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPSink{url: url, client: client}
+}
+
+// Write posts the events.
+func (s *HTTPSink) Write(ctx context.Context, events []server.AuditEvent) error {
+	b, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("audit: %s answered %s", s.url, res.Status)
+	}
+	return nil
+}
+
+// Close does nothing, the client's connections being reused.
+func (s *HTTPSink) Close() error {
+	return nil
+}
+
+// encodeLines writes the events to w as a JSON object per line.
+func encodeLines(w io.Writer, events []server.AuditEvent) error {
+	enc := json.NewEncoder(w)
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	prolog "github.com/glauco/proglog/internal/log"
+	"github.com/glauco/proglog/internal/server"
+	"github.com/stretchr/testify/require"
+)
+
+var testEvents = []server.AuditEvent{
+	{Time: time.Unix(1700000000, 0).UTC(), Subject: "alice", Object: "orders", Action: "produce", Method: "/log.v2.Log/Produce", Peer: "10.0.0.1:5000", Allowed: true, Latency: time.Millisecond},
+	{Time: time.Unix(1700000001, 0).UTC(), Subject: "bob", Object: "orders", Action: "consume", Method: "GET /consume", Peer: "10.0.0.2:5000", Reason: "bob not permitted to consume to orders"},
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := Open(path, nil)
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, sink.Write(ctx, testEvents[:1]))
+	require.NoError(t, sink.Write(ctx, testEvents[1:]))
+	require.NoError(t, sink.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	info, err := f.Stat()
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	var events []server.AuditEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event server.AuditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.Equal(t, testEvents, events)
+}
+
+func TestCommitLogSink(t *testing.T) {
+	_, err := Open("log", nil)
+	require.ErrorContains(t, err, "log:/var/lib/proglog/audit")
+
+	dir := filepath.Join(t.TempDir(), "audit")
+	sink, err := Open("log:"+dir, nil)
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, sink.Write(ctx, testEvents[:1]))
+	require.NoError(t, sink.Write(ctx, testEvents[1:]))
+	require.NoError(t, sink.Close())
+
+	// The events are records of the commit log, which keeps them once reopened
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	log, err := prolog.NewLog(dir, prolog.Config{})
+	require.NoError(t, err)
+	defer log.Close()
+	for off, want := range testEvents {
+		record, err := log.Read(uint64(off))
+		require.NoError(t, err)
+		require.Equal(t, want.Subject, string(record.Key))
+		var event server.AuditEvent
+		require.NoError(t, json.Unmarshal(record.Value, &event))
+		require.Equal(t, want, event)
+	}
+}
+
+func TestSlogSink(t *testing.T) {
+	var b strings.Builder
+	sink, err := Open("slog", slog.New(slog.NewJSONHandler(&b, nil)))
+	require.NoError(t, err)
+	require.NoError(t, sink.Write(context.Background(), testEvents))
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], `"msg":"audit"`)
+	require.Contains(t, lines[0], `"subject":"alice"`)
+	require.Contains(t, lines[0], `"allowed":true`)
+	require.NotContains(t, lines[0], `"reason"`)
+	require.Contains(t, lines[1], `"reason":"bob not permitted to consume to orders"`)
+}
+
+func TestHTTPSink(t *testing.T) {
+	status := http.StatusNoContent
+	var got []server.AuditEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	sink, err := Open(srv.URL, nil)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, sink.Write(ctx, testEvents))
+	require.Equal(t, testEvents, got)
+
+	// Endpoints failing to take the events fail the write
+	status = http.StatusServiceUnavailable
+	require.ErrorContains(t, sink.Write(ctx, testEvents), "503")
+}
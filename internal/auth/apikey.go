@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKeyPrefix starts every API key, so keys leaked in logs or repositories are easy to find.
+const apiKeyPrefix = "plg_"
+
+// apiKeyCheckInterval is the least time between checks of the store's file for changes, e.g. keys
+// issued or revoked by another process.
+const apiKeyCheckInterval = time.Second
+
+// APIKey describes a key of an APIKeyStore. The key itself isn't kept, only its hash, so it's
+// shown once, when issued.
+type APIKey struct {
+	// ID identifies the key, e.g. to revoke it; it's the part of the key following plg_.
+	ID string `json:"id"`
+	// Subject is the subject clients authenticating with the key are authorized as.
+	Subject string `json:"subject"`
+	// Name describes what the key is for, e.g. the webhook sending it.
+	Name    string    `json:"name,omitempty"`
+	Created time.Time `json:"created"`
+	// Expires is when the key stops being accepted; keys without it don't expire.
+	Expires *time.Time `json:"expires,omitempty"`
+	Hash    string     `json:"hash"` // Hex-encoded SHA-256 hash of the key
+}
+
+// APIKeyStore issues API keys, credentials for clients that can't easily authenticate with
+// certificates, e.g. browsers and webhooks, and validates them, returning the subjects they were
+// issued to. Keys are kept hashed in a JSON file, written readable by its owner only, so reading
+// the file doesn't reveal them. Keys are random, so hashing them with SHA-256 suffices.
+//
+// The file is read again when it changes, at most every second, so keys issued or revoked by
+// another process, e.g. proglog apikey, are picked up without a restart.
+type APIKeyStore struct {
+	path string
+
+	mu      sync.Mutex
+	keys    []APIKey
+	file    os.FileInfo // The file when last read, or nil if it didn't exist
+	checked time.Time   // When the file was last checked for changes
+}
+
+// OpenAPIKeyStore returns the store of the keys in the file, which is created once a key is
+// issued if it doesn't exist.
+func OpenAPIKeyStore(path string) (*APIKeyStore, error) {
+	s := &APIKeyStore{path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Issue issues a key to the subject, described by the name, expiring after the TTL unless it's 0,
+// and returns it with its description. The key is only returned now.
+func (s *APIKeyStore) Issue(subject, name string, ttl time.Duration) (string, APIKey, error) {
+	if subject == "" {
+		return "", APIKey{}, errors.New("apikey: subject is required")
+	}
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", APIKey{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", APIKey{}, err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(id) + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	info := APIKey{
+		ID:      hex.EncodeToString(id),
+		Subject: subject,
+		Name:    name,
+		Created: time.Now().UTC().Truncate(time.Second),
+		Hash:    hashAPIKey(key),
+	}
+	if ttl > 0 {
+		expires := info.Created.Add(ttl)
+		info.Expires = &expires
+	}
+	err := s.update(func(keys []APIKey) ([]APIKey, error) {
+		return append(keys, info), nil
+	})
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	return key, info, nil
+}
+
+// Revoke revokes the key with the ID, which is then refused.
+func (s *APIKeyStore) Revoke(id string) error {
+	return s.update(func(keys []APIKey) ([]APIKey, error) {
+		i := slices.IndexFunc(keys, func(k APIKey) bool { return k.ID == id })
+		if i < 0 {
+			return nil, fmt.Errorf("apikey: no key with ID %q", id)
+		}
+		return slices.Delete(keys, i, i+1), nil
+	})
+}
+
+// Keys returns the keys issued and not revoked, expired ones included.
+func (s *APIKeyStore) Keys() ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	return slices.Clone(s.keys), nil
+}
+
+// Validate returns the subject the key was issued to, failing if the key wasn't issued by the
+// store, was revoked or expired.
+func (s *APIKeyStore) Validate(_ context.Context, key string) (string, error) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if !ok || !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", errors.New("apikey: malformed key")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Failing to read the file again, the keys last read keep being accepted
+	s.refresh()
+	hash := hashAPIKey(key)
+	for _, k := range s.keys {
+		if k.ID != id || subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash)) != 1 {
+			continue
+		}
+		if k.Expires != nil && time.Now().After(*k.Expires) {
+			return "", fmt.Errorf("apikey: key %s expired at %s", k.ID, k.Expires.Format(time.RFC3339))
+		}
+		return k.Subject, nil
+	}
+	return "", errors.New("apikey: unknown key")
+}
+
+// refresh reads the file again if it changed since last read, checking at most every second.
+func (s *APIKeyStore) refresh() error {
+	if time.Since(s.checked) < apiKeyCheckInterval {
+		return nil
+	}
+	s.checked = time.Now()
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.keys, s.file = nil, nil
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("apikey: %w", err)
+	}
+	// Stores replace the file when writing it, so a file written within the resolution of its
+	// modification time still changes
+	if s.file != nil && os.SameFile(info, s.file) && info.ModTime().Equal(s.file.ModTime()) && info.Size() == s.file.Size() {
+		return nil
+	}
+	return s.load()
+}
+
+// load reads the keys from the file, or none if it doesn't exist.
+func (s *APIKeyStore) load() error {
+	keys, file, err := readAPIKeys(s.path)
+	if err != nil {
+		return err
+	}
+	s.keys, s.file, s.checked = keys, file, time.Now()
+	return nil
+}
+
+// update reads the keys from the file, changes them with fn and writes them back, replacing the
+// file at once so readers never see it half written.
+func (s *APIKeyStore) update(fn func([]APIKey) ([]APIKey, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, _, err := readAPIKeys(s.path)
+	if err != nil {
+		return err
+	}
+	if keys, err = fn(keys); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(struct {
+		Keys []APIKey `json:"keys"`
+	}{keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("apikey: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("apikey: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("apikey: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("apikey: %w", err)
+	}
+	return s.load()
+}
+
+// readAPIKeys reads the keys from the file and returns them with the file's info, or none if it
+// doesn't exist.
+func readAPIKeys(path string) ([]APIKey, os.FileInfo, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("apikey: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("apikey: %w", err)
+	}
+	var file struct {
+		Keys []APIKey `json:"keys"`
+	}
+	if err := json.NewDecoder(f).Decode(&file); err != nil {
+		return nil, nil, fmt.Errorf("apikey: %s: %w", path, err)
+	}
+	return file.Keys, info, nil
+}
+
+// hashAPIKey returns the hex-encoded SHA-256 hash of the key.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestAPIKeyStore verifies that keys issued validate as their subjects until revoked or expired,
+// and that only their hashes are stored.
+func TestAPIKeyStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "api-keys.json")
+	store, err := OpenAPIKeyStore(path)
+	require.NoError(t, err)
+
+	key, info, err := store.Issue("webhooks", "billing webhook", 0)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(key, "plg_"+info.ID+"_"))
+	require.Nil(t, info.Expires)
+	subject, err := store.Validate(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, "webhooks", subject)
+
+	// The file holds the key's hash, not the key, and only its owner may read it
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(b), key)
+	require.Contains(t, string(b), info.Hash)
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+
+	for scenario, bogus := range map[string]string{
+		"malformed":    "bogus",
+		"unknown ID":   "plg_0000000000000000_" + strings.SplitN(key, "_", 3)[2],
+		"wrong secret": key[:len(key)-4] + "AAAA",
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			_, err := store.Validate(ctx, bogus)
+			require.Error(t, err)
+		})
+	}
+
+	// Keys expire after their TTL
+	expiring, info, err := store.Issue("browser", "", time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, info.Created.Add(time.Hour), *info.Expires)
+	keys, err := store.Keys()
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	keys[1].Expires = &keys[1].Created
+	require.NoError(t, store.update(func([]APIKey) ([]APIKey, error) { return keys, nil }))
+	_, err = store.Validate(ctx, expiring)
+	require.ErrorContains(t, err, "expired")
+
+	// Keys revoked by another store of the same file are refused once the file is checked again
+	other, err := OpenAPIKeyStore(path)
+	require.NoError(t, err)
+	require.NoError(t, other.Revoke(keys[0].ID))
+	require.Error(t, other.Revoke(keys[0].ID))
+	time.Sleep(apiKeyCheckInterval)
+	_, err = store.Validate(ctx, key)
+	require.Error(t, err)
+	_, _, err = store.Issue("", "", 0)
+	require.Error(t, err)
+}
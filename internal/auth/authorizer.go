@@ -1,29 +1,207 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"slices"
+	"sync"
 
 	"github.com/casbin/casbin"
+	api "github.com/glauco/proglog/api/v1"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 type Authorizer struct {
+	model    string       // Path of the model file
+	policy   string       // Path of the policy file
+	mu       sync.RWMutex // Guards the enforcer, whose policy SetRules and Reload reload
 	enforcer *casbin.Enforcer
+	adapter  *policyAdapter // Loads the enforcer's policy, and the quota rules
+	rules    []*api.AclRule // Rules set on top of the policy file's
+	bindings []RoleBinding  // Role bindings set on top of the policy file's
+
+	quotaMu sync.Mutex
+	quotas  quotaBuckets // Token buckets of the subjects limited by quota rules
+}
+
+// RoleBinding grants a subject a role, and so the actions the rules allow the role. Roles may be
+// bound to other roles, whose actions they inherit, e.g. an admin role to a reader role.
+type RoleBinding struct {
+	Subject string
+	Role    string
 }
 
+// ErrNoRoles is returned managing role bindings when the model doesn't define roles.
+var ErrNoRoles = errors.New("the ACL model doesn't define roles: it needs a role_definition section")
+
+// New returns an Authorizer enforcing the model and the policy file, whose lines of type q set
+// quota rules. Quota rules that don't parse are reported by Err.
 func New(model, policy string) *Authorizer {
-	enforcer := casbin.NewEnforcer(model, policy)
+	adapter := newPolicyAdapter(policy)
+	enforcer := casbin.NewEnforcer(model, adapter)
+	// Rules set at runtime come from the cluster's configuration, not the policy file
+	enforcer.EnableAutoSave(false)
 	return &Authorizer{
+		model:    model,
+		policy:   policy,
 		enforcer: enforcer,
+		adapter:  adapter,
 	}
 }
 
+// Err returns the error loading the policy file's quota rules, if the last load failed, e.g. as
+// New can't return it.
+func (a *Authorizer) Err() error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.adapter.err
+}
+
 func (a *Authorizer) Authorize(subject, object, action string) error {
-	if !a.enforcer.Enforce(subject, object, action) {
+	a.mu.RLock()
+	allowed := a.enforcer.Enforce(subject, object, action)
+	a.mu.RUnlock()
+	if !allowed {
 		msg := fmt.Sprintf("%s not permitted to %s to %s", subject, action, object)
-		st := status.New(codes.PermissionDenied, msg)
-		return st.Err()
+		return api.NewError(codes.PermissionDenied, api.ReasonUnauthorized, msg, map[string]string{
+			"subject": subject,
+			"object":  object,
+			"action":  action,
+		})
+	}
+	return nil
+}
+
+// SetRules replaces the rules added to the policy file's, e.g. with those of the cluster's
+// configuration. The policy file is reloaded, so the rules previously set are dropped.
+func (a *Authorizer) SetRules(rules []*api.AclRule) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enforcer.LoadPolicy(); err != nil {
+		return fmt.Errorf("reload policy: %w", err)
+	}
+	for _, rule := range rules {
+		a.enforcer.AddPolicy(rule.Subject, rule.Object, rule.Action)
+	}
+	a.rules = rules
+	// Reloading the policy dropped the bindings set too
+	addRoleBindings(a.enforcer, a.bindings)
+	return nil
+}
+
+// AddRoleBinding binds the subject to the role on top of the policy file's bindings, and keeps the
+// binding when the rules are set or the files reloaded. Binding it again does nothing.
+func (a *Authorizer) AddRoleBinding(subject, role string) error {
+	if subject == "" || role == "" {
+		return errors.New("role bindings need a subject and a role")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !hasRoles(a.enforcer) {
+		return ErrNoRoles
+	}
+	binding := RoleBinding{Subject: subject, Role: role}
+	if !slices.Contains(a.bindings, binding) {
+		a.bindings = append(a.bindings, binding)
+	}
+	a.enforcer.AddGroupingPolicy(subject, role)
+	return nil
+}
+
+// RemoveRoleBinding removes a binding of the subject to the role added with AddRoleBinding. The
+// policy file's bindings can't be removed, and are kept.
+func (a *Authorizer) RemoveRoleBinding(subject, role string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !hasRoles(a.enforcer) {
+		return ErrNoRoles
+	}
+	i := slices.Index(a.bindings, RoleBinding{Subject: subject, Role: role})
+	if i < 0 {
+		return nil
+	}
+	a.bindings = slices.Delete(a.bindings, i, i+1)
+	// The policy file may bind the subject to the role too
+	if err := a.enforcer.LoadPolicy(); err != nil {
+		return fmt.Errorf("reload policy: %w", err)
+	}
+	for _, rule := range a.rules {
+		a.enforcer.AddPolicy(rule.Subject, rule.Object, rule.Action)
+	}
+	addRoleBindings(a.enforcer, a.bindings)
+	return nil
+}
+
+// RoleBindings returns the role bindings, those of the policy file then those added.
+func (a *Authorizer) RoleBindings() []RoleBinding {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if !hasRoles(a.enforcer) {
+		return nil
+	}
+	var bindings []RoleBinding
+	for _, g := range a.enforcer.GetGroupingPolicy() {
+		bindings = append(bindings, RoleBinding{Subject: g[0], Role: g[1]})
+	}
+	return bindings
+}
+
+// Roles returns the roles the subject holds, those bound to it and those they inherit.
+func (a *Authorizer) Roles(subject string) []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if !hasRoles(a.enforcer) {
+		return nil
+	}
+	return a.enforcer.GetImplicitRolesForUser(subject)
+}
+
+// hasRoles reports whether the enforcer's model defines roles.
+func hasRoles(enforcer *casbin.Enforcer) bool {
+	_, ok := enforcer.GetModel()["g"]["g"]
+	return ok
+}
+
+// addRoleBindings adds the bindings to the enforcer's, if its model defines roles.
+func addRoleBindings(enforcer *casbin.Enforcer, bindings []RoleBinding) {
+	if !hasRoles(enforcer) {
+		return
+	}
+	for _, binding := range bindings {
+		enforcer.AddGroupingPolicy(binding.Subject, binding.Role)
+	}
+}
+
+// Reload reads the model and policy files again, e.g. once an operator edited them, keeping the
+// rules set on top. If the files can't be read, e.g. as they're malformed, it fails and the
+// Authorizer keeps authorizing with the previous ones.
+func (a *Authorizer) Reload() error {
+	for _, path := range []string{a.model, a.policy} {
+		// The enforcer loads missing files as empty, which would deny everything
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+	}
+	adapter := newPolicyAdapter(a.policy)
+	enforcer, err := casbin.NewEnforcerSafe(a.model, adapter)
+	if err == nil {
+		err = adapter.err
+	}
+	if err != nil {
+		return fmt.Errorf("load %s and %s: %w", a.model, a.policy, err)
+	}
+	enforcer.EnableAutoSave(false)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, rule := range a.rules {
+		enforcer.AddPolicy(rule.Subject, rule.Object, rule.Action)
 	}
+	addRoleBindings(enforcer, a.bindings)
+	a.enforcer, a.adapter = enforcer, adapter
+	// Subjects start over with full buckets under the quota rules reloaded
+	a.quotaMu.Lock()
+	a.quotas = nil
+	a.quotaMu.Unlock()
 	return nil
 }
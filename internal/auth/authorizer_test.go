@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestAuthorizeTopics verifies that policies can grant actions on some topics and not others.
+func TestAuthorizeTopics(t *testing.T) {
+	dir := t.TempDir()
+
+	// Write a policy granting a service produce on one topic and root everything via wildcards
+	policy := filepath.Join(dir, "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte(
+		"p, orders-service, orders, produce\n"+
+			"p, root, *, produce\n"+
+			"p, root, *, consume\n",
+	), 0644))
+
+	authorizer := New("../../test/model.conf", policy)
+
+	// The service may produce to its own topic only
+	require.NoError(t, authorizer.Authorize("orders-service", "orders", "produce"))
+	err := authorizer.Authorize("orders-service", "payments", "produce")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	err = authorizer.Authorize("orders-service", "orders", "consume")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Wildcard objects match every topic
+	require.NoError(t, authorizer.Authorize("root", "payments", "produce"))
+	require.NoError(t, authorizer.Authorize("root", "orders", "consume"))
+}
+
+// TestSetRules verifies that rules set at runtime add to the policy file's and replace each other.
+func TestSetRules(t *testing.T) {
+	dir := t.TempDir()
+	policy := filepath.Join(dir, "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte("p, root, *, produce\n"), 0644))
+	authorizer := New("../../test/model.conf", policy)
+
+	// Rules set grant their actions on top of the policy file's
+	require.NoError(t, authorizer.SetRules([]*api.AclRule{{Subject: "nobody", Object: "orders", Action: "consume"}}))
+	require.NoError(t, authorizer.Authorize("nobody", "orders", "consume"))
+	require.NoError(t, authorizer.Authorize("root", "orders", "produce"))
+
+	// Setting rules again drops the previous ones, and leaves the policy file untouched
+	require.NoError(t, authorizer.SetRules(nil))
+	err := authorizer.Authorize("nobody", "orders", "consume")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.NoError(t, authorizer.Authorize("root", "orders", "produce"))
+	b, err := os.ReadFile(policy)
+	require.NoError(t, err)
+	require.Equal(t, "p, root, *, produce\n", string(b))
+}
+
+// TestReload verifies that reloading reads the policy file again, keeping the rules set, and
+// keeps the previous policy when the file can't be read.
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	policy := filepath.Join(dir, "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte("p, root, *, produce\n"), 0644))
+	authorizer := New("../../test/model.conf", policy)
+	require.NoError(t, authorizer.SetRules([]*api.AclRule{{Subject: "nobody", Object: "orders", Action: "consume"}}))
+
+	// The edited policy file replaces the previous one's rules
+	require.NoError(t, os.WriteFile(policy, []byte("p, orders-service, orders, produce\n"), 0644))
+	require.NoError(t, authorizer.Reload())
+	require.NoError(t, authorizer.Authorize("orders-service", "orders", "produce"))
+	err := authorizer.Authorize("root", "orders", "produce")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.NoError(t, authorizer.Authorize("nobody", "orders", "consume"))
+
+	// A policy that can't be read leaves the previous one in place
+	require.NoError(t, os.WriteFile(policy, []byte(",\n"), 0644))
+	require.Error(t, authorizer.Reload())
+	require.NoError(t, os.Remove(policy))
+	require.Error(t, authorizer.Reload())
+	require.NoError(t, authorizer.Authorize("orders-service", "orders", "produce"))
+}
+
+// TestRoles verifies that subjects hold the permissions of the roles they're bound to, and of the
+// roles those inherit, whether bound by the policy file or at runtime.
+func TestRoles(t *testing.T) {
+	dir := t.TempDir()
+	policy := filepath.Join(dir, "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte(
+		"p, orders-reader, orders, consume\n"+
+			"p, orders-writer, orders, produce\n"+
+			"g, orders-writer, orders-reader\n"+
+			"g, alice, orders-writer\n",
+	), 0644))
+	authorizer := New("../../test/model.conf", policy)
+
+	// Roles grant their permissions, and inherit those of the roles they're bound to
+	require.NoError(t, authorizer.Authorize("alice", "orders", "produce"))
+	require.NoError(t, authorizer.Authorize("alice", "orders", "consume"))
+	require.ElementsMatch(t, []string{"orders-writer", "orders-reader"}, authorizer.Roles("alice"))
+	err := authorizer.Authorize("bob", "orders", "consume")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Bindings added at runtime survive rules being set and the files being reloaded
+	require.NoError(t, authorizer.AddRoleBinding("bob", "orders-reader"))
+	require.NoError(t, authorizer.AddRoleBinding("bob", "orders-reader"))
+	require.NoError(t, authorizer.SetRules([]*api.AclRule{{Subject: "orders-reader", Object: "payments", Action: "consume"}}))
+	require.NoError(t, authorizer.Reload())
+	require.NoError(t, authorizer.Authorize("bob", "orders", "consume"))
+	require.NoError(t, authorizer.Authorize("bob", "payments", "consume"))
+	err = authorizer.Authorize("bob", "orders", "produce")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.Equal(t, []RoleBinding{
+		{Subject: "orders-writer", Role: "orders-reader"},
+		{Subject: "alice", Role: "orders-writer"},
+		{Subject: "bob", Role: "orders-reader"},
+	}, authorizer.RoleBindings())
+
+	// Removing a binding revokes the role's permissions, but the policy file's bindings stay
+	require.NoError(t, authorizer.RemoveRoleBinding("bob", "orders-reader"))
+	err = authorizer.Authorize("bob", "orders", "consume")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.NoError(t, authorizer.RemoveRoleBinding("alice", "orders-writer"))
+	require.NoError(t, authorizer.Authorize("alice", "payments", "consume"))
+	require.Error(t, authorizer.AddRoleBinding("", "orders-reader"))
+}
+
+// TestRolesUndefined verifies that models without roles authorize subjects by their own rules,
+// and refuse role bindings.
+func TestRolesUndefined(t *testing.T) {
+	dir := t.TempDir()
+	model := filepath.Join(dir, "model.conf")
+	require.NoError(t, os.WriteFile(model, []byte(
+		"[request_definition]\nr = sub, obj, act\n"+
+			"[policy_definition]\np = sub, obj, act\n"+
+			"[policy_effect]\ne = some(where (p.eft == allow))\n"+
+			"[matchers]\nm = r.sub == p.sub && keyMatch(r.obj, p.obj) && r.act == p.act\n",
+	), 0644))
+	policy := filepath.Join(dir, "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte("p, root, *, produce\n"), 0644))
+	authorizer := New(model, policy)
+
+	require.NoError(t, authorizer.Authorize("root", "orders", "produce"))
+	require.ErrorIs(t, authorizer.AddRoleBinding("root", "admin"), ErrNoRoles)
+	require.Nil(t, authorizer.Roles("root"))
+	require.NoError(t, authorizer.SetRules(nil))
+}
@@ -0,0 +1,461 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// JWTValidator defaults.
+const (
+	defaultSubjectClaim    = "sub"
+	defaultJWTLeeway       = time.Minute
+	defaultJWKSRefresh     = time.Hour
+	minJWKSRefreshInterval = 30 * time.Second // Least time between refreshes for unknown key IDs
+	defaultJWKSTimeout     = 10 * time.Second // How long fetching the set may take
+)
+
+// JWTConfig configures a JWTValidator.
+type JWTConfig struct {
+	// Issuer is the iss claim tokens must have, e.g. https://login.example.com/.
+	Issuer string
+	// Audience is the audience tokens must be issued for, in their aud claim, e.g. proglog.
+	Audience string
+	// JWKSURL is the URL of the JSON Web Key Set holding the issuer's public keys, e.g. its
+	// jwks_uri. JWKSFile reads the set from a file instead, e.g. for issuers without one. Exactly
+	// one of them must be set.
+	JWKSURL  string
+	JWKSFile string
+	// SubjectClaim is the claim holding the subject tokens are authorized as; defaults to sub.
+	// Other claims, e.g. email or client_id, name subjects as the issuer knows them.
+	SubjectClaim string
+	// Leeway is how far the clocks of the issuer and the server may drift apart when checking
+	// tokens' expiry and not-before times; defaults to a minute.
+	Leeway time.Duration
+	// RefreshInterval is how often the key set is fetched again; defaults to an hour. Tokens
+	// signed with a key the set doesn't hold fetch it again right away, at most every 30
+	// seconds, so keys the issuer rotates in are picked up.
+	RefreshInterval time.Duration
+	// HTTPClient fetches JWKSURL; defaults to a client timing out after 10 seconds. Fetches are
+	// bounded by its timeout, or 10 seconds if it has none.
+	HTTPClient *http.Client
+}
+
+// JWTValidator validates JSON Web Tokens, bearer tokens that clients without certificates
+// authenticate with, and returns their subjects. Tokens must be signed with one of the issuer's
+// keys, with RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512 or EdDSA, and have the
+// configured issuer and audience, an expiry in the future and, if they have one, a not-before time
+// in the past.
+type JWTValidator struct {
+	config JWTConfig
+	fetch  singleflight.Group // Fetches of the set, shared by the tokens waiting for them
+
+	mu      sync.Mutex
+	keys    []jwk     // Keys of the set last fetched
+	fetched time.Time // When the set was last fetched, whether it succeeded or not
+	err     error     // Why the set last failed to be fetched
+}
+
+// NewJWTValidator returns a validator of the tokens the config describes. The key set is fetched
+// when the first token is validated, so servers start while the issuer is unavailable.
+func NewJWTValidator(config JWTConfig) (*JWTValidator, error) {
+	if config.Issuer == "" || config.Audience == "" {
+		return nil, errors.New("jwt: issuer and audience are required")
+	}
+	if (config.JWKSURL == "") == (config.JWKSFile == "") {
+		return nil, errors.New("jwt: exactly one of the JWKS URL and file is required")
+	}
+	if config.SubjectClaim == "" {
+		config.SubjectClaim = defaultSubjectClaim
+	}
+	if config.Leeway <= 0 {
+		config.Leeway = defaultJWTLeeway
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = defaultJWKSRefresh
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: defaultJWKSTimeout}
+	}
+	return &JWTValidator{config: config}, nil
+}
+
+// jwtHeader is the header of a token.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Validate checks the token's signature and claims, and returns its subject.
+func (v *JWTValidator) Validate(ctx context.Context, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("jwt: malformed token")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", fmt.Errorf("jwt: header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("jwt: signature: %w", err)
+	}
+	keys, err := v.keysFor(ctx, header.Kid)
+	if err != nil {
+		return "", err
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if key.Alg != "" && key.Alg != header.Alg {
+			continue
+		}
+		if err := verifySignature(header.Alg, key.public, signed, signature); err == nil {
+			verified = true
+			break
+		} else if errors.Is(err, errUnsupportedAlg) {
+			return "", err
+		}
+	}
+	if !verified {
+		return "", errors.New("jwt: invalid signature")
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", fmt.Errorf("jwt: claims: %w", err)
+	}
+	return v.checkClaims(claims)
+}
+
+// checkClaims checks the token's issuer, audience and validity period, and returns its subject.
+func (v *JWTValidator) checkClaims(claims map[string]any) (string, error) {
+	if iss, _ := claims["iss"].(string); iss != v.config.Issuer {
+		return "", fmt.Errorf("jwt: issuer %q isn't %q", iss, v.config.Issuer)
+	}
+	var audiences []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+	if !slices.Contains(audiences, v.config.Audience) {
+		return "", fmt.Errorf("jwt: token isn't issued for %q", v.config.Audience)
+	}
+	now := time.Now()
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return "", errors.New("jwt: token has no expiry")
+	}
+	if now.After(exp.Add(v.config.Leeway)) {
+		return "", fmt.Errorf("jwt: token expired at %s", exp.UTC().Format(time.RFC3339))
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(v.config.Leeway).Before(nbf) {
+		return "", fmt.Errorf("jwt: token isn't valid before %s", nbf.UTC().Format(time.RFC3339))
+	}
+	subject, _ := claims[v.config.SubjectClaim].(string)
+	if subject == "" {
+		return "", fmt.Errorf("jwt: token has no %s claim", v.config.SubjectClaim)
+	}
+	return subject, nil
+}
+
+// numericDate returns the time of a NumericDate claim, in seconds since the epoch.
+func numericDate(claim any) (time.Time, bool) {
+	seconds, ok := claim.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// decodeSegment decodes a base64url-encoded JSON segment of a token.
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// keysFor returns the keys the token with the key ID may be signed with: the key with that ID, or
+// every key if the token names none. The set is fetched again if it's stale, or doesn't hold the
+// key and wasn't fetched in the last 30 seconds.
+//
+// The set is fetched without holding the lock, once for all the tokens needing it, and detached
+// from their requests: a client giving up only stops waiting for it, rather than failing the fetch
+// for every token until the next refresh.
+func (v *JWTValidator) keysFor(ctx context.Context, kid string) ([]jwk, error) {
+	if v.needsRefresh(kid) {
+		select {
+		case <-v.fetch.DoChan("jwks", func() (any, error) {
+			v.refresh()
+			return nil, nil
+		}):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("jwt: fetch keys: %w", ctx.Err())
+		}
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	// Keys fetched before keep validating tokens while the set can't be fetched again
+	if v.keys == nil && v.err != nil {
+		return nil, fmt.Errorf("jwt: fetch keys: %w", v.err)
+	}
+	if kid == "" {
+		return v.keys, nil
+	}
+	for _, key := range v.keys {
+		if key.Kid == kid {
+			return []jwk{key}, nil
+		}
+	}
+	return nil, fmt.Errorf("jwt: unknown key ID %q", kid)
+}
+
+// needsRefresh reports whether the set must be fetched again for the key ID: if it's stale, or
+// doesn't hold the key, or failed to be fetched, and wasn't fetched in the last 30 seconds.
+func (v *JWTValidator) needsRefresh(kid string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	since := time.Since(v.fetched)
+	if v.fetched.IsZero() || since >= v.config.RefreshInterval {
+		return true
+	}
+	return since >= minJWKSRefreshInterval && (v.err != nil || kid != "" && !hasKey(v.keys, kid))
+}
+
+// refresh fetches the set, bounded by the HTTP client's timeout, and records the keys or why they
+// failed to be fetched.
+func (v *JWTValidator) refresh() {
+	timeout := v.config.HTTPClient.Timeout
+	if timeout <= 0 {
+		timeout = defaultJWKSTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	keys, err := v.fetchKeys(ctx)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.fetched, v.err = time.Now(), err
+	if err == nil {
+		v.keys = keys
+	}
+}
+
+// hasKey returns whether the keys hold the key ID.
+func hasKey(keys []jwk, kid string) bool {
+	return slices.ContainsFunc(keys, func(key jwk) bool { return key.Kid == kid })
+}
+
+// fetchKeys reads the key set from its URL or file.
+func (v *JWTValidator) fetchKeys(ctx context.Context) ([]jwk, error) {
+	var data []byte
+	if v.config.JWKSFile != "" {
+		b, err := os.ReadFile(v.config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		data = b
+	} else {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.config.JWKSURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := v.config.HTTPClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s: %s", v.config.JWKSURL, res.Status)
+		}
+		if data, err = io.ReadAll(io.LimitReader(res.Body, 1<<20)); err != nil {
+			return nil, err
+		}
+	}
+	return parseJWKS(data)
+}
+
+// jwk is a JSON Web Key, holding an issuer's public key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA keys
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP keys
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	public crypto.PublicKey
+}
+
+// parseJWKS parses a JSON Web Key Set. Keys that aren't for signatures, or whose type isn't RSA,
+// EC or Ed25519, are skipped.
+func parseJWKS(data []byte) ([]jwk, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse JWKS: %w", err)
+	}
+	var keys []jwk
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		public, err := key.publicKey()
+		if errors.Is(err, errUnsupportedKey) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("parse JWKS key %q: %w", key.Kid, err)
+		}
+		key.public = public
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("parse JWKS: no signing keys")
+	}
+	return keys, nil
+}
+
+// ecdsaCurves are the curves of the keys each ECDSA algorithm signs with.
+var ecdsaCurves = map[string]string{"ES256": "P-256", "ES384": "P-384", "ES512": "P-521"}
+
+var (
+	errUnsupportedKey = errors.New("unsupported key type")
+	errUnsupportedAlg = errors.New("jwt: unsupported algorithm")
+)
+
+// publicKey returns the key's public key.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("invalid key parameter %q", s)
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errUnsupportedKey
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point isn't on the curve")
+		}
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errUnsupportedKey
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errUnsupportedKey
+}
+
+// verifySignature verifies the signature of the signed part of a token with the algorithm and
+// key. It fails with errUnsupportedAlg for other algorithms, e.g. none or HS256, which would let
+// anyone holding the public key sign tokens.
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+		key, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(key, signed, signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w %q", errUnsupportedAlg, alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "RS") {
+			return rsa.VerifyPKCS1v15(key, hash, digest, signature)
+		} else if strings.HasPrefix(alg, "PS") {
+			return rsa.VerifyPSS(key, hash, digest, signature, nil)
+		}
+	case *ecdsa.PublicKey:
+		// Signatures are the key-sized big-endian r and s concatenated
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if ecdsaCurves[alg] != key.Curve.Params().Name || len(signature) != 2*size {
+			break
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if ecdsa.Verify(key, digest, r, s) {
+			return nil
+		}
+	}
+	return errors.New("invalid signature")
+}
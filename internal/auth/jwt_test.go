@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testKey is a signing key of an issuer, with the JWK of its public key.
+type testKey struct {
+	kid    string
+	alg    string
+	signer crypto.Signer
+}
+
+func newTestKeys(t *testing.T) map[string]testKey {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return map[string]testKey{
+		"RS256": {"rsa", "RS256", rsaKey},
+		"PS256": {"rsa", "PS256", rsaKey},
+		"ES256": {"ec", "ES256", ecKey},
+		"EdDSA": {"ed", "EdDSA", edKey},
+	}
+}
+
+// jwks returns the JSON Web Key Set of the keys' public keys.
+func jwks(t *testing.T, keys ...testKey) []byte {
+	t.Helper()
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	var set []map[string]string
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if seen[key.kid] {
+			continue
+		}
+		seen[key.kid] = true
+		switch public := key.signer.Public().(type) {
+		case *rsa.PublicKey:
+			set = append(set, map[string]string{"kty": "RSA", "kid": key.kid, "use": "sig",
+				"n": b64(public.N.Bytes()), "e": b64(big.NewInt(int64(public.E)).Bytes())})
+		case *ecdsa.PublicKey:
+			set = append(set, map[string]string{"kty": "EC", "kid": key.kid, "crv": "P-256",
+				"x": b64(public.X.FillBytes(make([]byte, 32))), "y": b64(public.Y.FillBytes(make([]byte, 32)))})
+		case ed25519.PublicKey:
+			set = append(set, map[string]string{"kty": "OKP", "kid": key.kid, "crv": "Ed25519", "x": b64(public)})
+		}
+	}
+	b, err := json.Marshal(map[string]any{"keys": set})
+	require.NoError(t, err)
+	return b
+}
+
+// sign returns a token of the claims signed with the key.
+func sign(t *testing.T, key testKey, claims map[string]any) string {
+	t.Helper()
+	b64 := func(v any) string {
+		b, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := b64(map[string]string{"alg": key.alg, "kid": key.kid, "typ": "JWT"}) + "." + b64(claims)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	var err error
+	switch signer := key.signer.(type) {
+	case *rsa.PrivateKey:
+		if key.alg == "PS256" {
+			signature, err = rsa.SignPSS(rand.Reader, signer, crypto.SHA256, digest[:], nil)
+		} else {
+			signature, err = rsa.SignPKCS1v15(rand.Reader, signer, crypto.SHA256, digest[:])
+		}
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, signer, digest[:])
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(signer, []byte(signed))
+	}
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// tamper returns the token with its claims replaced, keeping its header and signature.
+func tamper(token string, claims map[string]any) string {
+	parts := strings.Split(token, ".")
+	b, _ := json.Marshal(claims)
+	return parts[0] + "." + base64.RawURLEncoding.EncodeToString(b) + "." + parts[2]
+}
+
+func TestJWTValidator(t *testing.T) {
+	keys := newTestKeys(t)
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(jwksFile, jwks(t, keys["RS256"], keys["ES256"], keys["EdDSA"]), 0600))
+	validator, err := NewJWTValidator(JWTConfig{Issuer: "https://issuer", Audience: "proglog", JWKSFile: jwksFile})
+	require.NoError(t, err)
+	emailValidator, err := NewJWTValidator(JWTConfig{Issuer: "https://issuer", Audience: "proglog", JWKSFile: jwksFile, SubjectClaim: "email"})
+	require.NoError(t, err)
+
+	now := time.Now().Unix()
+	claims := func(fn func(c map[string]any)) map[string]any {
+		c := map[string]any{"iss": "https://issuer", "aud": "proglog", "sub": "alice", "exp": now + 60}
+		if fn != nil {
+			fn(c)
+		}
+		return c
+	}
+	for scenario, test := range map[string]struct {
+		validator *JWTValidator
+		token     string
+		subject   string
+		err       string
+	}{
+		"RS256":          {token: sign(t, keys["RS256"], claims(nil)), subject: "alice"},
+		"PS256":          {token: sign(t, keys["PS256"], claims(nil)), subject: "alice"},
+		"ES256":          {token: sign(t, keys["ES256"], claims(nil)), subject: "alice"},
+		"EdDSA":          {token: sign(t, keys["EdDSA"], claims(nil)), subject: "alice"},
+		"audience array": {token: sign(t, keys["ES256"], claims(func(c map[string]any) { c["aud"] = []string{"other", "proglog"} })), subject: "alice"},
+		"subject claim": {
+			validator: emailValidator,
+			token:     sign(t, keys["ES256"], claims(func(c map[string]any) { c["email"] = "alice@example.com" })),
+			subject:   "alice@example.com",
+		},
+		"expired within leeway": {token: sign(t, keys["ES256"], claims(func(c map[string]any) { c["exp"] = now - 10 })), subject: "alice"},
+		"expired":               {token: sign(t, keys["ES256"], claims(func(c map[string]any) { c["exp"] = now - 120 })), err: "expired"},
+		"no expiry":             {token: sign(t, keys["ES256"], claims(func(c map[string]any) { delete(c, "exp") })), err: "no expiry"},
+		"not yet valid":         {token: sign(t, keys["ES256"], claims(func(c map[string]any) { c["nbf"] = now + 120 })), err: "isn't valid before"},
+		"other issuer":          {token: sign(t, keys["ES256"], claims(func(c map[string]any) { c["iss"] = "https://evil" })), err: "issuer"},
+		"other audience":        {token: sign(t, keys["ES256"], claims(func(c map[string]any) { c["aud"] = "other" })), err: "isn't issued for"},
+		"no subject":            {token: sign(t, keys["ES256"], claims(func(c map[string]any) { delete(c, "sub") })), err: "no sub claim"},
+		"unknown key":           {token: sign(t, testKey{"other", "ES256", keys["ES256"].signer}, claims(nil)), err: "unknown key ID"},
+		"wrong key":             {token: sign(t, testKey{"ec", "EdDSA", keys["EdDSA"].signer}, claims(nil)), err: "invalid signature"},
+		"tampered":              {token: tamper(sign(t, keys["ES256"], claims(nil)), claims(func(c map[string]any) { c["sub"] = "root" })), err: "invalid signature"},
+		"unsigned":              {token: "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"root"}`)) + ".", err: "unsupported algorithm"},
+		"HS256":                 {token: sign(t, testKey{"rsa", "HS256", keys["ES256"].signer}, claims(nil)), err: "unsupported algorithm"},
+		"malformed":             {token: "not a token", err: "malformed"},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			v := test.validator
+			if v == nil {
+				v = validator
+			}
+			subject, err := v.Validate(context.Background(), test.token)
+			if test.err != "" {
+				require.ErrorContains(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.subject, subject)
+		})
+	}
+}
+
+func TestJWTValidatorRefresh(t *testing.T) {
+	keys := newTestKeys(t)
+	var set atomic.Value
+	set.Store(jwks(t, keys["ES256"]))
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write(set.Load().([]byte))
+	}))
+	defer srv.Close()
+	validator, err := NewJWTValidator(JWTConfig{Issuer: "https://issuer", Audience: "proglog", JWKSURL: srv.URL})
+	require.NoError(t, err)
+	claims := map[string]any{"iss": "https://issuer", "aud": "proglog", "sub": "alice", "exp": time.Now().Unix() + 60}
+	ctx := context.Background()
+
+	// The set is fetched once, then cached
+	for i := 0; i < 2; i++ {
+		_, err = validator.Validate(ctx, sign(t, keys["ES256"], claims))
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(1), fetches.Load())
+
+	// Keys rotated in are fetched, though not more than every 30 seconds
+	set.Store(jwks(t, keys["ES256"], keys["EdDSA"]))
+	_, err = validator.Validate(ctx, sign(t, keys["EdDSA"], claims))
+	require.ErrorContains(t, err, "unknown key ID")
+	validator.fetched = validator.fetched.Add(-minJWKSRefreshInterval)
+	subject, err := validator.Validate(ctx, sign(t, keys["EdDSA"], claims))
+	require.NoError(t, err)
+	require.Equal(t, "alice", subject)
+	require.Equal(t, int32(2), fetches.Load())
+
+	// Keys fetched before keep validating tokens while the issuer is down
+	srv.Close()
+	validator.fetched = validator.fetched.Add(-defaultJWKSRefresh)
+	_, err = validator.Validate(ctx, sign(t, keys["ES256"], claims))
+	require.NoError(t, err)
+
+	// The config must name the issuer, audience and keys
+	_, err = NewJWTValidator(JWTConfig{Issuer: "https://issuer", Audience: "proglog"})
+	require.Error(t, err)
+	_, err = NewJWTValidator(JWTConfig{Issuer: "https://issuer", JWKSURL: srv.URL})
+	require.Error(t, err)
+}
+
+// TestJWTValidatorCancelledFetch verifies that clients giving up while the set is being fetched
+// neither block the others nor fail the fetch, which completes for the tokens validated next.
+func TestJWTValidatorCancelledFetch(t *testing.T) {
+	keys := newTestKeys(t)
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		w.Write(jwks(t, keys["ES256"]))
+	}))
+	defer srv.Close()
+	validator, err := NewJWTValidator(JWTConfig{Issuer: "https://issuer", Audience: "proglog", JWKSURL: srv.URL})
+	require.NoError(t, err)
+	token := sign(t, keys["ES256"], map[string]any{"iss": "https://issuer", "aud": "proglog", "sub": "alice", "exp": time.Now().Unix() + 60})
+
+	// A client cancelling stops waiting for the fetch, and others waiting aren't held up by it
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = validator.Validate(ctx, token)
+	require.ErrorIs(t, err, context.Canceled)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = validator.Validate(ctx, token)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The fetch they shared carries on, and isn't recorded as failed
+	close(release)
+	subject, err := validator.Validate(context.Background(), token)
+	require.NoError(t, err)
+	require.Equal(t, "alice", subject)
+	require.Equal(t, int32(1), fetches.Load())
+}
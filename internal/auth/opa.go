@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"google.golang.org/grpc/codes"
+)
+
+// OPAAuthorizer defaults.
+const (
+	defaultOPATimeout   = 2 * time.Second
+	maxOPACachedResults = 10000 // Most decisions cached before the cache is emptied
+)
+
+// OPAConfig configures an OPAAuthorizer.
+type OPAConfig struct {
+	// URL is the URL of the rule deciding requests in OPA's Data API, e.g.
+	// http://127.0.0.1:8181/v1/data/proglog/authz for the proglog.authz package. OPA may run
+	// as a sidecar loading local Rego files, e.g. opa run --server policy.rego, or remotely.
+	URL string
+	// Token, if set, authenticates the queries to OPA servers started with
+	// --authentication=token.
+	Token string
+	// Timeout bounds each query; defaults to 2 seconds.
+	Timeout time.Duration
+	// CacheTTL is how long decisions are cached, so requests don't each query OPA; decisions
+	// aren't cached when 0, and policies changed in OPA apply once the cached ones expire.
+	CacheTTL time.Duration
+	// HTTPClient sends the queries; defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// OPAAuthorizer authorizes requests with an Open Policy Agent policy, for organizations
+// standardizing their policies on Rego rather than Casbin. It queries the rule at the config's
+// URL with the input {"subject": ..., "object": ..., "action": ...}. The rule allows the request
+// if its result is true, or an object whose allow field is true; an object's reason field, if
+// set, explains denials. Undefined results, e.g. as the package doesn't exist, deny requests,
+// and so do failures to query OPA, which are Unavailable errors rather than PermissionDenied.
+type OPAAuthorizer struct {
+	config OPAConfig
+
+	mu    sync.Mutex
+	cache map[opaInput]opaDecision
+}
+
+// opaInput is the input of the queries, naming the request authorized.
+type opaInput struct {
+	Subject string `json:"subject"`
+	Object  string `json:"object"`
+	Action  string `json:"action"`
+}
+
+// opaDecision is a decision cached until it expires.
+type opaDecision struct {
+	allowed bool
+	reason  string
+	expires time.Time
+}
+
+// NewOPAAuthorizer returns an authorizer querying the rule at the config's URL.
+func NewOPAAuthorizer(config OPAConfig) (*OPAAuthorizer, error) {
+	if config.URL == "" {
+		return nil, errors.New("opa: URL is required")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultOPATimeout
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	return &OPAAuthorizer{config: config, cache: make(map[opaInput]opaDecision)}, nil
+}
+
+// Authorize decides whether the subject may act on the object, as OPA's policy or the cache does.
+func (a *OPAAuthorizer) Authorize(subject, object, action string) error {
+	input := opaInput{Subject: subject, Object: object, Action: action}
+	decision, ok := a.cached(input)
+	if !ok {
+		var err error
+		if decision, err = a.query(input); err != nil {
+			return api.NewError(codes.Unavailable, api.ReasonUnavailable, "authorization failed: "+err.Error(), nil)
+		}
+		a.store(input, decision)
+	}
+	if !decision.allowed {
+		msg := fmt.Sprintf("%s not permitted to %s to %s", subject, action, object)
+		if decision.reason != "" {
+			msg += ": " + decision.reason
+		}
+		return api.NewError(codes.PermissionDenied, api.ReasonUnauthorized, msg, map[string]string{
+			"subject": subject,
+			"object":  object,
+			"action":  action,
+		})
+	}
+	return nil
+}
+
+// cached returns the decision cached for the input, unless it expired.
+func (a *OPAAuthorizer) cached(input opaInput) (opaDecision, bool) {
+	if a.config.CacheTTL <= 0 {
+		return opaDecision{}, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	decision, ok := a.cache[input]
+	if !ok || time.Now().After(decision.expires) {
+		return opaDecision{}, false
+	}
+	return decision, true
+}
+
+// store caches the decision for the input, emptying the cache first if it's full.
+func (a *OPAAuthorizer) store(input opaInput, decision opaDecision) {
+	if a.config.CacheTTL <= 0 {
+		return
+	}
+	decision.expires = time.Now().Add(a.config.CacheTTL)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.cache) >= maxOPACachedResults {
+		clear(a.cache)
+	}
+	a.cache[input] = decision
+}
+
+// query asks OPA for the decision on the input.
+func (a *OPAAuthorizer) query(input opaInput) (opaDecision, error) {
+	b, err := json.Marshal(map[string]any{"input": input})
+	if err != nil {
+		return opaDecision{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.config.URL, bytes.NewReader(b))
+	if err != nil {
+		return opaDecision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.config.Token)
+	}
+	res, err := a.config.HTTPClient.Do(req)
+	if err != nil {
+		return opaDecision{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+		return opaDecision{}, fmt.Errorf("opa answered %s", res.Status)
+	}
+	var body struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil {
+		return opaDecision{}, fmt.Errorf("decode opa's response: %w", err)
+	}
+	return parseOPAResult(body.Result)
+}
+
+// parseOPAResult returns the decision of a rule's result: true, or an object with an allow field
+// and an optional reason. Undefined and other results deny.
+func parseOPAResult(result json.RawMessage) (opaDecision, error) {
+	if len(result) == 0 {
+		return opaDecision{reason: "the policy's decision is undefined"}, nil
+	}
+	var allowed bool
+	if err := json.Unmarshal(result, &allowed); err == nil {
+		return opaDecision{allowed: allowed}, nil
+	}
+	var decision struct {
+		Allow  bool   `json:"allow"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(result, &decision); err != nil {
+		return opaDecision{}, fmt.Errorf("the policy's decision is neither a boolean nor an object: %s", result)
+	}
+	return opaDecision{allowed: decision.Allow, reason: decision.Reason}, nil
+}
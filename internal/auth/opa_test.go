@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newFakeOPA returns the URL of a fake OPA serving the proglog/authz rule, which lets root do
+// anything, denies nobody with a reason and is undefined for everyone else, and the count of
+// queries it answered. It fails queries while failing is set.
+func newFakeOPA(t *testing.T, failing *atomic.Bool) (string, *atomic.Int32) {
+	t.Helper()
+	var queries atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/data/proglog/authz" || r.Header.Get("Authorization") != "Bearer opa-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		queries.Add(1)
+		var body struct {
+			Input opaInput `json:"input"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		switch body.Input.Subject {
+		case "root":
+			w.Write([]byte(`{"result": true}`))
+		case "nobody":
+			w.Write([]byte(`{"result": {"allow": false, "reason": "nobody may do nothing"}}`))
+		case "tenant":
+			json.NewEncoder(w).Encode(map[string]any{"result": map[string]any{"allow": body.Input.Object == "tenant-orders"}})
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/v1/data/proglog/authz", &queries
+}
+
+func TestOPAAuthorizer(t *testing.T) {
+	failing := &atomic.Bool{}
+	url, queries := newFakeOPA(t, failing)
+	authorizer, err := NewOPAAuthorizer(OPAConfig{URL: url, Token: "opa-token"})
+	require.NoError(t, err)
+
+	for scenario, test := range map[string]struct {
+		subject, object string
+		code            codes.Code
+	}{
+		"allowed":          {subject: "root", object: "orders", code: codes.OK},
+		"denied":           {subject: "nobody", object: "orders", code: codes.PermissionDenied},
+		"allowed object":   {subject: "tenant", object: "tenant-orders", code: codes.OK},
+		"denied object":    {subject: "tenant", object: "orders", code: codes.PermissionDenied},
+		"undefined result": {subject: "ghost", object: "orders", code: codes.PermissionDenied},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			err := authorizer.Authorize(test.subject, test.object, "produce")
+			require.Equal(t, test.code, status.Code(err))
+		})
+	}
+	err = authorizer.Authorize("nobody", "orders", "produce")
+	require.ErrorContains(t, err, "nobody may do nothing")
+
+	// Failing to query OPA denies requests as unavailable
+	failing.Store(true)
+	err = authorizer.Authorize("root", "orders", "produce")
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	unauthenticated, err := NewOPAAuthorizer(OPAConfig{URL: url})
+	require.NoError(t, err)
+	failing.Store(false)
+	err = unauthenticated.Authorize("root", "orders", "produce")
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	// Cached decisions are reused until they expire
+	cached, err := NewOPAAuthorizer(OPAConfig{URL: url, Token: "opa-token", CacheTTL: 100 * time.Millisecond})
+	require.NoError(t, err)
+	before := queries.Load()
+	require.NoError(t, cached.Authorize("root", "orders", "produce"))
+	require.Error(t, cached.Authorize("nobody", "orders", "produce"))
+	require.NoError(t, cached.Authorize("root", "orders", "produce"))
+	require.Error(t, cached.Authorize("nobody", "orders", "produce"))
+	require.Equal(t, before+2, queries.Load())
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, cached.Authorize("root", "orders", "produce"))
+	require.Equal(t, before+3, queries.Load())
+
+	_, err = NewOPAAuthorizer(OPAConfig{})
+	require.Error(t, err)
+}
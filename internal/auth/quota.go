@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/casbin/casbin/model"
+	"github.com/casbin/casbin/persist"
+	fileadapter "github.com/casbin/casbin/persist/file-adapter"
+	api "github.com/glauco/proglog/api/v1"
+	"golang.org/x/time/rate"
+)
+
+// QuotaRule limits the rate a subject, or each subject holding a role, may act on an object at,
+// e.g. produce to a topic. Policy files set them next to the rules allowing the actions, with
+// lines of type q giving the records then the bytes per second:
+//
+//	p, payments-api, payments, produce
+//	q, payments-api, payments, produce, 100, 1048576
+//	q, reader, *, consume, 0, 10485760
+//
+// A zero rate is unlimited. The object * matches every object, the subject sharing the rate
+// across them. A subject's own rules apply over its roles', and rules naming the object over *.
+type QuotaRule struct {
+	Subject          string
+	Object           string
+	Action           string
+	RecordsPerSecond float64
+	BytesPerSecond   float64
+}
+
+// parseQuotaRule parses the fields of a policy line of type q, following the type.
+func parseQuotaRule(fields []string) (QuotaRule, error) {
+	if len(fields) != 5 {
+		return QuotaRule{}, fmt.Errorf("quota rules have 5 fields, subject, object, action, records and bytes per second; got %d", len(fields))
+	}
+	rule := QuotaRule{Subject: fields[0], Object: fields[1], Action: fields[2]}
+	for i, rate := range []*float64{&rule.RecordsPerSecond, &rule.BytesPerSecond} {
+		var err error
+		if *rate, err = strconv.ParseFloat(fields[3+i], 64); err != nil || !(*rate >= 0) || math.IsInf(*rate, 1) {
+			return QuotaRule{}, fmt.Errorf("quota rule rate %q isn't a non-negative number", fields[3+i])
+		}
+	}
+	return rule, nil
+}
+
+// policyAdapter loads the policy file into the enforcer, but for its quota rules, which casbin's
+// models can't hold, and which it keeps instead.
+type policyAdapter struct {
+	*fileadapter.Adapter
+	path   string
+	quotas []QuotaRule
+	err    error // Error of the last load, which the enforcer ignores when it's created
+}
+
+func newPolicyAdapter(path string) *policyAdapter {
+	return &policyAdapter{Adapter: fileadapter.NewAdapter(path), path: path}
+}
+
+// LoadPolicy loads the policy file's lines into the model, as casbin's file adapter does, and
+// its quota rules into the adapter.
+func (a *policyAdapter) LoadPolicy(model model.Model) error {
+	a.quotas, a.err = nil, nil
+	if a.path == "" {
+		return nil
+	}
+	a.err = a.load(model)
+	return a.err
+}
+
+func (a *policyAdapter) load(model model.Model) error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		fields := strings.Split(line, ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		if fields[0] != "q" {
+			persist.LoadPolicyLine(line, model)
+			continue
+		}
+		rule, err := parseQuotaRule(fields[1:])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", a.path, n, err)
+		}
+		a.quotas = append(a.quotas, rule)
+	}
+	return scanner.Err()
+}
+
+// quotaRule returns the rule limiting the subject acting on the object, if any.
+func (a *Authorizer) quotaRule(subject, object, action string) (QuotaRule, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if len(a.adapter.quotas) == 0 {
+		return QuotaRule{}, false
+	}
+	subjects := []string{subject}
+	if hasRoles(a.enforcer) {
+		subjects = append(subjects, a.enforcer.GetImplicitRolesForUser(subject)...)
+	}
+	for _, sub := range subjects {
+		for _, obj := range []string{object, "*"} {
+			for _, rule := range a.adapter.quotas {
+				if rule.Subject == sub && rule.Object == obj && rule.Action == action {
+					return rule, true
+				}
+			}
+		}
+	}
+	return QuotaRule{}, false
+}
+
+// quotaBuckets holds the token buckets of the records and bytes of each subject limited by a
+// rule. Subjects limited by a role's rule each have their own.
+type quotaBuckets map[quotaKey]*quotaBucket
+
+type quotaKey struct {
+	subject string
+	rule    QuotaRule
+}
+
+// quotaBucket limits the records and bytes, nil if unlimited, holding one second's worth.
+type quotaBucket struct {
+	records *rate.Limiter
+	bytes   *rate.Limiter
+}
+
+// bucket returns the subject's bucket for acting on the object, or nil if no rule limits it.
+func (a *Authorizer) bucket(subject, object, action string) *quotaBucket {
+	rule, ok := a.quotaRule(subject, object, action)
+	if !ok {
+		return nil
+	}
+	a.quotaMu.Lock()
+	defer a.quotaMu.Unlock()
+	if a.quotas == nil {
+		a.quotas = make(quotaBuckets)
+	}
+	key := quotaKey{subject: subject, rule: rule}
+	b, ok := a.quotas[key]
+	if !ok {
+		b = &quotaBucket{records: newLimiter(rule.RecordsPerSecond), bytes: newLimiter(rule.BytesPerSecond)}
+		a.quotas[key] = b
+	}
+	return b
+}
+
+// newLimiter returns a limiter of the rate, holding a second's worth, or nil if it's zero.
+func newLimiter(perSecond float64) *rate.Limiter {
+	if perSecond == 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), max(1, int(math.Ceil(perSecond))))
+}
+
+// Throttle decides whether the subject may act on the object with the records and bytes of a
+// request now, within the quota of its rule, taking them from its quota if so. Otherwise, it takes
+// nothing and returns an api.ErrThrottled telling how long to wait, e.g. as the quota was
+// exceeded, or charged into debt by Charge. Subjects without rules aren't throttled.
+func (a *Authorizer) Throttle(subject, object, action string, records, bytes int) error {
+	b := a.bucket(subject, object, action)
+	if b == nil {
+		return nil
+	}
+	now := time.Now()
+	var taken []*rate.Reservation
+	for _, l := range []struct {
+		limiter *rate.Limiter
+		n       int
+		quota   string
+	}{{b.records, records, action + "_records"}, {b.bytes, bytes, action + "_bytes"}} {
+		if l.limiter == nil {
+			continue
+		}
+		// Requests larger than the bucket take all of it, or they could never pass
+		r := l.limiter.ReserveN(now, min(l.n, l.limiter.Burst()))
+		if delay := r.DelayFrom(now); delay > 0 {
+			r.CancelAt(now)
+			for _, t := range taken {
+				t.CancelAt(now)
+			}
+			return api.ErrThrottled{Subject: subject, Quota: l.quota, RetryAfter: delay}
+		}
+		taken = append(taken, r)
+	}
+	return nil
+}
+
+// Charge takes the records and bytes the subject already acted on the object with, e.g. consumed,
+// from its quota even if that puts it into debt, so they slow down its next requests.
+func (a *Authorizer) Charge(subject, object, action string, records, bytes int) {
+	b := a.bucket(subject, object, action)
+	if b == nil {
+		return
+	}
+	now := time.Now()
+	for _, l := range []struct {
+		limiter *rate.Limiter
+		n       int
+	}{{b.records, records}, {b.bytes, bytes}} {
+		if l.limiter != nil {
+			l.limiter.ReserveN(now, min(l.n, l.limiter.Burst()))
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// TestQuotaRules verifies that subjects are throttled by the quota rules of the policy file, their
+// own applying over their roles', and rules naming the object over those matching every object.
+func TestQuotaRules(t *testing.T) {
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte(
+		"p, root, *, produce\n"+
+			"g, alice, reader\n"+
+			"q, root, *, produce, 1, 0\n"+
+			"q, reader, *, consume, 2, 0\n"+
+			"q, alice, payments, consume, 0, 100\n",
+	), 0644))
+	authorizer := New("../../test/model.conf", policy)
+	require.NoError(t, authorizer.Err())
+	// throttled returns the quota the request exceeded, if it did
+	throttled := func(subject, object, action string, records, bytes int) string {
+		var err api.ErrThrottled
+		if errors.As(authorizer.Throttle(subject, object, action, records, bytes), &err) {
+			require.Greater(t, err.RetryAfter.Nanoseconds(), int64(0))
+			return err.Quota
+		}
+		return ""
+	}
+
+	// Rules on every object share their rate across objects
+	require.Empty(t, throttled("root", "orders", "produce", 1, 1<<20))
+	require.Equal(t, "produce_records", throttled("root", "payments", "produce", 1, 1))
+	require.NoError(t, authorizer.Authorize("root", "payments", "produce"))
+
+	// The subject's own rule on the object applies over its role's
+	require.Empty(t, throttled("alice", "payments", "consume", 1, 60))
+	require.Equal(t, "consume_bytes", throttled("alice", "payments", "consume", 1, 60))
+	require.Empty(t, throttled("alice", "orders", "consume", 1, 1<<20))
+	require.Empty(t, throttled("alice", "orders", "consume", 1, 1<<20))
+	require.Equal(t, "consume_records", throttled("alice", "orders", "consume", 1, 1))
+
+	// Subjects holding the role have their own buckets, and others aren't limited
+	require.NoError(t, authorizer.AddRoleBinding("bob", "reader"))
+	require.Empty(t, throttled("bob", "orders", "consume", 1, 1))
+	require.Empty(t, throttled("carol", "orders", "consume", 1000, 1<<30))
+
+	// What's charged after the fact puts the subject into debt, throttling its next requests
+	require.Empty(t, throttled("bob", "orders", "consume", 0, 0))
+	authorizer.Charge("bob", "orders", "consume", 5, 0)
+	require.Equal(t, "consume_records", throttled("bob", "orders", "consume", 0, 0))
+
+	// Malformed rules are reported, and fail reloading, keeping the previous rules
+	require.NoError(t, os.WriteFile(policy, []byte("q, root, *, produce, fast, 0\n"), 0644))
+	require.ErrorContains(t, authorizer.Reload(), "policy.csv:1")
+	require.Equal(t, "produce_records", throttled("root", "payments", "produce", 1, 1))
+	require.ErrorContains(t, New("../../test/model.conf", policy).Err(), "isn't a non-negative number")
+}
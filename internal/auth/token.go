@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+)
+
+// scopedTokenPrefix starts every scoped token, so they're told apart from other bearer tokens,
+// e.g. JWTs, and are easy to find when leaked.
+const scopedTokenPrefix = "plgt_"
+
+// TokenSigner defaults.
+const (
+	defaultTokenTTL = time.Hour
+	defaultMaxTTL   = 24 * time.Hour
+)
+
+// TokenSignerConfig configures a TokenSigner.
+type TokenSignerConfig struct {
+	// Keys are the HMAC-SHA256 keys of the cluster, of at least 32 bytes each. The first key
+	// signs tokens and every key verifies them, so keys can be rotated like the gossip's.
+	Keys [][]byte
+	// MaxTTL caps how long tokens are valid; defaults to a day.
+	MaxTTL time.Duration
+}
+
+// TokenSigner mints scoped tokens, short-lived bearer tokens authenticating as a subject but only
+// permitted the actions of their scopes, and validates them. Tokens are signed with a key every
+// server of the cluster holds, so any server validates the tokens another minted, without
+// storing them. Tokens are written as plgt_<claims>.<signature>, both base64url-encoded, the
+// claims being JSON.
+type TokenSigner struct {
+	keys   map[string][]byte // Keys by ID
+	signer string            // ID of the key signing tokens
+	maxTTL time.Duration
+}
+
+// tokenClaims are the claims of a scoped token.
+type tokenClaims struct {
+	ID       string       `json:"jti"`
+	KeyID    string       `json:"kid"`
+	Subject  string       `json:"sub"`
+	Scopes   []tokenScope `json:"scp"`
+	IssuedAt int64        `json:"iat"`
+	Expires  int64        `json:"exp"`
+}
+
+// tokenScope is a scope of the claims.
+type tokenScope struct {
+	Object string `json:"obj"`
+	Action string `json:"act"`
+}
+
+// NewTokenSigner returns a signer of tokens with the config's keys.
+func NewTokenSigner(config TokenSignerConfig) (*TokenSigner, error) {
+	if len(config.Keys) == 0 {
+		return nil, errors.New("token: a signing key is required")
+	}
+	if config.MaxTTL <= 0 {
+		config.MaxTTL = defaultMaxTTL
+	}
+	s := &TokenSigner{keys: make(map[string][]byte), maxTTL: config.MaxTTL}
+	for i, key := range config.Keys {
+		if len(key) < 32 {
+			return nil, fmt.Errorf("token: signing key must be at least 32 bytes, got %d", len(key))
+		}
+		id := tokenKeyID(key)
+		if i == 0 {
+			s.signer = id
+		}
+		s.keys[id] = key
+	}
+	return s, nil
+}
+
+// Issue mints a token authenticating as the subject, permitted the scopes' actions, and returns it
+// with its ID and expiry. The token is valid for the TTL, an hour if 0, capped to the maximum.
+func (s *TokenSigner) Issue(subject string, scopes []*api.TokenScope, ttl time.Duration) (token, id string, expires time.Time, err error) {
+	if subject == "" {
+		return "", "", time.Time{}, errors.New("token: subject is required")
+	}
+	if len(scopes) == 0 {
+		return "", "", time.Time{}, errors.New("token: a scope is required")
+	}
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+	ttl = min(ttl, s.maxTTL)
+	jti := make([]byte, 8)
+	if _, err := rand.Read(jti); err != nil {
+		return "", "", time.Time{}, err
+	}
+	now := time.Now().Truncate(time.Second)
+	claims := tokenClaims{
+		ID:       hex.EncodeToString(jti),
+		KeyID:    s.signer,
+		Subject:  subject,
+		IssuedAt: now.Unix(),
+		Expires:  now.Add(ttl).Unix(),
+	}
+	for _, scope := range scopes {
+		if scope.Object == "" || scope.Action == "" {
+			return "", "", time.Time{}, fmt.Errorf("token: scope %s:%s needs an object and an action", scope.Object, scope.Action)
+		}
+		claims.Scopes = append(claims.Scopes, tokenScope{Object: scope.Object, Action: scope.Action})
+	}
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	signed := scopedTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	token = signed + "." + base64.RawURLEncoding.EncodeToString(s.sign(s.keys[s.signer], signed))
+	return token, claims.ID, time.Unix(claims.Expires, 0), nil
+}
+
+// Minted reports whether the token is written as the signer's tokens are, rather than, e.g., as
+// a JWT; it doesn't validate it.
+func (s *TokenSigner) Minted(token string) bool {
+	return strings.HasPrefix(token, scopedTokenPrefix)
+}
+
+// Validate returns the subject, ID and scopes of a token signed with one of the keys, failing if
+// it wasn't or it expired.
+func (s *TokenSigner) Validate(_ context.Context, token string) (subject, id string, scopes []*api.TokenScope, err error) {
+	signed, sig, ok := strings.Cut(token, ".")
+	if !ok || !s.Minted(token) {
+		return "", "", nil, errors.New("token: malformed token")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(signed, scopedTokenPrefix))
+	if err != nil {
+		return "", "", nil, fmt.Errorf("token: malformed claims: %w", err)
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("token: malformed signature: %w", err)
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return "", "", nil, fmt.Errorf("token: malformed claims: %w", err)
+	}
+	key, ok := s.keys[claims.KeyID]
+	if !ok {
+		return "", "", nil, fmt.Errorf("token: unknown signing key %q", claims.KeyID)
+	}
+	if !hmac.Equal(mac, s.sign(key, signed)) {
+		return "", "", nil, errors.New("token: invalid signature")
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return "", "", nil, fmt.Errorf("token: token %s expired at %s", claims.ID, time.Unix(claims.Expires, 0).UTC().Format(time.RFC3339))
+	}
+	for _, scope := range claims.Scopes {
+		scopes = append(scopes, &api.TokenScope{Object: scope.Object, Action: scope.Action})
+	}
+	return claims.Subject, claims.ID, scopes, nil
+}
+
+// sign returns the HMAC-SHA256 of the signed part of a token with the key.
+func (s *TokenSigner) sign(key []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+// tokenKeyID returns the ID of a signing key: the start of its SHA-256 hash, which doesn't reveal
+// the key.
+func tokenKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTokenSigner verifies that tokens validate as their subjects and scopes until they expire,
+// and that tokens tampered with or signed with other keys don't.
+func TestTokenSigner(t *testing.T) {
+	ctx := context.Background()
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	signer, err := NewTokenSigner(TokenSignerConfig{Keys: [][]byte{oldKey}, MaxTTL: 2 * time.Hour})
+	require.NoError(t, err)
+	scopes := []*api.TokenScope{{Object: "payments", Action: "consume"}}
+
+	token, id, expires, err := signer.Issue("root", scopes, 0)
+	require.NoError(t, err)
+	require.True(t, signer.Minted(token))
+	require.WithinDuration(t, time.Now().Add(time.Hour), expires, 2*time.Second)
+	subject, gotID, gotScopes, err := signer.Validate(ctx, token)
+	require.NoError(t, err)
+	require.Equal(t, "root", subject)
+	require.Equal(t, id, gotID)
+	require.Equal(t, "payments", gotScopes[0].Object)
+	require.Equal(t, "consume", gotScopes[0].Action)
+
+	// TTLs are capped to the maximum
+	_, _, expires, err = signer.Issue("root", scopes, 48*time.Hour)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(2*time.Hour), expires, 2*time.Second)
+
+	// Rotating the keys in, tokens signed with the old key are still accepted
+	rotated, err := NewTokenSigner(TokenSignerConfig{Keys: [][]byte{newKey, oldKey}})
+	require.NoError(t, err)
+	_, _, _, err = rotated.Validate(ctx, token)
+	require.NoError(t, err)
+	newToken, _, _, err := rotated.Issue("root", scopes, 0)
+	require.NoError(t, err)
+
+	// Widening the scopes breaks the signature
+	signed, sig, _ := strings.Cut(token, ".")
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(signed, scopedTokenPrefix))
+	require.NoError(t, err)
+	var claims tokenClaims
+	require.NoError(t, json.Unmarshal(b, &claims))
+	claims.Scopes[0].Object = "*"
+	b, err = json.Marshal(claims)
+	require.NoError(t, err)
+	widened := scopedTokenPrefix + base64.RawURLEncoding.EncodeToString(b) + "." + sig
+
+	for scenario, bogus := range map[string]string{
+		"malformed":      "plgt_bogus",
+		"not minted":     "eyJhbGciOiJSUzI1NiJ9.e30.c2ln",
+		"widened":        widened,
+		"unknown key":    newToken,
+		"bad signature":  signed + ".AAAA",
+		"garbled claims": scopedTokenPrefix + "!!!." + sig,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			_, _, _, err := signer.Validate(ctx, bogus)
+			require.Error(t, err)
+		})
+	}
+
+	// Tokens aren't accepted once expired
+	claims.Scopes[0].Object = "payments"
+	claims.Expires = time.Now().Add(-time.Second).Unix()
+	b, err = json.Marshal(claims)
+	require.NoError(t, err)
+	signed = scopedTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	expired := signed + "." + base64.RawURLEncoding.EncodeToString(signer.sign(oldKey, signed))
+	_, _, _, err = signer.Validate(ctx, expired)
+	require.ErrorContains(t, err, "expired")
+
+	_, _, _, err = signer.Issue("", scopes, 0)
+	require.Error(t, err)
+	_, _, _, err = signer.Issue("root", nil, 0)
+	require.Error(t, err)
+	_, err = NewTokenSigner(TokenSignerConfig{Keys: [][]byte{[]byte("short")}})
+	require.Error(t, err)
+}
@@ -15,6 +15,7 @@ var (
 	NobodyClientKeyFile  = configFile("nobody-client-key.pem")
 	ACLModelFile         = configFile("model.conf")
 	ACLPolicyFile        = configFile("policy.csv")
+	GossipKeyFile        = configFile("gossip.key")
 )
 
 func configFile(filename string) string {
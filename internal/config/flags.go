@@ -0,0 +1,252 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Sources tells where each flag set by ParseFlags got its value from, e.g. "flag",
+// "env PROGLOG_DATA_DIR" or "file agent.yaml", keyed by the flag's name. Flags missing from it
+// have their default value.
+type Sources map[string]string
+
+// ParseFlags parses the command-line arguments into the flag set, then sets the flags they didn't
+// set from environment variables, then from the config file at the value of the flag named
+// fileFlag, if set, as LayerFlags does. It returns where each flag was set from.
+func ParseFlags(fs *flag.FlagSet, args []string, envPrefix, fileFlag string) (Sources, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	pfs := pflag.NewFlagSet(fs.Name(), pflag.ContinueOnError)
+	pfs.AddGoFlagSet(fs)
+	fs.Visit(func(f *flag.Flag) {
+		pfs.Lookup(f.Name).Changed = true
+	})
+	return LayerFlags(pfs, envPrefix, fileFlag)
+}
+
+// LayerFlags sets the flags of the flag set the command line didn't set, e.g. as parsed by a cobra
+// command, from environment variables, then from the config file at the value of the flag named
+// fileFlag, if set, both read with viper. Flags thus override environment variables, which
+// override the file, which overrides the flags' defaults. The environment variable of a flag is
+// its name in upper case with dashes replaced by underscores, after the prefix and an underscore,
+// e.g. PROGLOG_DATA_DIR for data-dir with the "PROGLOG" prefix; the file's keys are the flags'
+// names, e.g.
+//
+//	data-dir: /var/lib/proglog
+//	start-join-addrs: [10.0.0.1:8401, 10.0.0.2:8401]
+//
+// The file is read as TOML if its name ends in .toml, and as YAML otherwise. Lists are set as
+// comma-separated values. Keys that aren't flags fail the parsing, naming the flag they were
+// likely meant to be, so typos don't go unnoticed. It returns where each flag was set from.
+func LayerFlags(fs *pflag.FlagSet, envPrefix, fileFlag string) (Sources, error) {
+	sources := make(Sources)
+	fs.VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			sources[f.Name] = "flag"
+		}
+	})
+	v := viper.New()
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AllowEmptyEnv(true)
+	v.AutomaticEnv()
+
+	// Environment variables come first, as the config file's path may be set by one
+	var err error
+	fs.VisitAll(func(f *pflag.Flag) {
+		env := EnvVar(envPrefix, f.Name)
+		if _, ok := os.LookupEnv(env); err != nil || sources[f.Name] != "" || !ok {
+			return
+		}
+		if serr := fs.Set(f.Name, v.GetString(f.Name)); serr != nil {
+			err = fmt.Errorf("environment variable %s: %w", env, serr)
+		}
+		sources[f.Name] = "env " + env
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	file := fs.Lookup(fileFlag)
+	if file == nil || file.Value.String() == "" {
+		return sources, nil
+	}
+	path := file.Value.String()
+	values, err := readConfigFile(v, path)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, name := range sortedKeys(values) {
+		if fs.Lookup(name) == nil {
+			errs = append(errs, unknownKeyError(fs, name))
+			continue
+		}
+		if sources[name] != "" {
+			continue
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		sources[name] = "file " + path
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("config file %s: %w", path, errors.Join(errs...))
+	}
+	return sources, nil
+}
+
+// LongFlagArgs returns the arguments with the flags of the flag set written with a single dash,
+// e.g. -data-dir, written with two, as pflag, and so cobra, parse them, so the command lines of
+// programs moved from the flag package keep working. Arguments after -- are kept as they are.
+func LongFlagArgs(fs *pflag.FlagSet, args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			copy(out[i:], args[i:])
+			break
+		}
+		name, _, _ := strings.Cut(strings.TrimPrefix(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && len(name) > 1 && fs.Lookup(name) != nil {
+			arg = "-" + arg
+		}
+		out[i] = arg
+	}
+	return out
+}
+
+// EnvVar returns the environment variable setting the flag, e.g. PROGLOG_DATA_DIR for data-dir.
+func EnvVar(prefix, name string) string {
+	return prefix + "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// CheckFiles checks the files at the values of the named flags exist, if set, so a missing cert
+// fails at startup naming the flag and where it was set from, rather than once first used.
+func CheckFiles(fs *flag.FlagSet, sources Sources, names ...string) error {
+	var errs []error
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil || f.Value.String() == "" {
+			continue
+		}
+		if _, err := os.Stat(f.Value.String()); err != nil {
+			source := sources[name]
+			if source == "" {
+				source = "default"
+			}
+			errs = append(errs, fmt.Errorf("-%s (%s): %w", name, source, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PrintFlags writes the flags' effective values, one per line and sorted by name, each with where
+// it was set from, so how a process was configured can be told from its logs.
+func PrintFlags(w io.Writer, fs *flag.FlagSet, sources Sources) {
+	fs.VisitAll(func(f *flag.Flag) {
+		source := sources[f.Name]
+		if source == "" {
+			source = "default"
+		}
+		fmt.Fprintf(w, "  %s = %q (%s)\n", f.Name, f.Value.String(), source)
+	})
+}
+
+// unknownKeyError is the error of a config file's key that isn't a flag, suggesting the flag with
+// the closest name, if any is close enough to be a typo.
+func unknownKeyError(fs *pflag.FlagSet, key string) error {
+	// Keys are often written the way other formats name settings, e.g. data_dir or dataDir
+	normalized := strings.ToLower(strings.ReplaceAll(key, "_", "-"))
+	best, bestDistance := "", 3
+	fs.VisitAll(func(f *pflag.Flag) {
+		if f.Name == normalized || strings.ReplaceAll(f.Name, "-", "") == normalized {
+			best, bestDistance = f.Name, 0
+			return
+		}
+		if d := editDistance(normalized, f.Name); d < bestDistance {
+			best, bestDistance = f.Name, d
+		}
+	})
+	if best == "" {
+		return fmt.Errorf("unknown key %q", key)
+	}
+	return fmt.Errorf("unknown key %q, did you mean %q?", key, best)
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// readConfigFile reads the values of the config file's keys with viper, lists as comma-separated
+// values.
+func readConfigFile(v *viper.Viper, path string) (map[string]string, error) {
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		v.SetConfigType("toml")
+	}
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	raw := v.AllSettings()
+	values := make(map[string]string, len(raw))
+	var errs []error
+	for _, name := range sortedKeys(raw) {
+		switch value := raw[name].(type) {
+		case []any:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			values[name] = strings.Join(items, ",")
+		case map[string]any:
+			errs = append(errs, fmt.Errorf("%s: nested settings aren't supported, keys are the flags' names", name))
+		case nil:
+			values[name] = ""
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("config file %s: %w", path, errors.Join(errs...))
+	}
+	return values, nil
+}
+
+// sortedKeys returns the map's keys sorted, so errors are reported in a stable order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFlags(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "agent.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(
+		"data-dir: /from/file\n"+
+			"node-name: file\n"+
+			"rpc-port: 8400\n"+
+			"bootstrap: true\n"+
+			"start-join-addrs: [a:8401, b:8401]\n",
+	), 0644))
+	t.Setenv("TEST_CONFIG_FILE", file)
+	t.Setenv("TEST_NODE_NAME", "env")
+	t.Setenv("TEST_DATA_DIR", "/from/env")
+
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	fs.String("config-file", "", "")
+	dataDir := fs.String("data-dir", "", "")
+	nodeName := fs.String("node-name", "", "")
+	rpcPort := fs.Int("rpc-port", 0, "")
+	bootstrap := fs.Bool("bootstrap", false, "")
+	joinAddrs := fs.String("start-join-addrs", "", "")
+	sources, err := ParseFlags(fs, []string{"-data-dir", "/from/flag"}, "TEST", "config-file")
+	require.NoError(t, err)
+
+	// Flags override environment variables, which override the file, which sets its path
+	require.Equal(t, "/from/flag", *dataDir)
+	require.Equal(t, "env", *nodeName)
+	require.Equal(t, 8400, *rpcPort)
+	require.True(t, *bootstrap)
+	require.Equal(t, "a:8401,b:8401", *joinAddrs)
+	require.Equal(t, Sources{
+		"config-file":      "env TEST_CONFIG_FILE",
+		"data-dir":         "flag",
+		"node-name":        "env TEST_NODE_NAME",
+		"rpc-port":         "file " + file,
+		"bootstrap":        "file " + file,
+		"start-join-addrs": "file " + file,
+	}, sources)
+
+	// The effective config tells where each value comes from
+	var b strings.Builder
+	PrintFlags(&b, fs, sources)
+	require.Contains(t, b.String(), `  data-dir = "/from/flag" (flag)`)
+	require.Contains(t, b.String(), `  node-name = "env" (env TEST_NODE_NAME)`)
+
+	// Values that don't parse tell where they come from
+	t.Setenv("TEST_RPC_PORT", "port")
+	fs = flag.NewFlagSet("agent", flag.ContinueOnError)
+	fs.Int("rpc-port", 0, "")
+	_, err = ParseFlags(fs, nil, "TEST", "config-file")
+	require.ErrorContains(t, err, "TEST_RPC_PORT")
+}
+
+func TestConfigFileValidation(t *testing.T) {
+	dir := t.TempDir()
+	newFlagSet := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+		fs.String("config-file", "", "")
+		fs.String("data-dir", "", "")
+		fs.Int("rpc-port", 0, "")
+		fs.String("start-join-addrs", "", "")
+		fs.String("server-tls-cert-file", "", "")
+		return fs
+	}
+	parse := func(name, content string) (*flag.FlagSet, Sources, error) {
+		file := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+		fs := newFlagSet()
+		sources, err := ParseFlags(fs, []string{"-config-file", file}, "TEST", "config-file")
+		return fs, sources, err
+	}
+
+	// Unknown keys are reported together, with the flags they were likely meant to be
+	_, _, err := parse("typos.yaml", "data_dir: /data\nrpc-prot: 8400\nfoo: bar\n")
+	require.ErrorContains(t, err, `unknown key "data_dir", did you mean "data-dir"?`)
+	require.ErrorContains(t, err, `unknown key "rpc-prot", did you mean "rpc-port"?`)
+	require.ErrorContains(t, err, `unknown key "foo"`)
+	require.NotContains(t, err.Error(), `"foo", did you mean`)
+
+	// Nested settings and values that don't parse name their key
+	_, _, err = parse("nested.yaml", "server:\n  tls-cert-file: cert.pem\n")
+	require.ErrorContains(t, err, "server: nested settings aren't supported")
+	_, _, err = parse("invalid.yaml", "rpc-port: port\n")
+	require.ErrorContains(t, err, "rpc-port")
+
+	// TOML files are read by their extension
+	fs, sources, err := parse("agent.toml", "# Node settings\n"+
+		"data-dir = '/data' # inline comment\n"+
+		"rpc-port = 8_400\n"+
+		"start-join-addrs = [\"a:8401\", \"b#1:8401\"]\n")
+	require.NoError(t, err)
+	require.Equal(t, "/data", fs.Lookup("data-dir").Value.String())
+	require.Equal(t, "8400", fs.Lookup("rpc-port").Value.String())
+	require.Equal(t, "a:8401,b#1:8401", fs.Lookup("start-join-addrs").Value.String())
+	_, _, err = parse("table.toml", "[server]\ntls-cert-file = 'cert.pem'\n")
+	require.ErrorContains(t, err, "server: nested settings aren't supported")
+	_, _, err = parse("unquoted.toml", "data-dir = /data\n")
+	require.ErrorContains(t, err, "unquoted.toml: While parsing config")
+
+	// Missing files are reported with the flag and where it was set
+	fs, sources, err = parse("certs.yaml", "server-tls-cert-file: "+filepath.Join(dir, "missing.pem")+"\n")
+	require.NoError(t, err)
+	err = CheckFiles(fs, sources, "server-tls-cert-file", "data-dir")
+	require.ErrorContains(t, err, "-server-tls-cert-file (file "+filepath.Join(dir, "certs.yaml")+")")
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.NoError(t, CheckFiles(fs, sources, "config-file"))
+}
+
+// TestLayerFlags verifies that flags parsed by pflag, as cobra commands do, are layered over the
+// environment and the config file, and that single-dash long flags are still parsed.
+func TestLayerFlags(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "agent.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("data-dir: /from/file\nrpc-port: 8400\n"), 0644))
+	t.Setenv("TEST_RPC_PORT", "9400")
+	t.Setenv("TEST_NODE_NAME", "")
+
+	fs := pflag.NewFlagSet("agent", pflag.ContinueOnError)
+	fs.String("config-file", "", "")
+	dataDir := fs.String("data-dir", "", "")
+	nodeName := fs.String("node-name", "host", "")
+	rpcPort := fs.Int("rpc-port", 0, "")
+	bootstrap := fs.Bool("bootstrap", false, "")
+	args := LongFlagArgs(fs, []string{"-config-file=" + file, "-bootstrap", "-x", "--", "-data-dir"})
+	require.Equal(t, []string{"--config-file=" + file, "--bootstrap", "-x", "--", "-data-dir"}, args)
+	require.NoError(t, fs.Parse(args[:2]))
+
+	sources, err := LayerFlags(fs, "TEST", "config-file")
+	require.NoError(t, err)
+	require.True(t, *bootstrap)
+	require.Equal(t, "/from/file", *dataDir)
+	require.Equal(t, 9400, *rpcPort)
+	// Empty environment variables set the flags to empty values
+	require.Equal(t, "", *nodeName)
+	require.Equal(t, Sources{
+		"config-file": "flag",
+		"bootstrap":   "flag",
+		"data-dir":    "file " + file,
+		"rpc-port":    "env TEST_RPC_PORT",
+		"node-name":   "env TEST_NODE_NAME",
+	}, sources)
+}
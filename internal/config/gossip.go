@@ -0,0 +1,70 @@
+package config
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadGossipKeyring reads the keys encrypting the nodes' gossip from the file, which holds one
+// base64-encoded AES key of 16, 24 or 32 bytes per line. The first key encrypts the gossip and
+// every key decrypts it, so keys can be rotated by adding the new key after the current one on
+// every node, moving it first, then removing the old one. Blank lines and lines starting with #
+// are skipped.
+func LoadGossipKeyring(file string) ([][]byte, error) {
+	return loadKeyring(file, "gossip key", func(key []byte) error {
+		switch len(key) {
+		case 16, 24, 32:
+			return nil
+		}
+		return fmt.Errorf("gossip key must be 16, 24 or 32 bytes, got %d", len(key))
+	})
+}
+
+// LoadTokenSigningKeys reads the keys signing the cluster's scoped tokens from the file, written
+// like the gossip's keyring: one base64-encoded key of at least 32 bytes per line, the first
+// signing tokens and every key verifying them, so they're rotated the same way.
+func LoadTokenSigningKeys(file string) ([][]byte, error) {
+	return loadKeyring(file, "token signing key", func(key []byte) error {
+		if len(key) < 32 {
+			return fmt.Errorf("token signing key must be at least 32 bytes, got %d", len(key))
+		}
+		return nil
+	})
+}
+
+// loadKeyring reads the base64-encoded keys of the file, one per line, checking each with check.
+// Blank lines and lines starting with # are skipped.
+func loadKeyring(file, kind string, check func(key []byte) error) ([][]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys [][]byte
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid %s: %w", file, line, kind, err)
+		}
+		if err := check(key); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no %s", file, kind)
+	}
+	return keys, nil
+}
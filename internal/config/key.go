@@ -0,0 +1,202 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// errWrongPassphrase is returned when an encrypted key fails to decrypt with the passphrase.
+var errWrongPassphrase = errors.New("decrypting the TLS key failed: wrong passphrase?")
+
+// decryptKey returns the PEM-encoded key decrypted with the passphrase, if it's encrypted, either
+// as a PKCS #8 ENCRYPTED PRIVATE KEY, e.g. written by openssl genpkey -aes256, or with OpenSSL's
+// legacy Proc-Type: 4,ENCRYPTED headers, e.g. written by openssl genrsa -aes256. Unencrypted keys
+// are returned as is, without asking for the passphrase.
+func decryptKey(keyPEM []byte, passphrase func() ([]byte, error)) ([]byte, error) {
+	var (
+		out       []byte
+		encrypted bool
+	)
+	for rest := keyPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		// Legacy encryption is deprecated as insecure, but OpenSSL still writes such keys
+		legacy := x509.IsEncryptedPEMBlock(block)
+		if block.Type != "ENCRYPTED PRIVATE KEY" && !legacy {
+			out = append(out, pem.EncodeToMemory(block)...)
+			continue
+		}
+		encrypted = true
+		if passphrase == nil {
+			return nil, errors.New("the TLS key is encrypted, but no passphrase was given")
+		}
+		pass, err := passphrase()
+		if err != nil {
+			return nil, fmt.Errorf("TLS key passphrase: %w", err)
+		}
+		if legacy {
+			der, err := x509.DecryptPEMBlock(block, pass)
+			if err != nil {
+				return nil, errWrongPassphrase
+			}
+			block = &pem.Block{Type: block.Type, Bytes: der}
+		} else if block, err = decryptPKCS8(block.Bytes, pass); err != nil {
+			return nil, err
+		}
+		out = append(out, pem.EncodeToMemory(block)...)
+	}
+	if !encrypted {
+		return keyPEM, nil
+	}
+	return out, nil
+}
+
+// Object identifiers of PKCS #5's password-based encryption scheme 2 (RFC 8018), the algorithm
+// encrypting PKCS #8 keys in practice, and of the functions and ciphers it's used with.
+var (
+	oidPBES2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// pbkdf2PRFs are PBKDF2's pseudorandom functions, by the last arc of their object identifiers,
+// 1.2.840.113549.2.<arc>.
+var pbkdf2PRFs = map[int]func() hash.Hash{
+	7:  sha1.New,
+	8:  sha256.New224,
+	9:  sha256.New,
+	10: sha512.New384,
+	11: sha512.New,
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8 decrypts the DER of a PKCS #8 EncryptedPrivateKeyInfo encrypted with PBES2, using
+// PBKDF2 and AES or Triple DES in CBC mode, into a PRIVATE KEY block.
+func decryptPKCS8(der, passphrase []byte) (*pem.Block, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("parsing the encrypted TLS key: %w", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("the TLS key is encrypted with %s; only PBES2 is supported", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("parsing the TLS key's encryption parameters: %w", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("the TLS key's key is derived with %s; only PBKDF2 is supported", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("parsing the TLS key's PBKDF2 parameters: %w", err)
+	}
+	prf := sha1.New
+	if oid := kdf.PRF.Algorithm; len(oid) > 0 {
+		var ok bool
+		if prf, ok = pbkdf2PRFs[oid[len(oid)-1]]; !ok || !oid[:len(oid)-1].Equal(asn1.ObjectIdentifier{1, 2, 840, 113549, 2}) {
+			return nil, fmt.Errorf("the TLS key's key is derived with the unsupported PRF %s", oid)
+		}
+	}
+
+	var (
+		keyLen   int
+		newBlock func([]byte) (cipher.Block, error)
+	)
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keyLen, newBlock = 16, aes.NewCipher
+	case scheme.Equal(oidAES192CBC):
+		keyLen, newBlock = 24, aes.NewCipher
+	case scheme.Equal(oidAES256CBC):
+		keyLen, newBlock = 32, aes.NewCipher
+	case scheme.Equal(oidDESEDE3CBC):
+		keyLen, newBlock = 24, des.NewTripleDESCipher
+	default:
+		return nil, fmt.Errorf("the TLS key is encrypted with the unsupported cipher %s", scheme)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("parsing the TLS key's IV: %w", err)
+	}
+	block, err := newBlock(pbkdf2(prf, passphrase, kdf.Salt, kdf.IterationCount, keyLen))
+	if err != nil {
+		return nil, err
+	}
+	data := info.EncryptedData
+	if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, errors.New("the encrypted TLS key is malformed")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	// A wrong passphrase leaves garbage, caught by its padding, or else by parsing it
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > block.BlockSize() || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, errWrongPassphrase
+	}
+	plain = plain[:len(plain)-pad]
+	if _, err := x509.ParsePKCS8PrivateKey(plain); err != nil {
+		return nil, errWrongPassphrase
+	}
+	return &pem.Block{Type: "PRIVATE KEY", Bytes: plain}, nil
+}
+
+// pbkdf2 derives a key of keyLen bytes from the password, as RFC 8018 defines.
+func pbkdf2(prf func() hash.Hash, password, salt []byte, iterations, keyLen int) []byte {
+	mac := hmac.New(prf, password)
+	var key []byte
+	for i := uint32(1); len(key) < keyLen; i++ {
+		mac.Reset()
+		mac.Write(salt)
+		mac.Write(binary.BigEndian.AppendUint32(nil, i))
+		u := mac.Sum(nil)
+		t := bytes.Clone(u)
+		for n := 1; n < iterations; n++ {
+			mac.Reset()
+			mac.Write(u)
+			u = mac.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// PassphraseFromEnvOrTerminal returns a function, e.g. for TLSConfig.KeyPassphrase, returning the
+// value of the environment variable or, if it isn't set, the passphrase typed into the
+// controlling terminal after the prompt, without echoing it. Without a terminal, e.g. under
+// systemd, the environment variable must be set.
+func PassphraseFromEnvOrTerminal(env, prompt string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		if value, ok := os.LookupEnv(env); ok {
+			return []byte(value), nil
+		}
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			return nil, fmt.Errorf("set %s, or run in a terminal to be prompted for the passphrase: %w", env, err)
+		}
+		defer tty.Close()
+		fmt.Fprint(tty, prompt)
+		defer fmt.Fprintln(tty)
+		restore, err := disableEcho(tty)
+		if err != nil {
+			return nil, fmt.Errorf("set %s: %w", env, err)
+		}
+		defer restore()
+		line, err := bufio.NewReader(tty).ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimRight(line, "\r\n"), nil
+	}
+}
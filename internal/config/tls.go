@@ -5,36 +5,84 @@ import (
 	"crypto/x509"
 	"fmt"
 	"os"
+	"sync"
+	"time"
 )
 
+// certCheckInterval is the least time between checks of whether the certificate files changed.
+const certCheckInterval = time.Second
+
 type TLSConfig struct {
-	CertFile      string
-	KeyFile       string
-	CAFile        string
+	CertFile string
+	KeyFile  string
+	CAFile   string
+	// CertPEM, KeyPEM and CAPEM hold the PEM-encoded certificate, key and certificate authority
+	// themselves, e.g. fetched by secrets.Load from a secret manager, so they never touch the
+	// filesystem. They replace the corresponding files when set, and aren't reloaded.
+	CertPEM []byte
+	KeyPEM  []byte
+	CAPEM   []byte
+	// KeyPassphrase returns the passphrase decrypting the key, if it's encrypted, e.g.
+	// PassphraseFromEnvOrTerminal. It's called once, when an encrypted key is first loaded, and
+	// the passphrase is kept to decrypt the key reloaded, so it must stay the same when rotating.
+	KeyPassphrase func() ([]byte, error)
 	ServerAddress string
 	Server        bool
 }
 
+// SetupTLSConfig returns the TLS config the files, or PEM blocks, describe. The certificate and
+// key files are loaded now, failing if they can't be, then loaded again when the files change,
+// e.g. as cert-manager renews them, so new connections use the renewed certificate without a
+// restart while open ones, e.g. long-lived streams, carry on.
 func SetupTLSConfig(cfg TLSConfig) (*tls.Config, error) {
-	var err error
 	tlsConfig := &tls.Config{}
+	passphrase := cfg.KeyPassphrase
+	if passphrase != nil {
+		passphrase = sync.OnceValues(passphrase)
+	}
 
-	if cfg.CertFile != "" && cfg.KeyFile != "" {
-		tlsConfig.Certificates = make([]tls.Certificate, 1)
-		tlsConfig.Certificates[0], err = tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	var certificate func() *tls.Certificate
+	if len(cfg.CertPEM) > 0 || len(cfg.KeyPEM) > 0 {
+		keyPEM, err := decryptKey(cfg.KeyPEM, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.X509KeyPair(cfg.CertPEM, keyPEM)
 		if err != nil {
 			return nil, err
 		}
+		certificate = func() *tls.Certificate { return &cert }
+	} else if cfg.CertFile != "" && cfg.KeyFile != "" {
+		reloader, err := newCertReloader(cfg.CertFile, cfg.KeyFile, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		certificate = reloader.certificate
 	}
-	if cfg.CAFile != "" {
+	if certificate != nil {
+		if cfg.Server {
+			tlsConfig.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return certificate(), nil
+			}
+		} else {
+			tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return certificate(), nil
+			}
+		}
+	}
+	caPEM, caName := cfg.CAPEM, "CA PEM"
+	if len(caPEM) == 0 && cfg.CAFile != "" {
 		b, err := os.ReadFile(cfg.CAFile)
 		if err != nil {
 			return nil, err
 		}
+		caPEM, caName = b, cfg.CAFile
+	}
+	if len(caPEM) > 0 {
 		ca := x509.NewCertPool()
-		ok := ca.AppendCertsFromPEM([]byte(b))
+		ok := ca.AppendCertsFromPEM(caPEM)
 		if !ok {
-			return nil, fmt.Errorf("failed to parse root certificate: %q", cfg.CAFile)
+			return nil, fmt.Errorf("failed to parse root certificate: %q", caName)
 		}
 		if cfg.Server {
 			tlsConfig.ClientCAs = ca
@@ -47,3 +95,84 @@ func SetupTLSConfig(cfg TLSConfig) (*tls.Config, error) {
 
 	return tlsConfig, nil
 }
+
+// certReloader holds a certificate loaded from files, which it loads again when they change.
+// Files that fail to load, e.g. as the certificate was replaced but not its key yet, leave the
+// previous certificate in use until they're loaded at a later check.
+type certReloader struct {
+	certFile   string
+	keyFile    string
+	passphrase func() ([]byte, error) // Decrypts the key, if encrypted
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	stamp   [2]fileStamp // Stamps of the files the certificate was loaded from
+	checked time.Time    // When the files were last checked
+}
+
+// fileStamp tells whether a file changed since it was stamped.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func newCertReloader(certFile, keyFile string, passphrase func() ([]byte, error)) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, passphrase: passphrase}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// certificate returns the certificate, loaded again first if the files changed since the last
+// check, at most every certCheckInterval.
+func (r *certReloader) certificate() *tls.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) >= certCheckInterval {
+		if stamp, err := r.stamps(); err == nil && stamp != r.stamp {
+			// A failed load is retried at the next check, as the stamps still differ
+			r.load()
+		}
+		r.checked = time.Now()
+	}
+	return r.cert
+}
+
+// load loads the certificate from the files.
+func (r *certReloader) load() error {
+	stamp, err := r.stamps()
+	if err != nil {
+		return err
+	}
+	certPEM, err := os.ReadFile(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := os.ReadFile(r.keyFile)
+	if err != nil {
+		return err
+	}
+	if keyPEM, err = decryptKey(keyPEM, r.passphrase); err != nil {
+		return fmt.Errorf("%s: %w", r.keyFile, err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	r.cert, r.stamp = &cert, stamp
+	return nil
+}
+
+// stamps returns the stamps of the certificate and key files.
+func (r *certReloader) stamps() ([2]fileStamp, error) {
+	var stamps [2]fileStamp
+	for i, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return stamps, err
+		}
+		stamps[i] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glauco/proglog/pkg/devcert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert issues a certificate to the common name and writes it and its key to the files.
+func writeCert(t *testing.T, authority *devcert.Authority, commonName, certFile, keyFile string) {
+	t.Helper()
+	cert, err := authority.Issue(commonName, "127.0.0.1")
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+}
+
+func TestSetupTLSConfigReload(t *testing.T) {
+	authority, err := devcert.NewAuthority()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, authority.CertPEM(), 0600))
+	serverCert, serverKey := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	clientCert, clientKey := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	writeCert(t, authority, "server-1", serverCert, serverKey)
+	writeCert(t, authority, "client-1", clientCert, clientKey)
+
+	serverTLSConfig, err := SetupTLSConfig(TLSConfig{CertFile: serverCert, KeyFile: serverKey, CAFile: caFile, Server: true})
+	require.NoError(t, err)
+	clientTLSConfig, err := SetupTLSConfig(TLSConfig{CertFile: clientCert, KeyFile: clientKey, CAFile: caFile, ServerAddress: "127.0.0.1"})
+	require.NoError(t, err)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", serverTLSConfig)
+	require.NoError(t, err)
+	defer l.Close()
+	clients := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				tlsConn := conn.(*tls.Conn)
+				if tlsConn.Handshake() != nil {
+					return
+				}
+				clients <- tlsConn.ConnectionState().PeerCertificates[0].Subject.CommonName
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	// dial returns a connection to the server, and the common names the server and client
+	// authenticated each other with
+	dial := func() (*tls.Conn, string, string) {
+		conn, err := tls.Dial("tcp", l.Addr().String(), clientTLSConfig)
+		require.NoError(t, err)
+		return conn, conn.ConnectionState().PeerCertificates[0].Subject.CommonName, <-clients
+	}
+
+	conn, server, client := dial()
+	defer conn.Close()
+	require.Equal(t, "server-1", server)
+	require.Equal(t, "client-1", client)
+
+	// Renewed files are used by new connections once checked again, while open ones carry on
+	writeCert(t, authority, "server-2", serverCert, serverKey)
+	writeCert(t, authority, "client-2", clientCert, clientKey)
+	time.Sleep(certCheckInterval)
+	renewed, server, client := dial()
+	defer renewed.Close()
+	require.Equal(t, "server-2", server)
+	require.Equal(t, "client-2", client)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	// Files that don't load, e.g. half written, leave the previous certificate in use
+	require.NoError(t, os.WriteFile(serverKey, []byte("not a key"), 0600))
+	time.Sleep(certCheckInterval)
+	kept, server, _ := dial()
+	defer kept.Close()
+	require.Equal(t, "server-2", server)
+
+	// Missing files fail the setup right away
+	_, err = SetupTLSConfig(TLSConfig{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: serverKey})
+	require.Error(t, err)
+}
+
+// TestSetupTLSConfigPEM verifies that the certificates, keys and CA held in memory secure
+// connections like files do.
+func TestSetupTLSConfigPEM(t *testing.T) {
+	authority, err := devcert.NewAuthority()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	serverCert, serverKey := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	writeCert(t, authority, "server-1", serverCert, serverKey)
+	certPEM, err := os.ReadFile(serverCert)
+	require.NoError(t, err)
+	keyPEM, err := os.ReadFile(serverKey)
+	require.NoError(t, err)
+
+	serverTLSConfig, err := SetupTLSConfig(TLSConfig{CertPEM: certPEM, KeyPEM: keyPEM, Server: true})
+	require.NoError(t, err)
+	clientTLSConfig, err := SetupTLSConfig(TLSConfig{CAPEM: authority.CertPEM(), ServerAddress: "127.0.0.1"})
+	require.NoError(t, err)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", serverTLSConfig)
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	conn, err := tls.Dial("tcp", l.Addr().String(), clientTLSConfig)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, "server-1", conn.ConnectionState().PeerCertificates[0].Subject.CommonName)
+
+	// PEM blocks that don't parse fail the setup
+	_, err = SetupTLSConfig(TLSConfig{CertPEM: certPEM, KeyPEM: []byte("not a key")})
+	require.Error(t, err)
+	_, err = SetupTLSConfig(TLSConfig{CAPEM: []byte("not a CA")})
+	require.Error(t, err)
+}
+
+// TestSetupTLSConfigEncryptedKey verifies that encrypted keys are decrypted with the passphrase,
+// asked for once and kept to decrypt the renewed keys.
+func TestSetupTLSConfigEncryptedKey(t *testing.T) {
+	authority, err := devcert.NewAuthority()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	// writeEncryptedCert writes the certificate and its key, encrypted as openssl -aes256 does
+	writeEncryptedCert := func(commonName string) {
+		writeCert(t, authority, commonName, certFile, keyFile)
+		b, err := os.ReadFile(keyFile)
+		require.NoError(t, err)
+		block, _ := pem.Decode(b)
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte("s3cret"), x509.PEMCipherAES256)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600))
+	}
+	writeEncryptedCert("server-1")
+
+	asked := 0
+	tlsConfig, err := SetupTLSConfig(TLSConfig{CertFile: certFile, KeyFile: keyFile, Server: true, KeyPassphrase: func() ([]byte, error) {
+		asked++
+		return []byte("s3cret"), nil
+	}})
+	require.NoError(t, err)
+	// commonName returns the common name of the certificate the server presents
+	commonName := func() string {
+		cert, err := tlsConfig.GetCertificate(nil)
+		require.NoError(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		require.NoError(t, err)
+		return leaf.Subject.CommonName
+	}
+	require.Equal(t, "server-1", commonName())
+
+	writeEncryptedCert("server-2")
+	time.Sleep(certCheckInterval)
+	require.Equal(t, "server-2", commonName())
+	require.Equal(t, 1, asked)
+
+	// Without the passphrase, or with another, the setup fails
+	_, err = SetupTLSConfig(TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	require.ErrorContains(t, err, "no passphrase")
+	t.Setenv("TEST_KEY_PASSPHRASE", "wrong")
+	_, err = SetupTLSConfig(TLSConfig{CertFile: certFile, KeyFile: keyFile, KeyPassphrase: PassphraseFromEnvOrTerminal("TEST_KEY_PASSPHRASE", "")})
+	require.ErrorIs(t, err, errWrongPassphrase)
+}
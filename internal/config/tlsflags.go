@@ -0,0 +1,153 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/glauco/proglog/pkg/secrets"
+)
+
+// secretsTimeout bounds how long TLSFlags.Setup waits for the secrets to be fetched.
+const secretsTimeout = time.Minute
+
+// TLSFlags are the flags setting the files, or references to the secrets, securing one side of a
+// command's connections: its certificate, key and certificate authority. They're named after
+// their prefix, e.g. -server-tls-cert-file, or -tls-cert-file without one, and an encrypted key
+// is decrypted with the passphrase of the environment variable named likewise, e.g.
+// PROGLOG_SERVER_TLS_KEY_PASSPHRASE, or else typed into the terminal, so every command reads
+// certificates the same way.
+type TLSFlags struct {
+	CertFile   string
+	KeyFile    string
+	CAFile     string
+	CertSecret string
+	KeySecret  string
+	CASecret   string
+
+	prefix    string // Prefix of the flags' names, e.g. "server", or empty
+	envPrefix string // Prefix of the passphrase's environment variable, e.g. PROGLOG
+}
+
+// Register registers the flags on fs, named after the prefix, e.g. "server" for
+// -server-tls-cert-file or "" for -tls-cert-file, and described as desc's, e.g. "server's".
+func (f *TLSFlags) Register(fs *flag.FlagSet, envPrefix, prefix, desc string) {
+	f.prefix, f.envPrefix = prefix, envPrefix
+	fs.StringVar(&f.CertFile, f.Name("cert-file"), "", "Path to the "+desc+" TLS certificate.")
+	fs.StringVar(&f.KeyFile, f.Name("key-file"), "", "Path to the "+desc+" TLS key, optionally encrypted with the passphrase of "+f.passphraseEnv()+"\n"+
+		"or else prompted for.")
+	fs.StringVar(&f.CAFile, f.Name("ca-file"), "", "Path to the "+desc+" certificate authority.")
+	fs.StringVar(&f.CertSecret, f.Name("cert-secret"), "", "Reference to the "+desc+" TLS certificate, instead of -"+f.Name("cert-file")+", e.g. env:CERT,\n"+
+		"aws-sm:prod/proglog#cert, gcp-sm:projects/p/secrets/cert, aws-kms:env:CERT_CIPHERTEXT\n"+
+		"or gcp-kms:projects/p/locations/global/keyRings/r/cryptoKeys/k:env:CERT_CIPHERTEXT; fetched once at startup.")
+	fs.StringVar(&f.KeySecret, f.Name("key-secret"), "", "Reference to the "+desc+" TLS key, instead of -"+f.Name("key-file")+".")
+	fs.StringVar(&f.CASecret, f.Name("ca-secret"), "", "Reference to the "+desc+" certificate authority, instead of -"+f.Name("ca-file")+".")
+}
+
+// Name returns the name of the flag of the kind, e.g. "cert-file" for -server-tls-cert-file.
+func (f *TLSFlags) Name(kind string) string {
+	if f.prefix == "" {
+		return "tls-" + kind
+	}
+	return f.prefix + "-tls-" + kind
+}
+
+// passphraseEnv returns the environment variable holding the key's passphrase.
+func (f *TLSFlags) passphraseEnv() string {
+	return EnvVar(f.envPrefix, f.Name("key-passphrase"))
+}
+
+// IsSet reports whether any file or secret is set.
+func (f *TLSFlags) IsSet() bool {
+	return f.HasCert() || f.KeyFile != "" || f.KeySecret != "" || f.HasCA()
+}
+
+// HasCert reports whether the certificate is set, by its file or secret.
+func (f *TLSFlags) HasCert() bool {
+	return f.CertFile != "" || f.CertSecret != ""
+}
+
+// HasCA reports whether the certificate authority is set, by its file or secret.
+func (f *TLSFlags) HasCA() bool {
+	return f.CAFile != "" || f.CASecret != ""
+}
+
+// Files returns the names of the file flags, to check their files exist with CheckFiles.
+func (f *TLSFlags) Files() []string {
+	return []string{f.Name("cert-file"), f.Name("key-file"), f.Name("ca-file")}
+}
+
+// Validate checks the certificate and its key are set together, and each by its file or secret.
+func (f *TLSFlags) Validate() error {
+	var errs []error
+	for _, name := range []struct{ kind, file, secret string }{
+		{"cert", f.CertFile, f.CertSecret},
+		{"key", f.KeyFile, f.KeySecret},
+		{"ca", f.CAFile, f.CASecret},
+	} {
+		if name.file != "" && name.secret != "" {
+			errs = append(errs, fmt.Errorf("-%s and -%s are exclusive", f.Name(name.kind+"-file"), f.Name(name.kind+"-secret")))
+		}
+	}
+	if f.HasCert() != (f.KeyFile != "" || f.KeySecret != "") {
+		errs = append(errs, fmt.Errorf("-%s and -%s, or their secrets, must be set together", f.Name("cert-file"), f.Name("key-file")))
+	}
+	return errors.Join(errs...)
+}
+
+// Setup returns the TLS config, fetching the secrets first, or nil if no files or secrets were
+// given.
+func (f *TLSFlags) Setup(server bool, serverAddress string) (*tls.Config, error) {
+	if !f.IsSet() {
+		return nil, nil
+	}
+	cfg := TLSConfig{
+		CertFile:      f.CertFile,
+		KeyFile:       f.KeyFile,
+		CAFile:        f.CAFile,
+		KeyPassphrase: PassphraseFromEnvOrTerminal(f.passphraseEnv(), strings.TrimSpace("Passphrase of the "+f.prefix)+" TLS key: "),
+		Server:        server,
+		ServerAddress: serverAddress,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), secretsTimeout)
+	defer cancel()
+	for _, secret := range []struct {
+		flag string
+		ref  string
+		pem  *[]byte
+	}{
+		{f.Name("cert-secret"), f.CertSecret, &cfg.CertPEM},
+		{f.Name("key-secret"), f.KeySecret, &cfg.KeyPEM},
+		{f.Name("ca-secret"), f.CASecret, &cfg.CAPEM},
+	} {
+		if secret.ref == "" {
+			continue
+		}
+		b, err := secrets.Load(ctx, secret.ref)
+		if err != nil {
+			return nil, fmt.Errorf("-%s: %w", secret.flag, err)
+		}
+		*secret.pem = b
+	}
+	// The certificate and key are loaded as a pair, so one fetched from its secret takes the
+	// other's file read now, not reloaded, like secrets
+	for _, file := range []struct {
+		path *string
+		pem  *[]byte
+	}{{&cfg.CertFile, &cfg.CertPEM}, {&cfg.KeyFile, &cfg.KeyPEM}} {
+		if *file.path == "" || len(cfg.CertPEM)+len(cfg.KeyPEM) == 0 {
+			continue
+		}
+		b, err := os.ReadFile(*file.path)
+		if err != nil {
+			return nil, err
+		}
+		*file.pem, *file.path = b, ""
+	}
+	return SetupTLSConfig(cfg)
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/glauco/proglog/pkg/devcert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTLSFlags verifies that the flags are named after their prefix, validated together, and set
+// up from files and secrets alike.
+func TestTLSFlags(t *testing.T) {
+	var server, device TLSFlags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	server.Register(fs, "PROGLOG", "server", "server's")
+	device.Register(fs, "PROGLOG", "", "devices'")
+	require.NotNil(t, fs.Lookup("server-tls-key-secret"))
+	require.NotNil(t, fs.Lookup("tls-ca-file"))
+	require.Equal(t, []string{"server-tls-cert-file", "server-tls-key-file", "server-tls-ca-file"}, server.Files())
+	require.Contains(t, fs.Lookup("server-tls-key-file").Usage, "PROGLOG_SERVER_TLS_KEY_PASSPHRASE")
+
+	authority, err := devcert.NewAuthority()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	writeCert(t, authority, "server-1", certFile, keyFile)
+	t.Setenv("TEST_CA", string(authority.CertPEM()))
+
+	// Files and secrets mustn't both be set, nor the certificate without its key
+	require.NoError(t, fs.Parse([]string{"-server-tls-cert-file", certFile, "-server-tls-ca-file", "x", "-server-tls-ca-secret", "env:TEST_CA"}))
+	err = server.Validate()
+	require.ErrorContains(t, err, "-server-tls-ca-file and -server-tls-ca-secret are exclusive")
+	require.ErrorContains(t, err, "-server-tls-cert-file and -server-tls-key-file, or their secrets, must be set together")
+	require.NoError(t, device.Validate())
+	tlsConfig, err := device.Setup(true, "")
+	require.NoError(t, err)
+	require.Nil(t, tlsConfig)
+
+	// The key is read from its file, and the CA fetched from its secret
+	key, err := os.ReadFile(keyFile)
+	require.NoError(t, err)
+	t.Setenv("TEST_KEY", string(key))
+	require.NoError(t, fs.Parse([]string{"-server-tls-ca-file=", "-server-tls-key-secret", "env:TEST_KEY"}))
+	require.NoError(t, server.Validate())
+	tlsConfig, err = server.Setup(true, "")
+	require.NoError(t, err)
+	require.NotNil(t, tlsConfig.ClientCAs)
+
+	// Secrets failing to load are named after their flag
+	server.CASecret = "env:TEST_MISSING"
+	_, err = server.Setup(true, "")
+	require.ErrorContains(t, err, "-server-tls-ca-secret")
+}
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package config
+
+import (
+	"errors"
+	"os"
+)
+
+// disableEcho fails, as prompting for passphrases isn't supported on this platform.
+func disableEcho(*os.File) (func(), error) {
+	return nil, errors.New("prompting for a passphrase isn't supported on this platform")
+}
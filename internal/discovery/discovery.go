@@ -0,0 +1,131 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/hashicorp/raft"
+)
+
+// Discovery finds the servers of the cluster as they join and leave, and tells its handler about
+// them. Membership finds them by gossiping with Serf, and Static from a list; other
+// implementations may watch a service registry, e.g. Kubernetes' or Consul's, so the agent runs
+// with any of them, and tests can inject the servers they mean to.
+type Discovery interface {
+	// Start starts telling the handler about the servers joining and leaving, the local one's
+	// locality included. A Discovery is started once.
+	Start(handler Handler) error
+	// Leave tells the other servers the local one is leaving, if the Discovery can, and stops.
+	Leave() error
+	// Shutdown stops without leaving, so the other servers see the local one fail, e.g. while
+	// it restarts.
+	Shutdown() error
+}
+
+// Handler is notified as servers join and leave the cluster, e.g. to replicate from them.
+type Handler interface {
+	Join(name, addr string) error // Join is called with the name and RPC address of a joining server.
+	Leave(name string) error      // Leave is called with the name of a server that left or was reaped.
+}
+
+// NonvoterHandler is a Handler that tells voting servers from non-voting ones, e.g. to add them
+// to a Raft cluster with the right suffrage. Servers joining as non-voters are passed to
+// JoinNonvoter instead of Join.
+type NonvoterHandler interface {
+	Handler
+	JoinNonvoter(name, addr string) error
+}
+
+// LocalityHandler is a Handler placing servers in datacenters and racks, e.g. to spread the
+// replicas of their data across them. It's told the locality of every member, itself included,
+// before the member joins.
+type LocalityHandler interface {
+	Handler
+	SetLocality(name, datacenter, rack string)
+}
+
+// VersionHandler is a Handler recording the version of every member's build, itself included,
+// e.g. to list them with the servers. It's told a member's version before the member joins.
+type VersionHandler interface {
+	Handler
+	SetVersion(name, version string)
+}
+
+// ObserverHandler is a Handler observing every member joining, leaving and failing, the local
+// member's joining included, e.g. to stream them to monitoring systems. Observe is called before
+// the Handler's other methods, by the goroutine handling the events, so it must not block.
+type ObserverHandler interface {
+	Handler
+	Observe(MemberEvent)
+}
+
+// MemberEventType tells what happened to a member.
+type MemberEventType int
+
+const (
+	MemberJoined MemberEventType = iota + 1 // The member joined, or came back after failing.
+	MemberLeft                              // The member left, or was reaped after failing.
+	MemberFailed                            // The member stopped answering; it may come back.
+)
+
+// MemberEvent is a member joining, leaving or failing, passed to ObserverHandlers.
+type MemberEvent struct {
+	Type       MemberEventType
+	Name       string // Name is the member's node name.
+	RPCAddr    string // RPCAddr is the address the member serves its RPCs on.
+	NonVoter   bool   // NonVoter is set if the member joined as a non-voter.
+	Datacenter string
+	Rack       string
+	Version    string // Version is the version of the member's build, if known.
+}
+
+// dispatch tells the handler about the event through the interfaces it implements. Joining
+// members' locality and version are set before they join, and the local member is never joined nor left, as
+// it's part of the cluster from the start.
+func dispatch(handler Handler, e MemberEvent, local bool) error {
+	if h, ok := handler.(ObserverHandler); ok {
+		h.Observe(e)
+	}
+	switch e.Type {
+	case MemberJoined:
+		if h, ok := handler.(LocalityHandler); ok {
+			h.SetLocality(e.Name, e.Datacenter, e.Rack)
+		}
+		if h, ok := handler.(VersionHandler); ok && e.Version != "" {
+			h.SetVersion(e.Name, e.Version)
+		}
+		if local {
+			return nil
+		}
+		join := handler.Join
+		if h, ok := handler.(NonvoterHandler); ok && e.NonVoter {
+			join = h.JoinNonvoter
+		}
+		return join(e.Name, e.RPCAddr)
+	case MemberLeft:
+		if local {
+			return nil
+		}
+		return handler.Leave(e.Name)
+	}
+	return nil
+}
+
+// logHandlerError logs a failure of the handler to process a member's event. Only the Raft leader
+// can change the cluster's configuration, so followers failing for that reason log at debug level.
+func logHandlerError(logger *slog.Logger, err error, e MemberEvent) {
+	level := slog.LevelError
+	if errors.Is(err, raft.ErrNotLeader) {
+		level = slog.LevelDebug
+	}
+	msg := "failed to join"
+	if e.Type == MemberLeft {
+		msg = "failed to leave"
+	}
+	logger.Log(context.Background(), level, msg,
+		slog.String("error", err.Error()),
+		slog.String("name", e.Name),
+		slog.String(rpcAddrTag, e.RPCAddr),
+	)
+}
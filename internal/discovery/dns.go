@@ -0,0 +1,102 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+)
+
+const (
+	// defaultJoinDNSInterval is how often the join DNS name is re-resolved by default.
+	defaultJoinDNSInterval = 30 * time.Second
+	// resolveTimeout bounds how long resolving the join DNS name may take.
+	resolveTimeout = 5 * time.Second
+)
+
+// Resolver looks up the addresses of the members to join the cluster through. *net.Resolver,
+// e.g. net.DefaultResolver, implements it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// resolveJoinAddrs resolves the join DNS name into the Serf addresses of the members it points
+// at, sorted and without the member's own address. A name with a port, e.g.
+// "proglog.default.svc.cluster.local:8401", is looked up as A and AAAA records, whose addresses
+// are joined on that port. A name without a port, e.g. "_serf._tcp.proglog.example.com", is
+// looked up as an SRV record, whose targets are joined on their ports.
+func (m *Membership) resolveJoinAddrs(ctx context.Context) ([]string, error) {
+	var addrs []string
+	if host, port, err := net.SplitHostPort(m.JoinDNS); err == nil {
+		hosts, err := m.Resolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range hosts {
+			addrs = append(addrs, net.JoinHostPort(h, port))
+		}
+	} else {
+		_, srvs, err := m.Resolver.LookupSRV(ctx, "", "", m.JoinDNS)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range srvs {
+			target := strings.TrimSuffix(srv.Target, ".")
+			addrs = append(addrs, net.JoinHostPort(target, strconv.Itoa(int(srv.Port))))
+		}
+	}
+	addrs = slices.DeleteFunc(addrs, func(addr string) bool {
+		return addr == m.BindAddr
+	})
+	slices.Sort(addrs)
+	return slices.Compact(addrs), nil
+}
+
+// joinDNS joins the cluster through the members the join DNS name resolves to. Joining members
+// already known is harmless, and heals the cluster if it was partitioned.
+func (m *Membership) joinDNS() error {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := m.resolveJoinAddrs(ctx)
+	if err != nil {
+		return fmt.Errorf("resolve %q: %w", m.JoinDNS, err)
+	}
+	if len(addrs) == 0 {
+		// The member is the first one, or the others aren't in the DNS yet
+		return nil
+	}
+	if _, err := m.serf.Join(addrs, true); err != nil {
+		return fmt.Errorf("join cluster through %v: %w", addrs, err)
+	}
+	return nil
+}
+
+// rejoinDNS re-resolves the join DNS name and joins the members it resolves to every
+// JoinDNSInterval, until the member leaves or Serf shuts down, so members started before the
+// others were in the DNS still join them.
+func (m *Membership) rejoinDNS() {
+	ticker := time.NewTicker(m.JoinDNSInterval)
+	defer ticker.Stop()
+	for {
+		if m.serf.State() != serf.SerfAlive {
+			return
+		}
+		if err := m.joinDNS(); err != nil {
+			m.logger.Warn("failed to join through DNS",
+				slog.String("name", m.JoinDNS),
+				slog.String("error", err.Error()))
+		}
+		select {
+		case <-m.serf.ShutdownCh():
+			return
+		case <-ticker.C:
+		}
+	}
+}
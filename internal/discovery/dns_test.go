@@ -0,0 +1,95 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/stretchr/testify/require"
+)
+
+// TestJoinDNS verifies that members join the cluster through the members a DNS name resolves to,
+// once they're in the DNS.
+func TestJoinDNS(t *testing.T) {
+	m, h := setupMember(t, nil, nil)
+	host, port, err := net.SplitHostPort(m[0].BindAddr)
+	require.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	for scenario, name := range map[string]string{
+		"A records":  "proglog.svc:" + port,
+		"SRV record": "_serf._tcp.proglog.svc",
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			r := &resolver{
+				hosts: map[string][]string{"proglog.svc": {host}},
+				srvs:  map[string][]*net.SRV{"_serf._tcp.proglog.svc": {{Target: host + ".", Port: uint16(p)}}},
+			}
+			var joined []*Membership
+			joined, _ = setupMember(t, joined, func(c *Config) {
+				c.NodeName = scenario
+				c.StartJoinAddrs = nil
+				c.JoinDNS = name
+				c.JoinDNSInterval = 50 * time.Millisecond
+				c.Resolver = r
+			})
+			r.set(false)
+
+			// The member joins once the name resolves
+			time.Sleep(200 * time.Millisecond)
+			require.Len(t, joined[0].Members(), 1)
+			r.set(true)
+			require.Eventually(t, func() bool {
+				return memberStatus(m[0], scenario) == serf.StatusAlive
+			}, 3*time.Second, 50*time.Millisecond)
+			require.NoError(t, joined[0].Leave())
+		})
+	}
+	require.Len(t, h.joins, 2)
+
+	// The member's own address is skipped
+	r := &resolver{hosts: map[string][]string{"proglog.svc": {host}}, resolves: true}
+	addrs, err := (&Membership{Config: Config{JoinDNS: "proglog.svc:" + port, BindAddr: m[0].BindAddr, Resolver: r}}).
+		resolveJoinAddrs(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, addrs)
+}
+
+// resolver resolves the names it holds, once told to.
+type resolver struct {
+	hosts map[string][]string
+	srvs  map[string][]*net.SRV
+
+	mu       sync.Mutex
+	resolves bool
+}
+
+func (r *resolver) set(resolves bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resolves = resolves
+}
+
+func (r *resolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.resolves {
+		return nil, errors.New("no such host")
+	}
+	return r.hosts[host], nil
+}
+
+func (r *resolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.resolves {
+		return "", nil, errors.New("no such host")
+	}
+	return name, r.srvs[name], nil
+}
@@ -0,0 +1,318 @@
+package discovery
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/hashicorp/serf/serf"
+)
+
+// rpcAddrTag is the Serf tag holding the address a node serves its RPCs on.
+const rpcAddrTag = "rpc_addr"
+
+// nonVoterTag is the Serf tag set on nodes joining as non-voters.
+const nonVoterTag = "non_voter"
+
+// datacenterTag and rackTag are the Serf tags holding the datacenter and rack a node runs in.
+const (
+	datacenterTag = "datacenter"
+	rackTag       = "rack"
+)
+
+// versionTag is the Serf tag holding the version of a node's build.
+const versionTag = "version"
+
+// Gossip profiles tune Serf's failure detection for the network between the members.
+const (
+	ProfileLAN   = "lan"   // ProfileLAN suits members on a local network; it's the default.
+	ProfileWAN   = "wan"   // ProfileWAN suits members across datacenters, with higher latencies.
+	ProfileLocal = "local" // ProfileLocal suits members on the same host, e.g. in tests.
+)
+
+// Config configures a node's membership in the cluster.
+type Config struct {
+	NodeName string            // NodeName uniquely identifies the node in the cluster.
+	BindAddr string            // BindAddr is the address Serf gossips on, e.g. "127.0.0.1:8401".
+	Tags     map[string]string // Tags are shared with the other nodes; "rpc_addr" must hold the RPC address.
+	// StartJoinAddrs are the Serf addresses of existing members to join the cluster through.
+	// A node without any starts a new cluster.
+	StartJoinAddrs []string
+	// JoinRetryTimeout is how long starting keeps retrying to join the cluster through the
+	// StartJoinAddrs while none can be joined, e.g. as they're starting too, waiting a backoff
+	// between attempts. 0 tries once.
+	JoinRetryTimeout time.Duration
+	// JoinDNS is a DNS name resolving to the Serf addresses of members to join the cluster
+	// through, as an alternative to StartJoinAddrs, e.g. a Kubernetes headless service: a name
+	// with a port is looked up as A and AAAA records, joined on that port, and a name without
+	// one as an SRV record. It's re-resolved every JoinDNSInterval, 30 seconds by default, so
+	// members join the others as they appear in the DNS; failures are logged and retried then.
+	JoinDNS         string
+	JoinDNSInterval time.Duration
+	Resolver        Resolver // Resolver looks up JoinDNS; defaults to net.DefaultResolver.
+	// NonVoter tells the other members' handlers to add the node as a non-voter, through
+	// JoinNonvoter if they implement NonvoterHandler.
+	NonVoter bool
+	// Datacenter and Rack locate the node, e.g. its region and availability zone, so replicas
+	// can be spread across failure domains. They're passed to handlers implementing
+	// LocalityHandler.
+	Datacenter string
+	Rack       string
+	// Version is the version of the node's build, passed to handlers implementing VersionHandler
+	// so operators can audit which versions the cluster runs, e.g. during rolling upgrades.
+	Version string
+	// Profile tunes failure detection for the network between the members: ProfileLAN, the
+	// default, ProfileWAN for members across datacenters, or ProfileLocal. Every member of a
+	// cluster should use the same profile.
+	Profile string
+	// FailedMemberTimeout is how long a member may be failed, e.g. crashed or partitioned away,
+	// before it's reaped and its handler told it left; it may rejoin until then, e.g. when
+	// restarting. 0 defaults to 30 minutes. Members leaving gracefully are removed right away.
+	FailedMemberTimeout time.Duration
+	// EncryptKeys are the AES keys the gossip is encrypted with, e.g. loaded with
+	// config.LoadGossipKeyring: the first encrypts and every key decrypts, so keys can be
+	// rotated without splitting the cluster. Every member must share a key; members can't join
+	// a cluster encrypted with other keys, and encrypted members drop plaintext gossip. Gossip
+	// isn't encrypted when there are none.
+	EncryptKeys [][]byte
+	Logger      *slog.Logger // Logger receives membership events; defaults to slog.Default().
+}
+
+// defaultFailedMemberTimeout is how long members may be failed before they're reaped by default.
+const defaultFailedMemberTimeout = 30 * time.Minute
+
+// Bounds of the backoff between attempts to join the cluster through the start join addresses.
+const (
+	joinRetryMinBackoff = 500 * time.Millisecond
+	joinRetryMaxBackoff = 10 * time.Second
+)
+
+// Membership is the Discovery tracking the servers in the cluster by gossiping with Serf, and
+// telling its handler when servers join and leave. It's the foundation for replication and
+// service discovery.
+type Membership struct {
+	Config
+	handler Handler
+	serf    *serf.Serf
+	events  chan serf.Event
+	logger  *slog.Logger
+}
+
+var _ Discovery = (*Membership)(nil)
+
+// NewMembership returns a Membership for the configuration, with its defaults applied. It
+// gossips once started.
+func NewMembership(config Config) *Membership {
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	if config.FailedMemberTimeout == 0 {
+		config.FailedMemberTimeout = defaultFailedMemberTimeout
+	}
+	if config.Profile == "" {
+		config.Profile = ProfileLAN
+	}
+	if config.JoinDNSInterval == 0 {
+		config.JoinDNSInterval = defaultJoinDNSInterval
+	}
+	if config.Resolver == nil {
+		config.Resolver = net.DefaultResolver
+	}
+	return &Membership{
+		Config: config,
+		logger: config.Logger.With(slog.String("component", "membership"), slog.String("node", config.NodeName)),
+	}
+}
+
+// New creates a Membership for the configuration and starts it with the handler.
+func New(handler Handler, config Config) (*Membership, error) {
+	m := NewMembership(config)
+	if err := m.Start(handler); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Start starts Serf on the configured address, joins the cluster through the start join
+// addresses, if any, and starts handling membership events. Members are joined through the join
+// DNS name in the background.
+func (m *Membership) Start(handler Handler) error {
+	m.handler = handler
+	return m.setupSerf()
+}
+
+// setupSerf creates the Serf instance and joins the cluster.
+func (m *Membership) setupSerf() error {
+	addr, err := net.ResolveTCPAddr("tcp", m.BindAddr)
+	if err != nil {
+		return err
+	}
+	config := serf.DefaultConfig()
+	config.Init()
+	switch m.Profile {
+	case ProfileLAN:
+	case ProfileWAN:
+		config.MemberlistConfig = memberlist.DefaultWANConfig()
+	case ProfileLocal:
+		config.MemberlistConfig = memberlist.DefaultLocalConfig()
+	default:
+		return fmt.Errorf("unknown gossip profile %q, want %q, %q or %q", m.Profile, ProfileLAN, ProfileWAN, ProfileLocal)
+	}
+	config.MemberlistConfig.BindAddr = addr.IP.String()
+	config.MemberlistConfig.BindPort = addr.Port
+	if len(m.EncryptKeys) > 0 {
+		keyring, err := memberlist.NewKeyring(m.EncryptKeys[1:], m.EncryptKeys[0])
+		if err != nil {
+			return fmt.Errorf("gossip keyring: %w", err)
+		}
+		config.MemberlistConfig.Keyring = keyring
+	}
+	// Serf and memberlist log every gossip message, which is too chatty for the server's logs
+	config.LogOutput = io.Discard
+	config.MemberlistConfig.LogOutput = io.Discard
+	m.events = make(chan serf.Event)
+	config.EventCh = m.events
+	// Copy the tags so the caller's map isn't modified
+	config.Tags = maps.Clone(m.Tags)
+	if config.Tags == nil {
+		config.Tags = make(map[string]string)
+	}
+	if m.NonVoter {
+		config.Tags[nonVoterTag] = "true"
+	}
+	if m.Datacenter != "" {
+		config.Tags[datacenterTag] = m.Datacenter
+	}
+	if m.Rack != "" {
+		config.Tags[rackTag] = m.Rack
+	}
+	if m.Version != "" {
+		config.Tags[versionTag] = m.Version
+	}
+	config.NodeName = m.NodeName
+	// Reap failed members once they've been failed for the timeout, checking at least as often
+	config.ReconnectTimeout = m.FailedMemberTimeout
+	config.ReapInterval = min(config.ReapInterval, m.FailedMemberTimeout/2)
+	m.serf, err = serf.Create(config)
+	if err != nil {
+		return err
+	}
+
+	go m.eventHandler()
+	if len(m.StartJoinAddrs) > 0 {
+		if err := m.joinStartAddrs(); err != nil {
+			m.serf.Shutdown()
+			return err
+		}
+	}
+	if m.JoinDNS != "" {
+		go m.rejoinDNS()
+	}
+	return nil
+}
+
+// joinStartAddrs joins the cluster through the start join addresses, retrying with a backoff
+// doubling at each attempt until JoinRetryTimeout elapsed.
+func (m *Membership) joinStartAddrs() error {
+	deadline := time.Now().Add(m.JoinRetryTimeout)
+	backoff := joinRetryMinBackoff
+	for {
+		_, err := m.serf.Join(m.StartJoinAddrs, true)
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("join cluster through %v: %w", m.StartJoinAddrs, err)
+		}
+		m.logger.Warn("failed to join the cluster, retrying",
+			slog.Any("addrs", m.StartJoinAddrs),
+			slog.Duration("backoff", backoff),
+			slog.String("error", err.Error()))
+		time.Sleep(backoff)
+		backoff = min(2*backoff, joinRetryMaxBackoff)
+	}
+}
+
+// eventHandler passes the join and leave events of the members on to the handler, until Serf
+// shuts down and closes the events channel.
+func (m *Membership) eventHandler() {
+	for e := range m.events {
+		var t MemberEventType
+		switch e.EventType() {
+		case serf.EventMemberJoin:
+			t = MemberJoined
+		case serf.EventMemberFailed:
+			// Failed members may come back, so they're only removed once reaped
+			t = MemberFailed
+		case serf.EventMemberLeave, serf.EventMemberReap:
+			t = MemberLeft
+		default:
+			continue
+		}
+		for _, member := range e.(serf.MemberEvent).Members {
+			if e.EventType() == serf.EventMemberReap && member.Status == serf.StatusLeft {
+				// Members that left are reaped later, which isn't another event
+				continue
+			}
+			if t == MemberFailed {
+				m.logger.Warn("member failed",
+					slog.String("name", member.Name),
+					slog.String(rpcAddrTag, member.Tags[rpcAddrTag]),
+				)
+			}
+			event := MemberEvent{
+				Type:       t,
+				Name:       member.Name,
+				RPCAddr:    member.Tags[rpcAddrTag],
+				NonVoter:   member.Tags[nonVoterTag] == "true",
+				Datacenter: member.Tags[datacenterTag],
+				Rack:       member.Tags[rackTag],
+				Version:    member.Tags[versionTag],
+			}
+			local := m.isLocal(member)
+			if err := dispatch(m.handler, event, local); err != nil {
+				logHandlerError(m.logger, err, event)
+			}
+			if local && t == MemberLeft {
+				// The local member left, so there are no more events to handle
+				return
+			}
+		}
+	}
+}
+
+// isLocal reports whether the member is the local node.
+func (m *Membership) isLocal(member serf.Member) bool {
+	return m.serf.LocalMember().Name == member.Name
+}
+
+// Members returns a point-in-time snapshot of the cluster's members.
+func (m *Membership) Members() []serf.Member {
+	return m.serf.Members()
+}
+
+// Leave tells the other members that the node is leaving the cluster, then shuts Serf down.
+// It's a no-op if the Membership wasn't started.
+func (m *Membership) Leave() error {
+	if m.serf == nil {
+		return nil
+	}
+	if err := m.serf.Leave(); err != nil {
+		return err
+	}
+	return m.serf.Shutdown()
+}
+
+// Shutdown shuts Serf down without leaving the cluster, so the other members see the node fail
+// and keep it until it comes back or is reaped, e.g. while it restarts. It's a no-op if the
+// Membership wasn't started.
+func (m *Membership) Shutdown() error {
+	if m.serf == nil {
+		return nil
+	}
+	return m.serf.Shutdown()
+}
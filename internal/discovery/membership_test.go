@@ -0,0 +1,275 @@
+package discovery
+
+import (
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glauco/proglog/internal/config"
+	"github.com/hashicorp/serf/serf"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/go-dynaport"
+)
+
+// TestMembership verifies that members are told about servers joining and leaving the cluster.
+func TestMembership(t *testing.T) {
+	m, handler := setupMember(t, nil, nil)
+	m, _ = setupMember(t, m, nil)
+	m, _ = setupMember(t, m, nil)
+
+	// The first member learns about the two others
+	require.Eventually(t, func() bool {
+		return len(handler.joins) == 2 &&
+			len(m[0].Members()) == 3 &&
+			len(handler.leaves) == 0
+	}, 3*time.Second, 250*time.Millisecond)
+
+	// Once the last one leaves, the first member is told about it
+	require.NoError(t, m[2].Leave())
+	require.Eventually(t, func() bool {
+		return len(handler.joins) == 2 &&
+			len(m[0].Members()) == 3 &&
+			memberStatus(m[0], "2") == serf.StatusLeft &&
+			len(handler.leaves) == 1
+	}, 3*time.Second, 250*time.Millisecond)
+	require.Equal(t, "2", <-handler.leaves)
+}
+
+// TestMembershipReap verifies that failed members are only removed once they've been failed
+// for the configured timeout, and that the observer is told about them.
+func TestMembershipReap(t *testing.T) {
+	m, handler := setupMember(t, nil, func(c *Config) {
+		c.FailedMemberTimeout = 2 * time.Second
+	})
+	m, _ = setupMember(t, m, nil)
+	require.Eventually(t, func() bool {
+		return len(handler.joins) == 1
+	}, 3*time.Second, 250*time.Millisecond)
+
+	// The member shuts down without leaving, so it's failed but not removed yet
+	require.NoError(t, m[1].Shutdown())
+	require.Eventually(t, func() bool {
+		return memberStatus(m[0], "1") == serf.StatusFailed
+	}, 10*time.Second, 250*time.Millisecond)
+	require.Len(t, handler.leaves, 0)
+
+	// Once the timeout passes, the member is reaped
+	select {
+	case name := <-handler.leaves:
+		require.Equal(t, "1", name)
+	case <-time.After(10 * time.Second):
+		t.Fatal("failed member wasn't reaped")
+	}
+	require.Equal(t, serf.StatusNone, memberStatus(m[0], "1"))
+
+	// The observer was told the member joined, failed, then left once reaped
+	var types []MemberEventType
+	for len(handler.events) > 0 {
+		if e := <-handler.events; e.Name == "1" {
+			require.Equal(t, m[1].BindAddr, e.RPCAddr)
+			types = append(types, e.Type)
+		}
+	}
+	require.Equal(t, []MemberEventType{MemberJoined, MemberFailed, MemberLeft}, types)
+}
+
+// TestMembershipLocality verifies that members gossip their datacenter, rack and version, with the
+// profile suiting the network between them.
+func TestMembershipLocality(t *testing.T) {
+	m, h := setupMember(t, nil, func(c *Config) {
+		c.Profile, c.Datacenter, c.Rack, c.Version = ProfileWAN, "eu-west", "a", "v1.0.0"
+	})
+	_, _ = setupMember(t, m, func(c *Config) {
+		c.Profile, c.Datacenter, c.Rack, c.Version = ProfileWAN, "eu-west", "b", "v1.1.0"
+	})
+
+	// The handler learns the locality of every member, itself included
+	racks := make(map[string]string)
+	for len(racks) < 2 {
+		select {
+		case loc := <-h.localities:
+			require.Equal(t, "eu-west", loc["datacenter"])
+			racks[loc["id"]] = loc["rack"]
+		case <-time.After(3 * time.Second):
+			t.Fatalf("localities not learned, got %v", racks)
+		}
+	}
+	require.Equal(t, map[string]string{"0": "a", "1": "b"}, racks)
+	// and the version of their builds
+	require.Eventually(t, func() bool {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return maps.Equal(map[string]string{"0": "v1.0.0", "1": "v1.1.0"}, h.versions)
+	}, 3*time.Second, 50*time.Millisecond)
+
+	_, err := New(&handler{}, Config{NodeName: "x", BindAddr: "127.0.0.1:0", Profile: "moon"})
+	require.Error(t, err)
+}
+
+// TestMembershipEncryption verifies that members only gossip with members sharing their keys.
+func TestMembershipEncryption(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "gossip.key")
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	other := base64.StdEncoding.EncodeToString([]byte("fedcba9876543210"))
+	require.NoError(t, os.WriteFile(file, []byte("# current key\n"+key+"\n\n"+other+"\n"), 0600))
+	keys, err := config.LoadGossipKeyring(file)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+
+	// Members sharing a key join each other, even with keys being rotated in
+	m, h := setupMember(t, nil, func(c *Config) {
+		c.EncryptKeys = keys[:1]
+	})
+	m, _ = setupMember(t, m, func(c *Config) {
+		c.EncryptKeys = keys
+	})
+	require.Eventually(t, func() bool {
+		return len(h.joins) == 1
+	}, 3*time.Second, 250*time.Millisecond)
+
+	// Members with other keys or none can't join
+	for scenario, keys := range map[string][][]byte{
+		"other key": keys[1:],
+		"plaintext": nil,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			_, err := New(&handler{}, Config{
+				NodeName:       scenario,
+				BindAddr:       fmt.Sprintf("127.0.0.1:%d", dynaport.Get(1)[0]),
+				StartJoinAddrs: []string{m[0].BindAddr},
+				EncryptKeys:    keys,
+			})
+			require.Error(t, err)
+		})
+	}
+	require.Len(t, m[0].Members(), 2)
+}
+
+func TestMembershipJoinRetry(t *testing.T) {
+	// The seed member isn't up yet when the member starts joining it
+	addr := fmt.Sprintf("127.0.0.1:%d", dynaport.Get(1)[0])
+	seed := make(chan *Membership, 1)
+	go func() {
+		time.Sleep(time.Second)
+		m, err := New(&handler{}, Config{NodeName: "seed", BindAddr: addr})
+		if err != nil {
+			t.Error(err)
+		}
+		seed <- m
+	}()
+	m, err := New(&handler{}, Config{
+		NodeName:         "joiner",
+		BindAddr:         fmt.Sprintf("127.0.0.1:%d", dynaport.Get(1)[0]),
+		StartJoinAddrs:   []string{addr},
+		JoinRetryTimeout: 10 * time.Second,
+	})
+	require.NoError(t, err)
+	defer m.Shutdown()
+	s := <-seed
+	require.NotNil(t, s)
+	defer s.Shutdown()
+	require.Len(t, m.Members(), 2)
+
+	// Members give up once the timeout elapsed
+	start := time.Now()
+	_, err = New(&handler{}, Config{
+		NodeName:         "lonely",
+		BindAddr:         fmt.Sprintf("127.0.0.1:%d", dynaport.Get(1)[0]),
+		StartJoinAddrs:   []string{fmt.Sprintf("127.0.0.1:%d", dynaport.Get(1)[0])},
+		JoinRetryTimeout: time.Second,
+	})
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+// setupMember starts a member joining the cluster formed by the given members, with its
+// config adjusted by fn if set. Only the first member gets a handler recording the events,
+// which is returned.
+func setupMember(t *testing.T, members []*Membership, fn func(*Config)) ([]*Membership, *handler) {
+	id := len(members)
+	ports := dynaport.Get(1)
+	addr := fmt.Sprintf("127.0.0.1:%d", ports[0])
+	c := Config{
+		NodeName: fmt.Sprintf("%d", id),
+		BindAddr: addr,
+		Tags:     map[string]string{"rpc_addr": addr},
+	}
+	h := &handler{}
+	if len(members) == 0 {
+		h.joins = make(chan map[string]string, 3)
+		h.leaves = make(chan string, 3)
+		h.localities = make(chan map[string]string, 3)
+		h.events = make(chan MemberEvent, 10)
+	} else {
+		c.StartJoinAddrs = []string{members[0].BindAddr}
+	}
+	if fn != nil {
+		fn(&c)
+	}
+	m, err := New(h, c)
+	require.NoError(t, err)
+	t.Cleanup(func() { m.serf.Shutdown() })
+	return append(members, m), h
+}
+
+// memberStatus returns the status of the named member as seen by m.
+func memberStatus(m *Membership, name string) serf.MemberStatus {
+	for _, member := range m.Members() {
+		if member.Name == name {
+			return member.Status
+		}
+	}
+	return serf.StatusNone
+}
+
+// handler records the join and leave events it's told about, the members' localities and
+// versions, and the events it observes.
+type handler struct {
+	joins      chan map[string]string
+	leaves     chan string
+	localities chan map[string]string
+	events     chan MemberEvent
+
+	mu       sync.Mutex
+	versions map[string]string
+}
+
+func (h *handler) SetVersion(id, version string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.versions == nil {
+		h.versions = make(map[string]string)
+	}
+	h.versions[id] = version
+}
+
+func (h *handler) Observe(e MemberEvent) {
+	if h.events != nil {
+		h.events <- e
+	}
+}
+
+func (h *handler) SetLocality(id, datacenter, rack string) {
+	if h.localities != nil {
+		h.localities <- map[string]string{"id": id, "datacenter": datacenter, "rack": rack}
+	}
+}
+
+func (h *handler) Join(id, addr string) error {
+	if h.joins != nil {
+		h.joins <- map[string]string{"id": id, "addr": addr}
+	}
+	return nil
+}
+
+func (h *handler) Leave(id string) error {
+	if h.leaves != nil {
+		h.leaves <- id
+	}
+	return nil
+}
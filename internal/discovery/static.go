@@ -0,0 +1,122 @@
+package discovery
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// StaticServer is a server of a static cluster.
+type StaticServer struct {
+	Name       string // Name is the server's node name.
+	RPCAddr    string // RPCAddr is the address the server serves its RPCs on.
+	NonVoter   bool   // NonVoter adds the server as a non-voter.
+	Datacenter string
+	Rack       string
+	Version    string // Version is the version of the server's build, if known.
+}
+
+// Static is the Discovery of a cluster whose servers are configured, e.g. listed in a file or
+// set by tests, rather than discovered. The servers, the local one included, join once started;
+// servers can be added and removed later, e.g. as the configuration is reloaded. It can't tell
+// servers failing, so they're only removed when removed from the list.
+type Static struct {
+	NodeName string         // NodeName is the local server's name, which is never joined nor left.
+	Servers  []StaticServer // Servers are the servers of the cluster when started.
+	Logger   *slog.Logger   // Logger receives the handler's errors; defaults to slog.Default().
+
+	mu      sync.Mutex
+	handler Handler // Handler is nil until started and once stopped
+	servers map[string]StaticServer
+}
+
+var _ Discovery = (*Static)(nil)
+
+// Start tells the handler about the servers joining, in their names' order.
+func (s *Static) Start(handler Handler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Logger == nil {
+		s.Logger = slog.Default()
+	}
+	s.Logger = s.Logger.With(slog.String("component", "discovery"), slog.String("node", s.NodeName))
+	s.handler = handler
+	s.servers = make(map[string]StaticServer)
+	servers := slices.Clone(s.Servers)
+	slices.SortFunc(servers, func(a, b StaticServer) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	for _, server := range servers {
+		s.add(server)
+	}
+	return nil
+}
+
+// Add adds the server to the cluster, telling the handler about it joining if started. Adding a
+// server that was added before updates it, telling the handler about it joining again.
+func (s *Static) Add(server StaticServer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handler == nil {
+		s.Servers = append(s.Servers, server)
+		return
+	}
+	s.add(server)
+}
+
+// add tells the handler about the server joining. The caller must hold the lock.
+func (s *Static) add(server StaticServer) {
+	s.servers[server.Name] = server
+	s.notify(MemberJoined, server)
+}
+
+// Remove removes the server with the name from the cluster, telling the handler about it
+// leaving if started.
+func (s *Static) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handler == nil {
+		s.Servers = slices.DeleteFunc(s.Servers, func(server StaticServer) bool {
+			return server.Name == name
+		})
+		return
+	}
+	server, ok := s.servers[name]
+	if !ok {
+		return
+	}
+	delete(s.servers, name)
+	s.notify(MemberLeft, server)
+}
+
+// notify tells the handler about the server's event, logging its errors. The caller must hold
+// the lock.
+func (s *Static) notify(t MemberEventType, server StaticServer) {
+	event := MemberEvent{
+		Type:       t,
+		Name:       server.Name,
+		RPCAddr:    server.RPCAddr,
+		NonVoter:   server.NonVoter,
+		Datacenter: server.Datacenter,
+		Rack:       server.Rack,
+		Version:    server.Version,
+	}
+	if err := dispatch(s.handler, event, server.Name == s.NodeName); err != nil {
+		logHandlerError(s.Logger, err, event)
+	}
+}
+
+// Leave stops telling the handler about the servers. The other servers aren't told the local one
+// left; it must be removed from their lists.
+func (s *Static) Leave() error {
+	return s.Shutdown()
+}
+
+// Shutdown stops telling the handler about the servers.
+func (s *Static) Shutdown() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = nil
+	return nil
+}
@@ -0,0 +1,57 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestStatic verifies that the handler is told about the configured servers, and the servers
+// added and removed later, but not about the local server joining.
+func TestStatic(t *testing.T) {
+	h := &handler{
+		joins:      make(chan map[string]string, 3),
+		leaves:     make(chan string, 3),
+		localities: make(chan map[string]string, 3),
+		events:     make(chan MemberEvent, 10),
+	}
+	s := &Static{
+		NodeName: "0",
+		Servers: []StaticServer{
+			{Name: "1", RPCAddr: "127.0.0.1:8401", Rack: "b"},
+			{Name: "0", RPCAddr: "127.0.0.1:8400", Rack: "a"},
+		},
+	}
+
+	// Starting joins the other server, and locates every server
+	require.NoError(t, s.Start(h))
+	require.Equal(t, map[string]string{"id": "1", "addr": "127.0.0.1:8401"}, <-h.joins)
+	require.Len(t, h.joins, 0)
+	require.Equal(t, map[string]string{"id": "0", "datacenter": "", "rack": "a"}, <-h.localities)
+	require.Equal(t, map[string]string{"id": "1", "datacenter": "", "rack": "b"}, <-h.localities)
+
+	// Servers added and removed join and leave
+	s.Add(StaticServer{Name: "2", RPCAddr: "127.0.0.1:8402"})
+	require.Equal(t, map[string]string{"id": "2", "addr": "127.0.0.1:8402"}, <-h.joins)
+	s.Remove("1")
+	s.Remove("unknown")
+	require.Equal(t, "1", <-h.leaves)
+	require.Len(t, h.leaves, 0)
+
+	// Observers see every event, the local server's joining included
+	var events []MemberEvent
+	for len(h.events) > 0 {
+		events = append(events, <-h.events)
+	}
+	require.Equal(t, []MemberEvent{
+		{Type: MemberJoined, Name: "0", RPCAddr: "127.0.0.1:8400", Rack: "a"},
+		{Type: MemberJoined, Name: "1", RPCAddr: "127.0.0.1:8401", Rack: "b"},
+		{Type: MemberJoined, Name: "2", RPCAddr: "127.0.0.1:8402"},
+		{Type: MemberLeft, Name: "1", RPCAddr: "127.0.0.1:8401", Rack: "b"},
+	}, events)
+
+	// Once shut down, the handler isn't told about the servers anymore
+	require.NoError(t, s.Shutdown())
+	s.Add(StaticServer{Name: "3", RPCAddr: "127.0.0.1:8403"})
+	require.Len(t, h.joins, 0)
+}
@@ -0,0 +1,219 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc/codes"
+)
+
+// appliedPollInterval is how often waiting for a Raft log entry to be applied checks for it.
+const appliedPollInterval = 10 * time.Millisecond
+
+// errWritesPaused is the error of writes sent while writes are paused. Its gRPC status is
+// Unavailable, as writes resume once the backup took the offsets, so they can be retried.
+var errWritesPaused = api.NewError(codes.Unavailable, api.ReasonUnavailable, "writes are paused for a backup", nil)
+
+// PauseWrites pauses or resumes appends for every server of the cluster, and returns the index
+// of the cluster's Raft log entry doing so. Appends to the cluster's log committed after it fail,
+// and Partitions stops appending to the partitions once it applied it. Only the leader pauses
+// writes; others return an error wrapping raft.ErrNotLeader.
+func (l *DistributedLog) PauseWrites(paused bool) (uint64, error) {
+	var arg uint64
+	if paused {
+		arg = 1
+	}
+	buf, err := encodeRequest(pauseWritesRequestType, arg, nil)
+	if err != nil {
+		return 0, err
+	}
+	future := l.raft.Apply(buf, applyTimeout)
+	if err := future.Error(); err != nil {
+		return 0, applyError(err)
+	}
+	return future.Index(), nil
+}
+
+// WritesPaused reports whether writes are paused, as the server last applied it.
+func (l *DistributedLog) WritesPaused() bool {
+	return l.fsm.getWritesPaused()
+}
+
+// Range returns the offset of the log's first record and the one its next record will get, so
+// the log holds [lowest, next).
+func (l *DistributedLog) Range() (lowest, next uint64, err error) {
+	// Reading past the end of the log reports its range in a single locked read
+	_, err = l.log.Read(math.MaxUint64)
+	var outOfRange api.ErrOffsetOutOfRange
+	if !errors.As(err, &outOfRange) {
+		return 0, 0, fmt.Errorf("read log range: %w", err)
+	}
+	return outOfRange.Lowest, outOfRange.Next, nil
+}
+
+// waitApplied waits until the server applied the Raft log up to the index.
+func (l *DistributedLog) waitApplied(ctx context.Context, index uint64) error {
+	ticker := time.NewTicker(appliedPollInterval)
+	defer ticker.Stop()
+	for l.raft.AppliedIndex() < index {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// committedRange waits for the leader to apply every write committed before, e.g. by a
+// previous leader, and returns the log's range then.
+func (l *DistributedLog) committedRange() (lowest, next uint64, err error) {
+	if err := l.raft.Barrier(applyTimeout).Error(); err != nil {
+		return 0, 0, applyError(err)
+	}
+	return l.Range()
+}
+
+// writeGate stops the appends to the partitions' logs while writes are paused. The partitions'
+// Raft groups don't commit whether writes are paused, the cluster's does, so their appends check
+// it before being sent to Raft; draining the gate then waits for the appends that checked it
+// before writes were paused.
+type writeGate struct {
+	mu     sync.RWMutex // Held for reading by the appends in flight
+	paused func() bool
+}
+
+// enter lets an append through, unless writes are paused. Appends that entered must exit.
+// A nil gate lets every append through.
+func (g *writeGate) enter() error {
+	if g == nil {
+		return nil
+	}
+	g.mu.RLock()
+	if g.paused() {
+		g.mu.RUnlock()
+		return errWritesPaused
+	}
+	return nil
+}
+
+// exit marks an append that entered the gate as done.
+func (g *writeGate) exit() {
+	if g != nil {
+		g.mu.RUnlock()
+	}
+}
+
+// drain waits for the appends that entered the gate to be done.
+func (g *writeGate) drain() {
+	// Locking waits for the appends holding the lock for reading
+	g.mu.Lock()
+	g.mu.Unlock()
+}
+
+// PauseWrites pauses the writes to every partition of the cluster, the default topic's included,
+// for a backup, and returns the index of the cluster's Raft log entry pausing them, which
+// PrepareBackup waits for. Only the cluster's leader pauses writes, and a single backup at a time;
+// starting another fails with FailedPrecondition. ResumeWrites must follow.
+func (p *Partitions) PauseWrites() (uint64, error) {
+	p.mu.Lock()
+	if p.backingUp {
+		p.mu.Unlock()
+		return 0, api.NewError(codes.FailedPrecondition, api.ReasonInvalidRequest,
+			"a backup is already in progress", nil)
+	}
+	p.backingUp = true
+	p.mu.Unlock()
+	index, err := p.cluster.PauseWrites(true)
+	if err != nil {
+		p.mu.Lock()
+		p.backingUp = false
+		p.mu.Unlock()
+		return 0, err
+	}
+	return index, nil
+}
+
+// ResumeWrites resumes the writes paused by PauseWrites. If it fails, e.g. as the server lost the
+// leadership meanwhile, the cluster's leader resumes them at its next reconciliation.
+func (p *Partitions) ResumeWrites() error {
+	_, err := p.cluster.PauseWrites(false)
+	p.mu.Lock()
+	p.backingUp = false
+	p.mu.Unlock()
+	return err
+}
+
+// resumeAbandonedWrites resumes the writes left paused by a backup that didn't resume them, e.g.
+// as its leader failed, if the server leads the cluster and isn't backing it up.
+func (p *Partitions) resumeAbandonedWrites() {
+	if !p.cluster.WritesPaused() || p.cluster.raft.State() != raft.Leader {
+		return
+	}
+	p.mu.Lock()
+	backingUp := p.backingUp
+	p.mu.Unlock()
+	if backingUp {
+		return
+	}
+	if _, err := p.cluster.PauseWrites(false); err != nil {
+		p.logger.Error("failed to resume writes", slog.String("error", err.Error()))
+		return
+	}
+	p.logger.Info("resumed writes left paused by a backup")
+}
+
+// PrepareBackup waits for the server to apply the cluster's Raft log entry at pauseIndex, which
+// paused the writes, and for the appends in flight to be done. It then returns the ranges of
+// committed records of the partitions the server leads, and of the default topic if the server
+// leads the cluster, ordered by topic and partition. The ranges' RPC addresses are left unset.
+func (p *Partitions) PrepareBackup(ctx context.Context, pauseIndex uint64) ([]*api.BackupRange, error) {
+	if err := p.cluster.waitApplied(ctx, pauseIndex); err != nil {
+		return nil, err
+	}
+	if !p.cluster.WritesPaused() {
+		return nil, api.NewError(codes.FailedPrecondition, api.ReasonInvalidRequest,
+			"writes aren't paused for a backup", nil)
+	}
+	p.gate.drain()
+
+	var ranges []*api.BackupRange
+	if p.cluster.raft.State() == raft.Leader {
+		lowest, next, err := p.cluster.committedRange()
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, &api.BackupRange{
+			Topic:        DefaultTopic,
+			LowestOffset: lowest,
+			NextOffset:   next,
+		})
+	}
+	for group, l := range p.leading() {
+		lowest, next, err := l.committedRange()
+		if err != nil {
+			return nil, fmt.Errorf("partition %s: %w", group.name, err)
+		}
+		ranges = append(ranges, &api.BackupRange{
+			Topic:        group.topic,
+			Partition:    group.assignment.Id,
+			LowestOffset: lowest,
+			NextOffset:   next,
+		})
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		if ranges[i].Topic != ranges[j].Topic {
+			return ranges[i].Topic < ranges[j].Topic
+		}
+		return ranges[i].Partition < ranges[j].Partition
+	})
+	return ranges, nil
+}
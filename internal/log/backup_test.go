@@ -0,0 +1,86 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBackup(t *testing.T) {
+	l, p, _ := setupPartitions(t, 0)
+	ctx := context.Background()
+	_, err := l.CreateTopic("orders", 2, 1)
+	require.NoError(t, err)
+	var partitions []*DistributedLog
+	for id := uint32(0); id < 2; id++ {
+		var partition *DistributedLog
+		require.Eventually(t, func() bool {
+			partition, err = p.Partition("orders", id)
+			if err != nil {
+				return false
+			}
+			_, err = partition.Append(&api.Record{Value: []byte("order")})
+			return err == nil
+		}, 5*time.Second, 50*time.Millisecond)
+		partitions = append(partitions, partition)
+	}
+	_, err = l.Append(&api.Record{Value: []byte("first")})
+	require.NoError(t, err)
+	_, err = l.Append(&api.Record{Value: []byte("second")})
+	require.NoError(t, err)
+
+	// Pausing stops the writes to every partition, the default topic's included, and a single
+	// backup runs at a time
+	pauseIndex, err := p.PauseWrites()
+	require.NoError(t, err)
+	_, err = p.PauseWrites()
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = l.Append(&api.Record{Value: []byte("paused")})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	_, err = partitions[0].CompareAndAppend(&api.Record{Value: []byte("paused")}, 1)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	// The server takes the ranges of the partitions it leads once paused
+	ranges, err := p.PrepareBackup(ctx, pauseIndex)
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprint([]*api.BackupRange{
+		{Topic: DefaultTopic, NextOffset: 2},
+		{Topic: "orders", NextOffset: 1},
+		{Topic: "orders", Partition: 1, NextOffset: 1},
+	}), fmt.Sprint(ranges))
+
+	// Servers restoring a snapshot keep the writes paused
+	snap, err := l.fsm.Snapshot()
+	require.NoError(t, err)
+	defer snap.Release()
+	s := snap.(*snapshot)
+	log, err := NewLog(t.TempDir(), Config{})
+	require.NoError(t, err)
+	defer log.Remove()
+	restored := newFSM(log)
+	require.NoError(t, restored.Restore(io.NopCloser(io.MultiReader(bytes.NewReader(s.header), s.reader))))
+	require.True(t, restored.getWritesPaused())
+	require.False(t, restored.getRebalancePaused())
+
+	// Resuming lets the writes through again, and the server can't prepare a backup anymore
+	require.NoError(t, p.ResumeWrites())
+	_, err = partitions[0].Append(&api.Record{Value: []byte("resumed")})
+	require.NoError(t, err)
+	_, err = p.PrepareBackup(ctx, pauseIndex)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// Writes left paused by a backup that didn't resume them are resumed by the leader
+	_, err = l.PauseWrites(true)
+	require.NoError(t, err)
+	require.True(t, l.WritesPaused())
+	p.resumeAbandonedWrites()
+	require.False(t, l.WritesPaused())
+}
@@ -0,0 +1,148 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+// SetConfig replaces the cluster's dynamic configuration on every server, and returns it versioned
+// by the index of the Raft log entry setting it. The configuration replicates through Raft, so
+// every server converges on it without being restarted, and servers joining later or restoring a
+// snapshot get it too. Only the leader sets it; others return an error wrapping raft.ErrNotLeader.
+func (l *DistributedLog) SetConfig(config *api.ClusterConfig) (*api.ClusterConfig, error) {
+	if err := validateRules(config.GetAclRules()); err != nil {
+		return nil, err
+	}
+	config = proto.Clone(config).(*api.ClusterConfig)
+	config.Version = 0
+	buf, err := encodeRequest(setConfigRequestType, 0, config)
+	if err != nil {
+		return nil, err
+	}
+	future := l.raft.Apply(buf, applyTimeout)
+	if err := future.Error(); err != nil {
+		return nil, applyError(err)
+	}
+	if err, ok := future.Response().(error); ok {
+		return nil, err
+	}
+	config.Version = future.Index()
+	return config, nil
+}
+
+// UpdateACLRules removes the rules to remove from the ACL rules of the cluster's configuration,
+// then adds those to add that it doesn't hold yet, and returns the configuration updated. The
+// change is applied to the configuration each server holds when it applies the change, rather
+// than replacing it like SetConfig, so concurrent changes don't overwrite each other. Only the
+// leader updates the rules; others return an error wrapping raft.ErrNotLeader.
+func (l *DistributedLog) UpdateACLRules(add, remove []*api.AclRule) (*api.ClusterConfig, error) {
+	if err := errors.Join(validateRules(add), validateRules(remove)); err != nil {
+		return nil, err
+	}
+	buf, err := encodeRequest(updateACLRulesRequestType, 0, &api.AclRulesUpdate{Add: add, Remove: remove})
+	if err != nil {
+		return nil, err
+	}
+	future := l.raft.Apply(buf, applyTimeout)
+	if err := future.Error(); err != nil {
+		return nil, applyError(err)
+	}
+	switch res := future.Response().(type) {
+	case error:
+		return nil, res
+	case *api.ClusterConfig:
+		return res, nil
+	default:
+		return nil, fmt.Errorf("unexpected apply response %T", res)
+	}
+}
+
+// validateRules checks that every rule has a subject, an object and an action.
+func validateRules(rules []*api.AclRule) error {
+	for i, rule := range rules {
+		if rule.Subject == "" || rule.Object == "" || rule.Action == "" {
+			return api.NewError(codes.InvalidArgument, api.ReasonInvalidRequest,
+				fmt.Sprintf("ACL rule %d must have a subject, an object and an action", i), nil)
+		}
+	}
+	return nil
+}
+
+// updateRules returns the configuration with the update applied to its ACL rules, versioned by
+// the index of the Raft log entry updating them. The configuration isn't modified, as it's shared.
+func updateRules(config *api.ClusterConfig, update *api.AclRulesUpdate, index uint64) *api.ClusterConfig {
+	key := func(rule *api.AclRule) [3]string { return [3]string{rule.Subject, rule.Object, rule.Action} }
+	removed := make(map[[3]string]bool)
+	for _, rule := range update.Remove {
+		removed[key(rule)] = true
+	}
+	updated := proto.Clone(config).(*api.ClusterConfig)
+	updated.AclRules = nil
+	held := make(map[[3]string]bool)
+	hold := func(rule *api.AclRule) {
+		if k := key(rule); !held[k] {
+			held[k] = true
+			updated.AclRules = append(updated.AclRules, proto.Clone(rule).(*api.AclRule))
+		}
+	}
+	for _, rule := range config.AclRules {
+		if !removed[key(rule)] {
+			hold(rule)
+		}
+	}
+	for _, rule := range update.Add {
+		hold(rule)
+	}
+	updated.Version = index
+	return updated
+}
+
+// ClusterConfig returns the cluster's dynamic configuration, as the server last applied it. Its
+// version is 0 until the configuration is first set. It's shared, so it must not be modified.
+func (l *DistributedLog) ClusterConfig() *api.ClusterConfig {
+	return l.fsm.getConfig()
+}
+
+// enforceRetention truncates the partitions the server leads, and the default topic if it leads
+// the cluster, so they keep the retention records of the cluster's configuration, if set. Logs
+// are truncated a segment at a time, so they may keep more records until their oldest segment
+// only holds records past the retention.
+func (p *Partitions) enforceRetention() {
+	retention := p.cluster.ClusterConfig().RetentionRecords
+	if retention == 0 {
+		return
+	}
+	logs := make(map[string]*DistributedLog)
+	if p.cluster.raft.State() == raft.Leader {
+		logs[DefaultTopic] = p.cluster
+	}
+	for group, l := range p.leading() {
+		logs[group.name] = l
+	}
+	for name, l := range logs {
+		if err := l.enforceRetention(retention); err != nil {
+			p.logger.Error("failed to enforce retention",
+				slog.String("partition", name),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
+// enforceRetention truncates the log so it keeps at least the retention latest records, if that
+// removes a segment; truncating nothing would only add to Raft's log.
+func (l *DistributedLog) enforceRetention(retention uint64) error {
+	lowest, next, err := l.Range()
+	if err != nil || next-lowest <= retention {
+		return err
+	}
+	if !l.log.truncates(next - retention - 1) {
+		return nil
+	}
+	return l.Truncate(next - retention - 1)
+}
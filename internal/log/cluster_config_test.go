@@ -0,0 +1,113 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClusterConfig(t *testing.T) {
+	leader, p, _ := setupPartitions(t, 0)
+	follower, _, addr := setupPartitions(t, 1)
+	require.NoError(t, leader.Join("1", addr))
+
+	// Unset, the configuration is empty and retains every record
+	require.Zero(t, leader.ClusterConfig().Version)
+	for i := 0; i < 200; i++ {
+		_, err := leader.Append(&api.Record{Value: []byte("record")})
+		require.NoError(t, err)
+	}
+	p.enforceRetention()
+	lowest, _, err := leader.Range()
+	require.NoError(t, err)
+	require.Zero(t, lowest)
+
+	// Only the leader sets it, and rules missing a field are rejected
+	want := &api.ClusterConfig{
+		RetentionRecords: 50,
+		Quotas:           &api.QuotaSettings{ReceiveBytesPerSecond: 1024},
+		AclRules:         []*api.AclRule{{Subject: "nobody", Object: "*", Action: "consume"}},
+	}
+	_, err = follower.SetConfig(want)
+	require.ErrorIs(t, err, raft.ErrNotLeader)
+	_, err = leader.SetConfig(&api.ClusterConfig{AclRules: []*api.AclRule{{Subject: "nobody"}}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	config, err := leader.SetConfig(want)
+	require.NoError(t, err)
+	require.NotZero(t, config.Version)
+	require.Equal(t, want.AclRules[0].Subject, config.AclRules[0].Subject)
+
+	// Every server converges on the same configuration
+	require.Eventually(t, func() bool {
+		return follower.ClusterConfig().Version == config.Version
+	}, 3*time.Second, 50*time.Millisecond)
+	require.Equal(t, uint64(1024), follower.ClusterConfig().Quotas.ReceiveBytesPerSecond)
+
+	// The leader truncates the records past the retention, a segment at a time
+	p.enforceRetention()
+	lowest, next, err := leader.Range()
+	require.NoError(t, err)
+	require.NotZero(t, lowest)
+	require.GreaterOrEqual(t, next-lowest, want.RetentionRecords)
+
+	// Snapshots hold the configuration
+	snap, err := leader.fsm.Snapshot()
+	require.NoError(t, err)
+	defer snap.Release()
+	s := snap.(*snapshot)
+	log, err := NewLog(t.TempDir(), Config{})
+	require.NoError(t, err)
+	defer log.Remove()
+	restored := newFSM(log)
+	require.NoError(t, restored.Restore(io.NopCloser(io.MultiReader(bytes.NewReader(s.header), s.reader))))
+	require.Equal(t, config.Version, restored.getConfig().Version)
+	require.Equal(t, want.RetentionRecords, restored.getConfig().RetentionRecords)
+}
+
+func TestUpdateACLRules(t *testing.T) {
+	leader, _, _ := setupPartitions(t, 0)
+	follower, _, addr := setupPartitions(t, 1)
+	require.NoError(t, leader.Join("1", addr))
+	_, err := leader.SetConfig(&api.ClusterConfig{
+		RetentionRecords: 50,
+		AclRules:         []*api.AclRule{{Subject: "root", Object: "*", Action: "produce"}},
+	})
+	require.NoError(t, err)
+
+	// Only the leader updates the rules, and rules missing a field are rejected
+	alice := &api.AclRule{Subject: "alice", Object: "orders", Action: "consume"}
+	_, err = follower.UpdateACLRules([]*api.AclRule{alice}, nil)
+	require.ErrorIs(t, err, raft.ErrNotLeader)
+	_, err = leader.UpdateACLRules(nil, []*api.AclRule{{Subject: "alice"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Rules are added once, and the rest of the configuration is kept
+	config, err := leader.UpdateACLRules([]*api.AclRule{alice, alice}, nil)
+	require.NoError(t, err)
+	require.Len(t, config.AclRules, 2)
+	require.Equal(t, "alice", config.AclRules[1].Subject)
+	require.Equal(t, uint64(50), config.RetentionRecords)
+	config, err = leader.UpdateACLRules([]*api.AclRule{alice}, nil)
+	require.NoError(t, err)
+	require.Len(t, config.AclRules, 2)
+
+	// Rules are removed, those not held being ignored
+	config, err = leader.UpdateACLRules(nil, []*api.AclRule{
+		{Subject: "root", Object: "*", Action: "produce"},
+		{Subject: "bob", Object: "*", Action: "produce"},
+	})
+	require.NoError(t, err)
+	require.Len(t, config.AclRules, 1)
+	require.Equal(t, "alice", config.AclRules[0].Subject)
+	require.Eventually(t, func() bool {
+		return follower.ClusterConfig().Version == config.Version
+	}, 3*time.Second, 50*time.Millisecond)
+	require.Len(t, follower.ClusterConfig().AclRules, 1)
+}
@@ -1,9 +1,42 @@
 package log
 
+import (
+	"log/slog"
+
+	"github.com/hashicorp/raft"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Config configures a Log and, for a DistributedLog, the Raft consensus replicating it.
 type Config struct {
+	Raft struct {
+		// Config tunes Raft; LocalID must uniquely identify the server, and zero timeouts
+		// keep Raft's defaults.
+		raft.Config
+		StreamLayer *StreamLayer // StreamLayer carries the Raft RPCs between the servers.
+		Bootstrap   bool         // Bootstrap makes the server form a new cluster on its own.
+		// BootstrapServers are the servers a bootstrapping server forms the cluster with, instead
+		// of only itself. Every server bootstrapping the cluster must be given the same servers.
+		BootstrapServers []raft.Server
+	}
 	Segment struct {
 		MaxStoreBytes uint64
 		MaxIndexBytes uint64
 		InitialOffset uint64
 	}
+	// Logger receives the log's events, e.g. segments rolled and truncated, and failures to sync
+	// them; defaults to slog.Default().
+	Logger *slog.Logger
+	// TracerProvider provides the tracer of the spans of the appends and syncs, e.g. continuing
+	// the traces of the produce requests; defaults to otel.GetTracerProvider().
+	TracerProvider trace.TracerProvider
+}
+
+// tracer returns the tracer of the config's provider, or of the global one.
+func (c Config) tracer() trace.Tracer {
+	if c.TracerProvider == nil {
+		return otel.GetTracerProvider().Tracer(tracerName)
+	}
+	return c.TracerProvider.Tracer(tracerName)
 }
@@ -0,0 +1,1202 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// applyTimeout bounds how long a write waits for Raft to commit it.
+const applyTimeout = 10 * time.Second
+
+// DistributedLog is a Log replicated across the servers of a cluster with Raft. Writes go
+// through the Raft leader, which commits them once a quorum of servers stored them, and are
+// then applied to every server's Log in the same order. Reads are served by the local Log,
+// so followers may briefly lag behind the leader.
+type DistributedLog struct {
+	config Config
+	log    *Log      // Log the committed writes are applied to
+	fsm    *fsm      // FSM applying the committed writes, which also holds the topics
+	store  io.Closer // Store holding Raft's log, closed with the DistributedLog
+	raft   *raft.Raft
+	// bootstrapped is set if the server bootstrapped a new cluster, rather than restarting
+	// with the state of the cluster it was already part of
+	bootstrapped bool
+	// gate stops appends while writes are paused, for the partitions' logs; the cluster's log
+	// commits whether writes are paused, so it has none
+	gate *writeGate
+
+	mu         sync.Mutex
+	localities map[string]locality // Datacenters and racks of the servers, by ID, as gossiped
+	versions   map[string]string   // Versions of the servers' builds, by ID, as gossiped
+}
+
+// locality is the datacenter and rack a server runs in.
+type locality struct {
+	datacenter string
+	rack       string
+}
+
+// NewDistributedLog creates a DistributedLog storing its data in dataDir, which it splits
+// into a log directory for the records and a raft directory for Raft's own state.
+func NewDistributedLog(dataDir string, config Config) (*DistributedLog, error) {
+	l := &DistributedLog{
+		config: config,
+	}
+	if err := l.setupLog(dataDir); err != nil {
+		return nil, err
+	}
+	if err := l.setupRaft(dataDir); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// setupLog creates the Log the committed writes are applied to. On restarts, Raft restores its
+// latest snapshot and replays the writes committed since into the Log, so the Log starts over
+// empty: keeping the records from before the restart would apply those writes twice.
+func (l *DistributedLog) setupLog(dataDir string) error {
+	logDir := filepath.Join(dataDir, "log")
+	if err := os.RemoveAll(logDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return err
+	}
+	var err error
+	l.log, err = NewLog(logDir, l.config)
+	return err
+}
+
+// setupRaft starts Raft on the configured stream layer, bootstrapping a new cluster if
+// configured to and the server has no Raft state yet. Servers restarting with existing state
+// rejoin the cluster they were part of instead, so bootstrapping again can't split it.
+func (l *DistributedLog) setupRaft(dataDir string) error {
+	raftDir := filepath.Join(dataDir, "raft")
+	if err := os.MkdirAll(raftDir, 0755); err != nil {
+		return err
+	}
+
+	logs, stable, err := l.setupRaftStores(raftDir)
+	if err != nil {
+		return err
+	}
+	snapshots, err := raft.NewFileSnapshotStore(raftDir, 1, os.Stderr)
+	if err != nil {
+		return err
+	}
+	transport := raft.NewNetworkTransport(l.config.Raft.StreamLayer, 5, applyTimeout, os.Stderr)
+
+	// Start from Raft's defaults and override what's configured
+	config := raft.DefaultConfig()
+	config.LocalID = l.config.Raft.LocalID
+	if l.config.Raft.HeartbeatTimeout != 0 {
+		config.HeartbeatTimeout = l.config.Raft.HeartbeatTimeout
+	}
+	if l.config.Raft.ElectionTimeout != 0 {
+		config.ElectionTimeout = l.config.Raft.ElectionTimeout
+	}
+	if l.config.Raft.LeaderLeaseTimeout != 0 {
+		config.LeaderLeaseTimeout = l.config.Raft.LeaderLeaseTimeout
+	}
+	if l.config.Raft.CommitTimeout != 0 {
+		config.CommitTimeout = l.config.Raft.CommitTimeout
+	}
+	// Snapshots compact Raft's log once enough entries were committed since the last one
+	if l.config.Raft.SnapshotInterval != 0 {
+		config.SnapshotInterval = l.config.Raft.SnapshotInterval
+	}
+	if l.config.Raft.SnapshotThreshold != 0 {
+		config.SnapshotThreshold = l.config.Raft.SnapshotThreshold
+	}
+	if l.config.Raft.TrailingLogs != 0 {
+		config.TrailingLogs = l.config.Raft.TrailingLogs
+	}
+	if l.config.Raft.Logger != nil {
+		config.Logger = l.config.Raft.Logger
+	}
+
+	l.fsm = newFSM(l.log)
+	l.raft, err = raft.NewRaft(config, l.fsm, logs, stable, snapshots, transport)
+	if err != nil {
+		return err
+	}
+
+	hasState, err := raft.HasExistingState(logs, stable, snapshots)
+	if err != nil {
+		return err
+	}
+	if l.config.Raft.Bootstrap && !hasState {
+		l.bootstrapped = true
+		servers := l.config.Raft.BootstrapServers
+		if len(servers) == 0 {
+			servers = []raft.Server{{
+				ID:      config.LocalID,
+				Address: transport.LocalAddr(),
+			}}
+		}
+		return l.raft.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+	}
+	return nil
+}
+
+// setupRaftStores opens the stores of Raft's log and stable state in the raft directory: Raft's
+// log is stored in a Log, like the records, and its stable state in a file. Servers whose raft
+// directory holds the BoltDB store of Raft's log and stable state from earlier versions keep
+// using it, so they restart with their state rather than as new servers.
+func (l *DistributedLog) setupRaftStores(raftDir string) (raft.LogStore, raft.StableStore, error) {
+	boltPath := filepath.Join(raftDir, "raft.db")
+	if _, err := os.Stat(boltPath); err == nil {
+		store, err := raftboltdb.NewBoltStore(boltPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		l.store = store
+		return store, store, nil
+	}
+	logs, err := newLogStore(filepath.Join(raftDir, "log"), l.config)
+	if err != nil {
+		return nil, nil, err
+	}
+	stable, err := newStableStore(filepath.Join(raftDir, "stable.json"))
+	if err != nil {
+		logs.Close()
+		return nil, nil, err
+	}
+	l.store = logs
+	return logs, stable, nil
+}
+
+// Bootstrapped reports whether the server bootstrapped a new cluster when it was created.
+// Servers configured to bootstrap don't if they already hold Raft state, e.g. on restarts.
+func (l *DistributedLog) Bootstrapped() bool {
+	return l.bootstrapped
+}
+
+// Append replicates the record to the cluster and returns its offset once it's committed, i.e.
+// once a quorum of the voters durably stored it. Only the leader accepts writes; other servers
+// return an error wrapping raft.ErrNotLeader, with an Unavailable gRPC status.
+func (l *DistributedLog) Append(record *api.Record) (uint64, error) {
+	return l.AppendContext(context.Background(), record)
+}
+
+// AppendContext appends the record like Append, within the trace of ctx, e.g. of the produce
+// request. The trace goes on through Raft: the servers storing and applying the record continue
+// it, so its spans tell how long encoding the write, storing and fsyncing it on each server, and
+// appending it to their logs took.
+func (l *DistributedLog) AppendContext(ctx context.Context, record *api.Record) (off uint64, err error) {
+	ctx, span := startSpan(ctx, l.log.tracer, "DistributedLog.Append")
+	defer func() { endSpan(span, err) }()
+	if err := l.gate.enter(); err != nil {
+		return 0, err
+	}
+	defer l.gate.exit()
+	l.stampEpoch(record)
+	return l.apply(ctx, appendRequestType, 0, record)
+}
+
+// CompareAndAppend replicates the record to the cluster only if it gets the expected offset,
+// and returns an api.ErrOffsetMismatch otherwise. The check happens when the write is applied,
+// so it holds even if other writes were committed in the meantime.
+func (l *DistributedLog) CompareAndAppend(record *api.Record, expected uint64) (uint64, error) {
+	return l.CompareAndAppendContext(context.Background(), record, expected)
+}
+
+// CompareAndAppendContext appends the record like CompareAndAppend, within the trace of ctx,
+// like AppendContext.
+func (l *DistributedLog) CompareAndAppendContext(ctx context.Context, record *api.Record, expected uint64) (off uint64, err error) {
+	ctx, span := startSpan(ctx, l.log.tracer, "DistributedLog.CompareAndAppend")
+	defer func() { endSpan(span, err) }()
+	if err := l.gate.enter(); err != nil {
+		return 0, err
+	}
+	defer l.gate.exit()
+	l.stampEpoch(record)
+	return l.apply(ctx, compareAndAppendRequestType, expected, record)
+}
+
+// stampEpoch sets the record's leader epoch to the server's Raft term, which is the term it was
+// elected in while it leads. Every server applying the record fences it off if a leader of a later
+// epoch appended records before it was committed, so a deposed leader that still thinks it leads
+// can't append records after its successor's, and its write fails instead.
+func (l *DistributedLog) stampEpoch(record *api.Record) {
+	record.LeaderEpoch = l.raft.CurrentTerm()
+}
+
+// Truncate removes the segments up to the lowest offset on every server, like Log.Truncate.
+func (l *DistributedLog) Truncate(lowest uint64) error {
+	_, err := l.apply(context.Background(), truncateRequestType, lowest, nil)
+	return err
+}
+
+// apply commits a write through Raft and returns the offset it was applied at. The write's Raft
+// entry carries the trace of ctx, if any, for the servers storing and applying it to continue.
+func (l *DistributedLog) apply(ctx context.Context, reqType requestType, offset uint64, msg proto.Message) (uint64, error) {
+	_, span := startSpan(ctx, l.log.tracer, "encodeRequest")
+	buf, err := encodeRequest(reqType, offset, msg)
+	endSpan(span, err)
+	if err != nil {
+		return 0, err
+	}
+	ctx, span = startSpan(ctx, l.log.tracer, "raft.Apply")
+	future := l.raft.ApplyLog(raft.Log{Data: buf, Extensions: traceEntry(ctx)}, applyTimeout)
+	err = future.Error()
+	endSpan(span, err)
+	if err != nil {
+		return 0, applyError(err)
+	}
+	// The FSM's response is either the offset or the error applying the write
+	switch res := future.Response().(type) {
+	case error:
+		return 0, res
+	case uint64:
+		return res, nil
+	default:
+		return 0, fmt.Errorf("unexpected apply response %T", res)
+	}
+}
+
+// encodeRequest encodes a write as its request type, an offset argument and, for appends and
+// topics, the message.
+func encodeRequest(reqType requestType, offset uint64, msg proto.Message) ([]byte, error) {
+	buf := make([]byte, requestHeaderWidth)
+	buf[0] = byte(reqType)
+	enc.PutUint64(buf[1:], offset)
+	if msg != nil {
+		b, err := proto.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+	}
+	return buf, nil
+}
+
+// Replicated reports that appends only return once a quorum of the voters durably stored the
+// record, so the server can acknowledge produces asking for replicated acks.
+func (l *DistributedLog) Replicated() bool {
+	return true
+}
+
+// ReadIndex waits until the server applied every write committed before the call, so reads served
+// after it are linearizable: they see every write acknowledged before they were sent. Only the
+// leader knows what was committed; it confirms it still leads with a round of heartbeats to a
+// quorum of the voters, rather than by committing an entry, so reads don't write to the log.
+// Others return an error wrapping raft.ErrNotLeader.
+func (l *DistributedLog) ReadIndex(ctx context.Context) error {
+	// The leader's log holds every committed entry, so once it applied its last one as of the
+	// call, it applied every write acknowledged before
+	index := l.raft.LastIndex()
+	if err := l.raft.VerifyLeader().Error(); err != nil {
+		return applyError(err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, applyTimeout)
+	defer cancel()
+	return l.waitApplied(ctx, index)
+}
+
+// notLeaderError is the error of a write sent to a server that isn't the leader. Its gRPC status
+// is Unavailable, as the write wasn't stored and can be retried, e.g. on the leader once elected.
+type notLeaderError struct {
+	err error
+}
+
+func (e notLeaderError) Error() string {
+	return e.err.Error()
+}
+
+func (e notLeaderError) Unwrap() error {
+	return e.err
+}
+
+func (e notLeaderError) GRPCStatus() *status.Status {
+	return status.Convert(api.NewError(codes.Unavailable, api.ReasonUnavailable, e.err.Error(), nil))
+}
+
+// applyError returns the error of a write or configuration change Raft failed to commit. Those
+// rejected by a server that isn't the leader are safe to retry; others, e.g. when the leader lost
+// its leadership while replicating a write, may or may not have been committed, so they're
+// returned as is.
+func applyError(err error) error {
+	if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipTransferInProgress) {
+		return notLeaderError{err: err}
+	}
+	return err
+}
+
+// Read fetches the record at the offset from the local Log.
+func (l *DistributedLog) Read(offset uint64) (*api.Record, error) {
+	return l.log.Read(offset)
+}
+
+// LowestOffset returns the oldest offset in the local Log.
+func (l *DistributedLog) LowestOffset() (uint64, error) {
+	return l.log.LowestOffset()
+}
+
+// HighestOffset returns the most recent offset in the local Log.
+func (l *DistributedLog) HighestOffset() (uint64, error) {
+	return l.log.HighestOffset()
+}
+
+// SetLocality records the datacenter and rack of the server, which GetServers returns, so the
+// partitions' replicas are spread across racks. It's a discovery.LocalityHandler, so servers'
+// localities are learned as Serf discovers them.
+func (l *DistributedLog) SetLocality(id, datacenter, rack string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.localities == nil {
+		l.localities = make(map[string]locality)
+	}
+	l.localities[id] = locality{datacenter: datacenter, rack: rack}
+}
+
+// SetVersion records the version of the server's build, which GetServers returns. It's a
+// discovery.VersionHandler, so servers' versions are learned as Serf discovers them.
+func (l *DistributedLog) SetVersion(id, version string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.versions == nil {
+		l.versions = make(map[string]string)
+	}
+	l.versions[id] = version
+}
+
+// Join adds the server to the Raft cluster as a voter. It's a discovery.Handler, so servers
+// join as Serf discovers them; only the leader can add them, others return raft.ErrNotLeader.
+func (l *DistributedLog) Join(id, addr string) error {
+	return l.join(id, addr, true)
+}
+
+// JoinNonvoter adds the server to the Raft cluster as a non-voter, which replicates the log
+// without voting or counting towards the quorum, e.g. to serve reads or take backups.
+// Servers that are already members keep their suffrage, so promoted servers stay voters.
+func (l *DistributedLog) JoinNonvoter(id, addr string) error {
+	return l.join(id, addr, false)
+}
+
+// join adds the server to the Raft cluster as a voter or a non-voter.
+func (l *DistributedLog) join(id, addr string, voter bool) error {
+	configFuture := l.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+	serverID := raft.ServerID(id)
+	serverAddr := raft.ServerAddress(addr)
+	for _, srv := range configFuture.Configuration().Servers {
+		if srv.ID == serverID && srv.Address == serverAddr {
+			// Already a member of the cluster
+			return nil
+		}
+		if srv.ID == serverID || srv.Address == serverAddr {
+			// The server restarted with another address, or the address was reused; replace it
+			if err := l.raft.RemoveServer(srv.ID, 0, 0).Error(); err != nil {
+				return err
+			}
+		}
+	}
+	if !voter {
+		return l.raft.AddNonvoter(serverID, serverAddr, 0, 0).Error()
+	}
+	return l.raft.AddVoter(serverID, serverAddr, 0, 0).Error()
+}
+
+// Promote makes a non-voting member of the Raft cluster a voter. Promoting a voter does nothing.
+// Only the leader can promote servers; others return raft.ErrNotLeader.
+func (l *DistributedLog) Promote(id string) error {
+	future := l.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return err
+	}
+	for _, srv := range future.Configuration().Servers {
+		if srv.ID != raft.ServerID(id) {
+			continue
+		}
+		if srv.Suffrage == raft.Voter {
+			return nil
+		}
+		return applyError(l.raft.AddVoter(srv.ID, srv.Address, 0, 0).Error())
+	}
+	return errNotMember(id)
+}
+
+// TransferLeadership makes the leader hand the leadership over to the voter with the id, or to
+// the most up-to-date voter if the id is empty, and returns once the new leader was elected.
+// Only the leader can transfer the leadership; others return an error wrapping raft.ErrNotLeader.
+func (l *DistributedLog) TransferLeadership(id string) error {
+	if l.raft.State() != raft.Leader {
+		return applyError(raft.ErrNotLeader)
+	}
+	if id == "" {
+		return applyError(l.raft.LeadershipTransfer().Error())
+	}
+	future := l.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return err
+	}
+	for _, srv := range future.Configuration().Servers {
+		if srv.ID != raft.ServerID(id) {
+			continue
+		}
+		if srv.Suffrage != raft.Voter {
+			return api.NewError(codes.FailedPrecondition, api.ReasonInvalidRequest,
+				fmt.Sprintf("server %q isn't a voter, so it can't lead the cluster", id), nil)
+		}
+		if srv.ID == l.config.Raft.LocalID {
+			// Already the leader
+			return nil
+		}
+		return applyError(l.raft.LeadershipTransferToServer(srv.ID, srv.Address).Error())
+	}
+	return errNotMember(id)
+}
+
+// Leadership returns the cluster's leadership as the local server sees it.
+func (l *DistributedLog) Leadership() (*api.Leadership, error) {
+	servers, err := l.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	leadership := &api.Leadership{
+		Term:  l.raft.CurrentTerm(),
+		State: raftStates[l.raft.State()],
+	}
+	for _, srv := range servers {
+		if srv.IsLeader {
+			leadership.Leader = srv
+		}
+	}
+	if lastContact := l.raft.LastContact(); leadership.State != api.RaftState_RAFT_STATE_LEADER && !lastContact.IsZero() {
+		leadership.LastContact = timestamppb.New(lastContact)
+	}
+	return leadership, nil
+}
+
+// defaultReplicationFactor is how many servers replicate each partition of a topic by default.
+const defaultReplicationFactor = 3
+
+// maxPartitions caps the partitions of a topic, each of which runs its own Raft group.
+const maxPartitions = 1024
+
+// topicNameRegexp matches valid topic names, which are also used in paths and Raft group names.
+var topicNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,128}$`)
+
+// CreateTopic creates a topic with the given number of partitions, each assigned to
+// replicationFactor servers of the cluster which replicate it with their own Raft group.
+// A zero number of partitions defaults to 1, and a zero replication factor to 3, or to the
+// number of servers in smaller clusters. Only the leader creates topics; others return an error
+// wrapping raft.ErrNotLeader.
+func (l *DistributedLog) CreateTopic(name string, partitions, replicationFactor uint32) (*api.Topic, error) {
+	if !topicNameRegexp.MatchString(name) || name == "." || name == ".." || name == DefaultTopic {
+		return nil, api.NewError(codes.InvalidArgument, api.ReasonInvalidRequest,
+			fmt.Sprintf("invalid topic name %q", name), nil)
+	}
+	if partitions == 0 {
+		partitions = 1
+	}
+	if partitions > maxPartitions {
+		return nil, api.NewError(codes.InvalidArgument, api.ReasonInvalidRequest,
+			fmt.Sprintf("a topic has at most %d partitions, got %d", maxPartitions, partitions), nil)
+	}
+	if l.raft.State() != raft.Leader {
+		return nil, applyError(raft.ErrNotLeader)
+	}
+	servers, err := l.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	if replicationFactor == 0 {
+		replicationFactor = min(defaultReplicationFactor, uint32(len(servers)))
+	}
+	if int(replicationFactor) > len(servers) {
+		return nil, api.NewError(codes.FailedPrecondition, api.ReasonInvalidRequest,
+			fmt.Sprintf("replication factor %d exceeds the %d servers of the cluster", replicationFactor, len(servers)), nil)
+	}
+	topic := &api.Topic{
+		Name:       name,
+		Partitions: assignPartitions(name, partitions, replicationFactor, servers),
+	}
+	if _, err := l.apply(context.Background(), createTopicRequestType, 0, topic); err != nil {
+		return nil, err
+	}
+	return topic, nil
+}
+
+// Topics returns the topics created in the cluster, ordered by name, as the server last applied them.
+func (l *DistributedLog) Topics() []*api.Topic {
+	return l.fsm.getTopics()
+}
+
+// PauseRebalance pauses or resumes rebalancing the partitions across the servers, for every
+// server of the cluster. Only the leader pauses rebalancing; others return an error wrapping
+// raft.ErrNotLeader.
+func (l *DistributedLog) PauseRebalance(paused bool) error {
+	var arg uint64
+	if paused {
+		arg = 1
+	}
+	_, err := l.apply(context.Background(), pauseRebalanceRequestType, arg, nil)
+	return err
+}
+
+// RebalancePaused reports whether rebalancing is paused, as the server last applied it.
+func (l *DistributedLog) RebalancePaused() bool {
+	return l.fsm.getRebalancePaused()
+}
+
+// reassignPartitions commits the new assignments of the topic's partitions, which update holds.
+func (l *DistributedLog) reassignPartitions(update *api.Topic) error {
+	_, err := l.apply(context.Background(), reassignPartitionsRequestType, 0, update)
+	return err
+}
+
+// TopicsChanged is signaled whenever the topics change, e.g. to start replicating the partitions
+// assigned to the server. It's meant for a single receiver, which should read the topics again.
+func (l *DistributedLog) TopicsChanged() <-chan struct{} {
+	return l.fsm.topicsChanged
+}
+
+// raftStates maps Raft's states to their API representation.
+var raftStates = map[raft.RaftState]api.RaftState{
+	raft.Follower:  api.RaftState_RAFT_STATE_FOLLOWER,
+	raft.Candidate: api.RaftState_RAFT_STATE_CANDIDATE,
+	raft.Leader:    api.RaftState_RAFT_STATE_LEADER,
+	raft.Shutdown:  api.RaftState_RAFT_STATE_SHUTDOWN,
+}
+
+// errNotMember returns the error for a server that isn't a member of the cluster.
+func errNotMember(id string) error {
+	return api.NewError(codes.NotFound, api.ReasonNotFound,
+		fmt.Sprintf("server %q is not a member of the cluster", id), nil)
+}
+
+// Leave removes the server from the Raft cluster.
+func (l *DistributedLog) Leave(id string) error {
+	return l.raft.RemoveServer(raft.ServerID(id), 0, 0).Error()
+}
+
+// Demote removes the local server from the Raft cluster ahead of leaving it, if it's the leader.
+// The leader steps down once the removal is committed and the remaining servers elect a new one,
+// so the cluster keeps its quorum. Followers are removed by the leader as it learns they left.
+func (l *DistributedLog) Demote() error {
+	if l.raft.State() != raft.Leader {
+		return nil
+	}
+	return l.raft.RemoveServer(l.config.Raft.LocalID, 0, 0).Error()
+}
+
+// GetServers returns the servers of the Raft cluster, which one is the leader, and where they run
+// and which version they run, if known. The servers serve Raft and gRPC on the same address, so their Raft addresses are
+// their RPC addresses.
+func (l *DistributedLog) GetServers() ([]*api.Server, error) {
+	future := l.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	_, leaderID := l.raft.LeaderWithID()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var servers []*api.Server
+	for _, srv := range future.Configuration().Servers {
+		loc := l.localities[string(srv.ID)]
+		servers = append(servers, &api.Server{
+			Id:         string(srv.ID),
+			RpcAddr:    string(srv.Address),
+			IsLeader:   srv.ID == leaderID,
+			IsVoter:    srv.Suffrage == raft.Voter,
+			Datacenter: loc.datacenter,
+			Rack:       loc.rack,
+			Version:    l.versions[string(srv.ID)],
+		})
+	}
+	return servers, nil
+}
+
+// ObserveLeader calls fn whenever the server sees the leadership change, with the new leader, or
+// nil if the cluster lost its leader, until the returned func stops observing. fn is called by a
+// goroutine of its own, in order, so it may block, but changes seen meanwhile are dropped.
+func (l *DistributedLog) ObserveLeader(fn func(leader *api.Server)) (stop func()) {
+	observations := make(chan raft.Observation, 1)
+	observer := raft.NewObserver(observations, false, func(o *raft.Observation) bool {
+		_, ok := o.Data.(raft.LeaderObservation)
+		return ok
+	})
+	l.raft.RegisterObserver(observer)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case o := <-observations:
+				leader := o.Data.(raft.LeaderObservation)
+				if leader.LeaderID == "" {
+					fn(nil)
+					continue
+				}
+				l.mu.Lock()
+				loc := l.localities[string(leader.LeaderID)]
+				version := l.versions[string(leader.LeaderID)]
+				l.mu.Unlock()
+				fn(&api.Server{
+					Id:         string(leader.LeaderID),
+					RpcAddr:    string(leader.LeaderAddr),
+					IsLeader:   true,
+					IsVoter:    true,
+					Datacenter: loc.datacenter,
+					Rack:       loc.rack,
+					Version:    version,
+				})
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.raft.DeregisterObserver(observer)
+			close(done)
+		})
+	}
+}
+
+// WaitForLeader blocks until the cluster has elected a leader or the timeout passes.
+func (l *DistributedLog) WaitForLeader(timeout time.Duration) error {
+	timeoutc := time.After(timeout)
+	ticker := time.NewTicker(time.Second / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-timeoutc:
+			return errors.New("timed out waiting for a leader")
+		case <-ticker.C:
+			if addr, _ := l.raft.LeaderWithID(); addr != "" {
+				return nil
+			}
+		}
+	}
+}
+
+// Close shuts Raft down and closes the stores and the Log.
+func (l *DistributedLog) Close() error {
+	if err := l.raft.Shutdown().Error(); err != nil {
+		return err
+	}
+	if err := l.store.Close(); err != nil {
+		return err
+	}
+	return l.log.Close()
+}
+
+// requestType identifies the write encoded in a Raft log entry.
+type requestType uint8
+
+const (
+	appendRequestType requestType = iota
+	compareAndAppendRequestType
+	truncateRequestType
+	createTopicRequestType
+	reassignPartitionsRequestType
+	pauseRebalanceRequestType
+	pauseWritesRequestType
+	setConfigRequestType
+	updateACLRulesRequestType
+)
+
+// requestHeaderWidth is the size of the request type and offset argument preceding the message.
+const requestHeaderWidth = 1 + 8
+
+// fsm applies the writes committed by Raft to the Log. Every server applies the same writes
+// in the same order, so their Logs hold the same records at the same offsets. It also holds
+// the topics created through Raft, so every server knows where their partitions are.
+type fsm struct {
+	log *Log
+	// epoch is the leader epoch of the last appended record. Records of earlier epochs are
+	// fenced off. It's only accessed while applying or restoring, which Raft serializes.
+	epoch uint64
+
+	mu     sync.RWMutex
+	topics map[string]*api.Topic
+	// rebalancePaused is whether rebalancing the partitions across the servers is paused
+	rebalancePaused bool
+	// writesPaused is whether writes are paused cluster-wide, e.g. while backing up the cluster
+	writesPaused bool
+	// config is the cluster's dynamic configuration. It's replaced, never modified, when set.
+	config *api.ClusterConfig
+	// topicsChanged is signaled whenever topics are created or restored from a snapshot
+	topicsChanged chan struct{}
+	// commits notifies the observers registered with OnCommit of the records appended
+	commits commitObservers
+}
+
+var _ raft.FSM = (*fsm)(nil)
+
+// newFSM creates an FSM applying the committed writes to the log.
+func newFSM(log *Log) *fsm {
+	return &fsm{
+		log:           log,
+		topics:        make(map[string]*api.Topic),
+		config:        &api.ClusterConfig{},
+		topicsChanged: make(chan struct{}, 1),
+	}
+}
+
+// Apply applies a committed write and returns the offset it was applied at, or the error.
+func (f *fsm) Apply(entry *raft.Log) any {
+	data := entry.Data
+	if len(data) < requestHeaderWidth {
+		return fmt.Errorf("malformed raft log entry of %d bytes", len(data))
+	}
+	reqType := requestType(data[0])
+	offset := enc.Uint64(data[1:requestHeaderWidth])
+	switch reqType {
+	case truncateRequestType:
+		if err := f.log.Truncate(offset); err != nil {
+			return err
+		}
+		return offset
+	case createTopicRequestType:
+		topic := &api.Topic{}
+		if err := proto.Unmarshal(data[requestHeaderWidth:], topic); err != nil {
+			return err
+		}
+		if err := f.createTopic(topic); err != nil {
+			return err
+		}
+		return uint64(0)
+	case reassignPartitionsRequestType:
+		topic := &api.Topic{}
+		if err := proto.Unmarshal(data[requestHeaderWidth:], topic); err != nil {
+			return err
+		}
+		if err := f.reassignPartitions(topic); err != nil {
+			return err
+		}
+		return uint64(0)
+	case pauseRebalanceRequestType:
+		f.mu.Lock()
+		f.rebalancePaused = offset != 0
+		f.mu.Unlock()
+		return offset
+	case pauseWritesRequestType:
+		f.mu.Lock()
+		f.writesPaused = offset != 0
+		f.mu.Unlock()
+		return offset
+	case setConfigRequestType:
+		config := &api.ClusterConfig{}
+		if err := proto.Unmarshal(data[requestHeaderWidth:], config); err != nil {
+			return err
+		}
+		// The entry's index versions the configuration, the same on every server
+		config.Version = entry.Index
+		f.mu.Lock()
+		f.config = config
+		f.mu.Unlock()
+		return entry.Index
+	case updateACLRulesRequestType:
+		update := &api.AclRulesUpdate{}
+		if err := proto.Unmarshal(data[requestHeaderWidth:], update); err != nil {
+			return err
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.config = updateRules(f.config, update, entry.Index)
+		return f.config
+	}
+
+	record := &api.Record{}
+	if err := proto.Unmarshal(data[requestHeaderWidth:], record); err != nil {
+		return err
+	}
+	if f.writesPaused {
+		// Only Apply changes it, so it's read without the lock
+		return errWritesPaused
+	}
+	if record.LeaderEpoch < f.epoch {
+		// A later leader already appended records, so this one comes from a deposed leader
+		return fencedError(record.LeaderEpoch, f.epoch)
+	}
+	// Appends continue the trace of the request, which the entry carries
+	ctx, span := startSpan(entryContext(context.Background(), entry.Extensions), f.log.tracer, "fsm.Apply",
+		trace.WithAttributes(attribute.Int64("raft.index", int64(entry.Index))))
+	defer span.End()
+	var off uint64
+	var err error
+	switch reqType {
+	case appendRequestType:
+		off, err = f.log.AppendContext(ctx, record)
+	case compareAndAppendRequestType:
+		off, err = f.log.CompareAndAppendContext(ctx, record, offset)
+	default:
+		err = fmt.Errorf("unknown raft request type %d", reqType)
+	}
+	if err != nil {
+		return err
+	}
+	f.epoch = record.LeaderEpoch
+	f.commits.notify(off)
+	return off
+}
+
+// fencedError is the error of an append from a deposed leader of the epoch, fenced off by the
+// records of the later epoch. Its gRPC status is Unavailable, as the record wasn't stored and can
+// be produced again to the current leader.
+func fencedError(epoch, fencing uint64) error {
+	return api.NewError(codes.Unavailable, api.ReasonFenced,
+		fmt.Sprintf("append from leader epoch %d fenced off by leader epoch %d", epoch, fencing),
+		map[string]string{
+			"leader_epoch":  strconv.FormatUint(epoch, 10),
+			"fencing_epoch": strconv.FormatUint(fencing, 10),
+		})
+}
+
+// createTopic adds the topic, unless one with the same name exists.
+func (f *fsm) createTopic(topic *api.Topic) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.topics[topic.Name]; ok {
+		return api.NewError(codes.AlreadyExists, api.ReasonInvalidRequest,
+			fmt.Sprintf("topic %q already exists", topic.Name), nil)
+	}
+	f.topics[topic.Name] = topic
+	f.signalTopicsChanged()
+	return nil
+}
+
+// reassignPartitions replaces the assignments of the topic's partitions with those of update,
+// which holds the reassigned partitions only.
+func (f *fsm) reassignPartitions(update *api.Topic) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	topic, ok := f.topics[update.Name]
+	if !ok {
+		return api.NewError(codes.NotFound, api.ReasonNotFound,
+			fmt.Sprintf("topic %q does not exist", update.Name), nil)
+	}
+	for _, partition := range update.Partitions {
+		if partition.Id >= uint32(len(topic.Partitions)) {
+			return api.NewError(codes.NotFound, api.ReasonNotFound,
+				fmt.Sprintf("topic %q partition %d does not exist", update.Name, partition.Id), nil)
+		}
+	}
+	for _, partition := range update.Partitions {
+		topic.Partitions[partition.Id] = partition
+	}
+	f.signalTopicsChanged()
+	return nil
+}
+
+// signalTopicsChanged signals that the topics changed, without blocking if it's already signaled.
+func (f *fsm) signalTopicsChanged() {
+	select {
+	case f.topicsChanged <- struct{}{}:
+	default:
+	}
+}
+
+// getRebalancePaused returns whether rebalancing is paused.
+func (f *fsm) getRebalancePaused() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.rebalancePaused
+}
+
+// getWritesPaused returns whether writes are paused.
+func (f *fsm) getWritesPaused() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.writesPaused
+}
+
+// getConfig returns the cluster's dynamic configuration.
+func (f *fsm) getConfig() *api.ClusterConfig {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.config
+}
+
+// getTopics returns the topics, ordered by name.
+func (f *fsm) getTopics() []*api.Topic {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	topics := make([]*api.Topic, 0, len(f.topics))
+	for _, topic := range f.topics {
+		topics = append(topics, proto.Clone(topic).(*api.Topic))
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Name < topics[j].Name
+	})
+	return topics
+}
+
+// Snapshot takes a point-in-time snapshot of the topics and the Log, which lets Raft compact its
+// own log and send the snapshot to servers too far behind to catch up by replaying Raft's log.
+// The snapshot holds the topicsSnapshotMarker, the pausedFlags, the number of topics and each
+// topic, prefixed with its size, the cluster's configuration prefixed with its size if it was
+// set, followed by the Log's snapshot.
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	var header []byte
+	topics := f.getTopics()
+	header = enc.AppendUint64(header, topicsSnapshotMarker)
+	var paused uint64
+	if f.getRebalancePaused() {
+		paused |= rebalancePausedFlag
+	}
+	if f.getWritesPaused() {
+		paused |= writesPausedFlag
+	}
+	config := f.getConfig()
+	if config.Version != 0 {
+		paused |= configFlag
+	}
+	header = enc.AppendUint64(header, paused)
+	header = enc.AppendUint64(header, uint64(len(topics)))
+	for _, topic := range topics {
+		b, err := proto.Marshal(topic)
+		if err != nil {
+			return nil, err
+		}
+		header = enc.AppendUint64(header, uint64(len(b)))
+		header = append(header, b...)
+	}
+	if paused&configFlag != 0 {
+		b, err := proto.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+		header = enc.AppendUint64(header, uint64(len(b)))
+		header = append(header, b...)
+	}
+	r, err := f.log.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot{reader: r, header: header}, nil
+}
+
+// Restore replaces the topics, the cluster's configuration and the Log's records with those of
+// the snapshot.
+func (f *fsm) Restore(r io.ReadCloser) error {
+	defer r.Close()
+	topics := make(map[string]*api.Topic)
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if enc.Uint64(b) != topicsSnapshotMarker {
+		// Snapshots taken before topics existed only hold the Log, starting with its base offset
+		return f.restore(io.MultiReader(bytes.NewReader(b), r), topics, 0, &api.ClusterConfig{})
+	}
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	paused := enc.Uint64(b)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	for n := enc.Uint64(b); n > 0; n-- {
+		topic := &api.Topic{}
+		if err := readSizedMessage(r, topic); err != nil {
+			return err
+		}
+		topics[topic.Name] = topic
+	}
+	config := &api.ClusterConfig{}
+	if paused&configFlag != 0 {
+		if err := readSizedMessage(r, config); err != nil {
+			return err
+		}
+	}
+	return f.restore(r, topics, paused, config)
+}
+
+// readSizedMessage reads a message prefixed with its size from r into m.
+func readSizedMessage(r io.Reader, m proto.Message) error {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	data := make([]byte, enc.Uint64(b))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// restore replaces the Log's records with those read from r, then the topics, what's paused and
+// the cluster's configuration.
+func (f *fsm) restore(r io.Reader, topics map[string]*api.Topic, paused uint64, config *api.ClusterConfig) error {
+	if err := f.log.Restore(r); err != nil {
+		return err
+	}
+	// Carry on fencing from the epoch of the last restored record
+	f.epoch = 0
+	if highest, err := f.log.HighestOffset(); err == nil {
+		if record, err := f.log.Read(highest); err == nil {
+			f.epoch = record.LeaderEpoch
+			f.commits.notify(highest)
+		}
+	}
+	f.mu.Lock()
+	f.topics = topics
+	f.rebalancePaused = paused&rebalancePausedFlag != 0
+	f.writesPaused = paused&writesPausedFlag != 0
+	f.config = config
+	f.mu.Unlock()
+	f.signalTopicsChanged()
+	return nil
+}
+
+// pausedFlags tell what's paused in the snapshots, and whether they hold the cluster's
+// configuration. Snapshots taken before writes could be paused only hold whether rebalancing is,
+// as 1.
+const (
+	rebalancePausedFlag = 1 << iota
+	writesPausedFlag
+	configFlag
+)
+
+// topicsSnapshotMarker starts the snapshots holding topics, telling them apart from those taken
+// before topics existed, which start with the Log's base offset and can't start with it.
+const topicsSnapshotMarker = math.MaxUint64
+
+// snapshot is a snapshot of the topics and the Log, persisted by Raft while the Log keeps being
+// written to.
+type snapshot struct {
+	header []byte        // Encoded topics
+	reader io.ReadCloser // Log's snapshot
+}
+
+var _ raft.FSMSnapshot = (*snapshot)(nil)
+
+// Persist writes the snapshot to the sink, e.g. a file of Raft's snapshot store.
+func (s *snapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := io.Copy(sink, io.MultiReader(bytes.NewReader(s.header), s.reader)); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+// Release releases the Log's files held by the snapshot.
+func (s *snapshot) Release() {
+	s.reader.Close()
+}
+
+// RaftRPC is the first byte of the connections carrying Raft RPCs, which sets them apart from
+// the gRPC and HTTP connections sharing the same port.
+const RaftRPC = 1
+
+// StreamLayer is the raft.StreamLayer connecting the servers of the cluster. Connections
+// start with the RaftRPC byte, so they can be multiplexed with other protocols on one
+// listener, and are secured with TLS when configured.
+type StreamLayer struct {
+	ln              net.Listener
+	serverTLSConfig *tls.Config // Secures accepted connections
+	peerTLSConfig   *tls.Config // Secures dialed connections
+	header          []byte      // Identifies dialed connections
+	routed          bool        // Whether accepted connections had their header read when routed
+}
+
+var _ raft.StreamLayer = (*StreamLayer)(nil)
+
+// NewStreamLayer creates a StreamLayer accepting Raft connections on ln.
+func NewStreamLayer(ln net.Listener, serverTLSConfig, peerTLSConfig *tls.Config) *StreamLayer {
+	return &StreamLayer{
+		ln:              ln,
+		serverTLSConfig: serverTLSConfig,
+		peerTLSConfig:   peerTLSConfig,
+		header:          []byte{byte(RaftRPC)},
+	}
+}
+
+// newPartitionStreamLayer creates the StreamLayer of a partition's Raft group, accepting the
+// connections routed to the group on ln. Dialed connections start with PartitionRaftRPC and the
+// group's name, which is at most 255 bytes long.
+func newPartitionStreamLayer(ln net.Listener, group string, serverTLSConfig, peerTLSConfig *tls.Config) *StreamLayer {
+	return &StreamLayer{
+		ln:              ln,
+		serverTLSConfig: serverTLSConfig,
+		peerTLSConfig:   peerTLSConfig,
+		header:          append([]byte{byte(PartitionRaftRPC), byte(len(group))}, group...),
+		routed:          true,
+	}
+}
+
+// Dial connects to the server at addr and identifies the connection as a Raft one.
+func (s *StreamLayer) Dial(addr raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.Dial("tcp", string(addr))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(s.header); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if s.peerTLSConfig != nil {
+		conn = tls.Client(conn, s.peerTLSConfig)
+	}
+	return conn, nil
+}
+
+// Accept waits for the next Raft connection.
+func (s *StreamLayer) Accept() (net.Conn, error) {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if s.routed {
+		return s.secure(conn), nil
+	}
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if b[0] != byte(RaftRPC) {
+		conn.Close()
+		return nil, fmt.Errorf("not a raft rpc")
+	}
+	return s.secure(conn), nil
+}
+
+// secure wraps the accepted connection with TLS, if configured.
+func (s *StreamLayer) secure(conn net.Conn) net.Conn {
+	if s.serverTLSConfig != nil {
+		return tls.Server(conn, s.serverTLSConfig)
+	}
+	return conn
+}
+
+// Close closes the listener.
+func (s *StreamLayer) Close() error {
+	return s.ln.Close()
+}
+
+// Addr returns the listener's address, which the other servers dial.
+func (s *StreamLayer) Addr() net.Addr {
+	return s.ln.Addr()
+}
@@ -0,0 +1,297 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/go-dynaport"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMultipleNodes(t *testing.T) {
+	var logs []*DistributedLog
+	for i := 0; i < 3; i++ {
+		l, addr := setupDistributedLog(t, i, nil)
+		if i != 0 {
+			require.NoError(t, logs[0].Join(fmt.Sprintf("%d", i), addr))
+		}
+		logs = append(logs, l)
+	}
+
+	// Every node is a member of the cluster, and the bootstrapping node leads it
+	servers, err := logs[0].GetServers()
+	require.NoError(t, err)
+	require.Len(t, servers, 3)
+	require.True(t, servers[0].IsLeader)
+	require.False(t, servers[1].IsLeader)
+	require.False(t, servers[2].IsLeader)
+
+	// Records appended to the leader are replicated to every node
+	records := []*api.Record{
+		{Value: []byte("first")},
+		{Value: []byte("second"), Headers: map[string]string{"k": "v"}},
+	}
+	for _, record := range records {
+		off, err := logs[0].Append(record)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			for _, l := range logs {
+				got, err := l.Read(off)
+				if err != nil || string(got.Value) != string(record.Value) {
+					return false
+				}
+			}
+			return true
+		}, 3*time.Second, 50*time.Millisecond)
+	}
+
+	// Compare-and-append is checked against the log as Raft applies the write
+	off, err := logs[0].CompareAndAppend(&api.Record{Value: []byte("third")}, 2)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+	_, err = logs[0].CompareAndAppend(&api.Record{Value: []byte("stale")}, 2)
+	require.True(t, errors.As(err, new(api.ErrOffsetMismatch)))
+
+	// Followers don't accept writes
+	_, err = logs[1].Append(&api.Record{Value: []byte("follower")})
+	require.ErrorIs(t, err, raft.ErrNotLeader)
+
+	// Only the leader serves linearizable reads, without writing to Raft's log
+	index := logs[0].raft.LastIndex()
+	require.NoError(t, logs[0].ReadIndex(context.Background()))
+	require.Equal(t, index, logs[0].raft.LastIndex())
+	err = logs[1].ReadIndex(context.Background())
+	require.ErrorIs(t, err, raft.ErrNotLeader)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	// Nodes that left don't receive new records
+	require.NoError(t, logs[0].Leave("1"))
+	servers, err = logs[0].GetServers()
+	require.NoError(t, err)
+	require.Len(t, servers, 2)
+	off, err = logs[0].Append(&api.Record{Value: []byte("fourth")})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := logs[2].Read(off)
+		return err == nil
+	}, 3*time.Second, 50*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	_, err = logs[1].Read(off)
+	require.True(t, errors.As(err, new(api.ErrOffsetOutOfRange)))
+}
+
+func TestSnapshotCatchUp(t *testing.T) {
+	// Compact Raft's log down to a single entry at every snapshot
+	leader, _ := setupDistributedLog(t, 0, func(c *Config) {
+		c.Raft.TrailingLogs = 1
+	})
+	for i := 0; i < 10; i++ {
+		_, err := leader.Append(&api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+	}
+	topic, err := leader.CreateTopic("orders", 2, 0)
+	require.NoError(t, err)
+	require.NoError(t, leader.raft.Snapshot().Error())
+	_, err = leader.Append(&api.Record{Value: []byte("record 10")})
+	require.NoError(t, err)
+
+	// The records and topics before the snapshot are no longer in Raft's log, so a new node only
+	// gets them by installing the snapshot
+	follower, addr := setupDistributedLog(t, 1, nil)
+	require.NoError(t, leader.Join("1", addr))
+	require.Eventually(t, func() bool {
+		_, err := follower.Read(10)
+		return err == nil
+	}, 3*time.Second, 50*time.Millisecond)
+	for off := uint64(0); off <= 10; off++ {
+		record, err := follower.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("record %d", off), string(record.Value))
+	}
+	topics := follower.Topics()
+	require.Len(t, topics, 1)
+	require.True(t, proto.Equal(topic, topics[0]))
+}
+
+func TestLeaderEpochs(t *testing.T) {
+	l, _ := setupDistributedLog(t, 0, nil)
+
+	// Records are stamped with the epoch of the leader appending them
+	off, err := l.Append(&api.Record{Value: []byte("first")})
+	require.NoError(t, err)
+	record, err := l.Read(off)
+	require.NoError(t, err)
+	require.NotZero(t, record.LeaderEpoch)
+	require.Equal(t, l.raft.CurrentTerm(), record.LeaderEpoch)
+
+	// apply applies an append from the leader of the epoch, as Raft would
+	apply := func(f *fsm, index, epoch uint64) any {
+		data, err := encodeRequest(appendRequestType, 0, &api.Record{Value: []byte("record"), LeaderEpoch: epoch})
+		require.NoError(t, err)
+		return f.Apply(&raft.Log{Index: index, Data: data})
+	}
+	newLog := func() *Log {
+		log, err := NewLog(t.TempDir(), Config{})
+		require.NoError(t, err)
+		t.Cleanup(func() { log.Remove() })
+		return log
+	}
+	f := newFSM(newLog())
+	require.Equal(t, uint64(0), apply(f, 1, 2))
+	require.Equal(t, uint64(1), apply(f, 2, 3))
+
+	// Once a later leader appended records, appends of deposed leaders are fenced off
+	res := apply(f, 3, 2)
+	st := status.Convert(res.(error))
+	require.Equal(t, codes.Unavailable, st.Code())
+	require.NotEmpty(t, st.Details())
+	require.Equal(t, api.ReasonFenced, st.Details()[0].(*errdetails.ErrorInfo).Reason)
+	_, err = f.log.Read(2)
+	require.Error(t, err)
+
+	// Servers restoring a snapshot keep fencing them off
+	snap, err := f.Snapshot()
+	require.NoError(t, err)
+	defer snap.Release()
+	s := snap.(*snapshot)
+	restored := newFSM(newLog())
+	require.NoError(t, restored.Restore(io.NopCloser(io.MultiReader(bytes.NewReader(s.header), s.reader))))
+	_, fenced := apply(restored, 4, 2).(error)
+	require.True(t, fenced)
+	require.Equal(t, uint64(2), apply(restored, 5, 3))
+}
+
+func TestLeadershipTransfer(t *testing.T) {
+	var logs []*DistributedLog
+	for i := 0; i < 3; i++ {
+		l, addr := setupDistributedLog(t, i, nil)
+		if i == 2 {
+			require.NoError(t, logs[0].JoinNonvoter(fmt.Sprintf("%d", i), addr))
+		} else if i != 0 {
+			require.NoError(t, logs[0].Join(fmt.Sprintf("%d", i), addr))
+		}
+		logs = append(logs, l)
+	}
+
+	leadership, err := logs[0].Leadership()
+	require.NoError(t, err)
+	require.Equal(t, "0", leadership.Leader.Id)
+	require.Equal(t, api.RaftState_RAFT_STATE_LEADER, leadership.State)
+	require.Nil(t, leadership.LastContact)
+	term := leadership.Term
+
+	// Only the leader hands the leadership over, and only to voters of the cluster
+	err = logs[1].TransferLeadership("1")
+	require.ErrorIs(t, err, raft.ErrNotLeader)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, codes.FailedPrecondition, status.Code(logs[0].TransferLeadership("2")))
+	require.Equal(t, codes.NotFound, status.Code(logs[0].TransferLeadership("3")))
+	require.NoError(t, logs[0].TransferLeadership("0"))
+
+	// Once transferred, every server sees the new leader in a later term
+	require.NoError(t, logs[0].TransferLeadership("1"))
+	require.Eventually(t, func() bool {
+		for _, l := range logs {
+			leadership, err := l.Leadership()
+			if err != nil || leadership.Leader == nil || leadership.Leader.Id != "1" {
+				return false
+			}
+		}
+		return true
+	}, 3*time.Second, 50*time.Millisecond)
+	leadership, err = logs[0].Leadership()
+	require.NoError(t, err)
+	require.Equal(t, api.RaftState_RAFT_STATE_FOLLOWER, leadership.State)
+	require.Greater(t, leadership.Term, term)
+	require.NotNil(t, leadership.LastContact)
+	_, err = logs[1].Append(&api.Record{Value: []byte("new leader")})
+	require.NoError(t, err)
+}
+
+// TestDistributedLogTracing verifies that the trace of an append goes on through Raft, on the
+// leader and its followers.
+func TestDistributedLogTracing(t *testing.T) {
+	var (
+		logs      []*DistributedLog
+		recorders []*tracetest.SpanRecorder
+	)
+	for i := 0; i < 2; i++ {
+		recorder := tracetest.NewSpanRecorder()
+		l, addr := setupDistributedLog(t, i, func(c *Config) {
+			c.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		})
+		if i != 0 {
+			require.NoError(t, logs[0].Join(fmt.Sprintf("%d", i), addr))
+		}
+		logs = append(logs, l)
+		recorders = append(recorders, recorder)
+	}
+
+	provider := sdktrace.NewTracerProvider()
+	ctx, produce := provider.Tracer("test").Start(context.Background(), "produce")
+	_, err := logs[0].AppendContext(ctx, &api.Record{Value: []byte("traced")})
+	require.NoError(t, err)
+	produce.End()
+
+	// traced returns the names of the spans of the produce's trace the recorder ended
+	traced := func(recorder *tracetest.SpanRecorder) map[string]bool {
+		names := make(map[string]bool)
+		for _, span := range recorder.Ended() {
+			if span.SpanContext().TraceID() == produce.SpanContext().TraceID() {
+				names[span.Name()] = true
+			}
+		}
+		return names
+	}
+	leader := traced(recorders[0])
+	for _, name := range []string{"DistributedLog.Append", "encodeRequest", "raft.Apply", "raft.StoreLogs", "Log.Sync", "fsm.Apply", "Log.Append"} {
+		require.True(t, leader[name], name)
+	}
+	require.Eventually(t, func() bool {
+		follower := traced(recorders[1])
+		return follower["raft.StoreLogs"] && follower["Log.Sync"] && follower["fsm.Apply"] && follower["Log.Append"]
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+// setupDistributedLog creates the DistributedLog of node i, with Raft timeouts short enough for
+// tests, and returns it with its Raft address. Node 0 bootstraps the cluster and waits to lead
+// it, while the others must be joined to it. fn, if set, adjusts the config.
+func setupDistributedLog(t *testing.T, i int, fn func(*Config)) (*DistributedLog, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", dynaport.Get(1)[0]))
+	require.NoError(t, err)
+
+	config := Config{}
+	config.Raft.StreamLayer = NewStreamLayer(ln, nil, nil)
+	config.Raft.LocalID = raft.ServerID(fmt.Sprintf("%d", i))
+	config.Raft.HeartbeatTimeout = 50 * time.Millisecond
+	config.Raft.ElectionTimeout = 50 * time.Millisecond
+	config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+	config.Raft.CommitTimeout = 5 * time.Millisecond
+	config.Raft.Bootstrap = i == 0
+	if fn != nil {
+		fn(&config)
+	}
+
+	l, err := NewDistributedLog(t.TempDir(), config)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	if config.Raft.Bootstrap {
+		require.NoError(t, l.WaitForLeader(3*time.Second))
+	}
+	return l, ln.Addr().String()
+}
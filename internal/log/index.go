@@ -117,6 +117,39 @@ func (i *index) Write(off uint32, pos uint64) error {
 	return nil
 }
 
+// recover drops the entries at the end of the index that weren't written, as the server crashed
+// before closing it: the file is grown to its maximum size while open, so it ends with zeroed
+// entries. Written entries hold their number and positions increasing within the store's size.
+func (i *index) recover(storeSize uint64) {
+	i.truncate(writtenEntries(i.mmap[:min(i.size, uint64(len(i.mmap)))], storeSize))
+}
+
+// writtenEntries returns how many of the index's first entries were written, given the size of
+// the store they point into.
+func writtenEntries(b []byte, storeSize uint64) uint64 {
+	var n, prev uint64
+	for ; (n+1)*entWidth <= uint64(len(b)); n++ {
+		p := n * entWidth
+		off := uint64(enc.Uint32(b[p : p+offWidth]))
+		pos := enc.Uint64(b[p+offWidth : p+entWidth])
+		if off != n || pos >= storeSize || (n > 0 && pos <= prev) {
+			break
+		}
+		prev = pos
+	}
+	return n
+}
+
+// truncate drops the entries after the first n, so the index is written to from there.
+func (i *index) truncate(n uint64) {
+	i.size = n * entWidth
+}
+
+// sync commits the memory-mapped entries to stable storage.
+func (i *index) sync() error {
+	return i.mmap.Sync(gommap.MS_SYNC)
+}
+
 // Name returns the name of the file associated with the index.
 func (i *index) Name() string {
 	return i.file.Name()
@@ -0,0 +1,183 @@
+package log
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	api "github.com/glauco/proglog/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// SegmentInfo describes a segment of a log's directory as found on disk.
+type SegmentInfo struct {
+	BaseOffset uint64 // Offset of the segment's first record
+	NextOffset uint64 // Offset following the segment's last whole record
+	Records    uint64 // Number of whole records
+	StoreBytes int64  // Size of the store file
+	IndexBytes int64  // Size of the index file
+	// TrailingBytes is what the store holds past its last whole record, e.g. as the server crashed
+	// while appending; opening the log drops it.
+	TrailingBytes int64
+	// Clean reports whether the index holds only its entries, as once the log was closed; it's
+	// grown to its maximum size while the log is open, so it isn't after a crash.
+	Clean bool
+}
+
+// Inspector reads a log's directory without modifying it, unlike NewLog, which recovers from a
+// crash by truncating the segments' files. It's meant for debugging a log's data, with its
+// server stopped: the segments are read once opened, so records appended later are left out.
+type Inspector struct {
+	segments []*inspectedSegment
+}
+
+// inspectedSegment is a segment read by an Inspector.
+type inspectedSegment struct {
+	info      SegmentInfo
+	store     *os.File // Nil if the segment has no store file
+	positions []uint64 // Positions of the whole records in the store
+}
+
+// Inspect opens the log's directory for inspection. It fails if the directory doesn't exist, and
+// returns an Inspector without segments if it holds none. The Inspector must be closed.
+func Inspect(dir string) (*Inspector, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Segments are named after their base offset, e.g. 42.store and 42.index
+	var baseOffsets []uint64
+	seen := make(map[uint64]bool)
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".store" && ext != ".index") {
+			continue
+		}
+		off, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), ext), 10, 64)
+		if err != nil || seen[off] {
+			continue
+		}
+		seen[off] = true
+		baseOffsets = append(baseOffsets, off)
+	}
+	sort.Slice(baseOffsets, func(i, j int) bool { return baseOffsets[i] < baseOffsets[j] })
+
+	i := &Inspector{}
+	for _, off := range baseOffsets {
+		s, err := inspectSegment(dir, off)
+		if err != nil {
+			i.Close()
+			return nil, err
+		}
+		i.segments = append(i.segments, s)
+	}
+	return i, nil
+}
+
+// inspectSegment reads the segment's index and finds its whole records in the store, the way
+// recovering the segment does, without truncating what follows them.
+func inspectSegment(dir string, baseOffset uint64) (*inspectedSegment, error) {
+	name := filepath.Join(dir, strconv.FormatUint(baseOffset, 10))
+	s := &inspectedSegment{info: SegmentInfo{BaseOffset: baseOffset, NextOffset: baseOffset}}
+	idx, err := os.ReadFile(name + ".index")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	s.info.IndexBytes = int64(len(idx))
+	store, err := os.Open(name + ".store")
+	if errors.Is(err, fs.ErrNotExist) {
+		s.info.Clean = len(idx) == 0
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	s.store = store
+	fi, err := store.Stat()
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	s.info.StoreBytes = fi.Size()
+
+	// Entries are written once their records are appended, so only the last ones' records may be
+	// partly written
+	storeSize := uint64(fi.Size())
+	var whole, end uint64 // Number of whole records, and where the last ends in the store
+	size := make([]byte, lenWidth)
+	for n := writtenEntries(idx, storeSize); n > 0; n-- {
+		pos := enc.Uint64(idx[(n-1)*entWidth+offWidth : n*entWidth])
+		if _, err := store.ReadAt(size, int64(pos)); errors.Is(err, io.EOF) {
+			continue
+		} else if err != nil {
+			store.Close()
+			return nil, err
+		}
+		if length := enc.Uint64(size); length <= storeSize-pos-lenWidth {
+			whole, end = n, pos+lenWidth+length
+			break
+		}
+	}
+	for n := uint64(0); n < whole; n++ {
+		s.positions = append(s.positions, enc.Uint64(idx[n*entWidth+offWidth:(n+1)*entWidth]))
+	}
+	s.info.Records = whole
+	s.info.NextOffset = baseOffset + whole
+	s.info.TrailingBytes = int64(storeSize - end)
+	s.info.Clean = uint64(len(idx)) == whole*entWidth
+	return s, nil
+}
+
+// Segments describes the log's segments, ordered by their base offsets.
+func (i *Inspector) Segments() []SegmentInfo {
+	infos := make([]SegmentInfo, len(i.segments))
+	for n, s := range i.segments {
+		infos[n] = s.info
+	}
+	return infos
+}
+
+// Read reads the record at the offset, or fails with an api.ErrOffsetOutOfRange if no segment
+// holds it.
+func (i *Inspector) Read(off uint64) (*api.Record, error) {
+	for _, s := range i.segments {
+		if off < s.info.BaseOffset || off >= s.info.NextOffset {
+			continue
+		}
+		pos := s.positions[off-s.info.BaseOffset]
+		size := make([]byte, lenWidth)
+		if _, err := s.store.ReadAt(size, int64(pos)); err != nil {
+			return nil, err
+		}
+		b := make([]byte, enc.Uint64(size))
+		if _, err := s.store.ReadAt(b, int64(pos+lenWidth)); err != nil {
+			return nil, err
+		}
+		record := &api.Record{}
+		if err := proto.Unmarshal(b, record); err != nil {
+			return nil, err
+		}
+		return record, nil
+	}
+	err := api.ErrOffsetOutOfRange{Offset: off}
+	if len(i.segments) > 0 {
+		err.Lowest = i.segments[0].info.BaseOffset
+		err.Next = i.segments[len(i.segments)-1].info.NextOffset
+	}
+	return nil, err
+}
+
+// Close closes the segments' store files.
+func (i *Inspector) Close() error {
+	var errs []error
+	for _, s := range i.segments {
+		if s.store != nil {
+			errs = append(errs, s.store.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,78 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspect(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	c.Segment.InitialOffset = 10
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for _, value := range []string{"first", "second", "third"} {
+		_, err := log.Append(&api.Record{Value: []byte(value)})
+		require.NoError(t, err)
+	}
+
+	// While the log is open, its active index is grown to its maximum size
+	require.NoError(t, log.Sync())
+	inspector, err := Inspect(dir)
+	require.NoError(t, err)
+	segments := inspector.Segments()
+	require.Len(t, segments, 2)
+	require.Equal(t, uint64(10), segments[0].BaseOffset)
+	require.Equal(t, uint64(12), segments[0].NextOffset)
+	require.Equal(t, uint64(2), segments[0].Records)
+	require.Equal(t, SegmentInfo{
+		BaseOffset: 12,
+		NextOffset: 13,
+		Records:    1,
+		StoreBytes: segments[1].StoreBytes,
+		IndexBytes: 1024,
+	}, segments[1])
+	require.NoError(t, inspector.Close())
+	require.NoError(t, log.Close())
+
+	// Once closed, a crash is simulated by a partly written record ending the store
+	store := filepath.Join(dir, "12.store")
+	f, err := os.OpenFile(store, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 42, 'p', 'a', 'r'})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	before, err := os.Stat(store)
+	require.NoError(t, err)
+
+	inspector, err = Inspect(dir)
+	require.NoError(t, err)
+	defer inspector.Close()
+	segments = inspector.Segments()
+	require.True(t, segments[0].Clean)
+	require.True(t, segments[1].Clean)
+	require.Equal(t, int64(11), segments[1].TrailingBytes)
+	record, err := inspector.Read(12)
+	require.NoError(t, err)
+	require.Equal(t, "third", string(record.Value))
+	require.Equal(t, uint64(12), record.Offset)
+	_, err = inspector.Read(13)
+	require.Equal(t, api.ErrOffsetOutOfRange{Offset: 13, Lowest: 10, Next: 13}, err)
+
+	// Inspecting leaves the files as they were
+	after, err := os.Stat(store)
+	require.NoError(t, err)
+	require.Equal(t, before.Size(), after.Size())
+
+	// A directory without segments has none to inspect
+	empty, err := Inspect(t.TempDir())
+	require.NoError(t, err)
+	require.Empty(t, empty.Segments())
+	_, err = empty.Read(0)
+	require.Equal(t, api.ErrOffsetOutOfRange{Offset: 0}, err)
+}
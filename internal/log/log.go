@@ -1,7 +1,11 @@
 package log
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io"
+	"log/slog"
 	"os"
 	"path"
 	"sort"
@@ -10,6 +14,9 @@ import (
 	"sync"
 
 	api "github.com/glauco/proglog/api/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
 )
 
 // Log represents the entire log consisting of multiple segments.
@@ -20,6 +27,9 @@ type Log struct {
 	Config        Config       // Configuration for the log, including max store/index sizes
 	activeSegment *segment     // Currently active segment for writing new records
 	segments      []*segment   // List of all segments in the log
+	synced        uint64       // Offset up to which Sync committed the records to stable storage
+	logger        *slog.Logger // Logger of the log's events, naming its directory
+	tracer        trace.Tracer // Tracer of the spans of the appends and syncs
 }
 
 // NewLog creates a new Log instance with the given directory and configuration.
@@ -31,9 +41,14 @@ func NewLog(dir string, c Config) (*Log, error) {
 	if c.Segment.MaxIndexBytes == 0 {
 		c.Segment.MaxIndexBytes = 1024 // Set default max index bytes if not provided
 	}
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
 	l := &Log{
 		Dir:    dir,
 		Config: c,
+		logger: c.Logger.With(slog.String("dir", dir)),
+		tracer: c.tracer(),
 	}
 	// Initialize segments by scanning the directory
 	return l, l.setup()
@@ -89,16 +104,70 @@ func (l *Log) setup() error {
 // Append adds a new record to the log. If the active segment is full, it creates a new segment.
 // Returns the offset where the record was appended.
 func (l *Log) Append(record *api.Record) (uint64, error) {
-	l.mu.Lock()
+	return l.AppendContext(context.Background(), record)
+}
+
+// AppendContext appends the record like Append, within the trace of ctx, e.g. of the produce
+// request: its spans tell how long the append waited for the log's lock, marshaled the record,
+// and wrote it to the store and index of the active segment.
+func (l *Log) AppendContext(ctx context.Context, record *api.Record) (off uint64, err error) {
+	ctx, span := startSpan(ctx, l.tracer, "Log.Append")
+	defer func() { endSpan(span, err) }()
+	l.lock(ctx)
+	defer l.mu.Unlock()
+	return l.append(ctx, record)
+}
+
+// CompareAndAppend adds a new record to the log only if it would be stored at the expected offset.
+// If the log head has moved, it returns an ErrOffsetMismatch and leaves the log untouched.
+// This lets writers building state machines on top of the log use optimistic concurrency.
+func (l *Log) CompareAndAppend(record *api.Record, expected uint64) (uint64, error) {
+	return l.CompareAndAppendContext(context.Background(), record, expected)
+}
+
+// CompareAndAppendContext appends the record like CompareAndAppend, within the trace of ctx,
+// like AppendContext.
+func (l *Log) CompareAndAppendContext(ctx context.Context, record *api.Record, expected uint64) (off uint64, err error) {
+	ctx, span := startSpan(ctx, l.tracer, "Log.CompareAndAppend", trace.WithAttributes(attribute.Int64("expected_offset", int64(expected))))
+	defer func() { endSpan(span, err) }()
+	l.lock(ctx)
 	defer l.mu.Unlock()
+	// Check the expected offset and append under the same lock so no other write can interleave
+	if next := l.activeSegment.nextOffset; next != expected {
+		return 0, api.ErrOffsetMismatch{Expected: expected, Next: next}
+	}
+	return l.append(ctx, record)
+}
+
+// lock takes the write lock, within a span of the wait if the span of ctx is recorded, so
+// contention on the lock shows in traces.
+func (l *Log) lock(ctx context.Context) {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		l.mu.Lock()
+		return
+	}
+	_, span := startSpan(ctx, l.tracer, "Log.lock")
+	l.mu.Lock()
+	span.End()
+}
+
+// append adds a record to the active segment, rolling to a new segment when it is maxed.
+// The caller must hold the write lock.
+func (l *Log) append(ctx context.Context, record *api.Record) (uint64, error) {
 	// Append the record to the active segment
-	off, err := l.activeSegment.Append(record)
+	off, err := l.activeSegment.Append(ctx, record)
 	if err != nil {
 		return 0, err
 	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("offset", int64(off)))
 	// If the active segment is maxed out, create a new segment
 	if l.activeSegment.IsMaxed() {
-		err = l.newSegment(off + 1)
+		if err = l.newSegment(off + 1); err != nil {
+			l.logger.Error("rolling segment failed", slog.Uint64("base_offset", off+1), slog.String("error", err.Error()))
+		} else {
+			l.logger.Debug("rolled segment", slog.Uint64("base_offset", off+1), slog.Int("segments", len(l.segments)))
+			trace.SpanFromContext(ctx).AddEvent("rolled segment", trace.WithAttributes(attribute.Int64("base_offset", int64(off+1))))
+		}
 	}
 	return off, err
 }
@@ -116,9 +185,13 @@ func (l *Log) Read(off uint64) (*api.Record, error) {
 			break
 		}
 	}
-	// If no segment contains the offset, return an error
+	// If no segment contains the offset, return an error describing the log's current range
 	if s == nil {
-		return nil, api.ErrOffsetOutOfRange{Offset: off}
+		return nil, api.ErrOffsetOutOfRange{
+			Offset: off,
+			Lowest: l.segments[0].baseOffset,
+			Next:   l.segments[len(l.segments)-1].nextOffset,
+		}
 	}
 	return s.Read(off)
 }
@@ -130,6 +203,7 @@ func (l *Log) Close() error {
 	// Close all segments in the log
 	for _, segment := range l.segments {
 		if err := segment.Close(); err != nil {
+			l.logger.Error("closing segment failed", slog.Uint64("base_offset", segment.baseOffset), slog.String("error", err.Error()))
 			return err
 		}
 	}
@@ -156,15 +230,16 @@ func (l *Log) Reset() error {
 }
 
 // Truncate removes all segments whose offsets are less than or equal to the specified value.
-// Used to trim old data from the log.
+// Used to trim old data from the log. The active segment is kept so the log can still be appended to.
 func (l *Log) Truncate(lowest uint64) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	var segments []*segment
 	// Iterate through segments and remove those whose nextOffset is less than or equal to the given value
 	for _, s := range l.segments {
-		if s.nextOffset <= lowest+1 {
+		if s != l.activeSegment && s.nextOffset <= lowest+1 {
 			if err := s.Remove(); err != nil {
+				l.logger.Error("removing truncated segment failed", slog.Uint64("base_offset", s.baseOffset), slog.String("error", err.Error()))
 				return err
 			}
 			continue
@@ -172,10 +247,94 @@ func (l *Log) Truncate(lowest uint64) error {
 		// Keep segments that should not be removed
 		segments = append(segments, s)
 	}
+	if removed := len(l.segments) - len(segments); removed > 0 {
+		l.logger.Info("truncated log",
+			slog.Uint64("lowest_offset", segments[0].baseOffset),
+			slog.Int("removed_segments", removed),
+		)
+	}
 	l.segments = segments // Update the list of segments to only include retained ones
 	return nil
 }
 
+// truncates reports whether truncating the log up to the lowest offset would remove a segment.
+func (l *Log) truncates(lowest uint64) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	s := l.segments[0]
+	return s != l.activeSegment && s.nextOffset <= lowest+1
+}
+
+// Discard drops the records at the offset and after it, so the next record appended gets the
+// offset, e.g. to replace records a Raft leader overwrote. Discarding from before the log's
+// lowest offset or past its end drops every record, and the log starts over empty at the offset.
+func (l *Log) Discard(from uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Drop the segments starting at the offset or after it, or every segment if the records
+	// between the log's end and the offset are missing
+	gap := from > l.activeSegment.nextOffset
+	l.logger.Info("discarding records", slog.Uint64("from_offset", from), slog.Uint64("next_offset", l.activeSegment.nextOffset))
+	for len(l.segments) > 0 {
+		last := l.segments[len(l.segments)-1]
+		if !gap && last.baseOffset < from {
+			break
+		}
+		if err := last.Remove(); err != nil {
+			return err
+		}
+		l.segments = l.segments[:len(l.segments)-1]
+	}
+	l.synced = min(l.synced, from)
+	if len(l.segments) == 0 {
+		return l.newSegment(from)
+	}
+	// The last segment kept holds the offset, so it's cut there and appended to again
+	last := l.segments[len(l.segments)-1]
+	if err := last.truncate(from); err != nil {
+		return err
+	}
+	l.activeSegment = last
+	if last.IsMaxed() {
+		return l.newSegment(from)
+	}
+	return nil
+}
+
+// Sync commits the records appended since the last Sync to stable storage, so they survive the
+// server crashing; until then, they may only be in memory.
+func (l *Log) Sync() error {
+	return l.SyncContext(context.Background())
+}
+
+// SyncContext commits the records like Sync, within the trace of ctx: its spans tell how long the
+// sync waited for the log's lock, and flushed and fsynced the stores and indexes.
+func (l *Log) SyncContext(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, l.tracer, "Log.Sync")
+	defer func() { endSpan(span, err) }()
+	l.lock(ctx)
+	defer l.mu.Unlock()
+	for _, s := range l.segments {
+		if s.nextOffset <= l.synced {
+			continue
+		}
+		if err := s.sync(ctx); err != nil {
+			l.logger.Error("syncing segment failed", slog.Uint64("base_offset", s.baseOffset), slog.String("error", err.Error()))
+			return err
+		}
+	}
+	l.synced = l.activeSegment.nextOffset
+	return nil
+}
+
+// bounds returns the log's lowest offset and the one its next record will get, so the log
+// holds [lowest, next).
+func (l *Log) bounds() (lowest, next uint64) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.segments[0].baseOffset, l.activeSegment.nextOffset
+}
+
 // originReader is a wrapper around a store that keeps track of its reading position.
 type originReader struct {
 	*store       // Embedded store to read from
@@ -206,6 +365,89 @@ func (o *originReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// Snapshot returns a reader over a point-in-time copy of the log, which Restore loads back:
+// the log's lowest offset followed by its records, length-prefixed as segments store them.
+// Records appended after the snapshot are left out, and segments truncated after it are kept,
+// so the snapshot can be read while the log is in use. The reader must be closed.
+func (l *Log) Snapshot() (io.ReadCloser, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	header := make([]byte, lenWidth)
+	enc.PutUint64(header, l.segments[0].baseOffset)
+	snapshot := &snapshotReader{}
+	readers := []io.Reader{bytes.NewReader(header)}
+	for _, s := range l.segments {
+		f, size, err := s.store.snapshot()
+		if err != nil {
+			snapshot.Close()
+			return nil, err
+		}
+		snapshot.files = append(snapshot.files, f)
+		readers = append(readers, io.NewSectionReader(f, 0, size))
+	}
+	snapshot.Reader = io.MultiReader(readers...)
+	return snapshot, nil
+}
+
+// snapshotReader reads a snapshot from the store files it holds open.
+type snapshotReader struct {
+	io.Reader
+	files []*os.File
+}
+
+// Close closes the store files.
+func (r *snapshotReader) Close() error {
+	var errs []error
+	for _, f := range r.files {
+		errs = append(errs, f.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Restore replaces the log's records with those of a snapshot taken by Snapshot,
+// e.g. of another server's log, starting at the snapshot's lowest offset.
+func (l *Log) Restore(r io.Reader) error {
+	header := make([]byte, lenWidth)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Drop the current records and start over at the snapshot's lowest offset
+	for _, s := range l.segments {
+		if err := s.Remove(); err != nil {
+			return err
+		}
+	}
+	l.segments, l.activeSegment = nil, nil
+	if err := l.newSegment(enc.Uint64(header)); err != nil {
+		return err
+	}
+
+	size := make([]byte, lenWidth)
+	for {
+		if _, err := io.ReadFull(r, size); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		b := make([]byte, enc.Uint64(size))
+		if _, err := io.ReadFull(r, b); err != nil {
+			return err
+		}
+		record := &api.Record{}
+		if err := proto.Unmarshal(b, record); err != nil {
+			return err
+		}
+		// Records are contiguous, so appending them gives them back their offsets
+		if _, err := l.append(context.Background(), record); err != nil {
+			return err
+		}
+	}
+}
+
 // LowestOffset returns the base offset of the oldest segment in the log.
 // This represents the lowest available offset within the entire log.
 func (l *Log) LowestOffset() (uint64, error) {
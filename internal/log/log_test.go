@@ -1,12 +1,19 @@
 package log
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"testing"
 
 	api "github.com/glauco/proglog/api/v1"
 	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -20,6 +27,9 @@ func TestLog(t *testing.T) {
 		"init with existing segments":       testInitExisting,
 		"reader":                            testReader,
 		"truncate":                          testTruncate,
+		"compare and append":                testCompareAndAppend,
+		"snapshot and restore":              testSnapshotRestore,
+		"discard":                           testDiscard,
 	} {
 		// Run each scenario using t.Run for better isolation and test reporting
 		t.Run(scenario, func(t *testing.T) {
@@ -64,6 +74,8 @@ func testOutOfRangeErr(t *testing.T, log *Log) {
 	require.Nil(t, read) // No record should be returned
 	apiErr := err.(api.ErrOffsetOutOfRange)
 	require.Equal(t, uint64(1), apiErr.Offset) // Ensure an error is returned
+	require.Equal(t, uint64(0), apiErr.Lowest) // The empty log's range starts at 0...
+	require.Equal(t, uint64(0), apiErr.Next)   // ...and holds no records yet
 }
 
 // testInitExisting tests initializing a log with existing segments.
@@ -141,3 +153,170 @@ func testTruncate(t *testing.T, log *Log) {
 	_, err = log.Read(0)
 	require.Error(t, err)
 }
+
+// testCompareAndAppend tests that a compare-and-append only succeeds when the log head is at the expected offset.
+func testCompareAndAppend(t *testing.T, log *Log) {
+	append := &api.Record{
+		Value: []byte("hello world"),
+	}
+	// Appending at the current head succeeds
+	off, err := log.CompareAndAppend(append, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	// Appending with a stale expected offset fails and reports the actual head
+	_, err = log.CompareAndAppend(append, 0)
+	apiErr := err.(api.ErrOffsetMismatch)
+	require.Equal(t, uint64(0), apiErr.Expected)
+	require.Equal(t, uint64(1), apiErr.Next)
+
+	// The failed append must not have written anything
+	_, err = log.Read(1)
+	require.Error(t, err)
+}
+
+// testSnapshotRestore tests that restoring a snapshot gives back the records the log held when
+// the snapshot was taken, at their offsets, while records appended afterwards are left out.
+func testSnapshotRestore(t *testing.T, log *Log) {
+	for i := 0; i < 5; i++ {
+		_, err := log.Append(&api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+	}
+	// Truncate the first segment, so the snapshot starts past offset 0
+	require.NoError(t, log.Truncate(1))
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.NotZero(t, lowest)
+
+	snapshot, err := log.Snapshot()
+	require.NoError(t, err)
+	defer snapshot.Close()
+	_, err = log.Append(&api.Record{Value: []byte("after snapshot")})
+	require.NoError(t, err)
+
+	restored, err := NewLog(t.TempDir(), log.Config)
+	require.NoError(t, err)
+	_, err = restored.Append(&api.Record{Value: []byte("replaced")})
+	require.NoError(t, err)
+	require.NoError(t, restored.Restore(snapshot))
+
+	restoredLowest, err := restored.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, lowest, restoredLowest)
+	for off := lowest; off < 5; off++ {
+		record, err := restored.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, record.Offset)
+		require.Equal(t, fmt.Sprintf("record %d", off), string(record.Value))
+	}
+	_, err = restored.Read(5)
+	require.Error(t, err)
+
+	// The restored log keeps going from where the snapshot ended
+	off, err := restored.Append(&api.Record{Value: []byte("after restore")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), off)
+}
+
+// testDiscard tests that discarding drops the records from the offset on, across segments, and
+// that discarding outside the log's records starts it over.
+func testDiscard(t *testing.T, log *Log) {
+	for i := 0; i < 5; i++ {
+		_, err := log.Append(&api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+	}
+	require.Greater(t, len(log.segments), 2)
+
+	// Records from the offset on are dropped, and appends take their offsets over
+	require.NoError(t, log.Discard(1))
+	_, err := log.Read(1)
+	require.Error(t, err)
+	off, err := log.Append(&api.Record{Value: []byte("replaced")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	record, err := log.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, "record 0", string(record.Value))
+	record, err = log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, "replaced", string(record.Value))
+	require.NoError(t, log.Sync())
+
+	// Discarding past the end starts the log over at the offset
+	require.NoError(t, log.Discard(10))
+	lowest, next := log.bounds()
+	require.Equal(t, uint64(10), lowest)
+	require.Equal(t, uint64(10), next)
+	off, err = log.Append(&api.Record{Value: []byte("after gap")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), off)
+}
+
+// TestLogEvents verifies that the log reports rolling and truncating its segments to its logger.
+func TestLogEvents(t *testing.T) {
+	var buf bytes.Buffer
+	c := Config{Logger: slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))}
+	c.Segment.MaxStoreBytes = 32
+	dir := t.TempDir()
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.Contains(t, buf.String(), `msg="rolled segment" dir=`+dir+" base_offset=2 segments=2")
+
+	require.NoError(t, log.Truncate(1))
+	require.Contains(t, buf.String(), `msg="truncated log" dir=`+dir+" lowest_offset=2 removed_segments=1")
+}
+
+// TestLogTracing verifies that appends and syncs continue the trace of their context, and don't
+// start traces of their own.
+func TestLogTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	log, err := NewLog(t.TempDir(), Config{TracerProvider: provider})
+	require.NoError(t, err)
+	defer log.Close()
+
+	_, err = log.Append(&api.Record{Value: []byte("untraced")})
+	require.NoError(t, err)
+	require.NoError(t, log.Sync())
+	require.Empty(t, recorder.Ended())
+
+	ctx, produce := provider.Tracer("test").Start(context.Background(), "produce")
+	_, err = log.AppendContext(ctx, &api.Record{Value: []byte("traced")})
+	require.NoError(t, err)
+	require.NoError(t, log.SyncContext(ctx))
+	produce.End()
+
+	parents := make(map[string]string)
+	var sync sdktrace.ReadOnlySpan
+	names := make(map[trace.SpanID]string)
+	for _, span := range recorder.Ended() {
+		names[span.SpanContext().SpanID()] = span.Name()
+		if span.Name() == "store.Sync" {
+			sync = span
+		}
+	}
+	for _, span := range recorder.Ended() {
+		parents[span.Name()] = names[span.Parent().SpanID()]
+	}
+	require.Equal(t, map[string]string{
+		"produce":        "",
+		"Log.Append":     "produce",
+		"Log.Sync":       "produce",
+		"Log.lock":       "Log.Sync",
+		"segment.Append": "Log.Append",
+		"proto.Marshal":  "segment.Append",
+		"store.Append":   "segment.Append",
+		"index.Write":    "segment.Append",
+		"store.Sync":     "Log.Sync",
+		"index.Sync":     "Log.Sync",
+	}, parents)
+	require.Len(t, sync.Events(), 2)
+	require.Equal(t, "flushed buffer", sync.Events()[0].Name)
+	require.Equal(t, "fsynced file", sync.Events()[1].Name)
+}
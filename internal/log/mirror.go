@@ -0,0 +1,146 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"strconv"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// MirrorOffsetHeader is the record header holding the offset a mirrored record has in the source
+// cluster's log, mapping the destination's offsets to the source's.
+const MirrorOffsetHeader = "proglog.mirror.offset"
+
+// defaultMirrorRetryInterval is how long a mirror waits by default before retrying after a failure.
+const defaultMirrorRetryInterval = time.Second
+
+// Mirror copies the records of a source cluster into a destination cluster, e.g. to fail over to
+// another datacenter or to serve reads from another region. It consumes the source's log from
+// where the destination left off and produces every record to the destination, tagged with its
+// source offset in the MirrorOffsetHeader, until its context is canceled. Failures are logged and
+// retried, resuming from the destination's log, so a mirror can be restarted at any time without
+// skipping or duplicating records.
+type Mirror struct {
+	Source      api.LogClient // Source is the client of the cluster records are consumed from.
+	Destination api.LogClient // Destination is the client of the cluster records are produced to.
+	// PreserveOffsets stores every record at its source offset in the destination, producing it
+	// with an expected offset, so consumers can fail over without translating their offsets. The
+	// destination's log must then only be written by the mirror, and hold the source's records
+	// from its first one. Otherwise, offsets are mapped through the MirrorOffsetHeader, and the
+	// destination may take other writes.
+	PreserveOffsets bool
+	// Acks is how durably the destination must store each record before the next is mirrored;
+	// ACKS_REPLICATED makes sure mirrored records survive a minority of the destination failing.
+	Acks api.Acks
+	// RetryInterval is how long the mirror waits before retrying after a failure; 0 defaults to
+	// a second.
+	RetryInterval time.Duration
+	Logger        *slog.Logger // Logger receives mirroring errors; defaults to slog.Default().
+}
+
+// Run mirrors the source into the destination until the context is canceled.
+func (m *Mirror) Run(ctx context.Context) error {
+	if m.Logger == nil {
+		m.Logger = slog.Default()
+	}
+	if m.RetryInterval == 0 {
+		m.RetryInterval = defaultMirrorRetryInterval
+	}
+	for {
+		err := m.mirror(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if status.Code(err) == codes.DeadlineExceeded {
+			// The source closed the idle stream, so resume right away
+			continue
+		}
+		m.Logger.Error("failed to mirror",
+			slog.String("component", "mirror"),
+			slog.String("error", err.Error()))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(m.RetryInterval):
+		}
+	}
+}
+
+// mirror consumes the source's log from where the destination left off and produces every record
+// to the destination, until either fails or the context is canceled.
+func (m *Mirror) mirror(ctx context.Context) error {
+	next, err := m.resume(ctx)
+	if err != nil {
+		return err
+	}
+	stream, err := m.Source.ConsumeStream(ctx, &api.ConsumeRequest{Offset: next})
+	if err != nil {
+		return err
+	}
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		rec := res.GetRecord()
+		// The source's log position and timestamp don't carry over to the destination's log
+		mirrored := proto.Clone(rec).(*api.Record)
+		mirrored.Offset, mirrored.AppendTime = 0, nil
+		mirrored.Headers = maps.Clone(rec.GetHeaders())
+		if mirrored.Headers == nil {
+			mirrored.Headers = make(map[string]string, 1)
+		}
+		mirrored.Headers[MirrorOffsetHeader] = strconv.FormatUint(rec.GetOffset(), 10)
+		req := &api.ProduceRequest{Record: mirrored, Acks: m.Acks}
+		if m.PreserveOffsets {
+			req.ExpectedOffset = proto.Uint64(rec.GetOffset())
+		}
+		if _, err := m.Destination.Produce(ctx, req); err != nil {
+			return err
+		}
+	}
+}
+
+// resume returns the source offset to mirror from: the one following the last record mirrored
+// into the destination, or 0 if none was.
+func (m *Mirror) resume(ctx context.Context) (uint64, error) {
+	res, err := m.Destination.Consume(ctx, &api.ConsumeRequest{RelativeOffset: -1})
+	if status.Code(err) == codes.OutOfRange {
+		// The destination is empty, so mirror the source from its first record
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if m.PreserveOffsets {
+		return res.GetRecord().GetOffset() + 1, nil
+	}
+	// Look for the last mirrored record, the destination taking other writes too
+	for {
+		rec := res.GetRecord()
+		if value, ok := rec.GetHeaders()[MirrorOffsetHeader]; ok {
+			source, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return source + 1, nil
+		}
+		if rec.GetOffset() == 0 {
+			return 0, nil
+		}
+		res, err = m.Destination.Consume(ctx, &api.ConsumeRequest{Offset: rec.GetOffset() - 1})
+		if status.Code(err) == codes.OutOfRange {
+			// The records before were truncated, and none of those left were mirrored
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
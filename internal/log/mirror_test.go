@@ -0,0 +1,145 @@
+package log
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/auth"
+	"github.com/glauco/proglog/internal/config"
+	"github.com/glauco/proglog/internal/server"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func TestMirror(t *testing.T) {
+	source, mapped, preserved := setupMirroredServer(t), setupMirroredServer(t), setupMirroredServer(t)
+	ctx := context.Background()
+	produce := func(s *mirroredServer, value string) {
+		_, err := s.client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte(value)}})
+		require.NoError(t, err)
+	}
+	// run mirrors the source into the destination until the returned func stops it
+	run := func(destination *mirroredServer, preserveOffsets bool) func() {
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() {
+			m := &Mirror{
+				Source:          source.client,
+				Destination:     destination.client,
+				PreserveOffsets: preserveOffsets,
+				RetryInterval:   10 * time.Millisecond,
+			}
+			done <- m.Run(ctx)
+		}()
+		return func() {
+			cancel()
+			require.NoError(t, <-done)
+		}
+	}
+	produce(source, "first")
+	produce(source, "second")
+
+	// Mirrored records are tagged with their source offsets, apart from the destination's own
+	produce(mapped, "local")
+	stop := run(mapped, false)
+	require.Eventually(t, func() bool { return logSize(mapped.log) == 3 }, 3*time.Second, 10*time.Millisecond)
+	for off, want := range map[uint64]string{1: "0", 2: "1"} {
+		rec, err := mapped.log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, want, rec.Headers[MirrorOffsetHeader])
+	}
+
+	// Restarted, the mirror resumes where it stopped, skipping the destination's own writes
+	stop()
+	produce(source, "third")
+	produce(mapped, "local again")
+	stop = run(mapped, false)
+	require.Eventually(t, func() bool { return logSize(mapped.log) == 5 }, 3*time.Second, 10*time.Millisecond)
+	stop()
+	rec, err := mapped.log.Read(4)
+	require.NoError(t, err)
+	require.Equal(t, "third", string(rec.Value))
+	require.Equal(t, "2", rec.Headers[MirrorOffsetHeader])
+
+	// Preserving offsets, the destination stores every record at its source offset
+	stop = run(preserved, true)
+	defer stop()
+	produce(source, "fourth")
+	require.Eventually(t, func() bool { return logSize(preserved.log) == 4 }, 3*time.Second, 10*time.Millisecond)
+	for off, want := range []string{"first", "second", "third", "fourth"} {
+		rec, err := preserved.log.Read(uint64(off))
+		require.NoError(t, err)
+		require.Equal(t, want, string(rec.Value))
+	}
+}
+
+// mirroredServer is a gRPC server a mirror copies records from or to, with its log and a client.
+type mirroredServer struct {
+	log    *Log
+	client api.LogClient
+}
+
+// setupMirroredServer starts a gRPC server backed by a Log, and a client of it with the root
+// client's certificate. Everything is torn down when the test ends.
+func setupMirroredServer(t *testing.T) *mirroredServer {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	log, err := NewLog(t.TempDir(), Config{})
+	require.NoError(t, err)
+
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.ServerCertFile,
+		KeyFile:       config.ServerKeyFile,
+		CAFile:        config.CAFile,
+		ServerAddress: l.Addr().String(),
+		Server:        true,
+	})
+	require.NoError(t, err)
+	srv, err := server.NewGRPCServer(&server.Config{
+		CommitLog:  log,
+		Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile),
+	}, server.WithTLS(serverTLSConfig))
+	require.NoError(t, err)
+	go srv.Serve(l)
+
+	clientTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.RootClientCertFile,
+		KeyFile:  config.RootClientKeyFile,
+		CAFile:   config.CAFile,
+	})
+	require.NoError(t, err)
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(clientTLSConfig))}
+	conn, err := grpc.NewClient(l.Addr().String(), opts...)
+	require.NoError(t, err)
+
+	s := &mirroredServer{
+		log:    log,
+		client: api.NewLogClient(conn),
+	}
+
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Stop()
+		log.Remove()
+	})
+	return s
+}
+
+// logSize returns the number of records in the log.
+func logSize(l *Log) int {
+	// The tests don't truncate, so records are read from offset 0 until there are no more
+	n := 0
+	for {
+		if _, err := l.Read(uint64(n)); err != nil {
+			return n
+		}
+		n++
+	}
+}
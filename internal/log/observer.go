@@ -0,0 +1,92 @@
+package log
+
+import (
+	"sync"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/hashicorp/raft"
+)
+
+// OnLeaderChange calls fn whenever the server gains or loses the leadership, with whether it now
+// leads, until the returned func stops observing, so embedders can e.g. start or stop work only
+// the leader does without polling Raft. fn is called like ObserveLeader calls its func, and only
+// on changes: not for whether the server leads when it starts observing.
+func (l *DistributedLog) OnLeaderChange(fn func(leading bool)) (stop func()) {
+	id := string(l.config.Raft.LocalID)
+	leading := l.raft.State() == raft.Leader
+	return l.ObserveLeader(func(leader *api.Server) {
+		// Observations also report other servers taking over from one another
+		if now := leader != nil && leader.Id == id; now != leading {
+			leading = now
+			fn(leading)
+		}
+	})
+}
+
+// OnCommit calls fn with the offset of the records the server applies once committed, until the
+// returned func stops observing, so embedders can e.g. invalidate caches or count records
+// without polling the log. fn is called by a goroutine of its own, in order, so it may block;
+// records applied meanwhile are coalesced into a single call with the highest offset. Restoring
+// a snapshot calls it with the offset of the snapshot's last record.
+func (l *DistributedLog) OnCommit(fn func(offset uint64)) (stop func()) {
+	return l.fsm.commits.observe(fn)
+}
+
+// commitObservers notifies the observers registered with OnCommit of the offsets applied.
+type commitObservers struct {
+	mu        sync.Mutex
+	observers map[*commitObserver]struct{}
+}
+
+// commitObserver holds the latest offset applied that its observer hasn't been called with.
+type commitObserver struct {
+	offsets chan uint64 // Holds at most the latest offset, replacing those not taken yet
+	done    chan struct{}
+}
+
+// observe calls fn with the offsets notified, from a goroutine of its own, until stopped.
+func (c *commitObservers) observe(fn func(offset uint64)) (stop func()) {
+	o := &commitObserver{
+		offsets: make(chan uint64, 1),
+		done:    make(chan struct{}),
+	}
+	c.mu.Lock()
+	if c.observers == nil {
+		c.observers = make(map[*commitObserver]struct{})
+	}
+	c.observers[o] = struct{}{}
+	c.mu.Unlock()
+	go func() {
+		for {
+			select {
+			case <-o.done:
+				return
+			case off := <-o.offsets:
+				fn(off)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			delete(c.observers, o)
+			c.mu.Unlock()
+			close(o.done)
+		})
+	}
+}
+
+// notify hands the offset to every observer without blocking, replacing the offsets they haven't
+// taken yet. Only the FSM notifies, one offset at a time, so the offset always ends up held.
+func (c *commitObservers) notify(off uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for o := range c.observers {
+		select {
+		case <-o.offsets:
+		default:
+		}
+		o.offsets <- off
+	}
+}
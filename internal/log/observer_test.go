@@ -0,0 +1,72 @@
+package log
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObservers(t *testing.T) {
+	leader, _ := setupDistributedLog(t, 0, nil)
+	follower, addr := setupDistributedLog(t, 1, nil)
+	require.NoError(t, leader.Join("1", addr))
+
+	var (
+		mu        sync.Mutex
+		committed uint64
+		changes   []string
+	)
+	stop := follower.OnCommit(func(offset uint64) {
+		mu.Lock()
+		defer mu.Unlock()
+		committed = offset
+	})
+	defer stop()
+	for i, l := range []*DistributedLog{leader, follower} {
+		stop := l.OnLeaderChange(func(leading bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			if leading {
+				changes = append(changes, fmt.Sprintf("%d leads", i))
+			} else {
+				changes = append(changes, fmt.Sprintf("%d follows", i))
+			}
+		})
+		defer stop()
+	}
+
+	// Followers see the records committed without polling, the latest last
+	for i := 0; i < 3; i++ {
+		_, err := leader.Append(&api.Record{Value: []byte("record")})
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return committed == 2
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// Servers see themselves gain and lose the leadership, not other servers taking it over
+	require.NoError(t, leader.TransferLeadership("1"))
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(changes) == 2
+	}, 3*time.Second, 50*time.Millisecond)
+	mu.Lock()
+	require.ElementsMatch(t, []string{"0 follows", "1 leads"}, changes)
+	mu.Unlock()
+
+	// Once stopped, observers aren't called anymore
+	stop()
+	_, err := follower.Append(&api.Record{Value: []byte("record")})
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, uint64(2), committed)
+}
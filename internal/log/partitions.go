@@ -0,0 +1,461 @@
+package log
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc/codes"
+)
+
+// DefaultTopic is the topic held by the cluster's own DistributedLog, which every server
+// replicates. Other topics are created with CreateTopic and their partitions replicated by
+// the servers they're assigned to, each partition by its own Raft group.
+const DefaultTopic = "default"
+
+// PartitionRaftRPC is the first byte of the connections carrying the Raft RPCs of a partition's
+// Raft group. The length of the group's name and the name follow, so the partitions' connections
+// can share a listener and be routed to their group.
+const PartitionRaftRPC = 2
+
+// routeTimeout bounds how long routing an accepted connection waits for its group's name.
+const routeTimeout = 5 * time.Second
+
+// PartitionsConfig configures the Raft groups of the partitions a server replicates.
+type PartitionsConfig struct {
+	// DataDir holds a directory per replicated partition, under topics/<topic>/<partition>.
+	DataDir string
+	// Listener accepts the connections of the partitions' Raft groups, which start with
+	// PartitionRaftRPC. It's usually matched on the port the cluster's Raft group uses, as the
+	// servers dial the partitions' groups on the addresses they have in that group.
+	Listener        net.Listener
+	ServerTLSConfig *tls.Config // ServerTLSConfig secures the accepted Raft connections.
+	PeerTLSConfig   *tls.Config // PeerTLSConfig secures the dialed Raft connections.
+	// Config is the template of the partitions' config, e.g. the local ID and Raft timeouts,
+	// which should match the cluster's. Its stream layer and bootstrap settings are ignored.
+	Config Config
+	Logger *slog.Logger // Logger receives the partitions' logs; defaults to slog.Default().
+	// RebalanceInterval is how often the partitions are rebalanced across the servers; 0 defaults
+	// to a minute, and a negative interval only rebalances when triggered.
+	RebalanceInterval time.Duration
+	// MaxMoves caps how many replicas a rebalance moves, throttling the data copied across the
+	// cluster to catch the new replicas up; 0 defaults to 1.
+	MaxMoves int
+}
+
+// Partitions runs a DistributedLog for every partition assigned to the server, replicated with
+// the other replicas of the partition by its own Raft group. Writes to different partitions
+// thus go through different leaders, so write throughput scales with the servers rather than
+// serializing through the cluster's leader. The topics and the assignment of their partitions
+// are committed by the cluster's Raft group, which Partitions watches to start replicating the
+// partitions as they're assigned to the server.
+type Partitions struct {
+	PartitionsConfig
+	cluster *DistributedLog // Log whose Raft group commits the topics
+	router  *partitionRouter
+	gate    *writeGate // Gate of the partitions' appends, closed while writes are paused
+	logger  *slog.Logger
+
+	mu        sync.Mutex
+	logs      map[string]*DistributedLog // Logs of the replicated partitions, by Raft group name
+	backingUp bool                       // Whether the server is backing up the cluster
+	closed    bool
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewPartitions starts replicating the partitions assigned to the server by the cluster's log,
+// and keeps doing so as topics are created.
+func NewPartitions(cluster *DistributedLog, config PartitionsConfig) (*Partitions, error) {
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	if config.RebalanceInterval == 0 {
+		config.RebalanceInterval = defaultRebalanceInterval
+	}
+	if config.MaxMoves == 0 {
+		config.MaxMoves = defaultMaxMoves
+	}
+	p := &Partitions{
+		PartitionsConfig: config,
+		cluster:          cluster,
+		router:           newPartitionRouter(config.Listener),
+		gate:             &writeGate{paused: cluster.WritesPaused},
+		logger:           config.Logger.With(slog.String("component", "partitions")),
+		logs:             make(map[string]*DistributedLog),
+		done:             make(chan struct{}),
+	}
+	if err := p.sync(); err != nil {
+		p.Close()
+		return nil, err
+	}
+	p.wg.Add(2)
+	go func() {
+		defer p.wg.Done()
+		p.router.serve()
+	}()
+	go func() {
+		defer p.wg.Done()
+		p.watch()
+	}()
+	return p, nil
+}
+
+// watch starts and stops replicating partitions whenever the topics change, and reconciles and
+// rebalances them periodically, until closed.
+func (p *Partitions) watch() {
+	reconcile := time.NewTicker(reconcileInterval)
+	defer reconcile.Stop()
+	var rebalance <-chan time.Time
+	if p.RebalanceInterval > 0 {
+		ticker := time.NewTicker(p.RebalanceInterval)
+		defer ticker.Stop()
+		rebalance = ticker.C
+	}
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.cluster.TopicsChanged():
+			if err := p.sync(); err != nil {
+				p.logger.Error("failed to replicate partitions", slog.String("error", err.Error()))
+			}
+		case <-reconcile.C:
+			if err := p.sync(); err != nil {
+				p.logger.Error("failed to replicate partitions", slog.String("error", err.Error()))
+			}
+			p.reconcile()
+			p.resumeAbandonedWrites()
+			p.enforceRetention()
+		case <-rebalance:
+			p.rebalance()
+		}
+	}
+}
+
+// sync starts a DistributedLog for every partition assigned to the server that doesn't have one,
+// and stops those of the partitions moved to other servers once their Raft group removed the server.
+func (p *Partitions) sync() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	var errs []error
+	assigned := make(map[string]bool)
+	for _, topic := range p.cluster.Topics() {
+		for _, partition := range topic.Partitions {
+			group := groupName(topic.Name, partition.Id)
+			if !isReplica(partition, string(p.Config.Raft.LocalID)) {
+				continue
+			}
+			assigned[group] = true
+			if _, ok := p.logs[group]; ok {
+				continue
+			}
+			l, err := p.open(topic.Name, partition)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("partition %s: %w", group, err))
+				continue
+			}
+			p.logs[group] = l
+			p.logger.Info("replicating partition", slog.String("partition", group))
+		}
+	}
+	for group, l := range p.logs {
+		if assigned[group] || p.isMember(l) {
+			continue
+		}
+		errs = append(errs, l.Close(), os.RemoveAll(p.dir(group)))
+		delete(p.logs, group)
+		p.logger.Info("stopped replicating partition", slog.String("partition", group))
+	}
+	return errors.Join(errs...)
+}
+
+// isMember reports whether the server is still a member of the partition's Raft group. Servers
+// removed from the group are sent the configuration removing them before they're cut off.
+func (p *Partitions) isMember(l *DistributedLog) bool {
+	servers, err := l.GetServers()
+	if err != nil {
+		return true
+	}
+	for _, srv := range servers {
+		if srv.Id == string(p.Config.Raft.LocalID) {
+			return true
+		}
+	}
+	return false
+}
+
+// open starts the DistributedLog of the partition. The replicas of its first assignment bootstrap
+// the partition's Raft group with the same servers, those of the committed assignment, which is
+// safe in Raft: the replicas then elect a leader among themselves. Replicas assigned later wait
+// for the group's leader to add them, and replicas restarting with state rejoin it.
+func (p *Partitions) open(topic string, partition *api.PartitionAssignment) (*DistributedLog, error) {
+	group := groupName(topic, partition.Id)
+	ln := p.router.listen(group)
+	config := p.Config
+	config.Logger = p.logger.With(slog.String("partition", group))
+	config.Raft.StreamLayer = newPartitionStreamLayer(ln, group, p.ServerTLSConfig, p.PeerTLSConfig)
+	config.Raft.Bootstrap = partition.Generation == 0
+	config.Raft.BootstrapServers = nil
+	for _, replica := range partition.Replicas {
+		config.Raft.BootstrapServers = append(config.Raft.BootstrapServers, raft.Server{
+			ID:      raft.ServerID(replica.Id),
+			Address: raft.ServerAddress(replica.RpcAddr),
+		})
+	}
+	dir := p.dir(group)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	l, err := NewDistributedLog(dir, config)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	l.gate = p.gate
+	return l, nil
+}
+
+// dir returns the directory of the partition's Raft group, e.g. topics/<topic>/<partition>.
+func (p *Partitions) dir(group string) string {
+	return filepath.Join(p.DataDir, "topics", filepath.FromSlash(group))
+}
+
+// Partition returns the log of the topic's partition. It fails with NotFound if the partition
+// doesn't exist, and with FailedPrecondition if it isn't assigned to the server, with the
+// addresses of its replicas in the error's metadata.
+func (p *Partitions) Partition(topic string, id uint32) (*DistributedLog, error) {
+	p.mu.Lock()
+	l, ok := p.logs[groupName(topic, id)]
+	p.mu.Unlock()
+	if ok {
+		return l, nil
+	}
+	for _, t := range p.cluster.Topics() {
+		if t.Name != topic || id >= uint32(len(t.Partitions)) {
+			continue
+		}
+		var addrs []string
+		for _, replica := range t.Partitions[id].Replicas {
+			addrs = append(addrs, replica.RpcAddr)
+		}
+		return nil, api.NewError(codes.FailedPrecondition, api.ReasonInvalidRequest,
+			fmt.Sprintf("topic %q partition %d isn't replicated by this server", topic, id),
+			map[string]string{"replicas": strings.Join(addrs, ",")})
+	}
+	return nil, api.NewError(codes.NotFound, api.ReasonNotFound,
+		fmt.Sprintf("topic %q partition %d does not exist", topic, id), nil)
+}
+
+// Close stops replicating the partitions, closing their logs and the listener.
+func (p *Partitions) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	var errs []error
+	for group, l := range p.logs {
+		errs = append(errs, l.Close())
+		delete(p.logs, group)
+	}
+	p.mu.Unlock()
+	errs = append(errs, p.router.close())
+	p.wg.Wait()
+	return errors.Join(errs...)
+}
+
+// groupName returns the name of the partition's Raft group, which routes its connections.
+func groupName(topic string, partition uint32) string {
+	return topic + "/" + strconv.FormatUint(uint64(partition), 10)
+}
+
+// assignPartitions assigns each partition to replicationFactor servers, going round-robin over the
+// servers from one picked by the topic's name, so the cluster's partitions are spread evenly
+// across the servers. The servers are ordered by rackAlternated, so a partition's consecutive
+// replicas are on different racks, and losing a rack doesn't lose every replica of a partition.
+func assignPartitions(topic string, partitions, replicationFactor uint32, servers []*api.Server) []*api.PartitionAssignment {
+	servers = rackAlternated(servers)
+	h := fnv.New32a()
+	h.Write([]byte(topic))
+	start := int(h.Sum32() % uint32(len(servers)))
+
+	assignments := make([]*api.PartitionAssignment, partitions)
+	for i := range assignments {
+		assignment := &api.PartitionAssignment{Id: uint32(i)}
+		for r := 0; r < int(replicationFactor); r++ {
+			srv := servers[(start+i+r)%len(servers)]
+			assignment.Replicas = append(assignment.Replicas, &api.Server{Id: srv.Id, RpcAddr: srv.RpcAddr})
+		}
+		assignments[i] = assignment
+	}
+	return assignments
+}
+
+// rackAlternated orders the servers by ID, then interleaves the racks: the first server of every
+// rack, ordered by name, then their second servers, and so on. Servers of an unknown rack are in
+// a rack of their own, so without racks the servers are only ordered by ID.
+func rackAlternated(servers []*api.Server) []*api.Server {
+	servers = append([]*api.Server(nil), servers...)
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Id < servers[j].Id
+	})
+	byRack := make(map[string][]*api.Server)
+	var racks []string
+	for _, srv := range servers {
+		if _, ok := byRack[srv.Rack]; !ok {
+			racks = append(racks, srv.Rack)
+		}
+		byRack[srv.Rack] = append(byRack[srv.Rack], srv)
+	}
+	sort.Strings(racks)
+	alternated := make([]*api.Server, 0, len(servers))
+	for i := 0; len(alternated) < len(servers); i++ {
+		for _, rack := range racks {
+			if i < len(byRack[rack]) {
+				alternated = append(alternated, byRack[rack][i])
+			}
+		}
+	}
+	return alternated
+}
+
+// partitionRouter routes the Raft connections of the partitions' groups, accepted on a single
+// listener, to the listeners of their groups. Connections to groups the server doesn't
+// replicate, e.g. yet, are closed, and their Raft RPCs retried by the dialing server.
+type partitionRouter struct {
+	ln     net.Listener
+	mu     sync.Mutex
+	groups map[string]*groupListener
+}
+
+// newPartitionRouter creates a router for the connections accepted on ln.
+func newPartitionRouter(ln net.Listener) *partitionRouter {
+	return &partitionRouter{
+		ln:     ln,
+		groups: make(map[string]*groupListener),
+	}
+}
+
+// serve routes the accepted connections until the listener is closed.
+func (r *partitionRouter) serve() {
+	for {
+		conn, err := r.ln.Accept()
+		if err != nil {
+			return
+		}
+		go r.route(conn)
+	}
+}
+
+// route reads the group's name the connection starts with and hands it to the group's listener.
+func (r *partitionRouter) route(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(routeTimeout))
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(conn, b); err != nil || b[0] != byte(PartitionRaftRPC) {
+		conn.Close()
+		return
+	}
+	name := make([]byte, b[1])
+	if _, err := io.ReadFull(conn, name); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	r.mu.Lock()
+	gl, ok := r.groups[string(name)]
+	r.mu.Unlock()
+	if !ok {
+		conn.Close()
+		return
+	}
+	select {
+	case gl.conns <- conn:
+	case <-gl.closed:
+		conn.Close()
+	}
+}
+
+// listen returns the listener of the group's connections.
+func (r *partitionRouter) listen(group string) *groupListener {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	gl := &groupListener{
+		addr:   r.ln.Addr(),
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	gl.remove = func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.groups[group] == gl {
+			delete(r.groups, group)
+		}
+	}
+	r.groups[group] = gl
+	return gl
+}
+
+// close closes the listener, so no more connections are routed. Listeners shared with other
+// protocols may already be closed, e.g. by their servers.
+func (r *partitionRouter) close() error {
+	if err := r.ln.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// groupListener is the listener of a partition's Raft group, accepting the connections routed to it.
+type groupListener struct {
+	addr   net.Addr
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+	remove func() // Removes the listener from the router
+}
+
+var _ net.Listener = (*groupListener)(nil)
+
+// Accept waits for the next connection routed to the group.
+func (l *groupListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops routing connections to the group.
+func (l *groupListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		l.remove()
+	})
+	return nil
+}
+
+// Addr returns the address of the shared listener.
+func (l *groupListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,173 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/hashicorp/raft"
+	"github.com/soheilhy/cmux"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/go-dynaport"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAssignPartitions(t *testing.T) {
+	servers := []*api.Server{
+		{Id: "2", RpcAddr: "127.0.0.1:3", IsLeader: true},
+		{Id: "0", RpcAddr: "127.0.0.1:1"},
+		{Id: "1", RpcAddr: "127.0.0.1:2"},
+	}
+	assignments := assignPartitions("orders", 6, 2, servers)
+	require.Len(t, assignments, 6)
+
+	// Partitions go round-robin over the servers, so each leads and replicates as many
+	leads := make(map[string]int)
+	replicates := make(map[string]int)
+	for i, a := range assignments {
+		require.Equal(t, uint32(i), a.Id)
+		require.Len(t, a.Replicas, 2)
+		require.NotEqual(t, a.Replicas[0].Id, a.Replicas[1].Id)
+		leads[a.Replicas[0].Id]++
+		for _, r := range a.Replicas {
+			require.False(t, r.IsLeader)
+			replicates[r.Id]++
+		}
+	}
+	require.Equal(t, map[string]int{"0": 2, "1": 2, "2": 2}, leads)
+	require.Equal(t, map[string]int{"0": 4, "1": 4, "2": 4}, replicates)
+
+	// The assignment only depends on the topic and the servers, not on their order
+	reordered := []*api.Server{servers[1], servers[2], servers[0]}
+	require.Equal(t, fmt.Sprint(assignments), fmt.Sprint(assignPartitions("orders", 6, 2, reordered)))
+
+	// With racks, each partition's replicas are on different racks
+	racks := map[string]string{"0": "a", "1": "a", "2": "b", "3": "b"}
+	var racked []*api.Server
+	for id, rack := range racks {
+		racked = append(racked, &api.Server{Id: id, RpcAddr: "127.0.0.1:" + id, Rack: rack})
+	}
+	for _, a := range assignPartitions("orders", 8, 2, racked) {
+		require.NotEqual(t, racks[a.Replicas[0].Id], racks[a.Replicas[1].Id])
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	var (
+		logs       []*DistributedLog
+		partitions []*Partitions
+	)
+	for i := 0; i < 3; i++ {
+		l, p, addr := setupPartitions(t, i)
+		if i != 0 {
+			require.NoError(t, logs[0].Join(fmt.Sprintf("%d", i), addr))
+		}
+		logs = append(logs, l)
+		partitions = append(partitions, p)
+	}
+
+	// Topics are checked and created by the leader only
+	_, err := logs[0].CreateTopic(DefaultTopic, 1, 1)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = logs[0].CreateTopic("orders", 1, 4)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = logs[1].CreateTopic("orders", 1, 1)
+	require.ErrorIs(t, err, raft.ErrNotLeader)
+	topic, err := logs[0].CreateTopic("orders", 2, 0)
+	require.NoError(t, err)
+	require.Len(t, topic.Partitions, 2)
+	_, err = logs[0].CreateTopic("orders", 1, 1)
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	// Every server learns the topic, and every partition is replicated by every server
+	for _, l := range logs {
+		require.Eventually(t, func() bool {
+			return len(l.Topics()) == 1
+		}, 3*time.Second, 50*time.Millisecond)
+	}
+	for _, partition := range topic.Partitions {
+		require.Len(t, partition.Replicas, 3)
+
+		// The partition's replicas elect a leader, which accepts the partition's writes
+		var off uint64
+		require.Eventually(t, func() bool {
+			for _, p := range partitions {
+				l, err := p.Partition("orders", partition.Id)
+				if err != nil {
+					return false
+				}
+				if off, err = l.Append(&api.Record{Value: []byte("order")}); err == nil {
+					return true
+				}
+			}
+			return false
+		}, 5*time.Second, 50*time.Millisecond)
+		require.Equal(t, uint64(0), off)
+		for _, p := range partitions {
+			require.Eventually(t, func() bool {
+				l, err := p.Partition("orders", partition.Id)
+				require.NoError(t, err)
+				_, err = l.Read(off)
+				return err == nil
+			}, 3*time.Second, 50*time.Millisecond)
+		}
+	}
+
+	// The partitions' records are apart from the cluster's log
+	_, err = logs[0].Read(0)
+	require.Error(t, err)
+	_, err = partitions[0].Partition("orders", 2)
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = partitions[0].Partition("payments", 0)
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// setupPartitions creates the DistributedLog of node i like setupDistributedLog, with the Raft
+// connections of the cluster and the partitions sharing a listener, and its Partitions.
+func setupPartitions(t *testing.T, i int) (*DistributedLog, *Partitions, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", dynaport.Get(1)[0]))
+	require.NoError(t, err)
+	mux := cmux.New(ln)
+	raftLn := mux.Match(func(r io.Reader) bool {
+		b := make([]byte, 1)
+		_, err := r.Read(b)
+		return err == nil && b[0] == byte(RaftRPC)
+	})
+	partitionsLn := mux.Match(cmux.Any())
+	go mux.Serve()
+
+	config := Config{}
+	config.Raft.StreamLayer = NewStreamLayer(raftLn, nil, nil)
+	config.Raft.LocalID = raft.ServerID(fmt.Sprintf("%d", i))
+	config.Raft.HeartbeatTimeout = 50 * time.Millisecond
+	config.Raft.ElectionTimeout = 50 * time.Millisecond
+	config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+	config.Raft.CommitTimeout = 5 * time.Millisecond
+	config.Raft.Bootstrap = i == 0
+
+	dir := t.TempDir()
+	l, err := NewDistributedLog(dir, config)
+	require.NoError(t, err)
+	p, err := NewPartitions(l, PartitionsConfig{
+		DataDir:  dir,
+		Listener: partitionsLn,
+		Config:   config,
+		// Tests rebalance when they mean to
+		RebalanceInterval: -1,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		p.Close()
+		l.Close()
+		mux.Close()
+	})
+	if config.Raft.Bootstrap {
+		require.NoError(t, l.WaitForLeader(3*time.Second))
+	}
+	return l, p, ln.Addr().String()
+}
@@ -0,0 +1,252 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/hashicorp/raft"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// raftTypeHeader is the header holding the type of Raft log entries other than commands, which
+// most entries are.
+const raftTypeHeader = "raft_type"
+
+// logStore stores Raft's log entries in a Log, so Raft's log and the records share a storage
+// engine, tuned by the same segment config. Entries are records at the offset of their index,
+// their data as the value and their term as the leader epoch.
+type logStore struct {
+	*Log
+}
+
+var _ raft.LogStore = (*logStore)(nil)
+
+// newLogStore opens the log store in the directory. Raft's indexes start at 1.
+func newLogStore(dir string, c Config) (*logStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c.Segment.InitialOffset = 1
+	l, err := NewLog(dir, c)
+	if err != nil {
+		return nil, err
+	}
+	return &logStore{Log: l}, nil
+}
+
+// FirstIndex returns the index of the first entry, or 0 if there are none.
+func (s *logStore) FirstIndex() (uint64, error) {
+	lowest, next := s.bounds()
+	if lowest == next {
+		return 0, nil
+	}
+	return lowest, nil
+}
+
+// LastIndex returns the index of the last entry, or 0 if there are none.
+func (s *logStore) LastIndex() (uint64, error) {
+	lowest, next := s.bounds()
+	if lowest == next {
+		return 0, nil
+	}
+	return next - 1, nil
+}
+
+// GetLog reads the entry at the index into out, or returns raft.ErrLogNotFound.
+func (s *logStore) GetLog(index uint64, out *raft.Log) error {
+	record, err := s.Read(index)
+	if errors.As(err, new(api.ErrOffsetOutOfRange)) {
+		return raft.ErrLogNotFound
+	}
+	if err != nil {
+		return err
+	}
+	out.Index = record.Offset
+	out.Term = record.LeaderEpoch
+	out.Type = raft.LogCommand
+	if t, ok := record.Headers[raftTypeHeader]; ok {
+		typ, err := strconv.ParseUint(t, 10, 8)
+		if err != nil {
+			return err
+		}
+		out.Type = raft.LogType(typ)
+	}
+	out.Data = record.Value
+	out.Extensions = record.Key
+	out.AppendedAt = time.Time{}
+	if record.AppendTime != nil {
+		out.AppendedAt = record.AppendTime.AsTime()
+	}
+	return nil
+}
+
+// StoreLog stores the entry.
+func (s *logStore) StoreLog(entry *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{entry})
+}
+
+// StoreLogs stores the entries, in order, and syncs them to stable storage. Entries overwriting
+// others replace them and the entries after them, and entries following a gap, e.g. once a
+// snapshot was installed, start the log over. Raft stores entries in batches, so the spans of
+// storing them continue the trace of the first entry carrying one, e.g. of a produce request,
+// linking to the others'.
+func (s *logStore) StoreLogs(entries []*raft.Log) (err error) {
+	ctx := context.Background()
+	var links []trace.Link
+	for _, entry := range entries {
+		if len(entry.Extensions) == 0 {
+			continue
+		}
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			ctx = entryContext(ctx, entry.Extensions)
+			continue
+		}
+		links = append(links, trace.LinkFromContext(entryContext(context.Background(), entry.Extensions)))
+	}
+	ctx, span := startSpan(ctx, s.tracer, "raft.StoreLogs", trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("entries", len(entries))))
+	defer func() { endSpan(span, err) }()
+
+	for _, entry := range entries {
+		if _, next := s.bounds(); entry.Index != next {
+			if err := s.Discard(entry.Index); err != nil {
+				return err
+			}
+		}
+		record := &api.Record{
+			Value:       entry.Data,
+			Key:         entry.Extensions,
+			LeaderEpoch: entry.Term,
+		}
+		if entry.Type != raft.LogCommand {
+			record.Headers = map[string]string{raftTypeHeader: strconv.Itoa(int(entry.Type))}
+		}
+		if !entry.AppendedAt.IsZero() {
+			record.AppendTime = timestamppb.New(entry.AppendedAt)
+		}
+		if _, err := s.AppendContext(ctx, record); err != nil {
+			return err
+		}
+	}
+	return s.SyncContext(ctx)
+}
+
+// DeleteRange deletes the entries from min to max, inclusive: a prefix of the log, once
+// compacted into a snapshot, or a suffix, once overwritten by the leader. Segments are deleted
+// whole, so compacting may keep entries up to max until their segment only holds such entries.
+func (s *logStore) DeleteRange(min, max uint64) error {
+	lowest, next := s.bounds()
+	switch {
+	case lowest == next:
+		return nil
+	case min > lowest:
+		return s.Discard(min)
+	case max+1 >= next:
+		// Every entry is deleted; the next one stored starts the log over at its index
+		return s.Discard(lowest)
+	default:
+		return s.Truncate(max)
+	}
+}
+
+// stableStore stores Raft's stable state, e.g. its current term and vote, in a JSON file it
+// rewrites atomically on every change. There are a handful of keys, set once per election.
+type stableStore struct {
+	path string
+
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+var _ raft.StableStore = (*stableStore)(nil)
+
+// errStableKeyNotFound is the error of getting a key that was never set, which Raft expects to
+// read "not found".
+var errStableKeyNotFound = errors.New("not found")
+
+// newStableStore opens the stable store in the file, creating it on the first write.
+func newStableStore(path string) (*stableStore, error) {
+	s := &stableStore{path: path, values: make(map[string][]byte)}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.values); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Set sets the key's value and writes it to the file before returning.
+func (s *stableStore) Set(key, val []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[string(key)] = val
+	b, err := json.Marshal(s.values)
+	if err != nil {
+		return err
+	}
+	// Write a new file and rename it over the old one, so a crash leaves either whole
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(s.path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Get returns the key's value, or an error reading "not found" if it was never set.
+func (s *stableStore) Get(key []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, ok := s.values[string(key)]
+	if !ok {
+		return nil, errStableKeyNotFound
+	}
+	return val, nil
+}
+
+// SetUint64 sets the key's value to the integer.
+func (s *stableStore) SetUint64(key []byte, val uint64) error {
+	return s.Set(key, []byte(strconv.FormatUint(val, 10)))
+}
+
+// GetUint64 returns the key's integer value, or an error reading "not found" if it was never set.
+func (s *stableStore) GetUint64(key []byte) (uint64, error) {
+	val, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(val), 10, 64)
+}
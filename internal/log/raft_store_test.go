@@ -0,0 +1,119 @@
+package log
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogStore(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	s, err := newLogStore(dir, c)
+	require.NoError(t, err)
+	// entries returns entries from the first index to the last, in the term
+	entries := func(first, last, term uint64) []*raft.Log {
+		var entries []*raft.Log
+		for i := first; i <= last; i++ {
+			entries = append(entries, &raft.Log{
+				Index: i,
+				Term:  term,
+				Type:  raft.LogCommand,
+				Data:  []byte(fmt.Sprintf("entry %d", i)),
+			})
+		}
+		return entries
+	}
+	// indexes returns the store's first and last indexes
+	indexes := func() []uint64 {
+		first, err := s.FirstIndex()
+		require.NoError(t, err)
+		last, err := s.LastIndex()
+		require.NoError(t, err)
+		return []uint64{first, last}
+	}
+
+	// An empty store has no entries
+	require.Equal(t, []uint64{0, 0}, indexes())
+	require.ErrorIs(t, s.GetLog(1, &raft.Log{}), raft.ErrLogNotFound)
+
+	// Entries are read back as stored, whatever their type
+	appendedAt := time.Now().Round(0).UTC()
+	require.NoError(t, s.StoreLog(&raft.Log{
+		Index:      1,
+		Term:       1,
+		Type:       raft.LogConfiguration,
+		Data:       []byte("configuration"),
+		Extensions: []byte("extensions"),
+		AppendedAt: appendedAt,
+	}))
+	require.NoError(t, s.StoreLogs(entries(2, 10, 1)))
+	require.Equal(t, []uint64{1, 10}, indexes())
+	var entry raft.Log
+	require.NoError(t, s.GetLog(1, &entry))
+	require.Equal(t, raft.Log{
+		Index:      1,
+		Term:       1,
+		Type:       raft.LogConfiguration,
+		Data:       []byte("configuration"),
+		Extensions: []byte("extensions"),
+		AppendedAt: appendedAt,
+	}, entry)
+	require.NoError(t, s.GetLog(5, &entry))
+	require.Equal(t, raft.Log{Index: 5, Term: 1, Type: raft.LogCommand, Data: []byte("entry 5")}, entry)
+
+	// Entries overwritten by a new leader replace the suffix they conflict with
+	require.NoError(t, s.DeleteRange(8, 10))
+	require.NoError(t, s.StoreLogs(entries(8, 9, 2)))
+	require.Equal(t, []uint64{1, 9}, indexes())
+	require.NoError(t, s.GetLog(8, &entry))
+	require.Equal(t, uint64(2), entry.Term)
+
+	// Compacting deletes a prefix, as far as whole segments go
+	require.NoError(t, s.DeleteRange(1, 6))
+	first := indexes()[0]
+	require.Greater(t, first, uint64(1))
+	require.LessOrEqual(t, first, uint64(7))
+	require.NoError(t, s.GetLog(7, &entry))
+
+	// The entries survive restarts
+	require.NoError(t, s.Close())
+	s, err = newLogStore(dir, c)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{first, 9}, indexes())
+
+	// Once a snapshot is installed, every entry is deleted and the log goes on after it
+	require.NoError(t, s.DeleteRange(first, 20))
+	require.Equal(t, []uint64{0, 0}, indexes())
+	require.NoError(t, s.StoreLogs(entries(21, 22, 3)))
+	require.Equal(t, []uint64{21, 22}, indexes())
+	require.ErrorIs(t, s.GetLog(9, &entry), raft.ErrLogNotFound)
+	require.NoError(t, s.Close())
+}
+
+func TestStableStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stable.json")
+	s, err := newStableStore(path)
+	require.NoError(t, err)
+
+	// Keys never set aren't found, as Raft expects
+	_, err = s.GetUint64([]byte("CurrentTerm"))
+	require.EqualError(t, err, "not found")
+
+	// Values survive restarts
+	require.NoError(t, s.SetUint64([]byte("CurrentTerm"), 3))
+	require.NoError(t, s.Set([]byte("LastVoteCand"), []byte("0")))
+	s, err = newStableStore(path)
+	require.NoError(t, err)
+	term, err := s.GetUint64([]byte("CurrentTerm"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), term)
+	cand, err := s.Get([]byte("LastVoteCand"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("0"), cand)
+}
@@ -0,0 +1,360 @@
+package log
+
+import (
+	"log/slog"
+	"sort"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// defaultRebalanceInterval is how often the partitions are rebalanced by default.
+	defaultRebalanceInterval = time.Minute
+	// defaultMaxMoves is how many replicas a rebalance moves by default.
+	defaultMaxMoves = 1
+	// reconcileInterval is how often the partitions' leaders reconcile their Raft groups with the
+	// partitions' assignments.
+	reconcileInterval = time.Second
+)
+
+// Rebalance moves up to MaxMoves replicas across the servers and returns the moves. Replicas on
+// servers that left the cluster are moved first, then replicas of the servers holding the most
+// to those holding the least, until they hold as many give or take one. A rebalance moves one
+// replica of a partition at most, so its Raft group keeps a quorum while the new replica catches
+// up. Moves are committed as new assignments, which the partitions' leaders carry out by adding
+// the new replicas to their Raft groups, then removing the old ones.
+//
+// Only the cluster's leader rebalances; others return an error wrapping raft.ErrNotLeader.
+// Rebalancing fails with FailedPrecondition while paused.
+func (p *Partitions) Rebalance() ([]*api.PartitionMove, error) {
+	if p.cluster.RebalancePaused() {
+		return nil, api.NewError(codes.FailedPrecondition, api.ReasonInvalidRequest,
+			"rebalancing is paused", nil)
+	}
+	if p.cluster.raft.State() != raft.Leader {
+		return nil, applyError(raft.ErrNotLeader)
+	}
+	servers, err := p.cluster.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	moves, updates := planMoves(p.cluster.Topics(), servers, p.MaxMoves)
+	for _, update := range updates {
+		if err := p.cluster.reassignPartitions(update); err != nil {
+			return nil, err
+		}
+	}
+	for _, move := range moves {
+		p.logger.Info("moving replica",
+			slog.String("partition", groupName(move.Topic, move.Partition)),
+			slog.String("from", move.From),
+			slog.String("to", move.To))
+	}
+	return moves, nil
+}
+
+// PauseRebalance pauses or resumes rebalancing for every server of the cluster. Moves already
+// committed are still carried out. Only the cluster's leader pauses rebalancing.
+func (p *Partitions) PauseRebalance(paused bool) error {
+	return p.cluster.PauseRebalance(paused)
+}
+
+// rebalance runs a periodic rebalance, unless paused: the cluster's leader moves replicas, and
+// every server hands the leadership of the partitions it leads over to their preferred leaders,
+// so the partitions' leaders are spread across the servers like their replicas.
+func (p *Partitions) rebalance() {
+	if p.cluster.RebalancePaused() {
+		return
+	}
+	if p.cluster.raft.State() == raft.Leader {
+		if _, err := p.Rebalance(); err != nil {
+			p.logger.Error("failed to rebalance partitions", slog.String("error", err.Error()))
+		}
+	}
+	for group, l := range p.leading() {
+		if err := p.electPreferredLeader(l, group.assignment); err != nil {
+			p.logger.Error("failed to hand leadership over to the preferred leader",
+				slog.String("partition", group.name),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
+// reconcile makes the Raft groups of the partitions the server leads match their assignments.
+func (p *Partitions) reconcile() {
+	for group, l := range p.leading() {
+		if err := p.reconcileGroup(l, group.assignment); err != nil {
+			p.logger.Error("failed to reconcile partition",
+				slog.String("partition", group.name),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
+// ledGroup is a partition's Raft group led by the server, with the partition's topic and assignment.
+type ledGroup struct {
+	name       string
+	topic      string
+	assignment *api.PartitionAssignment
+}
+
+// leading returns the logs of the partitions whose Raft group the server leads.
+func (p *Partitions) leading() map[ledGroup]*DistributedLog {
+	groups := make(map[string]ledGroup)
+	for _, topic := range p.cluster.Topics() {
+		for _, partition := range topic.Partitions {
+			name := groupName(topic.Name, partition.Id)
+			groups[name] = ledGroup{name: name, topic: topic.Name, assignment: partition}
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	leading := make(map[ledGroup]*DistributedLog)
+	for name, l := range p.logs {
+		if group, ok := groups[name]; ok && l.raft.State() == raft.Leader {
+			leading[group] = l
+		}
+	}
+	return leading
+}
+
+// reconcileGroup adds the partition's assigned replicas missing from its Raft group as voters,
+// then removes the replicas that aren't assigned anymore, the leader itself last. Removing
+// replicas only once every assigned one votes keeps the group's quorum while replicas move.
+func (p *Partitions) reconcileGroup(l *DistributedLog, assignment *api.PartitionAssignment) error {
+	servers, err := l.GetServers()
+	if err != nil {
+		return err
+	}
+	members := make(map[string]*api.Server, len(servers))
+	for _, srv := range servers {
+		members[srv.Id] = srv
+	}
+	assigned := make(map[string]bool, len(assignment.Replicas))
+	for _, replica := range assignment.Replicas {
+		assigned[replica.Id] = true
+		srv, ok := members[replica.Id]
+		switch {
+		case !ok || srv.RpcAddr != replica.RpcAddr:
+			err = l.Join(replica.Id, replica.RpcAddr)
+		case !srv.IsVoter:
+			err = l.Promote(replica.Id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	local := string(p.Config.Raft.LocalID)
+	for _, srv := range servers {
+		if !assigned[srv.Id] && srv.Id != local {
+			if err := l.Leave(srv.Id); err != nil {
+				return err
+			}
+		}
+	}
+	if !assigned[local] {
+		// The leader steps down once its removal is committed
+		return l.Leave(local)
+	}
+	return nil
+}
+
+// electPreferredLeader hands the leadership of the partition over to its preferred leader, the
+// first of its replicas, once its Raft group matches its assignment.
+func (p *Partitions) electPreferredLeader(l *DistributedLog, assignment *api.PartitionAssignment) error {
+	if len(assignment.Replicas) == 0 {
+		return nil
+	}
+	preferred := assignment.Replicas[0].Id
+	if preferred == string(p.Config.Raft.LocalID) {
+		return nil
+	}
+	servers, err := l.GetServers()
+	if err != nil {
+		return err
+	}
+	if len(servers) != len(assignment.Replicas) {
+		return nil
+	}
+	assigned := make(map[string]bool, len(assignment.Replicas))
+	for _, replica := range assignment.Replicas {
+		assigned[replica.Id] = true
+	}
+	for _, srv := range servers {
+		if !assigned[srv.Id] || !srv.IsVoter {
+			return nil
+		}
+	}
+	return l.TransferLeadership(preferred)
+}
+
+// planMoves plans up to maxMoves replica moves across the servers, and returns them with the
+// topics holding the reassigned partitions. Moves replace the replicas on servers that aren't
+// in the cluster anymore first, then even out how many replicas each server holds, moving one
+// replica of a partition at most. Replicas move to servers on racks the partition's other
+// replicas aren't on if possible, and never to spread a partition over fewer racks. The plan only
+// depends on the topics and the servers, so it moves the same replicas whichever server plans it.
+func planMoves(topics []*api.Topic, servers []*api.Server, maxMoves int) ([]*api.PartitionMove, []*api.Topic) {
+	servers = append([]*api.Server(nil), servers...)
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Id < servers[j].Id
+	})
+	load := make(map[string]int, len(servers))
+	rackOf := make(map[string]string, len(servers))
+	for _, srv := range servers {
+		load[srv.Id] = 0
+		rackOf[srv.Id] = srv.Rack
+	}
+	for _, topic := range topics {
+		for _, partition := range topic.Partitions {
+			for _, replica := range partition.Replicas {
+				if _, ok := load[replica.Id]; ok {
+					load[replica.Id]++
+				}
+			}
+		}
+	}
+
+	var (
+		moves   []*api.PartitionMove
+		updates []*api.Topic
+		moved   = make(map[string]bool) // Reassigned partitions, by Raft group name
+	)
+	// move moves the partition's i-th replica to the server
+	move := func(topic *api.Topic, partition *api.PartitionAssignment, i int, to *api.Server) {
+		from := partition.Replicas[i].Id
+		if _, ok := load[from]; ok {
+			load[from]--
+		}
+		load[to.Id]++
+		reassigned := proto.Clone(partition).(*api.PartitionAssignment)
+		reassigned.Replicas[i] = &api.Server{Id: to.Id, RpcAddr: to.RpcAddr}
+		reassigned.Generation++
+		topic.Partitions[partition.Id] = reassigned
+		moved[groupName(topic.Name, partition.Id)] = true
+		moves = append(moves, &api.PartitionMove{
+			Topic:     topic.Name,
+			Partition: partition.Id,
+			From:      from,
+			To:        to.Id,
+		})
+		if len(updates) == 0 || updates[len(updates)-1].Name != topic.Name {
+			updates = append(updates, &api.Topic{Name: topic.Name})
+		}
+		update := updates[len(updates)-1]
+		update.Partitions = append(update.Partitions, reassigned)
+	}
+	// leastLoaded returns the server to move the partition's i-th replica to: of the servers that
+	// don't replicate the partition, those on racks its other replicas aren't on come first, then
+	// those holding the fewest replicas
+	leastLoaded := func(partition *api.PartitionAssignment, i int) *api.Server {
+		racks := otherRacks(partition, i, rackOf)
+		var least *api.Server
+		for _, srv := range servers {
+			if isReplica(partition, srv.Id) {
+				continue
+			}
+			if least == nil || racks[least.Rack] && !racks[srv.Rack] ||
+				racks[least.Rack] == racks[srv.Rack] && load[srv.Id] < load[least.Id] {
+				least = srv
+			}
+		}
+		return least
+	}
+
+	// Replace the replicas on servers that left the cluster
+	for _, topic := range topics {
+		for _, partition := range topic.Partitions {
+			if len(moves) >= maxMoves {
+				return moves, updates
+			}
+			for i, replica := range partition.Replicas {
+				if _, ok := load[replica.Id]; ok {
+					continue
+				}
+				if to := leastLoaded(partition, i); to != nil {
+					move(topic, partition, i, to)
+				}
+				break
+			}
+		}
+	}
+
+	// Move replicas from the servers holding the most to those holding the least
+	for len(moves) < maxMoves && len(servers) > 1 {
+		most, least := servers[0], servers[0]
+		for _, srv := range servers {
+			if load[srv.Id] > load[most.Id] {
+				most = srv
+			}
+			if load[srv.Id] < load[least.Id] {
+				least = srv
+			}
+		}
+		if load[most.Id]-load[least.Id] <= 1 {
+			break
+		}
+		if !moveReplica(topics, moved, rackOf, most.Id, least, move) {
+			break
+		}
+	}
+	return moves, updates
+}
+
+// moveReplica moves a replica of a partition not moved yet from the server with the given ID to
+// the other, if one isn't replicated by the latter already and the move doesn't spread it over
+// fewer racks, and reports whether it did.
+func moveReplica(
+	topics []*api.Topic,
+	moved map[string]bool,
+	rackOf map[string]string,
+	from string,
+	to *api.Server,
+	move func(*api.Topic, *api.PartitionAssignment, int, *api.Server),
+) bool {
+	for _, topic := range topics {
+		for _, partition := range topic.Partitions {
+			if moved[groupName(topic.Name, partition.Id)] || isReplica(partition, to.Id) {
+				continue
+			}
+			for i, replica := range partition.Replicas {
+				if replica.Id != from {
+					continue
+				}
+				if racks := otherRacks(partition, i, rackOf); racks[to.Rack] && !racks[rackOf[from]] {
+					// The partition would lose a rack
+					break
+				}
+				move(topic, partition, i, to)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// otherRacks returns the racks of the partition's replicas but the i-th, of those still in the
+// cluster.
+func otherRacks(partition *api.PartitionAssignment, i int, rackOf map[string]string) map[string]bool {
+	racks := make(map[string]bool, len(partition.Replicas))
+	for j, replica := range partition.Replicas {
+		if rack, ok := rackOf[replica.Id]; ok && j != i {
+			racks[rack] = true
+		}
+	}
+	return racks
+}
+
+// isReplica reports whether the server with the ID replicates the partition.
+func isReplica(partition *api.PartitionAssignment, id string) bool {
+	for _, replica := range partition.Replicas {
+		if replica.Id == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPlanMoves(t *testing.T) {
+	servers := func(ids ...string) []*api.Server {
+		var servers []*api.Server
+		for _, id := range ids {
+			servers = append(servers, &api.Server{Id: id, RpcAddr: "addr-" + id})
+		}
+		return servers
+	}
+	// racked places the servers on the racks, in order
+	racked := func(servers []*api.Server, racks ...string) []*api.Server {
+		for i, rack := range racks {
+			servers[i].Rack = rack
+		}
+		return servers
+	}
+	// topic returns a topic whose partitions are replicated by the servers with the IDs
+	topic := func(replicas ...[]string) *api.Topic {
+		topic := &api.Topic{Name: "orders"}
+		for i, ids := range replicas {
+			topic.Partitions = append(topic.Partitions, &api.PartitionAssignment{
+				Id:       uint32(i),
+				Replicas: servers(ids...),
+			})
+		}
+		return topic
+	}
+	for scenario, tc := range map[string]struct {
+		topic    *api.Topic
+		servers  []*api.Server
+		maxMoves int
+		want     []*api.PartitionMove
+	}{
+		"balanced cluster moves nothing": {
+			topic:    topic([]string{"0", "1"}, []string{"1", "2"}, []string{"2", "0"}),
+			servers:  servers("0", "1", "2"),
+			maxMoves: 3,
+		},
+		"replicas of servers that left move first": {
+			topic:    topic([]string{"0", "1"}, []string{"1", "0"}, []string{"0", "3"}),
+			servers:  servers("0", "1", "2"),
+			maxMoves: 3,
+			want: []*api.PartitionMove{
+				{Topic: "orders", Partition: 2, From: "3", To: "2"},
+				{Topic: "orders", Partition: 0, From: "0", To: "2"},
+			},
+		},
+		"new servers take replicas of the busiest": {
+			topic:    topic([]string{"0", "1"}, []string{"1", "0"}),
+			servers:  servers("0", "1", "2"),
+			maxMoves: 3,
+			want: []*api.PartitionMove{
+				{Topic: "orders", Partition: 0, From: "0", To: "2"},
+			},
+		},
+		"replicas move to other racks than their partition's": {
+			topic:    topic([]string{"0", "3"}, []string{"2", "1"}, []string{"2", "0"}),
+			servers:  racked(servers("0", "1", "2"), "a", "a", "b"),
+			maxMoves: 3,
+			want: []*api.PartitionMove{
+				// Server 1 holds fewer replicas, but is on the same rack as server 0; moving
+				// server 2's replicas to it would then leave their partitions on a single rack
+				{Topic: "orders", Partition: 0, From: "3", To: "2"},
+			},
+		},
+		"moves are capped": {
+			topic:    topic([]string{"0"}, []string{"0"}, []string{"0"}, []string{"0"}),
+			servers:  servers("0", "1", "2"),
+			maxMoves: 2,
+			want: []*api.PartitionMove{
+				{Topic: "orders", Partition: 0, From: "0", To: "1"},
+				{Topic: "orders", Partition: 1, From: "0", To: "2"},
+			},
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			moves, updates := planMoves([]*api.Topic{tc.topic}, tc.servers, tc.maxMoves)
+			require.Equal(t, fmt.Sprint(tc.want), fmt.Sprint(moves))
+			if len(tc.want) == 0 {
+				require.Empty(t, updates)
+				return
+			}
+
+			// The reassigned partitions replace the moved replicas, in a new generation
+			require.Len(t, updates, 1)
+			require.Len(t, updates[0].Partitions, len(tc.want))
+			for i, move := range tc.want {
+				partition := updates[0].Partitions[i]
+				require.Equal(t, move.Partition, partition.Id)
+				require.Equal(t, uint64(1), partition.Generation)
+				require.True(t, isReplica(partition, move.To))
+				require.False(t, isReplica(partition, move.From))
+			}
+		})
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	var (
+		logs       []*DistributedLog
+		partitions []*Partitions
+	)
+	join := func(i int) {
+		l, p, addr := setupPartitions(t, i)
+		if i != 0 {
+			require.NoError(t, logs[0].Join(fmt.Sprintf("%d", i), addr))
+		}
+		logs = append(logs, l)
+		partitions = append(partitions, p)
+	}
+	join(0)
+	join(1)
+
+	// Both servers replicate both partitions
+	_, err := logs[0].CreateTopic("orders", 2, 2)
+	require.NoError(t, err)
+	for id := uint32(0); id < 2; id++ {
+		require.Eventually(t, func() bool {
+			for _, p := range partitions {
+				l, err := p.Partition("orders", id)
+				if err != nil {
+					return false
+				}
+				if _, err := l.Append(&api.Record{Value: []byte("order")}); err == nil {
+					return true
+				}
+			}
+			return false
+		}, 5*time.Second, 50*time.Millisecond)
+	}
+
+	// Only the cluster's leader rebalances, moving a replica to the new server
+	join(2)
+	_, err = partitions[1].Rebalance()
+	require.ErrorIs(t, err, raft.ErrNotLeader)
+	moves, err := partitions[0].Rebalance()
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprint([]*api.PartitionMove{{Topic: "orders", Partition: 0, From: "0", To: "2"}}), fmt.Sprint(moves))
+
+	// The new replica catches up with the partition, and the old one is removed
+	require.Eventually(t, func() bool {
+		l, err := partitions[2].Partition("orders", 0)
+		if err != nil {
+			return false
+		}
+		_, err = l.Read(0)
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Eventually(t, func() bool {
+		_, err := partitions[0].Partition("orders", 0)
+		return status.Code(err) == codes.FailedPrecondition
+	}, 5*time.Second, 50*time.Millisecond)
+	_, err = os.Stat(filepath.Join(partitions[0].DataDir, "topics", "orders", "0"))
+	require.True(t, os.IsNotExist(err))
+	l, err := partitions[2].Partition("orders", 0)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		servers, err := l.GetServers()
+		return err == nil && len(servers) == 2 && !isReplica(&api.PartitionAssignment{Replicas: servers}, "0")
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// Once balanced, nothing moves
+	moves, err = partitions[0].Rebalance()
+	require.NoError(t, err)
+	require.Empty(t, moves)
+
+	// Pausing stops rebalancing on every server, until resumed
+	require.ErrorIs(t, partitions[1].PauseRebalance(true), raft.ErrNotLeader)
+	require.NoError(t, partitions[0].PauseRebalance(true))
+	_, err = partitions[0].Rebalance()
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.Eventually(t, func() bool {
+		return logs[2].RebalancePaused()
+	}, 3*time.Second, 50*time.Millisecond)
+	require.NoError(t, partitions[0].PauseRebalance(false))
+	_, err = partitions[0].Rebalance()
+	require.NoError(t, err)
+}
@@ -1,21 +1,27 @@
 package log
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 
 	api "github.com/glauco/proglog/api/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/proto"
 )
 
 // segment is a data structure that ties together a store and an index for a specific segment
 // of the log. It keeps track of the base offset (starting point) and the next available offset.
 type segment struct {
-	store                  *store // The store file for holding log records
-	index                  *index // The index file for keeping track of offsets
-	baseOffset, nextOffset uint64 // Base offset and next available offset for the segment
-	config                 Config // Configuration options for the segment
+	store                  *store       // The store file for holding log records
+	index                  *index       // The index file for keeping track of offsets
+	baseOffset, nextOffset uint64       // Base offset and next available offset for the segment
+	config                 Config       // Configuration options for the segment
+	tracer                 trace.Tracer // Tracer of the spans of the appends and syncs
 }
 
 // newSegment creates a new segment at the given directory with a specified base offset.
@@ -25,6 +31,7 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 	s := &segment{
 		baseOffset: baseOffset,
 		config:     c,
+		tracer:     c.tracer(),
 	}
 	var err error
 
@@ -62,6 +69,10 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 		return nil, err
 	}
 
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
 	// Determine the next offset to be used in the segment.
 	// If reading the last offset in the index fails (e.g., because it is empty),
 	// set the next offset to the base offset. Otherwise, calculate it based on the last offset read.
@@ -75,14 +86,21 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 	return s, nil
 }
 
-func (s *segment) Append(record *api.Record) (offset uint64, err error) {
+// Append adds the record to the segment at its next offset, within the trace of ctx: its spans
+// tell how long marshaling the record, and writing it to the store and the index took.
+func (s *segment) Append(ctx context.Context, record *api.Record) (offset uint64, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "segment.Append", trace.WithAttributes(attribute.Int64("base_offset", int64(s.baseOffset))))
+	defer func() { endSpan(span, err) }()
+
 	// Set the current offset to be the next available offset in the segment
 	cur := s.nextOffset
 	// Assign the current offset to the record
 	record.Offset = cur
 
 	// Marshal the record into a byte slice using protocol buffers for storage
+	_, marshalSpan := startSpan(ctx, s.tracer, "proto.Marshal")
 	p, err := proto.Marshal(record)
+	endSpan(marshalSpan, err)
 	if err != nil {
 		// Return an error if the marshaling fails
 		return 0, err
@@ -90,7 +108,9 @@ func (s *segment) Append(record *api.Record) (offset uint64, err error) {
 
 	// Append the marshaled record to the store
 	// The store returns the number of bytes written and the position where the record starts
-	_, pos, err := s.store.Append(p)
+	storeCtx, storeSpan := startSpan(ctx, s.tracer, "store.Append", trace.WithAttributes(attribute.Int("bytes", len(p))))
+	_, pos, err := s.store.AppendContext(storeCtx, p)
+	endSpan(storeSpan, err)
 	if err != nil {
 		// Return an error if appending to the store fails
 		return 0, err
@@ -98,9 +118,10 @@ func (s *segment) Append(record *api.Record) (offset uint64, err error) {
 
 	// Write the offset and the position of the record to the index
 	// Index offsets are always relative to the baseOffset of the segment
-	if err = s.index.Write(
-		uint32(s.nextOffset-uint64(s.baseOffset)), pos,
-	); err != nil {
+	_, indexSpan := startSpan(ctx, s.tracer, "index.Write")
+	err = s.index.Write(uint32(s.nextOffset-uint64(s.baseOffset)), pos)
+	endSpan(indexSpan, err)
+	if err != nil {
 		// Return an error if writing to the index fails
 		return 0, err
 	}
@@ -139,6 +160,72 @@ func (s *segment) Read(off uint64) (*api.Record, error) {
 	return record, err
 }
 
+// recover drops what the segment holds past its last whole record, e.g. as the server crashed
+// while appending: the index entries that weren't written, those whose records weren't wholly
+// written to the store, and the partial record ending the store.
+func (s *segment) recover() error {
+	s.index.recover(s.store.size)
+	size := make([]byte, lenWidth)
+	for n := s.index.size / entWidth; n > 0; n-- {
+		_, pos, err := s.index.Read(int64(n - 1))
+		if err != nil {
+			return err
+		}
+		if _, err := s.store.ReadAt(size, int64(pos)); errors.Is(err, io.EOF) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		// The read succeeded, so the store holds the record's length; compare what follows, as a
+		// partly written length may be too large to add to the position
+		if length, rest := enc.Uint64(size), s.store.size-pos-lenWidth; length <= rest {
+			s.index.truncate(n)
+			if length < rest {
+				return s.store.truncate(pos + lenWidth + length)
+			}
+			return nil
+		}
+	}
+	s.index.truncate(0)
+	if s.store.size > 0 {
+		return s.store.truncate(0)
+	}
+	return nil
+}
+
+// truncate drops the segment's records from the offset on, so the next record appended gets it.
+func (s *segment) truncate(next uint64) error {
+	if next >= s.nextOffset {
+		return nil
+	}
+	// The store is cut where the index says the first dropped record starts
+	_, pos, err := s.index.Read(int64(next - s.baseOffset))
+	if err != nil {
+		return err
+	}
+	if err := s.store.truncate(pos); err != nil {
+		return err
+	}
+	s.index.truncate(next - s.baseOffset)
+	s.nextOffset = next
+	return nil
+}
+
+// sync commits the segment's records to stable storage, within the trace of ctx: its spans tell
+// how long flushing and fsyncing the store, and syncing the index took.
+func (s *segment) sync(ctx context.Context) (err error) {
+	storeCtx, span := startSpan(ctx, s.tracer, "store.Sync", trace.WithAttributes(attribute.Int64("base_offset", int64(s.baseOffset))))
+	err = s.store.sync(storeCtx)
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+	_, span = startSpan(ctx, s.tracer, "index.Sync", trace.WithAttributes(attribute.Int64("base_offset", int64(s.baseOffset))))
+	err = s.index.sync()
+	endSpan(span, err)
+	return err
+}
+
 // Checks whether the segment has reached its maximum allowed size.
 // A segment is considered "maxed out" if either the store or index size exceeds their respective limits.
 func (s *segment) IsMaxed() bool {
@@ -1,6 +1,7 @@
 package log
 
 import (
+	"context"
 	"io"
 	"os"
 	"testing"
@@ -37,7 +38,7 @@ func TestSegment(t *testing.T) {
 	// Append three records to the segment, checking each time that the data can be read back correctly
 	for i := uint64(0); i < 3; i++ {
 		// Append the record to the segment
-		off, err := s.Append(want)
+		off, err := s.Append(context.Background(), want)
 		require.NoError(t, err) // Ensure no error during append
 		// The offset should match baseOffset + i for each appended record
 		require.Equal(t, 16+i, off)
@@ -49,7 +50,7 @@ func TestSegment(t *testing.T) {
 	}
 
 	// Attempt to append another record, which should fail as the index has reached its limit
-	_, err = s.Append(want)
+	_, err = s.Append(context.Background(), want)
 	require.Equal(t, io.EOF, err) // Expect an EOF error indicating that the index is full
 
 	// Confirm that the segment is now maxed out (index has reached maximum capacity)
@@ -77,3 +78,39 @@ func TestSegment(t *testing.T) {
 	// After recreating the segment, it should not be maxed out
 	require.False(t, s.IsMaxed())
 }
+
+// TestSegmentRecover verifies that a segment reopened after a crash drops what wasn't wholly
+// written: the zeroed entries ending its index and the partial record ending its store.
+func TestSegmentRecover(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxIndexBytes = 1024
+	s, err := newSegment(dir, 16, c)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err := s.Append(context.Background(), &api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.sync(context.Background()))
+
+	// Crash while appending a record: its length made it to the store, but not the record
+	f, err := os.OpenFile(s.store.Name(), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 42, 'h'})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// The segment reopened without being closed keeps the whole records, and goes on after them
+	s, err = newSegment(dir, 16, c)
+	require.NoError(t, err)
+	require.Equal(t, uint64(18), s.nextOffset)
+	off, err := s.Append(context.Background(), &api.Record{Value: []byte("after crash")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(18), off)
+	for off, want := range []string{"hello world", "hello world", "after crash"} {
+		got, err := s.Read(16 + uint64(off))
+		require.NoError(t, err)
+		require.Equal(t, want, string(got.Value))
+	}
+}
@@ -2,9 +2,13 @@ package log
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
 	"os"
 	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -43,10 +47,23 @@ func newStore(f *os.File) (*store, error) {
 // Append adds data to the store. It writes the length of the data followed by the data itself.
 // Returns the number of bytes written, the starting position, and any error encountered.
 func (s *store) Append(p []byte) (n uint64, pos uint64, err error) {
+	return s.AppendContext(context.Background(), p)
+}
+
+// AppendContext appends the data like Append, adding an event to the span of ctx when the
+// buffer fills up and is flushed to the file, which makes the append wait for the write.
+func (s *store) AppendContext(ctx context.Context, p []byte) (n uint64, pos uint64, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	pos = s.size
+	buffered := s.buf.Buffered()
+	defer func() {
+		// The buffer holds less than was written to it only if it was flushed in between
+		if err == nil && s.buf.Buffered() < buffered+int(n) {
+			trace.SpanFromContext(ctx).AddEvent("flushed buffer")
+		}
+	}()
 
 	// Write the length of p as an 8-byte integer, followed by the actual data
 	if err := binary.Write(s.buf, enc, uint64(len(p))); err != nil {
@@ -101,6 +118,59 @@ func (s *store) ReadAt(p []byte, off int64) (int, error) {
 	return s.File.ReadAt(p, off)
 }
 
+// snapshot flushes any buffered data and returns a new handle on the store's file with the
+// file's current size. Reading the handle up to that size gives the store's contents at the
+// time of the snapshot, even after the store is appended to, closed or removed.
+func (s *store) snapshot() (*os.File, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.buf.Flush(); err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(s.Name())
+	if err != nil {
+		return nil, 0, err
+	}
+	return f, int64(s.size), nil
+}
+
+// truncate drops the data from the position on, which must be a record's, so the store is
+// appended to from there.
+func (s *store) truncate(pos uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	// The file is opened for appending, so writes follow the new end
+	if err := s.File.Truncate(int64(pos)); err != nil {
+		return err
+	}
+	s.size = pos
+	return nil
+}
+
+// sync flushes any buffered data and commits the file to stable storage, adding events to the
+// span of ctx as each is done, so traces tell the flush from the fsync.
+func (s *store) sync(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	span := trace.SpanFromContext(ctx)
+	buffered := s.buf.Buffered()
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	span.AddEvent("flushed buffer", trace.WithAttributes(attribute.Int("bytes", buffered)))
+	if err := s.File.Sync(); err != nil {
+		return err
+	}
+	span.AddEvent("fsynced file")
+	return nil
+}
+
 // Close flushes any buffered data to disk and closes the file.
 // Ensures all data is safely written and resources are released.
 func (s *store) Close() error {
@@ -0,0 +1,55 @@
+package log
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName names the tracer of the log's spans, as OpenTelemetry names them by package.
+const tracerName = "github.com/glauco/proglog/internal/log"
+
+// traceparent is the W3C Trace Context header carrying a span's context across processes.
+const traceparent = "traceparent"
+
+// startSpan starts a span of the operation if ctx carries a trace, e.g. of a produce request.
+// Otherwise, it returns ctx and its span, which isn't recorded: the log's spans continue traces,
+// without starting their own, e.g. for each write Raft stores.
+func startSpan(ctx context.Context, tracer trace.Tracer, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return tracer.Start(ctx, name, opts...)
+}
+
+// endSpan ends the span, marking it failed with the error, if any.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceEntry returns the traceparent of the span of ctx, to carry in the Raft entry of a write
+// so the servers applying it continue the trace, or nil if the span isn't sampled, so entries
+// only grow when they're traced.
+func traceEntry(ctx context.Context) []byte {
+	if !trace.SpanContextFromContext(ctx).IsSampled() {
+		return nil
+	}
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return []byte(carrier[traceparent])
+}
+
+// entryContext returns ctx carrying the remote span context of an entry's traceparent, if
+// traceEntry set one.
+func entryContext(ctx context.Context, extensions []byte) context.Context {
+	if len(extensions) == 0 {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{traceparent: string(extensions)})
+}
@@ -0,0 +1,199 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+
+	apiv2 "github.com/glauco/proglog/api/v2"
+)
+
+// headerPrefix prefixes the names of the record headers describing the message, e.g. mqtt_topic.
+const headerPrefix = "mqtt_"
+
+// Route maps the messages published on the MQTT topics matching its filter to records of a
+// topic of the log.
+type Route struct {
+	// Filter matches the MQTT topics of the messages routed, as subscriptions do: + matches a
+	// level and # every level left, e.g. "devices/+/telemetry" or "devices/#". A level named
+	// {name} matches a level like +, and sets the record's header name to it, e.g. the device
+	// of "devices/{device}/telemetry".
+	Filter string `yaml:"filter"`
+	// Topic is the log's topic the records are appended to; the default topic when empty.
+	Topic string `yaml:"topic"`
+	// Partitions spreads the records across the topic's first partitions by key, so the
+	// records of a key stay in order; 0 and 1 append every record to partition 0.
+	Partitions uint32 `yaml:"partitions"`
+	// Key is the records' key, in which {name} is replaced by the level the filter named so,
+	// and {client_id} by the client's ID, e.g. "{device}". It's the client's ID when empty.
+	Key string `yaml:"key"`
+	// Headers are set on every record, besides the filter's named levels and the mqtt_topic,
+	// mqtt_client_id, mqtt_username, mqtt_qos, mqtt_retain and mqtt_will headers describing
+	// the message.
+	Headers map[string]string `yaml:"headers"`
+}
+
+// route is a Route parsed.
+type route struct {
+	Route
+	levels []string // Levels of the filter; named levels are held as their names in braces
+}
+
+// namedLevel matches the levels of filters and the placeholders of keys naming a level.
+var namedLevel = regexp.MustCompile(`^\{([A-Za-z0-9_.-]+)\}$`)
+
+// parseRoute validates the route's filter and key, and parses them.
+func parseRoute(r Route) (route, error) {
+	parsed := route{Route: r, levels: strings.Split(r.Filter, "/")}
+	if r.Filter == "" {
+		return route{}, fmt.Errorf("route to topic %q: empty filter", r.Topic)
+	}
+	names := map[string]bool{"client_id": true}
+	for i, level := range parsed.levels {
+		switch {
+		case level == "#" && i != len(parsed.levels)-1:
+			return route{}, fmt.Errorf("route %q: # must be the filter's last level", r.Filter)
+		case namedLevel.MatchString(level):
+			names[namedLevel.FindStringSubmatch(level)[1]] = true
+		case level != "+" && level != "#" && strings.ContainsAny(level, "+#{}"):
+			return route{}, fmt.Errorf("route %q: wildcards and names must make up whole levels", r.Filter)
+		}
+	}
+	for _, placeholder := range regexp.MustCompile(`\{[^}]*\}`).FindAllString(r.Key, -1) {
+		if name := strings.Trim(placeholder, "{}"); !names[name] {
+			return route{}, fmt.Errorf("route %q: key placeholder %s doesn't name a level of the filter", r.Filter, placeholder)
+		}
+	}
+	return parsed, nil
+}
+
+// match reports whether the route's filter matches the topic, and returns the values of the
+// levels it names.
+func (r route) match(topic string) (map[string]string, bool) {
+	levels := strings.Split(topic, "/")
+	named := make(map[string]string)
+	for i, level := range r.levels {
+		if level == "#" {
+			// Topics starting with $ are reserved, and not matched by leading wildcards
+			return named, i > 0 || !strings.HasPrefix(topic, "$")
+		}
+		if i >= len(levels) {
+			return nil, false
+		}
+		if m := namedLevel.FindStringSubmatch(level); m != nil || level == "+" {
+			if i == 0 && strings.HasPrefix(topic, "$") {
+				return nil, false
+			}
+			if m != nil {
+				named[m[1]] = levels[i]
+			}
+			continue
+		}
+		if level != levels[i] {
+			return nil, false
+		}
+	}
+	return named, len(levels) == len(r.levels)
+}
+
+// Bridge appends the messages MQTT clients publish as records of the log's topics, routing each
+// message to a topic by the first of its routes matching the message's MQTT topic. Its Handle
+// method is the Handler of a Server.
+type Bridge struct {
+	client apiv2.LogClient
+	routes []route
+	logger *slog.Logger
+}
+
+// NewBridge returns a Bridge appending the messages with the client as the routes map them,
+// logging the messages no route matches, which are dropped, to the logger.
+func NewBridge(client apiv2.LogClient, routes []Route, logger *slog.Logger) (*Bridge, error) {
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("bridge: no routes")
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	b := &Bridge{client: client, logger: logger.With(slog.String("component", "mqtt-bridge"))}
+	for _, r := range routes {
+		parsed, err := parseRoute(r)
+		if err != nil {
+			return nil, fmt.Errorf("bridge: %w", err)
+		}
+		b.routes = append(b.routes, parsed)
+	}
+	return b, nil
+}
+
+// Handle appends the message to the topic it's routed to, returning once the log stored it.
+// Messages no route matches are dropped, and don't fail, so clients don't publish them again.
+func (b *Bridge) Handle(ctx context.Context, msg *Message) error {
+	req, ok := b.produceRequest(msg)
+	if !ok {
+		b.logger.Debug("dropped message no route matches",
+			slog.String("topic", msg.Topic),
+			slog.String("client_id", msg.ClientID),
+		)
+		return nil
+	}
+	_, err := b.client.Produce(ctx, req)
+	return err
+}
+
+// produceRequest returns the request appending the message as its route maps it, if any does.
+func (b *Bridge) produceRequest(msg *Message) (*apiv2.ProduceRequest, bool) {
+	for _, r := range b.routes {
+		named, ok := r.match(msg.Topic)
+		if !ok {
+			continue
+		}
+		headers := make(map[string]string, len(r.Headers)+len(named)+6)
+		for name, value := range r.Headers {
+			headers[name] = value
+		}
+		for name, value := range named {
+			headers[name] = value
+		}
+		headers[headerPrefix+"topic"] = msg.Topic
+		headers[headerPrefix+"client_id"] = msg.ClientID
+		headers[headerPrefix+"qos"] = strconv.Itoa(int(msg.QoS))
+		if msg.Username != "" {
+			headers[headerPrefix+"username"] = msg.Username
+		}
+		if msg.Retain {
+			headers[headerPrefix+"retain"] = "true"
+		}
+		if msg.Will {
+			headers[headerPrefix+"will"] = "true"
+		}
+
+		key := msg.ClientID
+		if r.Key != "" {
+			named["client_id"] = msg.ClientID
+			key = r.Key
+			for name, value := range named {
+				key = strings.ReplaceAll(key, "{"+name+"}", value)
+			}
+		}
+		var partition uint32
+		if r.Partitions > 1 {
+			h := fnv.New32a()
+			h.Write([]byte(key))
+			partition = h.Sum32() % r.Partitions
+		}
+		return &apiv2.ProduceRequest{
+			Topic:     r.Topic,
+			Partition: partition,
+			Record: &apiv2.Record{
+				Value:   msg.Payload,
+				Key:     []byte(key),
+				Headers: headers,
+			},
+		}, true
+	}
+	return nil, false
+}
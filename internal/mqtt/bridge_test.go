@@ -0,0 +1,161 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"testing"
+
+	apiv2 "github.com/glauco/proglog/api/v2"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// produceClient records the requests it's asked to produce.
+type produceClient struct {
+	apiv2.LogClient
+	requests []*apiv2.ProduceRequest
+	err      error
+}
+
+func (c *produceClient) Produce(_ context.Context, req *apiv2.ProduceRequest, _ ...grpc.CallOption) (*apiv2.ProduceResponse, error) {
+	c.requests = append(c.requests, req)
+	return &apiv2.ProduceResponse{}, c.err
+}
+
+func TestBridge(t *testing.T) {
+	routes := []Route{{
+		Filter:  "devices/{device}/telemetry/#",
+		Topic:   "telemetry",
+		Key:     "{device}",
+		Headers: map[string]string{"source": "mqtt"},
+	}, {
+		Filter:     "devices/+/status",
+		Topic:      "status",
+		Partitions: 4,
+	}, {
+		Filter: "#",
+	}}
+	partition := func(key string, partitions uint32) uint32 {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return h.Sum32() % partitions
+	}
+
+	for scenario, tc := range map[string]struct {
+		msg  *Message
+		want *apiv2.ProduceRequest
+	}{
+		"named levels set headers and key": {
+			msg: &Message{ClientID: "c1", Username: "alice", Topic: "devices/d1/telemetry/temp", Payload: []byte("20"), QoS: 1},
+			want: &apiv2.ProduceRequest{
+				Topic: "telemetry",
+				Record: &apiv2.Record{
+					Value: []byte("20"),
+					Key:   []byte("d1"),
+					Headers: map[string]string{
+						"source":         "mqtt",
+						"device":         "d1",
+						"mqtt_topic":     "devices/d1/telemetry/temp",
+						"mqtt_client_id": "c1",
+						"mqtt_username":  "alice",
+						"mqtt_qos":       "1",
+					},
+				},
+			},
+		},
+		"# matches the parent level": {
+			msg: &Message{ClientID: "c1", Topic: "devices/d1/telemetry", Payload: []byte("20")},
+			want: &apiv2.ProduceRequest{
+				Topic: "telemetry",
+				Record: &apiv2.Record{
+					Value: []byte("20"),
+					Key:   []byte("d1"),
+					Headers: map[string]string{
+						"source":         "mqtt",
+						"device":         "d1",
+						"mqtt_topic":     "devices/d1/telemetry",
+						"mqtt_client_id": "c1",
+						"mqtt_qos":       "0",
+					},
+				},
+			},
+		},
+		"keys spread records across partitions": {
+			msg: &Message{ClientID: "c2", Topic: "devices/d2/status", Payload: []byte("offline"), QoS: 1, Retain: true, Will: true},
+			want: &apiv2.ProduceRequest{
+				Topic:     "status",
+				Partition: partition("c2", 4),
+				Record: &apiv2.Record{
+					Value: []byte("offline"),
+					Key:   []byte("c2"),
+					Headers: map[string]string{
+						"mqtt_topic":     "devices/d2/status",
+						"mqtt_client_id": "c2",
+						"mqtt_qos":       "1",
+						"mqtt_retain":    "true",
+						"mqtt_will":      "true",
+					},
+				},
+			},
+		},
+		"first matching route wins": {
+			msg: &Message{ClientID: "c3", Topic: "devices/d3/config", Payload: []byte("{}")},
+			want: &apiv2.ProduceRequest{
+				Record: &apiv2.Record{
+					Value: []byte("{}"),
+					Key:   []byte("c3"),
+					Headers: map[string]string{
+						"mqtt_topic":     "devices/d3/config",
+						"mqtt_client_id": "c3",
+						"mqtt_qos":       "0",
+					},
+				},
+			},
+		},
+		"leading wildcards don't match reserved topics": {
+			msg: &Message{ClientID: "c4", Topic: "$SYS/uptime", Payload: []byte("1")},
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			client := &produceClient{}
+			b, err := NewBridge(client, routes, nil)
+			require.NoError(t, err)
+			require.NoError(t, b.Handle(context.Background(), tc.msg))
+			if tc.want == nil {
+				require.Empty(t, client.requests)
+				return
+			}
+			require.Len(t, client.requests, 1)
+			require.Equal(t, tc.want.Topic, client.requests[0].Topic)
+			require.Equal(t, tc.want.Partition, client.requests[0].Partition)
+			require.Equal(t, tc.want.Record.Value, client.requests[0].Record.Value)
+			require.Equal(t, tc.want.Record.Key, client.requests[0].Record.Key)
+			require.Equal(t, tc.want.Record.Headers, client.requests[0].Record.Headers)
+		})
+	}
+}
+
+func TestBridgeProduceFailure(t *testing.T) {
+	client := &produceClient{err: errors.New("unavailable")}
+	b, err := NewBridge(client, []Route{{Filter: "#"}}, nil)
+	require.NoError(t, err)
+	// The server then closes the client's connection, so it publishes the message again
+	require.ErrorIs(t, b.Handle(context.Background(), &Message{Topic: "t"}), client.err)
+}
+
+func TestNewBridgeInvalidRoutes(t *testing.T) {
+	for scenario, route := range map[string]Route{
+		"empty filter":             {Topic: "t"},
+		"# not last":               {Filter: "devices/#/status"},
+		"partial wildcard":         {Filter: "devices/d+/status"},
+		"partial name":             {Filter: "devices/id-{device}"},
+		"key naming no level":      {Filter: "devices/{device}", Key: "{sensor}"},
+		"key naming a plain level": {Filter: "devices/+", Key: "{devices}"},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			_, err := NewBridge(&produceClient{}, []Route{route}, nil)
+			require.Error(t, err)
+		})
+	}
+}
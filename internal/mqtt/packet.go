@@ -0,0 +1,179 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Types of MQTT control packets, in the high nibble of their first byte.
+const (
+	typeConnect     byte = 1
+	typeConnack     byte = 2
+	typePublish     byte = 3
+	typePuback      byte = 4
+	typePubrec      byte = 5
+	typePubrel      byte = 6
+	typePubcomp     byte = 7
+	typeSubscribe   byte = 8
+	typeSuback      byte = 9
+	typeUnsubscribe byte = 10
+	typeUnsuback    byte = 11
+	typePingreq     byte = 12
+	typePingresp    byte = 13
+	typeDisconnect  byte = 14
+)
+
+// Return codes of CONNACK packets.
+const (
+	connAccepted           byte = 0
+	connBadProtocolVersion byte = 1
+	connIdentifierRejected byte = 2
+	connBadCredentials     byte = 4
+)
+
+// subackFailure is the SUBACK return code refusing a subscription.
+const subackFailure byte = 0x80
+
+// maxRemainingLength is the largest remaining length the protocol can encode, in 4 bytes.
+const maxRemainingLength = 268_435_455
+
+// errMalformed is the error of packets breaking the protocol, which close the connection.
+var errMalformed = errors.New("malformed packet")
+
+// packet is a control packet: its type and flags, from its fixed header, and what follows.
+type packet struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+// readPacket reads the next packet, failing on packets larger than maxSize bytes.
+func readPacket(r *bufio.Reader, maxSize int) (packet, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+	// The remaining length is a varint of up to 4 bytes, 7 bits per byte
+	var length, shift int
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, fmt.Errorf("%w: remaining length longer than 4 bytes", errMalformed)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, unexpectedEOF(err)
+		}
+		length |= int(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if length > maxSize {
+		return packet{}, fmt.Errorf("packet of %d bytes exceeds the limit of %d", length, maxSize)
+	}
+	p := packet{typ: first >> 4, flags: first & 0x0f, body: make([]byte, length)}
+	if _, err := io.ReadFull(r, p.body); err != nil {
+		return packet{}, unexpectedEOF(err)
+	}
+	return p, nil
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF, for packets cut short.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// writePacket writes a packet of the type and flags, with the body.
+func writePacket(w io.Writer, typ, flags byte, body []byte) error {
+	if len(body) > maxRemainingLength {
+		return fmt.Errorf("packet of %d bytes exceeds the protocol's limit", len(body))
+	}
+	b := make([]byte, 0, 5+len(body))
+	b = append(b, typ<<4|flags)
+	length := len(body)
+	for {
+		digit := byte(length & 0x7f)
+		length >>= 7
+		if length > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if length == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(b, body...))
+	return err
+}
+
+// packetID encodes a packet identifier, the body of acknowledgements.
+func packetID(id uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, id)
+}
+
+// decoder reads the fields of a packet's body in order. Its methods fail once a field is cut
+// short, after which it returns zero values.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+// byte reads a byte.
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.b) < 1 {
+		d.fail()
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+// uint16 reads a big-endian two byte integer.
+func (d *decoder) uint16() uint16 {
+	if d.err != nil || len(d.b) < 2 {
+		d.fail()
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.b)
+	d.b = d.b[2:]
+	return v
+}
+
+// bytes reads length-prefixed binary data.
+func (d *decoder) bytes() []byte {
+	n := int(d.uint16())
+	if d.err != nil || len(d.b) < n {
+		d.fail()
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+// string reads a length-prefixed string.
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// rest reads what's left of the body.
+func (d *decoder) rest() []byte {
+	v := d.b
+	d.b = nil
+	return v
+}
+
+// fail records the body was cut short.
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: body cut short", errMalformed)
+	}
+}
@@ -0,0 +1,451 @@
+// Package mqtt bridges MQTT clients, e.g. fleets of devices, to the log. Server accepts their
+// connections and the messages they publish, speaking MQTT 3.1.1 and 3.1, and Bridge appends
+// the messages as records of the log's topics, as its routes map them, so devices can feed the
+// log without a gRPC client.
+//
+// The server only ingests messages: it acknowledges QoS 1 and 2 messages once they're handled,
+// so devices publish them again until they're stored, but refuses subscriptions, keeps no
+// session state across connections, and ignores the retain flag but for passing it on.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a message published by a client.
+type Message struct {
+	ClientID string // ClientID identifies the client that published the message.
+	Username string // Username the client connected with, if any.
+	Topic    string
+	Payload  []byte
+	QoS      byte // QoS is the delivery guarantee the client published with: 0, 1 or 2.
+	Retain   bool
+	// Will is set on the will message a client registered when connecting, which the server
+	// publishes once the client disconnects without telling it first, e.g. as it went offline.
+	Will bool
+}
+
+// Handler handles the messages published. QoS 1 and 2 messages are acknowledged once it returns
+// nil; if it fails, the connection is closed, so the client publishes them again once
+// reconnected. It's called by the goroutine serving the client's connection, so each client's
+// messages are handled in order.
+type Handler func(ctx context.Context, msg *Message) error
+
+// Authenticator authenticates a client connecting with the client ID, username and password, if
+// any, and the state of its TLS connection, or nil if it isn't over TLS. Clients it fails are
+// refused.
+type Authenticator func(clientID, username string, password []byte, state *tls.ConnectionState) error
+
+// DefaultMaxPacketSize is how large the packets of clients may be by default.
+const DefaultMaxPacketSize = 1 << 20
+
+// connectTimeout is how long clients have to send their CONNECT packet once connected.
+const connectTimeout = 10 * time.Second
+
+// Server serves MQTT clients on listeners, passing the messages they publish to its Handler.
+type Server struct {
+	Handler      Handler
+	Authenticate Authenticator // Authenticate authenticates clients; all are accepted when nil.
+	// MaxPacketSize is how large clients' packets, and so their messages, may be;
+	// DefaultMaxPacketSize when 0. Clients sending larger ones are disconnected.
+	MaxPacketSize int
+	Logger        *slog.Logger // Logger receives the clients' errors; defaults to slog.Default().
+
+	mu        sync.Mutex
+	ctx       context.Context // Cancelled once closed, to stop the handlers
+	cancel    context.CancelFunc
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	clients   map[string]*client // Connected clients, by client ID
+	closed    bool
+	wg        sync.WaitGroup // Tracks the goroutines serving connections
+}
+
+// ErrServerClosed is returned by Serve once the server is closed.
+var ErrServerClosed = errors.New("mqtt: server closed")
+
+// init initializes the server's state on first use. The caller must hold the lock.
+func (s *Server) init() {
+	if s.ctx != nil {
+		return
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.listeners = make(map[net.Listener]struct{})
+	s.conns = make(map[net.Conn]struct{})
+	s.clients = make(map[string]*client)
+	if s.Logger == nil {
+		s.Logger = slog.Default()
+	}
+	s.Logger = s.Logger.With(slog.String("component", "mqtt"))
+	if s.MaxPacketSize == 0 {
+		s.MaxPacketSize = DefaultMaxPacketSize
+	}
+}
+
+// Serve accepts clients on the listener, e.g. one returned by tls.Listen, and serves each in a
+// goroutine of its own, until the listener fails or the server is closed, when it returns
+// ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.init()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				// Temporary failures, e.g. running out of file descriptors, pass
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops accepting clients, disconnects the connected ones, and waits for the handlers
+// of their messages to return, cancelling their context.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.init()
+	s.closed = true
+	s.cancel()
+	var errs []error
+	for l := range s.listeners {
+		errs = append(errs, l.Close())
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return errors.Join(errs...)
+}
+
+// client is a connected client.
+type client struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	id       string
+	username string
+	// keepAlive is how often the client promised to send packets; it's disconnected once
+	// silent for one and a half times as long. 0 disables the timeout.
+	keepAlive time.Duration
+	will      *Message // Will message, if the client registered one
+	// received are the identifiers of the QoS 2 messages handled but not released yet, so they
+	// aren't handled again when published again
+	received map[uint16]bool
+}
+
+// serveConn serves the client on the connection until it disconnects.
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	logger := s.Logger.With(slog.String("remote_addr", conn.RemoteAddr().String()))
+	c, err := s.connect(conn)
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			logger.Warn("failed to connect client", slog.String("error", err.Error()))
+		}
+		return
+	}
+	logger = logger.With(slog.String("client_id", c.id))
+	if !s.register(c) {
+		return
+	}
+
+	err = s.serveClient(c)
+	// Clients disconnected as they connected again, or as the server closes, don't publish
+	// their wills, nor do those disconnecting cleanly
+	current := s.unregister(c)
+	if err == nil || !current {
+		return
+	}
+	if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		logger.Warn("disconnected client", slog.String("error", err.Error()))
+	}
+	if c.will != nil {
+		if err := s.Handler(s.ctx, c.will); err != nil {
+			logger.Error("failed to handle will message", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// connect reads the client's CONNECT packet, authenticates the client, and answers with a
+// CONNACK packet. It fails if the client isn't accepted.
+func (s *Server) connect(conn net.Conn) (*client, error) {
+	conn.SetReadDeadline(time.Now().Add(connectTimeout))
+	r := bufio.NewReader(conn)
+	p, err := readPacket(r, s.MaxPacketSize)
+	if err != nil {
+		return nil, err
+	}
+	if p.typ != typeConnect {
+		return nil, fmt.Errorf("%w: expected CONNECT, got packet type %d", errMalformed, p.typ)
+	}
+	d := decoder{b: p.body}
+	protocol := d.string()
+	level := d.byte()
+	flags := d.byte()
+	keepAlive := d.uint16()
+	c := &client{
+		conn:      conn,
+		r:         r,
+		id:        d.string(),
+		keepAlive: time.Duration(keepAlive) * time.Second,
+		received:  make(map[uint16]bool),
+	}
+	if flags&0x04 != 0 {
+		c.will = &Message{
+			Topic:   d.string(),
+			Payload: d.bytes(),
+			QoS:     flags >> 3 & 0x03,
+			Retain:  flags&0x20 != 0,
+			Will:    true,
+		}
+	}
+	if flags&0x80 != 0 {
+		c.username = d.string()
+	}
+	var password []byte
+	if flags&0x40 != 0 {
+		password = d.bytes()
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	// MQTT 3.1.1 is level 4 of protocol MQTT, 3.1 level 3 of MQIsdp; later levels are refused
+	if protocol != "MQTT" && protocol != "MQIsdp" {
+		return nil, fmt.Errorf("%w: unknown protocol %q", errMalformed, protocol)
+	}
+	if flags&0x01 != 0 || (c.will != nil && (c.will.QoS > 2 || !validTopic(c.will.Topic))) {
+		return nil, fmt.Errorf("%w: invalid connect flags or will", errMalformed)
+	}
+	refuse := func(code byte, err error) (*client, error) {
+		writePacket(conn, typeConnack, 0, []byte{0, code})
+		return nil, err
+	}
+	if level != 3 && level != 4 {
+		return refuse(connBadProtocolVersion, fmt.Errorf("unsupported protocol level %d", level))
+	}
+	if c.id == "" {
+		// Clients without IDs get one, which can't resume a session
+		if flags&0x02 == 0 {
+			return refuse(connIdentifierRejected, errors.New("empty client ID without a clean session"))
+		}
+		c.id = "auto-" + randomID()
+	}
+	if s.Authenticate != nil {
+		var state *tls.ConnectionState
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			// The handshake happens on first read, which the CONNECT packet did
+			cs := tlsConn.ConnectionState()
+			state = &cs
+		}
+		if err := s.Authenticate(c.id, c.username, password, state); err != nil {
+			return refuse(connBadCredentials, fmt.Errorf("authenticate %q: %w", c.username, err))
+		}
+	}
+	if c.will != nil {
+		c.will.ClientID, c.will.Username = c.id, c.username
+	}
+	// Sessions aren't kept across connections, so there's never one present
+	if err := writePacket(conn, typeConnack, 0, []byte{0, connAccepted}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// register registers the connected client, disconnecting the client previously connected
+// with the same ID, as the protocol requires. It fails once the server is closed.
+func (s *Server) register(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if previous, ok := s.clients[c.id]; ok {
+		previous.conn.Close()
+	}
+	s.clients[c.id] = c
+	return true
+}
+
+// unregister unregisters the client once disconnected, unless replaced already. It reports
+// whether the client was still the one connected with its ID while the server was open.
+func (s *Server) unregister(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[c.id] != c {
+		return false
+	}
+	delete(s.clients, c.id)
+	return !s.closed
+}
+
+// serveClient serves the client's packets until it disconnects, returning nil if it did by
+// sending a DISCONNECT packet.
+func (s *Server) serveClient(c *client) error {
+	for {
+		deadline := time.Time{}
+		if c.keepAlive > 0 {
+			deadline = time.Now().Add(c.keepAlive * 3 / 2)
+		}
+		c.conn.SetReadDeadline(deadline)
+		p, err := readPacket(c.r, s.MaxPacketSize)
+		if err != nil {
+			return err
+		}
+		switch p.typ {
+		case typePublish:
+			err = s.publish(c, p)
+		case typePubrel:
+			// The client released a QoS 2 message, which won't be published again
+			d := decoder{b: p.body}
+			id := d.uint16()
+			if d.err != nil || p.flags != 0x02 {
+				return fmt.Errorf("%w: PUBREL", errMalformed)
+			}
+			delete(c.received, id)
+			err = writePacket(c.conn, typePubcomp, 0, packetID(id))
+		case typeSubscribe:
+			err = s.refuseSubscribe(c, p)
+		case typeUnsubscribe:
+			d := decoder{b: p.body}
+			id := d.uint16()
+			if d.err != nil || p.flags != 0x02 {
+				return fmt.Errorf("%w: UNSUBSCRIBE", errMalformed)
+			}
+			err = writePacket(c.conn, typeUnsuback, 0, packetID(id))
+		case typePingreq:
+			err = writePacket(c.conn, typePingresp, 0, nil)
+		case typeDisconnect:
+			return nil
+		default:
+			// The server publishes nothing, so clients have nothing to acknowledge
+			return fmt.Errorf("%w: unexpected packet type %d", errMalformed, p.typ)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// publish handles the message the client published, then acknowledges it as its QoS requires.
+func (s *Server) publish(c *client, p packet) error {
+	msg := &Message{
+		ClientID: c.id,
+		Username: c.username,
+		QoS:      p.flags >> 1 & 0x03,
+		Retain:   p.flags&0x01 != 0,
+	}
+	d := decoder{b: p.body}
+	msg.Topic = d.string()
+	var id uint16
+	if msg.QoS > 0 {
+		id = d.uint16()
+	}
+	msg.Payload = d.rest()
+	if d.err != nil || msg.QoS > 2 || !validTopic(msg.Topic) {
+		return fmt.Errorf("%w: PUBLISH", errMalformed)
+	}
+	if msg.QoS == 2 && c.received[id] {
+		// Published again as the PUBREC was lost, but handled already
+		return writePacket(c.conn, typePubrec, 0, packetID(id))
+	}
+	if err := s.Handler(s.ctx, msg); err != nil {
+		if msg.QoS == 0 {
+			// The client won't publish it again, so it's lost
+			s.Logger.Error("failed to handle message",
+				slog.String("client_id", c.id),
+				slog.String("topic", msg.Topic),
+				slog.String("error", err.Error()),
+			)
+			return nil
+		}
+		return fmt.Errorf("handle message on %q: %w", msg.Topic, err)
+	}
+	switch msg.QoS {
+	case 1:
+		return writePacket(c.conn, typePuback, 0, packetID(id))
+	case 2:
+		c.received[id] = true
+		return writePacket(c.conn, typePubrec, 0, packetID(id))
+	}
+	return nil
+}
+
+// refuseSubscribe refuses every subscription of the SUBSCRIBE packet, as the server doesn't
+// deliver messages.
+func (s *Server) refuseSubscribe(c *client, p packet) error {
+	d := decoder{b: p.body}
+	id := d.uint16()
+	body := packetID(id)
+	for len(d.b) > 0 && d.err == nil {
+		d.string()
+		d.byte()
+		body = append(body, subackFailure)
+	}
+	if d.err != nil || p.flags != 0x02 || len(body) == 2 {
+		return fmt.Errorf("%w: SUBSCRIBE", errMalformed)
+	}
+	return writePacket(c.conn, typeSuback, 0, body)
+}
+
+// validTopic reports whether the topic name can be published to: it's not empty, and holds
+// neither wildcards nor null characters.
+func validTopic(topic string) bool {
+	return topic != "" && !strings.ContainsAny(topic, "+#\x00")
+}
+
+// randomID returns a random identifier for clients connecting without one.
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
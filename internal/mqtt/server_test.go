@@ -0,0 +1,307 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testClient is a raw MQTT client, writing packets as the tests build them.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// encodeString encodes a length-prefixed string.
+func encodeString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+// connectBody returns the body of a CONNECT packet of MQTT 3.1.1 with a clean session.
+func connectBody(clientID string, flags byte, payload ...[]byte) []byte {
+	body := append(encodeString("MQTT"), 4, flags|0x02, 0, 60)
+	body = append(body, encodeString(clientID)...)
+	for _, p := range payload {
+		body = append(body, p...)
+	}
+	return body
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *testClient) write(typ, flags byte, body []byte) {
+	c.t.Helper()
+	require.NoError(c.t, writePacket(c.conn, typ, flags, body))
+}
+
+// expect reads the next packet, requiring its type and body.
+func (c *testClient) expect(typ byte, body []byte) {
+	c.t.Helper()
+	p, err := readPacket(c.r, DefaultMaxPacketSize)
+	require.NoError(c.t, err)
+	require.Equal(c.t, typ, p.typ)
+	require.Equal(c.t, string(body), string(p.body))
+}
+
+// connect connects as the client ID, requiring the server to accept it.
+func (c *testClient) connect(clientID string) {
+	c.t.Helper()
+	c.write(typeConnect, 0, connectBody(clientID, 0))
+	c.expect(typeConnack, []byte{0, connAccepted})
+}
+
+// publish publishes the payload on the topic, with the QoS and packet identifier.
+func (c *testClient) publish(topic string, qos byte, id uint16, payload string) {
+	c.t.Helper()
+	body := encodeString(topic)
+	if qos > 0 {
+		body = append(body, packetID(id)...)
+	}
+	c.write(typePublish, qos<<1, append(body, payload...))
+}
+
+// testHandler records the messages handled, failing those on topic "fail".
+type testHandler struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+func (h *testHandler) handle(_ context.Context, msg *Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if msg.Topic == "fail" {
+		return errors.New("failed")
+	}
+	h.messages = append(h.messages, msg)
+	return nil
+}
+
+func (h *testHandler) handled() []*Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*Message(nil), h.messages...)
+}
+
+func setupServer(t *testing.T, fn func(*Server)) (addr string, h *testHandler) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	h = &testHandler{}
+	srv := &Server{
+		Handler: h.handle,
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	if fn != nil {
+		fn(srv)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+	t.Cleanup(func() {
+		require.NoError(t, srv.Close())
+		require.ErrorIs(t, <-served, ErrServerClosed)
+	})
+	return l.Addr().String(), h
+}
+
+func TestServer(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T){
+		"publishes at every qos":               testPublish,
+		"handles qos 2 messages once":          testPublishQoS2Again,
+		"fails qos 1 messages not handled":     testPublishFailure,
+		"publishes wills of lost clients":      testWill,
+		"refuses subscriptions":                testSubscribe,
+		"refuses unsupported protocols":        testProtocolLevel,
+		"refuses clients not authenticated":    testAuthenticate,
+		"disconnects clients connecting again": testTakeOver,
+		"disconnects oversized packets":        testMaxPacketSize,
+	} {
+		t.Run(scenario, fn)
+	}
+}
+
+func testPublish(t *testing.T) {
+	addr, h := setupServer(t, nil)
+	c := dial(t, addr)
+	c.connect("device-1")
+
+	c.publish("devices/1/temp", 0, 0, "20")
+	c.publish("devices/1/temp", 1, 1, "21")
+	c.expect(typePuback, packetID(1))
+	c.publish("devices/1/temp", 2, 2, "22")
+	c.expect(typePubrec, packetID(2))
+	c.write(typePubrel, 0x02, packetID(2))
+	c.expect(typePubcomp, packetID(2))
+	c.write(typePingreq, 0, nil)
+	c.expect(typePingresp, nil)
+
+	messages := h.handled()
+	require.Len(t, messages, 3)
+	for i, msg := range messages {
+		require.Equal(t, &Message{
+			ClientID: "device-1",
+			Topic:    "devices/1/temp",
+			Payload:  []byte{'2', '0' + byte(i)},
+			QoS:      byte(i),
+		}, msg)
+	}
+}
+
+func testPublishQoS2Again(t *testing.T) {
+	addr, h := setupServer(t, nil)
+	c := dial(t, addr)
+	c.connect("device-1")
+
+	// The client publishes again as if the PUBREC was lost
+	c.publish("devices/1/temp", 2, 7, "22")
+	c.expect(typePubrec, packetID(7))
+	c.write(typePublish, 2<<1|0x08, append(append(encodeString("devices/1/temp"), packetID(7)...), "22"...))
+	c.expect(typePubrec, packetID(7))
+	c.write(typePubrel, 0x02, packetID(7))
+	c.expect(typePubcomp, packetID(7))
+	require.Len(t, h.handled(), 1)
+
+	// Once released, the identifier is reused for a new message
+	c.publish("devices/1/temp", 2, 7, "23")
+	c.expect(typePubrec, packetID(7))
+	require.Len(t, h.handled(), 2)
+}
+
+func testPublishFailure(t *testing.T) {
+	addr, _ := setupServer(t, nil)
+	c := dial(t, addr)
+	c.connect("device-1")
+
+	// QoS 0 messages are lost, but the client stays connected
+	c.publish("fail", 0, 0, "x")
+	c.write(typePingreq, 0, nil)
+	c.expect(typePingresp, nil)
+
+	// QoS 1 messages aren't acknowledged, so the client publishes them again once reconnected
+	c.publish("fail", 1, 1, "x")
+	_, err := readPacket(c.r, DefaultMaxPacketSize)
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func testWill(t *testing.T) {
+	addr, h := setupServer(t, nil)
+	will := append(encodeString("devices/1/status"), encodeString("offline")...)
+
+	// Disconnecting cleanly discards the will
+	c := dial(t, addr)
+	c.write(typeConnect, 0, connectBody("device-1", 0x04|1<<3, will))
+	c.expect(typeConnack, []byte{0, connAccepted})
+	c.write(typeDisconnect, 0, nil)
+	_, err := readPacket(c.r, DefaultMaxPacketSize)
+	require.ErrorIs(t, err, io.EOF)
+	require.Empty(t, h.handled())
+
+	// Losing the connection publishes it
+	c = dial(t, addr)
+	c.write(typeConnect, 0, connectBody("device-1", 0x04|1<<3|0x80, will, encodeString("alice")))
+	c.expect(typeConnack, []byte{0, connAccepted})
+	c.conn.Close()
+	require.Eventually(t, func() bool { return len(h.handled()) == 1 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, &Message{
+		ClientID: "device-1",
+		Username: "alice",
+		Topic:    "devices/1/status",
+		Payload:  []byte("offline"),
+		QoS:      1,
+		Will:     true,
+	}, h.handled()[0])
+}
+
+func testSubscribe(t *testing.T) {
+	addr, _ := setupServer(t, nil)
+	c := dial(t, addr)
+	c.connect("device-1")
+
+	body := append(packetID(3), encodeString("commands/#")...)
+	body = append(body, 1)
+	body = append(body, encodeString("config")...)
+	body = append(body, 0)
+	c.write(typeSubscribe, 0x02, body)
+	c.expect(typeSuback, []byte{0, 3, subackFailure, subackFailure})
+	c.write(typeUnsubscribe, 0x02, append(packetID(4), encodeString("config")...))
+	c.expect(typeUnsuback, packetID(4))
+}
+
+func testProtocolLevel(t *testing.T) {
+	addr, _ := setupServer(t, nil)
+	c := dial(t, addr)
+	body := connectBody("device-1", 0)
+	body[6] = 5 // MQTT 5
+	c.write(typeConnect, 0, body)
+	c.expect(typeConnack, []byte{0, connBadProtocolVersion})
+	_, err := readPacket(c.r, DefaultMaxPacketSize)
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func testAuthenticate(t *testing.T) {
+	addr, _ := setupServer(t, func(srv *Server) {
+		srv.Authenticate = func(clientID, username string, password []byte, state *tls.ConnectionState) error {
+			if username != "alice" || string(password) != "secret" || state != nil {
+				return errors.New("bad credentials")
+			}
+			return nil
+		}
+	})
+	credentials := func(password string) []byte {
+		return append(encodeString("alice"), encodeString(password)...)
+	}
+
+	c := dial(t, addr)
+	c.write(typeConnect, 0, connectBody("device-1", 0xc0, credentials("wrong")))
+	c.expect(typeConnack, []byte{0, connBadCredentials})
+
+	c = dial(t, addr)
+	c.write(typeConnect, 0, connectBody("device-1", 0xc0, credentials("secret")))
+	c.expect(typeConnack, []byte{0, connAccepted})
+}
+
+func testTakeOver(t *testing.T) {
+	addr, h := setupServer(t, nil)
+	will := append(encodeString("devices/1/status"), encodeString("offline")...)
+	first := dial(t, addr)
+	first.write(typeConnect, 0, connectBody("device-1", 0x04, will))
+	first.expect(typeConnack, []byte{0, connAccepted})
+
+	second := dial(t, addr)
+	second.connect("device-1")
+	_, err := readPacket(first.r, DefaultMaxPacketSize)
+	require.ErrorIs(t, err, io.EOF)
+
+	// The client connected again, so it isn't offline
+	second.publish("devices/1/temp", 1, 1, "20")
+	second.expect(typePuback, packetID(1))
+	require.Len(t, h.handled(), 1)
+	require.False(t, h.handled()[0].Will)
+}
+
+func testMaxPacketSize(t *testing.T) {
+	addr, h := setupServer(t, func(srv *Server) { srv.MaxPacketSize = 64 })
+	c := dial(t, addr)
+	c.connect("device-1")
+	c.publish("devices/1/temp", 0, 0, string(make([]byte, 64)))
+	_, err := readPacket(c.r, DefaultMaxPacketSize)
+	require.ErrorIs(t, err, io.EOF)
+	require.Empty(t, h.handled())
+}
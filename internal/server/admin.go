@@ -0,0 +1,428 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ClusterAdmin manages the servers of the cluster, e.g. a log replicated with Raft.
+type ClusterAdmin interface {
+	Promote(id string) error // Promote makes a non-voting server a voter.
+	// DescribeCluster returns the replica of every server with its lag behind the leader's.
+	DescribeCluster(ctx context.Context) ([]*api.ReplicaStatus, error)
+	// TransferLeadership hands the leadership over to the voter with the id, or to any voter if it's empty.
+	TransferLeadership(id string) error
+	Leadership() (*api.Leadership, error) // Leadership returns the cluster's leadership as the server sees it.
+	// CreateTopic creates a topic whose partitions are assigned to replicationFactor servers each.
+	CreateTopic(name string, partitions, replicationFactor uint32) (*api.Topic, error)
+	Topics() []*api.Topic // Topics returns the topics created in the cluster.
+	// Rebalance moves replicas of the partitions across the servers and returns the moves.
+	Rebalance() ([]*api.PartitionMove, error)
+	PauseRebalance(paused bool) error // PauseRebalance pauses or resumes rebalancing.
+	RebalancePaused() bool            // RebalancePaused reports whether rebalancing is paused.
+	// Backup streams a consistent backup of the cluster's topics and records to send.
+	Backup(ctx context.Context, send func(*api.BackupChunk) error) error
+	// PrepareBackup waits for the server to pause its writes at the cluster's Raft log entry at
+	// pauseIndex, then returns the ranges of committed records of the partitions it leads.
+	PrepareBackup(ctx context.Context, pauseIndex uint64) ([]*api.BackupRange, error)
+	// ReadBackup streams the records of the range, held by the server, to send.
+	ReadBackup(ctx context.Context, r *api.BackupRange, send func(*api.BackupChunk) error) error
+	// ClusterConfig returns the cluster's dynamic configuration, as the server last applied it.
+	ClusterConfig() *api.ClusterConfig
+	// SetConfig replaces the cluster's dynamic configuration on every server and returns it, versioned.
+	SetConfig(config *api.ClusterConfig) (*api.ClusterConfig, error)
+	// UpdateACLRules removes rules from the ACL rules of the cluster's configuration, then adds
+	// rules to them, on every server, and returns the configuration updated.
+	UpdateACLRules(add, remove []*api.AclRule) (*api.ClusterConfig, error)
+}
+
+// adminServer implements the Admin service on top of the server's ClusterAdmin.
+type adminServer struct {
+	api.UnimplementedAdminServer
+	*Config
+	consumers *consumerTracker // Positions of the consumer groups, listed by ListConsumers
+}
+
+// PromoteServer makes a non-voting server of the cluster a voter.
+func (s *adminServer) PromoteServer(ctx context.Context, req *api.PromoteServerRequest) (*api.PromoteServerResponse, error) {
+	if err := s.authorize(
+		ctx,
+		objectCluster,
+		adminAction,
+	); err != nil {
+		return nil, err
+	}
+	if s.ClusterAdmin == nil {
+		return nil, status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	if req.Id == "" {
+		return nil, api.NewError(codes.InvalidArgument, api.ReasonInvalidRequest, "id is required", nil)
+	}
+	if err := s.ClusterAdmin.Promote(req.Id); err != nil {
+		return nil, err
+	}
+	return &api.PromoteServerResponse{}, nil
+}
+
+// DescribeCluster returns the cluster's replicas with their lag behind the leader's.
+func (s *adminServer) DescribeCluster(ctx context.Context, req *api.DescribeClusterRequest) (*api.DescribeClusterResponse, error) {
+	if err := s.authorize(
+		ctx,
+		objectCluster,
+		describeAction,
+	); err != nil {
+		return nil, err
+	}
+	if s.ClusterAdmin == nil {
+		return nil, status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	replicas, err := s.ClusterAdmin.DescribeCluster(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &api.DescribeClusterResponse{Replicas: replicas}, nil
+}
+
+// DescribeReplica returns the state of the server's log: where it ends and when its most
+// recent record was appended.
+func (s *adminServer) DescribeReplica(ctx context.Context, req *api.DescribeReplicaRequest) (*api.DescribeReplicaResponse, error) {
+	if err := s.authorize(
+		ctx,
+		objectCluster,
+		describeAction,
+	); err != nil {
+		return nil, err
+	}
+	next, err := highWatermark(s.CommitLog)
+	if err != nil {
+		return nil, err
+	}
+	replica := &api.ReplicaStatus{NextOffset: next}
+	if next > 0 {
+		// The record may have been truncated meanwhile, leaving the append time unknown
+		record, err := s.CommitLog.Read(next - 1)
+		if err == nil {
+			replica.LastAppendTime = record.AppendTime
+		} else if !errors.As(err, new(api.ErrOffsetOutOfRange)) {
+			return nil, err
+		}
+	}
+	return &api.DescribeReplicaResponse{Replica: replica}, nil
+}
+
+// TransferLeadership makes the leader hand the leadership over to another voter.
+func (s *adminServer) TransferLeadership(ctx context.Context, req *api.TransferLeadershipRequest) (*api.TransferLeadershipResponse, error) {
+	if err := s.authorize(
+		ctx,
+		objectCluster,
+		adminAction,
+	); err != nil {
+		return nil, err
+	}
+	if s.ClusterAdmin == nil {
+		return nil, status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	if err := s.ClusterAdmin.TransferLeadership(req.Id); err != nil {
+		return nil, err
+	}
+	return &api.TransferLeadershipResponse{}, nil
+}
+
+// GetLeadership returns the cluster's leadership as the server sees it.
+func (s *adminServer) GetLeadership(ctx context.Context, req *api.GetLeadershipRequest) (*api.GetLeadershipResponse, error) {
+	if err := s.authorize(
+		ctx,
+		objectCluster,
+		describeAction,
+	); err != nil {
+		return nil, err
+	}
+	if s.ClusterAdmin == nil {
+		return nil, status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	leadership, err := s.ClusterAdmin.Leadership()
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetLeadershipResponse{Leadership: leadership}, nil
+}
+
+// CreateTopic creates a topic, assigning its partitions to the servers replicating them. Subjects
+// may create it if they administer the cluster, or the topic itself, so tenants can be allowed to
+// create the topics matching a pattern, e.g. p, alice, alice-*, admin.
+func (s *adminServer) CreateTopic(ctx context.Context, req *api.CreateTopicRequest) (*api.CreateTopicResponse, error) {
+	if err := s.authorize(
+		ctx,
+		objectCluster,
+		adminAction,
+	); err != nil {
+		if err := s.authorize(ctx, req.Name, adminAction); err != nil {
+			return nil, err
+		}
+	}
+	if s.ClusterAdmin == nil {
+		return nil, status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	topic, err := s.ClusterAdmin.CreateTopic(req.Name, req.Partitions, req.ReplicationFactor)
+	if err != nil {
+		return nil, err
+	}
+	return &api.CreateTopicResponse{Topic: topic}, nil
+}
+
+// ListTopics returns the topics created in the cluster and where their partitions are replicated.
+func (s *adminServer) ListTopics(ctx context.Context, req *api.ListTopicsRequest) (*api.ListTopicsResponse, error) {
+	if err := s.authorize(
+		ctx,
+		objectCluster,
+		describeAction,
+	); err != nil {
+		return nil, err
+	}
+	if s.ClusterAdmin == nil {
+		return nil, status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	return &api.ListTopicsResponse{
+		Topics:          s.ClusterAdmin.Topics(),
+		RebalancePaused: s.ClusterAdmin.RebalancePaused(),
+	}, nil
+}
+
+// TriggerRebalance rebalances the partitions across the servers right away.
+func (s *adminServer) TriggerRebalance(ctx context.Context, req *api.TriggerRebalanceRequest) (*api.TriggerRebalanceResponse, error) {
+	if err := s.authorize(
+		ctx,
+		objectCluster,
+		adminAction,
+	); err != nil {
+		return nil, err
+	}
+	if s.ClusterAdmin == nil {
+		return nil, status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	moves, err := s.ClusterAdmin.Rebalance()
+	if err != nil {
+		return nil, err
+	}
+	return &api.TriggerRebalanceResponse{Moves: moves}, nil
+}
+
+// PauseRebalance pauses or resumes rebalancing the partitions across the servers.
+func (s *adminServer) PauseRebalance(ctx context.Context, req *api.PauseRebalanceRequest) (*api.PauseRebalanceResponse, error) {
+	if err := s.authorize(
+		ctx,
+		objectCluster,
+		adminAction,
+	); err != nil {
+		return nil, err
+	}
+	if s.ClusterAdmin == nil {
+		return nil, status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	if err := s.ClusterAdmin.PauseRebalance(req.Paused); err != nil {
+		return nil, err
+	}
+	return &api.PauseRebalanceResponse{}, nil
+}
+
+// Backup streams a consistent backup of the cluster's topics and records.
+func (s *adminServer) Backup(req *api.BackupRequest, stream api.Admin_BackupServer) error {
+	if err := s.authorize(
+		stream.Context(),
+		objectCluster,
+		adminAction,
+	); err != nil {
+		return err
+	}
+	if s.ClusterAdmin == nil {
+		return status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	return s.ClusterAdmin.Backup(stream.Context(), stream.Send)
+}
+
+// PrepareBackup returns the ranges of the partitions the server leads, once its writes are paused.
+func (s *adminServer) PrepareBackup(ctx context.Context, req *api.PrepareBackupRequest) (*api.PrepareBackupResponse, error) {
+	if err := s.authorize(
+		ctx,
+		objectCluster,
+		adminAction,
+	); err != nil {
+		return nil, err
+	}
+	if s.ClusterAdmin == nil {
+		return nil, status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	ranges, err := s.ClusterAdmin.PrepareBackup(ctx, req.PauseIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &api.PrepareBackupResponse{Ranges: ranges}, nil
+}
+
+// ReadBackup streams the records of a range the server took for a backup.
+func (s *adminServer) ReadBackup(req *api.BackupRange, stream api.Admin_ReadBackupServer) error {
+	if err := s.authorize(
+		stream.Context(),
+		objectCluster,
+		adminAction,
+	); err != nil {
+		return err
+	}
+	if s.ClusterAdmin == nil {
+		return status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	if req.NextOffset < req.LowestOffset {
+		return api.NewError(codes.InvalidArgument, api.ReasonInvalidRequest,
+			"the range's next offset must not be lower than its lowest", nil)
+	}
+	return s.ClusterAdmin.ReadBackup(stream.Context(), req, stream.Send)
+}
+
+// GetConfig returns the cluster's dynamic configuration, as the server last applied it.
+func (s *adminServer) GetConfig(ctx context.Context, req *api.GetConfigRequest) (*api.GetConfigResponse, error) {
+	if err := s.authorize(
+		ctx,
+		objectCluster,
+		describeAction,
+	); err != nil {
+		return nil, err
+	}
+	if s.ClusterAdmin == nil {
+		return nil, status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	return &api.GetConfigResponse{Config: s.ClusterAdmin.ClusterConfig()}, nil
+}
+
+// SetConfig replaces the cluster's dynamic configuration, which every server converges on.
+func (s *adminServer) SetConfig(ctx context.Context, req *api.SetConfigRequest) (*api.SetConfigResponse, error) {
+	if err := s.authorize(
+		ctx,
+		objectCluster,
+		adminAction,
+	); err != nil {
+		return nil, err
+	}
+	if s.ClusterAdmin == nil {
+		return nil, status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	if req.Config == nil {
+		return nil, api.NewError(codes.InvalidArgument, api.ReasonInvalidRequest, "config is required", nil)
+	}
+	config, err := s.ClusterAdmin.SetConfig(req.Config)
+	if err != nil {
+		return nil, err
+	}
+	return &api.SetConfigResponse{Config: config}, nil
+}
+
+// AddAclRules adds rules to the ACL rules of the cluster's configuration, which every server
+// enforces on top of its policy file's.
+func (s *adminServer) AddAclRules(ctx context.Context, req *api.AddAclRulesRequest) (*api.AddAclRulesResponse, error) {
+	rules, err := s.updateACLRules(ctx, req.Rules, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &api.AddAclRulesResponse{Rules: rules}, nil
+}
+
+// RemoveAclRules removes rules from the ACL rules of the cluster's configuration.
+func (s *adminServer) RemoveAclRules(ctx context.Context, req *api.RemoveAclRulesRequest) (*api.RemoveAclRulesResponse, error) {
+	rules, err := s.updateACLRules(ctx, nil, req.Rules)
+	if err != nil {
+		return nil, err
+	}
+	return &api.RemoveAclRulesResponse{Rules: rules}, nil
+}
+
+// ListAclRules returns the ACL rules of the cluster's configuration, as the server last applied them.
+func (s *adminServer) ListAclRules(ctx context.Context, req *api.ListAclRulesRequest) (*api.ListAclRulesResponse, error) {
+	if err := s.authorizeACLAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if s.ClusterAdmin == nil {
+		return nil, status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	config := s.ClusterAdmin.ClusterConfig()
+	return &api.ListAclRulesResponse{Rules: &api.AclRules{Rules: config.AclRules, Version: config.Version}}, nil
+}
+
+// updateACLRules removes and adds the rules, and returns the rules of the configuration updated.
+func (s *adminServer) updateACLRules(ctx context.Context, add, remove []*api.AclRule) (*api.AclRules, error) {
+	if err := s.authorizeACLAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if s.ClusterAdmin == nil {
+		return nil, status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	if len(add) == 0 && len(remove) == 0 {
+		return nil, api.NewError(codes.InvalidArgument, api.ReasonInvalidRequest, "rules are required", nil)
+	}
+	config, err := s.ClusterAdmin.UpdateACLRules(add, remove)
+	if err != nil {
+		return nil, err
+	}
+	return &api.AclRules{Rules: config.AclRules, Version: config.Version}, nil
+}
+
+// authorizeACLAdmin authorizes managing the ACL rules. Subjects may manage them if they
+// administer the cluster, which may replace its whole configuration anyway, or hold the distinct
+// acl-admin action on it, so onboarding clients can be delegated without granting the rest of
+// the cluster's administration, e.g. p, onboarding, cluster, acl-admin.
+func (s *adminServer) authorizeACLAdmin(ctx context.Context) error {
+	if err := s.authorize(
+		ctx,
+		objectCluster,
+		adminAction,
+	); err != nil {
+		return s.authorize(ctx, objectCluster, aclAdminAction)
+	}
+	return nil
+}
+
+// CreateToken mints a scoped token authenticating as the caller, or as another subject for the
+// cluster's administrators. Subjects may mint tokens for themselves if they administer the
+// cluster or hold the distinct token action on it, e.g. p, support, cluster, token. Tokens only
+// narrow what their subjects may do, but scoped tokens can't mint tokens, which could outlive them.
+func (s *adminServer) CreateToken(ctx context.Context, req *api.CreateTokenRequest) (*api.CreateTokenResponse, error) {
+	sub := req.Subject
+	if sub == "" || sub == subject(ctx) {
+		sub = subject(ctx)
+		if err := s.authorize(ctx, objectCluster, adminAction); err != nil {
+			if err := s.authorize(ctx, objectCluster, tokenAction); err != nil {
+				return nil, err
+			}
+		}
+	} else if err := s.authorize(ctx, objectCluster, adminAction); err != nil {
+		return nil, err
+	}
+	if s.TokenIssuer == nil {
+		return nil, status.Error(codes.Unimplemented, "the server doesn't mint tokens")
+	}
+	if grant := tokenFromContext(ctx); grant != nil {
+		return nil, api.NewError(codes.PermissionDenied, api.ReasonUnauthorized, "scoped tokens can't mint tokens", map[string]string{
+			"subject": subject(ctx),
+			"token":   grant.id,
+		})
+	}
+	if len(req.Scopes) == 0 {
+		return nil, api.NewError(codes.InvalidArgument, api.ReasonInvalidRequest, "scopes are required", nil)
+	}
+	var ttl time.Duration
+	if req.Ttl != nil {
+		if ttl = req.Ttl.AsDuration(); ttl < 0 {
+			return nil, api.NewError(codes.InvalidArgument, api.ReasonInvalidRequest, "ttl must not be negative", nil)
+		}
+	}
+	token, id, expires, err := s.TokenIssuer.Issue(sub, req.Scopes, ttl)
+	if err != nil {
+		return nil, api.NewError(codes.InvalidArgument, api.ReasonInvalidRequest, err.Error(), nil)
+	}
+	s.Logger.Info("minted scoped token", "id", id, "subject", sub, "by", subject(ctx), "expires", expires)
+	return &api.CreateTokenResponse{Token: token, Id: id, Expires: timestamppb.New(expires)}, nil
+}
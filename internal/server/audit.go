@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+// AuditEvent records an authorization decision, for the audit trail of who did, or tried to do,
+// what on the log.
+type AuditEvent struct {
+	Time    time.Time `json:"time"`    // Time is when the decision was made.
+	Subject string    `json:"subject"` // Subject is the authenticated client.
+	// Token is the ID of the scoped token the client authenticated with, if it did, e.g. to
+	// tell the requests of a token minted by an operator from the operator's own.
+	Token  string `json:"token,omitempty"`
+	Object string `json:"object"` // Object is what the client acted on, e.g. a topic.
+	Action string `json:"action"` // Action is what the client did, e.g. produce.
+	// Method is the gRPC method called, e.g. /log.v1.Log/Produce, or the HTTP request's method
+	// and path, e.g. GET /offsets.
+	Method  string        `json:"method"`
+	Peer    string        `json:"peer"`    // Peer is the client's address.
+	Allowed bool          `json:"allowed"` // Allowed is whether the client may act on the object.
+	Latency time.Duration `json:"latency"` // Latency is how long the decision took, in nanoseconds.
+	// Reason is why the client was denied, the authorizer's error.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Auditor records the authorization decisions of the servers. Audit is called on the requests'
+// path, so it mustn't block, e.g. by queueing events to write them in the background.
+type Auditor interface {
+	Audit(event AuditEvent)
+}
+
+// authorize decides whether the client calling the RPC may act on the object, auditing the
+// decision if the config has an Auditor.
+func (c *Config) authorize(ctx context.Context, object, action string) error {
+	start := time.Now()
+	// Scoped tokens only narrow what their subjects may do
+	grant := tokenFromContext(ctx)
+	err := grant.permit(subject(ctx), object, action)
+	if err == nil {
+		err = c.Authorizer.Authorize(subject(ctx), object, action)
+	}
+	if c.Auditor == nil && err == nil {
+		return nil
+	}
+	method, _ := grpc.Method(ctx)
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr = p.Addr.String()
+	}
+	if c.Auditor != nil {
+		c.Auditor.Audit(newAuditEvent(start, subject(ctx), grant.tokenID(), object, action, method, addr, err))
+	}
+	if err != nil {
+		c.Logger.WarnContext(ctx, "authorization denied",
+			slog.String("subject", subject(ctx)),
+			slog.String("object", object),
+			slog.String("action", action),
+			slog.String("method", method),
+			slog.String("peer", addr),
+			slog.String("error", err.Error()),
+		)
+	}
+	return err
+}
+
+// newAuditEvent returns the event of a decision that started at start and returned err.
+func newAuditEvent(start time.Time, subject, token, object, action, method, peer string, err error) AuditEvent {
+	event := AuditEvent{
+		Time:    start,
+		Subject: subject,
+		Token:   token,
+		Object:  object,
+		Action:  action,
+		Method:  method,
+		Peer:    peer,
+		Allowed: err == nil,
+		Latency: time.Since(start),
+	}
+	if err != nil {
+		event.Reason = err.Error()
+	}
+	return event
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// defaultConsumerRetention is how long consumer groups are remembered after they last consumed,
+// unless the Config sets otherwise.
+const defaultConsumerRetention = 24 * time.Hour
+
+// consumerTracker keeps track of the position of every consumer group the server served records
+// to, in each partition, so their lag behind the head of the log can be listed by the Admin
+// service and alerted on. Unlike the stream registry's, positions outlive the RPCs reading them,
+// e.g. unary Consumes and streams reopened after a failure, until the groups stop consuming for
+// longer than the retention. It is also a Prometheus collector exporting that lag.
+type consumerTracker struct {
+	retention time.Duration
+	mu        sync.Mutex
+	consumers map[consumerKey]*consumerEntry
+}
+
+// consumerLagDesc describes the lag metric exported for every consumer group.
+var consumerLagDesc = prometheus.NewDesc(
+	"proglog_consumer_lag_records",
+	"Number of records between the offset following the last record served to a consumer group and the head of the log.",
+	[]string{"subject", "group", "topic", "partition"},
+	nil,
+)
+
+// consumerKey identifies a consumer group's position in a partition. A group consumed by several
+// subjects has a position for each, as each may read at its own pace.
+type consumerKey struct {
+	subject   string
+	group     string
+	topic     string
+	partition uint32
+}
+
+// consumerEntry is a consumer group's position in the partition's log.
+type consumerEntry struct {
+	log          CommitLog // Log of the partition, to compute the group's lag
+	offset       uint64    // Offset following the last record served
+	lastConsumed time.Time
+}
+
+// newConsumerTracker creates an empty tracker forgetting groups idle for longer than retention.
+func newConsumerTracker(retention time.Duration) *consumerTracker {
+	return &consumerTracker{
+		retention: retention,
+		consumers: make(map[consumerKey]*consumerEntry),
+	}
+}
+
+// consumed records that the record at offset of the log was served to the consumer group.
+func (t *consumerTracker) consumed(key consumerKey, log CommitLog, offset uint64) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.consumers[key] = &consumerEntry{log: log, offset: offset + 1, lastConsumed: now}
+	t.expire(now)
+}
+
+// expire forgets the groups that haven't consumed within the retention. It must be called with
+// the lock held.
+func (t *consumerTracker) expire(now time.Time) {
+	for key, e := range t.consumers {
+		if now.Sub(e.lastConsumed) > t.retention {
+			delete(t.consumers, key)
+		}
+	}
+}
+
+// highWatermarks returns a function returning the high watermark of a partition's log, reading
+// each log once, so the groups consuming a partition all see the same high watermark.
+func highWatermarks() func(key consumerKey, log CommitLog) (uint64, error) {
+	type partition struct {
+		topic string
+		id    uint32
+	}
+	hws := make(map[partition]uint64)
+	return func(key consumerKey, log CommitLog) (uint64, error) {
+		p := partition{topic: key.topic, id: key.partition}
+		if hw, ok := hws[p]; ok {
+			return hw, nil
+		}
+		hw, err := highWatermark(log)
+		if err != nil {
+			return 0, err
+		}
+		hws[p] = hw
+		return hw, nil
+	}
+}
+
+// list returns the positions of the consumer groups with their lag, filtered by topic and group
+// unless empty, and ordered by topic, partition, group and subject.
+func (t *consumerTracker) list(topic, group string) ([]*api.ConsumerLag, error) {
+	hwOf := highWatermarks()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(time.Now())
+	lags := make([]*api.ConsumerLag, 0, len(t.consumers))
+	for key, e := range t.consumers {
+		if (topic != "" && key.topic != topic) || (group != "" && key.group != group) {
+			continue
+		}
+		hw, err := hwOf(key, e.log)
+		if err != nil {
+			return nil, err
+		}
+		lags = append(lags, &api.ConsumerLag{
+			Subject:       key.subject,
+			Group:         key.group,
+			Topic:         key.topic,
+			Partition:     key.partition,
+			Offset:        e.offset,
+			HighWatermark: hw,
+			Lag:           lag(hw, e.offset),
+			LastConsumed:  timestamppb.New(e.lastConsumed),
+		})
+	}
+	sort.Slice(lags, func(i, j int) bool {
+		a, b := lags[i], lags[j]
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		if a.Partition != b.Partition {
+			return a.Partition < b.Partition
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.Subject < b.Subject
+	})
+	return lags, nil
+}
+
+// Describe implements prometheus.Collector.
+func (t *consumerTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- consumerLagDesc
+}
+
+// Collect implements prometheus.Collector, exporting the lag of every consumer group.
+func (t *consumerTracker) Collect(ch chan<- prometheus.Metric) {
+	lags, err := t.list("", "")
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(consumerLagDesc, err)
+		return
+	}
+	for _, l := range lags {
+		ch <- prometheus.MustNewConstMetric(
+			consumerLagDesc,
+			prometheus.GaugeValue,
+			float64(l.Lag),
+			l.Subject,
+			l.Group,
+			l.Topic,
+			strconv.FormatUint(uint64(l.Partition), 10),
+		)
+	}
+}
+
+// consumerGroup returns the consumer group of a read, which defaults to the client's subject.
+func consumerGroup(ctx context.Context, group string) string {
+	if group != "" {
+		return group
+	}
+	return subject(ctx)
+}
+
+// ListConsumers returns the consumer groups the server served records to, with their lag.
+func (s *adminServer) ListConsumers(ctx context.Context, req *api.ListConsumersRequest) (*api.ListConsumersResponse, error) {
+	if err := s.authorize(
+		ctx,
+		objectCluster,
+		describeAction,
+	); err != nil {
+		return nil, err
+	}
+	consumers, err := s.consumers.list(req.Topic, req.Group)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ListConsumersResponse{Consumers: consumers}, nil
+}
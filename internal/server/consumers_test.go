@@ -0,0 +1,58 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConsumerTrackerLag verifies that the tracker reports how far consumer groups are behind
+// the head of the log, both through list and as metrics, until they stop consuming for longer
+// than the retention.
+func TestConsumerTrackerLag(t *testing.T) {
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Remove()
+	for i := 0; i < 3; i++ {
+		_, err := clog.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	tracker := newConsumerTracker(time.Hour)
+	billing := consumerKey{subject: "root", group: "billing", topic: defaultTopic}
+	audit := consumerKey{subject: "root", group: "audit", topic: defaultTopic}
+	tracker.consumed(billing, clog, 0)
+	tracker.consumed(audit, clog, 2)
+
+	// Groups are positioned after the last record served to them, and lag behind the rest
+	lags, err := tracker.list("", "")
+	require.NoError(t, err)
+	require.Len(t, lags, 2)
+	require.Equal(t, "audit", lags[0].Group)
+	require.Equal(t, uint64(3), lags[0].Offset)
+	require.Equal(t, uint64(0), lags[0].Lag)
+	require.Equal(t, "billing", lags[1].Group)
+	require.Equal(t, uint64(1), lags[1].Offset)
+	require.Equal(t, uint64(3), lags[1].HighWatermark)
+	require.Equal(t, uint64(2), lags[1].Lag)
+
+	lags, err = tracker.list("", "billing")
+	require.NoError(t, err)
+	require.Len(t, lags, 1)
+	lags, err = tracker.list("orders", "")
+	require.NoError(t, err)
+	require.Empty(t, lags)
+
+	require.Equal(t, 2, testutil.CollectAndCount(tracker, "proglog_consumer_lag_records"))
+
+	// Groups idle for longer than the retention are forgotten
+	tracker.consumers[billing].lastConsumed = time.Now().Add(-2 * time.Hour)
+	lags, err = tracker.list("", "")
+	require.NoError(t, err)
+	require.Len(t, lags, 1)
+	require.Equal(t, "audit", lags[0].Group)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPI is the OpenAPI 3 document describing the HTTP API.
+// Keep it in sync with the routes registered in NewHttpServer; TestOpenAPI checks every route is documented.
+//
+//go:embed openapi.yaml
+var openAPI []byte
+
+// swaggerUI is a page rendering the OpenAPI document with Swagger UI, loaded from a CDN so the
+// server doesn't have to bundle its assets.
+const swaggerUI = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>ProgLog HTTP API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({url: "openapi.yaml", dom_id: "#swagger-ui"});
+    };
+  </script>
+</body>
+</html>
+`
+
+// handleOpenAPI serves the OpenAPI document, so client teams can generate SDKs from it.
+func (s *httpServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(openAPI)
+}
+
+// handleDocs serves Swagger UI for browsing and trying out the HTTP API.
+func (s *httpServer) handleDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUI))
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"context"
+
+	api "github.com/glauco/proglog/api/v1"
+	"google.golang.org/grpc"
+)
+
+// errorDetailsUnaryInterceptor makes sure every error returned by a unary RPC carries an
+// ErrorInfo detail, so clients can branch on its reason instead of matching messages.
+func errorDetailsUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		return resp, api.WithDetails(err)
+	}
+}
+
+// errorDetailsStreamInterceptor makes sure every error ending a stream carries an ErrorInfo detail.
+func errorDetailsStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return api.WithDetails(handler(srv, ss))
+	}
+}
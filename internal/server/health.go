@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+)
+
+// readyTimeout bounds how long the readiness checks of a single /readyz request may take.
+const readyTimeout = 5 * time.Second
+
+// ReadinessCheck reports whether a dependency of the server is ready to serve requests.
+type ReadinessCheck func(ctx context.Context) error
+
+// readinessCheck is a ReadinessCheck with the name it's reported under.
+type readinessCheck struct {
+	name  string
+	check ReadinessCheck
+}
+
+// ReadyResponse defines the structure for responses to readiness requests.
+type ReadyResponse struct {
+	Status string            `json:"status"` // "ready", or "unavailable" if any check failed
+	Checks map[string]string `json:"checks"` // Result of each check: "ok" or the error message
+}
+
+// handleHealthz reports that the process is up and serving HTTP, for liveness probes.
+func (s *httpServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz runs the readiness checks and responds with 200 OK if they all pass, or 503
+// Service Unavailable otherwise, so readiness probes only route traffic to ready servers.
+func (s *httpServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	res := ReadyResponse{Status: "ready", Checks: make(map[string]string, len(s.checks))}
+	status := http.StatusOK
+	for _, c := range s.checks {
+		if err := c.check(ctx); err != nil {
+			res.Checks[c.name] = err.Error()
+			res.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		res.Checks[c.name] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// logOpened is a ReadinessCheck passing once the log is open.
+func (s *httpServer) logOpened(ctx context.Context) error {
+	if s.Log == nil {
+		return errors.New("log not opened")
+	}
+	return nil
+}
+
+// DirWritable returns a ReadinessCheck passing while files can be created in dir,
+// e.g. to detect that the disk holding a log is full or was remounted read-only.
+func DirWritable(dir string) ReadinessCheck {
+	return func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		if _, err := f.Write([]byte("ok")); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
@@ -1,38 +1,180 @@
 package server
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	api "github.com/glauco/proglog/api/v1"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 )
 
+// HTTPConfig contains the dependencies and settings of the HTTP server.
+// NewHttpServer applies its options to the HTTPConfig and validates it before building the server.
+type HTTPConfig struct {
+	Addr      string       // Addr is the TCP address to listen on, e.g. ":9090".
+	Log       RecordLog    // Log stores the records; defaults to a new in-memory Log.
+	TLSConfig *tls.Config  // TLSConfig secures connections; HTTPAuth authenticates client certificates.
+	Logger    *slog.Logger // Logger receives the server's errors; defaults to slog.Default().
+	// Timeouts bound how long connections may take to send requests and receive responses.
+	Timeouts HTTPTimeouts
+	// MaxBodyBytes rejects request bodies larger than it with 413 Request Entity Too Large;
+	// 0 accepts bodies of any size.
+	MaxBodyBytes int64
+	// Middleware, such as RequestLogger or HTTPAuth.Middleware, wraps every route in the given order.
+	Middleware []mux.MiddlewareFunc
+	// ReadinessChecks are run by /readyz besides checking that the log is open, reported under their names.
+	ReadinessChecks map[string]ReadinessCheck
+	// AllowedOrigins are the origins of the web pages allowed to open WebSockets besides the
+	// server's own, e.g. https://app.example.com, or "*" for any; other pages are refused.
+	AllowedOrigins []string
+}
+
+// HTTPTimeouts are the timeouts of the HTTP server's connections; zero values disable them.
+// WriteTimeout must exceed the longest wait of long-polling consumes, or they are cut short.
+type HTTPTimeouts struct {
+	ReadHeader time.Duration // How long clients may take to send request headers
+	Read       time.Duration // How long clients may take to send whole requests
+	Write      time.Duration // How long handlers may take to write responses, from the end of the request headers
+	Idle       time.Duration // How long keep-alive connections may wait for the next request
+}
+
+// Validate checks that the HTTPConfig holds sane settings and fills in defaults for optional ones.
+func (c *HTTPConfig) Validate() error {
+	t := c.Timeouts
+	if t.ReadHeader < 0 || t.Read < 0 || t.Write < 0 || t.Idle < 0 {
+		return fmt.Errorf("http config: timeouts must not be negative, got %+v", t)
+	}
+	if c.MaxBodyBytes < 0 {
+		return fmt.Errorf("http config: max body size must not be negative, got %d", c.MaxBodyBytes)
+	}
+	if c.Log == nil {
+		c.Log = NewLog()
+	}
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+	return nil
+}
+
+// RecordLog stores the records served by the HTTP server. Log implements it in memory.
+type RecordLog interface {
+	Append(Record) (uint64, error) // Append adds a record to the log and returns its offset.
+	// CompareAndAppend appends only if the record gets the expected offset, or fails with api.ErrOffsetMismatch.
+	CompareAndAppend(Record, uint64) (uint64, error)
+	Read(uint64) (Record, error)         // Read retrieves the record at the given offset.
+	LowestOffset() uint64                // LowestOffset returns the offset of the oldest record.
+	NextOffset() uint64                  // NextOffset returns the offset the next appended record will get.
+	Truncate(before uint64) (int, error) // Truncate removes the records before an offset and returns how many.
+	Size() (records int, bytes int)      // Size returns the number of records and the size of their values.
+}
+
 // NewHttpServer initializes a new HTTP server with endpoints for producing and consuming log records.
-// It binds to the provided address and returns a configured *http.Server instance.
-func NewHttpServer(addr string) *http.Server {
-	httpsrv := newHttpServer()
+// It applies the options to the config, validates it and returns a configured *http.Server instance
+// listening on config.Addr, to be started with ListenAndServe, or ListenAndServeTLS with TLS.
+func NewHttpServer(config *HTTPConfig, opts ...HTTPOption) (*http.Server, error) {
+	// Apply the options and make sure the resulting configuration is usable
+	for _, opt := range opts {
+		opt(config)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	httpsrv := newHttpServer(config.Log)
+	httpsrv.upgrader.CheckOrigin = checkOrigin(config.AllowedOrigins)
+	for name, check := range config.ReadinessChecks {
+		httpsrv.checks = append(httpsrv.checks, readinessCheck{name, check})
+	}
 	r := mux.NewRouter()
+	if config.MaxBodyBytes > 0 {
+		r.Use(maxBodyBytes(config.MaxBodyBytes))
+	}
+	r.Use(config.Middleware...)
 
 	// POST endpoint for producing records
-	r.HandleFunc("/", httpsrv.handleProduce).Methods("POST")
-	// GET endpoint for consuming records
-	r.HandleFunc("/", httpsrv.handleConsume).Methods("GET")
-	return &http.Server{
-		Addr:    addr,
-		Handler: r,
+	r.HandleFunc("/records", httpsrv.handleProduce).Methods("POST").Name(routeProduce)
+	// POST endpoint for producing the request body as is, without encoding it
+	r.HandleFunc("/records/raw", httpsrv.handleProduceRaw).Methods("POST").Name(routeProduce)
+	// POST endpoint for producing CloudEvents, so the log can act as an event sink
+	r.HandleFunc("/cloudevents", httpsrv.handleCloudEvent).Methods("POST").Name(routeProduce)
+	// GET endpoint for consuming a batch of records, e.g. /records?offset=42&max=100
+	r.HandleFunc("/records", httpsrv.handleConsumeRecords).Methods("GET").Name(routeConsume)
+	// GET endpoint for consuming the record at the offset in the path
+	r.HandleFunc("/records/{offset}", httpsrv.handleGetRecord).Methods("GET").Name(routeConsume)
+	// GET endpoint describing the range of offsets held by the log
+	r.HandleFunc("/offsets", httpsrv.handleOffsets).Methods("GET").Name(routeOffsets)
+	// WebSocket endpoint for producing and consuming records with JSON frames
+	r.HandleFunc("/ws", httpsrv.handleWebSocket).Methods("GET").Name(routeWS)
+	// Admin endpoints for operators, requiring admin permissions
+	r.HandleFunc("/records", httpsrv.handleTruncate).Methods("DELETE").Name(routeAdmin)
+	r.HandleFunc("/segments", httpsrv.handleSegments).Methods("GET").Name(routeAdminDescribe)
+	r.HandleFunc("/stats", httpsrv.handleStats).Methods("GET").Name(routeAdminDescribe)
+	// Liveness and readiness probes, served without authentication
+	r.HandleFunc("/healthz", httpsrv.handleHealthz).Methods("GET").Name(routeHealth)
+	r.HandleFunc("/readyz", httpsrv.handleReadyz).Methods("GET").Name(routeHealth)
+	// API documentation: the OpenAPI document and Swagger UI rendering it
+	r.HandleFunc("/openapi.yaml", httpsrv.handleOpenAPI).Methods("GET").Name(routeDocs)
+	r.HandleFunc("/docs", httpsrv.handleDocs).Methods("GET").Name(routeDocs)
+
+	// Deprecated: the original routes overloading "/", where consuming requires a JSON body on GET.
+	// They are kept for existing clients and will be removed in a future release.
+	r.HandleFunc("/", httpsrv.handleProduce).Methods("POST").Name(routeProduce)
+	r.HandleFunc("/", httpsrv.handleConsume).Methods("GET").Name(routeConsume)
+
+	// Match OPTIONS requests on every path, so the middleware can answer CORS preflights
+	r.Methods("OPTIONS").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := &http.Server{
+		Addr:              config.Addr,
+		Handler:           r,
+		TLSConfig:         config.TLSConfig,
+		ReadHeaderTimeout: config.Timeouts.ReadHeader,
+		ReadTimeout:       config.Timeouts.Read,
+		WriteTimeout:      config.Timeouts.Write,
+		IdleTimeout:       config.Timeouts.Idle,
+		ErrorLog:          slog.NewLogLogger(config.Logger.Handler(), slog.LevelError),
 	}
+	// Shutting down doesn't close hijacked connections, so the WebSockets are closed with it
+	srv.RegisterOnShutdown(httpsrv.webSockets.close)
+	return srv, nil
 }
 
-// httpServer is a wrapper around the Log type, providing HTTP-based access to its methods.
+// maxBodyBytes returns middleware failing reads of request bodies past limit bytes,
+// which readBody answers with 413 Request Entity Too Large.
+func maxBodyBytes(limit int64) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// httpServer is a wrapper around a RecordLog, providing HTTP-based access to its methods.
 type httpServer struct {
-	Log *Log // Log instance to store and retrieve records
+	Log        RecordLog          // Log instance to store and retrieve records
+	checks     []readinessCheck   // Checks run by /readyz
+	webSockets webSocketSet       // Open WebSockets, closed when the server shuts down
+	upgrader   websocket.Upgrader // Upgrades /ws requests, checking their origin
 }
 
-// newHttpServer creates and returns a new httpServer instance with an initialized Log.
-func newHttpServer() *httpServer {
-	return &httpServer{
-		Log: NewLog(),
+// newHttpServer creates and returns a new httpServer instance serving the log.
+func newHttpServer(log RecordLog) *httpServer {
+	s := &httpServer{
+		Log: log,
 	}
+	s.checks = append(s.checks, readinessCheck{"log", s.logOpened})
+	return s
 }
 
 // ProduceRequest defines the structure for incoming requests to produce a new record in the log.
@@ -55,62 +197,270 @@ type ConsumeResponse struct {
 	Record Record `json:"record"` // Record retrieved from the log
 }
 
+// Limits on the number of records returned by a single GET /records request.
+const (
+	defaultConsumeLimit = 100  // Records returned when the request doesn't set a limit
+	maxConsumeLimit     = 1000 // Largest limit a request may ask for
+)
+
+// ConsumeRecordsResponse defines the structure for responses to batch consume requests, which
+// return a page of records. The next page starts at NextOffset; once a consumer has caught up
+// with the log, pages are empty and NextOffset stays put until more records are produced.
+type ConsumeRecordsResponse struct {
+	Records    []Record `json:"records"`     // Records read from the log, in offset order
+	NextOffset uint64   `json:"next_offset"` // Offset of the first record of the next page
+}
+
+// OffsetsResponse describes the range of offsets held by the log: records exist at offsets
+// in [LowestOffset, NextOffset), and the next produced record will get NextOffset.
+type OffsetsResponse struct {
+	LowestOffset uint64 `json:"lowest_offset"` // Offset of the oldest record in the log
+	NextOffset   uint64 `json:"next_offset"`   // Offset the next produced record will get
+}
+
 // handleProduce processes HTTP POST requests to add a new record to the log.
 // It decodes the request, appends the record to the log, and responds with the record's offset.
 func (s *httpServer) handleProduce(w http.ResponseWriter, r *http.Request) {
 	var req ProduceRequest
-	// Decode the JSON body into a ProduceRequest struct
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		// Respond with a 400 Bad Request if decoding fails
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	// Decode the body into a ProduceRequest struct, in the format given by its Content-Type
+	if !decode(w, r, &req) {
 		return
 	}
 
-	// Append the record to the log and get its offset
-	off, err := s.Log.Append(req.Record)
-	if err != nil {
-		// Respond with a 500 Internal Server Error if appending fails
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Append the record to the log and respond with its offset
+	s.produce(w, r, req.Record)
+}
+
+// Headers carrying the key and headers of records produced with POST /records/raw.
+const (
+	recordKeyHeader          = "X-Record-Key"
+	recordHeaderHeaderPrefix = "X-Record-Header-"
+)
+
+// handleProduceRaw processes HTTP POST requests to add a record whose value is the request
+// body, so binary payloads don't have to be encoded into JSON. The record's key is given by
+// the X-Record-Key header, and each X-Record-Header-{Name} header adds a header to the record.
+func (s *httpServer) handleProduceRaw(w http.ResponseWriter, r *http.Request) {
+	value, ok := readBody(w, r)
+	if !ok {
 		return
 	}
+	rec := Record{Value: value}
+	if key := r.Header.Get(recordKeyHeader); key != "" {
+		rec.Key = []byte(key)
+	}
+	for name, values := range r.Header {
+		// Header names are canonicalized, so lower case them to not depend on how they were sent
+		if name, ok := strings.CutPrefix(name, recordHeaderHeaderPrefix); ok && name != "" {
+			if rec.Headers == nil {
+				rec.Headers = make(map[string]string)
+			}
+			rec.Headers[strings.ToLower(name)] = values[0]
+		}
+	}
+
+	s.produce(w, r, rec)
+}
 
-	// Respond with a JSON containing the offset of the new record
-	res := ProduceResponse{Offset: off}
-	err = json.NewEncoder(w).Encode(res)
+// produce appends the record to the log and responds with its offset, in the format the client
+// accepts. With an If-Match header holding an offset, e.g. If-Match: "42", the record is only
+// appended if it gets that offset, like gRPC compare-and-append; otherwise the request fails with
+// 412 Precondition Failed. Either way, the ETag header holds the offset the next record will get,
+// so optimistic writers know what to expect next.
+func (s *httpServer) produce(w http.ResponseWriter, r *http.Request, rec Record) {
+	var off uint64
+	var err error
+	if match := r.Header.Get("If-Match"); match != "" && match != "*" {
+		expected, perr := strconv.ParseUint(strings.Trim(match, `"`), 10, 64)
+		if perr != nil {
+			http.Error(w, "If-Match must be an offset: "+perr.Error(), http.StatusBadRequest)
+			return
+		}
+		off, err = s.Log.CompareAndAppend(rec, expected)
+	} else {
+		off, err = s.Log.Append(rec)
+	}
+	var mismatch api.ErrOffsetMismatch
+	if errors.As(err, &mismatch) {
+		w.Header().Set("ETag", offsetETag(mismatch.Next))
+	}
 	if err != nil {
-		// Respond with a 500 Internal Server Error if encoding fails
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		// Respond with the status code describing the failure
+		httpError(w, err)
 		return
 	}
+	w.Header().Set("ETag", offsetETag(off+1))
+	respond(w, r, ProduceResponse{Offset: off})
+}
+
+// offsetETag returns the entity tag identifying the state of the log whose next offset is next.
+func offsetETag(next uint64) string {
+	return `"` + strconv.FormatUint(next, 10) + `"`
 }
 
 // handleConsume processes HTTP GET requests to retrieve a record from the log by its offset.
 // It decodes the request, retrieves the record, and responds with the record's content.
 func (s *httpServer) handleConsume(w http.ResponseWriter, r *http.Request) {
 	var req ConsumeRequest
-	// Decode the JSON body into a ConsumeRequest struct
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		// Respond with a 400 Bad Request if decoding fails
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	// Decode the body into a ConsumeRequest struct, in the format given by its Content-Type
+	if !decode(w, r, &req) {
 		return
 	}
 
 	// Read the record from the log using the provided offset
 	rec, err := s.Log.Read(req.Offset)
 	if err != nil {
-		// Respond with a 500 Internal Server Error if reading fails
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		// Respond with a 404 Not Found if there's no record at the offset, or a 500 if reading fails
+		httpError(w, err)
 		return
 	}
 
-	// Respond with a JSON containing the requested record
-	res := ConsumeResponse{Record: rec}
-	err = json.NewEncoder(w).Encode(res)
+	// Respond with the requested record, in the format the client accepts
+	respond(w, r, ConsumeResponse{Record: rec})
+}
+
+// maxConsumeWait is the longest a GET /records/{offset} request may wait for its record.
+const maxConsumeWait = time.Minute
+
+// handleGetRecord processes HTTP GET requests for the record at the offset given in the path,
+// e.g. GET /records/42, so consuming doesn't require a request body.
+// With a wait query parameter, e.g. GET /records/42?wait=30s, requests for the head of the log
+// are held until the record is appended, or answered with 204 No Content once the wait expires,
+// so pollers don't have to hammer the server.
+func (s *httpServer) handleGetRecord(w http.ResponseWriter, r *http.Request) {
+	// Parse the offset from the path
+	off, err := strconv.ParseUint(mux.Vars(r)["offset"], 10, 64)
 	if err != nil {
-		// Respond with a 500 Internal Server Error if encoding the response fails
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		// Respond with a 400 Bad Request if the offset isn't a valid number
+		http.Error(w, "invalid offset: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	var wait time.Duration
+	if query := r.URL.Query(); query.Has("wait") {
+		wait, err = time.ParseDuration(query.Get("wait"))
+		if err != nil || wait < 0 || wait > maxConsumeWait {
+			http.Error(w, "wait must be a duration between 0s and "+maxConsumeWait.String(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Read the record from the log
+	rec, err := s.Log.Read(off)
+	if isOffsetNotFound(err) && wait > 0 && off >= s.Log.LowestOffset() {
+		// The record hasn't been appended yet, so hold the request until it is
+		var found bool
+		if rec, found = s.awaitRecord(r.Context(), off, wait); !found {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		err = nil
+	}
+	if err != nil {
+		// Respond with a 404 Not Found if there's no record at the offset, or a 500 if reading fails
+		httpError(w, err)
+		return
+	}
+
+	// Respond with the requested record, in the format the client accepts
+	respond(w, r, ConsumeResponse{Record: rec})
+}
+
+// awaitRecord waits up to wait for the record at off to be appended, polling the log like
+// long-polling gRPC consumes do. It returns false if the wait expires or the client goes away first.
+func (s *httpServer) awaitRecord(ctx context.Context, off uint64, wait time.Duration) (Record, bool) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	ticker := time.NewTicker(readWaitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return Record{}, false
+		case <-ticker.C:
+		}
+		if rec, err := s.Log.Read(off); err == nil {
+			return rec, true
+		}
+	}
+}
+
+// handleOffsets processes HTTP GET requests for the range of offsets held by the log.
+func (s *httpServer) handleOffsets(w http.ResponseWriter, r *http.Request) {
+	res := OffsetsResponse{
+		LowestOffset: s.Log.LowestOffset(),
+		NextOffset:   s.Log.NextOffset(),
+	}
+	// The ETag can be sent back in the If-Match header of a produce
+	w.Header().Set("ETag", offsetETag(res.NextOffset))
+	respond(w, r, res)
+}
+
+// handleConsumeRecords processes HTTP GET requests for a page of records addressed by query
+// parameters, e.g. GET /records?offset=42&limit=100, which returns up to limit records starting
+// at offset along with the offset the next page starts at, also given in a Link header.
+// max is accepted as an alias of limit. Offsets outside the log are answered with 416 Range Not
+// Satisfiable. Requests without query parameters fall back to the deprecated JSON body form.
+func (s *httpServer) handleConsumeRecords(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if !query.Has("offset") {
+		// Deprecated: kept for clients sending the offset in a JSON body; remove in the next release
+		s.handleConsume(w, r)
+		return
+	}
+
+	// Parse the starting offset and the maximum number of records to return
+	off, err := strconv.ParseUint(query.Get("offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid offset: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := defaultConsumeLimit
+	for _, param := range []string{"max", "limit"} {
+		if !query.Has(param) {
+			continue
+		}
+		limit, err = strconv.Atoi(query.Get(param))
+		if err != nil || limit <= 0 || limit > maxConsumeLimit {
+			http.Error(w, param+" must be a number between 1 and "+strconv.Itoa(maxConsumeLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Pages can start anywhere from the oldest record to the end of the log
+	lowest, next := s.Log.LowestOffset(), s.Log.NextOffset()
+	if off < lowest || off > next {
+		// Respond with a 416 Range Not Satisfiable, so consumers know to move their cursor
+		msg := fmt.Sprintf("offset %d is outside the log's offsets [%d, %d]", off, lowest, next)
+		http.Error(w, msg, http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	res := ConsumeRecordsResponse{Records: []Record{}, NextOffset: off}
+	// A consumer that caught up with the log gets an empty page, so it can keep polling the same cursor...
+	if off != next {
+		// ...otherwise the first record must exist...
+		rec, err := s.Log.Read(off)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		res.Records = append(res.Records, rec)
+		// ...then the following ones are read until the limit or the end of the log
+		for len(res.Records) < limit {
+			rec, err = s.Log.Read(off + uint64(len(res.Records)))
+			if err != nil {
+				break
+			}
+			res.Records = append(res.Records, rec)
+		}
+		res.NextOffset = off + uint64(len(res.Records))
+	}
+
+	// Point to the next page, then respond with the records in the format the client accepts
+	link := url.URL{Path: r.URL.Path, RawQuery: url.Values{
+		"offset": {strconv.FormatUint(res.NextOffset, 10)},
+		"limit":  {strconv.Itoa(limit)},
+	}.Encode()}
+	w.Header().Set("Link", "<"+link.String()+`>; rel="next"`)
+	respond(w, r, res)
 }
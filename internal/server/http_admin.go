@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// TruncateResponse defines the structure for responses to truncate requests.
+type TruncateResponse struct {
+	Removed      int    `json:"removed"`       // Number of records removed from the log
+	LowestOffset uint64 `json:"lowest_offset"` // Offset of the oldest record left in the log
+}
+
+// SegmentInfo describes a segment of the log.
+type SegmentInfo struct {
+	BaseOffset uint64 `json:"base_offset"` // Offset of the first record in the segment
+	NextOffset uint64 `json:"next_offset"` // Offset following the last record in the segment
+	Bytes      int    `json:"bytes"`       // Size of the records' values in bytes
+}
+
+// SegmentsResponse defines the structure for responses listing the segments of the log.
+type SegmentsResponse struct {
+	Segments []SegmentInfo `json:"segments"` // Segments in offset order
+}
+
+// StatsResponse defines the structure for responses describing the log's contents.
+type StatsResponse struct {
+	LowestOffset uint64 `json:"lowest_offset"` // Offset of the oldest record in the log
+	NextOffset   uint64 `json:"next_offset"`   // Offset the next produced record will get
+	Records      int    `json:"records"`       // Number of records in the log
+	Bytes        int    `json:"bytes"`         // Size of the records' values in bytes
+}
+
+// handleTruncate processes HTTP DELETE requests removing the records before an offset,
+// e.g. DELETE /records?before_offset=42, to reclaim space taken by records no longer needed.
+func (s *httpServer) handleTruncate(w http.ResponseWriter, r *http.Request) {
+	before, err := strconv.ParseUint(r.URL.Query().Get("before_offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid before_offset: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	removed, err := s.Log.Truncate(before)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	respond(w, r, TruncateResponse{Removed: removed, LowestOffset: s.Log.LowestOffset()})
+}
+
+// handleSegments processes HTTP GET requests listing the segments of the log.
+// The in-memory log keeps every record in a single segment.
+func (s *httpServer) handleSegments(w http.ResponseWriter, r *http.Request) {
+	_, bytes := s.Log.Size()
+	respond(w, r, SegmentsResponse{Segments: []SegmentInfo{{
+		BaseOffset: s.Log.LowestOffset(),
+		NextOffset: s.Log.NextOffset(),
+		Bytes:      bytes,
+	}}})
+}
+
+// handleStats processes HTTP GET requests describing the log's contents.
+func (s *httpServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	records, bytes := s.Log.Size()
+	respond(w, r, StatsResponse{
+		LowestOffset: s.Log.LowestOffset(),
+		NextOffset:   s.Log.NextOffset(),
+		Records:      records,
+		Bytes:        bytes,
+	})
+}
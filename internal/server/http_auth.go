@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Names of the HTTP routes, used by middleware to find out which operation a request performs.
+const (
+	routeProduce = "produce"
+	routeConsume = "consume"
+	routeOffsets = "offsets"
+	routeWS      = "ws"
+	routeHealth  = "health"
+	routeDocs    = "docs"
+	// Admin routes modifying the log, and describing it
+	routeAdmin         = "admin"
+	routeAdminDescribe = "admin-describe"
+)
+
+// publicRoutes are served without authentication, e.g. for Kubernetes probes.
+var publicRoutes = map[string]bool{
+	routeHealth: true,
+	routeDocs:   true,
+}
+
+// permission is an action on an object, checked by the Authorizer.
+type permission struct {
+	object string
+	action string
+}
+
+// routePermissions lists the permissions a subject needs to use each HTTP route. They match
+// the permissions checked by the gRPC server, so both front doors enforce the same policy.
+// WebSockets can both produce and consume, so they require both permissions.
+var routePermissions = map[string][]permission{
+	routeProduce: {{defaultTopic, produceAction}},
+	routeConsume: {{defaultTopic, consumeAction}},
+	routeOffsets: {{objectOffsets, describeAction}},
+	routeWS:      {{defaultTopic, produceAction}, {defaultTopic, consumeAction}},
+	// Admin routes are authorized like the Debug gRPC service
+	routeAdmin:         {{objectAdmin, adminAction}},
+	routeAdminDescribe: {{objectAdmin, describeAction}},
+}
+
+// HTTPAuth authenticates HTTP requests and authorizes them with the same Authorizer as the
+// gRPC server. Requests are authenticated by, in order of precedence, the subject of a
+// verified client certificate, a bearer token in the Authorization header, or an API key
+// in the X-API-Key header.
+type HTTPAuth struct {
+	Authorizer   Authorizer        // Authorizer decides whether the subject may use the route.
+	BearerTokens map[string]string // BearerTokens maps accepted bearer tokens to their subjects.
+	APIKeys      map[string]string // APIKeys maps accepted API keys to their subjects.
+	// Tokens, if set, validates the bearer tokens BearerTokens doesn't hold, e.g. JWTs.
+	Tokens TokenValidator
+	// Issuer, if set, validates the scoped tokens it minted, like the gRPC server's TokenIssuer;
+	// they're only permitted the actions of their scopes.
+	Issuer TokenIssuer
+	// Keys, if set, validates the API keys APIKeys doesn't hold, e.g. an auth.APIKeyStore's.
+	Keys TokenValidator
+	// SubjectRules, if set, extract the subjects of client certificates like the gRPC server's;
+	// certificates are otherwise authenticated by their CommonNames.
+	SubjectRules []SubjectRule
+	// Auditor, if set, records every authorization decision, like the gRPC server's.
+	Auditor Auditor
+}
+
+// Middleware returns middleware rejecting unauthenticated requests with 401 Unauthorized and
+// unauthorized ones with 403 Forbidden. It must run inside the router, so the route is known.
+func (a *HTTPAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var name string
+		if route := mux.CurrentRoute(r); route != nil {
+			name = route.GetName()
+		}
+		if publicRoutes[name] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sub, grant, ok := a.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="proglog"`)
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		// Check every permission the route requires
+		for _, p := range routePermissions[name] {
+			if err := a.authorize(r, sub, grant, p); err != nil {
+				// Denials are 403 Forbidden; failures to decide map to their own status code
+				httpError(w, err)
+				return
+			}
+		}
+
+		// Make the subject available to the handlers, like the gRPC server does
+		ctx := context.WithValue(r.Context(), subjectContextKey{}, sub)
+		if grant != nil {
+			ctx = context.WithValue(ctx, tokenContextKey{}, grant)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authorize decides whether the subject making the request, limited by its scoped token if it
+// authenticated with one, has the permission, auditing the decision if an Auditor is set.
+func (a *HTTPAuth) authorize(r *http.Request, sub string, grant *tokenGrant, p permission) error {
+	start := time.Now()
+	err := grant.permit(sub, p.object, p.action)
+	if err == nil {
+		err = a.Authorizer.Authorize(sub, p.object, p.action)
+	}
+	if a.Auditor != nil {
+		a.Auditor.Audit(newAuditEvent(start, sub, grant.tokenID(), p.object, p.action, r.Method+" "+r.URL.Path, r.RemoteAddr, err))
+	}
+	return err
+}
+
+// authenticate returns the subject making the request, with the scoped token it authenticated
+// with if it did, or false if the request carries no valid credentials.
+func (a *HTTPAuth) authenticate(r *http.Request) (string, *tokenGrant, bool) {
+	// Client certificates are verified during the TLS handshake
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		sub, err := certSubject(r.TLS.VerifiedChains[0][0], a.SubjectRules)
+		return sub, nil, err == nil
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if a.Issuer != nil && a.Issuer.Minted(token) {
+			sub, id, scopes, err := a.Issuer.Validate(r.Context(), token)
+			return sub, &tokenGrant{id: id, scopes: scopes}, err == nil
+		}
+		if sub, ok := a.BearerTokens[token]; ok || a.Tokens == nil {
+			return sub, nil, ok
+		}
+		sub, err := a.Tokens.Validate(r.Context(), token)
+		return sub, nil, err == nil
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		if sub, ok := a.APIKeys[key]; ok || a.Keys == nil {
+			return sub, nil, ok
+		}
+		sub, err := a.Keys.Validate(r.Context(), key)
+		return sub, nil, err == nil
+	}
+	return "", nil, false
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Media types and prefixes of the CloudEvents HTTP protocol binding.
+const (
+	contentTypeCloudEvents  = "application/cloudevents+json" // Events in structured mode
+	cloudEventsMediaPrefix  = "application/cloudevents"      // Any CloudEvents format, e.g. batches
+	cloudEventsHeaderPrefix = "Ce-"                          // Attributes of events in binary mode, canonicalized
+)
+
+// cloudEventsRecordPrefix prefixes the names of the record headers holding event attributes,
+// as in the CloudEvents Kafka protocol binding, so consumers can use CloudEvents SDKs to read them.
+const cloudEventsRecordPrefix = "ce_"
+
+// requiredCloudEventAttributes must be set on every event.
+var requiredCloudEventAttributes = []string{"specversion", "id", "source", "type"}
+
+// handleCloudEvent processes HTTP POST requests carrying a CloudEvent, in binary mode, where the
+// attributes are ce-* headers and the body is the event data, or in structured mode, where the
+// body is the whole event encoded as application/cloudevents+json. The event data becomes the
+// record's value and its attributes the record's headers, laid out like the CloudEvents Kafka
+// binding: ce_{attribute} headers plus content-type, and the partitionkey extension as the key.
+func (s *httpServer) handleCloudEvent(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var attrs map[string]string
+	var data []byte
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == contentTypeCloudEvents:
+		attrs, data, err = parseStructuredCloudEvent(body)
+	case strings.HasPrefix(mediaType, cloudEventsMediaPrefix):
+		// Batches and non-JSON event formats aren't supported
+		http.Error(w, "unsupported cloudevents format: "+mediaType, http.StatusUnsupportedMediaType)
+		return
+	default:
+		attrs, data = binaryCloudEvent(r.Header), body
+	}
+	if err == nil {
+		err = validateCloudEvent(attrs)
+	}
+	if err != nil {
+		// Respond with a 400 Bad Request if the event is malformed
+		http.Error(w, "invalid cloudevent: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rec := Record{Value: data, Headers: make(map[string]string, len(attrs))}
+	for name, value := range attrs {
+		if name == "datacontenttype" {
+			rec.Headers["content-type"] = value
+			continue
+		}
+		rec.Headers[cloudEventsRecordPrefix+name] = value
+	}
+	if key, ok := attrs["partitionkey"]; ok {
+		rec.Key = []byte(key)
+	}
+
+	s.produce(w, r, rec)
+}
+
+// binaryCloudEvent returns the attributes of an event sent in binary mode, which are given by
+// the ce-* headers, plus the Content-Type header as the datacontenttype attribute.
+func binaryCloudEvent(h http.Header) map[string]string {
+	attrs := make(map[string]string)
+	for name, values := range h {
+		name, ok := strings.CutPrefix(name, cloudEventsHeaderPrefix)
+		if !ok || name == "" {
+			continue
+		}
+		// Header values percent-encode the characters headers can't hold
+		value, err := url.PathUnescape(values[0])
+		if err != nil {
+			value = values[0]
+		}
+		attrs[strings.ToLower(name)] = value
+	}
+	if ct := h.Get("Content-Type"); ct != "" {
+		attrs["datacontenttype"] = ct
+	}
+	return attrs
+}
+
+// parseStructuredCloudEvent returns the attributes and data of an event encoded in JSON.
+// Attributes that aren't strings, like numeric extensions, are kept in their JSON form.
+func parseStructuredCloudEvent(b []byte) (map[string]string, []byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, nil, err
+	}
+	attrs := make(map[string]string, len(fields))
+	for name, raw := range fields {
+		if name == "data" || name == "data_base64" || string(raw) == "null" {
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+		attrs[name] = value
+	}
+
+	var data []byte
+	if raw, ok := fields["data_base64"]; ok {
+		var encoded string
+		if err := json.Unmarshal(raw, &encoded); err != nil {
+			return nil, nil, fmt.Errorf("data_base64 must be a string: %w", err)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, nil, fmt.Errorf("data_base64: %w", err)
+		}
+		data = decoded
+	} else if raw, ok := fields["data"]; ok {
+		// JSON data is stored as is, while strings of other content types hold the data as text
+		var text string
+		if !isJSONContentType(attrs["datacontenttype"]) && json.Unmarshal(raw, &text) == nil {
+			data = []byte(text)
+		} else {
+			data = raw
+		}
+	}
+	return attrs, data, nil
+}
+
+// validateCloudEvent checks that the event has every required attribute, in a supported spec version.
+func validateCloudEvent(attrs map[string]string) error {
+	for _, name := range requiredCloudEventAttributes {
+		if attrs[name] == "" {
+			return errors.New("missing required attribute " + name)
+		}
+	}
+	if attrs["specversion"] != "1.0" {
+		return errors.New("unsupported specversion " + attrs["specversion"])
+	}
+	return nil
+}
+
+// isJSONContentType reports whether data of the content type is JSON, which is also the
+// default content type of structured events.
+func isJSONContentType(ct string) bool {
+	if ct == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(ct)
+	return err == nil && (mediaType == contentTypeJSON || strings.HasSuffix(mediaType, "+json"))
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Media types supported by the HTTP API for request and response bodies.
+const (
+	contentTypeJSON     = "application/json"
+	contentTypeProtobuf = "application/protobuf"
+	contentTypeMsgpack  = "application/msgpack"
+)
+
+// codec encodes and decodes HTTP bodies in a media type.
+type codec interface {
+	contentType() string
+	marshal(v any) ([]byte, error)
+	unmarshal(b []byte, v any) error
+}
+
+// codecs maps the media types clients may send and accept to their codec,
+// including the unofficial aliases in common use.
+var codecs = map[string]codec{
+	contentTypeJSON:          jsonCodec{},
+	contentTypeProtobuf:      protobufCodec{},
+	"application/x-protobuf": protobufCodec{},
+	contentTypeMsgpack:       msgpackCodec{},
+	"application/x-msgpack":  msgpackCodec{},
+}
+
+// errNoProtobuf is returned when a body has no protobuf representation.
+var errNoProtobuf = errors.New("no protobuf representation")
+
+// gzipMinSize is the smallest response body worth compressing; smaller ones, like produce
+// responses, would barely shrink, while pages of records compress well.
+const gzipMinSize = 1024
+
+// requestCodec returns the codec for the request's Content-Type, defaulting to JSON.
+func requestCodec(r *http.Request) (codec, bool) {
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		return jsonCodec{}, true
+	}
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return nil, false
+	}
+	c, ok := codecs[mediaType]
+	return c, ok
+}
+
+// responseCodec returns the codec for the first supported media type in the request's
+// Accept header, defaulting to JSON when the client accepts anything.
+func responseCodec(r *http.Request) (codec, bool) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return jsonCodec{}, true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if c, ok := codecs[mediaType]; ok {
+			return c, true
+		}
+		if mediaType == "*/*" || mediaType == "application/*" {
+			return jsonCodec{}, true
+		}
+	}
+	return nil, false
+}
+
+// decode reads the request body into v using the codec for the request's Content-Type,
+// decompressing it first if its Content-Encoding is gzip.
+// On failure it responds with 415 Unsupported Media Type or 400 Bad Request and returns false.
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	c, ok := requestCodec(r)
+	if !ok {
+		http.Error(w, "unsupported content type: "+r.Header.Get("Content-Type"), http.StatusUnsupportedMediaType)
+		return false
+	}
+	b, ok := readBody(w, r)
+	if !ok {
+		return false
+	}
+	err := c.unmarshal(b, v)
+	if errors.Is(err, errNoProtobuf) {
+		http.Error(w, "the request has no protobuf representation", http.StatusUnsupportedMediaType)
+		return false
+	}
+	if err != nil {
+		// Respond with a 400 Bad Request if decoding fails
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// readBody reads the request body, decompressing it first if its Content-Encoding is gzip.
+// On failure it responds with 415 Unsupported Media Type, 413 Request Entity Too Large or
+// 400 Bad Request and returns false.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	var body io.Reader = r.Body
+	switch enc := r.Header.Get("Content-Encoding"); enc {
+	case "", "identity":
+	case "gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "invalid gzip body: "+err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		defer zr.Close()
+		body = zr
+	default:
+		http.Error(w, "unsupported content encoding: "+enc, http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+	b, err := io.ReadAll(body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("request body larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return b, true
+}
+
+// respond writes v as the response body using the codec for the request's Accept header,
+// compressed with gzip if the client accepts it and the body is large enough to benefit.
+// It responds with 406 Not Acceptable if no accepted media type can represent v.
+func respond(w http.ResponseWriter, r *http.Request, v any) {
+	c, ok := responseCodec(r)
+	if !ok {
+		http.Error(w, "none of the accepted media types is supported: "+r.Header.Get("Accept"), http.StatusNotAcceptable)
+		return
+	}
+	b, err := c.marshal(v)
+	if errors.Is(err, errNoProtobuf) {
+		http.Error(w, "the response has no protobuf representation", http.StatusNotAcceptable)
+		return
+	}
+	if err != nil {
+		// Respond with a 500 Internal Server Error if encoding fails
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", c.contentType())
+	w.Header().Add("Vary", "Accept-Encoding")
+	if len(b) < gzipMinSize || !acceptsGzip(r) {
+		w.Write(b)
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	zw := gzip.NewWriter(w)
+	zw.Write(b)
+	zw.Close()
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding header allows gzip responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if enc != "gzip" && enc != "*" {
+			continue
+		}
+		// Clients may refuse an encoding with a zero quality value, e.g. "gzip;q=0"
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err == nil && weight > 0
+	}
+	return false
+}
+
+// jsonCodec encodes bodies as JSON, with record values in base64.
+type jsonCodec struct{}
+
+func (jsonCodec) contentType() string { return contentTypeJSON }
+
+func (jsonCodec) marshal(v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func (jsonCodec) unmarshal(b []byte, v any) error { return json.Unmarshal(b, v) }
+
+// msgpackCodec encodes bodies as msgpack, with record values as raw binary.
+// Field names are the same as in JSON.
+type msgpackCodec struct{}
+
+func (msgpackCodec) contentType() string { return contentTypeMsgpack }
+
+func (msgpackCodec) marshal(v any) ([]byte, error) {
+	var b bytes.Buffer
+	enc := msgpack.NewEncoder(&b)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (msgpackCodec) unmarshal(b []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(b))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// protobufCodec encodes bodies as the equivalent api/v1 protobuf messages.
+type protobufCodec struct{}
+
+func (protobufCodec) contentType() string { return contentTypeProtobuf }
+
+func (protobufCodec) marshal(v any) ([]byte, error) {
+	var m proto.Message
+	switch v := v.(type) {
+	case ProduceResponse:
+		m = &api.ProduceResponse{Offset: v.Offset}
+	case ConsumeResponse:
+		m = &api.ConsumeResponse{Record: v.Record.toProto()}
+	case ConsumeRecordsResponse:
+		batch := &api.RecordBatch{NextOffset: v.NextOffset}
+		for _, rec := range v.Records {
+			batch.Records = append(batch.Records, rec.toProto())
+		}
+		m = batch
+	default:
+		return nil, errNoProtobuf
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) unmarshal(b []byte, v any) error {
+	switch v := v.(type) {
+	case *ProduceRequest:
+		var req api.ProduceRequest
+		if err := proto.Unmarshal(b, &req); err != nil {
+			return err
+		}
+		v.Record = Record{
+			Value:   req.GetRecord().GetValue(),
+			Key:     req.GetRecord().GetKey(),
+			Headers: req.GetRecord().GetHeaders(),
+		}
+	case *ConsumeRequest:
+		var req api.ConsumeRequest
+		if err := proto.Unmarshal(b, &req); err != nil {
+			return err
+		}
+		v.Offset = req.Offset
+	default:
+		return errNoProtobuf
+	}
+	return nil
+}
+
+// toProto converts the record into its api/v1 equivalent.
+func (r Record) toProto() *api.Record {
+	return &api.Record{Value: r.Value, Offset: r.Offset, Key: r.Key, Headers: r.Headers}
+}
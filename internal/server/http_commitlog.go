@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+
+	api "github.com/glauco/proglog/api/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TruncatableLog is a CommitLog whose oldest records can be removed, like log.Log and
+// log.DistributedLog.
+type TruncatableLog interface {
+	CommitLog
+	// Truncate removes the segments holding only records up to lowest.
+	Truncate(lowest uint64) error
+}
+
+// commitRecordLog serves a CommitLog to the HTTP server, which works with Records.
+type commitRecordLog struct {
+	log TruncatableLog
+}
+
+var _ RecordLog = (*commitRecordLog)(nil)
+
+// NewCommitRecordLog returns a RecordLog storing the HTTP server's records in the commit log, so
+// they're persisted and served by the gRPC server too. Writes to a log.DistributedLog go through
+// Raft like the gRPC server's, so only the leader accepts them.
+func NewCommitRecordLog(log TruncatableLog) RecordLog {
+	return &commitRecordLog{log: log}
+}
+
+// Append appends the record to the commit log.
+func (l *commitRecordLog) Append(record Record) (uint64, error) {
+	return l.log.Append(toAPIRecord(record))
+}
+
+// CompareAndAppend appends the record to the commit log if it gets the expected offset.
+func (l *commitRecordLog) CompareAndAppend(record Record, expected uint64) (uint64, error) {
+	return l.log.CompareAndAppend(toAPIRecord(record), expected)
+}
+
+// Read reads the record at the offset from the commit log.
+func (l *commitRecordLog) Read(offset uint64) (Record, error) {
+	record, err := l.log.Read(offset)
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{
+		Value:   record.Value,
+		Offset:  record.Offset,
+		Key:     record.Key,
+		Headers: record.Headers,
+	}, nil
+}
+
+// LowestOffset returns the offset of the oldest record in the commit log.
+func (l *commitRecordLog) LowestOffset() uint64 {
+	lowest, _ := l.log.LowestOffset()
+	return lowest
+}
+
+// NextOffset returns the offset the next appended record will get.
+func (l *commitRecordLog) NextOffset() uint64 {
+	highest, err := l.log.HighestOffset()
+	if err != nil {
+		return 0
+	}
+	if highest == 0 {
+		// HighestOffset reports 0 for both an empty log and a log holding a single record,
+		// so check whether the record exists; if not, the error tells where the log ends
+		var outOfRange api.ErrOffsetOutOfRange
+		if _, err := l.log.Read(0); errors.As(err, &outOfRange) {
+			return outOfRange.Next
+		}
+	}
+	return highest + 1
+}
+
+// Truncate removes the segments holding only records before the offset, through Raft for a
+// distributed log so every node truncates the same records, and returns how many records were
+// removed. Records sharing a segment with later ones are kept until the whole segment can be
+// removed. A distributed log fails on servers that aren't the leader with an error wrapping
+// raft.ErrNotLeader.
+func (l *commitRecordLog) Truncate(before uint64) (int, error) {
+	lowest := l.LowestOffset()
+	if before <= lowest {
+		return 0, nil
+	}
+	if err := l.log.Truncate(before - 1); err != nil {
+		return 0, err
+	}
+	return int(l.LowestOffset() - lowest), nil
+}
+
+// Size returns the number of records in the commit log and the size of their values.
+// It reads every record, so it's meant for occasional admin requests.
+func (l *commitRecordLog) Size() (records int, bytes int) {
+	for off := l.LowestOffset(); off < l.NextOffset(); off++ {
+		record, err := l.log.Read(off)
+		if err != nil {
+			break
+		}
+		records++
+		bytes += len(record.Value)
+	}
+	return records, bytes
+}
+
+// toAPIRecord converts a record received by the HTTP server to the log's representation.
+func toAPIRecord(record Record) *api.Record {
+	return &api.Record{
+		Value:   record.Value,
+		Key:     record.Key,
+		Headers: record.Headers,
+		// Stamp the record like the gRPC server does, so replicas can tell how far behind they are
+		AppendTime: timestamppb.Now(),
+	}
+}
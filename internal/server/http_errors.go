@@ -0,0 +1,62 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// httpStatus returns the HTTP status code describing err, so clients can tell requests they
+// should fix from failures of the server. Offsets missing from the log are 404 Not Found and
+// failed compare-and-appends 412 Precondition Failed. Writes refused by a server that isn't the
+// cluster's leader are 421 Misdirected Request, for clients to retry them on the leader; errors
+// carrying a gRPC status, like the
+// Authorizer's, are mapped from their code, and anything else is 500 Internal Server Error.
+func httpStatus(err error) int {
+	if isOffsetNotFound(err) {
+		return http.StatusNotFound
+	}
+	if errors.As(err, new(api.ErrOffsetMismatch)) {
+		return http.StatusPreconditionFailed
+	}
+	if errors.Is(err, raft.ErrNotLeader) {
+		return http.StatusMisdirectedRequest
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	switch st.Code() {
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound, codes.OutOfRange:
+		return http.StatusNotFound
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// isOffsetNotFound reports whether err tells there's no record at the offset read, as returned by
+// the in-memory Log and by logs adapted with NewCommitRecordLog alike.
+func isOffsetNotFound(err error) bool {
+	return errors.Is(err, ErrOffsetNotFound) || errors.As(err, new(api.ErrOffsetOutOfRange))
+}
+
+// httpError responds with err's message and the status code describing it.
+func httpError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), httpStatus(err))
+}
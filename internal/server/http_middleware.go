@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The HTTP middleware in this file is meant to be passed to NewHttpServer in this order:
+//
+//	WithMiddleware(RequestID, RequestLogger(logger), CORS(cors), auth.Middleware, RateLimit(quotas))
+//
+// so every request gets an ID before it's logged, CORS preflights are answered
+// before authentication, since browsers send them without credentials, and quotas
+// are tracked per authenticated subject.
+
+// requestIDHeader carries the ID identifying a request across services.
+const requestIDHeader = "X-Request-ID"
+
+type requestIDContextKey struct{}
+
+// RequestID is middleware propagating the X-Request-ID header of incoming requests, or
+// generating a random ID for requests without one. The ID is echoed in the response and
+// available to later middleware and handlers through RequestIDFromContext.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDContextKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestIDFromContext returns the ID of the request the context belongs to, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// RequestLogger returns middleware logging every request once it's handled, with its
+// method, path, status code, response size, duration and request ID.
+func RequestLogger(logger *slog.Logger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			logger.InfoContext(r.Context(), "handled http request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", rec.status),
+				slog.Int("bytes", rec.bytes),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("request_id", RequestIDFromContext(r.Context())),
+			)
+		})
+	}
+}
+
+// statusRecorder wraps a ResponseWriter to record the status code and size of the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// WriteHeader records the status code and writes it.
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the number of bytes written and writes them.
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Hijack lets WebSocket upgrades take over the connection through the recorder.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Unwrap returns the wrapped ResponseWriter, for http.ResponseController.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// CORSConfig configures the CORS headers allowing browser clients served from other origins.
+type CORSConfig struct {
+	AllowedOrigins []string      // Origins allowed to call the API; "*" allows any origin.
+	AllowedHeaders []string      // Request headers allowed besides the CORS-safelisted ones.
+	MaxAge         time.Duration // How long browsers may cache preflight responses; 0 leaves it to the browser.
+}
+
+// corsMethods are the methods used by the HTTP API.
+var corsMethods = []string{http.MethodGet, http.MethodPost, http.MethodDelete}
+
+// CORS returns middleware adding CORS headers to requests from allowed origins and answering
+// their preflight requests. Requests from other origins are served without CORS headers,
+// which makes browsers block them.
+func CORS(c CORSConfig) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || !(slices.Contains(c.AllowedOrigins, "*") || slices.Contains(c.AllowedOrigins, origin)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h := w.Header()
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Add("Vary", "Origin")
+			h.Set("Access-Control-Expose-Headers", requestIDHeader)
+
+			// Answer preflight requests without passing them on
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Set("Access-Control-Allow-Methods", strings.Join(corsMethods, ", "))
+				h.Set("Access-Control-Allow-Headers", strings.Join(append([]string{
+					"Authorization", "Content-Type", "X-API-Key", requestIDHeader,
+				}, c.AllowedHeaders...), ", "))
+				if c.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"crypto/tls"
+	"log/slog"
+
+	"github.com/gorilla/mux"
+)
+
+// HTTPOption configures the HTTP server built by NewHttpServer.
+type HTTPOption func(*HTTPConfig)
+
+// WithHTTPLog sets the log the HTTP server stores records in.
+func WithHTTPLog(log RecordLog) HTTPOption {
+	return func(c *HTTPConfig) {
+		c.Log = log
+	}
+}
+
+// WithHTTPTLS secures the HTTP server with the given TLS configuration.
+// Verified client certificates authenticate requests when HTTPAuth is used.
+func WithHTTPTLS(tlsConfig *tls.Config) HTTPOption {
+	return func(c *HTTPConfig) {
+		c.TLSConfig = tlsConfig
+	}
+}
+
+// WithHTTPLogger sets the logger that receives the HTTP server's errors.
+func WithHTTPLogger(logger *slog.Logger) HTTPOption {
+	return func(c *HTTPConfig) {
+		c.Logger = logger
+	}
+}
+
+// WithHTTPTimeouts sets the timeouts of the HTTP server's connections.
+func WithHTTPTimeouts(timeouts HTTPTimeouts) HTTPOption {
+	return func(c *HTTPConfig) {
+		c.Timeouts = timeouts
+	}
+}
+
+// WithMaxBodyBytes rejects request bodies larger than the given size in bytes.
+func WithMaxBodyBytes(size int64) HTTPOption {
+	return func(c *HTTPConfig) {
+		c.MaxBodyBytes = size
+	}
+}
+
+// WithMiddleware wraps every route of the HTTP server with the middleware, after any added before.
+func WithMiddleware(mws ...mux.MiddlewareFunc) HTTPOption {
+	return func(c *HTTPConfig) {
+		c.Middleware = append(c.Middleware, mws...)
+	}
+}
+
+// WithReadinessCheck adds a check run by /readyz, reported under the given name.
+func WithReadinessCheck(name string, check ReadinessCheck) HTTPOption {
+	return func(c *HTTPConfig) {
+		if c.ReadinessChecks == nil {
+			c.ReadinessChecks = make(map[string]ReadinessCheck)
+		}
+		c.ReadinessChecks[name] = check
+	}
+}
+
+// WithAllowedOrigins allows web pages of the origins, e.g. https://app.example.com, or "*" for any,
+// to open WebSockets besides the server's own pages.
+func WithAllowedOrigins(origins ...string) HTTPOption {
+	return func(c *HTTPConfig) {
+		c.AllowedOrigins = append(c.AllowedOrigins, origins...)
+	}
+}
@@ -2,20 +2,50 @@ package server
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/auth"
+	"github.com/glauco/proglog/internal/config"
+	"github.com/glauco/proglog/internal/log"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/raft"
 	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
 )
 
 var (
 	write = []byte("hello world")
 )
 
+// newHTTPHandler builds an HTTP server with the given options and returns its handler.
+func newHTTPHandler(t *testing.T, opts ...HTTPOption) http.Handler {
+	t.Helper()
+	srv, err := NewHttpServer(&HTTPConfig{}, opts...)
+	require.NoError(t, err)
+	return srv.Handler
+}
+
 func TestHandleProduce(t *testing.T) {
-	srv := newHttpServer()
+	srv := newHttpServer(NewLog())
 
 	// Create a sample record to produce
 	reqBody := ProduceRequest{
@@ -41,7 +71,7 @@ func TestHandleProduce(t *testing.T) {
 }
 
 func TestHandleConsume(t *testing.T) {
-	srv := newHttpServer()
+	srv := newHttpServer(NewLog())
 
 	// First, produce a record to consume later
 	reqBody := ProduceRequest{
@@ -75,7 +105,7 @@ func TestHandleConsume(t *testing.T) {
 }
 
 func TestHandleConsumeNotFound(t *testing.T) {
-	srv := newHttpServer()
+	srv := newHttpServer(NewLog())
 
 	// Try to consume a record that doesn't exist
 	consumeReq := ConsumeRequest{Offset: 999}
@@ -90,5 +120,991 @@ func TestHandleConsumeNotFound(t *testing.T) {
 	res := w.Result()
 	defer res.Body.Close()
 
-	require.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+// TestHTTPRoutes verifies that records can be produced and consumed through the RESTful routes.
+func TestHTTPRoutes(t *testing.T) {
+	handler := newHTTPHandler(t)
+
+	// Produce a record with POST /records
+	body, err := json.Marshal(ProduceRequest{Record: Record{Value: write}})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/records", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Consume it with GET /records/{offset}, without a body
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/records/0", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var consumeRes ConsumeResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&consumeRes))
+	require.Equal(t, write, consumeRes.Record.Value)
+
+	// Offsets that aren't numbers are rejected
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/records/first", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Offsets without a record are not found
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/records/1", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	// GET /offsets reports the range of offsets in the log
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/offsets", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var offsetsRes OffsetsResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&offsetsRes))
+	require.Equal(t, OffsetsResponse{LowestOffset: 0, NextOffset: 1}, offsetsRes)
+}
+
+// TestHTTPConsumeQuery verifies that batches of records can be consumed with query parameters,
+// and that the deprecated JSON body form still works on GET /records.
+func TestHTTPConsumeQuery(t *testing.T) {
+	handler := newHTTPHandler(t)
+
+	// Produce a few records
+	for i := 0; i < 3; i++ {
+		body, err := json.Marshal(ProduceRequest{Record: Record{Value: write}})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/records", bytes.NewReader(body)))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// Consume at most two records starting at offset 1...
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/records?offset=1&max=2", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var res ConsumeRecordsResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	require.Len(t, res.Records, 2)
+	require.Equal(t, uint64(1), res.Records[0].Offset)
+	require.Equal(t, uint64(2), res.Records[1].Offset)
+
+	// ...and everything up to the end of the log when max is larger than what's left
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/records?offset=0", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	require.Len(t, res.Records, 3)
+
+	// Invalid limits are rejected
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/records?offset=0&max=0", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Pages can't start past the end of the log
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/records?offset=4", nil))
+	require.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+
+	// The deprecated body form returns a single record
+	body, err := json.Marshal(ConsumeRequest{Offset: 2})
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/records", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+	var consumeRes ConsumeResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&consumeRes))
+	require.Equal(t, uint64(2), consumeRes.Record.Offset)
+}
+
+// TestWebSocket verifies that records can be produced and consumed over the WebSocket endpoint.
+func TestWebSocket(t *testing.T) {
+	srv := httptest.NewServer(newHTTPHandler(t))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Produce a record and receive its offset
+	require.NoError(t, conn.WriteJSON(WebSocketFrame{Type: "produce", Record: &Record{Value: write}}))
+	var frame WebSocketFrame
+	require.NoError(t, conn.ReadJSON(&frame))
+	require.Equal(t, WebSocketFrame{Type: "produced", Offset: 0}, frame)
+
+	// Consume from the start of the log, receiving the existing record...
+	require.NoError(t, conn.WriteJSON(WebSocketFrame{Type: "consume", Offset: 0}))
+	require.NoError(t, conn.ReadJSON(&frame))
+	require.Equal(t, "record", frame.Type)
+	require.Equal(t, write, frame.Record.Value)
+
+	// ...and records produced afterwards, interleaved with the produce acknowledgements
+	require.NoError(t, conn.WriteJSON(WebSocketFrame{Type: "produce", Record: &Record{Value: []byte("again")}}))
+	types := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		frame = WebSocketFrame{}
+		require.NoError(t, conn.ReadJSON(&frame))
+		types[frame.Type] = true
+		if frame.Type == "record" {
+			require.Equal(t, uint64(1), frame.Record.Offset)
+		}
+	}
+	require.Equal(t, map[string]bool{"produced": true, "record": true}, types)
+
+	// Unknown frames get an error frame back
+	require.NoError(t, conn.WriteJSON(WebSocketFrame{Type: "bogus"}))
+	frame = WebSocketFrame{}
+	require.NoError(t, conn.ReadJSON(&frame))
+	require.Equal(t, "error", frame.Type)
+}
+
+// TestWebSocketOrigin verifies that only pages of the server's own origin, or of the allowed
+// ones, may open WebSockets, while clients that aren't browsers send no origin.
+func TestWebSocketOrigin(t *testing.T) {
+	srv := httptest.NewServer(newHTTPHandler(t, WithAllowedOrigins("https://app.example.com")))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	for origin, allowed := range map[string]bool{
+		"":                        true,
+		srv.URL:                   true,
+		"https://app.example.com": true,
+		"https://evil.example":    false,
+	} {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, res, err := websocket.DefaultDialer.Dial(url, header)
+		if !allowed {
+			require.ErrorIs(t, err, websocket.ErrBadHandshake, origin)
+			require.Equal(t, http.StatusForbidden, res.StatusCode)
+			continue
+		}
+		require.NoError(t, err, origin)
+		conn.Close()
+	}
+}
+
+// TestWebSocketTruncated verifies that consuming records truncated away ends the stream with an
+// error and a close frame, rather than waiting for them forever.
+func TestWebSocketTruncated(t *testing.T) {
+	log := NewLog()
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(Record{Value: write})
+		require.NoError(t, err)
+	}
+	_, err := log.Truncate(2)
+	require.NoError(t, err)
+	srv := httptest.NewServer(newHTTPHandler(t, WithHTTPLog(log)))
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteJSON(WebSocketFrame{Type: "consume", Offset: 0}))
+	var frame WebSocketFrame
+	require.NoError(t, conn.ReadJSON(&frame))
+	require.Equal(t, "error", frame.Type)
+	require.Contains(t, frame.Error, "offset 0 was truncated, the log starts at 2")
+	_, _, err = conn.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, closeStatusBase+http.StatusNotFound), "got %v", err)
+}
+
+// TestWebSocketShutdown verifies that shutting the server down closes the open WebSockets,
+// telling their clients the server is going away.
+func TestWebSocketShutdown(t *testing.T) {
+	srv, err := NewHttpServer(&HTTPConfig{})
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+l.Addr().String()+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	// The produce's acknowledgement tells the WebSocket is being served
+	require.NoError(t, conn.WriteJSON(WebSocketFrame{Type: "produce", Record: &Record{Value: write}}))
+	var frame WebSocketFrame
+	require.NoError(t, conn.ReadJSON(&frame))
+
+	require.NoError(t, srv.Shutdown(context.Background()))
+	_, _, err = conn.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "got %v", err)
+}
+
+// TestHTTPAuth verifies that HTTP requests are authenticated by client certificate, bearer token
+// or API key, and authorized with the same policy as the gRPC server.
+func TestHTTPAuth(t *testing.T) {
+	auditor := &recordingAuditor{}
+	signer, err := auth.NewTokenSigner(auth.TokenSignerConfig{Keys: [][]byte{[]byte(strings.Repeat("k", 32))}})
+	require.NoError(t, err)
+	scoped, _, _, err := signer.Issue("root", []*api.TokenScope{{Object: objectOffsets, Action: describeAction}}, time.Minute)
+	require.NoError(t, err)
+	outOfScope, outOfScopeID, _, err := signer.Issue("root", []*api.TokenScope{{Object: defaultTopic, Action: consumeAction}}, time.Minute)
+	require.NoError(t, err)
+	authn := &HTTPAuth{
+		Authorizer:   auth.New(config.ACLModelFile, config.ACLPolicyFile),
+		BearerTokens: map[string]string{"root-token": "root", "nobody-token": "nobody"},
+		APIKeys:      map[string]string{"root-key": "root"},
+		Tokens:       tokenValidator{"root-jwt": "root", "nobody-jwt": "nobody"},
+		Issuer:       signer,
+		Keys:         tokenValidator{"plg_root": "root", "plg_nobody": "nobody"},
+		Auditor:      auditor,
+	}
+	handler := newHTTPHandler(t, WithMiddleware(authn.Middleware))
+
+	for scenario, tc := range map[string]struct {
+		header, value string
+		want          int
+	}{
+		"no credentials":       {"", "", http.StatusUnauthorized},
+		"unknown bearer token": {"Authorization", "Bearer bogus", http.StatusUnauthorized},
+		"unknown API key":      {"X-API-Key", "bogus", http.StatusUnauthorized},
+		"unauthorized subject": {"Authorization", "Bearer nobody-token", http.StatusForbidden},
+		"authorized bearer":    {"Authorization", "Bearer root-token", http.StatusOK},
+		"authorized API key":   {"X-API-Key", "root-key", http.StatusOK},
+		"validated token":      {"Authorization", "Bearer root-jwt", http.StatusOK},
+		"unauthorized token":   {"Authorization", "Bearer nobody-jwt", http.StatusForbidden},
+		"validated API key":    {"X-API-Key", "plg_root", http.StatusOK},
+		"unauthorized API key": {"X-API-Key", "plg_nobody", http.StatusForbidden},
+		"API key as bearer":    {"Authorization", "Bearer plg_root", http.StatusUnauthorized},
+		"scoped token":         {"Authorization", "Bearer " + scoped, http.StatusOK},
+		"out of scope token":   {"Authorization", "Bearer " + outOfScope, http.StatusForbidden},
+		"invalid scoped token": {"Authorization", "Bearer plgt_bogus.sig", http.StatusUnauthorized},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/offsets", nil)
+			if tc.header != "" {
+				req.Header.Set(tc.header, tc.value)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			require.Equal(t, tc.want, w.Code)
+		})
+	}
+
+	// Authorization decisions are audited, unlike requests failing authentication
+	events := auditor.list()
+	require.Len(t, events, 9)
+	for _, event := range events {
+		require.Equal(t, "GET /offsets", event.Method)
+		require.Equal(t, objectOffsets, event.Object)
+		require.Equal(t, describeAction, event.Action)
+		require.Equal(t, event.Subject == "root" && event.Token != outOfScopeID, event.Allowed)
+	}
+
+	// Requests over TLS are authenticated by their client certificate. The server's listener
+	// terminates TLS, as StartTLS would serve its own certificate rather than the config's
+	srv := httptest.NewUnstartedServer(handler)
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.ServerCertFile,
+		KeyFile:  config.ServerKeyFile,
+		CAFile:   config.CAFile,
+		Server:   true,
+	})
+	require.NoError(t, err)
+	srv.Listener = tls.NewListener(srv.Listener, serverTLSConfig)
+	srv.Start()
+	defer srv.Close()
+	srv.URL = "https://" + srv.Listener.Addr().String()
+
+	for _, tc := range []struct {
+		cert, key string
+		want      int
+	}{
+		{config.RootClientCertFile, config.RootClientKeyFile, http.StatusOK},
+		{config.NobodyClientCertFile, config.NobodyClientKeyFile, http.StatusForbidden},
+	} {
+		clientTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+			CertFile:      tc.cert,
+			KeyFile:       tc.key,
+			CAFile:        config.CAFile,
+			ServerAddress: "127.0.0.1",
+		})
+		require.NoError(t, err)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig}}
+		res, err := client.Get(srv.URL + "/offsets")
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, tc.want, res.StatusCode)
+	}
+}
+
+// TestHTTPMiddleware verifies the request ID, logging and CORS middleware.
+func TestHTTPMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	handler := newHTTPHandler(t, WithMiddleware(
+		RequestID,
+		RequestLogger(slog.New(slog.NewJSONHandler(&logs, nil))),
+		CORS(CORSConfig{AllowedOrigins: []string{"https://example.com"}, MaxAge: time.Minute}),
+	))
+
+	// Requests without an ID get a generated one, which is logged with the request
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/offsets", nil))
+	id := w.Header().Get("X-Request-ID")
+	require.Len(t, id, 32)
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &line))
+	require.Equal(t, id, line["request_id"])
+	require.Equal(t, "/offsets", line["path"])
+	require.Equal(t, float64(http.StatusOK), line["status"])
+
+	// Incoming request IDs are propagated
+	req := httptest.NewRequest(http.MethodGet, "/offsets", nil)
+	req.Header.Set("X-Request-ID", "abc")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, "abc", w.Header().Get("X-Request-ID"))
+
+	// Preflights from allowed origins are answered...
+	req = httptest.NewRequest(http.MethodOptions, "/records", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	require.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), "POST")
+	require.Equal(t, "60", w.Header().Get("Access-Control-Max-Age"))
+
+	// ...while requests from other origins get no CORS headers
+	req = httptest.NewRequest(http.MethodGet, "/offsets", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+// TestHealth verifies the liveness and readiness endpoints.
+func TestHealth(t *testing.T) {
+	// Probes don't need credentials, even when authentication is enabled
+	authn := &HTTPAuth{Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile)}
+	handler := newHTTPHandler(t, WithMiddleware(authn.Middleware))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var res ReadyResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	require.Equal(t, ReadyResponse{Status: "ready", Checks: map[string]string{"log": "ok"}}, res)
+
+	// The server isn't ready while any check fails
+	srv := newHttpServer(NewLog())
+	srv.checks = append(srv.checks,
+		readinessCheck{"data", DirWritable(t.TempDir())},
+		readinessCheck{"missing", DirWritable(filepath.Join(t.TempDir(), "missing"))},
+	)
+	w = httptest.NewRecorder()
+	srv.handleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	require.Equal(t, "unavailable", res.Status)
+	require.Equal(t, "ok", res.Checks["data"])
+	require.NotEqual(t, "ok", res.Checks["missing"])
+}
+
+// TestOpenAPI verifies that the OpenAPI document describes every route of the HTTP server,
+// and that it's served along with Swagger UI.
+func TestOpenAPI(t *testing.T) {
+	var spec struct {
+		OpenAPI string                    `yaml:"openapi"`
+		Paths   map[string]map[string]any `yaml:"paths"`
+	}
+	require.NoError(t, yaml.Unmarshal(openAPI, &spec))
+	require.Equal(t, "3.0.3", spec.OpenAPI)
+
+	handler := newHTTPHandler(t)
+	err := handler.(*mux.Router).Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || path == "/" {
+			// Skip the CORS preflight catch-all and the deprecated routes
+			return nil
+		}
+		methods, err := route.GetMethods()
+		require.NoError(t, err)
+		for _, method := range methods {
+			require.Contains(t, spec.Paths[path], strings.ToLower(method), "%s %s is not documented", method, path)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	for path, contentType := range map[string]string{
+		"/openapi.yaml": "application/yaml",
+		"/docs":         "text/html; charset=utf-8",
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, contentType, w.Header().Get("Content-Type"))
+	}
+}
+
+// TestHTTPContentNegotiation verifies that bodies can be sent and received as protobuf or msgpack.
+func TestHTTPContentNegotiation(t *testing.T) {
+	handler := newHTTPHandler(t)
+
+	// Produce a record with a protobuf body and get a protobuf response
+	body, err := proto.Marshal(&api.ProduceRequest{Record: &api.Record{Value: write}})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/records", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/protobuf")
+	req.Header.Set("Accept", "application/protobuf")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/protobuf", w.Header().Get("Content-Type"))
+	var produceRes api.ProduceResponse
+	require.NoError(t, proto.Unmarshal(w.Body.Bytes(), &produceRes))
+	require.Equal(t, uint64(0), produceRes.Offset)
+
+	// Consume a batch as protobuf...
+	req = httptest.NewRequest(http.MethodGet, "/records?offset=0", nil)
+	req.Header.Set("Accept", "application/x-protobuf")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var batch api.RecordBatch
+	require.NoError(t, proto.Unmarshal(w.Body.Bytes(), &batch))
+	require.Len(t, batch.Records, 1)
+	require.Equal(t, write, batch.Records[0].Value)
+
+	// ...and as msgpack, with the same field names as JSON
+	req = httptest.NewRequest(http.MethodGet, "/records/0", nil)
+	req.Header.Set("Accept", "text/html, application/msgpack;q=0.9")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/msgpack", w.Header().Get("Content-Type"))
+	var consumeRes map[string]map[string]any
+	require.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &consumeRes))
+	require.Equal(t, write, consumeRes["record"]["value"])
+
+	// Unsupported media types are rejected
+	req = httptest.NewRequest(http.MethodPost, "/records", strings.NewReader("hello world"))
+	req.Header.Set("Content-Type", "text/plain")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/records/0", nil)
+	req.Header.Set("Accept", "text/plain")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNotAcceptable, w.Code)
+
+	// Responses without a protobuf equivalent can't be sent as protobuf
+	req = httptest.NewRequest(http.MethodGet, "/offsets", nil)
+	req.Header.Set("Accept", "application/protobuf")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNotAcceptable, w.Code)
+}
+
+// TestHTTPPagination verifies that HTTP consumers can page through the log with the next_offset cursor.
+func TestHTTPPagination(t *testing.T) {
+	handler := newHTTPHandler(t)
+	for i := 0; i < 5; i++ {
+		body, err := json.Marshal(ProduceRequest{Record: Record{Value: write}})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/records", bytes.NewReader(body)))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// Page through the log two records at a time until a page comes back empty
+	var pages [][]uint64
+	next := "/records?offset=0&limit=2"
+	for {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, next, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var res ConsumeRecordsResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		require.Equal(t, fmt.Sprintf(`</records?limit=2&offset=%d>; rel="next"`, res.NextOffset), w.Header().Get("Link"))
+		if len(res.Records) == 0 {
+			require.Equal(t, uint64(5), res.NextOffset)
+			break
+		}
+		var offsets []uint64
+		for _, rec := range res.Records {
+			offsets = append(offsets, rec.Offset)
+		}
+		pages = append(pages, offsets)
+		next = fmt.Sprintf("/records?offset=%d&limit=2", res.NextOffset)
+	}
+	require.Equal(t, [][]uint64{{0, 1}, {2, 3}, {4}}, pages)
+}
+
+// TestHTTPAdmin verifies that admins can truncate and inspect the log over HTTP.
+func TestHTTPAdmin(t *testing.T) {
+	authn := &HTTPAuth{
+		Authorizer:   auth.New(config.ACLModelFile, config.ACLPolicyFile),
+		BearerTokens: map[string]string{"root-token": "root", "nobody-token": "nobody"},
+	}
+	handler := newHTTPHandler(t, WithMiddleware(authn.Middleware))
+	do := func(method, target, token string) *httptest.ResponseRecorder {
+		var body io.Reader
+		if method == http.MethodPost {
+			b, err := json.Marshal(ProduceRequest{Record: Record{Value: write}})
+			require.NoError(t, err)
+			body = bytes.NewReader(b)
+		}
+		req := httptest.NewRequest(method, target, body)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusOK, do(http.MethodPost, "/records", "root-token").Code)
+	}
+
+	// Subjects without admin permissions can't use the admin endpoints
+	for _, target := range []string{"/segments", "/stats"} {
+		require.Equal(t, http.StatusForbidden, do(http.MethodGet, target, "nobody-token").Code)
+	}
+	require.Equal(t, http.StatusForbidden, do(http.MethodDelete, "/records?before_offset=2", "nobody-token").Code)
+
+	// Truncating removes the records before the offset
+	w := do(http.MethodDelete, "/records?before_offset=2", "root-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var truncateRes TruncateResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&truncateRes))
+	require.Equal(t, TruncateResponse{Removed: 2, LowestOffset: 2}, truncateRes)
+	require.Equal(t, http.StatusNotFound, do(http.MethodGet, "/records/1", "root-token").Code)
+	require.Equal(t, http.StatusRequestedRangeNotSatisfiable, do(http.MethodGet, "/records?offset=1", "root-token").Code)
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/records/2", "root-token").Code)
+	require.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/records", "root-token").Code)
+
+	// The segments and stats describe what's left
+	w = do(http.MethodGet, "/segments", "root-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var segmentsRes SegmentsResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&segmentsRes))
+	require.Equal(t, []SegmentInfo{{BaseOffset: 2, NextOffset: 3, Bytes: len(write)}}, segmentsRes.Segments)
+
+	w = do(http.MethodGet, "/stats", "root-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var statsRes StatsResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&statsRes))
+	require.Equal(t, StatsResponse{LowestOffset: 2, NextOffset: 3, Records: 1, Bytes: len(write)}, statsRes)
+}
+
+// TestHTTPStatus verifies that errors map to the HTTP status codes clients can react to.
+func TestHTTPStatus(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		err  error
+		want int
+	}{
+		"missing offset":         {ErrOffsetNotFound, http.StatusNotFound},
+		"offset out of range":    {api.ErrOffsetOutOfRange{Offset: 42}, http.StatusNotFound},
+		"invalid request":        {api.NewError(codes.InvalidArgument, api.ReasonInvalidRequest, "bad", nil), http.StatusBadRequest},
+		"unauthenticated":        {status.Error(codes.Unauthenticated, "who are you"), http.StatusUnauthorized},
+		"permission denied":      {api.NewError(codes.PermissionDenied, api.ReasonUnauthorized, "no", nil), http.StatusForbidden},
+		"throttled":              {status.Error(codes.ResourceExhausted, "slow down"), http.StatusTooManyRequests},
+		"unexpected error":       {io.ErrUnexpectedEOF, http.StatusInternalServerError},
+		"wrapped missing offset": {fmt.Errorf("read: %w", ErrOffsetNotFound), http.StatusNotFound},
+		"offset mismatch":        {api.ErrOffsetMismatch{Expected: 1, Next: 2}, http.StatusPreconditionFailed},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			require.Equal(t, tc.want, httpStatus(tc.err))
+		})
+	}
+}
+
+// TestHTTPRateLimit verifies that HTTP clients exceeding their quota get 429 Too Many Requests.
+func TestHTTPRateLimit(t *testing.T) {
+	handler := newHTTPHandler(t, WithMiddleware(RateLimit(QuotaConfig{ReceiveBytesPerSecond: 64})))
+	body, err := json.Marshal(ProduceRequest{Record: Record{Value: write}})
+	require.NoError(t, err)
+	produce := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/records", bytes.NewReader(body))
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// The first produce fits in the burst, the second exceeds the quota
+	require.Equal(t, http.StatusOK, produce("10.0.0.1:1234").Code)
+	w := produce("10.0.0.1:1235")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// Other clients have their own quota, and probes aren't limited
+	require.Equal(t, http.StatusOK, produce("10.0.0.2:1234").Code)
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.RemoteAddr = "10.0.0.1:1236"
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestHTTPCompression verifies that request bodies may be sent gzipped, and that large
+// responses are gzipped for clients accepting it.
+func TestHTTPCompression(t *testing.T) {
+	handler := newHTTPHandler(t)
+	body, err := json.Marshal(ProduceRequest{Record: Record{Value: write}})
+	require.NoError(t, err)
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	_, err = zw.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	// Gzipped produce requests are decompressed...
+	for i := 0; i < 50; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/records", bytes.NewReader(gzipped.Bytes()))
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		// ...and their small responses aren't worth compressing
+		require.Empty(t, w.Header().Get("Content-Encoding"))
+	}
+
+	// Other encodings are rejected
+	req := httptest.NewRequest(http.MethodPost, "/records", bytes.NewReader(body))
+	req.Header.Set("Content-Encoding", "br")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	// Pages of records are gzipped for clients accepting it
+	req = httptest.NewRequest(http.MethodGet, "/records?offset=0", nil)
+	req.Header.Set("Accept-Encoding", "deflate, gzip")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	zr, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	var res ConsumeRecordsResponse
+	require.NoError(t, json.NewDecoder(zr).Decode(&res))
+	require.Len(t, res.Records, 50)
+
+	// ...but not for clients refusing it
+	req = httptest.NewRequest(http.MethodGet, "/records?offset=0", nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Empty(t, w.Header().Get("Content-Encoding"))
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	require.Len(t, res.Records, 50)
+}
+
+// TestHTTPProduceRaw verifies that request bodies can be produced as is, with a key and headers.
+func TestHTTPProduceRaw(t *testing.T) {
+	handler := newHTTPHandler(t)
+	value := []byte{0x00, 0xff, 0x10, 0x80}
+	req := httptest.NewRequest(http.MethodPost, "/records/raw", bytes.NewReader(value))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Record-Key", "user-42")
+	req.Header.Set("X-Record-Header-Trace-ID", "abc")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var produceRes ProduceResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&produceRes))
+
+	// The record holds the body's bytes, the key and the headers
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/records/%d", produceRes.Offset), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var consumeRes ConsumeResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&consumeRes))
+	require.Equal(t, Record{
+		Value:   value,
+		Offset:  produceRes.Offset,
+		Key:     []byte("user-42"),
+		Headers: map[string]string{"trace-id": "abc"},
+	}, consumeRes.Record)
+}
+
+// TestHTTPLongPoll verifies that consumes with a wait are held until their record is produced,
+// whether the records are kept in memory or in a commit log.
+func TestHTTPLongPoll(t *testing.T) {
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+	for name, records := range map[string]RecordLog{"memory": NewLog(), "commit log": NewCommitRecordLog(clog)} {
+		t.Run(name, func(t *testing.T) {
+			testHTTPLongPoll(t, newHTTPHandler(t, WithHTTPLog(records)))
+		})
+	}
+}
+
+func testHTTPLongPoll(t *testing.T, handler http.Handler) {
+	body, err := json.Marshal(ProduceRequest{Record: Record{Value: write}})
+	require.NoError(t, err)
+
+	// Nothing is produced during the wait, so the request expires without content
+	start := time.Now()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/records/0?wait=50ms", nil))
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// A record produced during the wait is returned as soon as it's appended
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/records", bytes.NewReader(body)))
+	}()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/records/0?wait=10s", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var consumeRes ConsumeResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&consumeRes))
+	require.Equal(t, write, consumeRes.Record.Value)
+
+	// Waits must be valid durations of at most a minute
+	for _, wait := range []string{"soon", "-1s", "2m"} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/records/1?wait="+wait, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	}
+}
+
+// TestHTTPConfig verifies that the HTTP server is built from its config and options.
+func TestHTTPConfig(t *testing.T) {
+	// Invalid settings are rejected
+	_, err := NewHttpServer(&HTTPConfig{}, WithMaxBodyBytes(-1))
+	require.Error(t, err)
+	_, err = NewHttpServer(&HTTPConfig{}, WithHTTPTimeouts(HTTPTimeouts{Write: -time.Second}))
+	require.Error(t, err)
+
+	log := NewLog()
+	srv, err := NewHttpServer(&HTTPConfig{Addr: ":9090"},
+		WithHTTPLog(log),
+		WithHTTPTimeouts(HTTPTimeouts{ReadHeader: time.Second, Idle: time.Minute}),
+		WithMaxBodyBytes(64),
+		WithReadinessCheck("disk", DirWritable(t.TempDir())),
+	)
+	require.NoError(t, err)
+	require.Equal(t, ":9090", srv.Addr)
+	require.Equal(t, time.Second, srv.ReadHeaderTimeout)
+	require.Equal(t, time.Minute, srv.IdleTimeout)
+
+	// Records are stored in the given log
+	body, err := json.Marshal(ProduceRequest{Record: Record{Value: write}})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/records", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, uint64(1), log.NextOffset())
+
+	// Bodies over the limit are rejected
+	w = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/records/raw", bytes.NewReader(make([]byte, 65))))
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// Readiness checks are reported by /readyz
+	w = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var res ReadyResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	require.Equal(t, map[string]string{"log": "ok", "disk": "ok"}, res.Checks)
+}
+
+// TestHTTPCloudEvents verifies that CloudEvents are stored as records in binary and structured mode.
+func TestHTTPCloudEvents(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		contentType string
+		headers     map[string]string
+		body        string
+		want        Record
+		wantStatus  int
+	}{
+		"binary mode": {
+			contentType: "text/plain",
+			headers: map[string]string{
+				"ce-specversion":  "1.0",
+				"ce-id":           "1",
+				"ce-source":       "/sensors/42",
+				"ce-type":         "com.example.reading",
+				"ce-partitionkey": "sensor-42",
+			},
+			body: "21.5",
+			want: Record{
+				Value: []byte("21.5"),
+				Key:   []byte("sensor-42"),
+				Headers: map[string]string{
+					"ce_specversion":  "1.0",
+					"ce_id":           "1",
+					"ce_source":       "/sensors/42",
+					"ce_type":         "com.example.reading",
+					"ce_partitionkey": "sensor-42",
+					"content-type":    "text/plain",
+				},
+			},
+			wantStatus: http.StatusOK,
+		},
+		"structured mode with json data": {
+			contentType: "application/cloudevents+json",
+			body:        `{"specversion":"1.0","id":"2","source":"/orders","type":"com.example.order","sequence":7,"data":{"total":42}}`,
+			want: Record{
+				Value: []byte(`{"total":42}`),
+				Headers: map[string]string{
+					"ce_specversion": "1.0",
+					"ce_id":          "2",
+					"ce_source":      "/orders",
+					"ce_type":        "com.example.order",
+					"ce_sequence":    "7",
+				},
+			},
+			wantStatus: http.StatusOK,
+		},
+		"structured mode with binary data": {
+			contentType: "application/cloudevents+json; charset=utf-8",
+			body:        `{"specversion":"1.0","id":"3","source":"/images","type":"com.example.image","datacontenttype":"image/png","data_base64":"AP8Q"}`,
+			want: Record{
+				Value: []byte{0x00, 0xff, 0x10},
+				Headers: map[string]string{
+					"ce_specversion": "1.0",
+					"ce_id":          "3",
+					"ce_source":      "/images",
+					"ce_type":        "com.example.image",
+					"content-type":   "image/png",
+				},
+			},
+			wantStatus: http.StatusOK,
+		},
+		"missing attribute": {
+			contentType: "application/cloudevents+json",
+			body:        `{"specversion":"1.0","id":"4","source":"/orders"}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		"unsupported spec version": {
+			contentType: "application/cloudevents+json",
+			body:        `{"specversion":"0.3","id":"5","source":"/orders","type":"com.example.order"}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		"batch mode": {
+			contentType: "application/cloudevents-batch+json",
+			body:        `[]`,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			log := NewLog()
+			handler := newHTTPHandler(t, WithHTTPLog(log))
+			req := httptest.NewRequest(http.MethodPost, "/cloudevents", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			for name, value := range tc.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			require.Equal(t, tc.wantStatus, w.Code, w.Body.String())
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			rec, err := log.Read(0)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, rec)
+		})
+	}
+}
+
+// TestHTTPConditionalProduce verifies that produces with an If-Match header only append
+// if the log head is where the producer expects it.
+func TestHTTPConditionalProduce(t *testing.T) {
+	handler := newHTTPHandler(t)
+	produce := func(ifMatch string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(ProduceRequest{Record: Record{Value: write}})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/records", bytes.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// The ETag of /offsets tells producers where the head is
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/offsets", nil))
+	etag := w.Header().Get("ETag")
+	require.Equal(t, `"0"`, etag)
+
+	// A produce expecting the head succeeds and returns the new head...
+	w = produce(etag)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `"1"`, w.Header().Get("ETag"))
+
+	// ...while a produce expecting the old head fails, telling the producer where the head is now
+	w = produce(etag)
+	require.Equal(t, http.StatusPreconditionFailed, w.Code)
+	require.Equal(t, `"1"`, w.Header().Get("ETag"))
+
+	// Unquoted offsets are accepted too, and any head matches "*"
+	require.Equal(t, http.StatusOK, produce("1").Code)
+	require.Equal(t, http.StatusOK, produce("*").Code)
+	require.Equal(t, http.StatusBadRequest, produce(`"head"`).Code)
+}
+
+// TestHTTPCommitLog verifies the HTTP server stores its records in a commit log, which keeps them
+// once reopened.
+func TestHTTPCommitLog(t *testing.T) {
+	dir := t.TempDir()
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	handler := newHTTPHandler(t, WithHTTPLog(NewCommitRecordLog(clog)))
+	for i := 0; i < 2; i++ {
+		body, err := json.Marshal(ProduceRequest{Record: Record{Value: write, Key: []byte("key")}})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/records", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	require.NoError(t, clog.Close())
+
+	clog, err = log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+	records := NewCommitRecordLog(clog)
+	require.Equal(t, uint64(0), records.LowestOffset())
+	require.Equal(t, uint64(2), records.NextOffset())
+	record, err := records.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, Record{Value: write, Key: []byte("key"), Offset: 1}, record)
+	n, size := records.Size()
+	require.Equal(t, 2, n)
+	require.Equal(t, 2*len(write), size)
+}
+
+// followerLog is a commit log refusing truncations like a follower of a distributed log.
+type followerLog struct {
+	*log.Log
+}
+
+func (followerLog) Truncate(uint64) error {
+	return fmt.Errorf("truncate: %w", raft.ErrNotLeader)
+}
+
+// TestHTTPTruncateError verifies that failing to truncate the commit log is reported rather than
+// answered as if no records were removed, as 421 Misdirected Request on a follower.
+func TestHTTPTruncateError(t *testing.T) {
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+	for i := 0; i < 2; i++ {
+		_, err := clog.Append(&api.Record{Value: write})
+		require.NoError(t, err)
+	}
+	handler := newHTTPHandler(t, WithHTTPLog(NewCommitRecordLog(followerLog{clog})))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/records?before_offset=2", nil))
+	require.Equal(t, http.StatusMisdirectedRequest, w.Code)
+	require.Contains(t, w.Body.String(), raft.ErrNotLeader.Error())
 }
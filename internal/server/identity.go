@@ -0,0 +1,132 @@
+package server
+
+import (
+	"crypto/x509"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Sources of the names SubjectRules extract subjects from.
+const (
+	SubjectFromCN    = "cn"    // The certificate's Subject CommonName
+	SubjectFromDNS   = "dns"   // The certificate's DNS SANs
+	SubjectFromURI   = "uri"   // The certificate's URI SANs, e.g. SPIFFE IDs
+	SubjectFromEmail = "email" // The certificate's email SANs
+)
+
+// SubjectRule extracts the subject a client is authorized as from a name of its verified
+// certificate, rather than its CommonName, which CAs deprecate as an identity in favor of SANs.
+// E.g. uri:^spiffe://example\.org/ns/prod/sa/(.+)$ authorizes a workload by its service account.
+type SubjectRule struct {
+	// Source is the kind of name the rule extracts the subject from: cn, dns, uri or email.
+	Source string
+	// Pattern, if set, limits the rule to the names it matches, e.g. those of a trust domain; it
+	// should be anchored with ^ and $ to match them in full. Its first capture group, if it has
+	// one, is the subject; otherwise the whole name is.
+	Pattern *regexp.Regexp
+}
+
+// ParseSubjectRule parses a rule written as its source, optionally followed by a colon and its
+// pattern, e.g. dns or uri:^spiffe://example\.org/(.+)$.
+func ParseSubjectRule(s string) (SubjectRule, error) {
+	source, pattern, _ := strings.Cut(s, ":")
+	rule := SubjectRule{Source: source}
+	switch source {
+	case SubjectFromCN, SubjectFromDNS, SubjectFromURI, SubjectFromEmail:
+	default:
+		return SubjectRule{}, fmt.Errorf("subject rule %q: source must be cn, dns, uri or email", s)
+	}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return SubjectRule{}, fmt.Errorf("subject rule %q: %w", s, err)
+		}
+		rule.Pattern = re
+	}
+	return rule, nil
+}
+
+// String returns the rule as ParseSubjectRule parses it.
+func (r SubjectRule) String() string {
+	if r.Pattern == nil {
+		return r.Source
+	}
+	return r.Source + ":" + r.Pattern.String()
+}
+
+// names returns the certificate's names of the rule's source.
+func (r SubjectRule) names(cert *x509.Certificate) []string {
+	switch r.Source {
+	case SubjectFromCN:
+		return []string{cert.Subject.CommonName}
+	case SubjectFromDNS:
+		return cert.DNSNames
+	case SubjectFromURI:
+		names := make([]string, 0, len(cert.URIs))
+		for _, uri := range cert.URIs {
+			names = append(names, uri.String())
+		}
+		return names
+	case SubjectFromEmail:
+		return cert.EmailAddresses
+	}
+	return nil
+}
+
+// extract returns the subject the rule extracts from the name, or false if it doesn't match.
+func (r SubjectRule) extract(name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+	if r.Pattern == nil {
+		return name, true
+	}
+	match := r.Pattern.FindStringSubmatch(name)
+	if match == nil {
+		return "", false
+	}
+	if len(match) > 1 {
+		return match[1], match[1] != ""
+	}
+	return name, true
+}
+
+// certSubject returns the subject of a verified certificate: the one the first rule matching any
+// of its names extracts, or its CommonName without rules. Certificates no rule matches have no
+// subject, and aren't authenticated.
+func certSubject(cert *x509.Certificate, rules []SubjectRule) (string, error) {
+	if len(rules) == 0 {
+		return cert.Subject.CommonName, nil
+	}
+	for _, rule := range rules {
+		for _, name := range rule.names(cert) {
+			if subject, ok := rule.extract(name); ok {
+				return subject, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no subject rule matches the certificate of %q", cert.Subject.CommonName)
+}
+
+// SubjectRules is a flag.Value of rules, each parsed by ParseSubjectRule, set by repeating the flag.
+type SubjectRules []SubjectRule
+
+// String returns the rules, separated by spaces.
+func (r *SubjectRules) String() string {
+	rules := make([]string, 0, len(*r))
+	for _, rule := range *r {
+		rules = append(rules, rule.String())
+	}
+	return strings.Join(rules, " ")
+}
+
+// Set parses a rule and appends it to the rules.
+func (r *SubjectRules) Set(value string) error {
+	rule, err := ParseSubjectRule(value)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, rule)
+	return nil
+}
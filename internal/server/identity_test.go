@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"regexp"
+	"testing"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestCertSubject verifies that subjects are extracted from certificates by the first rule
+// matching one of their names, and by their CommonNames without rules.
+func TestCertSubject(t *testing.T) {
+	spiffe, err := url.Parse("spiffe://example.org/ns/prod/sa/billing")
+	require.NoError(t, err)
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "billing-7d9f"},
+		DNSNames:       []string{"billing.prod.svc", "billing.example.org"},
+		URIs:           []*url.URL{spiffe},
+		EmailAddresses: []string{"billing@example.org"},
+	}
+	parse := func(rules ...string) []SubjectRule {
+		var parsed SubjectRules
+		for _, rule := range rules {
+			require.NoError(t, parsed.Set(rule))
+		}
+		return parsed
+	}
+
+	for scenario, test := range map[string]struct {
+		rules []SubjectRule
+		want  string // Empty if no rule matches
+	}{
+		"no rules":             {want: "billing-7d9f"},
+		"common name":          {rules: parse("cn"), want: "billing-7d9f"},
+		"first DNS SAN":        {rules: parse("dns"), want: "billing.prod.svc"},
+		"matching DNS SAN":     {rules: parse(`dns:^.+\.example\.org$`), want: "billing.example.org"},
+		"URI SAN group":        {rules: parse(`uri:^spiffe://example\.org/ns/[^/]+/sa/([^/]+)$`), want: "billing"},
+		"email SAN":            {rules: parse("email"), want: "billing@example.org"},
+		"rules tried in order": {rules: parse(`uri:^spiffe://other\.org/(.+)$`, "email", "dns"), want: "billing@example.org"},
+		"no rule matching":     {rules: parse(`uri:^spiffe://other\.org/(.+)$`)},
+		"empty group":          {rules: []SubjectRule{{Source: SubjectFromDNS, Pattern: regexp.MustCompile(`^billing\.prod\.svc()$`)}}},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			subject, err := certSubject(cert, test.rules)
+			if test.want == "" {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.want, subject)
+		})
+	}
+
+	// Rules parse back from their strings, and bad ones fail
+	rules := SubjectRules(parse(`uri:^spiffe://example\.org/(.+)$`, "cn"))
+	require.Equal(t, `uri:^spiffe://example\.org/(.+)$ cn`, rules.String())
+	require.Error(t, rules.Set("subject"))
+	require.Error(t, rules.Set("dns:("))
+}
+
+// TestSubjectRules verifies that the gRPC server authorizes clients by the subjects its rules
+// extract, and refuses those no rule matches.
+func TestSubjectRules(t *testing.T) {
+	rootConn, nobodyConn, _, teardown := setupTestConns(t, func(c *Config) {
+		c.SubjectRules = []SubjectRule{{Source: SubjectFromCN, Pattern: regexp.MustCompile("^root$")}}
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	_, err := api.NewLogClient(rootConn).Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}})
+	require.NoError(t, err)
+	_, err = api.NewLogClient(nobodyConn).Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -3,12 +3,15 @@ package server
 import (
 	"fmt"
 	"sync"
+
+	api "github.com/glauco/proglog/api/v1"
 )
 
 // Log represents a thread-safe log that stores a sequence of records.
 // It uses a mutex to synchronize access to the records.
 type Log struct {
 	mu      sync.Mutex // Mutex to ensure thread-safe access to records
+	base    uint64     // Offset of the first record in records, which grows as the log is truncated
 	records []Record   // Slice to hold log records
 }
 
@@ -23,14 +26,30 @@ func (c *Log) Append(record Record) (uint64, error) {
 	c.mu.Lock()         // Lock to ensure thread-safe access
 	defer c.mu.Unlock() // Unlock after the function returns
 
-	// Set the offset of the new record to the current length of the records slice
-	record.Offset = uint64(len(c.records))
+	// Set the offset of the new record to the offset following the last record
+	record.Offset = c.base + uint64(len(c.records))
 	// Append the new record to the log
 	c.records = append(c.records, record)
 	// Return the offset of the appended record
 	return record.Offset, nil
 }
 
+// CompareAndAppend adds a new record to the log only if it would be stored at the expected offset.
+// If the log head has moved, it returns an api.ErrOffsetMismatch and leaves the log untouched.
+func (c *Log) CompareAndAppend(record Record, expected uint64) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Check the expected offset and append under the same lock so no other write can interleave
+	next := c.base + uint64(len(c.records))
+	if next != expected {
+		return 0, api.ErrOffsetMismatch{Expected: expected, Next: next}
+	}
+	record.Offset = next
+	c.records = append(c.records, record)
+	return record.Offset, nil
+}
+
 // Read retrieves a record from the log by its offset.
 // Returns an error if the offset is out of bounds.
 // This method is thread-safe, locking the log during the read operation.
@@ -39,19 +58,66 @@ func (c *Log) Read(offset uint64) (Record, error) {
 	defer c.mu.Unlock() // Unlock after the function returns
 
 	// Check if the offset is within the bounds of the log
-	if offset >= uint64(len(c.records)) {
+	if offset < c.base || offset >= c.base+uint64(len(c.records)) {
 		// Return an error if the offset is not found
 		return Record{}, ErrOffsetNotFound
 	}
 	// Return the record at the specified offset
-	return c.records[offset], nil
+	return c.records[offset-c.base], nil
+}
+
+// NextOffset returns the offset the next appended record will get.
+func (c *Log) NextOffset() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.base + uint64(len(c.records))
+}
+
+// LowestOffset returns the offset of the oldest record in the log, or the next offset if it's empty.
+func (c *Log) LowestOffset() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.base
+}
+
+// Truncate removes the records with offsets lower than before, up to the whole log.
+// It returns the number of records removed, and never fails.
+func (c *Log) Truncate(before uint64) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Remove at most every record in the log
+	n := uint64(len(c.records))
+	if before <= c.base {
+		return 0, nil
+	}
+	if before-c.base < n {
+		n = before - c.base
+	}
+	// Copy the remaining records so the removed ones can be garbage collected
+	c.records = append([]Record(nil), c.records[n:]...)
+	c.base += n
+	return int(n), nil
+}
+
+// Size returns the number of records in the log and the total size of their values in bytes.
+func (c *Log) Size() (records int, bytes int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range c.records {
+		bytes += len(r.Value)
+	}
+	return len(c.records), bytes
 }
 
 // Record represents a log record with a value and an offset.
 // The Value field stores the record data, and the Offset field indicates its position in the log.
+// Producers may also set a key and headers describing the record.
 type Record struct {
-	Value  []byte `json:"value"`  // The actual content of the record
-	Offset uint64 `json:"offset"` // The position of the record in the log
+	Value   []byte            `json:"value"`             // The actual content of the record
+	Offset  uint64            `json:"offset"`            // The position of the record in the log
+	Key     []byte            `json:"key,omitempty"`     // What the record is about, e.g. a user ID
+	Headers map[string]string `json:"headers,omitempty"` // Metadata about the record
 }
 
 // The error returned when a record at a given offset does not exist in the log.
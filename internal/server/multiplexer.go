@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/soheilhy/cmux"
+	"google.golang.org/grpc"
+)
+
+// Multiplexer serves several protocols on a single listener.
+// It sniffs the first bytes of every accepted connection and hands it to the
+// listener registered for the matching protocol, so the gRPC API, the HTTP
+// endpoints and any additional protocol (e.g. Raft) can share one port.
+type Multiplexer struct {
+	mux cmux.CMux // Connection multiplexer wrapping the shared listener
+}
+
+// NewMultiplexer creates a Multiplexer on top of the given listener.
+func NewMultiplexer(l net.Listener) *Multiplexer {
+	return &Multiplexer{
+		mux: cmux.New(l),
+	}
+}
+
+// Match returns a listener that receives the connections accepted by the given matchers.
+// Matchers are evaluated in registration order, so protocols with a distinctive
+// preamble must be registered through Match before calling Serve, which registers
+// the HTTP and catch-all gRPC listeners last.
+func (m *Multiplexer) Match(matchers ...cmux.Matcher) net.Listener {
+	return m.mux.Match(matchers...)
+}
+
+// Serve starts serving the gRPC and HTTP servers on the shared listener.
+// Plain HTTP/1.x requests are routed to the HTTP server; everything else, including
+// TLS connections that the gRPC server terminates itself, is routed to the gRPC server.
+// Either server may be nil if that protocol is not needed.
+// Serve blocks until one of the servers or the multiplexer stops and returns its error.
+func (m *Multiplexer) Serve(gsrv *grpc.Server, hsrv *http.Server) error {
+	errc := make(chan error, 3)
+
+	// Register the HTTP listener before the catch-all gRPC listener
+	if hsrv != nil {
+		httpL := m.mux.Match(cmux.HTTP1Fast())
+		go func() { errc <- hsrv.Serve(httpL) }()
+	}
+	if gsrv != nil {
+		grpcL := m.mux.Match(cmux.Any())
+		go func() { errc <- gsrv.Serve(grpcL) }()
+	}
+
+	// Start accepting connections on the shared listener
+	go func() { errc <- m.mux.Serve() }()
+
+	return <-errc
+}
+
+// Close stops accepting new connections on the shared listener.
+// The servers handed to Serve must be stopped by the caller.
+func (m *Multiplexer) Close() {
+	m.mux.Close()
+}
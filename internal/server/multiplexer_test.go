@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/auth"
+	"github.com/glauco/proglog/internal/config"
+	"github.com/glauco/proglog/internal/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// TestMultiplexer verifies that the gRPC API and the HTTP endpoints can be served on a single listener.
+func TestMultiplexer(t *testing.T) {
+	// Start a TCP listener shared by both servers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// Set up the gRPC server backed by a log in a temporary directory
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Remove()
+
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.ServerCertFile,
+		KeyFile:       config.ServerKeyFile,
+		CAFile:        config.CAFile,
+		ServerAddress: l.Addr().String(),
+		Server:        true,
+	})
+	require.NoError(t, err)
+	gsrv, err := NewGRPCServer(&Config{
+		CommitLog:  clog,
+		Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile),
+	}, WithTLS(serverTLSConfig))
+	require.NoError(t, err)
+	defer gsrv.Stop()
+
+	hsrv, err := NewHttpServer(&HTTPConfig{Addr: l.Addr().String()})
+	require.NoError(t, err)
+	defer hsrv.Close()
+
+	// Serve both protocols on the shared listener
+	mux := NewMultiplexer(l)
+	defer mux.Close()
+	go func() {
+		mux.Serve(gsrv, hsrv)
+	}()
+
+	// Produce a record over gRPC
+	clientTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.RootClientCertFile,
+		KeyFile:  config.RootClientKeyFile,
+		CAFile:   config.CAFile,
+	})
+	require.NoError(t, err)
+	conn, err := grpc.NewClient(
+		l.Addr().String(),
+		grpc.WithTransportCredentials(credentials.NewTLS(clientTLSConfig)),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	client := api.NewLogClient(conn)
+	produce, err := client.Produce(context.Background(), &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello grpc")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), produce.Offset)
+
+	// Produce a record over HTTP on the same port
+	body, err := json.Marshal(ProduceRequest{Record: Record{Value: []byte("hello http")}})
+	require.NoError(t, err)
+	res, err := http.Post(fmt.Sprintf("http://%s/records", l.Addr()), "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var produceRes ProduceResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&produceRes))
+	require.Equal(t, uint64(0), produceRes.Offset)
+}
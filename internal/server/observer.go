@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// tracerName names the tracer of the RPCs' spans, as OpenTelemetry names them by package.
+const tracerName = "github.com/glauco/proglog/internal/server"
+
+// observer logs and traces every RPC handled by the server and, when metrics are enabled,
+// records request counts and latencies in Prometheus collectors.
+type observer struct {
+	logger   *slog.Logger
+	tracer   trace.Tracer
+	requests *prometheus.CounterVec   // Requests handled, by method and status code
+	latency  *prometheus.HistogramVec // Request latency in seconds, by method
+}
+
+// newObserver creates an observer logging to the given logger, and tracing with the given
+// provider's tracer. If registerer is nil, no metrics are recorded.
+func newObserver(logger *slog.Logger, registerer prometheus.Registerer, provider trace.TracerProvider) (*observer, error) {
+	o := &observer{logger: logger, tracer: provider.Tracer(tracerName)}
+	if registerer == nil {
+		return o, nil
+	}
+
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proglog",
+		Subsystem: "grpc",
+		Name:      "requests_total",
+		Help:      "Number of RPCs handled by the server, by method and status code.",
+	}, []string{"method", "code"})
+	if err := register(registerer, &requests); err != nil {
+		return nil, err
+	}
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "proglog",
+		Subsystem: "grpc",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of the RPCs handled by the server, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+	if err := register(registerer, &latency); err != nil {
+		return nil, err
+	}
+	o.requests, o.latency = requests, latency
+	return o, nil
+}
+
+// register registers the collector, reusing the collector already registered under the
+// same name so that several servers can share one registry.
+func register[C prometheus.Collector](registerer prometheus.Registerer, c *C) error {
+	err := registerer.Register(*c)
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		*c = are.ExistingCollector.(C)
+		return nil
+	}
+	return err
+}
+
+// observe records the outcome of an RPC.
+func (o *observer) observe(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	elapsed := time.Since(start)
+	if o.requests != nil {
+		o.requests.WithLabelValues(method, code.String()).Inc()
+		o.latency.WithLabelValues(method).Observe(elapsed.Seconds())
+	}
+	o.logger.DebugContext(ctx, "handled rpc",
+		slog.String("method", method),
+		slog.String("code", code.String()),
+		slog.Duration("duration", elapsed),
+	)
+}
+
+// unaryInterceptor observes unary RPCs.
+func (o *observer) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		ctx, span := o.startSpan(ctx, info.FullMethod)
+		resp, err := handler(ctx, req)
+		endSpan(span, err)
+		o.observe(ctx, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// streamInterceptor observes streaming RPCs, from the moment the stream opens until it ends.
+func (o *observer) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx, span := o.startSpan(ss.Context(), info.FullMethod)
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = ctx
+		err := handler(srv, wrapped)
+		endSpan(span, err)
+		o.observe(ctx, info.FullMethod, start, err)
+		return err
+	}
+}
+
+// startSpan starts the server span of the RPC, continuing the trace of its W3C Trace Context
+// metadata, if any, e.g. the client's.
+func (o *observer) startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ctx = propagation.TraceContext{}.Extract(ctx, metadataCarrier(md))
+	}
+	service, name, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	return o.tracer.Start(ctx, service+"/"+name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "grpc"),
+			attribute.String("rpc.service", service),
+			attribute.String("rpc.method", name),
+		),
+	)
+}
+
+// endSpan ends the RPC's span with its status code, marking it failed on server errors, as
+// OpenTelemetry's conventions for gRPC do; client errors, e.g. NotFound, don't fail the server.
+func endSpan(span trace.Span, err error) {
+	code := status.Code(err)
+	span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(code)))
+	switch code {
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal, codes.Unavailable, codes.DataLoss:
+		span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+	span.End()
+}
+
+// metadataCarrier carries W3C Trace Context in gRPC metadata.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+// Option configures the gRPC server built by NewGRPCServer.
+type Option func(*Config)
+
+// WithLogger sets the logger that receives the server's logs.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Config) {
+		c.Logger = logger
+	}
+}
+
+// WithAuthorizer sets the authorizer that checks every request.
+func WithAuthorizer(authorizer Authorizer) Option {
+	return func(c *Config) {
+		c.Authorizer = authorizer
+	}
+}
+
+// WithTLS secures the server with the given TLS configuration.
+// Client certificates are required to authenticate requests.
+func WithTLS(tlsConfig *tls.Config) Option {
+	return func(c *Config) {
+		c.TLSConfig = tlsConfig
+	}
+}
+
+// Insecure accepts clients without certificates, e.g. over plaintext connections when the
+// server isn't secured WithTLS, as the AnonymousSubject. Unless an authorizer is set, every client
+// may do anything, so it's only fit for local development.
+func Insecure() Option {
+	return func(c *Config) {
+		c.Insecure = true
+	}
+}
+
+// WithTokenValidator authenticates clients without certificates by their bearer tokens, e.g. JWTs,
+// validated by the validator.
+func WithTokenValidator(validator TokenValidator) Option {
+	return func(c *Config) {
+		c.TokenValidator = validator
+	}
+}
+
+// WithTokenIssuer serves CreateToken, minting scoped tokens with the issuer, e.g. an
+// auth.TokenSigner, and authenticates clients by the tokens it minted.
+func WithTokenIssuer(issuer TokenIssuer) Option {
+	return func(c *Config) {
+		c.TokenIssuer = issuer
+	}
+}
+
+// WithSubjectRules authorizes clients by the subjects the rules extract from their certificates'
+// SANs, e.g. their SPIFFE IDs, rather than by their CommonNames.
+func WithSubjectRules(rules ...SubjectRule) Option {
+	return func(c *Config) {
+		c.SubjectRules = rules
+	}
+}
+
+// WithAuditor records every authorization decision of the server with the auditor.
+func WithAuditor(auditor Auditor) Option {
+	return func(c *Config) {
+		c.Auditor = auditor
+	}
+}
+
+// WithMetrics registers the server's RPC metrics with the given Prometheus registerer.
+func WithMetrics(registerer prometheus.Registerer) Option {
+	return func(c *Config) {
+		c.Metrics = registerer
+	}
+}
+
+// WithMaxMsgSize caps the size in bytes of the messages the server receives and sends.
+func WithMaxMsgSize(size int) Option {
+	return func(c *Config) {
+		c.MaxMsgSize = size
+	}
+}
+
+// WithMaxRecordBytes rejects produced records larger than the given size in bytes.
+func WithMaxRecordBytes(size int) Option {
+	return func(c *Config) {
+		c.MaxRecordBytes = size
+	}
+}
+
+// WithServerOptions passes raw gRPC server options through to grpc.NewServer,
+// for settings not covered by the other options.
+func WithServerOptions(opts ...grpc.ServerOption) Option {
+	return func(c *Config) {
+		c.ServerOptions = append(c.ServerOptions, opts...)
+	}
+}
+
+// WithStreamIdleTimeout closes ConsumeStreams that haven't been sent a record for the given duration.
+func WithStreamIdleTimeout(d time.Duration) Option {
+	return func(c *Config) {
+		c.StreamIdleTimeout = d
+	}
+}
+
+// WithConsumerRetention sets how long the lag of consumer groups is reported after they last consumed.
+func WithConsumerRetention(d time.Duration) Option {
+	return func(c *Config) {
+		c.ConsumerRetention = d
+	}
+}
+
+// WithQuotas limits the bytes each authenticated subject may transfer per second.
+func WithQuotas(quotas QuotaConfig) Option {
+	return func(c *Config) {
+		c.Quotas = quotas
+	}
+}
+
+// WithDebug registers the gRPC channelz service and the Debug service on the server.
+func WithDebug() Option {
+	return func(c *Config) {
+		c.EnableDebug = true
+	}
+}
+
+// WithGetServerer makes GetServers list the cluster's servers as the GetServerer reports them.
+func WithGetServerer(getServerer GetServerer) Option {
+	return func(c *Config) {
+		c.GetServerer = getServerer
+	}
+}
+
+// WithServerWatcher makes WatchServers stream the changes of the cluster's servers as the
+// ServerWatcher reports them.
+func WithServerWatcher(watcher ServerWatcher) Option {
+	return func(c *Config) {
+		c.ServerWatcher = watcher
+	}
+}
+
+// WithClusterAdmin makes the Admin service manage the cluster's servers through the ClusterAdmin.
+func WithClusterAdmin(admin ClusterAdmin) Option {
+	return func(c *Config) {
+		c.ClusterAdmin = admin
+	}
+}
+
+// WithPartitionLogs makes the v2 API serve the partitions of the topics other than the default
+// one from the PartitionLogs.
+func WithPartitionLogs(logs PartitionLogs) Option {
+	return func(c *Config) {
+		c.PartitionLogs = logs
+	}
+}
+
+// WithTracerProvider sets the provider of the tracer of the RPCs' spans, which the log's spans
+// continue when it's traced too.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *Config) {
+		c.TracerProvider = provider
+	}
+}
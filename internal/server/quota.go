@@ -0,0 +1,448 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	apiv2 "github.com/glauco/proglog/api/v2"
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// QuotaConfig configures the byte-rate quotas enforced per authenticated subject.
+// A zero rate disables the corresponding quota.
+type QuotaConfig struct {
+	ReceiveBytesPerSecond int // Bytes per second a subject may send to the server (e.g. produced records)
+	SendBytesPerSecond    int // Bytes per second the server may send to a subject (e.g. consumed records)
+	// Burst is the number of bytes a subject may transfer at once above its rate.
+	// It defaults to one second worth of the corresponding rate.
+	Burst int
+}
+
+// Throttler decides whether subjects are within quotas on the records and bytes they may produce
+// and consume, alongside the Authorizer's decisions, e.g. auth.Authorizer's quota rules. The
+// quota interceptors enforce the decisions of Authorizers implementing it, rejecting unary
+// requests and slowing streams down.
+type Throttler interface {
+	// Throttle takes a request's records and bytes from the subject's quota for the action on
+	// the object, or fails with an api.ErrThrottled, taking nothing, if they aren't available now.
+	Throttle(subject, object, action string, records, bytes int) error
+	// Charge takes the records and bytes already transferred from the subject's quota, even
+	// into debt.
+	Charge(subject, object, action string, records, bytes int)
+}
+
+// Names of the quotas reported in throttling errors.
+const (
+	receiveQuota = "receive_bytes"
+	sendQuota    = "send_bytes"
+)
+
+// quotas tracks the bytes received from and sent to each subject and throttles the
+// subjects that exceed their configured rates.
+type quotas struct {
+	static QuotaConfig
+	// cluster returns the cluster's dynamic configuration, whose quotas replace the static ones
+	// when set. It's nil, or returns nil, for servers outside a cluster.
+	cluster func() *api.ClusterConfig
+	// throttler enforces the Authorizer's quotas on the records produced and consumed, if it
+	// sets any.
+	throttler Throttler
+
+	mu      sync.Mutex
+	version uint64           // Version of the cluster's configuration the limiters follow
+	receive *subjectLimiters // Limits the bytes received from each subject
+	send    *subjectLimiters // Limits the bytes sent to each subject
+}
+
+// newQuotas creates the quotas described by the config, replaced by those of the cluster's
+// configuration when it sets them.
+func newQuotas(c QuotaConfig, cluster func() *api.ClusterConfig) *quotas {
+	return &quotas{
+		static:  c,
+		cluster: cluster,
+		receive: newSubjectLimiters(c.ReceiveBytesPerSecond, c.Burst),
+		send:    newSubjectLimiters(c.SendBytesPerSecond, c.Burst),
+	}
+}
+
+// limiters returns the limiters of the bytes received from and sent to the subjects. They're
+// replaced, with full buckets, whenever the cluster's configuration changes.
+func (q *quotas) limiters() (receive, send *subjectLimiters) {
+	var config *api.ClusterConfig
+	if q.cluster != nil {
+		config = q.cluster()
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if config != nil && config.Version != q.version {
+		q.version = config.Version
+		c := q.static
+		if quotas := config.Quotas; quotas != nil {
+			c = QuotaConfig{
+				ReceiveBytesPerSecond: int(quotas.ReceiveBytesPerSecond),
+				SendBytesPerSecond:    int(quotas.SendBytesPerSecond),
+				Burst:                 int(quotas.Burst),
+			}
+		}
+		q.receive = newSubjectLimiters(c.ReceiveBytesPerSecond, c.Burst)
+		q.send = newSubjectLimiters(c.SendBytesPerSecond, c.Burst)
+	}
+	return q.receive, q.send
+}
+
+// limiterSweepInterval is how often subjectLimiters evicts the limiters of idle subjects.
+const limiterSweepInterval = time.Minute
+
+// subjectLimiters holds one token bucket per subject, all sharing the same rate and burst.
+type subjectLimiters struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[string]*rate.Limiter
+	swept    time.Time // When the limiters of idle subjects were last evicted
+}
+
+// newSubjectLimiters creates limiters allowing perSecond bytes per second with the given burst.
+// It returns nil, which allows everything, if perSecond is zero.
+func newSubjectLimiters(perSecond, burst int) *subjectLimiters {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = perSecond
+	}
+	return &subjectLimiters{
+		limit:    rate.Limit(perSecond),
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// get returns the subject's limiter, creating it with a full bucket on first use, and evicts
+// those of idle subjects every limiterSweepInterval.
+func (l *subjectLimiters) get(subject string) *rate.Limiter {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) >= limiterSweepInterval {
+		l.sweep(now)
+	}
+	lim, ok := l.limiters[subject]
+	if !ok {
+		lim = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[subject] = lim
+	}
+	return lim
+}
+
+// sweep evicts the limiters whose buckets are full again by now, as their subjects stayed idle
+// long enough to refill them. A limiter created on the subject's next request starts full too,
+// so evicting them changes no decision, while clients gone for good, e.g. the IP addresses
+// RateLimit tracks, don't pile up.
+func (l *subjectLimiters) sweep(now time.Time) {
+	for subject, lim := range l.limiters {
+		if lim.TokensAt(now) >= float64(l.burst) {
+			delete(l.limiters, subject)
+		}
+	}
+	l.swept = now
+}
+
+// reserve takes n bytes from the subject's bucket if they are available now.
+// Otherwise it takes nothing and returns how long the subject must wait.
+func (l *subjectLimiters) reserve(subject string, n int) time.Duration {
+	if l == nil {
+		return 0
+	}
+	now := time.Now()
+	r := l.get(subject).ReserveN(now, min(n, l.burst))
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+// charge takes n bytes from the subject's bucket even if that puts it into debt,
+// so that bytes already transferred slow down the subject's next requests.
+func (l *subjectLimiters) charge(subject string, n int) {
+	if l == nil {
+		return
+	}
+	l.get(subject).ReserveN(time.Now(), min(n, l.burst))
+}
+
+// debt returns how long the subject must wait until its bucket is no longer in debt.
+func (l *subjectLimiters) debt(subject string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	tokens := l.get(subject).TokensAt(time.Now())
+	if tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tokens / float64(l.limit) * float64(time.Second))
+}
+
+// throttled returns an ErrThrottled if the subject can't transfer a request of size n now.
+func (q *quotas) throttled(subject string, n int) error {
+	receive, send := q.limiters()
+	if delay := send.debt(subject); delay > 0 {
+		return api.ErrThrottled{Subject: subject, Quota: sendQuota, RetryAfter: delay}
+	}
+	if delay := receive.reserve(subject, n); delay > 0 {
+		return api.ErrThrottled{Subject: subject, Quota: receiveQuota, RetryAfter: delay}
+	}
+	return nil
+}
+
+// throttle decides whether the subject may transfer the record the message carries, within the
+// Throttler's quotas for the action on the topic. Messages without records, e.g. consume
+// requests, only wait for the subject's debt to be repaid.
+func (q *quotas) throttle(subject, topic, action string, m any) error {
+	if q.throttler == nil || action == "" {
+		return nil
+	}
+	records, bytes := recordCount(m)
+	return q.throttler.Throttle(subject, topic, action, records, bytes)
+}
+
+// charge charges the record the message carries, if any, against the Throttler's quotas for
+// the action on the topic, e.g. once consumed.
+func (q *quotas) charge(subject, topic, action string, m any) {
+	if q.throttler == nil || action == "" {
+		return
+	}
+	if records, bytes := recordCount(m); records > 0 {
+		q.throttler.Charge(subject, topic, action, records, bytes)
+	}
+}
+
+// unaryInterceptor rejects unary requests from subjects over their quota with a throttling
+// error carrying a retry hint, and charges response bytes against the send quota, and consumed
+// records against the Throttler's.
+func (q *quotas) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		sub := subject(ctx)
+		if err := q.throttled(sub, messageSize(req)); err != nil {
+			return nil, err
+		}
+		action, topic := logAction(info.FullMethod), requestTopic(req)
+		if err := q.throttle(sub, topic, action, req); err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		if err == nil {
+			_, send := q.limiters()
+			send.charge(sub, messageSize(resp))
+			q.charge(sub, topic, action, resp)
+		}
+		return resp, err
+	}
+}
+
+// streamInterceptor throttles streams by delaying messages of subjects over their quota,
+// so long-lived streams slow down instead of failing.
+func (q *quotas) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &quotaStream{
+			ServerStream: ss,
+			quotas:       q,
+			subject:      subject(ss.Context()),
+			action:       logAction(info.FullMethod),
+			topic:        defaultTopic,
+		})
+	}
+}
+
+// logAction returns the action the Log RPC's records are limited as, produce or consume, or ""
+// for the other RPCs.
+func logAction(method string) string {
+	switch method {
+	case api.Log_Produce_FullMethodName, api.Log_ProduceStream_FullMethodName,
+		apiv2.Log_Produce_FullMethodName, apiv2.Log_ProduceStream_FullMethodName:
+		return produceAction
+	case api.Log_Consume_FullMethodName, api.Log_ConsumeStream_FullMethodName, api.Log_Subscribe_FullMethodName,
+		apiv2.Log_Consume_FullMethodName, apiv2.Log_ConsumeStream_FullMethodName:
+		return consumeAction
+	}
+	return ""
+}
+
+// requestTopic returns the topic the request addresses, the default one unless it's a v2
+// request naming another.
+func requestTopic(req any) string {
+	if r, ok := req.(interface{ GetTopic() string }); ok && r.GetTopic() != "" {
+		return r.GetTopic()
+	}
+	return defaultTopic
+}
+
+// recordCount returns the records a Log message carries, one or none, and their encoded size.
+func recordCount(m any) (records, bytes int) {
+	// The records are checked for nil before converting them, as typed nils wouldn't be
+	var record proto.Message
+	switch m := m.(type) {
+	case *api.ProduceRequest:
+		if m.GetRecord() != nil {
+			record = m.GetRecord()
+		}
+	case *api.ConsumeResponse:
+		if m.GetRecord() != nil {
+			record = m.GetRecord()
+		}
+	case *apiv2.ProduceRequest:
+		if m.GetRecord() != nil {
+			record = m.GetRecord()
+		}
+	case *apiv2.ConsumeResponse:
+		if m.GetRecord() != nil {
+			record = m.GetRecord()
+		}
+	}
+	if record == nil {
+		return 0, 0
+	}
+	return 1, proto.Size(record)
+}
+
+// RateLimit returns HTTP middleware enforcing the quotas with the same token buckets as the gRPC
+// server: requests from clients over their quota are rejected with 429 Too Many Requests and a
+// Retry-After header, and response bytes are charged against the send quota. Clients are the
+// subjects set by HTTPAuth, which must run first, or their IP addresses without authentication.
+// Health probes and docs aren't limited.
+func RateLimit(c QuotaConfig) mux.MiddlewareFunc {
+	q := newQuotas(c, nil)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if route := mux.CurrentRoute(r); route != nil && publicRoutes[route.GetName()] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			client := httpClient(r)
+			var throttled api.ErrThrottled
+			if err := q.throttled(client, max(int(r.ContentLength), 0)); errors.As(err, &throttled) {
+				// Retry-After is in whole seconds, so round up to not invite retries that fail again
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+				httpError(w, err)
+				return
+			}
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			_, send := q.limiters()
+			send.charge(client, rec.bytes)
+		})
+	}
+}
+
+// httpClient returns the identity HTTP quotas are tracked by: the authenticated subject, or the
+// client's IP address when requests aren't authenticated.
+func httpClient(r *http.Request) string {
+	if sub, ok := r.Context().Value(subjectContextKey{}).(string); ok {
+		return sub
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// quotaStream wraps a server stream to account its messages against the subject's quotas.
+type quotaStream struct {
+	grpc.ServerStream
+	quotas  *quotas
+	subject string
+	action  string // Action the stream's records are limited as, if it's a Log RPC's
+	topic   string // Topic the stream consumes, as of its last request
+}
+
+// RecvMsg receives a message and waits until the subject has enough receive quota for it, and
+// Throttler quota for the record it produces, if any.
+func (s *quotaStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	n := messageSize(m)
+	for {
+		receive, _ := s.quotas.limiters()
+		delay := receive.reserve(s.subject, n)
+		if delay == 0 {
+			break
+		}
+		if err := s.wait(delay); err != nil {
+			return err
+		}
+	}
+	if s.action == consumeAction {
+		s.topic = requestTopic(m)
+		return nil
+	}
+	return s.throttle(requestTopic(m), m)
+}
+
+// SendMsg waits until the subject's send quota is out of debt, and it has Throttler quota for
+// the record it consumes, if any, then sends the message.
+func (s *quotaStream) SendMsg(m any) error {
+	_, send := s.quotas.limiters()
+	if err := s.wait(send.debt(s.subject)); err != nil {
+		return err
+	}
+	if s.action == consumeAction {
+		if err := s.throttle(s.topic, m); err != nil {
+			return err
+		}
+	}
+	send.charge(s.subject, messageSize(m))
+	return s.ServerStream.SendMsg(m)
+}
+
+// throttle waits until the Throttler lets the subject transfer the record the message carries.
+func (s *quotaStream) throttle(topic string, m any) error {
+	if records, _ := recordCount(m); records == 0 {
+		return nil
+	}
+	for {
+		err := s.quotas.throttle(s.subject, topic, s.action, m)
+		var throttled api.ErrThrottled
+		if !errors.As(err, &throttled) {
+			return err
+		}
+		if err := s.wait(throttled.RetryAfter); err != nil {
+			return err
+		}
+	}
+}
+
+// wait blocks for the given delay or until the stream's context is done.
+func (s *quotaStream) wait(delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-s.Context().Done():
+		return s.Context().Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// messageSize returns the encoded size of a protobuf message, or 0 for anything else.
+func messageSize(m any) int {
+	if msg, ok := m.(proto.Message); ok {
+		return proto.Size(msg)
+	}
+	return 0
+}
@@ -2,33 +2,175 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
 
 	api "github.com/glauco/proglog/api/v1"
+	apiv2 "github.com/glauco/proglog/api/v2"
+	"github.com/glauco/proglog/internal/version"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	channelz "google.golang.org/grpc/channelz/service"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-// Config contains the dependencies required by the gRPC server.
+// Config contains the dependencies and settings of the gRPC server.
+// NewGRPCServer applies its options to the Config and validates it before building the server.
 type Config struct {
-	CommitLog  CommitLog // CommitLog is an interface used to append and read log records.
-	Authorizer Authorizer
+	CommitLog  CommitLog             // CommitLog is an interface used to append and read log records.
+	Authorizer Authorizer            // Authorizer decides whether a subject may act on an object.
+	Logger     *slog.Logger          // Logger receives the server's logs; defaults to slog.Default().
+	TLSConfig  *tls.Config           // TLSConfig secures connections and authenticates clients.
+	Metrics    prometheus.Registerer // Metrics registers the server's RPC metrics when set.
+	MaxMsgSize int                   // MaxMsgSize caps received and sent messages in bytes; 0 keeps gRPC's default.
+	// MaxRecordBytes rejects produced records whose encoded size exceeds it with a
+	// RECORD_TOO_LARGE error; 0 accepts records of any size that fits in a message.
+	MaxRecordBytes int
+	// TracerProvider provides the tracer of the spans of the RPCs, which continue the traces of
+	// their W3C Trace Context metadata; defaults to otel.GetTracerProvider().
+	TracerProvider trace.TracerProvider
+	// StreamIdleTimeout closes ConsumeStreams that haven't been sent a record for this long,
+	// so abandoned clients don't pin server resources; 0 keeps streams open indefinitely.
+	// Dead connections are detected separately through gRPC keepalives.
+	StreamIdleTimeout time.Duration
+	// ConsumerRetention is how long the server keeps reporting the lag of consumer groups
+	// after it last served them a record, e.g. so groups that were renamed or retired stop
+	// firing alerts; defaults to a day.
+	ConsumerRetention time.Duration
+	// Quotas limits the bytes each authenticated subject may transfer per second, unless the
+	// cluster's configuration sets other quotas.
+	Quotas QuotaConfig
+	// GetServerer lists the servers of the cluster for GetServers; servers outside a cluster leave it nil.
+	GetServerer GetServerer
+	// ServerWatcher streams the changes of the cluster's servers for WatchServers; servers
+	// outside a cluster leave it nil.
+	ServerWatcher ServerWatcher
+	// ClusterAdmin manages the cluster's servers for the Admin service; servers outside a cluster leave it nil.
+	ClusterAdmin ClusterAdmin
+	// PartitionLogs holds the partitions of the topics other than the default one, which the v2
+	// API serves; servers without topics leave it nil.
+	PartitionLogs PartitionLogs
+	// EnableDebug registers the gRPC channelz service and the Debug service, which lists the
+	// open streams with their subjects and offsets, for live troubleshooting.
+	EnableDebug bool
+	// Insecure accepts clients without certificates, e.g. over plaintext connections, as the
+	// AnonymousSubject, for local development. Unless an Authorizer is set, every subject may do
+	// anything.
+	Insecure bool
+	// TokenValidator, if set, authenticates clients without certificates by the bearer token in
+	// their requests' authorization metadata, e.g. JWTs validated by an auth.JWTValidator. The
+	// server's TLS config must then accept clients without certificates, e.g. with
+	// tls.VerifyClientCertIfGiven.
+	TokenValidator TokenValidator
+	// TokenIssuer, if set, mints the scoped tokens of the Admin service's CreateToken and
+	// authenticates clients by the bearer tokens it minted, which are then only permitted the
+	// actions of their scopes.
+	TokenIssuer TokenIssuer
+	// SubjectRules, if set, extract the subjects of clients from their certificates' SANs, or
+	// CommonNames, by the first rule matching; clients no rule matches aren't authenticated.
+	// Without rules, clients are authorized by their certificates' CommonNames.
+	SubjectRules []SubjectRule
+	// Auditor, if set, records every authorization decision, allowed and denied alike.
+	Auditor       Auditor
+	ServerOptions []grpc.ServerOption // ServerOptions are passed through to grpc.NewServer.
 }
 
+// Validate checks that the Config holds every required dependency and sane settings,
+// and fills in defaults for optional ones.
+func (c *Config) Validate() error {
+	if c.CommitLog == nil {
+		return fmt.Errorf("server config: commit log is required")
+	}
+	if c.Authorizer == nil && c.Insecure {
+		c.Authorizer = allowAll{}
+	}
+	if c.Authorizer == nil {
+		return fmt.Errorf("server config: authorizer is required")
+	}
+	if c.StreamIdleTimeout < 0 {
+		return fmt.Errorf("server config: stream idle timeout must not be negative, got %s", c.StreamIdleTimeout)
+	}
+	if c.ConsumerRetention < 0 {
+		return fmt.Errorf("server config: consumer retention must not be negative, got %s", c.ConsumerRetention)
+	}
+	if c.ConsumerRetention == 0 {
+		c.ConsumerRetention = defaultConsumerRetention
+	}
+	if c.Quotas.ReceiveBytesPerSecond < 0 || c.Quotas.SendBytesPerSecond < 0 || c.Quotas.Burst < 0 {
+		return fmt.Errorf("server config: quotas must not be negative, got %+v", c.Quotas)
+	}
+	if c.MaxMsgSize < 0 {
+		return fmt.Errorf("server config: max message size must not be negative, got %d", c.MaxMsgSize)
+	}
+	if c.MaxRecordBytes < 0 {
+		return fmt.Errorf("server config: max record size must not be negative, got %d", c.MaxRecordBytes)
+	}
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+	if c.TracerProvider == nil {
+		c.TracerProvider = otel.GetTracerProvider()
+	}
+	return nil
+}
+
+// Authorizer decides whether a subject may perform an action on an object.
 type Authorizer interface {
 	Authorize(subject, object, action string) error
 }
 
+// TokenValidator authenticates bearer tokens, returning the subject they were issued to.
+type TokenValidator interface {
+	Validate(ctx context.Context, token string) (subject string, err error)
+}
+
+// allowAll is the Authorizer of insecure servers without one, letting every subject do anything.
+type allowAll struct{}
+
+func (allowAll) Authorize(subject, object, action string) error {
+	return nil
+}
+
+// AnonymousSubject is the subject of the clients of insecure servers without certificates.
+const AnonymousSubject = "anonymous"
+
+// Objects checked by the authorizer. Records are authorized against the topic they belong
+// to, while the remaining objects guard cluster-wide resources.
+const (
+	defaultTopic  = "default" // Topic addressed by the v1 API, which carries no topic field
+	objectAdmin   = "admin"   // Administrative operations on the log and the cluster
+	objectCluster = "cluster" // Cluster metadata, such as the servers and which one leads
+	objectOffsets = "offsets" // Log offset metadata, such as the lowest and highest offsets
+)
+
+// Actions checked by the authorizer.
 const (
-	objectWildCard = "*"
-	produceAction  = "produce"
-	consumeAction  = "consume"
+	produceAction  = "produce"   // Append records to a topic
+	consumeAction  = "consume"   // Read records from a topic
+	describeAction = "describe"  // Read metadata about an object
+	adminAction    = "admin"     // Perform administrative operations
+	aclAdminAction = "acl-admin" // Manage the ACL rules of the cluster's configuration
+	tokenAction    = "token"     // Mint scoped tokens authenticating as oneself
 )
 
+// readWaitInterval is how often a long-polling Consume checks whether the requested record was appended.
+const readWaitInterval = 10 * time.Millisecond
+
 // Ensure grpcServer implements the api.LogServer interface.
 // This helps catch implementation errors during compile time.
 var _ api.LogServer = (*grpcServer)(nil)
@@ -38,13 +180,19 @@ var _ api.LogServer = (*grpcServer)(nil)
 type grpcServer struct {
 	api.UnimplementedLogServer // Provides default implementations of the LogServer methods.
 	*Config                    // Embeds the configuration, including the CommitLog interface.
+
+	topic     string           // Topic the CommitLog holds, which requests are authorized against.
+	partition uint32           // Partition of the topic the CommitLog holds.
+	consumers *consumerTracker // Tracks the consumer groups' positions, shared by every partition's server.
 }
 
 // newgrpcServer creates a new gRPC server instance.
 // It takes a Config object and returns a pointer to a grpcServer.
-func newgrpcServer(config *Config) (srv *grpcServer, err error) {
+func newgrpcServer(config *Config, consumers *consumerTracker) (srv *grpcServer, err error) {
 	srv = &grpcServer{
-		Config: config, // Assign the provided configuration
+		Config:    config, // Assign the provided configuration
+		topic:     defaultTopic,
+		consumers: consumers,
 	}
 	return srv, nil
 }
@@ -52,39 +200,122 @@ func newgrpcServer(config *Config) (srv *grpcServer, err error) {
 // Produce handles producing (adding) a record to the commit log.
 // It returns the offset at which the record was stored.
 func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
-	if err := s.Authorizer.Authorize(
-		subject(ctx),
-		objectWildCard,
+	if err := s.authorize(
+		ctx,
+		s.topic,
 		produceAction,
 	); err != nil {
 		return nil, err
 	}
-	// Append the record to the commit log
-	offset, err := s.CommitLog.Append(req.Record)
+	if req.Record == nil {
+		return nil, api.NewError(codes.InvalidArgument, api.ReasonInvalidRequest, "record is required", nil)
+	}
+	// Reject records too large to store before touching the log
+	if size := proto.Size(req.Record); s.MaxRecordBytes > 0 && size > s.MaxRecordBytes {
+		return nil, api.ErrRecordTooLarge{Size: size, Max: s.MaxRecordBytes}
+	}
+	// Only acknowledge replicated writes if the log waits for a quorum to store them
+	if req.Acks == api.Acks_ACKS_REPLICATED {
+		if rl, ok := s.CommitLog.(ReplicatedLog); !ok || !rl.Replicated() {
+			return nil, status.Error(codes.FailedPrecondition, "replicated acks need a log replicated with Raft")
+		}
+	}
+	// Stamp the record with the time it is appended, overriding anything the client sent; logs
+	// replicated with Raft stamp it with their leader's epoch too
+	req.Record.AppendTime = timestamppb.Now()
+	req.Record.LeaderEpoch = 0
+
+	var (
+		offset uint64
+		err    error
+	)
+	traced, isTraced := s.CommitLog.(TracedLog)
+	switch {
+	case req.ExpectedOffset != nil && isTraced:
+		offset, err = traced.CompareAndAppendContext(ctx, req.Record, req.GetExpectedOffset())
+	case req.ExpectedOffset != nil:
+		// Only append if the record would be stored at the offset the producer expects
+		offset, err = s.CommitLog.CompareAndAppend(req.Record, req.GetExpectedOffset())
+	case isTraced:
+		offset, err = traced.AppendContext(ctx, req.Record)
+	default:
+		// Append the record to the commit log
+		offset, err = s.CommitLog.Append(req.Record)
+	}
 	if err != nil {
 		return nil, err // Return an error if the append fails
 	}
-	// Return the offset of the new record in the ProduceResponse
-	return &api.ProduceResponse{Offset: offset}, nil
+	hw, err := highWatermark(s.CommitLog)
+	if err != nil {
+		return nil, err
+	}
+	// Return the offset, append time and leader epoch of the new record in the ProduceResponse,
+	// along with a session token the producer can present to read its own writes and the log's
+	// high watermark
+	return &api.ProduceResponse{
+		Offset:        offset,
+		AppendTime:    req.Record.AppendTime,
+		SessionToken:  newSessionToken(offset),
+		HighWatermark: hw,
+		LeaderEpoch:   req.Record.LeaderEpoch,
+	}, nil
 }
 
 // Consume handles reading a record from the commit log at a given offset.
 // It returns the record in a ConsumeResponse.
 func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
-	if err := s.Authorizer.Authorize(
-		subject(ctx),
-		objectWildCard,
+	if err := s.authorize(
+		ctx,
+		s.topic,
 		consumeAction,
 	); err != nil {
 		return nil, err
 	}
-	// Read the record from the commit log at the given offset
-	record, err := s.CommitLog.Read(req.Offset)
+	return s.consume(ctx, req)
+}
+
+// consume reads the record at the request's offset for a client already authorized to consume,
+// so streams polling the log authorize, and audit, their client once rather than every poll.
+func (s *grpcServer) consume(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
+	// Make sure this server has the writes the client already saw before serving the read, or
+	// every write acknowledged to any client for linearizable reads
+	if err := s.awaitSession(ctx, req.SessionToken); err != nil {
+		return nil, err
+	}
+	if err := s.awaitLinearizable(ctx, req.Linearizable); err != nil {
+		return nil, err
+	}
+	// Resolve the offset, which may be relative to the head of the log
+	offset, err := s.resolveOffset(req)
+	if err != nil {
+		return nil, err
+	}
+	var record *api.Record
+	if req.MaxWaitMs > 0 {
+		// Long-poll: wait up to max_wait_ms for the record to be appended
+		waitCtx, cancel := context.WithTimeout(ctx, time.Duration(req.MaxWaitMs)*time.Millisecond)
+		defer cancel()
+		record, err = s.readWait(waitCtx, offset)
+	} else {
+		// Read the record from the commit log at the given offset
+		record, err = s.CommitLog.Read(offset)
+	}
 	if err != nil {
 		return nil, err // Return an error if reading fails
 	}
-	// Return the record in a ConsumeResponse
-	return &api.ConsumeResponse{Record: record}, nil
+	// Track the position of the consumer group, to report its lag
+	s.consumers.consumed(consumerKey{
+		subject:   subject(ctx),
+		group:     consumerGroup(ctx, req.ConsumerGroup),
+		topic:     s.topic,
+		partition: s.partition,
+	}, s.CommitLog, record.Offset)
+	hw, err := highWatermark(s.CommitLog)
+	if err != nil {
+		return nil, err
+	}
+	// Return the record in a ConsumeResponse, with the high watermark so consumers can compute their lag
+	return &api.ConsumeResponse{Record: record, HighWatermark: hw}, nil
 }
 
 // ProduceStream handles a bidirectional stream where the client sends multiple ProduceRequests,
@@ -93,8 +324,11 @@ func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
 	for {
 		// Receive the next ProduceRequest from the stream
 		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil // The client closed the stream, so it ends cleanly
+		}
 		if err != nil {
-			return err // Return error if the client closes the stream or any other error occurs
+			return err // Return error if the stream failed
 		}
 		// Produce the record and get a response
 		res, err := s.Produce(stream.Context(), req)
@@ -111,18 +345,55 @@ func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
 // ConsumeStream handles a server-side streaming RPC where the client requests a stream
 // starting at a specific offset, and the server keeps sending new records as they arrive.
 func (s *grpcServer) ConsumeStream(req *api.ConsumeRequest, stream api.Log_ConsumeStreamServer) error {
+	// Authorize the client once, as the stream polls the log until it ends
+	if err := s.authorize(stream.Context(), s.topic, consumeAction); err != nil {
+		return err
+	}
+	// Wait for the client's writes, then resolve a relative starting offset once,
+	// so the stream moves forward from a fixed position
+	if err := s.awaitSession(stream.Context(), req.SessionToken); err != nil {
+		return err
+	}
+	if err := s.awaitLinearizable(stream.Context(), req.Linearizable); err != nil {
+		return err
+	}
+	offset, err := s.resolveOffset(req)
+	if err != nil {
+		return err
+	}
+	req.Offset, req.RelativeOffset, req.SessionToken, req.Linearizable = offset, 0, nil, false
+	// Track when a record was last sent, to close streams that stay idle for too long
+	lastSent := time.Now()
+	ticker := time.NewTicker(readWaitInterval)
+	defer ticker.Stop()
 	for {
+		// Report the stream's position for troubleshooting
+		setStreamOffset(stream.Context(), req.Offset)
 		select {
 		case <-stream.Context().Done():
 			return nil // If the client's context is done, terminate the stream
 		default:
 			// Attempt to consume a record from the requested offset
-			res, err := s.Consume(stream.Context(), req)
+			res, err := s.consume(stream.Context(), req)
 			switch err.(type) {
 			case nil:
 				// If no error, proceed to send the response
 			case api.ErrOffsetOutOfRange:
-				// If the offset is out of range, continue and wait for more records
+				// If the offset is out of range, close the stream if it has been idle for too long...
+				if idle := time.Since(lastSent); s.StreamIdleTimeout > 0 && idle >= s.StreamIdleTimeout {
+					return api.NewError(
+						codes.DeadlineExceeded,
+						api.ReasonStreamIdle,
+						fmt.Sprintf("consume stream idle for %s, closing", idle.Round(time.Millisecond)),
+						map[string]string{"offset": strconv.FormatUint(req.Offset, 10)},
+					)
+				}
+				// ...otherwise wait a moment for more records
+				select {
+				case <-stream.Context().Done():
+					return nil
+				case <-ticker.C:
+				}
 				continue
 			default:
 				return err // For any other error, terminate the stream
@@ -131,66 +402,499 @@ func (s *grpcServer) ConsumeStream(req *api.ConsumeRequest, stream api.Log_Consu
 			if err = stream.Send(res); err != nil {
 				return err // Return error if sending fails
 			}
+			lastSent = time.Now()
 			// Increment the offset for the next read
 			req.Offset++
 		}
 	}
 }
 
+// Subscribe handles a bidirectional stream where the server sends records like ConsumeStream
+// while the client steers the stream with seek, pause and resume commands, avoiding a re-dial
+// every time a consumer wants to jump position. The first command must be a seek.
+func (s *grpcServer) Subscribe(stream api.Log_SubscribeServer) error {
+	ctx := stream.Context()
+	// Authorize the client once, as the stream polls the log until it ends
+	if err := s.authorize(ctx, s.topic, consumeAction); err != nil {
+		return err
+	}
+
+	// Receive the initial seek that positions the stream
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	seek := first.GetSeek()
+	if seek == nil {
+		return api.NewError(codes.InvalidArgument, api.ReasonInvalidRequest, "the first subscribe command must be a seek", nil)
+	}
+	if err := s.awaitSession(ctx, seek.SessionToken); err != nil {
+		return err
+	}
+	if err := s.awaitLinearizable(ctx, seek.Linearizable); err != nil {
+		return err
+	}
+	offset, err := s.resolveOffset(seek)
+	if err != nil {
+		return err
+	}
+	group := seek.ConsumerGroup
+
+	// Receive the following commands in the background while records are being sent
+	cmds := make(chan *api.SubscribeRequest)
+	recvErrs := make(chan error, 1)
+	go func() {
+		for {
+			cmd, err := stream.Recv()
+			if err != nil {
+				recvErrs <- err
+				return
+			}
+			select {
+			case cmds <- cmd:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	paused := false
+	// apply updates the stream's position and state from a command
+	apply := func(cmd *api.SubscribeRequest) error {
+		switch c := cmd.Command.(type) {
+		case *api.SubscribeRequest_Seek:
+			if err := s.awaitSession(ctx, c.Seek.SessionToken); err != nil {
+				return err
+			}
+			if err := s.awaitLinearizable(ctx, c.Seek.Linearizable); err != nil {
+				return err
+			}
+			off, err := s.resolveOffset(c.Seek)
+			if err != nil {
+				return err
+			}
+			offset, group = off, c.Seek.ConsumerGroup
+		case *api.SubscribeRequest_Pause_:
+			paused = true
+		case *api.SubscribeRequest_Resume_:
+			paused = false
+		default:
+			return api.NewError(codes.InvalidArgument, api.ReasonInvalidRequest, "unknown subscribe command", nil)
+		}
+		return nil
+	}
+
+	ticker := time.NewTicker(readWaitInterval)
+	defer ticker.Stop()
+	for {
+		// Report the stream's position for troubleshooting
+		setStreamOffset(ctx, offset)
+
+		var wait <-chan time.Time // Set when the stream caught up with the head of the log
+		if !paused {
+			res, err := s.consume(ctx, &api.ConsumeRequest{Offset: offset, ConsumerGroup: group})
+			switch err.(type) {
+			case nil:
+				// Send the record and move on to the next one
+				if err = stream.Send(res); err != nil {
+					return err
+				}
+				offset++
+			case api.ErrOffsetOutOfRange:
+				// Wait a moment for more records
+				wait = ticker.C
+			default:
+				return err
+			}
+		}
+		if !paused && wait == nil {
+			// A record was sent: check for commands without blocking, then send the next one
+			select {
+			case cmd := <-cmds:
+				if err := apply(cmd); err != nil {
+					return err
+				}
+			default:
+			}
+			continue
+		}
+
+		// Paused or caught up: block until a command arrives, the client leaves, or it's time to poll again
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-recvErrs:
+			if err != io.EOF {
+				return err
+			}
+			// The client won't send more commands; keep streaming from the current position
+			recvErrs = nil
+		case cmd := <-cmds:
+			if err := apply(cmd); err != nil {
+				return err
+			}
+		case <-wait:
+		}
+	}
+}
+
+// readWait reads the record at the given offset, waiting for it to be appended if the offset
+// is past the head of the log. It polls the log until the record exists or the context is done,
+// in which case the last out-of-range error is returned.
+func (s *grpcServer) readWait(ctx context.Context, offset uint64) (*api.Record, error) {
+	ticker := time.NewTicker(readWaitInterval)
+	defer ticker.Stop()
+	for {
+		record, err := s.CommitLog.Read(offset)
+		outOfRange, ok := err.(api.ErrOffsetOutOfRange)
+		if !ok || offset < outOfRange.Lowest {
+			// Found the record, hit a real error, or the offset was truncated and will never appear
+			return record, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-ticker.C:
+		}
+	}
+}
+
+// highWatermark returns the offset the next record appended to the log will get.
+func highWatermark(clog CommitLog) (uint64, error) {
+	highest, err := clog.HighestOffset()
+	if err != nil {
+		return 0, err
+	}
+	if highest == 0 {
+		// HighestOffset reports 0 for both an empty log and a log holding a single record,
+		// so check whether the record exists; if not, the error tells where the log ends
+		if _, err := clog.Read(0); err != nil {
+			if outOfRange, ok := err.(api.ErrOffsetOutOfRange); ok {
+				return outOfRange.Next, nil
+			}
+			return 0, err
+		}
+	}
+	return highest + 1, nil
+}
+
+// resolveOffset returns the absolute offset addressed by the request.
+// A negative relative offset counts back from the head of the log, so -1 addresses the most
+// recent record; offsets reaching past the start of the log are clamped to the lowest offset.
+func (s *grpcServer) resolveOffset(req *api.ConsumeRequest) (uint64, error) {
+	if req.RelativeOffset == 0 {
+		return req.Offset, nil
+	}
+	if req.RelativeOffset > 0 {
+		return 0, api.NewError(
+			codes.InvalidArgument,
+			api.ReasonInvalidRequest,
+			fmt.Sprintf("relative offset must be negative, got %d", req.RelativeOffset),
+			map[string]string{"relative_offset": strconv.FormatInt(req.RelativeOffset, 10)},
+		)
+	}
+	lowest, err := s.CommitLog.LowestOffset()
+	if err != nil {
+		return 0, err
+	}
+	highest, err := s.CommitLog.HighestOffset()
+	if err != nil {
+		return 0, err
+	}
+	// The head of the log is one past the highest offset
+	back := uint64(-req.RelativeOffset)
+	if head := highest + 1; back < head && head-back > lowest {
+		return head - back, nil
+	}
+	return lowest, nil
+}
+
+// GetServers returns the servers of the cluster, with their RPC addresses and which one is the
+// leader, so clients can discover the replicas and route produces to the leader.
+func (s *grpcServer) GetServers(ctx context.Context, req *api.GetServersRequest) (*api.GetServersResponse, error) {
+	if err := s.authorize(
+		ctx,
+		objectCluster,
+		describeAction,
+	); err != nil {
+		return nil, err
+	}
+	if s.GetServerer == nil {
+		return nil, status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	servers, err := s.GetServerer.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetServersResponse{Servers: servers}, nil
+}
+
+// GetVersion returns the build of the server's binary.
+func (s *grpcServer) GetVersion(ctx context.Context, req *api.GetVersionRequest) (*api.GetVersionResponse, error) {
+	if err := s.authorize(
+		ctx,
+		objectCluster,
+		describeAction,
+	); err != nil {
+		return nil, err
+	}
+	return &api.GetVersionResponse{Build: version.Get()}, nil
+}
+
+// WatchServers streams the changes of the cluster's servers until the client cancels the stream.
+// Streams falling behind the changes are closed with ResourceExhausted.
+func (s *grpcServer) WatchServers(req *api.WatchServersRequest, stream api.Log_WatchServersServer) error {
+	if err := s.authorize(
+		stream.Context(),
+		objectCluster,
+		describeAction,
+	); err != nil {
+		return err
+	}
+	if s.ServerWatcher == nil {
+		return status.Error(codes.Unimplemented, "the server isn't part of a cluster")
+	}
+	events, stop := s.ServerWatcher.WatchServers()
+	defer stop()
+	// The header tells the client it's watching, so it can list the servers without missing changes
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return api.NewError(codes.ResourceExhausted, api.ReasonFellBehind,
+					"the stream fell behind the cluster's changes; watch again and list the servers", nil)
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ReplicatedLog is a CommitLog replicated across a cluster, e.g. with Raft. Produces asking for
+// replicated acks are only accepted by servers whose CommitLog is a ReplicatedLog reporting
+// that its appends return once a quorum of servers durably stored the record.
+type ReplicatedLog interface {
+	CommitLog
+	Replicated() bool
+}
+
+// TracedLog is a CommitLog whose appends continue the trace of the produce request, so its spans
+// tell where the time of slow produces goes, e.g. waiting for locks, marshaling or fsyncing.
+type TracedLog interface {
+	AppendContext(context.Context, *api.Record) (uint64, error)
+	CompareAndAppendContext(context.Context, *api.Record, uint64) (uint64, error)
+}
+
+// LinearizableLog is a CommitLog whose reads can be made linearizable, e.g. a log replicated with
+// Raft, whose followers may lag behind the leader. Other logs' reads always are.
+type LinearizableLog interface {
+	CommitLog
+	// ReadIndex waits until the log holds every write acknowledged before the call, or fails if
+	// the server can't tell, e.g. as it isn't the leader.
+	ReadIndex(ctx context.Context) error
+}
+
+// GetServerer lists the servers of the cluster, e.g. a log replicated with Raft.
+type GetServerer interface {
+	GetServers() ([]*api.Server, error)
+}
+
+// ServerWatcher streams the changes of the cluster's servers, e.g. as gossiped and elected.
+type ServerWatcher interface {
+	// WatchServers returns a channel receiving the changes following the call, and a func to stop
+	// watching. The channel is closed if the watcher falls behind.
+	WatchServers() (<-chan *api.ServerEvent, func())
+}
+
 // CommitLog is an interface that defines the methods required to interact with a log.
 // It includes methods for appending records and reading records by offset.
 type CommitLog interface {
-	Append(*api.Record) (uint64, error) // Append adds a record to the log and returns its offset.
-	Read(uint64) (*api.Record, error)   // Read retrieves a record at the given offset.
+	Append(*api.Record) (uint64, error)                   // Append adds a record to the log and returns its offset.
+	CompareAndAppend(*api.Record, uint64) (uint64, error) // CompareAndAppend appends only if the record gets the expected offset.
+	Read(uint64) (*api.Record, error)                     // Read retrieves a record at the given offset.
+	LowestOffset() (uint64, error)                        // LowestOffset returns the oldest offset in the log.
+	HighestOffset() (uint64, error)                       // HighestOffset returns the most recent offset in the log.
+}
+
+// PartitionLogs holds the logs of the partitions of the topics other than the default one.
+type PartitionLogs interface {
+	// PartitionLog returns the log of the topic's partition, or an error if the server doesn't
+	// hold it, e.g. because the partition doesn't exist or is replicated by other servers.
+	PartitionLog(topic string, partition uint32) (CommitLog, error)
 }
 
 // NewGRPCServer creates a new gRPC server instance, registers the LogServer service, and returns it.
 // It is responsible for setting up the gRPC server and linking the server logic.
-func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, error) {
-	opts = append(opts, grpc.StreamInterceptor(
+func NewGRPCServer(config *Config, opts ...Option) (*grpc.Server, error) {
+	// Apply the options and make sure the resulting configuration is usable
+	for _, opt := range opts {
+		opt(config)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Observe every RPC for logs and, if enabled, metrics
+	obs, err := newObserver(config.Logger, config.Metrics, config.TracerProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	// Enforce quotas on authenticated subjects, following those of the cluster's configuration
+	quotas := newQuotas(config.Quotas, func() *api.ClusterConfig {
+		if config.ClusterAdmin == nil {
+			return nil
+		}
+		return config.ClusterAdmin.ClusterConfig()
+	})
+	// and those the Authorizer's policy sets on the records produced and consumed, if any
+	quotas.throttler, _ = config.Authorizer.(Throttler)
+	// Keep track of open streams and, if enabled, report their lag as metrics
+	streams := newStreamRegistry(config.CommitLog, config.Logger)
+	if config.Metrics != nil {
+		err := config.Metrics.Register(streams)
+		var are prometheus.AlreadyRegisteredError
+		if err != nil && !errors.As(err, &are) {
+			return nil, err
+		}
+		// If another server already reports its streams on a shared registry, this server's
+		// streams are still listed by the Debug service but not exported as metrics
+	}
+	// Keep track of the consumer groups' positions and, if enabled, report their lag as metrics
+	consumers := newConsumerTracker(config.ConsumerRetention)
+	if config.Metrics != nil {
+		err := config.Metrics.Register(consumers)
+		var are prometheus.AlreadyRegisteredError
+		if err != nil && !errors.As(err, &are) {
+			return nil, err
+		}
+		// Likewise, the consumers of servers sharing a registry are only listed by the Admin service
+	}
+
+	grpcOpts := append([]grpc.ServerOption{}, config.ServerOptions...)
+	grpcOpts = append(grpcOpts, grpc.StreamInterceptor(
 		grpc_middleware.ChainStreamServer(
-			grpc_auth.StreamServerInterceptor(authenticate),
+			obs.streamInterceptor(),
+			errorDetailsStreamInterceptor(),
+			grpc_auth.StreamServerInterceptor(authenticator(config)),
+			quotas.streamInterceptor(),
+			streams.streamInterceptor(),
 		)), grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
-		grpc_auth.UnaryServerInterceptor(authenticate),
+		obs.unaryInterceptor(),
+		errorDetailsUnaryInterceptor(),
+		grpc_auth.UnaryServerInterceptor(authenticator(config)),
+		quotas.unaryInterceptor(),
 	)))
+	if config.TLSConfig != nil {
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(config.TLSConfig)))
+	}
+	if config.MaxMsgSize > 0 {
+		grpcOpts = append(grpcOpts,
+			grpc.MaxRecvMsgSize(config.MaxMsgSize),
+			grpc.MaxSendMsgSize(config.MaxMsgSize),
+		)
+	}
 
 	// Create a new gRPC server instance
-	gsrv := grpc.NewServer(opts...)
+	gsrv := grpc.NewServer(grpcOpts...)
 
 	// Create a new grpcServer instance using the provided configuration
-	srv, err := newgrpcServer(config)
+	srv, err := newgrpcServer(config, consumers)
 	if err != nil {
 		return nil, err // Return an error if the server initialization fails
 	}
 
-	// Register the grpcServer as the implementation of the LogServer
+	// Register the grpcServer as the implementation of the LogServer, and serve
+	// the v2 API, which addresses records by topic and partition, alongside it
 	api.RegisterLogServer(gsrv, srv)
+	apiv2.RegisterLogServer(gsrv, &grpcServerV2{v1: srv})
+	api.RegisterAdminServer(gsrv, &adminServer{Config: config, consumers: consumers})
+
+	// Register the troubleshooting services if enabled
+	if config.EnableDebug {
+		channelz.RegisterChannelzServiceToServer(gsrv)
+		api.RegisterDebugServer(gsrv, &debugServer{Config: config, streams: streams})
+	}
 
 	// Return the configured gRPC server
 	return gsrv, nil
 }
 
-func authenticate(ctx context.Context) (context.Context, error) {
-	peer, ok := peer.FromContext(ctx)
-	if !ok {
-		return ctx, status.New(
-			codes.Unknown,
-			"couldn't find peer info",
-		).Err()
+// authenticator returns the function authenticating clients by the subjects the config's rules
+// extract from their verified certificates or, without one, by their bearer tokens if minted by
+// the TokenIssuer or validated by the TokenValidator, or as the AnonymousSubject if insecure.
+func authenticator(config *Config) grpc_auth.AuthFunc {
+	authenticate := authenticatorFunc(config)
+	return func(ctx context.Context) (context.Context, error) {
+		authCtx, err := authenticate(ctx)
+		if err != nil {
+			method, _ := grpc.Method(ctx)
+			var addr string
+			if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+				addr = p.Addr.String()
+			}
+			config.Logger.WarnContext(ctx, "authentication failed",
+				slog.String("method", method),
+				slog.String("peer", addr),
+				slog.String("error", status.Convert(err).Message()),
+			)
+		}
+		return authCtx, err
 	}
+}
 
-	if peer.AuthInfo == nil {
-		return ctx, status.New(
-			codes.Unauthenticated,
-			"no transport security being used",
-		).Err()
-	}
+// authenticatorFunc authenticates the caller, as authenticator does, without logging failures.
+func authenticatorFunc(config *Config) grpc_auth.AuthFunc {
+	return func(ctx context.Context) (context.Context, error) {
+		peer, ok := peer.FromContext(ctx)
+		if !ok {
+			return ctx, status.New(
+				codes.Unknown,
+				"couldn't find peer info",
+			).Err()
+		}
 
-	tlsInfo := peer.AuthInfo.(credentials.TLSInfo)
-	subject := tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
-	ctx = context.WithValue(ctx, subjectContextKey{}, subject)
+		tlsInfo, ok := peer.AuthInfo.(credentials.TLSInfo)
+		if !ok || len(tlsInfo.State.VerifiedChains) == 0 {
+			token, err := grpc_auth.AuthFromMD(ctx, "bearer")
+			if err == nil && config.TokenIssuer != nil && config.TokenIssuer.Minted(token) {
+				subject, id, scopes, err := config.TokenIssuer.Validate(ctx, token)
+				if err != nil {
+					return ctx, status.Errorf(codes.Unauthenticated, "invalid bearer token: %v", err)
+				}
+				ctx = context.WithValue(ctx, tokenContextKey{}, &tokenGrant{id: id, scopes: scopes})
+				return context.WithValue(ctx, subjectContextKey{}, subject), nil
+			}
+			if err == nil && config.TokenValidator != nil {
+				subject, err := config.TokenValidator.Validate(ctx, token)
+				if err != nil {
+					return ctx, status.Errorf(codes.Unauthenticated, "invalid bearer token: %v", err)
+				}
+				return context.WithValue(ctx, subjectContextKey{}, subject), nil
+			}
+			if config.Insecure {
+				return context.WithValue(ctx, subjectContextKey{}, AnonymousSubject), nil
+			}
+			return ctx, status.New(
+				codes.Unauthenticated,
+				"no verified client certificate",
+			).Err()
+		}
 
-	return ctx, nil
+		subject, err := certSubject(tlsInfo.State.VerifiedChains[0][0], config.SubjectRules)
+		if err != nil {
+			return ctx, status.Error(codes.Unauthenticated, err.Error())
+		}
+		ctx = context.WithValue(ctx, subjectContextKey{}, subject)
+
+		return ctx, nil
+	}
 }
 
 func subject(ctx context.Context) string {
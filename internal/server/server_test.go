@@ -2,19 +2,37 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"net"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	api "github.com/glauco/proglog/api/v1"
 	"github.com/glauco/proglog/internal/auth"
 	"github.com/glauco/proglog/internal/config"
 	"github.com/glauco/proglog/internal/log"
+	"github.com/glauco/proglog/internal/version"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 // TestServer runs multiple scenarios to verify the behavior of the gRPC server.
@@ -26,10 +44,19 @@ func TestServer(t *testing.T) {
 		nobodyClient api.LogClient,
 		config *Config,
 	){
-		"produce/consume a message to/from the log succeeds": testProduceConsume,
-		"produce/consume stream succeeds":                    testProduceConsumeStream,
-		"consume past log boundary fails":                    testConsumePastBoundary,
-		"unauthorized fails":                                 unauthorized,
+		"produce/consume a message to/from the log succeeds":  testProduceConsume,
+		"produce/consume stream succeeds":                     testProduceConsumeStream,
+		"consume past log boundary fails":                     testConsumePastBoundary,
+		"unauthorized fails":                                  unauthorized,
+		"produce with expected offset":                        testProduceExpectedOffset,
+		"consume relative to the head of the log":             testConsumeRelative,
+		"long-poll consume waits for the record":              testConsumeLongPoll,
+		"consume with a session token reads your writes":      testSessionToken,
+		"linearizable consume waits for the read index":       testLinearizableConsume,
+		"get servers lists the cluster":                       testGetServers,
+		"get version returns the server's build":              testGetVersion,
+		"watch servers streams the cluster's changes":         testWatchServers,
+		"produce with replicated acks needs a replicated log": testProduceReplicatedAcks,
 	} {
 		// Run each scenario as a sub-test for better isolation and reporting
 		t.Run(scenario, func(t *testing.T) {
@@ -44,6 +71,14 @@ func TestServer(t *testing.T) {
 // It starts a gRPC server, creates a log client, and returns a teardown function to clean up resources.
 func setupTest(t *testing.T, fn func(*Config)) (rootClient api.LogClient, nobodyClient api.LogClient, cfg *Config, teardown func()) {
 	t.Helper()
+	rootConn, nobodyConn, cfg, teardown := setupTestConns(t, fn)
+	return api.NewLogClient(rootConn), api.NewLogClient(nobodyConn), cfg, teardown
+}
+
+// setupTestConns sets up the same environment as setupTest but returns the client connections,
+// for tests that call services other than Log.
+func setupTestConns(t *testing.T, fn func(*Config)) (rootConn, nobodyConn *grpc.ClientConn, cfg *Config, teardown func()) {
+	t.Helper()
 
 	// Start a TCP listener on a random available port
 	l, err := net.Listen("tcp", "127.0.0.1:0")
@@ -71,14 +106,12 @@ func setupTest(t *testing.T, fn func(*Config)) (rootClient api.LogClient, nobody
 		return conn, client, opts
 	}
 
-	var rootConn *grpc.ClientConn
-	rootConn, rootClient, _ = newClient(
+	rootConn, _, _ = newClient(
 		config.RootClientCertFile,
 		config.RootClientKeyFile,
 	)
 
-	var nobodyConn *grpc.ClientConn
-	nobodyConn, nobodyClient, _ = newClient(
+	nobodyConn, _, _ = newClient(
 		config.NobodyClientCertFile,
 		config.NobodyClientKeyFile,
 	)
@@ -109,10 +142,9 @@ func setupTest(t *testing.T, fn func(*Config)) (rootClient api.LogClient, nobody
 		Server:        true,
 	})
 	require.NoError(t, err)
-	serverCreds := credentials.NewTLS(serverTLSConfig)
 
 	// Create the gRPC server using the configuration
-	server, err := NewGRPCServer(cfg, grpc.Creds(serverCreds))
+	server, err := NewGRPCServer(cfg, WithTLS(serverTLSConfig))
 	require.NoError(t, err)
 
 	// Start the server in a separate goroutine
@@ -120,8 +152,8 @@ func setupTest(t *testing.T, fn func(*Config)) (rootClient api.LogClient, nobody
 		server.Serve(l)
 	}()
 
-	// Return the client, configuration, and a teardown function to clean up resources
-	return rootClient, nobodyClient, cfg, func() {
+	// Return the connections, configuration, and a teardown function to clean up resources
+	return rootConn, nobodyConn, cfg, func() {
 		server.Stop()      // Stop the gRPC server
 		rootConn.Close()   // Close the client connection
 		nobodyConn.Close() // Close the client connection
@@ -150,6 +182,19 @@ func testProduceConsume(t *testing.T, client api.LogClient, _ api.LogClient, con
 	// Verify that the consumed value matches the produced value
 	require.Equal(t, want.Value, consume.Record.Value)
 	require.Equal(t, produce.Offset, consume.Record.Offset)
+	// Verify that the record carries the append time returned to the producer
+	require.NotNil(t, produce.AppendTime)
+	require.True(t, proto.Equal(produce.AppendTime, consume.Record.AppendTime))
+	// Verify that both responses report the high watermark, one past the only record
+	require.Equal(t, uint64(1), produce.HighWatermark)
+	require.Equal(t, uint64(1), consume.HighWatermark)
+
+	// After another record is produced, consuming the first one shows a lag of one record
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: want})
+	require.NoError(t, err)
+	consume, err = client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), consume.HighWatermark-(consume.Record.Offset+1))
 }
 
 // testProduceConsumeStream tests that records can be produced and consumed using gRPC streaming.
@@ -181,6 +226,7 @@ func testProduceConsumeStream(t *testing.T, client api.LogClient, _ api.LogClien
 			res, err := stream.Recv()
 			require.NoError(t, err)
 			require.Equal(t, res.Offset, uint64(offset)) // Verify the offset matches the expected value
+			require.NotNil(t, res.AppendTime)            // Verify the server stamped the record
 		}
 	}
 
@@ -194,10 +240,9 @@ func testProduceConsumeStream(t *testing.T, client api.LogClient, _ api.LogClien
 		for i, record := range records {
 			res, err := stream.Recv()
 			require.NoError(t, err)
-			require.Equal(t, res.Record, &api.Record{
-				Value:  record.Value,
-				Offset: uint64(i),
-			}) // Verify the received record matches the expected value
+			require.Equal(t, record.Value, res.Record.Value) // Verify the received record matches the expected value
+			require.Equal(t, uint64(i), res.Record.Offset)
+			require.NotNil(t, res.Record.AppendTime)
 		}
 	}
 }
@@ -220,6 +265,14 @@ func testConsumePastBoundary(t *testing.T, client api.LogClient, _ api.LogClient
 	got := status.Code(err) // Get the gRPC error code
 	want := status.Code(api.ErrOffsetOutOfRange{}.GRPCStatus().Err())
 	require.Equal(t, want, got) // Ensure the error code matches "offset out of range"
+	require.Equal(t, codes.OutOfRange, got)
+
+	// Ensure the error carries the requested offset and the log's current range
+	info := errorInfo(t, err)
+	require.Equal(t, api.ReasonOffsetOutOfRange, info.Reason)
+	require.Equal(t, "1", info.Metadata["offset"])
+	require.Equal(t, "0", info.Metadata["lowest_offset"])
+	require.Equal(t, "1", info.Metadata["next_offset"])
 }
 
 func unauthorized(t *testing.T, _ api.LogClient, client api.LogClient, config *Config) {
@@ -243,3 +296,1397 @@ func unauthorized(t *testing.T, _ api.LogClient, client api.LogClient, config *C
 	gotCode, wantCode = status.Code(err), codes.PermissionDenied
 	require.Equal(t, wantCode, gotCode)
 }
+
+// testProduceExpectedOffset tests that a produce with an expected offset fails once the log head has moved.
+func testProduceExpectedOffset(t *testing.T, client api.LogClient, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+	record := &api.Record{
+		Value: []byte("hello world"),
+	}
+
+	// Produce at the current head of the log
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record:         record,
+		ExpectedOffset: proto.Uint64(0),
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), produce.Offset)
+
+	// Producing again with the same expected offset fails with FailedPrecondition
+	produce, err = client.Produce(ctx, &api.ProduceRequest{
+		Record:         record,
+		ExpectedOffset: proto.Uint64(0),
+	})
+	require.Nil(t, produce)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+// testConsumeRelative tests that negative relative offsets address records counting back from the head of the log.
+func testConsumeRelative(t *testing.T, client api.LogClient, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	// Produce a few records to the log
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte(fmt.Sprintf("message %d", i))},
+		})
+		require.NoError(t, err)
+	}
+
+	// -1 addresses the most recent record
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{RelativeOffset: -1})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), consume.Record.Offset)
+
+	// Reaching past the start of the log clamps to the lowest offset
+	consume, err = client.Consume(ctx, &api.ConsumeRequest{RelativeOffset: -10})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), consume.Record.Offset)
+
+	// Positive relative offsets are rejected
+	_, err = client.Consume(ctx, &api.ConsumeRequest{RelativeOffset: 1})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// A stream starting two records back receives the last two records
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{RelativeOffset: -2})
+	require.NoError(t, err)
+	for _, want := range []uint64{1, 2} {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, want, res.Record.Offset)
+	}
+}
+
+// testConsumeLongPoll tests that a Consume with max_wait_ms blocks until the record is appended or the wait expires.
+func testConsumeLongPoll(t *testing.T, client api.LogClient, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	// Without a record, the long-poll times out with OutOfRange
+	_, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 0, MaxWaitMs: 50})
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+
+	// Produce the record while the long-poll is waiting
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}()
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 0, MaxWaitMs: 5000})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), consume.Record.Value)
+}
+
+// testSessionToken tests that reads presenting a session token are only served once the
+// server has the write the token identifies.
+func testSessionToken(t *testing.T, client api.LogClient, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	// Producing returns a token for the write...
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, produce.SessionToken)
+
+	// ...which lets the producer read its own write
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{
+		Offset:       produce.Offset,
+		SessionToken: produce.SessionToken,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), consume.Record.Value)
+
+	// A token for a write the server doesn't have yet makes the read fail so it can be retried
+	_, err = client.Consume(ctx, &api.ConsumeRequest{
+		Offset:       produce.Offset,
+		SessionToken: newSessionToken(5),
+	})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	info := errorInfo(t, err)
+	require.Equal(t, api.ReasonReplicaBehind, info.Reason)
+	require.Equal(t, "5", info.Metadata["offset"])
+	require.Equal(t, "1", info.Metadata["next_offset"])
+
+	// Malformed tokens are rejected
+	_, err = client.Consume(ctx, &api.ConsumeRequest{
+		Offset:       produce.Offset,
+		SessionToken: []byte("bogus"),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// testLinearizableConsume tests that linearizable reads are only served once the log holds every
+// acknowledged write, and fail when it can't tell.
+func testLinearizableConsume(t *testing.T, client api.LogClient, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+
+	// Logs that aren't replicated serve linearizable reads as is
+	req := &api.ConsumeRequest{Offset: produce.Offset, Linearizable: true}
+	_, err = client.Consume(ctx, req)
+	require.NoError(t, err)
+
+	// Replicated ones wait for the read index first, and only for linearizable reads
+	var (
+		readIndexes  int
+		readIndexErr error
+	)
+	config.CommitLog = linearizableLog{CommitLog: config.CommitLog, readIndex: func(context.Context) error {
+		readIndexes++
+		return readIndexErr
+	}}
+	_, err = client.Consume(ctx, req)
+	require.NoError(t, err)
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.Equal(t, 1, readIndexes)
+
+	readIndexErr = api.NewError(codes.Unavailable, api.ReasonUnavailable, "not the leader", nil)
+	_, err = client.Consume(ctx, req)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	stream, err := client.ConsumeStream(ctx, req)
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+// TestErrorDetails verifies that errors carry an ErrorInfo reason clients can branch on,
+// and a RetryInfo when retrying may succeed.
+func TestErrorDetails(t *testing.T) {
+	rootClient, nobodyClient, _, teardown := setupTest(t, func(c *Config) {
+		c.MaxRecordBytes = 16
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	// Records over the size limit are rejected
+	_, err := rootClient.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("a record well over sixteen bytes")},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	info := errorInfo(t, err)
+	require.Equal(t, api.ReasonRecordTooLarge, info.Reason)
+	require.Equal(t, api.ErrorDomain, info.Domain)
+	require.Equal(t, "16", info.Metadata["max_size"])
+
+	// Authorization failures name the denied action
+	_, err = nobodyClient.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	info = errorInfo(t, err)
+	require.Equal(t, api.ReasonUnauthorized, info.Reason)
+	require.Equal(t, "consume", info.Metadata["action"])
+
+	// Malformed requests are reported as such
+	_, err = rootClient.Consume(ctx, &api.ConsumeRequest{RelativeOffset: 1})
+	require.Equal(t, api.ReasonInvalidRequest, errorInfo(t, err).Reason)
+	_, err = rootClient.Produce(ctx, &api.ProduceRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Equal(t, api.ReasonInvalidRequest, errorInfo(t, err).Reason)
+
+	// Retryable errors tell clients how long to back off
+	_, err = rootClient.Consume(ctx, &api.ConsumeRequest{SessionToken: newSessionToken(1)})
+	require.Equal(t, api.ReasonReplicaBehind, errorInfo(t, err).Reason)
+	var retry *errdetails.RetryInfo
+	for _, d := range status.Convert(err).Details() {
+		if r, ok := d.(*errdetails.RetryInfo); ok {
+			retry = r
+		}
+	}
+	require.NotNil(t, retry)
+	require.Equal(t, api.DefaultRetryDelay, retry.RetryDelay.AsDuration())
+}
+
+// errorInfo returns the ErrorInfo detail of a gRPC error, failing the test if there is none.
+func errorInfo(t *testing.T, err error) *errdetails.ErrorInfo {
+	t.Helper()
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info
+		}
+	}
+	require.FailNow(t, "error has no ErrorInfo detail", "%v", err)
+	return nil
+}
+
+// TestConfigValidate verifies that servers can't be built without their required dependencies.
+func TestConfigValidate(t *testing.T) {
+	_, err := NewGRPCServer(&Config{})
+	require.Error(t, err)
+
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Remove()
+
+	_, err = NewGRPCServer(&Config{CommitLog: clog})
+	require.Error(t, err)
+
+	_, err = NewGRPCServer(
+		&Config{CommitLog: clog},
+		WithAuthorizer(auth.New(config.ACLModelFile, config.ACLPolicyFile)),
+		WithMaxMsgSize(-1),
+	)
+	require.Error(t, err)
+}
+
+// TestInsecure verifies that insecure servers accept plaintext clients as the anonymous
+// subject, while other servers reject them.
+func TestInsecure(t *testing.T) {
+	ctx := context.Background()
+	for scenario, test := range map[string]struct {
+		opts    []Option
+		produce codes.Code
+		consume codes.Code
+	}{
+		"secure server rejects plaintext clients": {
+			opts:    []Option{WithAuthorizer(auth.New(config.ACLModelFile, config.ACLPolicyFile))},
+			produce: codes.Unauthenticated,
+			consume: codes.Unauthenticated,
+		},
+		"insecure server allows anything": {opts: []Option{Insecure()}},
+		"insecure server authorizes the anonymous subject": {
+			opts: []Option{Insecure(), WithAuthorizer(subjectAuthorizer{AnonymousSubject: consumeAction})},
+			// The authorizer only allows the anonymous subject to consume
+			produce: codes.PermissionDenied,
+			consume: codes.OutOfRange,
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			clog, err := log.NewLog(t.TempDir(), log.Config{})
+			require.NoError(t, err)
+			defer clog.Remove()
+			srv, err := NewGRPCServer(&Config{CommitLog: clog}, test.opts...)
+			require.NoError(t, err)
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			go srv.Serve(l)
+			defer srv.Stop()
+
+			conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+			require.NoError(t, err)
+			defer conn.Close()
+			client := api.NewLogClient(conn)
+			_, err = client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}})
+			require.Equal(t, test.produce, status.Code(err))
+			_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+			require.Equal(t, test.consume, status.Code(err))
+		})
+	}
+}
+
+// TestTokenAuth verifies that clients without certificates authenticate with bearer tokens
+// when the server has a token validator.
+func TestTokenAuth(t *testing.T) {
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Remove()
+	srv, err := NewGRPCServer(
+		&Config{CommitLog: clog},
+		WithTokenValidator(tokenValidator{"alice-token": "alice"}),
+		WithAuthorizer(subjectAuthorizer{"alice": produceAction}),
+	)
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := api.NewLogClient(conn)
+	for scenario, test := range map[string]struct {
+		authorization string
+		code          codes.Code
+	}{
+		"valid token":   {authorization: "Bearer alice-token", code: codes.OK},
+		"invalid token": {authorization: "Bearer bogus", code: codes.Unauthenticated},
+		"other scheme":  {authorization: "Basic YWxpY2U6", code: codes.Unauthenticated},
+		"no token":      {code: codes.Unauthenticated},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			ctx := context.Background()
+			if test.authorization != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", test.authorization)
+			}
+			_, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}})
+			require.Equal(t, test.code, status.Code(err))
+		})
+	}
+}
+
+// TestCreateToken verifies that scoped tokens authenticate as their subjects, but are only
+// permitted the actions of their scopes, and that only subjects allowed to may mint them.
+func TestCreateToken(t *testing.T) {
+	rootConn, _, _, teardown := setupTestConns(t, nil)
+	defer teardown()
+	ctx := context.Background()
+	consume := []*api.TokenScope{{Object: defaultTopic, Action: consumeAction}}
+	_, err := api.NewAdminClient(rootConn).CreateToken(ctx, &api.CreateTokenRequest{Scopes: consume})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Remove()
+	signer, err := auth.NewTokenSigner(auth.TokenSignerConfig{Keys: [][]byte{[]byte(strings.Repeat("k", 32))}})
+	require.NoError(t, err)
+	auditor := &recordingAuditor{}
+	srv, err := NewGRPCServer(
+		&Config{CommitLog: clog},
+		WithTokenValidator(tokenValidator{"root-token": "root", "nobody-token": "nobody"}),
+		WithTokenIssuer(signer),
+		WithAuthorizer(auth.New(config.ACLModelFile, config.ACLPolicyFile)),
+		WithAuditor(auditor),
+	)
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l)
+	defer srv.Stop()
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	admin, client := api.NewAdminClient(conn), api.NewLogClient(conn)
+	as := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+	record := &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}}
+
+	// Root's token consuming the default topic may consume, but not produce, though root may
+	minted, err := admin.CreateToken(as("root-token"), &api.CreateTokenRequest{Scopes: consume, Ttl: durationpb.New(time.Minute)})
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Minute), minted.Expires.AsTime(), 2*time.Second)
+	_, err = client.Produce(as("root-token"), record)
+	require.NoError(t, err)
+	_, err = client.Consume(as(minted.Token), &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	_, err = client.Produce(as(minted.Token), record)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	events := auditor.list()
+	require.Equal(t, "root", events[len(events)-1].Subject)
+	require.Equal(t, minted.Id, events[len(events)-1].Token)
+	require.False(t, events[len(events)-1].Allowed)
+	_, err = client.Consume(as(minted.Token[:len(minted.Token)-4]+"AAAA"), &api.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// The ACL still applies to the subjects of tokens minted for them
+	forNobody, err := admin.CreateToken(as("root-token"), &api.CreateTokenRequest{Scopes: consume, Subject: "nobody"})
+	require.NoError(t, err)
+	_, err = client.Consume(as(forNobody.Token), &api.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	for scenario, test := range map[string]struct {
+		token string
+		req   *api.CreateTokenRequest
+		code  codes.Code
+	}{
+		"no scopes":            {"root-token", &api.CreateTokenRequest{}, codes.InvalidArgument},
+		"negative ttl":         {"root-token", &api.CreateTokenRequest{Scopes: consume, Ttl: durationpb.New(-time.Minute)}, codes.InvalidArgument},
+		"scope without action": {"root-token", &api.CreateTokenRequest{Scopes: []*api.TokenScope{{Object: defaultTopic}}}, codes.InvalidArgument},
+		"nobody for itself":    {"nobody-token", &api.CreateTokenRequest{Scopes: consume}, codes.PermissionDenied},
+		"nobody for root":      {"nobody-token", &api.CreateTokenRequest{Scopes: consume, Subject: "root"}, codes.PermissionDenied},
+		"scoped token":         {minted.Token, &api.CreateTokenRequest{Scopes: consume}, codes.PermissionDenied},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			_, err := admin.CreateToken(as(test.token), test.req)
+			require.Equal(t, test.code, status.Code(err))
+		})
+	}
+
+	// Even tokens scoped to anything can't mint tokens, which could outlive them
+	wide, err := admin.CreateToken(as("root-token"), &api.CreateTokenRequest{Scopes: []*api.TokenScope{{Object: "*", Action: "*"}}})
+	require.NoError(t, err)
+	_, err = client.Produce(as(wide.Token), record)
+	require.NoError(t, err)
+	_, err = admin.CreateToken(as(wide.Token), &api.CreateTokenRequest{Scopes: consume})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// tokenValidator validates the tokens it maps to their subjects.
+type tokenValidator map[string]string
+
+func (v tokenValidator) Validate(_ context.Context, token string) (string, error) {
+	subject, ok := v[token]
+	if !ok {
+		return "", fmt.Errorf("unknown token")
+	}
+	return subject, nil
+}
+
+// TestAuditor verifies that every authorization decision is audited, allowed and denied alike.
+func TestAuditor(t *testing.T) {
+	auditor := &recordingAuditor{}
+	rootClient, nobodyClient, _, teardown := setupTest(t, func(c *Config) {
+		c.Auditor = auditor
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := rootClient.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}})
+	require.NoError(t, err)
+	_, err = nobodyClient.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	events := auditor.list()
+	require.Len(t, events, 2)
+	require.Equal(t, "root", events[0].Subject)
+	require.Equal(t, defaultTopic, events[0].Object)
+	require.Equal(t, produceAction, events[0].Action)
+	require.Equal(t, api.Log_Produce_FullMethodName, events[0].Method)
+	require.True(t, events[0].Allowed)
+	require.Empty(t, events[0].Reason)
+	require.Contains(t, events[0].Peer, "127.0.0.1:")
+	require.Equal(t, "nobody", events[1].Subject)
+	require.Equal(t, consumeAction, events[1].Action)
+	require.Equal(t, api.Log_Consume_FullMethodName, events[1].Method)
+	require.False(t, events[1].Allowed)
+	require.NotEmpty(t, events[1].Reason)
+}
+
+// TestAuditStreams verifies that consume streams are audited once when they open, not every time
+// they poll the log for records while idle.
+func TestAuditStreams(t *testing.T) {
+	auditor := &recordingAuditor{}
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		c.Auditor = auditor
+	})
+	defer teardown()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	subscription, err := client.Subscribe(ctx)
+	require.NoError(t, err)
+	require.NoError(t, subscription.Send(&api.SubscribeRequest{
+		Command: &api.SubscribeRequest_Seek{Seek: &api.ConsumeRequest{Offset: 0}},
+	}))
+
+	// Both streams poll the empty log every readWaitInterval meanwhile
+	time.Sleep(20 * readWaitInterval)
+	methods := make(map[string]int)
+	for _, event := range auditor.list() {
+		methods[event.Method]++
+	}
+	require.Equal(t, map[string]int{
+		api.Log_ConsumeStream_FullMethodName: 1,
+		api.Log_Subscribe_FullMethodName:     1,
+	}, methods)
+}
+
+// recordingAuditor records the events it's given.
+type recordingAuditor struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (a *recordingAuditor) Audit(event AuditEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.events = append(a.events, event)
+}
+
+func (a *recordingAuditor) list() []AuditEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]AuditEvent(nil), a.events...)
+}
+
+// subjectAuthorizer allows each subject the action it maps to, on any object.
+type subjectAuthorizer map[string]string
+
+func (a subjectAuthorizer) Authorize(subject, object, action string) error {
+	if a[subject] != action {
+		return status.Error(codes.PermissionDenied, "denied")
+	}
+	return nil
+}
+
+// TestServerMetrics verifies that RPCs are counted when metrics are enabled.
+func TestServerMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		c.Metrics = registry
+	})
+	defer teardown()
+
+	_, err := client.Produce(context.Background(), &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+
+	count, err := testutil.GatherAndCount(registry, "proglog_grpc_requests_total")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+// TestServerTracing verifies that produces continue the trace of their traceparent metadata, down
+// to the log's appends.
+func TestServerTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		clog, err := log.NewLog(t.TempDir(), log.Config{TracerProvider: provider})
+		require.NoError(t, err)
+		c.CommitLog = clog
+		c.TracerProvider = provider
+	})
+	defer teardown()
+
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "traceparent", traceparent)
+	_, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+		spans[span.Name()] = span
+	}
+	rpc := spans["log.v1.Log/Produce"]
+	require.NotNil(t, rpc)
+	require.Equal(t, "00f067aa0ba902b7", rpc.Parent().SpanID().String())
+	require.Equal(t, rpc.SpanContext().SpanID(), spans["Log.Append"].Parent().SpanID())
+	for _, name := range []string{"Log.lock", "segment.Append", "proto.Marshal", "store.Append", "index.Write"} {
+		require.Contains(t, spans, name)
+	}
+}
+
+// TestConsumeStreamIdleTimeout verifies that idle ConsumeStreams are closed by the server.
+func TestConsumeStreamIdleTimeout(t *testing.T) {
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		c.StreamIdleTimeout = 100 * time.Millisecond
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	_, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+
+	// The stream delivers the existing record, then is closed once it stays idle
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+// TestQuotas verifies that subjects exceeding their byte-rate quota are throttled with a retry hint.
+func TestQuotas(t *testing.T) {
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		c.Quotas = QuotaConfig{ReceiveBytesPerSecond: 64}
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	req := &api.ProduceRequest{
+		Record: &api.Record{Value: make([]byte, 40)},
+	}
+	// The first produce fits in the burst, the second exceeds the quota
+	_, err := client.Produce(ctx, req)
+	require.NoError(t, err)
+	_, err = client.Produce(ctx, req)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// The error tells the client how long to back off
+	var retry *errdetails.RetryInfo
+	for _, d := range status.Convert(err).Details() {
+		if r, ok := d.(*errdetails.RetryInfo); ok {
+			retry = r
+		}
+	}
+	require.NotNil(t, retry)
+	require.Greater(t, retry.RetryDelay.AsDuration(), time.Duration(0))
+
+	// Once the subject backs off, it may produce again
+	time.Sleep(retry.RetryDelay.AsDuration())
+	_, err = client.Produce(ctx, req)
+	require.NoError(t, err)
+}
+
+// TestQuotaEviction verifies that the limiters of subjects idle long enough for their buckets to
+// refill are evicted, while those of active subjects keep their debt.
+func TestQuotaEviction(t *testing.T) {
+	limiters := newSubjectLimiters(64, 0)
+	limiters.charge("idle", 32)
+	limiters.charge("active", 64)
+	now := time.Now()
+
+	// Half a second in, the idle subject's bucket is full again, unlike the active one's
+	limiters.sweep(now.Add(500 * time.Millisecond))
+	require.NotContains(t, limiters.limiters, "idle")
+	require.Contains(t, limiters.limiters, "active")
+	limiters.sweep(now.Add(time.Second))
+	require.Empty(t, limiters.limiters)
+
+	// Sweeps run as limiters are looked up, once per interval
+	limiters.swept = now.Add(-limiterSweepInterval)
+	limiters.limiters["gone"] = rate.NewLimiter(limiters.limit, limiters.burst)
+	limiters.get("new")
+	require.NotContains(t, limiters.limiters, "gone")
+	require.Contains(t, limiters.limiters, "new")
+}
+
+// TestQuotaRules verifies that the records subjects produce and consume are limited by the quota
+// rules of the Authorizer's policy: unary requests over quota are rejected, and streams slowed
+// down.
+func TestQuotaRules(t *testing.T) {
+	acl, err := os.ReadFile(config.ACLPolicyFile)
+	require.NoError(t, err)
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	require.NoError(t, os.WriteFile(policy, append(acl, []byte("\n"+
+		"q, root, *, produce, 2, 0\n"+
+		"q, root, *, consume, 1, 0\n")...), 0644))
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		c.Authorizer = auth.New(config.ACLModelFile, policy)
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	req := &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}}
+	for range 2 {
+		_, err := client.Produce(ctx, req)
+		require.NoError(t, err)
+	}
+	_, err = client.Produce(ctx, req)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.ErrorContains(t, err, "produce_records")
+
+	// The consume quota lets a record through at once, and the next a second later
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	start := time.Now()
+	for range 2 {
+		_, err := stream.Recv()
+		require.NoError(t, err)
+	}
+	require.Greater(t, time.Since(start), 900*time.Millisecond)
+
+	// Unary consumes are charged once served, their records' size unknown until then, so the
+	// next is rejected while the subject is in debt
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.ErrorContains(t, err, "consume_records")
+}
+
+// TestDebugListStreams verifies that the Debug service reports open consume streams with their subject and offset.
+func TestDebugListStreams(t *testing.T) {
+	rootConn, nobodyConn, _, teardown := setupTestConns(t, func(c *Config) {
+		c.EnableDebug = true
+	})
+	defer teardown()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := api.NewLogClient(rootConn)
+
+	_, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+
+	// Open a stream and read the only record, leaving the stream waiting at offset 1
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	debug := api.NewDebugClient(rootConn)
+	require.Eventually(t, func() bool {
+		res, err := debug.ListStreams(ctx, &api.ListStreamsRequest{})
+		require.NoError(t, err)
+		return len(res.Streams) == 1 &&
+			res.Streams[0].Subject == "root" &&
+			res.Streams[0].Offset == 1 &&
+			res.Streams[0].Lag == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// Subjects without admin permissions can't inspect the streams
+	_, err = api.NewDebugClient(nobodyConn).ListStreams(ctx, &api.ListStreamsRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// TestAdminPromoteServer verifies that PromoteServer promotes servers through the ClusterAdmin,
+// to subjects with admin permissions on the cluster.
+func TestAdminPromoteServer(t *testing.T) {
+	rootConn, nobodyConn, config, teardown := setupTestConns(t, nil)
+	defer teardown()
+	ctx := context.Background()
+	admin := api.NewAdminClient(rootConn)
+
+	_, err := admin.PromoteServer(ctx, &api.PromoteServerRequest{Id: "1"})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+
+	var promoted []string
+	config.ClusterAdmin = clusterAdmin{promote: func(id string) error {
+		promoted = append(promoted, id)
+		return nil
+	}}
+	_, err = admin.PromoteServer(ctx, &api.PromoteServerRequest{Id: "1"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1"}, promoted)
+	_, err = admin.PromoteServer(ctx, &api.PromoteServerRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Subjects without admin permissions on the cluster can't promote servers
+	_, err = api.NewAdminClient(nobodyConn).PromoteServer(ctx, &api.PromoteServerRequest{Id: "1"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.Equal(t, []string{"1"}, promoted)
+}
+
+// TestAdminDescribe verifies that servers describe their own replica of the log, and the
+// cluster's replicas as the ClusterAdmin reports them.
+func TestAdminDescribe(t *testing.T) {
+	rootConn, nobodyConn, config, teardown := setupTestConns(t, nil)
+	defer teardown()
+	ctx := context.Background()
+	admin := api.NewAdminClient(rootConn)
+
+	// An empty replica has no records, so no append time
+	res, err := admin.DescribeReplica(ctx, &api.DescribeReplicaRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), res.Replica.NextOffset)
+	require.Nil(t, res.Replica.LastAppendTime)
+
+	var produced *api.ProduceResponse
+	for i := 0; i < 2; i++ {
+		produced, err = api.NewLogClient(rootConn).Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+	res, err = admin.DescribeReplica(ctx, &api.DescribeReplicaRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), res.Replica.NextOffset)
+	require.True(t, proto.Equal(produced.AppendTime, res.Replica.LastAppendTime))
+
+	_, err = admin.DescribeCluster(ctx, &api.DescribeClusterRequest{})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+	want := []*api.ReplicaStatus{
+		{Server: &api.Server{Id: "leader", IsLeader: true}, NextOffset: 2},
+		{Server: &api.Server{Id: "follower"}, NextOffset: 1, LagRecords: 1},
+	}
+	config.ClusterAdmin = clusterAdmin{describe: func(context.Context) ([]*api.ReplicaStatus, error) {
+		return want, nil
+	}}
+	cluster, err := admin.DescribeCluster(ctx, &api.DescribeClusterRequest{})
+	require.NoError(t, err)
+	require.Len(t, cluster.Replicas, len(want))
+	for i := range want {
+		require.True(t, proto.Equal(want[i], cluster.Replicas[i]))
+	}
+
+	// Subjects without permission to describe the cluster can't inspect it
+	_, err = api.NewAdminClient(nobodyConn).DescribeCluster(ctx, &api.DescribeClusterRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = api.NewAdminClient(nobodyConn).DescribeReplica(ctx, &api.DescribeReplicaRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// TestAdminLeadership verifies that the leadership is transferred and described through the
+// ClusterAdmin, to subjects with the matching permissions on the cluster.
+func TestAdminLeadership(t *testing.T) {
+	rootConn, nobodyConn, config, teardown := setupTestConns(t, nil)
+	defer teardown()
+	ctx := context.Background()
+	admin := api.NewAdminClient(rootConn)
+
+	_, err := admin.TransferLeadership(ctx, &api.TransferLeadershipRequest{Id: "1"})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+	_, err = admin.GetLeadership(ctx, &api.GetLeadershipRequest{})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+
+	var transferred []string
+	want := &api.Leadership{
+		Leader: &api.Server{Id: "1", IsLeader: true, IsVoter: true},
+		Term:   2,
+		State:  api.RaftState_RAFT_STATE_FOLLOWER,
+	}
+	config.ClusterAdmin = clusterAdmin{
+		transfer: func(id string) error {
+			transferred = append(transferred, id)
+			return nil
+		},
+		leadership: func() (*api.Leadership, error) { return want, nil },
+	}
+	_, err = admin.TransferLeadership(ctx, &api.TransferLeadershipRequest{Id: "1"})
+	require.NoError(t, err)
+	_, err = admin.TransferLeadership(ctx, &api.TransferLeadershipRequest{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1", ""}, transferred)
+	res, err := admin.GetLeadership(ctx, &api.GetLeadershipRequest{})
+	require.NoError(t, err)
+	require.True(t, proto.Equal(want, res.Leadership))
+
+	// Subjects without permissions on the cluster can't transfer or see the leadership
+	nobody := api.NewAdminClient(nobodyConn)
+	_, err = nobody.TransferLeadership(ctx, &api.TransferLeadershipRequest{Id: "1"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = nobody.GetLeadership(ctx, &api.GetLeadershipRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.Len(t, transferred, 2)
+}
+
+// TestAdminTopics verifies that topics are created and listed through the ClusterAdmin, to
+// subjects with the matching permissions on the cluster.
+func TestAdminTopics(t *testing.T) {
+	rootConn, nobodyConn, cfg, teardown := setupTestConns(t, nil)
+	defer teardown()
+	ctx := context.Background()
+	admin := api.NewAdminClient(rootConn)
+
+	_, err := admin.CreateTopic(ctx, &api.CreateTopicRequest{Name: "orders"})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+	_, err = admin.ListTopics(ctx, &api.ListTopicsRequest{})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+
+	var topics []*api.Topic
+	cfg.ClusterAdmin = clusterAdmin{
+		create: func(name string, partitions, replicationFactor uint32) (*api.Topic, error) {
+			topic := &api.Topic{Name: name}
+			for i := uint32(0); i < partitions; i++ {
+				topic.Partitions = append(topic.Partitions, &api.PartitionAssignment{
+					Id:       i,
+					Replicas: []*api.Server{{Id: fmt.Sprint(i % replicationFactor)}},
+				})
+			}
+			topics = append(topics, topic)
+			return topic, nil
+		},
+		topics: func() []*api.Topic { return topics },
+	}
+	created, err := admin.CreateTopic(ctx, &api.CreateTopicRequest{
+		Name:              "orders",
+		Partitions:        2,
+		ReplicationFactor: 1,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "orders", created.Topic.Name)
+	require.Len(t, created.Topic.Partitions, 2)
+	res, err := admin.ListTopics(ctx, &api.ListTopicsRequest{})
+	require.NoError(t, err)
+	require.Len(t, res.Topics, 1)
+	require.True(t, proto.Equal(created.Topic, res.Topics[0]))
+	require.False(t, res.RebalancePaused)
+
+	// Subjects without permissions on the cluster can't create or list topics
+	nobody := api.NewAdminClient(nobodyConn)
+	_, err = nobody.CreateTopic(ctx, &api.CreateTopicRequest{Name: "payments"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = nobody.ListTopics(ctx, &api.ListTopicsRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.Len(t, topics, 1)
+
+	// Subjects administering topics may create those only
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte("p, nobody, nobody-*, admin\n"), 0600))
+	cfg.Authorizer = auth.New(config.ACLModelFile, policy)
+	_, err = nobody.CreateTopic(ctx, &api.CreateTopicRequest{Name: "nobody-orders", Partitions: 1, ReplicationFactor: 1})
+	require.NoError(t, err)
+	_, err = nobody.CreateTopic(ctx, &api.CreateTopicRequest{Name: "payments"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.Len(t, topics, 2)
+}
+
+// TestAdminRebalance verifies that rebalancing is triggered and paused through the ClusterAdmin,
+// by subjects allowed to administer the cluster.
+func TestAdminRebalance(t *testing.T) {
+	rootConn, nobodyConn, config, teardown := setupTestConns(t, nil)
+	defer teardown()
+	ctx := context.Background()
+	admin := api.NewAdminClient(rootConn)
+
+	_, err := admin.TriggerRebalance(ctx, &api.TriggerRebalanceRequest{})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+	_, err = admin.PauseRebalance(ctx, &api.PauseRebalanceRequest{Paused: true})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+
+	var paused []bool
+	want := []*api.PartitionMove{{Topic: "orders", Partition: 1, From: "0", To: "2"}}
+	config.ClusterAdmin = clusterAdmin{
+		rebalance: func() ([]*api.PartitionMove, error) { return want, nil },
+		pause: func(p bool) error {
+			paused = append(paused, p)
+			return nil
+		},
+	}
+	res, err := admin.TriggerRebalance(ctx, &api.TriggerRebalanceRequest{})
+	require.NoError(t, err)
+	require.Len(t, res.Moves, 1)
+	require.True(t, proto.Equal(want[0], res.Moves[0]))
+	_, err = admin.PauseRebalance(ctx, &api.PauseRebalanceRequest{Paused: true})
+	require.NoError(t, err)
+	_, err = admin.PauseRebalance(ctx, &api.PauseRebalanceRequest{})
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, false}, paused)
+
+	// Subjects without permission to administer the cluster can't rebalance it
+	nobody := api.NewAdminClient(nobodyConn)
+	_, err = nobody.TriggerRebalance(ctx, &api.TriggerRebalanceRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = nobody.PauseRebalance(ctx, &api.PauseRebalanceRequest{Paused: true})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.Len(t, paused, 2)
+}
+
+// TestAdminConfig verifies that the cluster's configuration is read and set through the
+// ClusterAdmin, and that the quotas it sets replace the server's.
+func TestAdminConfig(t *testing.T) {
+	rootConn, nobodyConn, config, teardown := setupTestConns(t, nil)
+	defer teardown()
+	ctx := context.Background()
+	admin := api.NewAdminClient(rootConn)
+
+	_, err := admin.GetConfig(ctx, &api.GetConfigRequest{})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+
+	cluster := &clusterAdmin{config: &api.ClusterConfig{}}
+	cluster.setConfig = func(c *api.ClusterConfig) (*api.ClusterConfig, error) {
+		c = proto.Clone(c).(*api.ClusterConfig)
+		c.Version = cluster.config.Version + 1
+		cluster.config = c
+		return c, nil
+	}
+	config.ClusterAdmin = cluster
+	_, err = admin.SetConfig(ctx, &api.SetConfigRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	set, err := admin.SetConfig(ctx, &api.SetConfigRequest{Config: &api.ClusterConfig{
+		Quotas: &api.QuotaSettings{ReceiveBytesPerSecond: 64},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), set.Config.Version)
+	res, err := admin.GetConfig(ctx, &api.GetConfigRequest{})
+	require.NoError(t, err)
+	require.True(t, proto.Equal(set.Config, res.Config))
+
+	// The cluster's quotas apply right away: the first produce fits in the burst, the second
+	// exceeds the quota
+	log := api.NewLogClient(rootConn)
+	req := &api.ProduceRequest{Record: &api.Record{Value: make([]byte, 40)}}
+	_, err = log.Produce(ctx, req)
+	require.NoError(t, err)
+	_, err = log.Produce(ctx, req)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// Subjects without permission to administer the cluster can't configure it
+	_, err = api.NewAdminClient(nobodyConn).SetConfig(ctx, &api.SetConfigRequest{Config: &api.ClusterConfig{}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.Equal(t, uint64(1), cluster.config.Version)
+}
+
+// TestAdminAclRules verifies that ACL rules are added, removed and listed through the ClusterAdmin,
+// by subjects administering the cluster or allowed the distinct acl-admin action on it.
+func TestAdminAclRules(t *testing.T) {
+	rootConn, nobodyConn, config, teardown := setupTestConns(t, nil)
+	defer teardown()
+	ctx := context.Background()
+	admin := api.NewAdminClient(rootConn)
+	alice := &api.AclRule{Subject: "alice", Object: "orders", Action: "consume"}
+
+	_, err := admin.AddAclRules(ctx, &api.AddAclRulesRequest{Rules: []*api.AclRule{alice}})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+
+	cluster := &clusterAdmin{config: &api.ClusterConfig{RetentionRecords: 10}}
+	var updates [][2][]*api.AclRule
+	cluster.updateACL = func(add, remove []*api.AclRule) (*api.ClusterConfig, error) {
+		updates = append(updates, [2][]*api.AclRule{add, remove})
+		c := proto.Clone(cluster.config).(*api.ClusterConfig)
+		c.AclRules = append(c.AclRules, add...)
+		if len(remove) > 0 {
+			c.AclRules = nil
+		}
+		c.Version = cluster.config.Version + 1
+		cluster.config = c
+		return c, nil
+	}
+	config.ClusterAdmin = cluster
+	_, err = admin.AddAclRules(ctx, &api.AddAclRulesRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	added, err := admin.AddAclRules(ctx, &api.AddAclRulesRequest{Rules: []*api.AclRule{alice}})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), added.Rules.Version)
+	require.True(t, proto.Equal(alice, added.Rules.Rules[0]))
+	listed, err := admin.ListAclRules(ctx, &api.ListAclRulesRequest{})
+	require.NoError(t, err)
+	require.True(t, proto.Equal(added.Rules, listed.Rules))
+	removed, err := admin.RemoveAclRules(ctx, &api.RemoveAclRulesRequest{Rules: []*api.AclRule{alice}})
+	require.NoError(t, err)
+	require.Empty(t, removed.Rules.Rules)
+	require.Len(t, updates, 2)
+	require.Empty(t, updates[1][0])
+	require.True(t, proto.Equal(alice, updates[1][1][0]))
+
+	// Subjects neither administering the cluster nor its ACL rules can't manage them
+	nobody := api.NewAdminClient(nobodyConn)
+	_, err = nobody.AddAclRules(ctx, &api.AddAclRulesRequest{Rules: []*api.AclRule{alice}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = nobody.ListAclRules(ctx, &api.ListAclRulesRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// The acl-admin action allows managing the rules, but not the rest of the cluster
+	require.NoError(t, config.Authorizer.(*auth.Authorizer).SetRules([]*api.AclRule{
+		{Subject: "nobody", Object: "cluster", Action: "acl-admin"},
+	}))
+	_, err = nobody.AddAclRules(ctx, &api.AddAclRulesRequest{Rules: []*api.AclRule{alice}})
+	require.NoError(t, err)
+	_, err = nobody.ListAclRules(ctx, &api.ListAclRulesRequest{})
+	require.NoError(t, err)
+	_, err = nobody.SetConfig(ctx, &api.SetConfigRequest{Config: &api.ClusterConfig{}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.Len(t, updates, 3)
+}
+
+// TestAdminBackup verifies that backups are streamed from the ClusterAdmin, to subjects with
+// admin permissions on the cluster.
+func TestAdminBackup(t *testing.T) {
+	rootConn, nobodyConn, config, teardown := setupTestConns(t, nil)
+	defer teardown()
+	ctx := context.Background()
+	// recv returns the chunks of the backup streamed to the connection's subject
+	recv := func(conn *grpc.ClientConn) ([]*api.BackupChunk, error) {
+		stream, err := api.NewAdminClient(conn).Backup(ctx, &api.BackupRequest{})
+		require.NoError(t, err)
+		var chunks []*api.BackupChunk
+		for {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				return chunks, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	_, err := recv(rootConn)
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+
+	want := []*api.BackupChunk{
+		{Ranges: []*api.BackupRange{{Topic: "default", NextOffset: 1}}},
+		{Topic: "default", Records: []*api.Record{{Value: []byte("hello world")}}},
+	}
+	config.ClusterAdmin = clusterAdmin{backup: func(ctx context.Context, send func(*api.BackupChunk) error) error {
+		for _, chunk := range want {
+			if err := send(chunk); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+	chunks, err := recv(rootConn)
+	require.NoError(t, err)
+	require.Len(t, chunks, len(want))
+	for i := range want {
+		require.True(t, proto.Equal(want[i], chunks[i]))
+	}
+
+	// Subjects without permission to administer the cluster can't back it up
+	_, err = recv(nobodyConn)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// TestAdminListConsumers verifies that the lag of the consumer groups served by every consume
+// RPC is listed to subjects allowed to describe the cluster, and exported as metrics.
+func TestAdminListConsumers(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	rootConn, nobodyConn, _, teardown := setupTestConns(t, func(c *Config) {
+		c.Metrics = registry
+	})
+	defer teardown()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := api.NewLogClient(rootConn)
+	admin := api.NewAdminClient(rootConn)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte(fmt.Sprintf("message %d", i))},
+		})
+		require.NoError(t, err)
+	}
+
+	// Reads are tracked under their group, or else as the subject's...
+	_, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 1, ConsumerGroup: "billing"})
+	require.NoError(t, err)
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	// ...and streams are tracked as they progress, here up to the head of the log
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 1, ConsumerGroup: "audit"})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = stream.Recv()
+		require.NoError(t, err)
+	}
+
+	var res *api.ListConsumersResponse
+	require.Eventually(t, func() bool {
+		res, err = admin.ListConsumers(ctx, &api.ListConsumersRequest{})
+		require.NoError(t, err)
+		return len(res.Consumers) == 3 && res.Consumers[0].Offset == 3
+	}, time.Second, 10*time.Millisecond)
+	audit, billing, root := res.Consumers[0], res.Consumers[1], res.Consumers[2]
+	require.Equal(t, "audit", audit.Group)
+	require.Equal(t, uint64(0), audit.Lag)
+	require.Equal(t, "billing", billing.Group)
+	require.Equal(t, "root", billing.Subject)
+	require.Equal(t, "default", billing.Topic)
+	require.Equal(t, uint64(2), billing.Offset)
+	require.Equal(t, uint64(1), billing.Lag)
+	require.Equal(t, "root", root.Group)
+	require.Equal(t, uint64(1), root.Offset)
+	require.Equal(t, uint64(3), root.HighWatermark)
+	require.Equal(t, uint64(2), root.Lag)
+	require.NotNil(t, root.LastConsumed)
+
+	res, err = admin.ListConsumers(ctx, &api.ListConsumersRequest{Group: "billing"})
+	require.NoError(t, err)
+	require.Len(t, res.Consumers, 1)
+	res, err = admin.ListConsumers(ctx, &api.ListConsumersRequest{Topic: "orders"})
+	require.NoError(t, err)
+	require.Empty(t, res.Consumers)
+
+	count, err := testutil.GatherAndCount(registry, "proglog_consumer_lag_records")
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	// Subjects without permission to describe the cluster can't list the consumers
+	_, err = api.NewAdminClient(nobodyConn).ListConsumers(ctx, &api.ListConsumersRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// TestSubscribe verifies that a subscriber can seek, pause and resume a stream without re-dialing.
+func TestSubscribe(t *testing.T) {
+	client, _, _, teardown := setupTest(t, nil)
+	defer teardown()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Produce a few records to the log
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte(fmt.Sprintf("message %d", i))},
+		})
+		require.NoError(t, err)
+	}
+
+	stream, err := client.Subscribe(ctx)
+	require.NoError(t, err)
+
+	// Start at offset 1 and read to the head of the log
+	require.NoError(t, stream.Send(&api.SubscribeRequest{
+		Command: &api.SubscribeRequest_Seek{Seek: &api.ConsumeRequest{Offset: 1}},
+	}))
+	for _, want := range []uint64{1, 2} {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, want, res.Record.Offset)
+	}
+
+	// Pause, then seek back to the start of the log while paused and resume
+	require.NoError(t, stream.Send(&api.SubscribeRequest{
+		Command: &api.SubscribeRequest_Pause_{Pause: &api.SubscribeRequest_Pause{}},
+	}))
+	require.NoError(t, stream.Send(&api.SubscribeRequest{
+		Command: &api.SubscribeRequest_Seek{Seek: &api.ConsumeRequest{Offset: 0}},
+	}))
+	require.NoError(t, stream.Send(&api.SubscribeRequest{
+		Command: &api.SubscribeRequest_Resume_{Resume: &api.SubscribeRequest_Resume{}},
+	}))
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), res.Record.Offset)
+}
+
+// testGetServers verifies that GetServers lists the servers reported by the GetServerer,
+// and fails on servers outside a cluster.
+func testGetServers(t *testing.T, client api.LogClient, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	_, err := client.GetServers(ctx, &api.GetServersRequest{})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+
+	want := []*api.Server{
+		{Id: "leader", RpcAddr: "127.0.0.1:8400", IsLeader: true},
+		{Id: "follower", RpcAddr: "127.0.0.1:8401"},
+	}
+	config.GetServerer = getServers(func() ([]*api.Server, error) { return want, nil })
+	res, err := client.GetServers(ctx, &api.GetServersRequest{})
+	require.NoError(t, err)
+	require.Len(t, res.Servers, len(want))
+	for i := range want {
+		require.True(t, proto.Equal(want[i], res.Servers[i]))
+	}
+}
+
+// testGetVersion verifies that GetVersion returns the build of the binary to authorized clients.
+func testGetVersion(t *testing.T, client api.LogClient, nobody api.LogClient, _ *Config) {
+	ctx := context.Background()
+
+	res, err := client.GetVersion(ctx, &api.GetVersionRequest{})
+	require.NoError(t, err)
+	require.True(t, proto.Equal(version.Get(), res.Build))
+	require.Equal(t, runtime.Version(), res.Build.GoVersion)
+
+	_, err = nobody.GetVersion(ctx, &api.GetVersionRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// testWatchServers verifies that WatchServers streams the changes reported by the ServerWatcher
+// once the header is sent, and closes streams falling behind.
+func testWatchServers(t *testing.T, client api.LogClient, nobody api.LogClient, config *Config) {
+	ctx := context.Background()
+	// watch starts watching with the client, once the server is
+	watch := func(client api.LogClient) (api.Log_WatchServersClient, error) {
+		stream, err := client.WatchServers(ctx, &api.WatchServersRequest{})
+		require.NoError(t, err)
+		_, err = stream.Header()
+		return stream, err
+	}
+
+	stream, err := watch(client)
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+
+	events := make(chan *api.ServerEvent, 1)
+	config.ServerWatcher = watchServers(func() (<-chan *api.ServerEvent, func()) {
+		return events, func() {}
+	})
+	stream, err = watch(client)
+	require.NoError(t, err)
+	want := &api.ServerEvent{
+		Type:   api.ServerEventType_SERVER_EVENT_TYPE_JOINED,
+		Server: &api.Server{Id: "follower", RpcAddr: "127.0.0.1:8401"},
+	}
+	events <- want
+	got, err := stream.Recv()
+	require.NoError(t, err)
+	require.True(t, proto.Equal(want, got))
+
+	// Watchers falling behind are told to watch again
+	close(events)
+	_, err = stream.Recv()
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// Subjects without permission to describe the cluster can't watch it
+	stream, err = watch(nobody)
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// testProduceReplicatedAcks verifies that produces asking for replicated acks fail unless the
+// log waits for a quorum to store the records.
+func testProduceReplicatedAcks(t *testing.T, client api.LogClient, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+	req := &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+		Acks:   api.Acks_ACKS_REPLICATED,
+	}
+
+	_, err := client.Produce(ctx, req)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	config.CommitLog = replicatedLog{CommitLog: config.CommitLog}
+	res, err := client.Produce(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), res.Offset)
+}
+
+// replicatedLog is a CommitLog posing as a replicated one.
+type replicatedLog struct {
+	CommitLog
+}
+
+func (replicatedLog) Replicated() bool { return true }
+
+// linearizableLog is a CommitLog posing as one whose reads can be made linearizable.
+type linearizableLog struct {
+	CommitLog
+	readIndex func(context.Context) error
+}
+
+func (l linearizableLog) ReadIndex(ctx context.Context) error { return l.readIndex(ctx) }
+
+// getServers adapts a function to the GetServerer interface.
+type getServers func() ([]*api.Server, error)
+
+func (f getServers) GetServers() ([]*api.Server, error) { return f() }
+
+// watchServers adapts a function to the ServerWatcher interface.
+type watchServers func() (<-chan *api.ServerEvent, func())
+
+func (f watchServers) WatchServers() (<-chan *api.ServerEvent, func()) { return f() }
+
+// clusterAdmin adapts functions to the ClusterAdmin interface.
+type clusterAdmin struct {
+	promote    func(id string) error
+	describe   func(ctx context.Context) ([]*api.ReplicaStatus, error)
+	transfer   func(id string) error
+	leadership func() (*api.Leadership, error)
+	create     func(name string, partitions, replicationFactor uint32) (*api.Topic, error)
+	topics     func() []*api.Topic
+	rebalance  func() ([]*api.PartitionMove, error)
+	pause      func(paused bool) error
+	paused     bool
+	backup     func(ctx context.Context, send func(*api.BackupChunk) error) error
+	config     *api.ClusterConfig
+	setConfig  func(config *api.ClusterConfig) (*api.ClusterConfig, error)
+	updateACL  func(add, remove []*api.AclRule) (*api.ClusterConfig, error)
+}
+
+func (a clusterAdmin) Promote(id string) error { return a.promote(id) }
+
+func (a clusterAdmin) DescribeCluster(ctx context.Context) ([]*api.ReplicaStatus, error) {
+	return a.describe(ctx)
+}
+
+func (a clusterAdmin) TransferLeadership(id string) error { return a.transfer(id) }
+
+func (a clusterAdmin) Leadership() (*api.Leadership, error) { return a.leadership() }
+
+func (a clusterAdmin) CreateTopic(name string, partitions, replicationFactor uint32) (*api.Topic, error) {
+	return a.create(name, partitions, replicationFactor)
+}
+
+func (a clusterAdmin) Topics() []*api.Topic { return a.topics() }
+
+func (a clusterAdmin) Rebalance() ([]*api.PartitionMove, error) { return a.rebalance() }
+
+func (a clusterAdmin) PauseRebalance(paused bool) error { return a.pause(paused) }
+
+func (a clusterAdmin) RebalancePaused() bool { return a.paused }
+
+func (a clusterAdmin) Backup(ctx context.Context, send func(*api.BackupChunk) error) error {
+	return a.backup(ctx, send)
+}
+
+func (a clusterAdmin) PrepareBackup(context.Context, uint64) ([]*api.BackupRange, error) {
+	return nil, nil
+}
+
+func (a clusterAdmin) ReadBackup(context.Context, *api.BackupRange, func(*api.BackupChunk) error) error {
+	return nil
+}
+
+func (a clusterAdmin) ClusterConfig() *api.ClusterConfig { return a.config }
+
+func (a clusterAdmin) SetConfig(config *api.ClusterConfig) (*api.ClusterConfig, error) {
+	return a.setConfig(config)
+}
+
+func (a clusterAdmin) UpdateACLRules(add, remove []*api.AclRule) (*api.ClusterConfig, error) {
+	return a.updateACL(add, remove)
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	api "github.com/glauco/proglog/api/v1"
+	apiv2 "github.com/glauco/proglog/api/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Ensure grpcServerV2 implements the apiv2.LogServer interface.
+var _ apiv2.LogServer = (*grpcServerV2)(nil)
+
+// grpcServerV2 implements the v2 log API, which addresses records by topic and partition.
+// The default topic has a single partition, which maps onto the same commit log served by the
+// v1 API, while the partitions of other topics are served from the PartitionLogs, if any.
+type grpcServerV2 struct {
+	apiv2.UnimplementedLogServer
+	v1 *grpcServer // v1 server handling requests for the default topic
+}
+
+// partitionServer returns a v1 server handling requests for the addressed partition, authorizing
+// them against its topic. An empty topic addresses the default topic. The subject must be allowed
+// the action on the topic before the partition is looked up, so subjects can't tell which topics
+// exist without permissions on them.
+func (s *grpcServerV2) partitionServer(ctx context.Context, topic string, partition uint32, action string) (*grpcServer, error) {
+	if topic == "" || topic == defaultTopic || s.v1.PartitionLogs == nil {
+		if err := checkPartition(topic, partition); err != nil {
+			return nil, err
+		}
+		return s.v1, nil
+	}
+	if err := s.v1.authorize(ctx, topic, action); err != nil {
+		return nil, err
+	}
+	clog, err := s.v1.PartitionLogs.PartitionLog(topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	config := *s.v1.Config
+	config.CommitLog = clog
+	return &grpcServer{Config: &config, topic: topic, partition: partition, consumers: s.v1.consumers}, nil
+}
+
+// checkPartition returns an error unless the topic and partition address the default log.
+// An empty topic addresses the default topic.
+func checkPartition(topic string, partition uint32) error {
+	if (topic == "" || topic == defaultTopic) && partition == 0 {
+		return nil
+	}
+	return api.NewError(
+		codes.NotFound,
+		api.ReasonNotFound,
+		fmt.Sprintf("topic %q partition %d does not exist", topic, partition),
+		map[string]string{
+			"topic":     topic,
+			"partition": strconv.FormatUint(uint64(partition), 10),
+		},
+	)
+}
+
+// Produce appends a record to the addressed partition and returns its offset.
+func (s *grpcServerV2) Produce(ctx context.Context, req *apiv2.ProduceRequest) (*apiv2.ProduceResponse, error) {
+	srv, err := s.partitionServer(ctx, req.Topic, req.Partition, produceAction)
+	if err != nil {
+		return nil, err
+	}
+	res, err := srv.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{
+			Value:   req.GetRecord().GetValue(),
+			Key:     req.GetRecord().GetKey(),
+			Headers: req.GetRecord().GetHeaders(),
+		},
+		ExpectedOffset: req.ExpectedOffset,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &apiv2.ProduceResponse{
+		Offset:        res.Offset,
+		AppendTime:    res.AppendTime,
+		SessionToken:  res.SessionToken,
+		HighWatermark: res.HighWatermark,
+	}, nil
+}
+
+// Consume reads a record from the addressed partition.
+func (s *grpcServerV2) Consume(ctx context.Context, req *apiv2.ConsumeRequest) (*apiv2.ConsumeResponse, error) {
+	srv, err := s.partitionServer(ctx, req.Topic, req.Partition, consumeAction)
+	if err != nil {
+		return nil, err
+	}
+	res, err := srv.Consume(ctx, toV1ConsumeRequest(req))
+	if err != nil {
+		return nil, err
+	}
+	return toV2ConsumeResponse(res, req.Topic, req.Partition), nil
+}
+
+// ProduceStream handles a bidirectional stream of produce requests and responses.
+func (s *grpcServerV2) ProduceStream(stream apiv2.Log_ProduceStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		res, err := s.Produce(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(res); err != nil {
+			return err
+		}
+	}
+}
+
+// ConsumeStream streams the records of the addressed partition starting at the requested offset.
+func (s *grpcServerV2) ConsumeStream(req *apiv2.ConsumeRequest, stream apiv2.Log_ConsumeStreamServer) error {
+	srv, err := s.partitionServer(stream.Context(), req.Topic, req.Partition, consumeAction)
+	if err != nil {
+		return err
+	}
+	setStreamLog(stream.Context(), srv.CommitLog)
+	return srv.ConsumeStream(toV1ConsumeRequest(req), &consumeStreamV2{
+		ServerStream: stream,
+		stream:       stream,
+		topic:        req.Topic,
+		partition:    req.Partition,
+	})
+}
+
+// consumeStreamV2 adapts a v2 consume stream so the v1 handler can send records on it.
+type consumeStreamV2 struct {
+	grpc.ServerStream
+	stream    apiv2.Log_ConsumeStreamServer
+	topic     string
+	partition uint32
+}
+
+// Send converts the v1 response and sends it on the v2 stream.
+func (s *consumeStreamV2) Send(res *api.ConsumeResponse) error {
+	return s.stream.Send(toV2ConsumeResponse(res, s.topic, s.partition))
+}
+
+// toV1ConsumeRequest converts a v2 consume request into its v1 equivalent.
+func toV1ConsumeRequest(req *apiv2.ConsumeRequest) *api.ConsumeRequest {
+	return &api.ConsumeRequest{
+		Offset:         req.Offset,
+		RelativeOffset: req.RelativeOffset,
+		MaxWaitMs:      req.MaxWaitMs,
+		SessionToken:   req.SessionToken,
+		Linearizable:   req.Linearizable,
+		ConsumerGroup:  req.ConsumerGroup,
+	}
+}
+
+// toV2ConsumeResponse converts a v1 consume response into its v2 equivalent.
+func toV2ConsumeResponse(res *api.ConsumeResponse, topic string, partition uint32) *apiv2.ConsumeResponse {
+	if topic == "" {
+		topic = defaultTopic
+	}
+	return &apiv2.ConsumeResponse{
+		Record: &apiv2.Record{
+			Value:      res.Record.GetValue(),
+			Offset:     res.Record.GetOffset(),
+			AppendTime: res.Record.GetAppendTime(),
+			Topic:      topic,
+			Partition:  partition,
+			Key:        res.Record.GetKey(),
+			Headers:    res.Record.GetHeaders(),
+		},
+		HighWatermark: res.HighWatermark,
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	api "github.com/glauco/proglog/api/v1"
+	apiv2 "github.com/glauco/proglog/api/v2"
+	"github.com/glauco/proglog/internal/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestServerV2 verifies that the v2 API serves the default topic from the same log as the v1 API.
+func TestServerV2(t *testing.T) {
+	rootConn, _, _, teardown := setupTestConns(t, nil)
+	defer teardown()
+	ctx := context.Background()
+	client := apiv2.NewLogClient(rootConn)
+	clientV1 := api.NewLogClient(rootConn)
+
+	// Produce to the default topic through the v2 API
+	headers := map[string]string{"source": "v2"}
+	produce, err := client.Produce(ctx, &apiv2.ProduceRequest{
+		Record: &apiv2.Record{Value: []byte("hello v2"), Key: []byte("k"), Headers: headers},
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), produce.Offset)
+
+	// The record is visible through the v1 API...
+	consumeV1, err := clientV1.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello v2"), consumeV1.Record.Value)
+	require.Equal(t, headers, consumeV1.Record.Headers)
+
+	// ...and through the v2 API, addressed by its topic and partition
+	consume, err := client.Consume(ctx, &apiv2.ConsumeRequest{Topic: "default", Offset: produce.Offset})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello v2"), consume.Record.Value)
+	require.Equal(t, "default", consume.Record.Topic)
+	require.Equal(t, uint32(0), consume.Record.Partition)
+	require.Equal(t, []byte("k"), consume.Record.Key)
+	require.Equal(t, headers, consume.Record.Headers)
+
+	// Records produced through the v1 API are streamed by the v2 API
+	_, err = clientV1.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello v1")}})
+	require.NoError(t, err)
+	stream, err := client.ConsumeStream(ctx, &apiv2.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	for _, want := range []string{"hello v2", "hello v1"} {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, want, string(res.Record.Value))
+	}
+
+	// Other topics and partitions don't exist yet
+	_, err = client.Produce(ctx, &apiv2.ProduceRequest{
+		Topic:  "orders",
+		Record: &apiv2.Record{Value: []byte("hello")},
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Consume(ctx, &apiv2.ConsumeRequest{Partition: 1})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// TestServerV2Partitions verifies that the v2 API serves the partitions of other topics from the
+// PartitionLogs, apart from the default topic.
+func TestServerV2Partitions(t *testing.T) {
+	orders, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	rootConn, nobodyConn, _, teardown := setupTestConns(t, func(c *Config) {
+		c.PartitionLogs = partitionLogs(func(topic string, partition uint32) (CommitLog, error) {
+			if topic == "orders" && partition == 0 {
+				return orders, nil
+			}
+			return nil, status.Error(codes.NotFound, "no such partition")
+		})
+	})
+	defer teardown()
+	ctx := context.Background()
+	client := apiv2.NewLogClient(rootConn)
+
+	// Records produced to the topic's partition are stored in its own log...
+	for _, value := range []string{"first order", "second order"} {
+		_, err := client.Produce(ctx, &apiv2.ProduceRequest{
+			Topic:  "orders",
+			Record: &apiv2.Record{Value: []byte(value)},
+		})
+		require.NoError(t, err)
+	}
+	record, err := orders.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, "second order", string(record.Value))
+	consume, err := client.Consume(ctx, &apiv2.ConsumeRequest{Topic: "orders", Offset: 1})
+	require.NoError(t, err)
+	require.Equal(t, "second order", string(consume.Record.Value))
+	require.Equal(t, "orders", consume.Record.Topic)
+	// The group's lag is tracked in the partition
+	consumers, err := api.NewAdminClient(rootConn).ListConsumers(ctx, &api.ListConsumersRequest{Topic: "orders"})
+	require.NoError(t, err)
+	require.Len(t, consumers.Consumers, 1)
+	require.Equal(t, uint32(0), consumers.Consumers[0].Partition)
+	require.Equal(t, uint64(2), consumers.Consumers[0].Offset)
+
+	// ...and streamed from it
+	stream, err := client.ConsumeStream(ctx, &apiv2.ConsumeRequest{Topic: "orders"})
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "first order", string(res.Record.Value))
+
+	// The default topic is still served from the server's own log, which is empty
+	_, err = client.Consume(ctx, &apiv2.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+
+	// Partitions the PartitionLogs don't hold fail with its error
+	_, err = client.Consume(ctx, &apiv2.ConsumeRequest{Topic: "orders", Partition: 1})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// Subjects without permissions on a topic are denied whether it exists or not
+	nobody := apiv2.NewLogClient(nobodyConn)
+	for _, topic := range []string{"orders", "payments"} {
+		_, err = nobody.Consume(ctx, &apiv2.ConsumeRequest{Topic: topic})
+		require.Equal(t, codes.PermissionDenied, status.Code(err))
+		_, err = nobody.Produce(ctx, &apiv2.ProduceRequest{Topic: topic, Record: &apiv2.Record{Value: []byte("order")}})
+		require.Equal(t, codes.PermissionDenied, status.Code(err))
+	}
+}
+
+// partitionLogs adapts a function to the PartitionLogs interface.
+type partitionLogs func(topic string, partition uint32) (CommitLog, error)
+
+func (f partitionLogs) PartitionLog(topic string, partition uint32) (CommitLog, error) {
+	return f(topic, partition)
+}
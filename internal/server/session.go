@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"google.golang.org/grpc/codes"
+)
+
+// sessionTokenVersion identifies the layout of session tokens, so it can evolve
+// (e.g. to carry a replication term) without breaking clients holding old tokens.
+const sessionTokenVersion = 1
+
+// sessionWaitTimeout bounds how long a read waits for the server to catch up with the
+// write identified by its session token before failing so the client can retry elsewhere.
+const sessionWaitTimeout = time.Second
+
+// newSessionToken returns the session token identifying the write stored at offset.
+// Tokens are opaque to clients: a version byte followed by the big-endian offset.
+func newSessionToken(offset uint64) []byte {
+	token := make([]byte, 1+8)
+	token[0] = sessionTokenVersion
+	binary.BigEndian.PutUint64(token[1:], offset)
+	return token
+}
+
+// parseSessionToken returns the offset of the write identified by a session token.
+func parseSessionToken(token []byte) (uint64, error) {
+	if len(token) != 1+8 || token[0] != sessionTokenVersion {
+		return 0, api.NewError(codes.InvalidArgument, api.ReasonInvalidRequest, "malformed session token", nil)
+	}
+	return binary.BigEndian.Uint64(token[1:]), nil
+}
+
+// awaitSession waits until the log includes the write identified by the session token,
+// giving up after sessionWaitTimeout or once the context is done. It returns an
+// ErrReplicaBehind if the log is still behind, and nothing if there is no token.
+func (s *grpcServer) awaitSession(ctx context.Context, token []byte) error {
+	if len(token) == 0 {
+		return nil
+	}
+	offset, err := parseSessionToken(token)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, sessionWaitTimeout)
+	defer cancel()
+	ticker := time.NewTicker(readWaitInterval)
+	defer ticker.Stop()
+	for {
+		_, err := s.CommitLog.Read(offset)
+		outOfRange, ok := err.(api.ErrOffsetOutOfRange)
+		if !ok || offset < outOfRange.Lowest {
+			// The write is in the log, or was and has since been truncated
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return api.ErrReplicaBehind{Offset: offset, Next: outOfRange.Next}
+		case <-ticker.C:
+		}
+	}
+}
+
+// awaitLinearizable waits until the log holds every write acknowledged before, if the read is
+// linearizable and the log's reads may not be, e.g. as it's a follower's replica.
+func (s *grpcServer) awaitLinearizable(ctx context.Context, linearizable bool) error {
+	l, ok := s.CommitLog.(LinearizableLog)
+	if !linearizable || !ok {
+		return nil
+	}
+	return l.ReadIndex(ctx)
+}
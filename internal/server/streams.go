@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// streamRegistry keeps track of the streaming RPCs currently open on the server,
+// so operators can see who is connected, where each consumer is reading and how far
+// behind the head of the log it is. It is also a Prometheus collector exporting that lag.
+type streamRegistry struct {
+	log     CommitLog    // Log the streams read from, to compute their lag
+	logger  *slog.Logger // Logger of the streams opening and closing
+	mu      sync.Mutex
+	nextID  uint64
+	streams map[uint64]*streamEntry
+}
+
+// streamLagDesc describes the lag metric exported for every open consume stream.
+var streamLagDesc = prometheus.NewDesc(
+	"proglog_stream_lag_records",
+	"Number of records between an open consume stream's position and the head of the log.",
+	[]string{"id", "method", "subject"},
+	nil,
+)
+
+// streamEntry describes an open stream. Its offset is updated by the handler as it progresses.
+type streamEntry struct {
+	id        uint64
+	method    string
+	subject   string
+	peer      string
+	startedAt time.Time
+	offset    atomic.Uint64 // Next offset the stream will read
+	consuming atomic.Bool   // Whether the stream reads records, so its offset and lag are meaningful
+	log       CommitLog     // Log the stream reads from, if not the registry's; set before consuming
+}
+
+// newStreamRegistry creates an empty stream registry for streams reading from the given log,
+// logging them as they open and close to the given logger.
+func newStreamRegistry(log CommitLog, logger *slog.Logger) *streamRegistry {
+	return &streamRegistry{
+		log:     log,
+		logger:  logger,
+		streams: make(map[uint64]*streamEntry),
+	}
+}
+
+// add registers a new stream and returns its entry.
+func (r *streamRegistry) add(method, subject, peer string) *streamEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	e := &streamEntry{
+		id:        r.nextID,
+		method:    method,
+		subject:   subject,
+		peer:      peer,
+		startedAt: time.Now(),
+	}
+	r.streams[e.id] = e
+	return e
+}
+
+// remove unregisters a stream once it ends.
+func (r *streamRegistry) remove(e *streamEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.streams, e.id)
+}
+
+// list returns a snapshot of the open streams, ordered by ID.
+// Consume streams report their lag behind the current high watermark of the log they read.
+func (r *streamRegistry) list() ([]*api.StreamInfo, error) {
+	// The registry's log is read once, so the streams reading it all see the same high watermark
+	registryHW := sync.OnceValues(func() (uint64, error) { return highWatermark(r.log) })
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	infos := make([]*api.StreamInfo, 0, len(r.streams))
+	for _, e := range r.streams {
+		info := &api.StreamInfo{
+			Id:        e.id,
+			Method:    e.method,
+			Subject:   e.subject,
+			Peer:      e.peer,
+			StartedAt: timestamppb.New(e.startedAt),
+		}
+		if e.consuming.Load() {
+			hw, err := e.highWatermark(registryHW)
+			if err != nil {
+				return nil, err
+			}
+			info.Offset = e.offset.Load()
+			info.Lag = lag(hw, info.Offset)
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Id < infos[j].Id
+	})
+	return infos, nil
+}
+
+// highWatermark returns the high watermark of the log the consuming stream reads, which is the
+// registry's, read by registryHW, unless the stream reads its own.
+func (e *streamEntry) highWatermark(registryHW func() (uint64, error)) (uint64, error) {
+	if e.log != nil {
+		return highWatermark(e.log)
+	}
+	return registryHW()
+}
+
+// lag returns how many records a reader positioned at offset is behind the high watermark.
+func lag(hw, offset uint64) uint64 {
+	if offset >= hw {
+		return 0
+	}
+	return hw - offset
+}
+
+// Describe implements prometheus.Collector.
+func (r *streamRegistry) Describe(ch chan<- *prometheus.Desc) {
+	ch <- streamLagDesc
+}
+
+// Collect implements prometheus.Collector, exporting the lag of every open consume stream.
+func (r *streamRegistry) Collect(ch chan<- prometheus.Metric) {
+	// The registry's log is read once, so the streams reading it all see the same high watermark
+	registryHW := sync.OnceValues(func() (uint64, error) { return highWatermark(r.log) })
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.streams {
+		if !e.consuming.Load() {
+			continue
+		}
+		hw, err := e.highWatermark(registryHW)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(streamLagDesc, err)
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(
+			streamLagDesc,
+			prometheus.GaugeValue,
+			float64(lag(hw, e.offset.Load())),
+			strconv.FormatUint(e.id, 10),
+			e.method,
+			e.subject,
+		)
+	}
+}
+
+// streamInterceptor registers every stream for the duration of its handler and makes
+// its entry available to the handler through the stream's context.
+func (r *streamRegistry) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		var addr string
+		if p, ok := peer.FromContext(ctx); ok {
+			addr = p.Addr.String()
+		}
+		e := r.add(info.FullMethod, subject(ctx), addr)
+		defer r.remove(e)
+		logger := r.logger.With(
+			slog.Uint64("stream", e.id),
+			slog.String("method", e.method),
+			slog.String("subject", e.subject),
+			slog.String("peer", e.peer),
+		)
+		logger.DebugContext(ctx, "stream opened")
+		err := handler(srv, &registeredStream{
+			ServerStream: ss,
+			ctx:          context.WithValue(ctx, streamEntryContextKey{}, e),
+		})
+		attrs := []any{
+			slog.String("code", status.Code(err).String()),
+			slog.Duration("duration", time.Since(e.startedAt)),
+		}
+		if e.consuming.Load() {
+			attrs = append(attrs, slog.Uint64("offset", e.offset.Load()))
+		}
+		logger.DebugContext(ctx, "stream closed", attrs...)
+		return err
+	}
+}
+
+// registeredStream wraps a server stream to carry its registry entry in the context.
+type registeredStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the stream's context, which carries its registry entry.
+func (s *registeredStream) Context() context.Context {
+	return s.ctx
+}
+
+// setStreamOffset records the next offset the stream in the context will read,
+// marking it as a consume stream. It does nothing if the stream isn't registered.
+func setStreamOffset(ctx context.Context, offset uint64) {
+	if e, ok := ctx.Value(streamEntryContextKey{}).(*streamEntry); ok {
+		e.offset.Store(offset)
+		e.consuming.Store(true)
+	}
+}
+
+// setStreamLog records the log the stream in the context reads, when it isn't the registry's.
+// It must be called before the stream's offset is first set.
+func setStreamLog(ctx context.Context, log CommitLog) {
+	if e, ok := ctx.Value(streamEntryContextKey{}).(*streamEntry); ok {
+		e.log = log
+	}
+}
+
+type streamEntryContextKey struct{}
+
+// debugServer implements the Debug service on top of the server's stream registry.
+type debugServer struct {
+	api.UnimplementedDebugServer
+	*Config
+	streams *streamRegistry
+}
+
+// ListStreams returns the streams currently open on the server.
+func (s *debugServer) ListStreams(ctx context.Context, req *api.ListStreamsRequest) (*api.ListStreamsResponse, error) {
+	if err := s.authorize(
+		ctx,
+		objectAdmin,
+		describeAction,
+	); err != nil {
+		return nil, err
+	}
+	streams, err := s.streams.list()
+	if err != nil {
+		return nil, err
+	}
+	return &api.ListStreamsResponse{Streams: streams}, nil
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"log/slog"
+	"testing"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStreamRegistryLag verifies that the registry reports how far consume streams are
+// behind the head of the log, both through list and as metrics.
+func TestStreamRegistryLag(t *testing.T) {
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Remove()
+
+	r := newStreamRegistry(clog, slog.Default())
+
+	// With an empty log, a consumer at offset 0 isn't behind
+	consumer := r.add("/log.v1.Log/ConsumeStream", "root", "127.0.0.1:1")
+	consumer.offset.Store(0)
+	consumer.consuming.Store(true)
+	infos, err := r.list()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), infos[0].Lag)
+
+	// Once records are appended, the consumer lags behind by the records it hasn't read
+	for i := 0; i < 3; i++ {
+		_, err := clog.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	consumer.offset.Store(1)
+	// Streams that don't consume, like produce streams, report no position or lag
+	r.add("/log.v1.Log/ProduceStream", "root", "127.0.0.1:2")
+
+	infos, err = r.list()
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	require.Equal(t, uint64(1), infos[0].Offset)
+	require.Equal(t, uint64(2), infos[0].Lag)
+	require.Equal(t, uint64(0), infos[1].Lag)
+
+	// Only the consume stream is exported as a metric
+	require.Equal(t, 1, testutil.CollectAndCount(r, "proglog_stream_lag_records"))
+	require.Equal(t, 2.0, testutil.ToFloat64(r))
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"google.golang.org/grpc/codes"
+)
+
+// TokenIssuer mints the scoped tokens of the Admin service's CreateToken, bearer tokens
+// authenticating as a subject but only permitted the actions of their scopes, and validates
+// them, e.g. an auth.TokenSigner.
+type TokenIssuer interface {
+	// Issue mints a token for the subject, permitted the scopes' actions for the TTL, and
+	// returns it with its ID and expiry.
+	Issue(subject string, scopes []*api.TokenScope, ttl time.Duration) (token, id string, expires time.Time, err error)
+	// Minted reports whether the token is written as the issuer's tokens are, so other bearer
+	// tokens, e.g. JWTs, are left to the TokenValidator.
+	Minted(token string) bool
+	// Validate returns the subject, ID and scopes of a token the issuer minted.
+	Validate(ctx context.Context, token string) (subject, id string, scopes []*api.TokenScope, err error)
+}
+
+// scopeAny in a scope's object or action stands for any.
+const scopeAny = "*"
+
+// tokenGrant is the scoped token a client authenticated with, limiting what it may do.
+type tokenGrant struct {
+	id     string
+	scopes []*api.TokenScope
+}
+
+type tokenContextKey struct{}
+
+// tokenFromContext returns the scoped token the client authenticated with, or nil.
+func tokenFromContext(ctx context.Context) *tokenGrant {
+	grant, _ := ctx.Value(tokenContextKey{}).(*tokenGrant)
+	return grant
+}
+
+// tokenID returns the ID of the scoped token, or an empty string if there's none.
+func (g *tokenGrant) tokenID() string {
+	if g == nil {
+		return ""
+	}
+	return g.id
+}
+
+// permit fails if the scoped token doesn't permit the action on the object. Clients without one
+// are only limited by the Authorizer.
+func (g *tokenGrant) permit(subject, object, action string) error {
+	if g == nil {
+		return nil
+	}
+	for _, scope := range g.scopes {
+		if (scope.Object == scopeAny || scope.Object == object) && (scope.Action == scopeAny || scope.Action == action) {
+			return nil
+		}
+	}
+	msg := fmt.Sprintf("%s's token %s isn't scoped to %s to %s", subject, g.id, action, object)
+	return api.NewError(codes.PermissionDenied, api.ReasonUnauthorized, msg, map[string]string{
+		"subject": subject,
+		"object":  object,
+		"action":  action,
+		"token":   g.id,
+	})
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Types of the JSON frames exchanged on the WebSocket endpoint.
+const (
+	frameProduce  = "produce"  // Client to server: append the frame's record to the log
+	frameConsume  = "consume"  // Client to server: stream records starting at the frame's offset
+	frameProduced = "produced" // Server to client: the produced record got the frame's offset
+	frameRecord   = "record"   // Server to client: a record read from the log
+	frameError    = "error"    // Server to client: the last request failed
+)
+
+// WebSocketFrame is a JSON message sent in either direction on the WebSocket endpoint.
+// Clients send produce and consume frames; the server answers with produced, record and error frames.
+type WebSocketFrame struct {
+	Type   string  `json:"type"`             // One of produce, consume, produced, record or error
+	Record *Record `json:"record,omitempty"` // Record to produce, or record read from the log
+	Offset uint64  `json:"offset,omitempty"` // Offset to consume from, or offset of the produced record
+	Error  string  `json:"error,omitempty"`  // Error message of error frames
+}
+
+// closeStatusBase is added to the HTTP status describing the error that ends a consume to get the
+// code of the close frame, e.g. 4404 for records truncated away, as codes 4000 to 4999 are left
+// to applications.
+const closeStatusBase = 4000
+
+// maxCloseReason is the longest reason a close frame may carry, in bytes.
+const maxCloseReason = 123
+
+// checkOrigin returns the origin check of the WebSocket upgrader, accepting requests without an
+// Origin, which don't come from browsers, requests from pages of the server's own origin, and
+// those from the allowed origins, or any origin if they include "*". Browsers open WebSockets
+// across origins with the user's cookies and certificates, so other pages mustn't be able to.
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		for _, a := range allowed {
+			if a == "*" || strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// handleWebSocket upgrades the request to a WebSocket on which the client can produce records
+// and consume the log as it grows, for environments where gRPC streaming is unavailable.
+// A consume frame starts streaming records from its offset, replacing any previous consume.
+func (s *httpServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // The upgrader already responded with an error
+	}
+	ws := &webSocket{conn: conn, log: s.Log}
+	defer ws.close()
+	if !s.webSockets.add(ws) {
+		// The server is shutting down
+		ws.goingAway()
+		return
+	}
+	defer s.webSockets.remove(ws)
+
+	for {
+		// Read the next request from the client until the connection closes
+		var frame WebSocketFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			return
+		}
+		switch frame.Type {
+		case frameProduce:
+			ws.produce(frame.Record)
+		case frameConsume:
+			ws.consume(frame.Offset)
+		default:
+			ws.send(WebSocketFrame{Type: frameError, Error: "unknown frame type: " + frame.Type})
+		}
+	}
+}
+
+// webSocket holds the state of a WebSocket connection.
+type webSocket struct {
+	conn    *websocket.Conn
+	log     RecordLog
+	writeMu sync.Mutex // Serializes writes, since the connection supports a single writer
+
+	cancel context.CancelFunc // Stops the current consume, if any
+	done   chan struct{}      // Closed when the current consume stopped
+}
+
+// send writes a frame to the client.
+func (ws *webSocket) send(frame WebSocketFrame) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	return ws.conn.WriteJSON(frame)
+}
+
+// produce appends the record to the log and tells the client its offset.
+func (ws *webSocket) produce(record *Record) {
+	if record == nil {
+		ws.send(WebSocketFrame{Type: frameError, Error: "produce frame without a record"})
+		return
+	}
+	off, err := ws.log.Append(*record)
+	if err != nil {
+		ws.send(WebSocketFrame{Type: frameError, Error: err.Error()})
+		return
+	}
+	ws.send(WebSocketFrame{Type: frameProduced, Offset: off})
+}
+
+// consume stops the current consume, if any, and streams records starting at offset in the background.
+func (ws *webSocket) consume(offset uint64) {
+	ws.stopConsume()
+	ctx, cancel := context.WithCancel(context.Background())
+	ws.cancel, ws.done = cancel, make(chan struct{})
+	go func(done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(readWaitInterval)
+		defer ticker.Stop()
+		for {
+			rec, err := ws.log.Read(offset)
+			if err == nil {
+				if err := ws.send(WebSocketFrame{Type: frameRecord, Record: &rec}); err != nil {
+					return
+				}
+				offset++
+				continue
+			}
+			if lowest := ws.log.LowestOffset(); offset < lowest {
+				// The records were truncated away, and won't be appended again
+				ws.fail(fmt.Errorf("%w: offset %d was truncated, the log starts at %d", ErrOffsetNotFound, offset, lowest))
+				return
+			}
+			if !isOffsetNotFound(err) {
+				// Reading fails, which retrying won't fix
+				ws.fail(err)
+				return
+			}
+			// Caught up with the head of the log, wait for more records
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}(ws.done)
+}
+
+// stopConsume stops the current consume and waits for it to finish.
+func (ws *webSocket) stopConsume() {
+	if ws.cancel != nil {
+		ws.cancel()
+		<-ws.done
+		ws.cancel, ws.done = nil, nil
+	}
+}
+
+// fail ends a consume that can't carry on: it sends the client an error frame, then closes the
+// connection with a close frame whose code tells the HTTP status of the error, e.g. 4404.
+func (ws *webSocket) fail(err error) {
+	ws.send(WebSocketFrame{Type: frameError, Error: err.Error()})
+	reason := err.Error()
+	if len(reason) > maxCloseReason {
+		reason = reason[:maxCloseReason]
+	}
+	ws.closeWith(closeStatusBase+httpStatus(err), reason)
+}
+
+// goingAway tells the client the server is shutting down, and closes the connection.
+func (ws *webSocket) goingAway() {
+	ws.closeWith(websocket.CloseGoingAway, "server shutting down")
+}
+
+// closeWith sends the client a close frame with the code and reason, and closes the connection,
+// which ends the handler's reads. It may be called while the handler serves the WebSocket.
+func (ws *webSocket) closeWith(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	ws.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	ws.conn.Close()
+}
+
+// webSocketSet is the set of open WebSockets.
+type webSocketSet struct {
+	mu      sync.Mutex
+	open    map[*webSocket]struct{}
+	closing bool // Set once the server shuts down, so new WebSockets are closed right away
+}
+
+// add adds the WebSocket, unless the server is shutting down.
+func (s *webSocketSet) add(ws *webSocket) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	if s.open == nil {
+		s.open = make(map[*webSocket]struct{})
+	}
+	s.open[ws] = struct{}{}
+	return true
+}
+
+// remove removes the WebSocket once its handler returns.
+func (s *webSocketSet) remove(ws *webSocket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.open, ws)
+}
+
+// close tells the clients of the open WebSockets the server is shutting down, and closes them.
+func (s *webSocketSet) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closing = true
+	for ws := range s.open {
+		ws.goingAway()
+	}
+}
+
+// close stops consuming and closes the connection.
+func (ws *webSocket) close() {
+	ws.stopConsume()
+	ws.conn.Close()
+}
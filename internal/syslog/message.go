@@ -0,0 +1,233 @@
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nilValue is the value of the header fields and the structured data a message leaves unset.
+const nilValue = "-"
+
+// facilities are the keywords of the facilities, by code, as syslog daemons name them.
+var facilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// severities are the keywords of the severities, by code.
+var severities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// Message is a syslog message, as RFC 5424 formats it. Fields the message leaves unset, with
+// the nil value "-", are empty.
+type Message struct {
+	Facility  int       // Facility is the part of the system that sent the message, e.g. 3 for daemons.
+	Severity  int       // Severity is from 0, emergency, to 7, debug.
+	Timestamp time.Time // Timestamp is when the message was sent; zero when unset.
+	Hostname  string
+	AppName   string
+	ProcID    string
+	MsgID     string // MsgID identifies the type of the message, e.g. TCPIN.
+	// StructuredData are the values of the message's structured data elements' parameters, by
+	// their elements' IDs then their names; parameters repeated in an element hold each value.
+	StructuredData map[string]map[string][]string
+	Msg            []byte // Msg is the free-form message, without the UTF-8 BOM if any.
+}
+
+// FacilityName returns the keyword of the message's facility, e.g. daemon.
+func (m *Message) FacilityName() string {
+	return facilities[m.Facility]
+}
+
+// SeverityName returns the keyword of the message's severity, e.g. err.
+func (m *Message) SeverityName() string {
+	return severities[m.Severity]
+}
+
+// ErrInvalid is returned by Parse for messages that aren't RFC 5424 messages.
+var ErrInvalid = errors.New("syslog: invalid RFC 5424 message")
+
+// Parse parses the RFC 5424 message, without the framing it was transported with, e.g.
+//
+//	<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3"] An application event
+func Parse(b []byte) (*Message, error) {
+	p := &parser{b: b}
+	m := &Message{}
+
+	// PRI is <facility * 8 + severity>, followed by the version, 1
+	if !p.consume('<') {
+		return nil, p.fail("expected <PRI>")
+	}
+	pri, ok := p.number(3)
+	if !ok || !p.consume('>') || pri > 191 {
+		return nil, p.fail("invalid PRI")
+	}
+	m.Facility, m.Severity = pri/8, pri%8
+	if version, ok := p.number(2); !ok || version != 1 {
+		return nil, p.fail("unsupported version")
+	}
+
+	timestamp := p.field()
+	if timestamp != nilValue {
+		t, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return nil, p.fail("invalid timestamp %q", timestamp)
+		}
+		m.Timestamp = t
+	}
+	for _, f := range []struct {
+		dst    *string
+		maxLen int
+	}{{&m.Hostname, 255}, {&m.AppName, 48}, {&m.ProcID, 128}, {&m.MsgID, 32}} {
+		value := p.field()
+		if value == "" || len(value) > f.maxLen {
+			return nil, p.fail("invalid header")
+		}
+		if value != nilValue {
+			*f.dst = value
+		}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	// The structured data is the nil value or elements up to the first space outside them
+	if !p.consume(' ') {
+		return nil, p.fail("expected structured data")
+	}
+	if !p.consume('-') {
+		for p.peek() == '[' {
+			if err := p.element(m); err != nil {
+				return nil, err
+			}
+		}
+		if m.StructuredData == nil {
+			return nil, p.fail("expected structured data")
+		}
+	}
+	if len(p.b) > 0 && !p.consume(' ') {
+		return nil, p.fail("expected a space before the message")
+	}
+	m.Msg = bytes.TrimPrefix(p.b, []byte("\xef\xbb\xbf"))
+	return m, nil
+}
+
+// parser reads the message in order.
+type parser struct {
+	b   []byte
+	err error
+}
+
+// fail records and returns the error of the message, telling what's wrong with it.
+func (p *parser) fail(format string, args ...any) error {
+	if p.err == nil {
+		p.err = fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+	}
+	return p.err
+}
+
+// peek returns the next byte, or 0 at the end of the message.
+func (p *parser) peek() byte {
+	if len(p.b) == 0 {
+		return 0
+	}
+	return p.b[0]
+}
+
+// consume consumes the next byte if it's c.
+func (p *parser) consume(c byte) bool {
+	if p.peek() != c {
+		return false
+	}
+	p.b = p.b[1:]
+	return true
+}
+
+// number reads a decimal number of up to maxDigits digits.
+func (p *parser) number(maxDigits int) (int, bool) {
+	n := 0
+	for n < len(p.b) && n < maxDigits && p.b[n] >= '0' && p.b[n] <= '9' {
+		n++
+	}
+	if n == 0 {
+		return 0, false
+	}
+	v, _ := strconv.Atoi(string(p.b[:n]))
+	p.b = p.b[n:]
+	return v, true
+}
+
+// field reads a header field preceded by a space, up to the next space.
+func (p *parser) field() string {
+	if !p.consume(' ') {
+		p.fail("expected a space between header fields")
+		return ""
+	}
+	n := bytes.IndexByte(p.b, ' ')
+	if n < 0 {
+		n = len(p.b)
+	}
+	v := string(p.b[:n])
+	p.b = p.b[n:]
+	return v
+}
+
+// element reads a structured data element, [id name="value" ...], into the message.
+func (p *parser) element(m *Message) error {
+	p.consume('[')
+	id := p.name()
+	if id == "" {
+		return p.fail("invalid structured data ID")
+	}
+	if m.StructuredData == nil {
+		m.StructuredData = make(map[string]map[string][]string)
+	}
+	params := m.StructuredData[id]
+	if params == nil {
+		params = make(map[string][]string)
+		m.StructuredData[id] = params
+	}
+	for p.consume(' ') {
+		name := p.name()
+		if name == "" || !p.consume('=') || !p.consume('"') {
+			return p.fail("invalid structured data parameter in %q", id)
+		}
+		// Values escape ", \ and ] with a backslash; other backslashes are kept
+		var value strings.Builder
+		for {
+			if len(p.b) == 0 {
+				return p.fail("unterminated structured data parameter %q", name)
+			}
+			c := p.b[0]
+			p.b = p.b[1:]
+			if c == '"' {
+				break
+			}
+			if c == '\\' && len(p.b) > 0 && (p.b[0] == '"' || p.b[0] == '\\' || p.b[0] == ']') {
+				c = p.b[0]
+				p.b = p.b[1:]
+			}
+			value.WriteByte(c)
+		}
+		params[name] = append(params[name], value.String())
+	}
+	if !p.consume(']') {
+		return p.fail("unterminated structured data element %q", id)
+	}
+	return nil
+}
+
+// name reads a structured data ID or parameter name: printable ASCII but space, =, ] and ".
+func (p *parser) name() string {
+	n := 0
+	for n < len(p.b) && n < 32 && p.b[n] > ' ' && p.b[n] < 127 && !strings.ContainsRune(`=]"`, rune(p.b[n])) {
+		n++
+	}
+	v := string(p.b[:n])
+	p.b = p.b[n:]
+	return v
+}
@@ -0,0 +1,79 @@
+package syslog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		raw  string
+		want *Message
+	}{
+		"every field set": {
+			raw: `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog 1234 ID47 [exampleSDID@32473 iut="3" eventSource="Application"] An application event`,
+			want: &Message{
+				Facility:  20,
+				Severity:  5,
+				Timestamp: time.Date(2003, 10, 11, 22, 14, 15, 3_000_000, time.UTC),
+				Hostname:  "mymachine.example.com",
+				AppName:   "evntslog",
+				ProcID:    "1234",
+				MsgID:     "ID47",
+				StructuredData: map[string]map[string][]string{
+					"exampleSDID@32473": {"iut": {"3"}, "eventSource": {"Application"}},
+				},
+				Msg: []byte("An application event"),
+			},
+		},
+		"nil values": {
+			raw:  `<34>1 - - - - - -`,
+			want: &Message{Facility: 4, Severity: 2},
+		},
+		"message with a BOM": {
+			raw:  "<13>1 - host app - - - \xef\xbb\xbf'su root' failed",
+			want: &Message{Facility: 1, Severity: 5, Hostname: "host", AppName: "app", Msg: []byte("'su root' failed")},
+		},
+		"several elements with escapes": {
+			raw: `<0>1 - h - - - [a x="1" x="\"2\]"][b y="c:\\d\e"]`,
+			want: &Message{
+				Hostname: "h",
+				StructuredData: map[string]map[string][]string{
+					"a": {"x": {"1", `"2]`}},
+					"b": {"y": {`c:\d\e`}},
+				},
+			},
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			msg, err := Parse([]byte(tc.raw))
+			require.NoError(t, err)
+			if tc.want.Msg == nil {
+				tc.want.Msg = []byte{}
+			}
+			require.Equal(t, tc.want, msg)
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for scenario, raw := range map[string]string{
+		"bsd syslog":                  "<34>Oct 11 22:14:15 mymachine su: 'su root' failed",
+		"missing pri":                 "1 - - - - - -",
+		"pri out of range":            "<192>1 - - - - - -",
+		"unsupported version":         "<34>2 - - - - - -",
+		"invalid timestamp":           "<34>1 yesterday - - - - -",
+		"missing fields":              "<34>1 - host app",
+		"app name too long":           "<34>1 - host " + string(make([]byte, 49)) + " - - -",
+		"unterminated element":        `<34>1 - - - - - [a x="1"`,
+		"unterminated parameter":      `<34>1 - - - - - [a x="1]`,
+		"no space before the message": `<34>1 - - - - - -msg`,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			_, err := Parse([]byte(raw))
+			require.ErrorIs(t, err, ErrInvalid)
+		})
+	}
+}
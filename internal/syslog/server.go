@@ -0,0 +1,292 @@
+// Package syslog makes the log a durable syslog sink. Server receives syslog messages over TCP,
+// TLS and UDP, and Sink appends them as records, with headers describing them parsed from their
+// RFC 5424 header, so hosts can ship their logs to the log as they do to a syslog daemon.
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Handler handles a message received from the address, as received but for its framing. It's
+// called by the goroutine serving the connection, or the UDP socket, the message came on, so
+// each connection's messages are handled in order.
+type Handler func(ctx context.Context, raw []byte, addr net.Addr) error
+
+// DefaultMaxMessageSize is how large messages may be by default, the most a UDP datagram holds.
+const DefaultMaxMessageSize = 64 << 10
+
+// Server serves syslog senders on stream listeners, for TCP and TLS, and packet connections,
+// for UDP, passing the messages they send to its Handler.
+type Server struct {
+	Handler Handler
+	// MaxMessageSize is how large messages may be; DefaultMaxMessageSize when 0. Connections
+	// sending larger ones are closed, and larger datagrams dropped.
+	MaxMessageSize int
+	Logger         *slog.Logger // Logger receives the senders' errors; defaults to slog.Default().
+
+	mu        sync.Mutex
+	ctx       context.Context // Cancelled once closed, to stop the handlers
+	cancel    context.CancelFunc
+	listeners map[net.Listener]struct{}
+	conns     map[io.Closer]struct{} // Connections served: packet ones, and stream listeners'
+	closed    bool
+	wg        sync.WaitGroup // Tracks the goroutines serving connections
+}
+
+// ErrServerClosed is returned by ServeStream and ServePacket once the server is closed.
+var ErrServerClosed = errors.New("syslog: server closed")
+
+// init initializes the server's state on first use. The caller must hold the lock.
+func (s *Server) init() {
+	if s.ctx != nil {
+		return
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.listeners = make(map[net.Listener]struct{})
+	s.conns = make(map[io.Closer]struct{})
+	if s.Logger == nil {
+		s.Logger = slog.Default()
+	}
+	s.Logger = s.Logger.With(slog.String("component", "syslog"))
+	if s.MaxMessageSize == 0 {
+		s.MaxMessageSize = DefaultMaxMessageSize
+	}
+}
+
+// track tracks the listener, to close it once the server closes, or closes it and fails if the
+// server is closed already.
+func (s *Server) track(l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	if s.closed {
+		l.Close()
+		return false
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+// serve tracks the connection as served until done is called, to close it once the server closes
+// and wait for it to be done, or closes it and fails if the server is closed already.
+func (s *Server) serve(conn io.Closer) (done func(), ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	if s.closed {
+		conn.Close()
+		return nil, false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}, true
+}
+
+// isClosed reports whether the server is closed.
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// ServeStream accepts senders on the listener, e.g. a TCP one or one returned by tls.Listen,
+// and serves each in a goroutine of its own, until the listener fails or the server is closed,
+// when it returns ErrServerClosed. Messages are framed by octet counting, as RFC 6587 and RFC
+// 5425 specify, or terminated by line feeds, as most senders do over TCP; each message may be
+// framed either way.
+func (s *Server) ServeStream(l net.Listener) error {
+	if !s.track(l) {
+		return ErrServerClosed
+	}
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				// Temporary failures, e.g. running out of file descriptors, pass
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		done, ok := s.serve(conn)
+		if !ok {
+			return ErrServerClosed
+		}
+		go func() {
+			defer done()
+			defer conn.Close()
+			if err := s.serveConn(conn); err != nil && !s.isClosed() {
+				s.Logger.Warn("closed connection",
+					slog.String("remote_addr", conn.RemoteAddr().String()),
+					slog.String("error", err.Error()),
+				)
+			}
+		}()
+	}
+}
+
+// serveConn handles the messages of the connection until it's closed. Senders don't get
+// acknowledgements, so the connection is closed once a message fails to be handled, for the
+// sender to send what it hasn't yet again once reconnected.
+func (s *Server) serveConn(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	for {
+		raw, err := readFrame(r, s.MaxMessageSize)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(raw) == 0 {
+			continue
+		}
+		if err := s.Handler(s.ctx, raw, conn.RemoteAddr()); err != nil {
+			return fmt.Errorf("handle message: %w", err)
+		}
+	}
+}
+
+// readFrame reads the next message of the stream, framed by octet counting, "<length> <message>",
+// or terminated by a line feed. It returns io.EOF once the stream ends between messages.
+func readFrame(r *bufio.Reader, maxSize int) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] >= '1' && first[0] <= '9' {
+		// RFC 5424 messages start with <, so messages starting with a digit are octet counted
+		length, err := r.ReadSlice(' ')
+		if err != nil {
+			return nil, fmt.Errorf("read message length: %w", unexpectedEOF(err))
+		}
+		n, err := strconv.Atoi(string(length[:len(length)-1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid message length %q", length)
+		}
+		if n > maxSize {
+			return nil, fmt.Errorf("message of %d bytes exceeds the limit of %d", n, maxSize)
+		}
+		raw := make([]byte, n)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return raw, nil
+	}
+
+	var raw []byte
+	for {
+		line, err := r.ReadSlice('\n')
+		if len(raw)+len(line) > maxSize+1 {
+			return nil, fmt.Errorf("message exceeds the limit of %d bytes", maxSize)
+		}
+		raw = append(raw, line...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(raw) > 0 {
+			// The last message may be left unterminated as the sender closes the connection
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+	return bytes.TrimRight(raw, "\r\n\x00"), nil
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF, for messages cut short.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ServePacket handles the messages of the datagrams received on the connection, e.g. a UDP one,
+// a message per datagram, until the connection fails or the server is closed, when it returns
+// ErrServerClosed. Datagrams are unacknowledged, so the messages that fail to be handled are lost.
+func (s *Server) ServePacket(pc net.PacketConn) error {
+	done, ok := s.serve(pc)
+	if !ok {
+		return ErrServerClosed
+	}
+	defer done()
+	buf := make([]byte, 64<<10)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if n > s.MaxMessageSize {
+			s.Logger.Warn("dropped message exceeding the size limit",
+				slog.String("remote_addr", addr.String()),
+				slog.Int("size", n),
+			)
+			continue
+		}
+		raw := bytes.TrimRight(buf[:n], "\r\n\x00")
+		if len(raw) == 0 {
+			continue
+		}
+		// The buffer is reused for the next datagram
+		if err := s.Handler(s.ctx, bytes.Clone(raw), addr); err != nil {
+			s.Logger.Error("failed to handle message",
+				slog.String("remote_addr", addr.String()),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+}
+
+// Close stops accepting senders and receiving datagrams, closes the connections, and waits for
+// the handlers of their messages to return, cancelling their context.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.init()
+	s.closed = true
+	s.cancel()
+	var errs []error
+	for l := range s.listeners {
+		errs = append(errs, l.Close())
+	}
+	// Packet connections are closed as listeners are, reporting their errors
+	for conn := range s.conns {
+		if _, ok := conn.(net.PacketConn); ok {
+			errs = append(errs, conn.Close())
+		} else {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,124 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testHandler records the messages handled, failing those holding "fail".
+type testHandler struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (h *testHandler) handle(_ context.Context, raw []byte, _ net.Addr) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if strings.Contains(string(raw), "fail") {
+		return errors.New("failed")
+	}
+	h.messages = append(h.messages, string(raw))
+	return nil
+}
+
+func (h *testHandler) handled() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.messages...)
+}
+
+func setupServer(t *testing.T, maxMessageSize int) (*Server, *testHandler) {
+	t.Helper()
+	h := &testHandler{}
+	srv := &Server{
+		Handler:        h.handle,
+		MaxMessageSize: maxMessageSize,
+		Logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	return srv, h
+}
+
+func TestServeStream(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		sent string
+		want []string
+	}{
+		"octet counting": {
+			sent: "11 <34>1 first12 <34>1 second",
+			want: []string{"<34>1 first", "<34>1 second"},
+		},
+		"line feeds": {
+			sent: "<34>1 first\n<34>1 second\r\n\n<34>1 unterminated",
+			want: []string{"<34>1 first", "<34>1 second", "<34>1 unterminated"},
+		},
+		"mixed framing": {
+			sent: "<34>1 first\n12 <34>1 second<34>1 third\n",
+			want: []string{"<34>1 first", "<34>1 second", "<34>1 third"},
+		},
+		"messages exceeding the limit close the connection": {
+			sent: "11 <34>1 first100 <34>1 too large",
+			want: []string{"<34>1 first"},
+		},
+		"messages not handled close the connection": {
+			sent: "<34>1 first\n<34>1 fail\n<34>1 lost\n",
+			want: []string{"<34>1 first"},
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			srv, h := setupServer(t, 64)
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			served := make(chan error, 1)
+			go func() { served <- srv.ServeStream(l) }()
+			defer func() {
+				require.NoError(t, srv.Close())
+				require.ErrorIs(t, <-served, ErrServerClosed)
+			}()
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+			_, err = io.WriteString(conn, tc.sent)
+			require.NoError(t, err)
+			conn.(*net.TCPConn).CloseWrite()
+
+			// The server closes the connection once the messages are handled, or one fails
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, err = bufio.NewReader(conn).ReadByte()
+			require.ErrorIs(t, err, io.EOF)
+			require.Equal(t, tc.want, h.handled())
+		})
+	}
+}
+
+func TestServePacket(t *testing.T) {
+	srv, h := setupServer(t, 64)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- srv.ServePacket(pc) }()
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	// Datagrams that aren't handled, or exceed the limit, are lost, but the next ones are handled
+	for _, msg := range []string{"<34>1 first\n", "<34>1 fail", "<34>1 " + strings.Repeat("x", 64), "<34>1 second"} {
+		_, err := io.WriteString(conn, msg)
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool { return len(h.handled()) == 2 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"<34>1 first", "<34>1 second"}, h.handled())
+
+	require.NoError(t, srv.Close())
+	require.ErrorIs(t, <-served, ErrServerClosed)
+}
@@ -0,0 +1,95 @@
+package syslog
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+	"strings"
+	"time"
+
+	apiv2 "github.com/glauco/proglog/api/v2"
+)
+
+// headerPrefix prefixes the names of the record headers describing the message, e.g.
+// syslog_hostname.
+const headerPrefix = "syslog_"
+
+// Sink appends the messages a Server receives as records of a topic of the log. Its Handle
+// method is the Handler of a Server.
+//
+// Records hold the messages as received, so they can be forwarded to syslog daemons as they
+// were sent, and are keyed by the messages' hostnames. Their headers are the fields of the
+// messages' RFC 5424 headers that are set: syslog_facility and syslog_severity, as keywords,
+// e.g. daemon and err, syslog_timestamp, syslog_hostname, syslog_app, syslog_procid and
+// syslog_msgid; and syslog_remote_addr, the address of the sender. Structured data parameters
+// are set as syslog_sd.<element ID>.<parameter name> headers, repeated parameters' values
+// separated by commas. Messages that aren't RFC 5424 messages, e.g. BSD syslog ones, are still
+// appended, keyed by the sender's host and with the syslog_error header telling why they couldn't
+// be parsed, so nothing sent to the sink is lost.
+type Sink struct {
+	Client apiv2.LogClient
+	Topic  string // Topic is the log's topic the messages are appended to; the default topic when empty.
+	// Partitions spreads the records across the topic's first partitions by key, so the records
+	// of a host stay in order; 0 and 1 append every record to partition 0.
+	Partitions uint32
+}
+
+// Handle appends the message to the sink's topic, returning once the log stored it.
+func (s *Sink) Handle(ctx context.Context, raw []byte, addr net.Addr) error {
+	_, err := s.Client.Produce(ctx, s.produceRequest(raw, addr))
+	return err
+}
+
+// produceRequest returns the request appending the message.
+func (s *Sink) produceRequest(raw []byte, addr net.Addr) *apiv2.ProduceRequest {
+	headers := make(map[string]string)
+	var key string
+	if addr != nil {
+		headers[headerPrefix+"remote_addr"] = addr.String()
+		key, _, _ = net.SplitHostPort(addr.String())
+	}
+	msg, err := Parse(raw)
+	if err != nil {
+		headers[headerPrefix+"error"] = err.Error()
+	} else {
+		headers[headerPrefix+"facility"] = msg.FacilityName()
+		headers[headerPrefix+"severity"] = msg.SeverityName()
+		if !msg.Timestamp.IsZero() {
+			headers[headerPrefix+"timestamp"] = msg.Timestamp.Format(time.RFC3339Nano)
+		}
+		for name, value := range map[string]string{
+			"hostname": msg.Hostname,
+			"app":      msg.AppName,
+			"procid":   msg.ProcID,
+			"msgid":    msg.MsgID,
+		} {
+			if value != "" {
+				headers[headerPrefix+name] = value
+			}
+		}
+		for id, params := range msg.StructuredData {
+			for name, values := range params {
+				headers[headerPrefix+"sd."+id+"."+name] = strings.Join(values, ",")
+			}
+		}
+		if msg.Hostname != "" {
+			key = msg.Hostname
+		}
+	}
+
+	var partition uint32
+	if s.Partitions > 1 {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		partition = h.Sum32() % s.Partitions
+	}
+	return &apiv2.ProduceRequest{
+		Topic:     s.Topic,
+		Partition: partition,
+		Record: &apiv2.Record{
+			Value:   raw,
+			Key:     []byte(key),
+			Headers: headers,
+		},
+	}
+}
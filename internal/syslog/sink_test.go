@@ -0,0 +1,94 @@
+package syslog
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"net"
+	"testing"
+
+	apiv2 "github.com/glauco/proglog/api/v2"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// produceClient records the requests it's asked to produce.
+type produceClient struct {
+	apiv2.LogClient
+	requests []*apiv2.ProduceRequest
+	err      error
+}
+
+func (c *produceClient) Produce(_ context.Context, req *apiv2.ProduceRequest, _ ...grpc.CallOption) (*apiv2.ProduceResponse, error) {
+	c.requests = append(c.requests, req)
+	return &apiv2.ProduceResponse{}, c.err
+}
+
+func TestSink(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 51234}
+	partition := func(key string) uint32 {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return h.Sum32() % 8
+	}
+
+	for scenario, tc := range map[string]struct {
+		raw     string
+		key     string
+		headers map[string]string
+	}{
+		"headers are parsed": {
+			raw: `<27>1 2024-05-01T10:00:00.5+02:00 web-1 nginx 42 ACCESS [origin ip="10.0.0.7" ip="10.0.0.8"] GET /`,
+			key: "web-1",
+			headers: map[string]string{
+				"syslog_facility":     "daemon",
+				"syslog_severity":     "err",
+				"syslog_timestamp":    "2024-05-01T10:00:00.5+02:00",
+				"syslog_hostname":     "web-1",
+				"syslog_app":          "nginx",
+				"syslog_procid":       "42",
+				"syslog_msgid":        "ACCESS",
+				"syslog_sd.origin.ip": "10.0.0.7,10.0.0.8",
+				"syslog_remote_addr":  "10.0.0.7:51234",
+			},
+		},
+		"senders key messages without hostnames": {
+			raw: `<14>1 - - - - - - hello`,
+			key: "10.0.0.7",
+			headers: map[string]string{
+				"syslog_facility":    "user",
+				"syslog_severity":    "info",
+				"syslog_remote_addr": "10.0.0.7:51234",
+			},
+		},
+		"messages not parsed are kept": {
+			raw: `<34>Oct 11 22:14:15 mymachine su: 'su root' failed`,
+			key: "10.0.0.7",
+			headers: map[string]string{
+				"syslog_error":       "syslog: invalid RFC 5424 message: unsupported version",
+				"syslog_remote_addr": "10.0.0.7:51234",
+			},
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			client := &produceClient{}
+			sink := &Sink{Client: client, Topic: "syslog", Partitions: 8}
+			require.NoError(t, sink.Handle(context.Background(), []byte(tc.raw), addr))
+			require.Len(t, client.requests, 1)
+			req := client.requests[0]
+			require.Equal(t, "syslog", req.Topic)
+			require.Equal(t, partition(tc.key), req.Partition)
+			require.Equal(t, tc.raw, string(req.Record.Value))
+			require.Equal(t, tc.key, string(req.Record.Key))
+			require.Equal(t, tc.headers, req.Record.Headers)
+		})
+	}
+}
+
+func TestSinkProduceFailure(t *testing.T) {
+	client := &produceClient{err: errors.New("unavailable")}
+	sink := &Sink{Client: client}
+	// The server then closes the sender's connection, so it sends the message again
+	require.ErrorIs(t, sink.Handle(context.Background(), []byte("<34>1 - - - - - -"), nil), client.err)
+	require.Zero(t, client.requests[0].Partition)
+}
@@ -0,0 +1,124 @@
+// Package systemd integrates the servers with systemd: it accepts the listeners systemd opens
+// for socket-activated services, and notifies systemd of the servers' state, so units of
+// Type=notify are started once the servers are ready to serve rather than once their processes
+// are, without polling health checks. Outside systemd, there are no listeners and notifying is a
+// no-op.
+package systemd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// States the servers notify systemd of.
+const (
+	Ready     = "READY=1"     // Ready tells the server started, or finished reloading.
+	Reloading = "RELOADING=1" // Reloading tells the server is reloading its configuration.
+	Stopping  = "STOPPING=1"  // Stopping tells the server is shutting down.
+)
+
+// listenFDsStart is the first file descriptor systemd passes the listeners at.
+const listenFDsStart = 3
+
+// Listeners returns the listeners systemd passed the process, as socket activation does, in the
+// order of the socket unit's Listen settings, or none if it passed none. It unsets the
+// environment variables passing them, so the processes it starts don't take them for theirs.
+func Listeners() ([]net.Listener, error) {
+	files := listenFiles(listenFDsStart)
+	var listeners []net.Listener
+	for _, f := range files {
+		// FileListener duplicates the file descriptor, so the file is closed either way
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("socket activation: %s isn't a listening socket: %w", f.Name(), err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// Listener returns the listener systemd passed the process, for servers serving on a single
+// socket, or nil if it passed none. It fails if systemd passed several.
+func Listener() (net.Listener, error) {
+	listeners, err := Listeners()
+	if err != nil {
+		return nil, err
+	}
+	switch len(listeners) {
+	case 0:
+		return nil, nil
+	case 1:
+		return listeners[0], nil
+	}
+	for _, l := range listeners {
+		l.Close()
+	}
+	return nil, fmt.Errorf("socket activation: expected a socket, got %d", len(listeners))
+}
+
+// listenFiles returns the files systemd passed from the file descriptor start, as LISTEN_FDS
+// counts them, named as LISTEN_FDNAMES names them, if they were passed to this process.
+func listenFiles(start int) []*os.File {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	// The variables are inherited by child processes, which the files weren't meant for
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	files := make([]*os.File, 0, n)
+	for i := 0; i < n; i++ {
+		fd := start + i
+		syscall.CloseOnExec(fd)
+		name := "fd " + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files = append(files, os.NewFile(uintptr(fd), name))
+	}
+	return files
+}
+
+// Notify notifies systemd of the state, e.g. Ready, through the socket NOTIFY_SOCKET names. It
+// reports whether systemd was notified, which it isn't if the process wasn't started by a
+// unit of Type=notify.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// Sockets named with a leading @ are in the abstract namespace, as Go resolves them
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("notify systemd: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("notify systemd: %w", err)
+	}
+	return true, nil
+}
+
+// NotifyOrLog notifies systemd of the state as Notify does, only logging a failure, as the servers
+// serve whether systemd knows their state or not.
+func NotifyOrLog(state string) {
+	if _, err := Notify(state); err != nil {
+		log.Print(err)
+	}
+}
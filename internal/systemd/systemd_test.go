@@ -0,0 +1,102 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenFiles(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	listener, err := l.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer listener.Close()
+	// passFile passes a copy of the listener's file descriptor, as systemd would
+	passFile := func() int {
+		fd, err := syscall.Dup(int(listener.Fd()))
+		require.NoError(t, err)
+		return fd
+	}
+
+	for scenario, tc := range map[string]struct {
+		pid   int
+		fds   string
+		names string
+		want  string // Name of the file passed, if any; the file descriptor's when empty
+		none  bool
+	}{
+		"passed to the process": {pid: os.Getpid(), fds: "1", names: "rpc", want: "rpc"},
+		"without names":         {pid: os.Getpid(), fds: "1"},
+		"passed to another":     {pid: os.Getpid() + 1, fds: "1", none: true},
+		"none passed":           {pid: os.Getpid(), fds: "0", none: true},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			t.Setenv("LISTEN_PID", strconv.Itoa(tc.pid))
+			t.Setenv("LISTEN_FDS", tc.fds)
+			t.Setenv("LISTEN_FDNAMES", tc.names)
+			fd := passFile()
+			files := listenFiles(fd)
+			if tc.none {
+				syscall.Close(fd)
+				require.Empty(t, files)
+			} else {
+				require.Len(t, files, 1)
+				defer files[0].Close()
+				require.Equal(t, uintptr(fd), files[0].Fd())
+				if tc.want == "" {
+					tc.want = "fd " + strconv.Itoa(fd)
+				}
+				require.Equal(t, tc.want, files[0].Name())
+			}
+			// The variables are unset, so child processes don't take the files for theirs
+			_, ok := os.LookupEnv("LISTEN_FDS")
+			require.False(t, ok)
+		})
+	}
+
+	// The file passed is served as a listener
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	files := listenFiles(passFile())
+	require.Len(t, files, 1)
+	defer files[0].Close()
+	passed, err := net.FileListener(files[0])
+	require.NoError(t, err)
+	defer passed.Close()
+	require.Equal(t, l.Addr().String(), passed.Addr().String())
+}
+
+func TestNotify(t *testing.T) {
+	// Outside systemd, there's no one to notify
+	t.Setenv("NOTIFY_SOCKET", "")
+	notified, err := Notify(Ready)
+	require.NoError(t, err)
+	require.False(t, notified)
+
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+	for _, state := range []string{Ready, Reloading, Stopping} {
+		notified, err := Notify(state)
+		require.NoError(t, err)
+		require.True(t, notified)
+		b := make([]byte, 64)
+		n, err := conn.Read(b)
+		require.NoError(t, err)
+		require.Equal(t, state, string(b[:n]))
+	}
+
+	// A socket no one listens on fails
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+	_, err = Notify(Ready)
+	require.Error(t, err)
+}
@@ -0,0 +1,85 @@
+// Package version reports the build of the proglog binaries: the version they were released as,
+// and the commit they were built from and when, set at link time, e.g.
+//
+//	go build -ldflags "-X github.com/glauco/proglog/internal/version.Version=v1.2.3 \
+//		-X github.com/glauco/proglog/internal/version.Commit=$(git rev-parse HEAD) \
+//		-X github.com/glauco/proglog/internal/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/agent
+//
+// as `make build` does. Binaries built without them fall back on what the Go toolchain embeds,
+// e.g. the commit of the checkout `go build` ran in.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	api "github.com/glauco/proglog/api/v1"
+)
+
+// Set at link time with -ldflags "-X".
+var (
+	Version = "dev" // Version the binaries were released as, e.g. v1.2.3
+	Commit  = ""    // Commit the binaries were built from
+	Date    = ""    // Time the binaries were built, in RFC 3339
+)
+
+// Get returns the build of the running binary.
+func Get() *api.BuildInfo {
+	build := &api.BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		fallback(build, info)
+	}
+	return build
+}
+
+// fallback fills in what wasn't set at link time from the build info the Go toolchain embeds:
+// the module's version, if built with go install, and the commit of the checkout built in.
+func fallback(build *api.BuildInfo, info *debug.BuildInfo) {
+	if build.Version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		build.Version = info.Main.Version
+	}
+	modified := false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if build.Commit == "" {
+				build.Commit = setting.Value
+			}
+		case "vcs.time":
+			if build.Date == "" {
+				build.Date = setting.Value
+			}
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if modified && build.Commit != "" && Commit == "" && !strings.HasSuffix(build.Commit, "-dirty") {
+		// The checkout had uncommitted changes, so the binary isn't quite that commit's
+		build.Commit += "-dirty"
+	}
+}
+
+// String describes the build on a line, e.g. "v1.2.3 (commit 0a1b2c3, built
+// 2024-05-01T10:00:00Z, go1.23.3)".
+func String(build *api.BuildInfo) string {
+	details := []string{}
+	if build.Commit != "" {
+		commit := build.Commit
+		if len(commit) > 12 && !strings.HasSuffix(commit, "-dirty") {
+			commit = commit[:12]
+		}
+		details = append(details, "commit "+commit)
+	}
+	if build.Date != "" {
+		details = append(details, "built "+build.Date)
+	}
+	details = append(details, build.GoVersion)
+	return fmt.Sprintf("%s (%s)", build.Version, strings.Join(details, ", "))
+}
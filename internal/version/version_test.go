@@ -0,0 +1,58 @@
+package version
+
+import (
+	"runtime/debug"
+	"testing"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFallback(t *testing.T) {
+	info := &debug.BuildInfo{
+		Main: debug.Module{Version: "v1.2.3"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0a1b2c3d4e5f60718293a4b5c6d7e8f901234567"},
+			{Key: "vcs.time", Value: "2024-05-01T10:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+	for scenario, tc := range map[string]struct {
+		build *api.BuildInfo
+		want  *api.BuildInfo
+	}{
+		"unset fields come from the toolchain": {
+			build: &api.BuildInfo{Version: "dev"},
+			want: &api.BuildInfo{
+				Version: "v1.2.3",
+				Commit:  "0a1b2c3d4e5f60718293a4b5c6d7e8f901234567-dirty",
+				Date:    "2024-05-01T10:00:00Z",
+			},
+		},
+		"link time fields are kept": {
+			build: &api.BuildInfo{Version: "v2.0.0", Commit: "abc", Date: "2025-01-01T00:00:00Z"},
+			want:  &api.BuildInfo{Version: "v2.0.0", Commit: "abc", Date: "2025-01-01T00:00:00Z"},
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			// Commit is set at link time for the second scenario only
+			old := Commit
+			Commit = tc.build.Commit
+			defer func() { Commit = old }()
+			fallback(tc.build, info)
+			require.Equal(t, tc.want.Version, tc.build.Version)
+			require.Equal(t, tc.want.Commit, tc.build.Commit)
+			require.Equal(t, tc.want.Date, tc.build.Date)
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	require.Equal(t, "v1.2.3 (commit 0a1b2c3d4e5f, built 2024-05-01T10:00:00Z, go1.23.3)", String(&api.BuildInfo{
+		Version:   "v1.2.3",
+		Commit:    "0a1b2c3d4e5f60718293a4b5c6d7e8f901234567",
+		Date:      "2024-05-01T10:00:00Z",
+		GoVersion: "go1.23.3",
+	}))
+	require.Equal(t, "dev (go1.23.3)", String(&api.BuildInfo{Version: "dev", GoVersion: "go1.23.3"}))
+}
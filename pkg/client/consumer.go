@@ -0,0 +1,264 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Consumer defaults.
+const (
+	defaultCommitInterval = 5 * time.Second
+	defaultMinBackoff     = 100 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+)
+
+// OffsetStore persists a consumer's position, so a Consumer restarted with the same store resumes
+// where the previous one left off. The log's server doesn't store consumers' offsets yet, so
+// stores persist them on the client's side, e.g. in a file or a database.
+type OffsetStore interface {
+	// Load returns the offset of the next record to consume, and false if none was committed.
+	Load(ctx context.Context) (offset uint64, ok bool, err error)
+	// Commit stores the offset of the next record to consume.
+	Commit(ctx context.Context, offset uint64) error
+}
+
+// ConsumerConfig configures a Consumer.
+type ConsumerConfig struct {
+	// Offset is where the Consumer starts if Store holds no offset.
+	Offset uint64
+	// Store, if set, is where the Consumer loads its position from and commits it to.
+	Store OffsetStore
+	// CommitInterval is how often the position is committed to Store, if it moved; defaults to 5s.
+	// It's also committed once the Consumer stops.
+	CommitInterval time.Duration
+	// MinBackoff and MaxBackoff bound how long the Consumer waits before reconnecting after its
+	// stream failed, doubling the wait at each failure in a row; they default to 100ms and 5s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Consumer consumes the log from its position, over a ConsumeStream it reopens from the last
+// record processed if it fails, e.g. as the server restarted, so records are processed in order,
+// once each as long as the Consumer runs. It delivers the records to a handler or a channel, and
+// commits its position to an OffsetStore, so a Consumer restarted with the same store resumes
+// where the previous one left off; records processed after the last commit are processed again.
+type Consumer struct {
+	client api.LogClient
+	config ConsumerConfig
+
+	mu        sync.Mutex
+	position  uint64 // Offset of the next record to process
+	committed uint64 // Position last committed
+	loaded    bool   // Whether the position was loaded from the store
+	err       error  // Error that stopped the channel delivering the records
+}
+
+// NewConsumer creates a Consumer consuming the log through the client.
+func NewConsumer(client api.LogClient, config ConsumerConfig) *Consumer {
+	if config.CommitInterval <= 0 {
+		config.CommitInterval = defaultCommitInterval
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = defaultMinBackoff
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = max(defaultMaxBackoff, config.MinBackoff)
+	}
+	return &Consumer{
+		client:    client,
+		config:    config,
+		position:  config.Offset,
+		committed: config.Offset,
+	}
+}
+
+// Position returns the offset of the next record the Consumer processes.
+func (c *Consumer) Position() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.position
+}
+
+// Run consumes the records from the Consumer's position and calls handler with each, in order,
+// until ctx is done or handler fails, and returns why it stopped: handler's error, or ctx's.
+// Records are processed once handler returns nil; one it fails is processed again by the next
+// run. The position is committed every CommitInterval, and once Run stops.
+func (c *Consumer) Run(ctx context.Context, handler func(context.Context, *api.Record) error) (err error) {
+	if err := c.load(ctx); err != nil {
+		return err
+	}
+	commitCtx, stopCommitting := context.WithCancel(ctx)
+	committing := make(chan struct{})
+	go func() {
+		defer close(committing)
+		c.commitEvery(commitCtx)
+	}()
+	defer func() {
+		stopCommitting()
+		<-committing
+		// Commit the last records processed, even though ctx is done
+		if cerr := c.Commit(context.WithoutCancel(ctx)); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	backoff := c.config.MinBackoff
+	for {
+		processed, err := c.consume(ctx, handler)
+		var herr handlerError
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.As(err, &herr):
+			return herr.err
+		case !retriable(err):
+			return err
+		}
+		if processed {
+			backoff = c.config.MinBackoff
+		}
+		// Wait before reconnecting, so a server that's down isn't flooded
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, c.config.MaxBackoff)
+	}
+}
+
+// handlerError is an error of the handler, which stops the Consumer rather than reconnecting.
+type handlerError struct {
+	err error
+}
+
+func (e handlerError) Error() string { return e.err.Error() }
+
+// consume streams the records from the position to handler until the stream fails, and returns
+// whether any record was processed, with the error.
+func (c *Consumer) consume(ctx context.Context, handler func(context.Context, *api.Record) error) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: c.Position()})
+	if err != nil {
+		return false, err
+	}
+	processed := false
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return processed, err
+		}
+		if err := handler(ctx, res.Record); err != nil {
+			return processed, handlerError{err}
+		}
+		processed = true
+		c.mu.Lock()
+		c.position = res.Record.Offset + 1
+		c.mu.Unlock()
+	}
+}
+
+// retriable reports whether the stream failed in a way reconnecting may get past, e.g. as the
+// server restarted or closed the stream after it stayed idle. Other errors, e.g. the consumer not
+// being allowed to consume, stop the Consumer.
+func retriable(err error) bool {
+	if err == nil || errors.Is(err, io.EOF) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded, codes.Internal:
+		return true
+	}
+	return false
+}
+
+// Records runs the Consumer like Run, delivering the records to the returned channel, which is
+// closed once the Consumer stops; Err then returns why. A record is processed once it's received
+// from the channel.
+func (c *Consumer) Records(ctx context.Context) <-chan *api.Record {
+	records := make(chan *api.Record)
+	go func() {
+		defer close(records)
+		err := c.Run(ctx, func(ctx context.Context, record *api.Record) error {
+			select {
+			case records <- record:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+	}()
+	return records
+}
+
+// Err returns why the channel returned by Records was closed, or nil while it's open.
+func (c *Consumer) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Commit commits the position to the store, if set and the position moved since last committed.
+func (c *Consumer) Commit(ctx context.Context) error {
+	if c.config.Store == nil {
+		return nil
+	}
+	c.mu.Lock()
+	position, committed := c.position, c.committed
+	c.mu.Unlock()
+	if position == committed {
+		return nil
+	}
+	if err := c.config.Store.Commit(ctx, position); err != nil {
+		return fmt.Errorf("commit offset %d: %w", position, err)
+	}
+	c.mu.Lock()
+	c.committed = position
+	c.mu.Unlock()
+	return nil
+}
+
+// commitEvery commits the position every CommitInterval until ctx is done. Failed commits are
+// retried at the next one.
+func (c *Consumer) commitEvery(ctx context.Context) {
+	ticker := time.NewTicker(c.config.CommitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Commit(ctx)
+		}
+	}
+}
+
+// load loads the position from the store the first time the Consumer runs.
+func (c *Consumer) load(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loaded || c.config.Store == nil {
+		return nil
+	}
+	offset, ok, err := c.config.Store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("load offset: %w", err)
+	}
+	if ok {
+		c.position, c.committed = offset, offset
+	}
+	c.loaded = true
+	return nil
+}
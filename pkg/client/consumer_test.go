@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConsumer(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, root, nobody api.LogClient){
+		"handler processes records and commits":   testConsumerHandler,
+		"restarted consumer resumes from store":   testConsumerResume,
+		"failed stream resumes from last record":  testConsumerReconnect,
+		"channel delivers records until canceled": testConsumerRecords,
+		"permanent errors stop the consumer":      testConsumerPermanentError,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			root, nobody := setupServer(t)
+			ctx := context.Background()
+			for _, value := range []string{"first", "second", "third"} {
+				_, err := root.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte(value)}})
+				require.NoError(t, err)
+			}
+			fn(t, root, nobody)
+		})
+	}
+}
+
+func testConsumerHandler(t *testing.T, root, _ api.LogClient) {
+	store := &memoryStore{}
+	c := NewConsumer(root, ConsumerConfig{Store: store})
+	ctx, cancel := context.WithCancel(context.Background())
+	var values []string
+	err := c.Run(ctx, func(_ context.Context, record *api.Record) error {
+		values = append(values, string(record.Value))
+		if len(values) == 3 {
+			cancel()
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, []string{"first", "second", "third"}, values)
+	require.Equal(t, uint64(3), c.Position())
+	// The position is committed once the consumer stops
+	require.Equal(t, uint64(3), store.offset())
+
+	// A failing handler stops the consumer, and its record is processed again next time
+	_, err = root.Produce(context.Background(), &api.ProduceRequest{Record: &api.Record{Value: []byte("fourth")}})
+	require.NoError(t, err)
+	failure := errors.New("failed")
+	err = c.Run(context.Background(), func(context.Context, *api.Record) error { return failure })
+	require.ErrorIs(t, err, failure)
+	require.Equal(t, uint64(3), c.Position())
+}
+
+func testConsumerResume(t *testing.T, root, _ api.LogClient) {
+	store := &memoryStore{}
+	require.NoError(t, store.Commit(context.Background(), 2))
+	c := NewConsumer(root, ConsumerConfig{Store: store})
+	ctx, cancel := context.WithCancel(context.Background())
+	var offsets []uint64
+	c.Run(ctx, func(_ context.Context, record *api.Record) error {
+		offsets = append(offsets, record.Offset)
+		cancel()
+		return nil
+	})
+	require.Equal(t, []uint64{2}, offsets)
+}
+
+func testConsumerReconnect(t *testing.T, root, _ api.LogClient) {
+	// The first stream fails after a record, as if the server went away
+	flaky := &flakyClient{LogClient: root, failAfter: 1}
+	c := NewConsumer(flaky, ConsumerConfig{MinBackoff: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	var offsets []uint64
+	c.Run(ctx, func(_ context.Context, record *api.Record) error {
+		offsets = append(offsets, record.Offset)
+		if len(offsets) == 3 {
+			cancel()
+		}
+		return nil
+	})
+	require.Equal(t, []uint64{0, 1, 2}, offsets)
+	require.Equal(t, []uint64{0, 1}, flaky.starts)
+}
+
+func testConsumerRecords(t *testing.T, root, _ api.LogClient) {
+	c := NewConsumer(root, ConsumerConfig{Offset: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	records := c.Records(ctx)
+	record := <-records
+	require.Equal(t, "second", string(record.Value))
+	require.Nil(t, c.Err())
+	cancel()
+	for range records {
+	}
+	require.ErrorIs(t, c.Err(), context.Canceled)
+}
+
+func testConsumerPermanentError(t *testing.T, _, nobody api.LogClient) {
+	c := NewConsumer(nobody, ConsumerConfig{})
+	err := c.Run(context.Background(), func(context.Context, *api.Record) error { return nil })
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// memoryStore is an OffsetStore holding the offset in memory.
+type memoryStore struct {
+	mu        sync.Mutex
+	committed *uint64
+}
+
+func (s *memoryStore) Load(context.Context) (uint64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.committed == nil {
+		return 0, false, nil
+	}
+	return *s.committed, true, nil
+}
+
+func (s *memoryStore) Commit(_ context.Context, offset uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.committed = &offset
+	return nil
+}
+
+func (s *memoryStore) offset() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.committed
+}
+
+// flakyClient is a client whose first ConsumeStream fails after failAfter records, and which
+// records the offsets its streams start at.
+type flakyClient struct {
+	api.LogClient
+	failAfter int
+	starts    []uint64
+}
+
+func (c *flakyClient) ConsumeStream(ctx context.Context, req *api.ConsumeRequest, opts ...grpc.CallOption) (api.Log_ConsumeStreamClient, error) {
+	c.starts = append(c.starts, req.Offset)
+	stream, err := c.LogClient.ConsumeStream(ctx, req, opts...)
+	if err != nil || len(c.starts) > 1 {
+		return stream, err
+	}
+	return &flakyStream{Log_ConsumeStreamClient: stream, left: c.failAfter}, nil
+}
+
+// flakyStream is a stream failing as Unavailable after its left records.
+type flakyStream struct {
+	api.Log_ConsumeStreamClient
+	left int
+}
+
+func (s *flakyStream) Recv() (*api.ConsumeResponse, error) {
+	if s.left == 0 {
+		return nil, status.Error(codes.Unavailable, "server went away")
+	}
+	s.left--
+	return s.Log_ConsumeStreamClient.Recv()
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FileStore is an OffsetStore keeping the offset in a local file, a bookmark, so scripts
+// consuming the log resume where they left off when run again. The file holds the offset in
+// decimal, and is replaced atomically at each commit, so a crash leaves the previous offset
+// rather than a partly written one. Consumers mustn't share a file.
+type FileStore struct {
+	path string
+}
+
+// NewFileStore returns a store keeping the offset in the file, created with its directory at the
+// first commit.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load returns the offset in the file, and false if the file doesn't exist.
+func (s *FileStore) Load(context.Context) (uint64, bool, error) {
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	offset, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("parse offset in %s: %w", s.path, err)
+	}
+	return offset, true, nil
+}
+
+// Commit writes the offset to a temporary file in the file's directory, syncs it, and renames it
+// over the file.
+func (s *FileStore) Commit(_ context.Context, offset uint64) error {
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := fmt.Fprintf(f, "%d\n", offset); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "bookmarks", "orders")
+	store := NewFileStore(path)
+
+	// Nothing was committed before the file exists
+	_, ok, err := store.Load(ctx)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// Commits create the file and its directory, and replace the previous offset
+	require.NoError(t, store.Commit(ctx, 42))
+	require.NoError(t, store.Commit(ctx, 43))
+	offset, ok, err := NewFileStore(path).Load(ctx)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(43), offset)
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "temporary files are left behind")
+
+	// Files that don't hold an offset fail to load
+	require.NoError(t, os.WriteFile(path, []byte("not an offset"), 0600))
+	_, _, err = store.Load(ctx)
+	require.ErrorContains(t, err, "parse offset")
+}
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+
+	api "github.com/glauco/proglog/api/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Offsets returns the range of offsets the log holds, so its records are at [lowest, next) and
+// the next record produced gets next. It consumes past the log's end, which fails with the log's
+// range in the error's details.
+func Offsets(ctx context.Context, client api.LogClient) (lowest, next uint64, err error) {
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: math.MaxUint64})
+	if status.Code(err) != codes.OutOfRange {
+		return 0, 0, fmt.Errorf("consume past the log's end: %w", err)
+	}
+	for _, detail := range status.Convert(err).Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if !ok || info.Reason != api.ReasonOffsetOutOfRange {
+			continue
+		}
+		if lowest, err = strconv.ParseUint(info.Metadata["lowest_offset"], 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("parse lowest offset: %w", err)
+		}
+		if next, err = strconv.ParseUint(info.Metadata["next_offset"], 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("parse next offset: %w", err)
+		}
+		return lowest, next, nil
+	}
+	return 0, 0, fmt.Errorf("consume past the log's end: no offsets in %w", err)
+}
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestOffsets(t *testing.T) {
+	root, nobody := setupServer(t)
+	ctx := context.Background()
+
+	// An empty log holds no offsets
+	lowest, next, err := Offsets(ctx, root)
+	require.NoError(t, err)
+	require.Zero(t, lowest)
+	require.Zero(t, next)
+
+	for i := 0; i < 2; i++ {
+		_, err := root.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("record")}})
+		require.NoError(t, err)
+	}
+	lowest, next, err = Offsets(ctx, root)
+	require.NoError(t, err)
+	require.Zero(t, lowest)
+	require.Equal(t, uint64(2), next)
+
+	// Errors other than the log's range are returned as is
+	_, _, err = Offsets(ctx, nobody)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
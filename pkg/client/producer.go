@@ -0,0 +1,306 @@
+// Package client provides higher-level clients of the log's gRPC API, which batch, track
+// positions and recover from failures on top of the generated api.LogClient.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// Producer defaults.
+const (
+	defaultBatchSize  = 100
+	defaultBatchBytes = 1 << 20
+	defaultLinger     = 5 * time.Millisecond
+)
+
+// ErrProducerClosed is the error of records produced once the Producer is closed.
+var ErrProducerClosed = errors.New("producer closed")
+
+// ProducerConfig configures a Producer.
+type ProducerConfig struct {
+	// BatchSize flushes the buffered records once they're this many; defaults to 100.
+	BatchSize int
+	// BatchBytes flushes the buffered records once their encoded size reaches it; defaults to 1MiB.
+	BatchBytes int
+	// Linger flushes the buffered records this long after the first was buffered, however few
+	// they are; defaults to 5ms.
+	Linger time.Duration
+	// Acks is how durably the server stores the records before acknowledging them.
+	Acks api.Acks
+	// Results, if set, receives the result of every record produced without a callback, in the
+	// order they were produced. It must be read from, or it blocks the Producer.
+	Results chan<- *ProduceResult
+}
+
+// ProduceResult is the result of producing a record: the offset it was stored at, or the error.
+type ProduceResult struct {
+	Record *api.Record
+	Offset uint64
+	Err    error
+}
+
+// Producer buffers records and produces them in batches over a ProduceStream, flushing a batch
+// once it holds BatchSize records or BatchBytes bytes, or Linger after its first record was
+// buffered. Records are produced in the order they were buffered, and their results reported the
+// same way, so a Producer suits high-throughput producers that don't wait for each record.
+//
+// Records aren't retried: if the stream fails, every record of the batch not acknowledged yet
+// fails with its error, as they may or may not have been stored. The next batch opens a new
+// stream. A Producer is safe for concurrent use.
+type Producer struct {
+	client api.LogClient
+	config ProducerConfig
+
+	records chan *pendingRecord // Records to buffer, closed once the Producer is closed
+	flushes chan chan struct{}  // Flushes asked for, each closing its channel once done
+	done    chan struct{}       // Closed once the buffered records are flushed after closing
+	ctx     context.Context     // Context of the streams, canceled once done
+	cancel  context.CancelFunc
+
+	mu     sync.RWMutex // Held for reading while records are queued, for writing to close
+	closed bool
+
+	// Only the Producer's goroutine accesses the stream and the batch
+	stream  api.Log_ProduceStreamClient
+	pending []*pendingRecord // Batch being buffered
+	size    int              // Encoded size of the batch
+}
+
+// pendingRecord is a buffered record and how to report its result.
+type pendingRecord struct {
+	record   *api.Record
+	callback func(offset uint64, err error)
+}
+
+// NewProducer creates a Producer producing to the log through the client. It must be closed.
+func NewProducer(client api.LogClient, config ProducerConfig) *Producer {
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.BatchBytes <= 0 {
+		config.BatchBytes = defaultBatchBytes
+	}
+	if config.Linger <= 0 {
+		config.Linger = defaultLinger
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Producer{
+		client:  client,
+		config:  config,
+		records: make(chan *pendingRecord, config.BatchSize),
+		flushes: make(chan chan struct{}),
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	go p.run()
+	return p
+}
+
+// Produce buffers the record for the next batch. callback, if set, is called with the offset the
+// record was stored at or the error, by the Producer's goroutine, so it must not block; records
+// without a callback report their result to the config's Results channel, if set. Produce blocks
+// while the buffer is full, until ctx is done.
+func (p *Producer) Produce(ctx context.Context, record *api.Record, callback func(offset uint64, err error)) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrProducerClosed
+	}
+	select {
+	case p.records <- &pendingRecord{record: record, callback: callback}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush produces the records buffered before it was called, and waits until they're acknowledged
+// or failed, or ctx is done.
+func (p *Producer) Flush(ctx context.Context) error {
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return ErrProducerClosed
+	}
+	flushed := make(chan struct{})
+	select {
+	case p.flushes <- flushed:
+	case <-ctx.Done():
+		p.mu.RUnlock()
+		return ctx.Err()
+	}
+	p.mu.RUnlock()
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close flushes the buffered records, waits for their results and closes the stream. Records
+// produced afterwards fail with ErrProducerClosed.
+func (p *Producer) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.done
+		return nil
+	}
+	p.closed = true
+	// Produce and Flush hold the lock for reading, so nothing is sent anymore
+	close(p.records)
+	p.mu.Unlock()
+	<-p.done
+	return nil
+}
+
+// run buffers the records into batches and flushes them, until the Producer is closed.
+func (p *Producer) run() {
+	defer close(p.done)
+	defer p.cancel()
+	linger := time.NewTimer(p.config.Linger)
+	linger.Stop()
+	for {
+		select {
+		case pr, ok := <-p.records:
+			if !ok {
+				p.flush()
+				p.closeStream()
+				return
+			}
+			if len(p.pending) == 0 {
+				linger.Reset(p.config.Linger)
+			}
+			p.pending = append(p.pending, pr)
+			p.size += proto.Size(pr.record)
+			if len(p.pending) >= p.config.BatchSize || p.size >= p.config.BatchBytes {
+				linger.Stop()
+				p.flush()
+			}
+		case <-linger.C:
+			p.flush()
+		case flushed := <-p.flushes:
+			// Take the records buffered before the flush was asked for, which are already queued
+			p.drain()
+			linger.Stop()
+			p.flush()
+			close(flushed)
+		}
+	}
+}
+
+// drain buffers the records queued without waiting for more, flushing full batches.
+func (p *Producer) drain() {
+	for {
+		select {
+		case pr, ok := <-p.records:
+			if !ok {
+				return
+			}
+			p.pending = append(p.pending, pr)
+			p.size += proto.Size(pr.record)
+			if len(p.pending) >= p.config.BatchSize || p.size >= p.config.BatchBytes {
+				p.flush()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// flush produces the batch: it sends every record while receiving their results in order, so the
+// batch takes a single round trip, then reports the results.
+func (p *Producer) flush() {
+	batch := p.pending
+	p.pending, p.size = nil, 0
+	if len(batch) == 0 {
+		return
+	}
+	stream, err := p.openStream()
+	if err != nil {
+		p.fail(batch, err)
+		return
+	}
+	// Receive while sending, so large batches don't fill the stream both ways
+	type received struct {
+		offsets []uint64
+		err     error
+	}
+	receivedc := make(chan received, 1)
+	go func() {
+		var r received
+		for range batch {
+			res, err := stream.Recv()
+			if err != nil {
+				r.err = err
+				break
+			}
+			r.offsets = append(r.offsets, res.Offset)
+		}
+		receivedc <- r
+	}()
+	for _, pr := range batch {
+		if err := stream.Send(&api.ProduceRequest{Record: pr.record, Acks: p.config.Acks}); err != nil {
+			// The stream broke; receiving fails with its status
+			break
+		}
+	}
+	r := <-receivedc
+	for i, offset := range r.offsets {
+		p.report(batch[i], offset, nil)
+	}
+	if r.err != nil {
+		// The records not acknowledged may have been stored, but that can't be known anymore
+		p.closeStream()
+		p.fail(batch[len(r.offsets):], r.err)
+	}
+}
+
+// openStream returns the stream to produce with, opening one if there's none.
+func (p *Producer) openStream() (api.Log_ProduceStreamClient, error) {
+	if p.stream != nil {
+		return p.stream, nil
+	}
+	stream, err := p.client.ProduceStream(p.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open produce stream: %w", err)
+	}
+	p.stream = stream
+	return stream, nil
+}
+
+// closeStream closes the stream, if open, so the next batch opens another.
+func (p *Producer) closeStream() {
+	if p.stream == nil {
+		return
+	}
+	p.stream.CloseSend()
+	p.stream = nil
+}
+
+// fail reports the error as the result of the records.
+func (p *Producer) fail(batch []*pendingRecord, err error) {
+	for _, pr := range batch {
+		p.report(pr, 0, err)
+	}
+}
+
+// report reports the record's result to its callback, or to the Results channel.
+func (p *Producer) report(pr *pendingRecord, offset uint64, err error) {
+	if pr.callback != nil {
+		pr.callback(offset, err)
+		return
+	}
+	if p.config.Results != nil {
+		p.config.Results <- &ProduceResult{Record: pr.record, Offset: offset, Err: err}
+	}
+}
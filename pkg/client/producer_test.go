@@ -0,0 +1,173 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/auth"
+	"github.com/glauco/proglog/internal/config"
+	"github.com/glauco/proglog/internal/log"
+	"github.com/glauco/proglog/internal/server"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+func TestProducer(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, root, nobody api.LogClient){
+		"full batches are flushed right away":    testProducerBatchSize,
+		"results go to the channel after linger": testProducerResults,
+		"flush waits for the buffered records":   testProducerFlush,
+		"failed batches fail their records":      testProducerFailure,
+		"closing flushes the buffered records":   testProducerClose,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			root, nobody := setupServer(t)
+			fn(t, root, nobody)
+		})
+	}
+}
+
+func testProducerBatchSize(t *testing.T, root, _ api.LogClient) {
+	// Lingering longer than the test, only full batches are flushed
+	p := NewProducer(root, ProducerConfig{BatchSize: 3, Linger: time.Hour})
+	defer p.Close()
+	ctx := context.Background()
+	var (
+		mu      sync.Mutex
+		offsets []uint64
+	)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, p.Produce(ctx, &api.Record{Value: []byte("record")}, func(offset uint64, err error) {
+			require.NoError(t, err)
+			mu.Lock()
+			defer mu.Unlock()
+			offsets = append(offsets, offset)
+		}))
+	}
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(offsets) == 3
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []uint64{0, 1, 2}, offsets)
+}
+
+func testProducerResults(t *testing.T, root, _ api.LogClient) {
+	results := make(chan *ProduceResult, 2)
+	p := NewProducer(root, ProducerConfig{Linger: 10 * time.Millisecond, Results: results})
+	defer p.Close()
+	ctx := context.Background()
+	for _, value := range []string{"first", "second"} {
+		require.NoError(t, p.Produce(ctx, &api.Record{Value: []byte(value)}, nil))
+	}
+	for i, value := range []string{"first", "second"} {
+		select {
+		case res := <-results:
+			require.NoError(t, res.Err)
+			require.Equal(t, uint64(i), res.Offset)
+			require.Equal(t, value, string(res.Record.Value))
+		case <-time.After(time.Second):
+			t.Fatal("no result after lingering")
+		}
+	}
+}
+
+func testProducerFlush(t *testing.T, root, _ api.LogClient) {
+	p := NewProducer(root, ProducerConfig{Linger: time.Hour})
+	defer p.Close()
+	ctx := context.Background()
+	var offset uint64
+	require.NoError(t, p.Produce(ctx, &api.Record{Value: []byte("record")}, nil))
+	require.NoError(t, p.Produce(ctx, &api.Record{Value: []byte("record")}, func(off uint64, err error) {
+		require.NoError(t, err)
+		offset = off
+	}))
+	require.NoError(t, p.Flush(ctx))
+	require.Equal(t, uint64(1), offset)
+	res, err := root.Consume(ctx, &api.ConsumeRequest{Offset: 1})
+	require.NoError(t, err)
+	require.Equal(t, "record", string(res.Record.Value))
+}
+
+func testProducerFailure(t *testing.T, root, nobody api.LogClient) {
+	p := NewProducer(nobody, ProducerConfig{Linger: time.Hour})
+	defer p.Close()
+	ctx := context.Background()
+	var errs []error
+	for i := 0; i < 2; i++ {
+		require.NoError(t, p.Produce(ctx, &api.Record{Value: []byte("record")}, func(_ uint64, err error) {
+			errs = append(errs, err)
+		}))
+	}
+	require.NoError(t, p.Flush(ctx))
+	require.Len(t, errs, 2)
+	for _, err := range errs {
+		require.Equal(t, codes.PermissionDenied, status.Code(err))
+	}
+}
+
+func testProducerClose(t *testing.T, root, _ api.LogClient) {
+	p := NewProducer(root, ProducerConfig{Linger: time.Hour})
+	ctx := context.Background()
+	produced := false
+	require.NoError(t, p.Produce(ctx, &api.Record{Value: []byte("record")}, func(_ uint64, err error) {
+		require.NoError(t, err)
+		produced = true
+	}))
+	require.NoError(t, p.Close())
+	require.True(t, produced)
+	require.ErrorIs(t, p.Produce(ctx, &api.Record{}, nil), ErrProducerClosed)
+	require.ErrorIs(t, p.Flush(ctx), ErrProducerClosed)
+	require.NoError(t, p.Close())
+}
+
+// setupServer starts a server of a log in a temporary directory, and returns clients of it
+// authenticated as root, allowed to do anything, and as nobody, allowed nothing.
+func setupServer(t *testing.T) (root, nobody api.LogClient) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.ServerCertFile,
+		KeyFile:       config.ServerKeyFile,
+		CAFile:        config.CAFile,
+		Server:        true,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	srv, err := server.NewGRPCServer(&server.Config{
+		CommitLog:  clog,
+		Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile),
+	}, server.WithTLS(serverTLSConfig))
+	require.NoError(t, err)
+	go srv.Serve(l)
+	t.Cleanup(func() {
+		srv.Stop()
+		clog.Close()
+	})
+
+	newClient := func(certFile, keyFile string) api.LogClient {
+		tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+			CertFile:      certFile,
+			KeyFile:       keyFile,
+			CAFile:        config.CAFile,
+			ServerAddress: "127.0.0.1",
+		})
+		require.NoError(t, err)
+		conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return api.NewLogClient(conn)
+	}
+	return newClient(config.RootClientCertFile, config.RootClientKeyFile),
+		newClient(config.NobodyClientCertFile, config.NobodyClientKeyFile)
+}
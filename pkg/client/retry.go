@@ -0,0 +1,225 @@
+package client
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	apiv2 "github.com/glauco/proglog/api/v2"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// RetryPolicy defaults.
+const (
+	defaultMaxAttempts       = 4
+	defaultInitialBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff   = 5 * time.Second
+	defaultBackoffMultiplier = 2
+)
+
+// defaultRetryableCodes are the codes of the failures worth retrying by default: the server
+// couldn't be reached, e.g. while a leader is elected, or throttled the client.
+var defaultRetryableCodes = []codes.Code{codes.Unavailable, codes.ResourceExhausted}
+
+// idempotentMethods are the unary methods calling again has the same effect as calling once, as
+// they only read, so they're retried and hedged.
+var idempotentMethods = []string{
+	api.Log_Consume_FullMethodName,
+	api.Log_GetServers_FullMethodName,
+	api.Log_GetVersion_FullMethodName,
+	apiv2.Log_Consume_FullMethodName,
+	api.Admin_DescribeCluster_FullMethodName,
+	api.Admin_DescribeReplica_FullMethodName,
+	api.Admin_GetLeadership_FullMethodName,
+	api.Admin_ListTopics_FullMethodName,
+	api.Admin_GetConfig_FullMethodName,
+	api.Debug_ListStreams_FullMethodName,
+}
+
+// RetryPolicy configures how unary calls failing with a retryable code are retried, waiting an
+// exponential backoff with jitter between attempts, or hedged. Its zero value retries the
+// idempotent calls up to 3 times, and never hedges.
+//
+// Calls that read are idempotent. Produces aren't, as a produce failing may have appended its
+// record anyway, e.g. when the connection dropped before the response arrived, so retrying it
+// may append the record twice. They're only retried if they're conditional, which makes retries
+// safe: a retry of a produce that was appended fails with FailedPrecondition, as the offset
+// expected is taken. Other produces are retried only if RetryProduce is set. The other
+// calls that write, and streams, are never retried; Consumers reconnect their streams themselves.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a call is attempted at most, including the first; defaults to
+	// 4. 1 disables retries and hedging.
+	MaxAttempts int
+	// InitialBackoff is how long to wait before the first retry, multiplied by BackoffMultiplier
+	// at each retry up to MaxBackoff; they default to 100ms, 2 and 5s. Each wait is picked at
+	// random up to the backoff, so clients failing together don't retry together. Waits are
+	// at least as long as the server asks for in a RetryInfo, e.g. when throttling the client.
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+	// RetryableCodes are the codes of the failures retried; default to Unavailable and
+	// ResourceExhausted.
+	RetryableCodes []codes.Code
+	// RetryProduce retries unconditional produces too, for producers that tolerate records
+	// appended twice, e.g. as their consumers deduplicate them.
+	RetryProduce bool
+	// HedgingDelay, if set, hedges the idempotent calls instead of retrying them: another attempt
+	// is sent each HedgingDelay the previous ones haven't answered, or right away when one fails
+	// with a retryable code, up to MaxAttempts, and the first to answer is used. Hedging lowers
+	// the tail latency of reads at the cost of load on the servers; long polls shouldn't be hedged.
+	HedgingDelay time.Duration
+}
+
+// WithRetryPolicy returns the dial option retrying and hedging the calls of a client connection
+// as the policy says.
+func WithRetryPolicy(policy RetryPolicy) grpc.DialOption {
+	return grpc.WithChainUnaryInterceptor(policy.UnaryClientInterceptor())
+}
+
+// withDefaults returns the policy with its defaults set.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultMaxAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = defaultInitialBackoff
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		p.MaxBackoff = max(defaultRetryMaxBackoff, p.InitialBackoff)
+	}
+	if p.BackoffMultiplier < 1 {
+		p.BackoffMultiplier = defaultBackoffMultiplier
+	}
+	if p.RetryableCodes == nil {
+		p.RetryableCodes = defaultRetryableCodes
+	}
+	return p
+}
+
+// UnaryClientInterceptor returns the interceptor retrying and hedging unary calls as the policy
+// says.
+func (p RetryPolicy) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	p = p.withDefaults()
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		idempotent := slices.Contains(idempotentMethods, method)
+		switch {
+		case p.MaxAttempts == 1:
+			return invoker(ctx, method, req, reply, cc, opts...)
+		case idempotent && p.HedgingDelay > 0:
+			return p.hedge(ctx, method, req, reply, cc, invoker, opts)
+		case idempotent || p.retriesProduce(req):
+			return p.retry(ctx, method, req, reply, cc, invoker, opts)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// retriesProduce returns whether the request is a produce the policy retries.
+func (p RetryPolicy) retriesProduce(req any) bool {
+	switch req := req.(type) {
+	case *api.ProduceRequest:
+		return p.RetryProduce || req.ExpectedOffset != nil
+	case *apiv2.ProduceRequest:
+		return p.RetryProduce || req.ExpectedOffset != nil
+	}
+	return false
+}
+
+// retryable returns whether the policy retries the call's error.
+func (p RetryPolicy) retryable(err error) bool {
+	return slices.Contains(p.RetryableCodes, status.Code(err))
+}
+
+// retry calls the method until it succeeds, fails with a code that isn't retryable, or was
+// attempted MaxAttempts times, waiting a backoff between attempts.
+func (p RetryPolicy) retry(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts []grpc.CallOption) error {
+	backoff := p.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil || attempt == p.MaxAttempts || !p.retryable(err) {
+			return err
+		}
+		// Wait before retrying, so a server that's down or throttling isn't flooded
+		wait := max(rand.N(backoff)+1, retryDelay(err))
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-time.After(wait):
+		}
+		backoff = min(time.Duration(float64(backoff)*p.BackoffMultiplier), p.MaxBackoff)
+	}
+}
+
+// hedgedResult is the result of a hedged attempt.
+type hedgedResult struct {
+	reply any
+	err   error
+}
+
+// hedge sends an attempt of the call every HedgingDelay, or as soon as one fails with a retryable
+// code, up to MaxAttempts, and returns the first to succeed or fail with a code that isn't
+// retryable, canceling the others. If every attempt fails, it returns the last's error.
+func (p RetryPolicy) hedge(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts []grpc.CallOption) error {
+	msg, ok := reply.(proto.Message)
+	if !ok {
+		return p.retry(ctx, method, req, reply, cc, invoker, opts)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Attempts write their own replies, as they run concurrently, and the buffer lets those
+	// losing finish once the call returned
+	results := make(chan hedgedResult, p.MaxAttempts)
+	send := func() {
+		attemptReply := msg.ProtoReflect().New().Interface()
+		go func() {
+			err := invoker(ctx, method, req, attemptReply, cc, opts...)
+			results <- hedgedResult{attemptReply, err}
+		}()
+	}
+	send()
+	sent, answered := 1, 0
+	timer := time.NewTimer(p.HedgingDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if sent < p.MaxAttempts {
+				send()
+				sent++
+				timer.Reset(p.HedgingDelay)
+			}
+		case res := <-results:
+			answered++
+			if res.err == nil {
+				proto.Merge(msg, res.reply.(proto.Message))
+				return nil
+			}
+			if !p.retryable(res.err) || answered == p.MaxAttempts {
+				return res.err
+			}
+			// Attempts failing don't wait for the delay
+			if sent < p.MaxAttempts {
+				send()
+				sent++
+				timer.Reset(p.HedgingDelay)
+			}
+		}
+	}
+}
+
+// retryDelay returns how long the server asked to wait before retrying in the error's RetryInfo,
+// or 0.
+func retryDelay(err error) time.Duration {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok && info.RetryDelay != nil {
+			return info.RetryDelay.AsDuration()
+		}
+	}
+	return 0
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// fakeInvoker is an invoker answering the calls with its errors in turn, then successfully with
+// the offset, counting the attempts.
+type fakeInvoker struct {
+	mu       sync.Mutex
+	errs     []error
+	attempts int
+	// delay, if set, is how long the first attempt takes to answer, unless canceled
+	delay time.Duration
+}
+
+func (f *fakeInvoker) invoke(ctx context.Context, _ string, _, reply any, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+	f.mu.Lock()
+	f.attempts++
+	attempt := f.attempts
+	var err error
+	if len(f.errs) > 0 {
+		err, f.errs = f.errs[0], f.errs[1:]
+	}
+	f.mu.Unlock()
+	if attempt == 1 && f.delay > 0 {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-time.After(f.delay):
+		}
+	}
+	if err != nil {
+		return err
+	}
+	reply.(*api.ConsumeResponse).Record = &api.Record{Offset: uint64(attempt)}
+	return nil
+}
+
+func TestRetryPolicy(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "down")
+	consume, produce := api.Log_Consume_FullMethodName, api.Log_Produce_FullMethodName
+	policy := RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	for scenario, test := range map[string]struct {
+		policy   RetryPolicy
+		method   string
+		req      any
+		errs     []error
+		attempts int
+		code     codes.Code
+	}{
+		"retries reads": {
+			policy: policy, method: consume, errs: []error{unavailable, unavailable}, attempts: 3,
+		},
+		"gives up after max attempts": {
+			policy: policy, method: consume, errs: []error{unavailable, unavailable, unavailable, unavailable},
+			attempts: 4, code: codes.Unavailable,
+		},
+		"doesn't retry other codes": {
+			policy: policy, method: consume, errs: []error{status.Error(codes.InvalidArgument, "bad")},
+			attempts: 1, code: codes.InvalidArgument,
+		},
+		"doesn't retry produces": {
+			policy: policy, method: produce, req: &api.ProduceRequest{}, errs: []error{unavailable},
+			attempts: 1, code: codes.Unavailable,
+		},
+		"retries conditional produces": {
+			policy: policy, method: produce, req: &api.ProduceRequest{ExpectedOffset: proto.Uint64(1)},
+			errs: []error{unavailable}, attempts: 2,
+		},
+		"retries produces if told to": {
+			policy: RetryPolicy{InitialBackoff: time.Millisecond, RetryProduce: true}, method: produce,
+			req: &api.ProduceRequest{}, errs: []error{unavailable}, attempts: 2,
+		},
+		"doesn't retry with one attempt": {
+			policy: RetryPolicy{MaxAttempts: 1}, method: consume, errs: []error{unavailable},
+			attempts: 1, code: codes.Unavailable,
+		},
+		"retries the codes configured": {
+			policy: RetryPolicy{InitialBackoff: time.Millisecond, RetryableCodes: []codes.Code{codes.Aborted}},
+			method: consume, errs: []error{status.Error(codes.Aborted, "conflict"), unavailable},
+			attempts: 2, code: codes.Unavailable,
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			invoker := &fakeInvoker{errs: test.errs}
+			reply := &api.ConsumeResponse{}
+			err := test.policy.UnaryClientInterceptor()(context.Background(), test.method, test.req, reply, nil, invoker.invoke)
+			require.Equal(t, test.code, status.Code(err))
+			require.Equal(t, test.attempts, invoker.attempts)
+		})
+	}
+}
+
+func TestRetryPolicyRetryInfo(t *testing.T) {
+	// The server's RetryInfo asks for a longer wait than the backoff
+	st, err := status.New(codes.ResourceExhausted, "slow down").WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(100 * time.Millisecond),
+	})
+	require.NoError(t, err)
+	invoker := &fakeInvoker{errs: []error{st.Err()}}
+	policy := RetryPolicy{InitialBackoff: time.Millisecond}
+	start := time.Now()
+	err = policy.UnaryClientInterceptor()(context.Background(), api.Log_Consume_FullMethodName, nil, &api.ConsumeResponse{}, nil, invoker.invoke)
+	require.NoError(t, err)
+	require.Equal(t, 2, invoker.attempts)
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	// Waits end with the call's context
+	invoker = &fakeInvoker{errs: []error{st.Err()}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = policy.UnaryClientInterceptor()(ctx, api.Log_Consume_FullMethodName, nil, &api.ConsumeResponse{}, nil, invoker.invoke)
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	require.Equal(t, 1, invoker.attempts)
+}
+
+func TestRetryPolicyHedging(t *testing.T) {
+	policy := RetryPolicy{HedgingDelay: 10 * time.Millisecond}
+	interceptor := policy.UnaryClientInterceptor()
+
+	// The first attempt is slow, so the second answers first
+	invoker := &fakeInvoker{delay: time.Second}
+	reply := &api.ConsumeResponse{}
+	start := time.Now()
+	err := interceptor(context.Background(), api.Log_Consume_FullMethodName, nil, reply, nil, invoker.invoke)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), time.Second)
+	require.Equal(t, uint64(2), reply.Record.Offset)
+
+	// Attempts failing with a retryable code are hedged right away
+	unavailable := status.Error(codes.Unavailable, "down")
+	invoker = &fakeInvoker{errs: []error{unavailable, unavailable, unavailable, unavailable}}
+	err = interceptor(context.Background(), api.Log_Consume_FullMethodName, nil, reply, nil, invoker.invoke)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, 4, invoker.attempts)
+
+	// Produces aren't hedged
+	invoker = &fakeInvoker{delay: 50 * time.Millisecond}
+	err = interceptor(context.Background(), api.Log_Produce_FullMethodName, &api.ProduceRequest{}, &api.ConsumeResponse{}, nil, invoker.invoke)
+	require.NoError(t, err)
+	require.Equal(t, 1, invoker.attempts)
+}
@@ -0,0 +1,20 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestWithBearerToken(t *testing.T) {
+	md, err := bearerToken("secret").GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"authorization": "Bearer secret"}, md)
+
+	// Tokens aren't sent over plaintext connections
+	_, err = grpc.NewClient("127.0.0.1:0", grpc.WithTransportCredentials(insecure.NewCredentials()), WithBearerToken("secret"))
+	require.Error(t, err)
+}
@@ -0,0 +1,185 @@
+// Package devcert generates throwaway certificate authorities and certificates on the fly, for
+// running and testing proglog locally without provisioning certificates with cfssl. Its
+// certificates use ECDSA P-256 keys, are valid for a year, and must never secure production.
+package devcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// validity is how long the certificates generated are valid for.
+const validity = 365 * 24 * time.Hour
+
+// Authority is a certificate authority issuing the certificates of servers and their clients.
+type Authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+// NewAuthority returns a new authority with a self-signed certificate.
+func NewAuthority() (*Authority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "proglog development CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return newAuthority(der, key)
+}
+
+// LoadAuthority returns the authority whose certificate and key are PEM encoded, e.g. as written
+// by Generate.
+func LoadAuthority(certPEM, keyPEM []byte) (*Authority, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return nil, errors.New("devcert: no certificate in the authority's certificate PEM")
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, errors.New("devcert: no key in the authority's key PEM")
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("devcert: parse the authority's key: %w", err)
+	}
+	return newAuthority(certBlock.Bytes, key)
+}
+
+func newAuthority(der []byte, key *ecdsa.PrivateKey) (*Authority, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &Authority{cert: cert, key: key, pool: pool}, nil
+}
+
+// CertPool returns a pool holding the authority's certificate, to verify the certificates it
+// issued.
+func (a *Authority) CertPool() *x509.CertPool {
+	return a.pool
+}
+
+// CertPEM returns the authority's certificate, PEM encoded.
+func (a *Authority) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.cert.Raw})
+}
+
+// KeyPEM returns the authority's key, PEM encoded.
+func (a *Authority) KeyPEM() ([]byte, error) {
+	return keyPEM(a.key)
+}
+
+// Issue issues a certificate to the common name, which servers authorize clients by. The
+// certificate authenticates clients, and servers too if hosts lists the names or IPs they're
+// reached at.
+func (a *Authority) Issue(commonName string, hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if len(hosts) > 0 {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// ServerTLSConfig returns the TLS config of a server reached at the hosts, defaulting to
+// localhost and the loopback IPs, requiring clients to authenticate with certificates the
+// authority issued.
+func (a *Authority) ServerTLSConfig(hosts ...string) (*tls.Config, error) {
+	if len(hosts) == 0 {
+		hosts = LocalHosts
+	}
+	cert, err := a.Issue("server", hosts...)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    a.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// ClientTLSConfig returns the TLS config of a client authenticating as the common name, e.g.
+// root, and trusting the servers whose certificates the authority issued.
+func (a *Authority) ClientTLSConfig(commonName string) (*tls.Config, error) {
+	cert, err := a.Issue(commonName)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: a.pool}, nil
+}
+
+// LocalHosts are the hosts servers' certificates are valid for by default.
+var LocalHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// serialNumber returns a random serial number for a certificate.
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+}
+
+// keyPEM returns the key, PEM encoded.
+func keyPEM(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
@@ -0,0 +1,100 @@
+package devcert
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandshake(t *testing.T) {
+	ca, err := NewAuthority()
+	require.NoError(t, err)
+	serverConfig, err := ca.ServerTLSConfig()
+	require.NoError(t, err)
+	clientConfig, err := ca.ClientTLSConfig("root")
+	require.NoError(t, err)
+	clientConfig.ServerName = "localhost"
+	require.Equal(t, "root", handshake(t, serverConfig, clientConfig))
+
+	// Clients of another authority aren't trusted
+	other, err := NewAuthority()
+	require.NoError(t, err)
+	clientConfig, err = other.ClientTLSConfig("root")
+	require.NoError(t, err)
+	clientConfig.ServerName, clientConfig.RootCAs = "localhost", ca.CertPool()
+	require.Empty(t, handshake(t, serverConfig, clientConfig))
+}
+
+// handshake connects a client to a server with the TLS configs and returns the common name of
+// the client's certificate the server verified, or an empty string if the handshake failed.
+func handshake(t *testing.T, serverConfig, clientConfig *tls.Config) string {
+	t.Helper()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	require.NoError(t, err)
+	defer l.Close()
+	verified := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			verified <- ""
+			return
+		}
+		defer conn.Close()
+		tlsConn := conn.(*tls.Conn)
+		if err := tlsConn.Handshake(); err != nil || len(tlsConn.ConnectionState().VerifiedChains) == 0 {
+			verified <- ""
+			return
+		}
+		verified <- tlsConn.ConnectionState().VerifiedChains[0][0].Subject.CommonName
+	}()
+	conn, err := tls.Dial("tcp", l.Addr().String(), clientConfig)
+	if err == nil {
+		// TLS 1.3 clients finish their handshake before the server verified their certificate
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	return <-verified
+}
+
+func TestGenerate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "certs")
+	require.NoError(t, Generate(dir))
+	for _, name := range []string{
+		CAFile, CAKeyFile, ServerCertFile, ServerKeyFile, RootClientCertFile, RootClientKeyFile,
+		NobodyClientCertFile, NobodyClientKeyFile, ACLModelFile, ACLPolicyFile,
+	} {
+		require.FileExists(t, filepath.Join(dir, name))
+	}
+	read := func(name string) string {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	// Generating again keeps the files, and issues those missing with the same authority
+	ca, server := read(CAFile), read(ServerCertFile)
+	require.NoError(t, os.Remove(filepath.Join(dir, RootClientCertFile)))
+	require.NoError(t, Generate(dir))
+	require.Equal(t, ca, read(CAFile))
+	require.Equal(t, server, read(ServerCertFile))
+	clientConfig, err := ClientTLSConfig(dir)
+	require.NoError(t, err)
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(dir, ServerCertFile), filepath.Join(dir, ServerKeyFile))
+	require.NoError(t, err)
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientConfig.RootCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	clientConfig.ServerName = "127.0.0.1"
+	require.Equal(t, "root", handshake(t, serverConfig, clientConfig))
+
+	// A new authority issues every certificate again
+	require.NoError(t, os.Remove(filepath.Join(dir, CAKeyFile)))
+	require.NoError(t, Generate(dir))
+	require.NotEqual(t, ca, read(CAFile))
+	require.NotEqual(t, server, read(ServerCertFile))
+}
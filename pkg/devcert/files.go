@@ -0,0 +1,187 @@
+package devcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Names of the files Generate writes, the same as those of the certificates provisioned with
+// cfssl and the ACL, so servers and clients defaulting to them find them in the directory.
+const (
+	CAFile               = "ca.pem"
+	CAKeyFile            = "ca-key.pem"
+	ServerCertFile       = "server.pem"
+	ServerKeyFile        = "server-key.pem"
+	RootClientCertFile   = "root-client.pem"
+	RootClientKeyFile    = "root-client-key.pem"
+	NobodyClientCertFile = "nobody-client.pem"
+	NobodyClientKeyFile  = "nobody-client-key.pem"
+	ACLModelFile         = "model.conf"
+	ACLPolicyFile        = "policy.csv"
+)
+
+// ACLModel is the ACL model authorizing subjects, the common names of clients' certificates, to
+// act on objects, which may be patterns, e.g. * for every topic. Subjects hold the permissions of
+// the roles they're bound to, and of those the roles are bound to in turn.
+const ACLModel = `[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && keyMatch(r.obj, p.obj) && r.act == p.act
+`
+
+// ACLPolicy is the ACL policy authorizing root to do anything, and nobody nothing.
+const ACLPolicy = `p, root, *, produce
+p, root, *, consume
+p, root, offsets, describe
+p, root, admin, admin
+p, root, admin, describe
+p, root, cluster, describe
+p, root, cluster, admin
+`
+
+// Generate writes a throwaway certificate authority into the directory, creating it if needed,
+// with the certificates and keys of a server reached at the hosts, defaulting to localhost and
+// the loopback IPs, and of the root and nobody clients, and an ACL authorizing root to do
+// anything. Files already in the directory are kept, so servers and clients generating them on
+// start reuse the same authority, and the certificates missing are issued by the directory's
+// authority, if it holds one.
+func Generate(dir string, hosts ...string) error {
+	if len(hosts) == 0 {
+		hosts = LocalHosts
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	ca, err := loadAuthority(path(CAFile), path(CAKeyFile))
+	if err != nil {
+		return err
+	}
+	if ca == nil {
+		if ca, err = NewAuthority(); err != nil {
+			return err
+		}
+		key, err := ca.KeyPEM()
+		if err != nil {
+			return err
+		}
+		// Certificates a previous authority issued wouldn't be trusted anymore
+		for _, name := range []string{ServerCertFile, RootClientCertFile, NobodyClientCertFile} {
+			if err := os.Remove(path(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		if err := writeFiles(map[string][]byte{path(CAFile): ca.CertPEM(), path(CAKeyFile): key}); err != nil {
+			return err
+		}
+	}
+
+	for _, issued := range []struct {
+		certFile, keyFile, commonName string
+		hosts                         []string
+	}{
+		{ServerCertFile, ServerKeyFile, "server", hosts},
+		{RootClientCertFile, RootClientKeyFile, "root", nil},
+		{NobodyClientCertFile, NobodyClientKeyFile, "nobody", nil},
+	} {
+		if exists(path(issued.certFile)) && exists(path(issued.keyFile)) {
+			continue
+		}
+		cert, err := ca.Issue(issued.commonName, issued.hosts...)
+		if err != nil {
+			return err
+		}
+		key, err := keyPEM(cert.PrivateKey.(*ecdsa.PrivateKey))
+		if err != nil {
+			return err
+		}
+		if err := writeFiles(map[string][]byte{
+			path(issued.certFile): pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}),
+			path(issued.keyFile):  key,
+		}); err != nil {
+			return err
+		}
+	}
+
+	for name, content := range map[string]string{ACLModelFile: ACLModel, ACLPolicyFile: ACLPolicy} {
+		if !exists(path(name)) {
+			if err := writeFiles(map[string][]byte{path(name): []byte(content)}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ClientTLSConfig returns the TLS config of the root client whose files Generate wrote into the
+// directory, trusting the servers whose certificates the directory's authority issued.
+func ClientTLSConfig(dir string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, RootClientCertFile), filepath.Join(dir, RootClientKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := os.ReadFile(filepath.Join(dir, CAFile))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("devcert: no certificate in %s", filepath.Join(dir, CAFile))
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool}, nil
+}
+
+// loadAuthority returns the authority whose certificate and key are in the files, or nil if
+// either is missing.
+func loadAuthority(certFile, keyFile string) (*Authority, error) {
+	if !exists(certFile) || !exists(keyFile) {
+		return nil, nil
+	}
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyData, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := LoadAuthority(certPEM, keyData)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", certFile, err)
+	}
+	return ca, nil
+}
+
+// writeFiles writes the files, readable by their owner only as they may hold keys.
+func writeFiles(files map[string][]byte) error {
+	for name, content := range files {
+		if err := os.WriteFile(name, content, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exists returns whether the file exists.
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
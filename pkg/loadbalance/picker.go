@@ -0,0 +1,77 @@
+package loadbalance
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+// Picker routes the RPCs of connections to proglog targets: produces go to the leader, the
+// only server accepting writes, and consumes and other reads are spread across the followers
+// round-robin, taking load off the leader. Reads go to the leader while there are no followers,
+// or if their context was made with ToLeader.
+// The registered Picker builds a new Picker for every update of a connection's servers.
+type Picker struct {
+	leader    balancer.SubConn
+	followers []balancer.SubConn
+	current   atomic.Uint64 // Counts reads, to pick the next follower
+}
+
+var _ base.PickerBuilder = (*Picker)(nil)
+var _ balancer.Picker = (*Picker)(nil)
+
+// leaderKey is the context key of RPCs routed to the leader.
+type leaderKey struct{}
+
+// ToLeader returns a context routing the RPCs it's passed to to the leader, e.g. linearizable
+// consumes, which only the leader serves.
+func ToLeader(ctx context.Context) context.Context {
+	return context.WithValue(ctx, leaderKey{}, true)
+}
+
+// Build returns a Picker over the ready connections, telling the leader from the followers
+// by the attribute the Resolver sets on their addresses.
+func (p *Picker) Build(buildInfo base.PickerBuildInfo) balancer.Picker {
+	picker := &Picker{}
+	for sc, scInfo := range buildInfo.ReadySCs {
+		isLeader, _ := scInfo.Address.Attributes.Value(isLeaderAttr{}).(bool)
+		if isLeader {
+			picker.leader = sc
+			continue
+		}
+		picker.followers = append(picker.followers, sc)
+	}
+	return picker
+}
+
+// Pick picks the connection to send the RPC to.
+func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	var result balancer.PickResult
+	var toLeader bool
+	if info.Ctx != nil {
+		toLeader, _ = info.Ctx.Value(leaderKey{}).(bool)
+	}
+	if strings.Contains(info.FullMethodName, "Produce") || toLeader || len(p.followers) == 0 {
+		result.SubConn = p.leader
+	} else {
+		result.SubConn = p.nextFollower()
+	}
+	if result.SubConn == nil {
+		// Wait for the resolver to find the servers and the connections to become ready
+		return result, balancer.ErrNoSubConnAvailable
+	}
+	return result, nil
+}
+
+// nextFollower returns the next follower in round-robin order.
+func (p *Picker) nextFollower() balancer.SubConn {
+	cur := p.current.Add(1) - 1
+	return p.followers[cur%uint64(len(p.followers))]
+}
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(Name, &Picker{}, base.Config{}))
+}
@@ -0,0 +1,97 @@
+package loadbalance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestPicker(t *testing.T) {
+	// Define a set of test scenarios, each represented by a function that takes a *testing.T
+	for scenario, fn := range map[string]func(t *testing.T){
+		"no subconns available":         testPickerNoSubConnAvailable,
+		"produces go to the leader":     testPickerProducesToLeader,
+		"consumes go to the followers":  testPickerConsumesFromFollowers,
+		"reads fall back to the leader": testPickerReadsFromLeaderWithoutFollowers,
+		"reads can go to the leader":    testPickerReadsToLeader,
+	} {
+		t.Run(scenario, fn)
+	}
+}
+
+func testPickerNoSubConnAvailable(t *testing.T) {
+	picker := (&Picker{}).Build(base.PickerBuildInfo{})
+	for _, method := range []string{
+		"/log.vX.Log/Produce",
+		"/log.vX.Log/Consume",
+	} {
+		result, err := picker.Pick(balancer.PickInfo{FullMethodName: method})
+		require.Equal(t, balancer.ErrNoSubConnAvailable, err)
+		require.Nil(t, result.SubConn)
+	}
+}
+
+func testPickerProducesToLeader(t *testing.T) {
+	picker, subConns := setupPicker(3)
+	for i := 0; i < 5; i++ {
+		gotPick, err := picker.Pick(balancer.PickInfo{FullMethodName: "/log.vX.Log/Produce"})
+		require.NoError(t, err)
+		require.Equal(t, subConns[0], gotPick.SubConn)
+	}
+}
+
+func testPickerConsumesFromFollowers(t *testing.T) {
+	picker, subConns := setupPicker(3)
+	// Consumes are spread across the followers, never reaching the leader
+	picked := map[balancer.SubConn]int{}
+	for i := 0; i < 6; i++ {
+		gotPick, err := picker.Pick(balancer.PickInfo{FullMethodName: "/log.vX.Log/Consume"})
+		require.NoError(t, err)
+		picked[gotPick.SubConn]++
+	}
+	require.Equal(t, map[balancer.SubConn]int{subConns[1]: 3, subConns[2]: 3}, picked)
+}
+
+func testPickerReadsFromLeaderWithoutFollowers(t *testing.T) {
+	picker, subConns := setupPicker(1)
+	gotPick, err := picker.Pick(balancer.PickInfo{FullMethodName: "/log.vX.Log/ConsumeStream"})
+	require.NoError(t, err)
+	require.Equal(t, subConns[0], gotPick.SubConn)
+}
+
+func testPickerReadsToLeader(t *testing.T) {
+	picker, subConns := setupPicker(3)
+	gotPick, err := picker.Pick(balancer.PickInfo{
+		FullMethodName: "/log.vX.Log/Consume",
+		Ctx:            ToLeader(context.Background()),
+	})
+	require.NoError(t, err)
+	require.Equal(t, subConns[0], gotPick.SubConn)
+}
+
+// setupPicker builds a Picker over n ready subconns, the first of which is the leader's.
+func setupPicker(n int) (balancer.Picker, []*subConn) {
+	var subConns []*subConn
+	buildInfo := base.PickerBuildInfo{
+		ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
+	}
+	for i := 0; i < n; i++ {
+		sc := &subConn{}
+		addr := resolver.Address{
+			Attributes: attributes.New(isLeaderAttr{}, i == 0),
+		}
+		buildInfo.ReadySCs[sc] = base.SubConnInfo{Address: addr}
+		subConns = append(subConns, sc)
+	}
+	return (&Picker{}).Build(buildInfo), subConns
+}
+
+// subConn is a balancer.SubConn that's only compared by identity.
+type subConn struct {
+	balancer.SubConn
+}
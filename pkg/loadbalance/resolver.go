@@ -0,0 +1,151 @@
+package loadbalance
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"google.golang.org/grpc/status"
+)
+
+// Name is the scheme of the targets the Resolver resolves, e.g. proglog:///127.0.0.1:8400,
+// and the name of the load balancing policy routing their RPCs with the Picker.
+const Name = "proglog"
+
+// watchRetryInterval is how long the Resolver waits before watching the servers again after the
+// stream failed.
+const watchRetryInterval = time.Second
+
+// isLeaderAttr is the address attribute telling the Picker whether the server is the leader.
+type isLeaderAttr struct{}
+
+// Resolver resolves proglog targets into the servers of the cluster. It asks the server the
+// target names for the cluster's servers with GetServers, and marks the leader's address
+// so the Picker can route produces to it. It also watches the servers with WatchServers, and
+// resolves them again as soon as they change, e.g. when a new leader is elected. The registered
+// Resolver builds a new Resolver for every client connection.
+type Resolver struct {
+	mu            sync.Mutex
+	clientConn    resolver.ClientConn
+	resolverConn  *grpc.ClientConn
+	serviceConfig *serviceconfig.ParseResult
+	logger        *slog.Logger
+	stopWatching  context.CancelFunc
+}
+
+var _ resolver.Builder = (*Resolver)(nil)
+var _ resolver.Resolver = (*Resolver)(nil)
+
+// Build connects to the server named by the target, with the same credentials as the client
+// connection, and resolves the cluster's servers.
+func (r *Resolver) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	res := &Resolver{
+		clientConn: cc,
+		serviceConfig: cc.ParseServiceConfig(
+			fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, Name),
+		),
+		logger: slog.Default().With(slog.String("component", "resolver")),
+	}
+	var dialOpts []grpc.DialOption
+	if opts.DialCreds != nil {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(opts.DialCreds))
+	}
+	var err error
+	res.resolverConn, err = grpc.NewClient(target.Endpoint(), dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	res.ResolveNow(resolver.ResolveNowOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	res.stopWatching = cancel
+	go res.watch(ctx)
+	return res, nil
+}
+
+// Scheme returns the scheme of the targets the Resolver resolves.
+func (r *Resolver) Scheme() string {
+	return Name
+}
+
+// ResolveNow fetches the cluster's servers and updates the client connection with them.
+// gRPC calls it again when connecting to a server fails, e.g. after the cluster changed.
+func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client := api.NewLogClient(r.resolverConn)
+	res, err := client.GetServers(context.Background(), &api.GetServersRequest{})
+	if err != nil {
+		r.logger.Error("failed to resolve servers", slog.String("error", err.Error()))
+		r.clientConn.ReportError(err)
+		return
+	}
+	var addrs []resolver.Address
+	for _, server := range res.Servers {
+		addrs = append(addrs, resolver.Address{
+			Addr:       server.RpcAddr,
+			Attributes: attributes.New(isLeaderAttr{}, server.IsLeader),
+		})
+	}
+	if err := r.clientConn.UpdateState(resolver.State{
+		Addresses:     addrs,
+		ServiceConfig: r.serviceConfig,
+	}); err != nil {
+		r.logger.Error("failed to update state", slog.String("error", err.Error()))
+	}
+}
+
+// watch resolves the servers again whenever the server the target names streams a change, until
+// the context is canceled. Servers that can't stream the changes, e.g. those outside a cluster,
+// leave the Resolver to gRPC's re-resolution when connecting to a server fails.
+func (r *Resolver) watch(ctx context.Context) {
+	for {
+		err := r.resolveOnChanges(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case status.Code(err) == codes.Unimplemented, status.Code(err) == codes.PermissionDenied:
+			r.logger.Debug("not watching servers", slog.String("error", err.Error()))
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+// resolveOnChanges resolves the servers whenever the stream of their changes receives one, until
+// the stream fails.
+func (r *Resolver) resolveOnChanges(ctx context.Context) error {
+	stream, err := api.NewLogClient(r.resolverConn).WatchServers(ctx, &api.WatchServersRequest{})
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := stream.Recv(); err != nil {
+			return err
+		}
+		r.ResolveNow(resolver.ResolveNowOptions{})
+	}
+}
+
+// Close stops watching the servers and closes the connection used to resolve them.
+func (r *Resolver) Close() {
+	r.stopWatching()
+	if err := r.resolverConn.Close(); err != nil {
+		r.logger.Error("failed to close conn", slog.String("error", err.Error()))
+	}
+}
+
+func init() {
+	resolver.Register(&Resolver{})
+}
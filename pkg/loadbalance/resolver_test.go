@@ -0,0 +1,160 @@
+package loadbalance
+
+import (
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/auth"
+	"github.com/glauco/proglog/internal/config"
+	"github.com/glauco/proglog/internal/log"
+	"github.com/glauco/proglog/internal/server"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+func TestResolver(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.ServerCertFile,
+		KeyFile:       config.ServerKeyFile,
+		CAFile:        config.CAFile,
+		Server:        true,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	servers := &getServers{}
+	events := make(chan *api.ServerEvent, 1)
+	srv, err := server.NewGRPCServer(&server.Config{
+		CommitLog:  clog,
+		Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile),
+	},
+		server.WithTLS(serverTLSConfig),
+		server.WithGetServerer(servers),
+		server.WithServerWatcher(watchServers(events)),
+	)
+	require.NoError(t, err)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	clientTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.RootClientCertFile,
+		KeyFile:       config.RootClientKeyFile,
+		CAFile:        config.CAFile,
+		Server:        false,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+
+	// Resolve the cluster through the server, with the credentials of the client connection
+	conn := &clientConn{}
+	r := &Resolver{}
+	res, err := r.Build(
+		resolver.Target{URL: *mustParseURL(t, "proglog:///"+l.Addr().String())},
+		conn,
+		resolver.BuildOptions{DialCreds: credentials.NewTLS(clientTLSConfig)},
+	)
+	require.NoError(t, err)
+	defer res.Close()
+
+	// The servers' addresses are marked with whether they lead the cluster
+	want := resolver.State{
+		Addresses: []resolver.Address{{
+			Addr:       "localhost:9001",
+			Attributes: attributes.New(isLeaderAttr{}, true),
+		}, {
+			Addr:       "localhost:9002",
+			Attributes: attributes.New(isLeaderAttr{}, false),
+		}},
+	}
+	state := conn.State()
+	require.Equal(t, want.Addresses, state.Addresses)
+	require.NotNil(t, state.ServiceConfig)
+	require.NoError(t, state.ServiceConfig.Err)
+
+	// Once the leadership changes, the servers are resolved again right away
+	servers.swapped.Store(true)
+	events <- &api.ServerEvent{
+		Type:   api.ServerEventType_SERVER_EVENT_TYPE_LEADER_CHANGED,
+		Server: &api.Server{Id: "follower", RpcAddr: "localhost:9002", IsLeader: true},
+	}
+	require.Eventually(t, func() bool {
+		addrs := conn.State().Addresses
+		return len(addrs) == 2 && addrs[1].Attributes.Value(isLeaderAttr{}) == true
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+// getServers is a GetServerer reporting a fixed cluster, whose follower leads once swapped.
+type getServers struct {
+	swapped atomic.Bool
+}
+
+func (s *getServers) GetServers() ([]*api.Server, error) {
+	swapped := s.swapped.Load()
+	return []*api.Server{{
+		Id:       "leader",
+		RpcAddr:  "localhost:9001",
+		IsLeader: !swapped,
+	}, {
+		Id:       "follower",
+		RpcAddr:  "localhost:9002",
+		IsLeader: swapped,
+	}}, nil
+}
+
+// watchServers is a ServerWatcher streaming the events sent on the channel.
+type watchServers chan *api.ServerEvent
+
+func (w watchServers) WatchServers() (<-chan *api.ServerEvent, func()) {
+	return w, func() {}
+}
+
+// clientConn is a resolver.ClientConn recording the state the resolver reports.
+type clientConn struct {
+	resolver.ClientConn
+	mu    sync.Mutex
+	state resolver.State
+}
+
+func (c *clientConn) UpdateState(state resolver.State) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = state
+	return nil
+}
+
+// State returns the state the resolver last reported.
+func (c *clientConn) State() resolver.State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+func (c *clientConn) ReportError(err error) {}
+
+func (c *clientConn) NewAddress(addrs []resolver.Address) {}
+
+func (c *clientConn) NewServiceConfig(config string) {}
+
+func (c *clientConn) ParseServiceConfig(config string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{}
+}
+
+// mustParseURL parses the URL of a target.
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	return u
+}
@@ -0,0 +1,378 @@
+// Package logtest helps test programs using the log. Fake is an in-memory api.LogClient, for unit
+// tests that don't need a server, and StartServer starts a real server with its own log, TLS
+// certificates and ACL, for tests going through the gRPC API.
+package logtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/version"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Fake is an in-memory api.LogClient, holding a single log as a server does. It serves Produce,
+// Consume, ProduceStream, ConsumeStream, GetServers and GetVersion, failing as a server does,
+// with the same status errors, e.g. OutOfRange past the end of the log. Produces are
+// acknowledged at once, whatever their acks, and the log is never truncated; Subscribe and
+// WatchServers fail with Unimplemented.
+//
+// Tests can inspect the records with Records, and inject failures with FailNext. A Fake is safe
+// for concurrent use; its zero value is an empty log.
+type Fake struct {
+	mu       sync.Mutex
+	records  []*api.Record
+	appended chan struct{}      // Closed once a record is appended, waking waiting consumers
+	failures map[string][]error // Errors the next calls fail with, by full method name
+}
+
+var _ api.LogClient = (*Fake)(nil)
+
+// Records returns copies of the records produced, in order.
+func (f *Fake) Records() []*api.Record {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	records := make([]*api.Record, len(f.records))
+	for i, record := range f.records {
+		records[i] = proto.Clone(record).(*api.Record)
+	}
+	return records
+}
+
+// FailNext makes the next calls of the method, by its full name, e.g.
+// api.Log_Produce_FullMethodName, fail with the errors in turn, before reaching the log. Errors
+// should be status errors, as servers return, e.g. status.Error(codes.Unavailable, "").
+func (f *Fake) FailNext(method string, errs ...error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures == nil {
+		f.failures = make(map[string][]error)
+	}
+	f.failures[method] = append(f.failures[method], errs...)
+}
+
+// fail returns the error the call of the method must fail with, if any. The caller must hold
+// the lock.
+func (f *Fake) fail(method string) error {
+	errs := f.failures[method]
+	if len(errs) == 0 {
+		return nil
+	}
+	f.failures[method] = errs[1:]
+	return errs[0]
+}
+
+// Produce appends the record to the log.
+func (f *Fake) Produce(ctx context.Context, req *api.ProduceRequest, _ ...grpc.CallOption) (*api.ProduceResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(api.Log_Produce_FullMethodName); err != nil {
+		return nil, err
+	}
+	return f.produce(ctx, req)
+}
+
+// produce appends the record to the log. The caller must hold the lock.
+func (f *Fake) produce(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	if req.Record == nil {
+		return nil, status.Error(codes.InvalidArgument, "record is required")
+	}
+	next := uint64(len(f.records))
+	if req.ExpectedOffset != nil && *req.ExpectedOffset != next {
+		return nil, api.ErrOffsetMismatch{Expected: *req.ExpectedOffset, Next: next}.GRPCStatus().Err()
+	}
+	record := proto.Clone(req.Record).(*api.Record)
+	record.Offset, record.AppendTime = next, timestamppb.Now()
+	f.records = append(f.records, record)
+	if f.appended != nil {
+		close(f.appended)
+		f.appended = nil
+	}
+	return &api.ProduceResponse{
+		Offset:        record.Offset,
+		AppendTime:    record.AppendTime,
+		HighWatermark: next + 1,
+	}, nil
+}
+
+// Consume reads the record at the offset, waiting up to the request's max wait for it to be
+// appended.
+func (f *Fake) Consume(ctx context.Context, req *api.ConsumeRequest, _ ...grpc.CallOption) (*api.ConsumeResponse, error) {
+	f.mu.Lock()
+	err := f.fail(api.Log_Consume_FullMethodName)
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	offset, err := f.resolveOffset(req)
+	if err != nil {
+		return nil, err
+	}
+	var deadline <-chan time.Time
+	if req.MaxWaitMs > 0 {
+		timer := time.NewTimer(time.Duration(req.MaxWaitMs) * time.Millisecond)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	return f.consume(ctx, offset, deadline)
+}
+
+// resolveOffset returns the offset the request addresses: a negative relative offset counts back
+// from the head of the log, clamped to its start.
+func (f *Fake) resolveOffset(req *api.ConsumeRequest) (uint64, error) {
+	if req.RelativeOffset == 0 {
+		return req.Offset, nil
+	}
+	if req.RelativeOffset > 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "relative offset must be negative, got %d", req.RelativeOffset)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	head, back := uint64(len(f.records)), uint64(-req.RelativeOffset)
+	if back < head {
+		return head - back, nil
+	}
+	return 0, nil
+}
+
+// consume reads the record at the offset, waiting for it to be appended until the deadline,
+// which never passes when nil, or failing at once if the deadline is nil.
+func (f *Fake) consume(ctx context.Context, offset uint64, deadline <-chan time.Time) (*api.ConsumeResponse, error) {
+	for {
+		f.mu.Lock()
+		next := uint64(len(f.records))
+		if offset < next {
+			record := proto.Clone(f.records[offset]).(*api.Record)
+			f.mu.Unlock()
+			return &api.ConsumeResponse{Record: record, HighWatermark: next}, nil
+		}
+		if f.appended == nil {
+			f.appended = make(chan struct{})
+		}
+		appended := f.appended
+		f.mu.Unlock()
+
+		outOfRange := api.ErrOffsetOutOfRange{Offset: offset, Next: next}.GRPCStatus().Err()
+		if deadline == nil {
+			return nil, outOfRange
+		}
+		select {
+		case <-appended:
+		case <-deadline:
+			return nil, outOfRange
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// ProduceStream returns a stream appending the records sent, and receiving their offsets.
+func (f *Fake) ProduceStream(ctx context.Context, _ ...grpc.CallOption) (grpc.BidiStreamingClient[api.ProduceRequest, api.ProduceResponse], error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(api.Log_ProduceStream_FullMethodName); err != nil {
+		return nil, err
+	}
+	return &produceStream{stream: newStream[api.ProduceResponse](ctx, 1024), fake: f}, nil
+}
+
+// ConsumeStream returns a stream receiving the records from the offset on, including those
+// appended once it reached the end of the log, until its context is done.
+func (f *Fake) ConsumeStream(ctx context.Context, req *api.ConsumeRequest, _ ...grpc.CallOption) (grpc.ServerStreamingClient[api.ConsumeResponse], error) {
+	f.mu.Lock()
+	err := f.fail(api.Log_ConsumeStream_FullMethodName)
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	offset, err := f.resolveOffset(req)
+	if err != nil {
+		return nil, err
+	}
+	s := newStream[api.ConsumeResponse](ctx, 0)
+	never := make(chan time.Time)
+	go func() {
+		for ; ; offset++ {
+			res, err := f.consume(s.ctx, offset, never)
+			if err != nil {
+				s.end(err)
+				return
+			}
+			if !s.push(res) {
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+// Subscribe fails with Unimplemented.
+func (f *Fake) Subscribe(context.Context, ...grpc.CallOption) (grpc.BidiStreamingClient[api.SubscribeRequest, api.ConsumeResponse], error) {
+	return nil, status.Error(codes.Unimplemented, "logtest: Subscribe isn't faked")
+}
+
+// GetServers returns the single server holding the fake log, the leader.
+func (f *Fake) GetServers(context.Context, *api.GetServersRequest, ...grpc.CallOption) (*api.GetServersResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(api.Log_GetServers_FullMethodName); err != nil {
+		return nil, err
+	}
+	return &api.GetServersResponse{Servers: []*api.Server{{
+		Id:       "logtest",
+		RpcAddr:  "logtest",
+		IsLeader: true,
+		IsVoter:  true,
+		Version:  version.Get().Version,
+	}}}, nil
+}
+
+// WatchServers fails with Unimplemented.
+func (f *Fake) WatchServers(context.Context, *api.WatchServersRequest, ...grpc.CallOption) (grpc.ServerStreamingClient[api.ServerEvent], error) {
+	return nil, status.Error(codes.Unimplemented, "logtest: WatchServers isn't faked")
+}
+
+// GetVersion returns the build of the test binary.
+func (f *Fake) GetVersion(context.Context, *api.GetVersionRequest, ...grpc.CallOption) (*api.GetVersionResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(api.Log_GetVersion_FullMethodName); err != nil {
+		return nil, err
+	}
+	return &api.GetVersionResponse{Build: version.Get()}, nil
+}
+
+// stream is the client side of a fake stream, receiving the responses pushed to it until it's
+// ended, or its context is done.
+type stream[Res any] struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	responses chan *Res
+	done      chan struct{} // Closed once ended
+	once      sync.Once
+	err       error // Error Recv returns once the responses are received, io.EOF if none
+}
+
+// newStream returns a stream buffering up to size responses.
+func newStream[Res any](ctx context.Context, size int) *stream[Res] {
+	ctx, cancel := context.WithCancel(ctx)
+	return &stream[Res]{ctx: ctx, cancel: cancel, responses: make(chan *Res, size), done: make(chan struct{})}
+}
+
+// push sends the response to the client, failing once the stream's context is done.
+func (s *stream[Res]) push(res *Res) bool {
+	select {
+	case s.responses <- res:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// end ends the stream with the error, or cleanly if nil, once the responses pushed are received.
+func (s *stream[Res]) end(err error) {
+	s.once.Do(func() {
+		if err == nil {
+			err = io.EOF
+		}
+		s.err = err
+		close(s.done)
+	})
+}
+
+func (s *stream[Res]) Recv() (*Res, error) {
+	// Responses pushed before the stream ended come first
+	select {
+	case res := <-s.responses:
+		return res, nil
+	default:
+	}
+	select {
+	case res := <-s.responses:
+		return res, nil
+	case <-s.done:
+		select {
+		case res := <-s.responses:
+			return res, nil
+		default:
+			return nil, s.err
+		}
+	case <-s.ctx.Done():
+		return nil, status.FromContextError(s.ctx.Err()).Err()
+	}
+}
+
+func (s *stream[Res]) Header() (metadata.MD, error) { return metadata.MD{}, nil }
+
+func (s *stream[Res]) Trailer() metadata.MD { return metadata.MD{} }
+
+func (s *stream[Res]) CloseSend() error { return nil }
+
+func (s *stream[Res]) Context() context.Context { return s.ctx }
+
+func (s *stream[Res]) SendMsg(any) error {
+	return errors.New("logtest: the stream doesn't send messages")
+}
+
+func (s *stream[Res]) RecvMsg(m any) error {
+	res, err := s.Recv()
+	if err != nil {
+		return err
+	}
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return fmt.Errorf("logtest: can't receive into %T", m)
+	}
+	proto.Merge(msg, any(res).(proto.Message))
+	return nil
+}
+
+// produceStream is a fake ProduceStream, appending the records as they're sent.
+type produceStream struct {
+	*stream[api.ProduceResponse]
+	fake *Fake
+}
+
+func (s *produceStream) Send(req *api.ProduceRequest) error {
+	select {
+	case <-s.done:
+		// The stream failed, which Recv tells, as with gRPC streams
+		return io.EOF
+	default:
+	}
+	s.fake.mu.Lock()
+	res, err := s.fake.produce(s.ctx, req)
+	s.fake.mu.Unlock()
+	if err != nil {
+		s.end(err)
+		return nil
+	}
+	s.push(res)
+	return nil
+}
+
+func (s *produceStream) SendMsg(m any) error {
+	req, ok := m.(*api.ProduceRequest)
+	if !ok {
+		return fmt.Errorf("logtest: can't send %T", m)
+	}
+	return s.Send(req)
+}
+
+func (s *produceStream) CloseSend() error {
+	s.end(nil)
+	return nil
+}
@@ -0,0 +1,172 @@
+package logtest
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestFake runs the same scenarios against a Fake and a real server, so the fake behaves as
+// servers do.
+func TestFake(t *testing.T) {
+	for scenario, fn := range map[string]func(*testing.T, api.LogClient){
+		"produces and consumes":     testProduceConsume,
+		"fails past the end":        testOutOfRange,
+		"compares and appends":      testExpectedOffset,
+		"consumes relative offsets": testRelativeOffset,
+		"waits for records":         testMaxWait,
+		"streams produces":          testProduceStream,
+		"streams consumes":          testConsumeStream,
+		"lists the leader":          testGetServers,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			t.Run("fake", func(t *testing.T) {
+				fn(t, &Fake{})
+			})
+			t.Run("server", func(t *testing.T) {
+				fn(t, StartServer(t).Client(t, "root"))
+			})
+		})
+	}
+}
+
+func produce(t *testing.T, client api.LogClient, values ...string) {
+	t.Helper()
+	for _, value := range values {
+		_, err := client.Produce(context.Background(), &api.ProduceRequest{Record: &api.Record{Value: []byte(value)}})
+		require.NoError(t, err)
+	}
+}
+
+func testProduceConsume(t *testing.T, client api.LogClient) {
+	ctx := context.Background()
+	want := &api.Record{Value: []byte("hello"), Key: []byte("k"), Headers: map[string]string{"h": "v"}}
+	produced, err := client.Produce(ctx, &api.ProduceRequest{Record: want})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), produced.Offset)
+	require.Equal(t, uint64(1), produced.HighWatermark)
+	require.NotNil(t, produced.AppendTime)
+
+	consumed, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	require.Equal(t, want.Value, consumed.Record.Value)
+	require.Equal(t, want.Key, consumed.Record.Key)
+	require.Equal(t, want.Headers, consumed.Record.Headers)
+	require.True(t, proto.Equal(produced.AppendTime, consumed.Record.AppendTime))
+	require.Equal(t, uint64(1), consumed.HighWatermark)
+}
+
+func testOutOfRange(t *testing.T, client api.LogClient) {
+	produce(t, client, "a")
+	_, err := client.Consume(context.Background(), &api.ConsumeRequest{Offset: 1})
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+}
+
+func testExpectedOffset(t *testing.T, client api.LogClient) {
+	ctx := context.Background()
+	produce(t, client, "a")
+	_, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("b")}, ExpectedOffset: proto.Uint64(0)})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	res, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("b")}, ExpectedOffset: proto.Uint64(1)})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), res.Offset)
+}
+
+func testRelativeOffset(t *testing.T, client api.LogClient) {
+	ctx := context.Background()
+	produce(t, client, "a", "b", "c")
+	for relative, want := range map[int64]string{-1: "c", -3: "a", -10: "a"} {
+		res, err := client.Consume(ctx, &api.ConsumeRequest{RelativeOffset: relative})
+		require.NoError(t, err)
+		require.Equal(t, want, string(res.Record.Value))
+	}
+	_, err := client.Consume(ctx, &api.ConsumeRequest{RelativeOffset: 1})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func testMaxWait(t *testing.T, client api.LogClient) {
+	ctx := context.Background()
+	_, err := client.Consume(ctx, &api.ConsumeRequest{MaxWaitMs: 50})
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("a")}})
+	}()
+	res, err := client.Consume(ctx, &api.ConsumeRequest{MaxWaitMs: 5000})
+	require.NoError(t, err)
+	require.Equal(t, "a", string(res.Record.Value))
+}
+
+func testProduceStream(t *testing.T, client api.LogClient) {
+	stream, err := client.ProduceStream(context.Background())
+	require.NoError(t, err)
+	for _, value := range []string{"a", "b"} {
+		require.NoError(t, stream.Send(&api.ProduceRequest{Record: &api.Record{Value: []byte(value)}}))
+	}
+	for offset := range uint64(2) {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, offset, res.Offset)
+	}
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func testConsumeStream(t *testing.T, client api.LogClient) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	produce(t, client, "a")
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{})
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "a", string(res.Record.Value))
+
+	// The stream receives the records appended once it reached the end
+	produce(t, client, "b")
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "b", string(res.Record.Value))
+	require.Equal(t, uint64(1), res.Record.Offset)
+
+	cancel()
+	_, err = stream.Recv()
+	require.Equal(t, codes.Canceled, status.Code(err))
+}
+
+func testGetServers(t *testing.T, client api.LogClient) {
+	res, err := client.GetServers(context.Background(), &api.GetServersRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		// Servers outside a cluster don't list servers
+		t.Skip()
+	}
+	require.NoError(t, err)
+	require.Len(t, res.Servers, 1)
+	require.True(t, res.Servers[0].IsLeader)
+}
+
+func TestFakeFailNext(t *testing.T) {
+	fake := &Fake{}
+	unavailable := status.Error(codes.Unavailable, "down")
+	fake.FailNext(api.Log_Produce_FullMethodName, unavailable, unavailable)
+
+	// Failures are injected before the log is reached, so the records aren't appended
+	for range 2 {
+		_, err := fake.Produce(context.Background(), &api.ProduceRequest{Record: &api.Record{Value: []byte("a")}})
+		require.ErrorIs(t, err, unavailable)
+	}
+	produce(t, fake, "b")
+	records := fake.Records()
+	require.Len(t, records, 1)
+	require.Equal(t, "b", string(records[0].Value))
+	require.Equal(t, uint64(0), records[0].Offset)
+}
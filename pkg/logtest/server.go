@@ -0,0 +1,140 @@
+package logtest
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	api "github.com/glauco/proglog/api/v1"
+	"github.com/glauco/proglog/internal/auth"
+	prolog "github.com/glauco/proglog/internal/log"
+	"github.com/glauco/proglog/internal/server"
+	"github.com/glauco/proglog/pkg/devcert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// ServerOption configures the servers StartServer starts.
+type ServerOption func(*serverConfig)
+
+type serverConfig struct {
+	policy         []string
+	maxRecordBytes int
+}
+
+// WithPolicy adds the rules to the server's ACL policy, besides root's, in the policy file's
+// format, e.g. "p, alice, *, consume" authorizing clients whose certificates' common name is
+// alice to consume any topic.
+func WithPolicy(rules ...string) ServerOption {
+	return func(c *serverConfig) {
+		c.policy = append(c.policy, rules...)
+	}
+}
+
+// WithMaxRecordBytes rejects the records larger than size bytes, as servers configured with a
+// record size limit do.
+func WithMaxRecordBytes(size int) ServerOption {
+	return func(c *serverConfig) {
+		c.maxRecordBytes = size
+	}
+}
+
+// Server is a server started by StartServer.
+type Server struct {
+	Addr   string // Addr is the server's RPC address, on 127.0.0.1.
+	Dir    string // Dir holds the server's log, in log/, and its ACL and CA files.
+	CAFile string // CAFile is the certificate authority the server's certificate chains to.
+	ca     *devcert.Authority
+}
+
+// StartServer starts a server on a random port of 127.0.0.1, with a log, TLS certificates and an
+// ACL of its own in a temporary directory, and stops it once the test ends. The server requires
+// clients to authenticate with certificates its authority issues, which Client, Conn and
+// ClientTLSConfig use, and authorizes them by their common names: root may do anything, and other
+// subjects, e.g. nobody, what WithPolicy allows them.
+func StartServer(t testing.TB, opts ...ServerOption) *Server {
+	t.Helper()
+	cfg := &serverConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	dir := t.TempDir()
+	ca, err := devcert.NewAuthority()
+	if err != nil {
+		t.Fatalf("logtest: create certificate authority: %v", err)
+	}
+	s := &Server{Dir: dir, CAFile: filepath.Join(dir, devcert.CAFile), ca: ca}
+	modelFile, policyFile := filepath.Join(dir, devcert.ACLModelFile), filepath.Join(dir, devcert.ACLPolicyFile)
+	policy := devcert.ACLPolicy + strings.Join(append(cfg.policy, ""), "\n")
+	for name, content := range map[string]string{s.CAFile: string(ca.CertPEM()), modelFile: devcert.ACLModel, policyFile: policy} {
+		if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+			t.Fatalf("logtest: %v", err)
+		}
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "log"), 0700); err != nil {
+		t.Fatalf("logtest: %v", err)
+	}
+	clog, err := prolog.NewLog(filepath.Join(dir, "log"), prolog.Config{})
+	if err != nil {
+		t.Fatalf("logtest: create log: %v", err)
+	}
+	tlsConfig, err := ca.ServerTLSConfig()
+	if err != nil {
+		t.Fatalf("logtest: issue server certificate: %v", err)
+	}
+	gsrv, err := server.NewGRPCServer(
+		&server.Config{
+			CommitLog:      clog,
+			Authorizer:     auth.New(modelFile, policyFile),
+			MaxRecordBytes: cfg.maxRecordBytes,
+		},
+		server.WithTLS(tlsConfig),
+	)
+	if err != nil {
+		t.Fatalf("logtest: create server: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("logtest: listen: %v", err)
+	}
+	s.Addr = l.Addr().String()
+	go gsrv.Serve(l)
+	t.Cleanup(func() {
+		gsrv.Stop()
+		clog.Close()
+	})
+	return s
+}
+
+// ClientTLSConfig returns the TLS config of a client authenticating as the subject, with a
+// certificate the server's authority issued.
+func (s *Server) ClientTLSConfig(t testing.TB, subject string) *tls.Config {
+	t.Helper()
+	tlsConfig, err := s.ca.ClientTLSConfig(subject)
+	if err != nil {
+		t.Fatalf("logtest: issue certificate of %q: %v", subject, err)
+	}
+	return tlsConfig
+}
+
+// Conn returns a connection to the server authenticated as the subject, closed once the test
+// ends, e.g. for clients of the v2 API or the other services.
+func (s *Server) Conn(t testing.TB, subject string) *grpc.ClientConn {
+	t.Helper()
+	conn, err := grpc.NewClient(s.Addr, grpc.WithTransportCredentials(credentials.NewTLS(s.ClientTLSConfig(t, subject))))
+	if err != nil {
+		t.Fatalf("logtest: connect to %s: %v", s.Addr, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// Client returns a client of the server authenticated as the subject, e.g. root.
+func (s *Server) Client(t testing.TB, subject string) api.LogClient {
+	t.Helper()
+	return api.NewLogClient(s.Conn(t, subject))
+}
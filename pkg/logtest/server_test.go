@@ -0,0 +1,40 @@
+package logtest
+
+import (
+	"context"
+	"testing"
+
+	api "github.com/glauco/proglog/api/v1"
+	apiv2 "github.com/glauco/proglog/api/v2"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStartServer(t *testing.T) {
+	ctx := context.Background()
+	srv := StartServer(t, WithPolicy("p, alice, *, consume"), WithMaxRecordBytes(64))
+	require.FileExists(t, srv.CAFile)
+	require.DirExists(t, srv.Dir)
+
+	root := srv.Client(t, "root")
+	_, err := root.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}})
+	require.NoError(t, err)
+	_, err = root.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: make([]byte, 100)}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Subjects are authorized by the policy
+	alice := srv.Client(t, "alice")
+	_, err = alice.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	res, err := alice.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(res.Record.Value))
+	_, err = srv.Client(t, "nobody").Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Connections serve the other APIs too
+	v2, err := apiv2.NewLogClient(srv.Conn(t, "root")).Consume(ctx, &apiv2.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(v2.Record.Value))
+}
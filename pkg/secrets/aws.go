@@ -0,0 +1,88 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// awsSecret returns the value of the Secrets Manager secret named by its name or ARN, optionally
+// followed by # and the field of the secret's JSON object to return.
+func (l *Loader) awsSecret(ctx context.Context, name string) ([]byte, error) {
+	id, field, _ := strings.Cut(name, "#")
+	cfg, err := l.awsConfig(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	out, err := secretsmanager.NewFromConfig(cfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.SecretString == nil {
+		if field != "" {
+			return nil, fmt.Errorf("binary secret has no field %q", field)
+		}
+		return out.SecretBinary, nil
+	}
+	if field == "" {
+		return []byte(*out.SecretString), nil
+	}
+	var fields map[string]string
+	if err := json.Unmarshal([]byte(*out.SecretString), &fields); err != nil {
+		return nil, fmt.Errorf("secret isn't a JSON object of strings, to take field %q from: %w", field, err)
+	}
+	value, ok := fields[field]
+	if !ok {
+		return nil, fmt.Errorf("secret has no field %q", field)
+	}
+	return []byte(value), nil
+}
+
+// awsDecrypt decrypts a base64-encoded ciphertext blob with KMS, e.g. one written by aws kms
+// encrypt --output text --query CiphertextBlob. The blob names its key, which the credentials
+// must be allowed to decrypt with.
+func (l *Loader) awsDecrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	blob, err := decodeBase64(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("ciphertext isn't base64-encoded: %w", err)
+	}
+	cfg, err := l.awsConfig(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	out, err := kms.NewFromConfig(cfg).Decrypt(ctx, &kms.DecryptInput{CiphertextBlob: blob})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+// awsConfig loads the SDK's configuration, its credentials, region and endpoints, from the
+// environment and the shared config files, sending its requests with the Loader's HTTP client, if
+// set. Resources named by their ARN are reached in the ARN's region.
+func (l *Loader) awsConfig(ctx context.Context, arn string) (aws.Config, error) {
+	var opts []func(*config.LoadOptions) error
+	if l.HTTPClient != nil {
+		opts = append(opts, config.WithHTTPClient(l.HTTPClient))
+	}
+	if parts := strings.Split(arn, ":"); len(parts) > 3 && parts[0] == "arn" && parts[3] != "" {
+		opts = append(opts, config.WithRegion(parts[3]))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	if cfg.Region == "" {
+		return aws.Config{}, errors.New("no AWS region: set AWS_REGION")
+	}
+	return cfg, nil
+}
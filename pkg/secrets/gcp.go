@@ -0,0 +1,106 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Google Cloud defaults.
+const (
+	gcpSecretManagerEndpoint = "https://secretmanager.googleapis.com"
+	gcpKMSEndpoint           = "https://cloudkms.googleapis.com"
+	defaultGCEMetadataHost   = "metadata.google.internal"
+)
+
+// gcpSecret returns the payload of the Secret Manager secret version named by its resource name,
+// projects/<project>/secrets/<secret>, optionally followed by /versions/<version>; the latest
+// version otherwise.
+func (l *Loader) gcpSecret(ctx context.Context, name string) ([]byte, error) {
+	if !strings.HasPrefix(name, "projects/") || !strings.Contains(name, "/secrets/") {
+		return nil, fmt.Errorf("secret %q must be named projects/<project>/secrets/<secret>[/versions/<version>]", name)
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	token, err := l.gcpToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpSecretManagerEndpoint+"/v1/"+name+":access", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var out struct {
+		Payload struct {
+			Data []byte `json:"data"`
+		} `json:"payload"`
+	}
+	if err := l.getJSON(req, &out); err != nil {
+		return nil, err
+	}
+	return out.Payload.Data, nil
+}
+
+// gcpDecrypt decrypts a base64-encoded ciphertext with the Cloud KMS key named by its resource
+// name, projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>, e.g. one written
+// by gcloud kms encrypt and encoded with base64.
+func (l *Loader) gcpDecrypt(ctx context.Context, key string, ciphertext []byte) ([]byte, error) {
+	if !strings.HasPrefix(key, "projects/") || !strings.Contains(key, "/cryptoKeys/") {
+		return nil, fmt.Errorf("key %q must be named projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>", key)
+	}
+	blob, err := decodeBase64(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("ciphertext isn't base64-encoded: %w", err)
+	}
+	token, err := l.gcpToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(map[string][]byte{"ciphertext": blob})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gcpKMSEndpoint+"/v1/"+key+":decrypt", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	var out struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	if err := l.getJSON(req, &out); err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+// gcpToken returns the access token of GOOGLE_OAUTH_ACCESS_TOKEN or, without it, of the
+// instance's service account, from the metadata server at GCE_METADATA_HOST, if set.
+func (l *Loader) gcpToken(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	host := defaultGCEMetadataHost
+	if h := os.Getenv("GCE_METADATA_HOST"); h != "" {
+		host = h
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var out struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := l.getJSON(req, &out); err != nil {
+		return "", fmt.Errorf("no GOOGLE_OAUTH_ACCESS_TOKEN, nor a token from the metadata server: %w", err)
+	}
+	return out.AccessToken, nil
+}
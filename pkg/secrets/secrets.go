@@ -0,0 +1,141 @@
+// Package secrets fetches secrets, e.g. TLS certificates and keys, from where deployments keep them
+// instead of files: environment variables, AWS Secrets Manager, Google Cloud Secret Manager, or
+// ciphertexts decrypted with AWS KMS or Google Cloud KMS, so they never need to touch the
+// container's filesystem.
+//
+// Secrets are named by references written <source>:<name>:
+//
+//	env:TLS_KEY                                   the environment variable TLS_KEY
+//	file:/run/secrets/tls.key                     the file
+//	base64:env:TLS_KEY                            the base64-decoded value of another reference
+//	aws-sm:prod/proglog/tls                       the AWS Secrets Manager secret, by name or ARN
+//	aws-sm:prod/proglog/tls#key                   a field of the secret, if it's a JSON object
+//	gcp-sm:projects/p/secrets/tls-key             the latest version of the Google Cloud secret
+//	gcp-sm:projects/p/secrets/tls-key/versions/3  a version of the secret
+//	aws-kms:env:TLS_KEY_CIPHERTEXT                another reference's base64 ciphertext, decrypted by AWS KMS
+//	gcp-kms:projects/p/locations/global/keyRings/r/cryptoKeys/k:env:TLS_KEY_CIPHERTEXT
+//	                                              another reference's base64 ciphertext, decrypted by the Cloud KMS key
+//
+// AWS requests are sent with the AWS SDK, configured like the AWS CLI: credentials of the
+// environment, the shared config files, the ECS or EKS Pod Identity container credentials
+// endpoint or the EC2 instance's role, in the region of AWS_REGION, or of the secret's ARN, and
+// AWS_ENDPOINT_URL replacing AWS's endpoints, e.g. to use LocalStack. Google Cloud requests are
+// authenticated with the access token of the GOOGLE_OAUTH_ACCESS_TOKEN environment variable or,
+// without it, of the instance's service account, from the metadata server.
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultTimeout bounds the requests fetching a secret when the Loader has no HTTP client.
+const defaultTimeout = 30 * time.Second
+
+// Loader fetches secrets by their references. The zero value is ready to use.
+type Loader struct {
+	// HTTPClient sends the requests to the secret managers, KMSes and metadata servers; defaults
+	// to a client timing out after 30 seconds, and to the AWS SDK's, which trusts AWS_CA_BUNDLE,
+	// for AWS.
+	HTTPClient *http.Client
+}
+
+// Load returns the secret the reference names.
+func Load(ctx context.Context, ref string) ([]byte, error) {
+	var l Loader
+	return l.Load(ctx, ref)
+}
+
+// Load returns the secret the reference names.
+func (l *Loader) Load(ctx context.Context, ref string) ([]byte, error) {
+	source, name, ok := strings.Cut(ref, ":")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("secrets: reference %q must be written <source>:<name>", ref)
+	}
+	var (
+		b   []byte
+		err error
+	)
+	switch source {
+	case "env":
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("secrets: environment variable %s isn't set", name)
+		}
+		b = []byte(value)
+	case "file":
+		b, err = os.ReadFile(name)
+	case "base64":
+		if b, err = l.Load(ctx, name); err == nil {
+			b, err = decodeBase64(b)
+		}
+	case "aws-sm":
+		b, err = l.awsSecret(ctx, name)
+	case "aws-kms":
+		var ciphertext []byte
+		if ciphertext, err = l.Load(ctx, name); err == nil {
+			b, err = l.awsDecrypt(ctx, ciphertext)
+		}
+	case "gcp-sm":
+		b, err = l.gcpSecret(ctx, name)
+	case "gcp-kms":
+		key, inner, ok := strings.Cut(name, ":")
+		if !ok {
+			return nil, fmt.Errorf("secrets: reference %q must be written gcp-kms:<key>:<reference>", ref)
+		}
+		var ciphertext []byte
+		if ciphertext, err = l.Load(ctx, inner); err == nil {
+			b, err = l.gcpDecrypt(ctx, key, ciphertext)
+		}
+	default:
+		return nil, fmt.Errorf("secrets: reference %q: unknown source %q; expected env, file, base64, aws-sm, aws-kms, gcp-sm or gcp-kms", ref, source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %s: %w", ref, err)
+	}
+	return b, nil
+}
+
+// client returns the HTTP client sending the requests.
+func (l *Loader) client() *http.Client {
+	if l.HTTPClient != nil {
+		return l.HTTPClient
+	}
+	return &http.Client{Timeout: defaultTimeout}
+}
+
+// decodeBase64 decodes standard base64, ignoring the surrounding whitespace, e.g. a trailing newline.
+func decodeBase64(b []byte) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+}
+
+// getJSON sends the request and decodes its JSON response into out.
+func (l *Loader) getJSON(req *http.Request, out any) error {
+	res, err := l.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(out)
+}
+
+// do sends the request, failing unless it's answered 200 OK.
+func (l *Loader) do(req *http.Request) (*http.Response, error) {
+	res, err := l.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), res.Status, strings.TrimSpace(string(b)))
+	}
+	return res, nil
+}
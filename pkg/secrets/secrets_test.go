@@ -0,0 +1,153 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCloud serves the AWS Secrets Manager and KMS APIs the AWS SDK calls, the Google Cloud
+// Secret Manager and KMS APIs, and an AWS container credentials endpoint.
+func fakeCloud(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/creds" {
+			json.NewEncoder(w).Encode(map[string]string{"AccessKeyId": "AKIDCONTAINER", "SecretAccessKey": "secret", "Token": "session"})
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/v1/projects/") {
+			if r.Header.Get("Authorization") != "Bearer gcp-token" {
+				http.Error(w, "unauthenticated", http.StatusUnauthorized)
+				return
+			}
+			switch r.URL.Path {
+			case "/v1/projects/p/secrets/tls-key/versions/latest:access":
+				json.NewEncoder(w).Encode(map[string]any{"payload": map[string][]byte{"data": []byte("gcp key")}})
+			case "/v1/projects/p/locations/global/keyRings/r/cryptoKeys/k:decrypt":
+				var in struct {
+					Ciphertext []byte `json:"ciphertext"`
+				}
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+				json.NewEncoder(w).Encode(map[string][]byte{"plaintext": []byte(strings.TrimPrefix(string(in.Ciphertext), "sealed:"))})
+			default:
+				http.Error(w, "not found", http.StatusNotFound)
+			}
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID") || !strings.Contains(auth, "/eu-west-1/") {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"__type": "AccessDeniedException", "message": "bad signature"})
+			return
+		}
+		var in map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+		switch r.Header.Get("X-Amz-Target") {
+		case "secretsmanager.GetSecretValue":
+			switch in["SecretId"] {
+			case "prod/tls", "arn:aws:secretsmanager:eu-west-1:123456789012:secret:prod/tls":
+				json.NewEncoder(w).Encode(map[string]string{"SecretString": `{"cert":"aws cert","key":"aws key"}`})
+			case "prod/binary":
+				json.NewEncoder(w).Encode(map[string][]byte{"SecretBinary": []byte("aws binary")})
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"__type": "ResourceNotFoundException", "message": "no such secret"})
+			}
+		case "TrentService.Decrypt":
+			blob, _ := base64.StdEncoding.DecodeString(in["CiphertextBlob"])
+			json.NewEncoder(w).Encode(map[string][]byte{"Plaintext": []byte(strings.TrimPrefix(string(blob), "sealed:"))})
+		}
+	}))
+}
+
+// TestLoad verifies that secrets are loaded from every source their references name.
+func TestLoad(t *testing.T) {
+	srv := fakeCloud(t)
+	defer srv.Close()
+	target, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	// Google Cloud's endpoints are fixed, so its requests are sent to the fake instead
+	loader := &Loader{HTTPClient: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme, r.URL.Host = target.Scheme, target.Host
+		return http.DefaultTransport.RoundTrip(r)
+	})}}
+	file := filepath.Join(t.TempDir(), "tls.key")
+	require.NoError(t, os.WriteFile(file, []byte("file key"), 0600))
+	t.Setenv("TLS_KEY", "env key")
+	t.Setenv("TLS_KEY_B64", base64.StdEncoding.EncodeToString([]byte("decoded key"))+"\n")
+	t.Setenv("TLS_KEY_SEALED", base64.StdEncoding.EncodeToString([]byte("sealed:kms key")))
+	// The AWS SDK is configured by the environment alone, not the machine's files or instance
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_CA_BUNDLE", "")
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "gcp-token")
+
+	for ref, want := range map[string]string{
+		"env:TLS_KEY":                       "env key",
+		"file:" + file:                      "file key",
+		"base64:env:TLS_KEY_B64":            "decoded key",
+		"aws-sm:prod/tls":                   `{"cert":"aws cert","key":"aws key"}`,
+		"aws-sm:prod/tls#key":               "aws key",
+		"aws-sm:prod/binary":                "aws binary",
+		"aws-kms:env:TLS_KEY_SEALED":        "kms key",
+		"gcp-sm:projects/p/secrets/tls-key": "gcp key",
+		"gcp-kms:projects/p/locations/global/keyRings/r/cryptoKeys/k:env:TLS_KEY_SEALED": "kms key",
+	} {
+		t.Run(ref, func(t *testing.T) {
+			b, err := loader.Load(context.Background(), ref)
+			require.NoError(t, err)
+			require.Equal(t, want, string(b))
+		})
+	}
+
+	for ref, msg := range map[string]string{
+		"TLS_KEY":                           "<source>:<name>",
+		"vault:secret/tls":                  "unknown source",
+		"env:MISSING":                       "isn't set",
+		"base64:env:TLS_KEY":                "illegal base64",
+		"aws-sm:prod/missing":               "ResourceNotFoundException: no such secret",
+		"aws-sm:prod/tls#missing":           `no field "missing"`,
+		"aws-sm:prod/binary#key":            "binary secret",
+		"gcp-sm:projects/p/secrets/other":   "404 Not Found",
+		"gcp-sm:tls-key":                    "must be named",
+		"gcp-kms:keys/k:env:TLS_KEY_SEALED": "must be named",
+		"gcp-kms:projects/p/locations/global/keyRings/r/cryptoKeys/other:env:TLS_KEY_SEALED": "404 Not Found",
+		"gcp-kms:projects/p/locations/global/keyRings/r/cryptoKeys/k":                        "gcp-kms:<key>:<reference>",
+	} {
+		t.Run(ref, func(t *testing.T) {
+			_, err := loader.Load(context.Background(), ref)
+			require.ErrorContains(t, err, msg)
+		})
+	}
+
+	// Without credentials in the environment, the container's are used, and secrets named by
+	// their ARNs are fetched from their region
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", srv.URL+"/creds")
+	t.Setenv("AWS_REGION", "")
+	_, err = loader.Load(context.Background(), "aws-sm:prod/tls")
+	require.ErrorContains(t, err, "no AWS region")
+	b, err := loader.Load(context.Background(), "aws-sm:arn:aws:secretsmanager:eu-west-1:123456789012:secret:prod/tls#cert")
+	require.NoError(t, err)
+	require.Equal(t, "aws cert", string(b))
+}
+
+// roundTripFunc is an http.RoundTripper calling itself.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
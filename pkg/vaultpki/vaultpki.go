@@ -0,0 +1,297 @@
+// Package vaultpki requests the certificates of proglog's servers and clients from the PKI secrets
+// engine of HashiCorp Vault, and renews them before they expire, so nodes don't depend on
+// certificates provisioned by hand with cfssl. A Vault role issues the certificates, whose common
+// names servers authorize clients by, as with any other certificate.
+package vaultpki
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Issuer defaults.
+const (
+	defaultMount        = "pki"
+	defaultRetryMin     = 10 * time.Second
+	defaultRetryMax     = 5 * time.Minute
+	defaultIssueTimeout = 30 * time.Second
+)
+
+// Config configures an Issuer.
+type Config struct {
+	// Address is Vault's address, e.g. https://vault.example.com:8200.
+	Address string
+	// Token authenticates the requests to Vault. TokenFile reads it from a file instead, before
+	// each request, so a Vault agent may keep the file's token fresh. One of them is required.
+	Token     string
+	TokenFile string
+	// Namespace is the Vault Enterprise namespace the PKI engine is mounted in, if any.
+	Namespace string
+	// Mount is the path the PKI engine is mounted at; defaults to pki.
+	Mount string
+	// Role is the role issuing the certificates, which must allow their common name and hosts.
+	Role string
+	// CommonName is the certificate's common name: the subject servers authorize a client as.
+	CommonName string
+	// Hosts are the DNS names and IPs a server is reached at, which the certificate is valid
+	// for; clients leave it empty.
+	Hosts []string
+	// TTL is how long certificates are valid for; the role's default when 0.
+	TTL time.Duration
+	// HTTPClient sends the requests to Vault, e.g. trusting Vault's CA; defaults to a client
+	// timing out after 30 seconds.
+	HTTPClient *http.Client
+	// Logger logs the renewals and their failures; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Issuer holds a certificate issued by Vault, which it renews in the background once two thirds
+// of its lifetime have passed. Renewals failing are retried, with backoff, while the current
+// certificate keeps being used. The TLS configs it returns pick up the renewed certificates for
+// new connections.
+type Issuer struct {
+	config Config
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+}
+
+// New returns an issuer holding a certificate issued with the config, failing if Vault doesn't
+// issue it, and renewing it until closed.
+func New(config Config) (*Issuer, error) {
+	if config.Address == "" || config.Role == "" || config.CommonName == "" {
+		return nil, errors.New("vaultpki: address, role and common name are required")
+	}
+	if (config.Token == "") == (config.TokenFile == "") {
+		return nil, errors.New("vaultpki: exactly one of the token and token file is required")
+	}
+	if config.Mount == "" {
+		config.Mount = defaultMount
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: defaultIssueTimeout}
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	i := &Issuer{config: config, stop: make(chan struct{}), done: make(chan struct{})}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultIssueTimeout)
+	defer cancel()
+	if err := i.renew(ctx); err != nil {
+		return nil, err
+	}
+	go i.run()
+	return i, nil
+}
+
+// Certificate returns the current certificate.
+func (i *Issuer) Certificate() *tls.Certificate {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.cert
+}
+
+// CertPool returns the pool of the CA chain that issued the first certificate, which the peers'
+// certificates are verified with. A CA rotated in Vault thus takes a restart.
+func (i *Issuer) CertPool() *x509.CertPool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.pool
+}
+
+// ServerTLSConfig returns the TLS config of a server presenting the current certificate and
+// requiring clients to authenticate with certificates of the same CA.
+func (i *Issuer) ServerTLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return i.Certificate(), nil
+		},
+		ClientCAs:  i.CertPool(),
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}
+}
+
+// ClientTLSConfig returns the TLS config of a client presenting the current certificate, and
+// trusting the servers with certificates of the same CA reached at the server name.
+func (i *Issuer) ClientTLSConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return i.Certificate(), nil
+		},
+		RootCAs:    i.CertPool(),
+		ServerName: serverName,
+	}
+}
+
+// Close stops renewing the certificate.
+func (i *Issuer) Close() error {
+	select {
+	case <-i.stop:
+	default:
+		close(i.stop)
+	}
+	<-i.done
+	return nil
+}
+
+// run renews the certificate once two thirds of its lifetime have passed, retrying failures
+// with backoff, until the issuer is closed.
+func (i *Issuer) run() {
+	defer close(i.done)
+	retry := defaultRetryMin
+	for {
+		leaf := i.Certificate().Leaf
+		wait := time.Until(leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) * 2 / 3))
+		timer := time.NewTimer(wait)
+		select {
+		case <-i.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), defaultIssueTimeout)
+			err := i.renew(ctx)
+			cancel()
+			if err == nil {
+				retry = defaultRetryMin
+				break
+			}
+			i.config.Logger.Error("failed to renew the certificate from Vault",
+				slog.String("common_name", i.config.CommonName),
+				slog.Time("expires", leaf.NotAfter),
+				slog.Duration("retry_in", retry),
+				slog.String("error", err.Error()))
+			select {
+			case <-i.stop:
+				return
+			case <-time.After(retry):
+			}
+			retry = min(2*retry, defaultRetryMax)
+		}
+	}
+}
+
+// issueResponse is the response of the PKI engine's issue endpoint.
+type issueResponse struct {
+	Data struct {
+		Certificate string   `json:"certificate"`
+		IssuingCA   string   `json:"issuing_ca"`
+		CAChain     []string `json:"ca_chain"`
+		PrivateKey  string   `json:"private_key"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+// renew requests a new certificate from Vault and replaces the current one with it.
+func (i *Issuer) renew(ctx context.Context) error {
+	res, err := i.issue(ctx)
+	if err != nil {
+		return err
+	}
+	// Servers send the intermediates along with their certificates, so peers trusting the root
+	// verify them
+	chain := res.Data.Certificate
+	for _, ca := range res.Data.CAChain {
+		if ca != res.Data.Certificate {
+			chain += "\n" + ca
+		}
+	}
+	cert, err := tls.X509KeyPair([]byte(chain), []byte(res.Data.PrivateKey))
+	if err != nil {
+		return fmt.Errorf("vaultpki: %w", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("vaultpki: %w", err)
+		}
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.pool == nil {
+		pool := x509.NewCertPool()
+		for _, ca := range append([]string{res.Data.IssuingCA}, res.Data.CAChain...) {
+			pool.AppendCertsFromPEM([]byte(ca))
+		}
+		i.pool = pool
+	} else {
+		i.config.Logger.Info("renewed the certificate from Vault",
+			slog.String("common_name", i.config.CommonName),
+			slog.Time("expires", cert.Leaf.NotAfter))
+	}
+	i.cert = &cert
+	return nil
+}
+
+// issue requests a certificate from the PKI engine's issue endpoint.
+func (i *Issuer) issue(ctx context.Context) (*issueResponse, error) {
+	token := i.config.Token
+	if i.config.TokenFile != "" {
+		b, err := os.ReadFile(i.config.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("vaultpki: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	body := map[string]string{"common_name": i.config.CommonName}
+	var altNames, ipSANs []string
+	for _, host := range i.config.Hosts {
+		if net.ParseIP(host) != nil {
+			ipSANs = append(ipSANs, host)
+		} else {
+			altNames = append(altNames, host)
+		}
+	}
+	if len(altNames) > 0 {
+		body["alt_names"] = strings.Join(altNames, ",")
+	}
+	if len(ipSANs) > 0 {
+		body["ip_sans"] = strings.Join(ipSANs, ",")
+	}
+	if i.config.TTL > 0 {
+		body["ttl"] = i.config.TTL.String()
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%s/v1/%s/issue/%s", strings.TrimSuffix(i.config.Address, "/"), i.config.Mount, i.config.Role)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", token)
+	if i.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", i.config.Namespace)
+	}
+	httpRes, err := i.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vaultpki: %w", err)
+	}
+	defer httpRes.Body.Close()
+	var res issueResponse
+	if err := json.NewDecoder(io.LimitReader(httpRes.Body, 1<<20)).Decode(&res); err != nil && httpRes.StatusCode/100 == 2 {
+		return nil, fmt.Errorf("vaultpki: decode response: %w", err)
+	}
+	if httpRes.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("vaultpki: %s answered %s: %s", url, httpRes.Status, strings.Join(res.Errors, "; "))
+	}
+	return &res, nil
+}
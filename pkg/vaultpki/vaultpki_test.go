@@ -0,0 +1,165 @@
+package vaultpki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/glauco/proglog/pkg/devcert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVault serves the issue endpoint of a PKI engine mounted at pki, with a role named proglog,
+// signing certificates with a development CA.
+type fakeVault struct {
+	t       *testing.T
+	ca      *x509.Certificate
+	caPEM   string
+	key     *ecdsa.PrivateKey
+	issued  atomic.Int32
+	failing atomic.Bool
+	last    atomic.Value // Last request's body
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *httptest.Server) {
+	t.Helper()
+	authority, err := devcert.NewAuthority()
+	require.NoError(t, err)
+	keyPEM, err := authority.KeyPEM()
+	require.NoError(t, err)
+	block, _ := pem.Decode(keyPEM)
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	require.NoError(t, err)
+	block, _ = pem.Decode(authority.CertPEM())
+	ca, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	v := &fakeVault{t: t, ca: ca, caPEM: string(authority.CertPEM()), key: key}
+	srv := httptest.NewServer(http.HandlerFunc(v.serveHTTP))
+	t.Cleanup(srv.Close)
+	return v, srv
+}
+
+func (v *fakeVault) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/pki/issue/proglog" || r.Header.Get("X-Vault-Token") != "s.token" {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"errors":["permission denied"]}`))
+		return
+	}
+	if v.failing.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"errors":["Vault is sealed"]}`))
+		return
+	}
+	var req map[string]string
+	require.NoError(v.t, json.NewDecoder(r.Body).Decode(&req))
+	v.last.Store(req)
+	ttl := time.Hour
+	if req["ttl"] != "" {
+		var err error
+		ttl, err = time.ParseDuration(req["ttl"])
+		require.NoError(v.t, err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(v.t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(v.issued.Add(1))),
+		Subject:      pkix.Name{CommonName: req["common_name"]},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(ttl),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, name := range strings.Split(req["alt_names"], ",") {
+		if name != "" {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	for _, ip := range strings.Split(req["ip_sans"], ",") {
+		if ip != "" {
+			template.IPAddresses = append(template.IPAddresses, net.ParseIP(ip))
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, v.ca, &key.PublicKey, v.key)
+	require.NoError(v.t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(v.t, err)
+	json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+		"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		"issuing_ca":  v.caPEM,
+		"ca_chain":    []string{v.caPEM},
+		"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}})
+}
+
+func TestIssuer(t *testing.T) {
+	vault, srv := newFakeVault(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("s.token\n"), 0600))
+
+	server, err := New(Config{Address: srv.URL, TokenFile: tokenFile, Role: "proglog", CommonName: "node-0", Hosts: []string{"localhost", "127.0.0.1"}})
+	require.NoError(t, err)
+	defer server.Close()
+	require.Equal(t, map[string]string{"common_name": "node-0", "alt_names": "localhost", "ip_sans": "127.0.0.1"}, vault.last.Load())
+	client, err := New(Config{Address: srv.URL, Token: "s.token", Role: "proglog", CommonName: "root"})
+	require.NoError(t, err)
+	defer client.Close()
+
+	// The server and client authenticate each other with the certificates issued
+	l, err := tls.Listen("tcp", "127.0.0.1:0", server.ServerTLSConfig())
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	conn, err := tls.Dial("tcp", l.Addr().String(), client.ClientTLSConfig("127.0.0.1"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Handshake())
+	require.Equal(t, "node-0", conn.ConnectionState().PeerCertificates[0].Subject.CommonName)
+	conn.Close()
+
+	// Vault refusing to issue the first certificate fails
+	_, err = New(Config{Address: srv.URL, Token: "bogus", Role: "proglog", CommonName: "root"})
+	require.ErrorContains(t, err, "permission denied")
+	_, err = New(Config{Address: srv.URL, Role: "proglog", CommonName: "root"})
+	require.Error(t, err)
+}
+
+func TestIssuerRenewal(t *testing.T) {
+	vault, srv := newFakeVault(t)
+	issuer, err := New(Config{Address: srv.URL, Token: "s.token", Role: "proglog", CommonName: "root", TTL: 3 * time.Second})
+	require.NoError(t, err)
+	defer issuer.Close()
+	first := issuer.Certificate()
+	require.Equal(t, map[string]string{"common_name": "root", "ttl": "3s"}, vault.last.Load())
+
+	// The certificate is renewed once two thirds of its lifetime have passed
+	require.Eventually(t, func() bool { return issuer.Certificate() != first }, 4*time.Second, 50*time.Millisecond)
+	require.NotEqual(t, first.Leaf.SerialNumber, issuer.Certificate().Leaf.SerialNumber)
+
+	// The current certificate is kept while renewals fail
+	vault.failing.Store(true)
+	issued := vault.issued.Load()
+	current := issuer.Certificate()
+	time.Sleep(3 * time.Second)
+	require.Equal(t, issued, vault.issued.Load())
+	require.Same(t, current, issuer.Certificate())
+}